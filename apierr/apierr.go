@@ -0,0 +1,309 @@
+// Package apierr defines the structured errors returned by the fees API.
+//
+// Every error carries an Encore error code (which determines the HTTP status) and a
+// machine-readable Reason in its details, so clients can branch on specific failures:
+//
+//	{"code": "failed_precondition", "message": "bill 123 is closed", "details": {"reason": "bill_closed"}}
+package apierr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"encore.dev/beta/errs"
+	"go.temporal.io/api/serviceerror"
+)
+
+// Reason is a machine-readable error code returned in the details of an error response.
+type Reason string
+
+const (
+	BillNotFound            Reason = "bill_not_found"
+	BillClosed              Reason = "bill_closed"
+	BillAlreadyPaid         Reason = "bill_already_paid"
+	BillNotPayable          Reason = "bill_not_payable"
+	BillNotRefundable       Reason = "bill_not_refundable"
+	BillNotPendingApproval  Reason = "bill_not_pending_approval"
+	BillNotCloseFailed      Reason = "bill_not_close_failed"
+	BillNotSettled          Reason = "bill_not_settled"
+	NothingToRefund         Reason = "nothing_to_refund"
+	RefundExceedsBalance    Reason = "refund_exceeds_balance"
+	DunningNotFound         Reason = "dunning_not_found"
+	CloseTimeout            Reason = "close_timeout"
+	CloseFailed             Reason = "close_failed"
+	LineItemTimeout         Reason = "line_item_timeout"
+	LineItemDropped         Reason = "line_item_dropped"
+	InvalidCurrency         Reason = "invalid_currency"
+	InvalidAmount           Reason = "invalid_amount"
+	InvalidParameter        Reason = "invalid_parameter"
+	QuotaExhausted          Reason = "quota_exhausted"
+	QuotaExceeded           Reason = "quota_exceeded"
+	RateLimited             Reason = "rate_limited"
+	UnsafeRetry             Reason = "unsafe_retry"
+	InvalidAPIKey           Reason = "invalid_api_key"
+	InsufficientScope       Reason = "insufficient_scope"
+	APIKeyNotFound          Reason = "api_key_not_found"
+	APIKeyExists            Reason = "api_key_exists"
+	TemplateNotFound        Reason = "template_not_found"
+	SubscriptionNotFound    Reason = "subscription_not_found"
+	SubscriptionCanceled    Reason = "subscription_canceled"
+	VersionMismatch         Reason = "version_mismatch"
+	AttachmentNotFound      Reason = "attachment_not_found"
+	AttachmentRejected      Reason = "attachment_rejected"
+	LedgerAccountNotFound   Reason = "ledger_account_not_found"
+	ScheduleNotFound        Reason = "schedule_not_found"
+	WorkflowNotRunning      Reason = "workflow_not_running"
+	JobNotFound             Reason = "job_not_found"
+	JobFinished             Reason = "job_finished"
+	ErasureNotFound         Reason = "erasure_not_found"
+	UnsettledBills          Reason = "unsettled_bills"
+	FieldEncryptionDisabled Reason = "field_encryption_disabled"
+	BillDisputed            Reason = "bill_disputed"
+	DisputeNotFound         Reason = "dispute_not_found"
+	DisputeEvidenceClosed   Reason = "dispute_evidence_closed"
+	TemporalUnavailable     Reason = "temporal_unavailable"
+	StateTokenTimeout       Reason = "state_token_timeout"
+	BillNotClosed           Reason = "bill_not_closed"
+	ContactNotFound         Reason = "contact_not_found"
+	DeliveryFailed          Reason = "delivery_failed"
+	ArchiveNotFound         Reason = "archive_not_found"
+	AccrualSnapshotNotFound Reason = "accrual_snapshot_not_found"
+	NegativeTotal           Reason = "negative_total"
+	UnknownReasonCode       Reason = "unknown_reason_code"
+	TestModeUnsupported     Reason = "test_mode_unsupported"
+	CouponNotFound          Reason = "coupon_not_found"
+	CouponExists            Reason = "coupon_exists"
+	CouponExpired           Reason = "coupon_expired"
+	CouponExhausted         Reason = "coupon_exhausted"
+	CouponNotApplicable     Reason = "coupon_not_applicable"
+	CouponTimeout           Reason = "coupon_timeout"
+	RequestCanceled         Reason = "request_canceled"
+	RequestDeadlineExceeded Reason = "request_deadline_exceeded"
+	Internal                Reason = "internal"
+)
+
+// Reasons lists every Reason, for the API documentation.
+var Reasons = []Reason{
+	BillNotFound,
+	BillClosed,
+	BillAlreadyPaid,
+	BillNotPayable,
+	BillNotRefundable,
+	BillNotPendingApproval,
+	BillNotCloseFailed,
+	BillNotSettled,
+	NothingToRefund,
+	RefundExceedsBalance,
+	DunningNotFound,
+	CloseTimeout,
+	CloseFailed,
+	LineItemTimeout,
+	LineItemDropped,
+	InvalidCurrency,
+	InvalidAmount,
+	InvalidParameter,
+	QuotaExhausted,
+	QuotaExceeded,
+	RateLimited,
+	UnsafeRetry,
+	InvalidAPIKey,
+	InsufficientScope,
+	APIKeyNotFound,
+	APIKeyExists,
+	TemplateNotFound,
+	SubscriptionNotFound,
+	SubscriptionCanceled,
+	VersionMismatch,
+	AttachmentNotFound,
+	AttachmentRejected,
+	LedgerAccountNotFound,
+	ScheduleNotFound,
+	WorkflowNotRunning,
+	JobNotFound,
+	JobFinished,
+	ErasureNotFound,
+	UnsettledBills,
+	FieldEncryptionDisabled,
+	BillDisputed,
+	DisputeNotFound,
+	DisputeEvidenceClosed,
+	TemporalUnavailable,
+	StateTokenTimeout,
+	BillNotClosed,
+	ContactNotFound,
+	DeliveryFailed,
+	ArchiveNotFound,
+	AccrualSnapshotNotFound,
+	NegativeTotal,
+	UnknownReasonCode,
+	TestModeUnsupported,
+	CouponNotFound,
+	CouponExists,
+	CouponExpired,
+	CouponExhausted,
+	CouponNotApplicable,
+	CouponTimeout,
+	RequestCanceled,
+	RequestDeadlineExceeded,
+	Internal,
+}
+
+// Details is the details payload of every fees API error.
+type Details struct {
+	Reason Reason `json:"reason"`
+	// RetryAfterSeconds tells throttled clients when to try again.
+	RetryAfterSeconds int `json:"retryAfterSeconds,omitempty"`
+}
+
+// ErrDetails marks Details as Encore error details.
+func (Details) ErrDetails() {}
+
+// New returns an error with the given code, reason, and formatted message.
+func New(code errs.ErrCode, reason Reason, format string, args ...any) error {
+	return &errs.Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+		Details: Details{Reason: reason},
+	}
+}
+
+// NotFound reports that the addressed resource does not exist.
+func NotFound(reason Reason, format string, args ...any) error {
+	return New(errs.NotFound, reason, format, args...)
+}
+
+// InvalidArgument reports a malformed request, such as a bad currency or amount.
+func InvalidArgument(reason Reason, format string, args ...any) error {
+	return New(errs.InvalidArgument, reason, format, args...)
+}
+
+// FailedPrecondition reports a request the resource's current state does not allow,
+// such as adding items to a closed bill.
+func FailedPrecondition(reason Reason, format string, args ...any) error {
+	return New(errs.FailedPrecondition, reason, format, args...)
+}
+
+// ResourceExhausted reports an exhausted quota.
+func ResourceExhausted(reason Reason, format string, args ...any) error {
+	return New(errs.ResourceExhausted, reason, format, args...)
+}
+
+// TooManyRequests reports a throttled caller, who may retry after retryAfter.
+func TooManyRequests(retryAfter time.Duration, format string, args ...any) error {
+	return &errs.Error{
+		Code:    errs.ResourceExhausted,
+		Message: fmt.Sprintf(format, args...),
+		Details: Details{Reason: RateLimited, RetryAfterSeconds: int(math.Ceil(retryAfter.Seconds()))},
+	}
+}
+
+// RetryAfter returns how long a throttled caller should wait, or zero.
+func RetryAfter(err error) time.Duration {
+	var apiErr *errs.Error
+	if !errors.As(err, &apiErr) {
+		return 0
+	}
+	if d, ok := apiErr.Details.(Details); ok {
+		return time.Duration(d.RetryAfterSeconds) * time.Second
+	}
+	return 0
+}
+
+// Unauthenticated reports a missing or invalid API key.
+func Unauthenticated(reason Reason, format string, args ...any) error {
+	return New(errs.Unauthenticated, reason, format, args...)
+}
+
+// PermissionDenied reports an authenticated caller that may not perform the request.
+func PermissionDenied(reason Reason, format string, args ...any) error {
+	return New(errs.PermissionDenied, reason, format, args...)
+}
+
+// Unavailable reports that a dependency is down and the request may be retried.
+func Unavailable(reason Reason, format string, args ...any) error {
+	return New(errs.Unavailable, reason, format, args...)
+}
+
+// Wrap converts an unexpected error into an Internal error. The cause is kept in the
+// error's metadata, which is logged but never sent to clients.
+func Wrap(err error, format string, args ...any) error {
+	var apiErr *errs.Error
+	if errors.As(err, &apiErr) {
+		return err
+	}
+	return &errs.Error{
+		Code:    errs.Internal,
+		Message: fmt.Sprintf(format, args...),
+		Details: Details{Reason: Internal},
+		Meta:    errs.Metadata{"cause": err.Error()},
+	}
+}
+
+// FromContext reports that the request ctx ended before the work described by format
+// finished: Canceled if the caller canceled it, or DeadlineExceeded if its deadline
+// passed. It returns nil while ctx has not ended.
+func FromContext(ctx context.Context, format string, args ...any) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return New(errs.DeadlineExceeded, RequestDeadlineExceeded, format+": the request's deadline passed", args...)
+	case ctx.Err() != nil:
+		return New(errs.Canceled, RequestCanceled, format+": the request was canceled", args...)
+	}
+	return nil
+}
+
+// FromTemporal converts an error from the Temporal client. A missing workflow becomes
+// NotFound with the given reason; an unreachable or overloaded Temporal server becomes
+// Unavailable; a call the caller canceled becomes Canceled; anything else is Internal.
+func FromTemporal(err error, notFound Reason, format string, args ...any) error {
+	var apiErr *errs.Error
+	if errors.As(err, &apiErr) {
+		return err
+	}
+
+	var nf *serviceerror.NotFound
+	var canceled *serviceerror.Canceled
+	switch {
+	case errors.As(err, &nf):
+		return NotFound(notFound, format, args...)
+	case errors.As(err, &canceled) || errors.Is(err, context.Canceled):
+		return New(errs.Canceled, RequestCanceled, format+": the request was canceled", args...)
+	case IsTemporalUnavailable(err):
+		return &errs.Error{
+			Code:    errs.Unavailable,
+			Message: fmt.Sprintf(format, args...) + ": temporal is unavailable",
+			Details: Details{Reason: TemporalUnavailable},
+			Meta:    errs.Metadata{"cause": err.Error()},
+		}
+	}
+	return Wrap(err, format, args...)
+}
+
+// IsTemporalUnavailable reports whether err, from the Temporal client or already
+// converted by FromTemporal, means the Temporal server is unreachable or overloaded.
+func IsTemporalUnavailable(err error) bool {
+	var apiErr *errs.Error
+	if errors.As(err, &apiErr) {
+		return ReasonOf(err) == TemporalUnavailable
+	}
+	var unavailable *serviceerror.Unavailable
+	var deadline *serviceerror.DeadlineExceeded
+	var exhausted *serviceerror.ResourceExhausted
+	return errors.As(err, &unavailable) || errors.As(err, &deadline) || errors.As(err, &exhausted) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// ReasonOf returns the Reason of an error created by this package, or "".
+func ReasonOf(err error) Reason {
+	var apiErr *errs.Error
+	if !errors.As(err, &apiErr) {
+		return ""
+	}
+	if d, ok := apiErr.Details.(Details); ok {
+		return d.Reason
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package apierr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+	"time"
+
+	"encore.dev/beta/errs"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/serviceerror"
+)
+
+func code(t *testing.T, err error) errs.ErrCode {
+	t.Helper()
+	var apiErr *errs.Error
+	require.True(t, errors.As(err, &apiErr))
+	return apiErr.Code
+}
+
+// TestFromTemporal tests that Temporal client errors map to the matching API error codes.
+func TestFromTemporal(t *testing.T) {
+	err := FromTemporal(fmt.Errorf("query: %w", serviceerror.NewNotFound("workflow not found")), BillNotFound, "bill %s not found", "b1")
+	require.Equal(t, errs.NotFound, code(t, err))
+	require.Equal(t, BillNotFound, ReasonOf(err))
+
+	err = FromTemporal(serviceerror.NewUnavailable("connection refused"), BillNotFound, "failed to signal bill %s", "b1")
+	require.Equal(t, errs.Unavailable, code(t, err))
+	require.Equal(t, TemporalUnavailable, ReasonOf(err))
+
+	err = FromTemporal(context.DeadlineExceeded, BillNotFound, "failed to signal bill %s", "b1")
+	require.Equal(t, errs.Unavailable, code(t, err))
+
+	err = FromTemporal(fmt.Errorf("signal: %w", context.Canceled), BillNotFound, "failed to signal bill %s", "b1")
+	require.Equal(t, errs.Canceled, code(t, err))
+	require.Equal(t, RequestCanceled, ReasonOf(err))
+	require.False(t, IsTemporalUnavailable(err))
+
+	err = FromTemporal(errors.New("boom"), BillNotFound, "failed to signal bill %s", "b1")
+	require.Equal(t, errs.Internal, code(t, err))
+	require.Equal(t, Internal, ReasonOf(err))
+
+	require.True(t, IsTemporalUnavailable(FromTemporal(serviceerror.NewUnavailable("down"), BillNotFound, "x")))
+	require.False(t, IsTemporalUnavailable(err))
+}
+
+// TestFromContext tests that a canceled request and one past its deadline are told
+// apart, and that a live request is not an error.
+func TestFromContext(t *testing.T) {
+	require.NoError(t, FromContext(context.Background(), "bill %s did not close", "b1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := FromContext(ctx, "bill %s did not close", "b1")
+	require.Equal(t, errs.Canceled, code(t, err))
+	require.Equal(t, RequestCanceled, ReasonOf(err))
+	require.Equal(t, "bill b1 did not close: the request was canceled", err.(*errs.Error).Message)
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	err = FromContext(ctx, "bill %s did not close", "b1")
+	require.Equal(t, errs.DeadlineExceeded, code(t, err))
+	require.Equal(t, RequestDeadlineExceeded, ReasonOf(err))
+}
+
+// TestWrap_KeepsAPIErrors tests that already-structured errors pass through unchanged.
+func TestWrap_KeepsAPIErrors(t *testing.T) {
+	original := FailedPrecondition(BillClosed, "bill %s is closed", "b1")
+	require.Same(t, original, Wrap(original, "unexpected"))
+	require.Same(t, original, FromTemporal(original, BillNotFound, "unexpected"))
+	require.Equal(t, Reason(""), ReasonOf(errors.New("plain")))
+}
+
+// TestTooManyRequests tests that throttling errors round the retry delay up to whole seconds.
+func TestTooManyRequests(t *testing.T) {
+	err := TooManyRequests(1500*time.Millisecond, "rate limit exceeded for API key %s", "k1")
+	require.Equal(t, errs.ResourceExhausted, code(t, err))
+	require.Equal(t, RateLimited, ReasonOf(err))
+	require.Equal(t, 2*time.Second, RetryAfter(err))
+	require.Zero(t, RetryAfter(errors.New("plain")))
+}
+
+// TestReasons tests that Reasons lists every declared Reason, so the API documentation
+// does not miss one.
+func TestReasons(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "apierr.go", nil, 0)
+	require.NoError(t, err)
+	var declared []Reason
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value := spec.(*ast.ValueSpec)
+			if ident, ok := value.Type.(*ast.Ident); ok && ident.Name == "Reason" {
+				lit := value.Values[0].(*ast.BasicLit)
+				declared = append(declared, Reason(strings.Trim(lit.Value, `"`)))
+			}
+		}
+	}
+	require.ElementsMatch(t, declared, Reasons)
+}
@@ -0,0 +1,516 @@
+// Package client is a Go SDK for the fees API.
+//
+// It wraps the Encore HTTP endpoints with typed methods, retries transient failures
+// with exponential backoff, and attaches an idempotency key to every mutating request
+// so a retried call is recognizable as the same operation.
+//
+//	c := client.New("http://localhost:4000", client.WithAPIKey(os.Getenv("FEES_API_KEY")))
+//	created, err := c.CreateBill(ctx, &client.CreateBillRequest{CustomerID: "cust-1", Currency: "USD"})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IdempotencyKeyHeader carries the idempotency key of a mutating request. The same key
+// is sent on every retry of a call.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// TenantIDHeader identifies the calling tenant, which owns the bills it creates.
+const TenantIDHeader = "X-Tenant-ID"
+
+// RetryAttemptHeader numbers retried requests (2 for the first retry). The fees API
+// rejects retries of non-idempotent calls that were sent without an idempotency key.
+const RetryAttemptHeader = "X-Retry-Attempt"
+
+// Client calls the fees API. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	retry      RetryPolicy
+	newKey     func() string
+	tenantID   string
+	apiKey     string
+}
+
+// RetryPolicy controls how failed requests are retried. Network errors and 429, 502,
+// 503 and 504 responses are retried; other responses are returned to the caller.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first. Values below 1 mean 1.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry; it doubles on each retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy is used unless WithRetryPolicy is given.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithRetryPolicy overrides DefaultRetryPolicy.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+// WithIdempotencyKeyFunc sets the generator of idempotency keys for calls made without
+// an explicit key (see WithIdempotencyKey). The default generates random UUIDs.
+func WithIdempotencyKeyFunc(f func() string) Option {
+	return func(c *Client) { c.newKey = f }
+}
+
+// WithAPIKey authenticates requests with an API key issued by the fees API.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithTenantID sends tenantID in the X-Tenant-ID header, which the fees API uses to
+// assign new bills to the tenant and account requests against its monthly quotas.
+// Requests made with an API key use the key's tenant instead.
+func WithTenantID(tenantID string) Option {
+	return func(c *Client) { c.tenantID = tenantID }
+}
+
+// New returns a Client for the fees API served at baseURL (e.g. "http://localhost:4000").
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		retry:      DefaultRetryPolicy,
+		newKey:     uuid.NewString,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+type idempotencyKeyCtxKey struct{}
+
+// WithIdempotencyKey returns a context that makes the next mutating call use key instead
+// of a generated one, so a caller can safely repeat an operation across process restarts.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtxKey{}, key)
+}
+
+type ifMatchCtxKey struct{}
+
+// withIfMatch returns a context that sends version as the If-Match header of the
+// request made with it. A zero version sends none.
+func withIfMatch(ctx context.Context, version int64) context.Context {
+	if version == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, ifMatchCtxKey{}, strconv.FormatInt(version, 10))
+}
+
+// APIError is returned when the fees API responds with a non-2xx status.
+type APIError struct {
+	StatusCode int
+	// Code is the Encore error code, e.g. "not_found" or "invalid_argument".
+	Code    string
+	Message string
+	// Reason is the machine-readable error reason, e.g. "bill_closed" or
+	// "invalid_currency". Branch on it rather than on Message.
+	Reason string
+}
+
+// errorBody is the JSON body of an error response.
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details struct {
+		Reason            string `json:"reason"`
+		RetryAfterSeconds int    `json:"retryAfterSeconds"`
+	} `json:"details"`
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("fees api: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("fees api: %d: %s", e.StatusCode, e.Message)
+}
+
+// CreateBill creates a new bill.
+func (c *Client) CreateBill(ctx context.Context, req *CreateBillRequest) (*CreateBillResponse, error) {
+	var resp CreateBillResponse
+	if err := c.do(ctx, http.MethodPost, "/bills", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AddLineItem adds a line item to an open bill.
+func (c *Client) AddLineItem(ctx context.Context, billID string, req *AddLineItemRequest) (*AddLineItemResponse, error) {
+	path := "/bills/" + url.PathEscape(billID) + "/items"
+	if req.Wait {
+		path += "?wait=true"
+	}
+
+	var resp AddLineItemResponse
+	if err := c.do(withIfMatch(ctx, req.IfVersion), http.MethodPost, path, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CloseBill closes a bill, or moves it to CLOSING when params or the bill set a grace
+// period. params may be nil.
+func (c *Client) CloseBill(ctx context.Context, billID string, params *CloseBillParams) (*CloseBillResponse, error) {
+	path := "/bills/" + url.PathEscape(billID) + "/close"
+	if params != nil {
+		q := url.Values{}
+		if params.GracePeriod > 0 {
+			q.Set("gracePeriod", params.GracePeriod.String())
+		}
+		if !params.DueDate.IsZero() {
+			q.Set("dueDate", params.DueDate.Format(time.RFC3339))
+		}
+		if len(q) > 0 {
+			path += "?" + q.Encode()
+		}
+		ctx = withIfMatch(ctx, params.IfVersion)
+	}
+
+	var resp CloseBillResponse
+	if err := c.do(ctx, http.MethodPost, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetBill retrieves a bill.
+func (c *Client) GetBill(ctx context.Context, billID string) (*Bill, error) {
+	var resp struct {
+		Bill Bill `json:"bill"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/bills/"+url.PathEscape(billID), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Bill, nil
+}
+
+// GetBillAsOf retrieves a bill as it was at asOf, rebuilt from its event history, such
+// as the total a customer saw before the bill changed.
+func (c *Client) GetBillAsOf(ctx context.Context, billID string, asOf time.Time) (*Bill, error) {
+	var resp struct {
+		Bill Bill `json:"bill"`
+	}
+	path := "/bills/" + url.PathEscape(billID) + "?" + url.Values{"asOf": {asOf.Format(time.RFC3339Nano)}}.Encode()
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Bill, nil
+}
+
+// GetBillAtLeast retrieves a bill once it reflects the change that returned
+// stateToken, such as an AddLineItem, so tests need not sleep before reading their
+// writes. The service waits up to 10 seconds and then fails with
+// state_token_timeout.
+func (c *Client) GetBillAtLeast(ctx context.Context, billID, stateToken string) (*Bill, error) {
+	var resp struct {
+		Bill Bill `json:"bill"`
+	}
+	path := "/bills/" + url.PathEscape(billID) + "?" + url.Values{"minStateToken": {stateToken}}.Encode()
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Bill, nil
+}
+
+// WaitForBill waits for a bill to reach status, or to close if status is
+// BillStatusClosed, and returns it with reached true. If the bill has not got there
+// after timeout, at most a minute, it is returned as it is with reached false, and the
+// call can be repeated. An empty status waits for CLOSED, and a zero timeout waits 30
+// seconds.
+func (c *Client) WaitForBill(ctx context.Context, billID string, status BillStatus, timeout time.Duration) (*Bill, bool, error) {
+	var resp struct {
+		Bill    Bill `json:"bill"`
+		Reached bool `json:"reached"`
+	}
+	query := url.Values{}
+	if status != "" {
+		query.Set("status", string(status))
+	}
+	if timeout > 0 {
+		query.Set("timeout", timeout.String())
+	}
+	path := "/bills/" + url.PathEscape(billID) + "/wait"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, false, err
+	}
+	return &resp.Bill, resp.Reached, nil
+}
+
+// GetBillWithWorkflow retrieves a bill along with a description of its workflow, such
+// as the activities it is retrying. The workflow is nil when the service could not
+// describe it.
+func (c *Client) GetBillWithWorkflow(ctx context.Context, billID string) (*Bill, *BillWorkflow, error) {
+	var resp struct {
+		Bill     Bill          `json:"bill"`
+		Workflow *BillWorkflow `json:"workflow"`
+	}
+	path := "/bills/" + url.PathEscape(billID) + "?includeWorkflow=true"
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, nil, err
+	}
+	return &resp.Bill, resp.Workflow, nil
+}
+
+// SetSpendingAlerts replaces the spending alerts of a bill that has not closed yet; nil
+// alerts remove them. ifVersion, when set, applies the change only if the bill is still
+// at that version.
+func (c *Client) SetSpendingAlerts(ctx context.Context, billID string, alerts *SpendingAlerts, ifVersion int64) error {
+	if alerts == nil {
+		alerts = &SpendingAlerts{}
+	}
+	path := "/bills/" + url.PathEscape(billID) + "/spending-alerts"
+	return c.do(withIfMatch(ctx, ifVersion), http.MethodPut, path, alerts, nil)
+}
+
+// CloseBills starts closing every open bill matching req and returns the close_batch
+// job, whose progress GetJob reports.
+func (c *Client) CloseBills(ctx context.Context, req *CloseBillsRequest) (*Job, error) {
+	var resp struct {
+		Job Job `json:"job"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/bills/close-batch", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Job, nil
+}
+
+// GetJob retrieves a job with its status, progress and result.
+func (c *Client) GetJob(ctx context.Context, jobID string) (*Job, error) {
+	var resp struct {
+		Job Job `json:"job"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(jobID), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Job, nil
+}
+
+// CancelJob asks a job to stop. It is CANCELED once it has stopped.
+func (c *Client) CancelJob(ctx context.Context, jobID string) (*Job, error) {
+	var resp struct {
+		Job Job `json:"job"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/jobs/"+url.PathEscape(jobID)+"/cancel", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Job, nil
+}
+
+// ListBills lists bills, optionally filtered by params.
+func (c *Client) ListBills(ctx context.Context, params *ListBillsParams) (*ListBillsResponse, error) {
+	path := "/bills"
+	if params != nil {
+		q := url.Values{}
+		if params.Status != "" {
+			q.Set("status", string(params.Status))
+		}
+		if params.Currency != "" {
+			q.Set("currency", params.Currency)
+		}
+		if params.CustomerID != "" {
+			q.Set("customerId", params.CustomerID)
+		}
+		if params.TenantID != "" {
+			q.Set("tenantId", params.TenantID)
+		}
+		if params.Limit > 0 {
+			q.Set("limit", strconv.Itoa(params.Limit))
+		}
+		if params.Offset > 0 {
+			q.Set("offset", strconv.Itoa(params.Offset))
+		}
+		if len(q) > 0 {
+			path += "?" + q.Encode()
+		}
+	}
+
+	var resp ListBillsResponse
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// do sends the request, retrying per the client's RetryPolicy, and decodes a 2xx
+// JSON response into out.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("fees api: failed to encode request: %w", err)
+		}
+	}
+
+	var idempotencyKey string
+	if method != http.MethodGet {
+		idempotencyKey, _ = ctx.Value(idempotencyKeyCtxKey{}).(string)
+		if idempotencyKey == "" {
+			idempotencyKey = c.newKey()
+		}
+	}
+
+	attempts := max(c.retry.MaxAttempts, 1)
+	backoff := c.retry.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := c.attempt(ctx, method, path, payload, idempotencyKey, attempt, out)
+		if err == nil || attempt >= attempts || !retryable(err, retryAfter) {
+			return err
+		}
+
+		wait := jitter(backoff)
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff = min(backoff*2, c.retry.MaxBackoff)
+	}
+}
+
+// attempt performs a single HTTP round trip. It returns the server's Retry-After hint, if any.
+func (c *Client) attempt(ctx context.Context, method, path string, payload []byte, idempotencyKey string, attempt int, out any) (time.Duration, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return 0, fmt.Errorf("fees api: failed to build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set(IdempotencyKeyHeader, idempotencyKey)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	if c.tenantID != "" {
+		req.Header.Set(TenantIDHeader, c.tenantID)
+	}
+	if ifMatch, _ := ctx.Value(ifMatchCtxKey{}).(string); ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	if attempt > 1 {
+		req.Header.Set(RetryAttemptHeader, strconv.Itoa(attempt))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, &transportError{err: err}
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, &transportError{err: err}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var body errorBody
+		if json.Unmarshal(respBody, &body) != nil || body.Message == "" {
+			body.Message = strings.TrimSpace(string(respBody))
+		}
+		apiErr := &APIError{StatusCode: resp.StatusCode, Code: body.Code, Message: body.Message, Reason: body.Details.Reason}
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		if retryAfter == 0 && body.Details.RetryAfterSeconds > 0 {
+			// Throttled requests report the delay in the error details.
+			retryAfter = time.Duration(body.Details.RetryAfterSeconds) * time.Second
+		}
+		return retryAfter, apiErr
+	}
+
+	if out != nil && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return 0, fmt.Errorf("fees api: failed to decode response: %w", err)
+		}
+	}
+	return 0, nil
+}
+
+// transportError wraps a network-level failure, which is always retryable.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string { return "fees api: " + e.err.Error() }
+func (e *transportError) Unwrap() error { return e.err }
+
+// retryable reports whether a failed attempt should be retried. An exhausted monthly
+// quota is reported as 429 without Retry-After and is not worth retrying.
+func retryable(err error, retryAfter time.Duration) bool {
+	var tErr *transportError
+	if errors.As(err, &tErr) {
+		return true
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests:
+			return retryAfter > 0 || apiErr.Code != "resource_exhausted"
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+	return false
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds.
+func parseRetryAfter(v string) time.Duration {
+	secs, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// jitter returns a random duration in [d/2, d).
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
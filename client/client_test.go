@@ -0,0 +1,268 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var fastRetries = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+// TestCreateBill_RetriesWithSameIdempotencyKey tests that a retried mutation reuses its idempotency key.
+func TestCreateBill_RetriesWithSameIdempotencyKey(t *testing.T) {
+	var mu sync.Mutex
+	var keys, attempts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get(IdempotencyKeyHeader))
+		attempts = append(attempts, r.Header.Get(RetryAttemptHeader))
+		attempt := len(keys)
+		mu.Unlock()
+
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/bills", r.URL.Path)
+		if attempt == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var req CreateBillRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Equal(t, "USD", req.Currency)
+		json.NewEncoder(w).Encode(CreateBillResponse{BillID: "bill-1", InitialStatus: BillStatusOpen})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithRetryPolicy(fastRetries))
+	resp, err := c.CreateBill(context.Background(), &CreateBillRequest{Currency: "USD"})
+	require.NoError(t, err)
+	require.Equal(t, "bill-1", resp.BillID)
+
+	require.Len(t, keys, 2)
+	require.NotEmpty(t, keys[0])
+	require.Equal(t, keys[0], keys[1])
+	require.Equal(t, []string{"", "2"}, attempts)
+}
+
+// TestAddLineItem_ExplicitIdempotencyKey tests that a key set on the context is sent instead of a generated one.
+func TestAddLineItem_ExplicitIdempotencyKey(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/bills/bill-1/items", r.URL.Path)
+		require.Equal(t, "usage-42", r.Header.Get(IdempotencyKeyHeader))
+		require.Equal(t, "Bearer fms_test", r.Header.Get("Authorization"))
+		json.NewEncoder(w).Encode(AddLineItemResponse{LineItemID: "li-1", BillID: "bill-1"})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithAPIKey("fms_test"))
+	ctx := WithIdempotencyKey(context.Background(), "usage-42")
+	resp, err := c.AddLineItem(ctx, "bill-1", &AddLineItemRequest{Description: "Usage", Amount: 5})
+	require.NoError(t, err)
+	require.Equal(t, "li-1", resp.LineItemID)
+}
+
+// TestAddLineItem_Wait tests that the wait option is sent as a query parameter and the bill's post-add state decoded.
+func TestAddLineItem_Wait(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/bills/bill-1/items", r.URL.Path)
+		require.Equal(t, "true", r.URL.Query().Get("wait"))
+		total := 12.5
+		json.NewEncoder(w).Encode(AddLineItemResponse{LineItemID: "li-1", BillID: "bill-1", TotalAmount: &total, ItemCount: 2, Status: BillStatusOpen})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	resp, err := c.AddLineItem(context.Background(), "bill-1", &AddLineItemRequest{Description: "Usage", Amount: 5, Wait: true})
+	require.NoError(t, err)
+	require.Equal(t, 12.5, *resp.TotalAmount)
+	require.Equal(t, 2, resp.ItemCount)
+	require.Equal(t, BillStatusOpen, resp.Status)
+}
+
+// TestCloseBill_GracePeriod tests that a close grace period is sent as a query parameter.
+func TestCloseBill_GracePeriod(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/bills/bill-1/close", r.URL.Path)
+		require.Equal(t, "5m0s", r.URL.Query().Get("gracePeriod"))
+		json.NewEncoder(w).Encode(CloseBillResponse{Bill: Bill{ID: "bill-1", Status: BillStatusClosing}})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	resp, err := c.CloseBill(context.Background(), "bill-1", &CloseBillParams{GracePeriod: 5 * time.Minute})
+	require.NoError(t, err)
+	require.Equal(t, BillStatusClosing, resp.Status)
+}
+
+// TestGetBillAsOf tests that the point in time is sent as an RFC 3339 asOf query parameter.
+func TestGetBillAsOf(t *testing.T) {
+	asOf := time.Date(2024, 6, 15, 9, 30, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/bills/bill-1", r.URL.Path)
+		require.Equal(t, "2024-06-15T09:30:00Z", r.URL.Query().Get("asOf"))
+		json.NewEncoder(w).Encode(map[string]any{"bill": Bill{ID: "bill-1", TotalAmount: 40}})
+	}))
+	defer srv.Close()
+
+	bill, err := New(srv.URL).GetBillAsOf(context.Background(), "bill-1", asOf)
+	require.NoError(t, err)
+	require.Equal(t, 40.0, bill.TotalAmount)
+}
+
+// TestGetBillAtLeast tests that the state token is sent as the minStateToken query parameter.
+func TestGetBillAtLeast(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/bills/bill-1", r.URL.Path)
+		require.Equal(t, "v3", r.URL.Query().Get("minStateToken"))
+		json.NewEncoder(w).Encode(map[string]any{"bill": Bill{ID: "bill-1", Version: 3}})
+	}))
+	defer srv.Close()
+
+	bill, err := New(srv.URL).GetBillAtLeast(context.Background(), "bill-1", "v3")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), bill.Version)
+}
+
+// TestWaitForBill tests that the status and timeout are sent as query parameters and
+// whether the bill reached the status is decoded.
+func TestWaitForBill(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/bills/bill-1/wait", r.URL.Path)
+		require.Equal(t, "PAID", r.URL.Query().Get("status"))
+		require.Equal(t, "45s", r.URL.Query().Get("timeout"))
+		json.NewEncoder(w).Encode(map[string]any{"bill": Bill{ID: "bill-1", Status: BillStatusPaid}, "reached": true})
+	}))
+	defer srv.Close()
+
+	bill, reached, err := New(srv.URL).WaitForBill(context.Background(), "bill-1", BillStatusPaid, 45*time.Second)
+	require.NoError(t, err)
+	require.True(t, reached)
+	require.Equal(t, BillStatusPaid, bill.Status)
+}
+
+// TestGetBillWithWorkflow tests that the workflow description is requested and decoded.
+func TestGetBillWithWorkflow(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "true", r.URL.Query().Get("includeWorkflow"))
+		json.NewEncoder(w).Encode(map[string]any{
+			"bill":     Bill{ID: "bill-1", Status: BillStatusClosing},
+			"workflow": BillWorkflow{WorkflowID: "bill-bill-1", Status: "Running", LastFailure: "connection refused"},
+		})
+	}))
+	defer srv.Close()
+
+	bill, wf, err := New(srv.URL).GetBillWithWorkflow(context.Background(), "bill-1")
+	require.NoError(t, err)
+	require.Equal(t, BillStatusClosing, bill.Status)
+	require.Equal(t, "connection refused", wf.LastFailure)
+}
+
+// TestCloseBill_IfVersion tests that IfVersion is sent as If-Match and a version mismatch is not retried.
+func TestCloseBill_IfVersion(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		require.Equal(t, "3", r.Header.Get("If-Match"))
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code":"failed_precondition","message":"bill is at version 4","details":{"reason":"version_mismatch"}}`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithRetryPolicy(fastRetries))
+	_, err := c.CloseBill(context.Background(), "bill-1", &CloseBillParams{IfVersion: 3})
+
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, "version_mismatch", apiErr.Reason)
+	require.Equal(t, 1, calls)
+}
+
+// TestGetBill_APIErrorIsNotRetried tests that client errors are decoded and returned without retrying.
+func TestGetBill_APIErrorIsNotRetried(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		require.Empty(t, r.Header.Get(IdempotencyKeyHeader))
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"code":"not_found","message":"bill not found","details":{"reason":"bill_not_found"}}`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithRetryPolicy(fastRetries))
+	_, err := c.GetBill(context.Background(), "missing")
+
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	require.Equal(t, "not_found", apiErr.Code)
+	require.Equal(t, "bill_not_found", apiErr.Reason)
+	require.Equal(t, 1, calls)
+}
+
+// TestListBills_QueryParameters tests that filters are sent as query parameters and retries stop at MaxAttempts.
+func TestListBills_QueryParameters(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		require.Equal(t, "CLOSED", r.URL.Query().Get("status"))
+		require.Equal(t, "EUR", r.URL.Query().Get("currency"))
+		require.Equal(t, "cust-1", r.URL.Query().Get("customerId"))
+		require.Equal(t, "10", r.URL.Query().Get("limit"))
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithRetryPolicy(fastRetries))
+	_, err := c.ListBills(context.Background(), &ListBillsParams{Status: BillStatusClosed, Currency: "EUR", CustomerID: "cust-1", Limit: 10})
+	require.Error(t, err)
+	require.Equal(t, fastRetries.MaxAttempts, calls)
+}
+
+// TestCreateBill_QuotaExhaustedIsNotRetried tests that an exhausted monthly quota fails fast.
+func TestCreateBill_QuotaExhaustedIsNotRetried(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		require.Equal(t, "tenant-a", r.Header.Get(TenantIDHeader))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"code":"resource_exhausted","message":"monthly bills_created quota of 10 exhausted"}`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithRetryPolicy(fastRetries), WithTenantID("tenant-a"))
+	_, err := c.CreateBill(context.Background(), &CreateBillRequest{Currency: "USD"})
+
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, "resource_exhausted", apiErr.Code)
+	require.Equal(t, 1, calls)
+}
+
+// TestAddLineItem_RateLimitedIsRetried tests that a throttled request waits out the delay from the error details.
+func TestAddLineItem_RateLimitedIsRetried(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"code":"resource_exhausted","message":"rate limit exceeded","details":{"reason":"rate_limited","retryAfterSeconds":1}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(AddLineItemResponse{LineItemID: "li-1", BillID: "bill-1"})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithRetryPolicy(fastRetries))
+	start := time.Now()
+	resp, err := c.AddLineItem(context.Background(), "bill-1", &AddLineItemRequest{Description: "Usage", Amount: 5})
+	require.NoError(t, err)
+	require.Equal(t, "li-1", resp.LineItemID)
+	require.Equal(t, 2, calls)
+	require.GreaterOrEqual(t, time.Since(start), time.Second)
+}
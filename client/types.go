@@ -0,0 +1,465 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// BillStatus represents the status of a bill.
+type BillStatus string
+
+const (
+	BillStatusOpen            BillStatus = "OPEN"
+	BillStatusClosing         BillStatus = "CLOSING"
+	BillStatusPendingApproval BillStatus = "PENDING_APPROVAL"
+	BillStatusClosed          BillStatus = "CLOSED"
+	BillStatusPaid            BillStatus = "PAID"
+	BillStatusPaymentFailed   BillStatus = "PAYMENT_FAILED"
+	BillStatusDelinquent      BillStatus = "DELINQUENT"
+	BillStatusCloseFailed     BillStatus = "CLOSE_FAILED"
+	BillStatusOverdue         BillStatus = "OVERDUE"
+	BillStatusPartiallyPaid   BillStatus = "PARTIALLY_PAID"
+)
+
+// Bill represents a bill as returned by the fees API.
+type Bill struct {
+	ID string `json:"id"`
+	// Number is the bill's human-readable number, such as CUST123-2024-00042, given
+	// when it closes. Bills without a customer are not numbered.
+	Number           string       `json:"number,omitempty"`
+	TenantID         string       `json:"tenantId,omitempty"`
+	CustomerID       string       `json:"customerId,omitempty"`
+	Currency         string       `json:"currency"`
+	Status           BillStatus   `json:"status"`
+	LineItems        []LineItem   `json:"lineItems"`
+	TotalAmount      float64      `json:"totalAmount"`
+	CreatedAt        *time.Time   `json:"createdAt"`
+	ClosedAt         *time.Time   `json:"closedAt,omitempty"`
+	CloseRequestedAt *time.Time   `json:"closeRequestedAt,omitempty"`
+	FinalizesAt      *time.Time   `json:"finalizesAt,omitempty"`
+	DueDate          *time.Time   `json:"dueDate,omitempty"`
+	AutoCollect      bool         `json:"autoCollect,omitempty"`
+	Payments         []Payment    `json:"payments,omitempty"`
+	RefundedAmount   float64      `json:"refundedAmount,omitempty"`
+	CreditNotes      []CreditNote `json:"creditNotes,omitempty"`
+	FollowUpOf       string       `json:"followUpOf,omitempty"`
+	TemplateID       string       `json:"templateId,omitempty"`
+	// ParentBillID is set on a sub-bill to the bill its total rolls up to on close.
+	ParentBillID string      `json:"parentBillId,omitempty"`
+	Adjustment   *Adjustment `json:"adjustment,omitempty"`
+	// AppliedCredit is set when the customer's credit balance was applied on close.
+	AppliedCredit *AppliedCredit `json:"appliedCredit,omitempty"`
+	// Commitment is set on bills under a committed-use contract.
+	Commitment *Commitment `json:"commitment,omitempty"`
+	// Coupons are the coupons applied to the bill, taken off its total when it closes.
+	Coupons []AppliedCoupon `json:"coupons,omitempty"`
+	// PeriodEnd is when the bill's billing period ends, if it has one.
+	PeriodEnd *time.Time `json:"periodEnd,omitempty"`
+	// Rounding is how the bill's line items and totals are rounded to its currency's
+	// minor units, if the bill has a rounding policy.
+	Rounding *Rounding `json:"rounding,omitempty"`
+	// AmountPaid is the sum of the bill's successful payments.
+	AmountPaid float64 `json:"amountPaid,omitempty"`
+	// BalanceDue is the part of a closed bill's total not yet paid.
+	BalanceDue *float64 `json:"balanceDue,omitempty"`
+	// Allocations lists the successful payments applied to the bill's balance.
+	Allocations []PaymentAllocation `json:"allocations,omitempty"`
+	// SpendingAlerts notify the customer as the bill's total reaches percentages of a
+	// budget, and CrossedThresholds lists those reached so far.
+	SpendingAlerts    *SpendingAlerts    `json:"spendingAlerts,omitempty"`
+	CrossedThresholds []CrossedThreshold `json:"crossedThresholds,omitempty"`
+	// HardCap bounds the bill's total as line items arrive. Items it rejects fail
+	// with reason "quota_exceeded".
+	HardCap *HardCap `json:"hardCap,omitempty"`
+	// Version increases with every change to the bill. Pass it as IfVersion to apply a
+	// change only if the bill has not changed since it was read.
+	Version int64 `json:"version"`
+	// Stale is set when the bill was read from the database because Temporal was
+	// unavailable; recent changes may be missing.
+	Stale bool `json:"stale,omitempty"`
+}
+
+// SpendingAlerts notifies a customer as a bill's total approaches a budget.
+type SpendingAlerts struct {
+	Budget float64 `json:"budget"`
+	// Thresholds are percentages of Budget, such as 80 and 100.
+	Thresholds []float64 `json:"thresholds"`
+}
+
+// HardCap bounds a bill's total as its line items arrive.
+type HardCap struct {
+	Amount float64 `json:"amount"`
+	// Action is "reject" or "flag".
+	Action string `json:"action"`
+}
+
+// CrossedThreshold records a spending alert threshold a bill's total reached.
+type CrossedThreshold struct {
+	Threshold float64   `json:"threshold"`
+	Amount    float64   `json:"amount"`
+	Total     float64   `json:"total"`
+	CrossedAt time.Time `json:"crossedAt"`
+}
+
+// Adjustment records how a bill's total was adjusted against the customer's
+// minimum or maximum bill total when it closed.
+type Adjustment struct {
+	// Kind is "minimum_commitment", "maximum_cap", or "maximum_exceeded".
+	Kind        string  `json:"kind"`
+	Limit       float64 `json:"limit"`
+	TotalBefore float64 `json:"totalBefore"`
+	Amount      float64 `json:"amount,omitempty"`
+	LineItemID  string  `json:"lineItemId,omitempty"`
+}
+
+// AppliedCredit records the customer credit applied to a bill when it closed.
+type AppliedCredit struct {
+	// Amount is the credit taken from the customer's balance, as a positive amount.
+	Amount float64 `json:"amount"`
+	// LineItemID is the negative line item that deducts Amount from the bill.
+	LineItemID string `json:"lineItemId"`
+}
+
+// Commitment is the committed amount a bill billed up front under the customer's
+// contract, and how much of it the bill's usage has drawn down.
+type Commitment struct {
+	Amount     float64 `json:"amount"`
+	LineItemID string  `json:"lineItemId"`
+	DrawnDown  float64 `json:"drawnDown"`
+	Remaining  float64 `json:"remaining"`
+}
+
+// AppliedCoupon is a coupon applied to a bill. Kind is "percentage" or "fixed".
+// Amount and LineItemID are set once the bill closed, to the discount taken off and
+// the credit taking it off.
+type AppliedCoupon struct {
+	Code       string  `json:"code"`
+	Kind       string  `json:"kind"`
+	PercentOff float64 `json:"percentOff,omitempty"`
+	AmountOff  float64 `json:"amountOff,omitempty"`
+	Amount     float64 `json:"amount,omitempty"`
+	LineItemID string  `json:"lineItemId,omitempty"`
+}
+
+// Rounding is a bill's rounding policy.
+type Rounding struct {
+	// Mode is "half_up", "half_even", or "floor".
+	Mode string `json:"mode"`
+	// Decimals is the number of decimals amounts are rounded to, such as 0 for JPY.
+	Decimals int `json:"decimals"`
+}
+
+// LineItemType says whether a line item charges, credits or adjusts its bill.
+type LineItemType string
+
+const (
+	// LineItemCharge items have positive amounts; items without a type are charges.
+	LineItemCharge LineItemType = "CHARGE"
+	// LineItemCredit items have negative amounts.
+	LineItemCredit LineItemType = "CREDIT"
+	// LineItemAdjustment items correct the bill's total up or down.
+	LineItemAdjustment LineItemType = "ADJUSTMENT"
+)
+
+// LineItem is a single charge, credit or adjustment on a bill.
+type LineItem struct {
+	ID          string  `json:"id"`
+	Description string  `json:"description"`
+	Amount      float64 `json:"amount"`
+	// Type is left empty on items of bills that ran before types existed, which are
+	// charges unless negative.
+	Type LineItemType `json:"type,omitempty"`
+	// ReasonCode says why a credit or adjustment was made, and Reference links it to
+	// what it corrects.
+	ReasonCode string             `json:"reasonCode,omitempty"`
+	Reference  *LineItemReference `json:"reference,omitempty"`
+	// Quantity, UnitPrice and Unit are set on items priced per unit; Amount is then
+	// their product.
+	Quantity   float64     `json:"quantity,omitempty"`
+	UnitPrice  float64     `json:"unitPrice,omitempty"`
+	Unit       string      `json:"unit,omitempty"`
+	RoutedFrom *RoutedFrom `json:"routedFrom,omitempty"`
+	// SubBillID is set on the item holding the rolled-up total of a sub-bill.
+	SubBillID string `json:"subBillId,omitempty"`
+	Late      bool   `json:"late,omitempty"`
+	// OverHardCap is set on items accepted above the bill's hard cap.
+	OverHardCap bool `json:"overHardCap,omitempty"`
+	// ClientReference is the caller's own ID for the item, if one was given.
+	ClientReference string `json:"clientReference,omitempty"`
+	// Metric is set on items rating usage of a metric the bill prices in tiers.
+	Metric string `json:"metric,omitempty"`
+	// Drawdown is the part of the item's amount the bill's commitment covered; Amount
+	// is only the overage.
+	Drawdown float64 `json:"drawdown,omitempty"`
+	// Waived is set on the zero-amount items recording what a free tier or trial waived
+	// of the item they reference.
+	Waived float64 `json:"waived,omitempty"`
+}
+
+// LineItemReference links a credit or adjustment to what it corrects: an item of the
+// same bill, or a record outside the service such as a support ticket.
+type LineItemReference struct {
+	LineItemID string `json:"lineItemId,omitempty"`
+	External   string `json:"external,omitempty"`
+}
+
+// RoutedFrom tags a line item forwarded from a bill that had already closed.
+type RoutedFrom struct {
+	BillID      string     `json:"billId"`
+	PeriodStart *time.Time `json:"periodStart,omitempty"`
+	PeriodEnd   *time.Time `json:"periodEnd,omitempty"`
+}
+
+// Payment is one attempt to collect a bill.
+type Payment struct {
+	ID               string     `json:"id"`
+	Status           string     `json:"status"`
+	Amount           float64    `json:"amount"`
+	GatewayReference string     `json:"gatewayReference,omitempty"`
+	FailureReason    string     `json:"failureReason,omitempty"`
+	CreatedAt        *time.Time `json:"createdAt"`
+	CompletedAt      *time.Time `json:"completedAt,omitempty"`
+	// Method is how a recorded payment was received, such as "bank_transfer".
+	Method string `json:"method,omitempty"`
+}
+
+// PaymentAllocation is the part of a bill's balance settled by one successful payment.
+type PaymentAllocation struct {
+	PaymentID    string    `json:"paymentId"`
+	Amount       float64   `json:"amount"`
+	BalanceAfter float64   `json:"balanceAfter"`
+	AllocatedAt  time.Time `json:"allocatedAt"`
+}
+
+// CreditNote records a full or partial refund of a bill.
+type CreditNote struct {
+	ID               string     `json:"id"`
+	Status           string     `json:"status"`
+	Amount           float64    `json:"amount"`
+	Reason           string     `json:"reason,omitempty"`
+	LineItems        []LineItem `json:"lineItems"`
+	GatewayReference string     `json:"gatewayReference,omitempty"`
+	FailureReason    string     `json:"failureReason,omitempty"`
+	CreatedAt        *time.Time `json:"createdAt"`
+	CompletedAt      *time.Time `json:"completedAt,omitempty"`
+}
+
+// CreateBillRequest is the request payload for creating a new bill.
+type CreateBillRequest struct {
+	CustomerID  string `json:"customerId,omitempty"`
+	Currency    string `json:"currency"`
+	AutoCollect bool   `json:"autoCollect,omitempty"`
+	// CloseGracePeriod (e.g. "5m") overrides the template's and the service's default
+	// close grace period.
+	CloseGracePeriod string `json:"closeGracePeriod,omitempty"`
+	// TemplateID seeds the bill with a bill template's recurring line items.
+	TemplateID string `json:"templateId,omitempty"`
+	// DueDate is when payment is due once the bill closes. A bill still unpaid then
+	// becomes OVERDUE.
+	DueDate *time.Time `json:"dueDate,omitempty"`
+	// PeriodEnd is when the bill's billing period ends. The service closes bills still
+	// open after it.
+	PeriodEnd *time.Time `json:"periodEnd,omitempty"`
+	// SpendingAlerts overrides the customer's spending alerts in the bill's currency.
+	SpendingAlerts *SpendingAlerts `json:"spendingAlerts,omitempty"`
+	// ParentBillID makes the bill a sub-bill of an open bill in the same currency,
+	// whose total is added to the parent when it closes.
+	ParentBillID string `json:"parentBillId,omitempty"`
+	// UsagePrices price the usage of metrics in tiers, for line items that give a
+	// metric and a quantity instead of an amount.
+	UsagePrices []UsagePrice `json:"usagePrices,omitempty"`
+	// FreeTiers waive the first units of a metric's usage, or what the bill charges in
+	// the first days after it is created.
+	FreeTiers []FreeTier `json:"freeTiers,omitempty"`
+	// Coupons are codes of coupons to apply to the bill, redeemed as it is created.
+	Coupons []string `json:"coupons,omitempty"`
+}
+
+// FreeTier waives the first Units units of a metric's usage, or what a bill charges
+// in its first Days days. A rule sets one of Units and Days; a trial without a Metric
+// waives every charge.
+type FreeTier struct {
+	Metric string  `json:"metric,omitempty"`
+	Units  float64 `json:"units,omitempty"`
+	Days   int     `json:"days,omitempty"`
+}
+
+// UsagePrice prices the usage of one metric in tiers. Mode is "graduated" (the
+// default), pricing each unit at the tier it falls in, or "volume", pricing every unit
+// at the tier the total usage falls in.
+type UsagePrice struct {
+	Metric string      `json:"metric"`
+	Mode   string      `json:"mode,omitempty"`
+	Tiers  []PriceTier `json:"tiers"`
+}
+
+// PriceTier is one tier of a usage price. UpTo is the usage the tier ends at, counted
+// from zero; the last tier leaves it 0.
+type PriceTier struct {
+	UpTo      float64 `json:"upTo,omitempty"`
+	UnitPrice float64 `json:"unitPrice"`
+}
+
+// CreateBillResponse is the response payload after creating a bill. Queued is set
+// when Temporal was unavailable and the bill will be created once it is back.
+type CreateBillResponse struct {
+	BillID          string     `json:"billId"`
+	WorkflowID      string     `json:"workflowId"`
+	RunID           string     `json:"runId"`
+	InitialStatus   BillStatus `json:"initialStatus"`
+	Queued          bool       `json:"queued,omitempty"`
+	ConfirmationMsg string     `json:"confirmationMsg"`
+}
+
+// AddLineItemRequest is the request payload for adding a line item to a bill.
+type AddLineItemRequest struct {
+	Description string `json:"description"`
+	// Amount may be left out when Quantity and UnitPrice are given, in which case the
+	// item's amount is their product.
+	Amount    float64 `json:"amount,omitempty"`
+	Quantity  float64 `json:"quantity,omitempty"`
+	UnitPrice float64 `json:"unitPrice,omitempty"`
+	// Unit names what Quantity counts, such as "transaction" or "GB".
+	Unit string `json:"unit,omitempty"`
+	// Currency, when set, must be the bill's currency.
+	Currency string `json:"currency,omitempty"`
+	// Metric rates Quantity units of usage with the bill's usage price for the metric,
+	// leaving Amount and UnitPrice out.
+	Metric string `json:"metric,omitempty"`
+	// Type is CHARGE (the default), CREDIT or ADJUSTMENT. Charges are positive, credits
+	// negative, and adjustments either. Credits and adjustments require a ReasonCode
+	// from the service's configured list, failing with reason "unknown_reason_code"
+	// otherwise.
+	Type       LineItemType `json:"type,omitempty"`
+	ReasonCode string       `json:"reasonCode,omitempty"`
+	// Reference optionally links a credit or adjustment to the item it corrects.
+	Reference *LineItemReference `json:"reference,omitempty"`
+	// AllowNegativeTotal lets a credit or adjustment take the bill's total below zero,
+	// which otherwise fails with reason "negative_total".
+	AllowNegativeTotal bool `json:"allowNegativeTotal,omitempty"`
+	// ClientReference optionally identifies the item, e.g. by usage record ID. Adding an
+	// item with a reference the bill already holds adds nothing and returns the existing
+	// item with Duplicate set, so re-ingesting a record cannot charge it twice.
+	ClientReference string `json:"clientReference,omitempty"`
+	// Wait holds the response until the bill reports the item applied, so a GetBill
+	// right after sees it. It is sent as the wait query parameter.
+	Wait bool `json:"-"`
+	// IfVersion, when set, adds the item only if the bill is still at this version,
+	// failing with reason "version_mismatch" otherwise. It is sent as If-Match.
+	IfVersion int64 `json:"-"`
+}
+
+// AddLineItemResponse is the response payload after adding a line item. BillID differs
+// from the requested bill when a late item was routed to the customer's next bill.
+// Queued is set when Temporal was unavailable and the item will be added once it is back.
+type AddLineItemResponse struct {
+	LineItemID string `json:"lineItemId"`
+	BillID     string `json:"billId"`
+	Queued     bool   `json:"queued,omitempty"`
+	Duplicate  bool   `json:"duplicate,omitempty"`
+	// StateToken identifies the bill's state with the item. Pass it to
+	// GetBillAtLeast to read the bill with the item.
+	StateToken string `json:"stateToken,omitempty"`
+	// TotalAmount, ItemCount and Status describe the bill just after the item was
+	// applied. They are set only when Wait was requested.
+	TotalAmount     *float64   `json:"totalAmount,omitempty"`
+	ItemCount       int        `json:"itemCount,omitempty"`
+	Status          BillStatus `json:"status,omitempty"`
+	ConfirmationMsg string     `json:"confirmationMsg"`
+}
+
+// CloseBillParams holds the optional parameters of CloseBill.
+type CloseBillParams struct {
+	// GracePeriod keeps the bill CLOSING and accepting late line items for this long
+	// before it finalizes. Zero uses the bill's own grace period.
+	GracePeriod time.Duration
+	// DueDate, when set, replaces the due date given at creation.
+	DueDate time.Time
+	// IfVersion, when set, closes the bill only if it is still at this version,
+	// failing with reason "version_mismatch" otherwise. It is sent as If-Match.
+	IfVersion int64
+}
+
+// CloseBillResponse is the response payload after closing a bill.
+type CloseBillResponse struct {
+	Bill
+	ConfirmationMsg string `json:"confirmationMsg,omitempty"`
+}
+
+// ListBillsParams filters ListBills. Zero values are omitted.
+type ListBillsParams struct {
+	Status     BillStatus
+	Currency   string
+	CustomerID string
+	// TenantID filters the listing to one tenant; API keys only see their own tenant.
+	TenantID string
+	Limit    int
+	Offset   int
+}
+
+// ListBillsResponse is the response payload for listing bills.
+type ListBillsResponse struct {
+	Bills      []Bill `json:"bills"`
+	TotalCount int    `json:"totalCount"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	// Stale is set when the list was read from the database because Temporal was
+	// unavailable.
+	Stale bool `json:"stale,omitempty"`
+}
+
+// BillWorkflow describes the Temporal workflow that runs a bill, as returned by
+// GetBillWithWorkflow.
+type BillWorkflow struct {
+	WorkflowID        string            `json:"workflowId"`
+	RunID             string            `json:"runId"`
+	Status            string            `json:"status"`
+	StartedAt         time.Time         `json:"startedAt"`
+	ClosedAt          *time.Time        `json:"closedAt,omitempty"`
+	HistoryLength     int64             `json:"historyLength"`
+	PendingActivities []PendingActivity `json:"pendingActivities"`
+	// LastFailure is the most recent failure of a pending activity.
+	LastFailure string `json:"lastFailure,omitempty"`
+}
+
+// PendingActivity is an activity a bill's workflow is waiting on.
+type PendingActivity struct {
+	Type          string     `json:"type"`
+	State         string     `json:"state"`
+	Attempt       int32      `json:"attempt"`
+	LastFailure   string     `json:"lastFailure,omitempty"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+}
+
+// CloseBillsRequest selects the open bills CloseBills closes. At least one of
+// CustomerIDs and CreatedBefore is required; bills must match both when both are set.
+type CloseBillsRequest struct {
+	CustomerIDs   []string   `json:"customerIds,omitempty"`
+	CreatedBefore *time.Time `json:"createdBefore,omitempty"`
+	// TenantID limits an internal caller's batch to one tenant.
+	TenantID string `json:"tenantId,omitempty"`
+}
+
+// Job is a long-running operation, such as a batch started by CloseBills.
+type Job struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// Status is QUEUED, RUNNING, SUCCEEDED, FAILED or CANCELED.
+	Status   string      `json:"status"`
+	Progress JobProgress `json:"progress"`
+	// Result is the outcome of a SUCCEEDED job, for kinds that produce one.
+	Result            json.RawMessage `json:"result,omitempty"`
+	Error             string          `json:"error,omitempty"`
+	CreatedAt         time.Time       `json:"createdAt"`
+	StartedAt         *time.Time      `json:"startedAt,omitempty"`
+	CancelRequestedAt *time.Time      `json:"cancelRequestedAt,omitempty"`
+	CompletedAt       *time.Time      `json:"completedAt,omitempty"`
+}
+
+// JobProgress reports how many items of its work a job has handled.
+type JobProgress struct {
+	Processed int      `json:"processed"`
+	Succeeded int      `json:"succeeded"`
+	Failed    int      `json:"failed"`
+	FailedIDs []string `json:"failedIds,omitempty"`
+}
@@ -0,0 +1,791 @@
+// Package fakes provides an in-memory fake of the fees API for integration tests of
+// code that calls feeMS, without standing up Temporal or Postgres.
+//
+// A Server speaks the same HTTP API as the fees service for the endpoints the client
+// package covers, so code under test talks to it through client.Client exactly as it
+// would to the real service:
+//
+//	fake := fakes.NewServer()
+//	defer fake.Close()
+//	c := client.New(fake.URL)
+//
+// The fake mirrors the service's validation, error codes and reasons, idempotency keys,
+// client references, line item types and reason codes, bill versions and If-Match
+// checks, close grace periods, and X-Tenant-ID scoping. Workflows are not simulated: every change is applied before
+// its request returns, a bill with a grace period closes once the fake's clock passes
+// its finalizesAt, and a closed bill becomes OVERDUE once it passes its dueDate. Approvals, payments, refunds, and late item routing are not
+// implemented; a line item sent to a CLOSED bill fails with reason "bill_closed".
+package fakes
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"encore.app/apierr"
+	"encore.app/client"
+	"encore.dev/beta/errs"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultTenantID owns bills created without an X-Tenant-ID header, as in the service.
+	defaultTenantID = "default"
+	// maxClientReferenceLength matches the service's limit on client references.
+	maxClientReferenceLength = 255
+	// maxReasonCodeLength and maxExternalReferenceLength match the service's limits on
+	// the reason codes and external references of credits and adjustments.
+	maxReasonCodeLength        = 64
+	maxExternalReferenceLength = 200
+)
+
+// defaultReasonCodes are the reason codes the service accepts unless
+// FEES_LINE_ITEM_REASON_CODES lists others.
+var defaultReasonCodes = []string{"goodwill", "service_credit", "promotion", "overbilled", "underbilled", "billing_error", "other"}
+
+// Server is an in-memory fake of the fees API served over HTTP. It is safe for
+// concurrent use.
+type Server struct {
+	*httptest.Server
+
+	now         func() time.Time
+	reasonCodes []string
+
+	mu           sync.Mutex
+	bills        map[string]*client.Bill
+	order        []string // bill IDs in creation order
+	gracePeriods map[string]time.Duration
+	responses    map[string]recordedResponse // by method, path and idempotency key
+	sequences    map[string]int64            // last bill number, by tenant, customer and year
+}
+
+// recordedResponse is a response replayed to a request that repeats an idempotency key.
+type recordedResponse struct {
+	status int
+	body   []byte
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithClock sets the clock the fake reads the time from, so tests can step bills
+// through their close grace periods. The default is time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(s *Server) { s.now = now }
+}
+
+// WithReasonCodes sets the reason codes credits and adjustments may give, like the
+// service's FEES_LINE_ITEM_REASON_CODES. The default is the service's default list.
+func WithReasonCodes(codes ...string) Option {
+	return func(s *Server) { s.reasonCodes = codes }
+}
+
+// NewServer starts a fake fees API. Close it when the test is done.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		now:          time.Now,
+		reasonCodes:  defaultReasonCodes,
+		bills:        make(map[string]*client.Bill),
+		gracePeriods: make(map[string]time.Duration),
+		responses:    make(map[string]recordedResponse),
+		sequences:    make(map[string]int64),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.Server = httptest.NewServer(s.Handler())
+	return s
+}
+
+// Handler returns the fake's HTTP handler, for serving it other than through
+// NewServer's httptest server.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /bills", s.idempotent(s.createBill))
+	mux.HandleFunc("POST /bills/{billID}/items", s.idempotent(s.addLineItem))
+	mux.HandleFunc("POST /bills/{billID}/close", s.closeBill)
+	mux.HandleFunc("GET /bills/{billID}", s.getBill)
+	mux.HandleFunc("GET /bills", s.listBills)
+	return mux
+}
+
+// Bill returns a copy of a bill as the fake holds it, for assertions.
+func (s *Server) Bill(billID string) (client.Bill, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bill, ok := s.bills[billID]
+	if !ok {
+		return client.Bill{}, false
+	}
+	s.advance(bill)
+	return copyBill(bill), true
+}
+
+// Reset forgets every bill and recorded idempotency key.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bills = make(map[string]*client.Bill)
+	s.order = nil
+	s.gracePeriods = make(map[string]time.Duration)
+	s.responses = make(map[string]recordedResponse)
+	s.sequences = make(map[string]int64)
+}
+
+// ------ Endpoints ------
+
+func (s *Server) createBill(w http.ResponseWriter, r *http.Request) {
+	var req client.CreateBillRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, apierr.InvalidArgument(apierr.InvalidParameter, "invalid request body: %v", err))
+		return
+	}
+	if !validCurrency(req.Currency) {
+		writeError(w, apierr.InvalidArgument(apierr.InvalidCurrency, "invalid currency %q: must be a three-letter ISO 4217 code such as \"USD\"", req.Currency))
+		return
+	}
+	var grace time.Duration
+	if req.CloseGracePeriod != "" {
+		var err error
+		if grace, err = parseGracePeriod("closeGracePeriod", req.CloseGracePeriod); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	if req.TemplateID != "" {
+		writeError(w, apierr.NotFound(apierr.TemplateNotFound, "bill template %s not found", req.TemplateID))
+		return
+	}
+	now := s.now().UTC()
+	if req.DueDate != nil && !req.DueDate.After(now) {
+		writeError(w, apierr.InvalidArgument(apierr.InvalidParameter, "dueDate %s must be in the future", req.DueDate.Format(time.RFC3339)))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.ParentBillID != "" {
+		if err := s.checkParentBill(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	bill := &client.Bill{
+		ID:          uuid.NewString(),
+		TenantID:    tenantOrDefault(r.Header.Get(client.TenantIDHeader)),
+		CustomerID:  req.CustomerID,
+		Currency:    req.Currency,
+		Status:      client.BillStatusOpen,
+		LineItems:   []client.LineItem{},
+		CreatedAt:   &now,
+		DueDate:     clonePtr(req.DueDate),
+		AutoCollect: req.AutoCollect,
+		Version:     1,
+
+		ParentBillID: req.ParentBillID,
+	}
+	s.bills[bill.ID] = bill
+	s.order = append(s.order, bill.ID)
+	s.gracePeriods[bill.ID] = grace
+
+	writeJSON(w, http.StatusOK, client.CreateBillResponse{
+		BillID:          bill.ID,
+		WorkflowID:      "bill-" + bill.ID,
+		RunID:           uuid.NewString(),
+		InitialStatus:   bill.Status,
+		ConfirmationMsg: "Bill created successfully.",
+	})
+}
+
+func (s *Server) addLineItem(w http.ResponseWriter, r *http.Request) {
+	ifVersion, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var req client.AddLineItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, apierr.InvalidArgument(apierr.InvalidParameter, "invalid request body: %v", err))
+		return
+	}
+	if err := validateType(&req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.Quantity != 0 || req.UnitPrice != 0 {
+		if !(req.Quantity > 0 && signed(req.Type, req.UnitPrice)) || math.IsInf(req.Quantity, 0) || math.IsInf(req.UnitPrice, 0) {
+			writeError(w, apierr.InvalidArgument(apierr.InvalidAmount, "line item quantity must be a positive number and unitPrice %s, got %v and %v", sign(req.Type), req.Quantity, req.UnitPrice))
+			return
+		}
+		amount := roundAmount(req.Quantity * req.UnitPrice)
+		if req.Amount != 0 && roundAmount(req.Amount) != amount {
+			writeError(w, apierr.InvalidArgument(apierr.InvalidAmount, "line item amount %v does not match quantity %v at unit price %v, which comes to %v", req.Amount, req.Quantity, req.UnitPrice, amount))
+			return
+		}
+		req.Amount = amount
+	}
+	if math.IsNaN(req.Amount) || math.IsInf(req.Amount, 0) || !signed(req.Type, req.Amount) {
+		writeError(w, apierr.InvalidArgument(apierr.InvalidAmount, "line item amount must be %s, got %v", sign(req.Type), req.Amount))
+		return
+	}
+	if len(req.ClientReference) > maxClientReferenceLength {
+		writeError(w, apierr.InvalidArgument(apierr.InvalidParameter, "clientReference must be at most %d characters", maxClientReferenceLength))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bill, err := s.visibleBill(r, r.PathValue("billID"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	wait := r.URL.Query().Get("wait") == "true"
+
+	if req.ClientReference != "" {
+		for _, item := range bill.LineItems {
+			if item.ClientReference == req.ClientReference {
+				writeJSON(w, http.StatusOK, addLineItemResponse(bill, item.ID, true, wait))
+				return
+			}
+		}
+	}
+	if req.Currency != "" && !strings.EqualFold(req.Currency, bill.Currency) {
+		writeError(w, apierr.InvalidArgument(apierr.InvalidCurrency, "line item currency %s does not match bill %s's currency %s", req.Currency, bill.ID, bill.Currency))
+		return
+	}
+	if err := checkVersion(bill, ifVersion); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.checkReason(&req, bill); err != nil {
+		writeError(w, err)
+		return
+	}
+	if bill.Status != client.BillStatusOpen && bill.Status != client.BillStatusClosing {
+		writeError(w, apierr.FailedPrecondition(apierr.BillClosed, "bill %s is %s and cannot accept line items", bill.ID, bill.Status))
+		return
+	}
+	if total := roundAmount(bill.TotalAmount + req.Amount); req.Amount < 0 && total < 0 && !req.AllowNegativeTotal {
+		writeError(w, apierr.FailedPrecondition(apierr.NegativeTotal, "line item of %.2f %s would take bill %s to %.2f %s; set allowNegativeTotal to accept it",
+			req.Amount, bill.Currency, bill.ID, total, bill.Currency))
+		return
+	}
+
+	item := client.LineItem{
+		ID:              uuid.NewString(),
+		Description:     req.Description,
+		Amount:          req.Amount,
+		Type:            req.Type,
+		ReasonCode:      req.ReasonCode,
+		Reference:       clonePtr(req.Reference),
+		Quantity:        req.Quantity,
+		UnitPrice:       req.UnitPrice,
+		Unit:            req.Unit,
+		Late:            bill.Status == client.BillStatusClosing,
+		ClientReference: req.ClientReference,
+	}
+	bill.LineItems = append(bill.LineItems, item)
+	bill.TotalAmount = roundAmount(bill.TotalAmount + item.Amount)
+	bill.Version++
+
+	writeJSON(w, http.StatusOK, addLineItemResponse(bill, item.ID, false, wait))
+}
+
+func (s *Server) closeBill(w http.ResponseWriter, r *http.Request) {
+	ifVersion, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var grace *time.Duration
+	if v := r.URL.Query().Get("gracePeriod"); v != "" {
+		d, err := parseGracePeriod("gracePeriod", v)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		grace = &d
+	}
+	var dueDate *time.Time
+	if v := r.URL.Query().Get("dueDate"); v != "" {
+		d, err := time.Parse(time.RFC3339, v)
+		if err != nil || !d.After(s.now()) {
+			writeError(w, apierr.InvalidArgument(apierr.InvalidParameter, "invalid dueDate %q: must be a future RFC 3339 time such as \"2025-07-01T00:00:00Z\"", v))
+			return
+		}
+		dueDate = &d
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bill, err := s.visibleBill(r, r.PathValue("billID"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := checkVersion(bill, ifVersion); err != nil {
+		writeError(w, err)
+		return
+	}
+	if bill.Status != client.BillStatusOpen && bill.Status != client.BillStatusClosing {
+		writeJSON(w, http.StatusOK, client.CloseBillResponse{Bill: copyBill(bill), ConfirmationMsg: "Bill is already closed."})
+		return
+	}
+
+	if dueDate != nil && bill.Status == client.BillStatusOpen {
+		bill.DueDate = dueDate
+	}
+	now := s.now().UTC()
+	if grace == nil {
+		g := s.gracePeriods[bill.ID]
+		grace = &g
+	}
+	if bill.Status == client.BillStatusOpen && *grace > 0 {
+		finalizesAt := now.Add(*grace)
+		bill.Status = client.BillStatusClosing
+		bill.CloseRequestedAt = &now
+		bill.FinalizesAt = &finalizesAt
+		bill.Version++
+		writeJSON(w, http.StatusOK, client.CloseBillResponse{
+			Bill:            copyBill(bill),
+			ConfirmationMsg: fmt.Sprintf("Close requested; bill finalizes at %s.", finalizesAt.Format(time.RFC3339)),
+		})
+		return
+	}
+
+	if bill.CloseRequestedAt == nil {
+		bill.CloseRequestedAt = &now
+	}
+	s.finalize(bill, now)
+	writeJSON(w, http.StatusOK, client.CloseBillResponse{Bill: copyBill(bill), ConfirmationMsg: "Bill closed successfully and details retrieved."})
+}
+
+func (s *Server) getBill(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bill, err := s.visibleBill(r, r.PathValue("billID"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// Changes are applied before each request returns, so a state token is reached at
+	// once or never.
+	if token := r.URL.Query().Get("minStateToken"); token != "" {
+		version, err := strconv.ParseInt(strings.TrimPrefix(token, "v"), 10, 64)
+		if err != nil || version <= 0 || !strings.HasPrefix(token, "v") {
+			writeError(w, apierr.InvalidArgument(apierr.InvalidParameter, "invalid minStateToken %q: must be a stateToken returned by a change to the bill", token))
+			return
+		}
+		if version > bill.Version {
+			writeError(w, apierr.Unavailable(apierr.StateTokenTimeout, "bill %s is at version %d, not %d", bill.ID, bill.Version, version))
+			return
+		}
+	}
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatInt(bill.Version, 10)))
+	writeJSON(w, http.StatusOK, struct {
+		Bill client.Bill `json:"bill"`
+	}{copyBill(bill)})
+}
+
+func (s *Server) listBills(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	status := client.BillStatus(q.Get("status"))
+	if status != "" && !validStatus(status) {
+		writeError(w, apierr.InvalidArgument(apierr.InvalidParameter, "invalid status parameter: '%s'. Must be 'OPEN', 'CLOSING', 'PENDING_APPROVAL', 'CLOSE_FAILED', 'CLOSED', 'PARTIALLY_PAID', 'PAID', 'PAYMENT_FAILED', 'OVERDUE', 'DELINQUENT', or empty", status))
+		return
+	}
+	limit, err := nonNegativeInt(q, "limit")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	offset, err := nonNegativeInt(q, "offset")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	tenantID := q.Get("tenantId")
+	if scope := r.Header.Get(client.TenantIDHeader); scope != "" {
+		if tenantID != "" && tenantID != scope {
+			writeError(w, apierr.PermissionDenied(apierr.InsufficientScope, "API key may not list bills of tenant %s", tenantID))
+			return
+		}
+		tenantID = scope
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bills := []client.Bill{}
+	for _, id := range s.order {
+		bill := s.bills[id]
+		s.advance(bill)
+		if status != "" && bill.Status != status {
+			continue
+		}
+		if currency := q.Get("currency"); currency != "" && bill.Currency != currency {
+			continue
+		}
+		if customerID := q.Get("customerId"); customerID != "" && bill.CustomerID != customerID {
+			continue
+		}
+		if tenantID != "" && bill.TenantID != tenantID {
+			continue
+		}
+		bills = append(bills, copyBill(bill))
+	}
+
+	total := len(bills)
+	bills = bills[min(offset, total):]
+	if limit > 0 {
+		bills = bills[:min(limit, len(bills))]
+	}
+	writeJSON(w, http.StatusOK, client.ListBillsResponse{Bills: bills, TotalCount: total, Limit: limit, Offset: offset})
+}
+
+// ------ State ------
+
+// visibleBill returns the bill addressed by the request, advanced to the fake's clock.
+// Bills of other tenants are reported as not found, as in the service. s.mu must be held.
+func (s *Server) visibleBill(r *http.Request, billID string) (*client.Bill, error) {
+	bill, ok := s.bills[billID]
+	if ok {
+		scope := r.Header.Get(client.TenantIDHeader)
+		ok = scope == "" || scope == bill.TenantID
+	}
+	if !ok {
+		return nil, apierr.NotFound(apierr.BillNotFound, "bill %s not found", billID)
+	}
+	s.advance(bill)
+	return bill, nil
+}
+
+// advance makes the changes the service's workflow would have made to the bill by
+// now: it closes a CLOSING bill whose grace period has passed, and marks a closed bill
+// past its due date OVERDUE. s.mu must be held.
+func (s *Server) advance(bill *client.Bill) {
+	now := s.now().UTC()
+	if bill.Status == client.BillStatusClosing && bill.FinalizesAt != nil && !now.Before(*bill.FinalizesAt) {
+		s.finalize(bill, *bill.FinalizesAt)
+	}
+	if bill.Status == client.BillStatusClosed && bill.DueDate != nil && !now.Before(*bill.DueDate) {
+		bill.Status = client.BillStatusOverdue
+		bill.Version++
+	}
+}
+
+// finalize marks the bill CLOSED at closedAt, numbers it, and adds a sub-bill's total
+// to its parent. The fake has no follow-up bills, so a parent that closed first gets
+// nothing. s.mu must be held.
+func (s *Server) finalize(bill *client.Bill, closedAt time.Time) {
+	bill.Status = client.BillStatusClosed
+	bill.ClosedAt = &closedAt
+	bill.FinalizesAt = nil
+	bill.Version++
+	if bill.CustomerID != "" {
+		year := closedAt.UTC().Year()
+		key := fmt.Sprintf("%s/%s/%d", bill.TenantID, bill.CustomerID, year)
+		s.sequences[key]++
+		bill.Number = fmt.Sprintf("%s-%04d-%05d", bill.CustomerID, year, s.sequences[key])
+	}
+
+	parent, ok := s.bills[bill.ParentBillID]
+	if !ok || bill.TotalAmount == 0 {
+		return
+	}
+	s.advance(parent)
+	if parent.Status != client.BillStatusOpen && parent.Status != client.BillStatusClosing {
+		return
+	}
+	parent.LineItems = append(parent.LineItems, client.LineItem{
+		ID:          uuid.NewSHA1(uuid.NameSpaceOID, []byte("feems/sub-bill/"+bill.ID)).String(),
+		Description: "Sub-bill " + bill.ID,
+		Amount:      bill.TotalAmount,
+		SubBillID:   bill.ID,
+		Late:        parent.Status == client.BillStatusClosing,
+	})
+	parent.TotalAmount = roundAmount(parent.TotalAmount + bill.TotalAmount)
+	parent.Version++
+}
+
+// checkParentBill checks that a bill created with req can be a sub-bill of
+// req.ParentBillID, as the service does. s.mu must be held.
+func (s *Server) checkParentBill(r *http.Request, req *client.CreateBillRequest) error {
+	if req.AutoCollect || req.DueDate != nil {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "sub-bills are paid through their parent bill and cannot set autoCollect or dueDate")
+	}
+	parent, err := s.visibleBill(r, req.ParentBillID)
+	if err != nil {
+		return apierr.NotFound(apierr.BillNotFound, "parent bill %s not found", req.ParentBillID)
+	}
+	if parent.TenantID != tenantOrDefault(r.Header.Get(client.TenantIDHeader)) {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "parent bill %s belongs to another tenant", req.ParentBillID)
+	}
+	if parent.Currency != req.Currency {
+		return apierr.InvalidArgument(apierr.InvalidCurrency, "parent bill %s is in %s, not %s", req.ParentBillID, parent.Currency, req.Currency)
+	}
+	if parent.Status != client.BillStatusOpen && parent.Status != client.BillStatusClosing {
+		return apierr.FailedPrecondition(apierr.BillClosed, "parent bill %s is already closed", req.ParentBillID)
+	}
+	return nil
+}
+
+// idempotent replays the recorded response to a request that repeats the idempotency
+// key of an earlier successful one, so client retries are not applied twice.
+func (s *Server) idempotent(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(client.IdempotencyKeyHeader)
+		if key == "" {
+			h(w, r)
+			return
+		}
+		key = r.Method + " " + r.URL.Path + " " + r.Header.Get(client.TenantIDHeader) + " " + key
+
+		s.mu.Lock()
+		recorded, ok := s.responses[key]
+		s.mu.Unlock()
+		if ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(recorded.status)
+			w.Write(recorded.body)
+			return
+		}
+
+		rec := httptest.NewRecorder()
+		h(rec, r)
+		if rec.Code < 300 {
+			s.mu.Lock()
+			s.responses[key] = recordedResponse{status: rec.Code, body: rec.Body.Bytes()}
+			s.mu.Unlock()
+		}
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	}
+}
+
+// ------ Helpers ------
+
+// validateType checks the request's type and reason code as the service does,
+// defaulting the type to CHARGE.
+func validateType(req *client.AddLineItemRequest) error {
+	if req.Type == "" {
+		req.Type = client.LineItemCharge
+	}
+	if req.Type != client.LineItemCharge && req.Type != client.LineItemCredit && req.Type != client.LineItemAdjustment {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "invalid type %q: must be CHARGE, CREDIT or ADJUSTMENT", req.Type)
+	}
+	if len(req.ReasonCode) > maxReasonCodeLength {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "reasonCode must be at most %d characters", maxReasonCodeLength)
+	}
+	if req.Type == client.LineItemCharge {
+		if req.ReasonCode != "" {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "reasonCode is only accepted on CREDIT and ADJUSTMENT items")
+		}
+		if req.AllowNegativeTotal {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "allowNegativeTotal is only accepted on CREDIT and ADJUSTMENT items")
+		}
+	} else if req.ReasonCode == "" {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "%s items require a reasonCode", req.Type)
+	}
+	return nil
+}
+
+// checkReason checks the request's reason code against the fake's reason codes, and
+// that its reference names an item of bill.
+func (s *Server) checkReason(req *client.AddLineItemRequest, bill *client.Bill) error {
+	if req.ReasonCode != "" && !slices.Contains(s.reasonCodes, req.ReasonCode) {
+		return apierr.InvalidArgument(apierr.UnknownReasonCode, "unknown reasonCode %q: must be one of %s", req.ReasonCode, strings.Join(s.reasonCodes, ", "))
+	}
+	ref := req.Reference
+	if ref == nil {
+		return nil
+	}
+	if req.Type == client.LineItemCharge {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "reference is only accepted on CREDIT and ADJUSTMENT items")
+	}
+	if ref.LineItemID == "" && ref.External == "" {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "reference must give a lineItemId or an external reference")
+	}
+	if len(ref.External) > maxExternalReferenceLength {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "reference.external must be at most %d characters", maxExternalReferenceLength)
+	}
+	if ref.LineItemID != "" && !slices.ContainsFunc(bill.LineItems, func(item client.LineItem) bool { return item.ID == ref.LineItemID }) {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "reference.lineItemId %q is not a line item of bill %s", ref.LineItemID, bill.ID)
+	}
+	return nil
+}
+
+// signed reports whether v has the sign of the type's amounts: positive for charges,
+// negative for credits, and either for adjustments.
+func signed(t client.LineItemType, v float64) bool {
+	switch t {
+	case client.LineItemCredit:
+		return v < 0
+	case client.LineItemAdjustment:
+		return v != 0
+	}
+	return v > 0
+}
+
+// sign describes the amounts signed accepts, for error messages.
+func sign(t client.LineItemType) string {
+	switch t {
+	case client.LineItemCredit:
+		return "a negative number for CREDIT items"
+	case client.LineItemAdjustment:
+		return "a non-zero number for ADJUSTMENT items"
+	}
+	return "a positive number"
+}
+
+func addLineItemResponse(bill *client.Bill, itemID string, duplicate, wait bool) client.AddLineItemResponse {
+	resp := client.AddLineItemResponse{
+		LineItemID:      itemID,
+		BillID:          bill.ID,
+		Duplicate:       duplicate,
+		StateToken:      "v" + strconv.FormatInt(bill.Version, 10),
+		ConfirmationMsg: "LineItem added successfully.",
+	}
+	if duplicate {
+		resp.ConfirmationMsg = fmt.Sprintf("Bill already holds line item %s.", itemID)
+	}
+	if wait {
+		total := bill.TotalAmount
+		resp.TotalAmount = &total
+		resp.ItemCount = len(bill.LineItems)
+		resp.Status = bill.Status
+	}
+	return resp
+}
+
+// copyBill returns a copy of bill that shares no slices or pointers with it.
+func copyBill(bill *client.Bill) client.Bill {
+	c := *bill
+	c.LineItems = slices.Clone(bill.LineItems)
+	if c.LineItems == nil {
+		c.LineItems = []client.LineItem{}
+	}
+	c.CreatedAt = clonePtr(bill.CreatedAt)
+	c.ClosedAt = clonePtr(bill.ClosedAt)
+	c.CloseRequestedAt = clonePtr(bill.CloseRequestedAt)
+	c.FinalizesAt = clonePtr(bill.FinalizesAt)
+	c.DueDate = clonePtr(bill.DueDate)
+	return c
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func checkVersion(bill *client.Bill, version int64) error {
+	if version == 0 || bill.Version == version {
+		return nil
+	}
+	return apierr.FailedPrecondition(apierr.VersionMismatch, "bill %s is at version %d, not %d; re-read it and retry", bill.ID, bill.Version, version)
+}
+
+// parseIfMatch parses an If-Match header as the service does: a bare version, a quoted
+// entity tag, or empty or "*" for any version.
+func parseIfMatch(header string) (int64, error) {
+	value := strings.TrimPrefix(strings.TrimSpace(header), "W/")
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, apierr.InvalidArgument(apierr.InvalidParameter, "invalid If-Match %q: must be a bill version such as \"3\"", header)
+	}
+	return version, nil
+}
+
+func parseGracePeriod(name, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, apierr.InvalidArgument(apierr.InvalidParameter, "invalid %s %q: must be a non-negative duration such as \"5m\"", name, value)
+	}
+	return d, nil
+}
+
+func nonNegativeInt(q map[string][]string, name string) (int, error) {
+	values := q[name]
+	if len(values) == 0 || values[0] == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(values[0])
+	if err != nil || n < 0 {
+		return 0, apierr.InvalidArgument(apierr.InvalidParameter, "invalid %s %q: must be a non-negative integer", name, values[0])
+	}
+	return n, nil
+}
+
+func validCurrency(c string) bool {
+	if len(c) != 3 {
+		return false
+	}
+	for _, r := range c {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func validStatus(status client.BillStatus) bool {
+	switch status {
+	case client.BillStatusOpen, client.BillStatusClosing, client.BillStatusPendingApproval, client.BillStatusCloseFailed,
+		client.BillStatusClosed, client.BillStatusPartiallyPaid, client.BillStatusPaid, client.BillStatusPaymentFailed, client.BillStatusOverdue, client.BillStatusDelinquent:
+		return true
+	}
+	return false
+}
+
+func tenantOrDefault(tenantID string) string {
+	if tenantID == "" {
+		return defaultTenantID
+	}
+	return tenantID
+}
+
+func roundAmount(v float64) float64 {
+	return math.Round(v*1e4) / 1e4
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err in the Encore error format the service responds with.
+func writeError(w http.ResponseWriter, err error) {
+	e, ok := err.(*errs.Error)
+	if !ok {
+		e = &errs.Error{Code: errs.Internal, Message: err.Error()}
+	}
+	writeJSON(w, e.Code.HTTPStatus(), e)
+}
@@ -0,0 +1,317 @@
+package fakes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"encore.app/client"
+	"github.com/stretchr/testify/require"
+)
+
+// reasonOf returns the reason of a fees API error.
+func reasonOf(t *testing.T, err error) string {
+	t.Helper()
+	var apiErr *client.APIError
+	require.True(t, errors.As(err, &apiErr), "expected an APIError, got %v", err)
+	return apiErr.Reason
+}
+
+// TestServer_BillLifecycle tests creating a bill, adding items, and closing it through the client.
+func TestServer_BillLifecycle(t *testing.T) {
+	fake := NewServer()
+	defer fake.Close()
+	c := client.New(fake.URL)
+	ctx := context.Background()
+
+	created, err := c.CreateBill(ctx, &client.CreateBillRequest{CustomerID: "cust-1", Currency: "USD"})
+	require.NoError(t, err)
+	require.Equal(t, client.BillStatusOpen, created.InitialStatus)
+
+	added, err := c.AddLineItem(ctx, created.BillID, &client.AddLineItemRequest{Description: "API calls", Amount: 12.5, ClientReference: "usage-1", Wait: true})
+	require.NoError(t, err)
+	require.NotNil(t, added.TotalAmount)
+	require.Equal(t, 12.5, *added.TotalAmount)
+	require.Equal(t, 1, added.ItemCount)
+
+	dup, err := c.AddLineItem(ctx, created.BillID, &client.AddLineItemRequest{Description: "API calls", Amount: 12.5, ClientReference: "usage-1"})
+	require.NoError(t, err)
+	require.True(t, dup.Duplicate)
+	require.Equal(t, added.LineItemID, dup.LineItemID)
+
+	bill, err := c.GetBill(ctx, created.BillID)
+	require.NoError(t, err)
+	require.Len(t, bill.LineItems, 1)
+	require.Equal(t, int64(2), bill.Version)
+
+	closed, err := c.CloseBill(ctx, created.BillID, &client.CloseBillParams{IfVersion: bill.Version})
+	require.NoError(t, err)
+	require.Equal(t, client.BillStatusClosed, closed.Status)
+	require.Equal(t, 12.5, closed.TotalAmount)
+	require.NotNil(t, closed.ClosedAt)
+	require.Equal(t, fmt.Sprintf("cust-1-%d-00001", closed.ClosedAt.UTC().Year()), closed.Number)
+
+	_, err = c.AddLineItem(ctx, created.BillID, &client.AddLineItemRequest{Description: "late", Amount: 1})
+	require.Equal(t, "bill_closed", reasonOf(t, err))
+}
+
+// TestServer_Errors tests that the fake reports the service's error reasons.
+func TestServer_Errors(t *testing.T) {
+	fake := NewServer()
+	defer fake.Close()
+	c := client.New(fake.URL)
+	ctx := context.Background()
+
+	_, err := c.CreateBill(ctx, &client.CreateBillRequest{Currency: "usd"})
+	require.Equal(t, "invalid_currency", reasonOf(t, err))
+
+	_, err = c.GetBill(ctx, "missing")
+	require.Equal(t, "bill_not_found", reasonOf(t, err))
+	var apiErr *client.APIError
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	require.Equal(t, "not_found", apiErr.Code)
+
+	created, err := c.CreateBill(ctx, &client.CreateBillRequest{Currency: "EUR"})
+	require.NoError(t, err)
+
+	_, err = c.AddLineItem(ctx, created.BillID, &client.AddLineItemRequest{Description: "free", Amount: 0})
+	require.Equal(t, "invalid_amount", reasonOf(t, err))
+
+	_, err = c.AddLineItem(ctx, created.BillID, &client.AddLineItemRequest{Description: "mismatch", Amount: 5, Quantity: 2, UnitPrice: 2})
+	require.Equal(t, "invalid_amount", reasonOf(t, err))
+
+	_, err = c.AddLineItem(ctx, created.BillID, &client.AddLineItemRequest{Description: "dollars", Amount: 1, Currency: "USD"})
+	require.Equal(t, "invalid_currency", reasonOf(t, err))
+
+	priced, err := c.AddLineItem(ctx, created.BillID, &client.AddLineItemRequest{Description: "Storage", Quantity: 20, UnitPrice: 0.1, Unit: "GB", Currency: "eur", Wait: true})
+	require.NoError(t, err)
+	require.Equal(t, 2.0, *priced.TotalAmount)
+
+	_, err = c.AddLineItem(ctx, created.BillID, &client.AddLineItemRequest{Description: "stale", Amount: 1, IfVersion: 7})
+	require.Equal(t, "version_mismatch", reasonOf(t, err))
+
+	_, err = c.ListBills(ctx, &client.ListBillsParams{Status: "SETTLED"})
+	require.Equal(t, "invalid_parameter", reasonOf(t, err))
+}
+
+// TestServer_LineItemTypes tests that the fake applies the service's rules for credits
+// and adjustments: signed amounts, known reason codes, and no negative totals unless
+// allowed.
+func TestServer_LineItemTypes(t *testing.T) {
+	fake := NewServer()
+	defer fake.Close()
+	c := client.New(fake.URL)
+	ctx := context.Background()
+
+	created, err := c.CreateBill(ctx, &client.CreateBillRequest{Currency: "USD"})
+	require.NoError(t, err)
+	charge, err := c.AddLineItem(ctx, created.BillID, &client.AddLineItemRequest{Description: "Usage", Amount: 10})
+	require.NoError(t, err)
+
+	for _, req := range []client.AddLineItemRequest{
+		{Description: "no reason", Amount: -2, Type: client.LineItemCredit},
+		{Description: "positive credit", Amount: 2, Type: client.LineItemCredit, ReasonCode: "goodwill"},
+		{Description: "charge with reason", Amount: 2, ReasonCode: "goodwill"},
+		{Description: "dangling", Amount: -2, Type: client.LineItemCredit, ReasonCode: "goodwill", Reference: &client.LineItemReference{LineItemID: "missing"}},
+	} {
+		_, err := c.AddLineItem(ctx, created.BillID, &req)
+		require.Contains(t, []string{"invalid_parameter", "invalid_amount"}, reasonOf(t, err), req.Description)
+	}
+	_, err = c.AddLineItem(ctx, created.BillID, &client.AddLineItemRequest{Description: "Credit", Amount: -2, Type: client.LineItemCredit, ReasonCode: "refund"})
+	require.Equal(t, "unknown_reason_code", reasonOf(t, err))
+
+	credited, err := c.AddLineItem(ctx, created.BillID, &client.AddLineItemRequest{
+		Description: "Outage credit", Amount: -4, Type: client.LineItemCredit, ReasonCode: "service_credit",
+		Reference: &client.LineItemReference{LineItemID: charge.LineItemID}, Wait: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 6.0, *credited.TotalAmount)
+
+	_, err = c.AddLineItem(ctx, created.BillID, &client.AddLineItemRequest{Description: "Too much", Amount: -7, Type: client.LineItemAdjustment, ReasonCode: "overbilled"})
+	require.Equal(t, "negative_total", reasonOf(t, err))
+	below, err := c.AddLineItem(ctx, created.BillID, &client.AddLineItemRequest{Description: "Too much", Amount: -7, Type: client.LineItemAdjustment, ReasonCode: "overbilled", AllowNegativeTotal: true, Wait: true})
+	require.NoError(t, err)
+	require.Equal(t, -1.0, *below.TotalAmount)
+
+	bill, err := c.GetBill(ctx, created.BillID)
+	require.NoError(t, err)
+	require.Equal(t, client.LineItemCharge, bill.LineItems[0].Type)
+	require.Equal(t, client.LineItemCredit, bill.LineItems[1].Type)
+	require.Equal(t, charge.LineItemID, bill.LineItems[1].Reference.LineItemID)
+}
+
+// TestServer_IdempotencyKey tests that a repeated idempotency key replays the first response.
+func TestServer_IdempotencyKey(t *testing.T) {
+	fake := NewServer()
+	defer fake.Close()
+	c := client.New(fake.URL)
+	ctx := client.WithIdempotencyKey(context.Background(), "create-1")
+
+	first, err := c.CreateBill(ctx, &client.CreateBillRequest{Currency: "USD"})
+	require.NoError(t, err)
+	second, err := c.CreateBill(ctx, &client.CreateBillRequest{Currency: "USD"})
+	require.NoError(t, err)
+	require.Equal(t, first.BillID, second.BillID)
+
+	list, err := c.ListBills(context.Background(), nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, list.TotalCount)
+}
+
+// TestServer_GracePeriod tests that a bill closed with a grace period finalizes once the clock passes it.
+func TestServer_GracePeriod(t *testing.T) {
+	var mu sync.Mutex
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	fake := NewServer(WithClock(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}))
+	defer fake.Close()
+	c := client.New(fake.URL)
+	ctx := context.Background()
+
+	created, err := c.CreateBill(ctx, &client.CreateBillRequest{Currency: "USD"})
+	require.NoError(t, err)
+
+	closing, err := c.CloseBill(ctx, created.BillID, &client.CloseBillParams{GracePeriod: 5 * time.Minute})
+	require.NoError(t, err)
+	require.Equal(t, client.BillStatusClosing, closing.Status)
+	require.Equal(t, now.Add(5*time.Minute), *closing.FinalizesAt)
+
+	late, err := c.AddLineItem(ctx, created.BillID, &client.AddLineItemRequest{Description: "late", Amount: 3, Wait: true})
+	require.NoError(t, err)
+	require.Equal(t, client.BillStatusClosing, late.Status)
+
+	mu.Lock()
+	now = now.Add(5 * time.Minute)
+	mu.Unlock()
+
+	bill, err := c.GetBill(ctx, created.BillID)
+	require.NoError(t, err)
+	require.Equal(t, client.BillStatusClosed, bill.Status)
+	require.Equal(t, 3.0, bill.TotalAmount)
+	require.True(t, bill.LineItems[0].Late)
+}
+
+// TestServer_SubBills tests that a sub-bill's total is added to its parent when it closes.
+func TestServer_SubBills(t *testing.T) {
+	fake := NewServer()
+	defer fake.Close()
+	c := client.New(fake.URL)
+	ctx := context.Background()
+
+	parent, err := c.CreateBill(ctx, &client.CreateBillRequest{CustomerID: "org-1", Currency: "USD"})
+	require.NoError(t, err)
+	_, err = c.CreateBill(ctx, &client.CreateBillRequest{Currency: "EUR", ParentBillID: parent.BillID})
+	require.Equal(t, "invalid_currency", reasonOf(t, err))
+
+	child, err := c.CreateBill(ctx, &client.CreateBillRequest{CustomerID: "org-1", Currency: "USD", ParentBillID: parent.BillID})
+	require.NoError(t, err)
+	_, err = c.AddLineItem(ctx, child.BillID, &client.AddLineItemRequest{Description: "Seats", Amount: 40})
+	require.NoError(t, err)
+	_, err = c.CloseBill(ctx, child.BillID, nil)
+	require.NoError(t, err)
+
+	bill, err := c.GetBill(ctx, parent.BillID)
+	require.NoError(t, err)
+	require.Len(t, bill.LineItems, 1)
+	require.Equal(t, child.BillID, bill.LineItems[0].SubBillID)
+	require.Equal(t, 40.0, bill.TotalAmount)
+
+	_, err = c.CloseBill(ctx, parent.BillID, nil)
+	require.NoError(t, err)
+	_, err = c.CreateBill(ctx, &client.CreateBillRequest{Currency: "USD", ParentBillID: parent.BillID})
+	require.Equal(t, "bill_closed", reasonOf(t, err))
+}
+
+// TestServer_Tenants tests that bills are scoped to the X-Tenant-ID of their caller.
+func TestServer_Tenants(t *testing.T) {
+	fake := NewServer()
+	defer fake.Close()
+	acme := client.New(fake.URL, client.WithTenantID("acme"))
+	globex := client.New(fake.URL, client.WithTenantID("globex"))
+	ctx := context.Background()
+
+	created, err := acme.CreateBill(ctx, &client.CreateBillRequest{Currency: "USD"})
+	require.NoError(t, err)
+	_, err = globex.CreateBill(ctx, &client.CreateBillRequest{Currency: "GBP"})
+	require.NoError(t, err)
+
+	_, err = globex.GetBill(ctx, created.BillID)
+	require.Equal(t, "bill_not_found", reasonOf(t, err))
+
+	list, err := acme.ListBills(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, list.Bills, 1)
+	require.Equal(t, "acme", list.Bills[0].TenantID)
+
+	all, err := client.New(fake.URL).ListBills(ctx, &client.ListBillsParams{Currency: "GBP", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, all.Bills, 1)
+	require.Equal(t, "globex", all.Bills[0].TenantID)
+}
+
+// TestServer_Overdue tests that a closed bill becomes OVERDUE once the clock passes its due date.
+func TestServer_Overdue(t *testing.T) {
+	var mu sync.Mutex
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	fake := NewServer(WithClock(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}))
+	defer fake.Close()
+	c := client.New(fake.URL)
+	ctx := context.Background()
+
+	past := now.Add(-time.Hour)
+	_, err := c.CreateBill(ctx, &client.CreateBillRequest{Currency: "USD", DueDate: &past})
+	require.Equal(t, "invalid_parameter", reasonOf(t, err))
+
+	created, err := c.CreateBill(ctx, &client.CreateBillRequest{Currency: "USD"})
+	require.NoError(t, err)
+	dueDate := now.Add(24 * time.Hour)
+	closed, err := c.CloseBill(ctx, created.BillID, &client.CloseBillParams{DueDate: dueDate})
+	require.NoError(t, err)
+	require.Equal(t, client.BillStatusClosed, closed.Status)
+	require.Equal(t, dueDate, *closed.DueDate)
+
+	mu.Lock()
+	now = dueDate
+	mu.Unlock()
+
+	list, err := c.ListBills(ctx, &client.ListBillsParams{Status: client.BillStatusOverdue})
+	require.NoError(t, err)
+	require.Len(t, list.Bills, 1)
+	require.Equal(t, created.BillID, list.Bills[0].ID)
+}
+
+// TestServer_StateToken tests that a line item's state token reads the bill with the
+// item, and that tokens the bill has not reached fail like the service's timeout.
+func TestServer_StateToken(t *testing.T) {
+	fake := NewServer()
+	defer fake.Close()
+	c := client.New(fake.URL)
+	ctx := context.Background()
+
+	created, err := c.CreateBill(ctx, &client.CreateBillRequest{Currency: "USD"})
+	require.NoError(t, err)
+	added, err := c.AddLineItem(ctx, created.BillID, &client.AddLineItemRequest{Description: "API calls", Amount: 3})
+	require.NoError(t, err)
+	require.NotEmpty(t, added.StateToken)
+
+	bill, err := c.GetBillAtLeast(ctx, created.BillID, added.StateToken)
+	require.NoError(t, err)
+	require.Len(t, bill.LineItems, 1)
+
+	_, err = c.GetBillAtLeast(ctx, created.BillID, "v99")
+	require.Equal(t, "state_token_timeout", reasonOf(t, err))
+	_, err = c.GetBillAtLeast(ctx, created.BillID, "99")
+	require.Equal(t, "invalid_parameter", reasonOf(t, err))
+}
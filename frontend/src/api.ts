@@ -2,6 +2,12 @@ import axios from 'axios';
 
 const API_BASE_URL = 'http://localhost:4000'; // Assuming Encore runs on port 4000
 
+// The fees API requires an API key with the bills:read and bills:write scopes.
+const API_KEY = process.env.REACT_APP_FEES_API_KEY;
+if (API_KEY) {
+  axios.defaults.headers.common['Authorization'] = `Bearer ${API_KEY}`;
+}
+
 // Define interfaces based on your Go types.go
 // These might need adjustments based on the exact JSON structure.
 export interface Bill {
@@ -19,6 +25,7 @@ export interface LineItem {
   id: string;
   description: string;
   amount: number;
+  late?: boolean; // Added while the bill was CLOSING
 }
 
 export interface CreateBillRequest {
@@ -42,6 +49,10 @@ export interface AddLineItemRequest {
 export interface AddLineItemResponse {
   lineItemId: string;
   billId: string;
+  // Set when the item was added with wait=true.
+  totalAmount?: number;
+  itemCount?: number;
+  status?: string;
   confirmationMsg: string;
 }
 
@@ -54,7 +65,7 @@ export interface GetBillResponse {
 }
 
 export interface ListBillsParams {
-  status?: 'OPEN' | 'CLOSED' | ''; // Adjust as per your API
+  status?: 'OPEN' | 'CLOSING' | 'PENDING_APPROVAL' | 'CLOSED' | ''; // Adjust as per your API
   // Add other params like currency, limit, offset if needed
 }
 
@@ -140,8 +151,8 @@ export const listBills = async (params?: ListBillsParams): Promise<ListBillsResp
   return response.data;
 };
 
-export const addLineItem = async (billID: string, data: AddLineItemRequest): Promise<AddLineItemResponse> => {
-  const response = await axios.post<AddLineItemResponse>(`${API_BASE_URL}/bills/${billID}/items`, data);
+export const addLineItem = async (billID: string, data: AddLineItemRequest, wait = false): Promise<AddLineItemResponse> => {
+  const response = await axios.post<AddLineItemResponse>(`${API_BASE_URL}/bills/${billID}/items`, data, { params: wait ? { wait: true } : undefined });
   return response.data;
 };
 
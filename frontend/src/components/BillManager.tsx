@@ -95,7 +95,8 @@ const BillManager: React.FC = () => {
     setSuccessMessage(null);
     try {
       const request: AddLineItemRequest = { description: newItemDescription, amount: newItemAmount };
-      const response = await api.addLineItem(selectedBill.id, request);
+      // Wait for the item to be applied so the refresh below shows it.
+      const response = await api.addLineItem(selectedBill.id, request, true);
       setNewItemDescription('');
       setNewItemAmount(0);
       setSuccessMessage(response.confirmationMsg || 'Line item added successfully!');
@@ -155,6 +156,8 @@ const BillManager: React.FC = () => {
             <select id="filterStatus" value={listFilterStatus} onChange={(e) => setListFilterStatus(e.target.value as ListBillsParams['status'])}>
               <option value="">All</option>
               <option value="OPEN">Open</option>
+              <option value="CLOSING">Closing</option>
+              <option value="PENDING_APPROVAL">Pending approval</option>
               <option value="CLOSED">Closed</option>
             </select>
             <button onClick={() => fetchBills(listFilterStatus)} className="btn btn-secondary" disabled={isBillListLoading}>
@@ -239,7 +242,7 @@ const BillManager: React.FC = () => {
               <div className="empty-state line-items-empty"><p>No line items yet for this bill.</p></div>
             )}
 
-            {selectedBill.status === 'OPEN' && (
+            {(selectedBill.status === 'OPEN' || selectedBill.status === 'CLOSING') && (
               <div className="add-line-item-form-container">
                 <h5>Add New Line Item</h5>
                 <form onSubmit={handleAddLineItem} className="bill-form">
@@ -0,0 +1,262 @@
+// Package locale formats amounts and dates the way readers in a locale expect them,
+// for bills rendered for people rather than programs.
+//
+// Locales differ in the characters that separate decimals and group thousands, in
+// where the currency symbol goes, in how dates are ordered, and in which way text
+// runs: 1234.56 euros read "€1,234.56" in the United States, "1.234,56 €" in Germany,
+// and "١٬٢٣٤٫٥٦ €" in Saudi Arabia. Amounts are rounded to their currency's minor
+// units first.
+package locale
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"encore.app/rounding"
+)
+
+// Direction is the direction text runs in a locale.
+type Direction string
+
+const (
+	LeftToRight Direction = "ltr"
+	RightToLeft Direction = "rtl"
+)
+
+// Grouping decides where the digits of the integer part of a number are separated.
+type Grouping int
+
+const (
+	// Thousands groups digits by three: 1,234,567.
+	Thousands Grouping = iota
+	// Indian groups the last three digits, then by two: 12,34,567.
+	Indian
+)
+
+// Locale holds the conventions for formatting amounts and dates in a locale.
+type Locale struct {
+	// Tag is the locale's BCP 47 tag, such as "de-DE".
+	Tag       string
+	Direction Direction
+	// Decimal separates the fraction of a number, and Group the digit groups of its
+	// integer part.
+	Decimal  string
+	Group    string
+	Grouping Grouping
+	// Digits are the locale's digits zero to nine, when it does not use ASCII digits.
+	Digits []rune
+	// SymbolAfter puts the currency symbol after the number, and SymbolSpace
+	// separates them with a no-break space.
+	SymbolAfter bool
+	SymbolSpace bool
+	// Currency is the locale's own currency, whose symbol is shown without a country.
+	Currency string
+	// DateLayout is the time.Format layout of a date.
+	DateLayout string
+}
+
+const (
+	noBreakSpace       = "\u00a0"
+	narrowNoBreakSpace = "\u202f"
+	// rightToLeftMark keeps a minus sign to the right of the number in right-to-left
+	// text, where it belongs.
+	rightToLeftMark = "\u200f"
+)
+
+// locales are the supported locales. The first locale of each language is used for a
+// tag naming only the language, such as "de".
+var locales = []Locale{
+	{Tag: "en-US", Direction: LeftToRight, Decimal: ".", Group: ",", Currency: "USD", DateLayout: "01/02/2006"},
+	{Tag: "en-GB", Direction: LeftToRight, Decimal: ".", Group: ",", Currency: "GBP", DateLayout: "02/01/2006"},
+	{Tag: "en-IN", Direction: LeftToRight, Decimal: ".", Group: ",", Grouping: Indian, Currency: "INR", DateLayout: "02/01/2006"},
+	{Tag: "de-DE", Direction: LeftToRight, Decimal: ",", Group: ".", SymbolAfter: true, SymbolSpace: true, Currency: "EUR", DateLayout: "02.01.2006"},
+	{Tag: "de-CH", Direction: LeftToRight, Decimal: ".", Group: "’", SymbolSpace: true, Currency: "CHF", DateLayout: "02.01.2006"},
+	{Tag: "fr-FR", Direction: LeftToRight, Decimal: ",", Group: narrowNoBreakSpace, SymbolAfter: true, SymbolSpace: true, Currency: "EUR", DateLayout: "02/01/2006"},
+	{Tag: "es-ES", Direction: LeftToRight, Decimal: ",", Group: ".", SymbolAfter: true, SymbolSpace: true, Currency: "EUR", DateLayout: "02/01/2006"},
+	{Tag: "it-IT", Direction: LeftToRight, Decimal: ",", Group: ".", SymbolAfter: true, SymbolSpace: true, Currency: "EUR", DateLayout: "02/01/2006"},
+	{Tag: "nl-NL", Direction: LeftToRight, Decimal: ",", Group: ".", SymbolSpace: true, Currency: "EUR", DateLayout: "02-01-2006"},
+	{Tag: "pt-BR", Direction: LeftToRight, Decimal: ",", Group: ".", SymbolSpace: true, Currency: "BRL", DateLayout: "02/01/2006"},
+	{Tag: "ja-JP", Direction: LeftToRight, Decimal: ".", Group: ",", Currency: "JPY", DateLayout: "2006/01/02"},
+	{Tag: "zh-CN", Direction: LeftToRight, Decimal: ".", Group: ",", Currency: "CNY", DateLayout: "2006/01/02"},
+	{Tag: "ar-SA", Direction: RightToLeft, Decimal: "٫", Group: "٬", Digits: []rune("٠١٢٣٤٥٦٧٨٩"), SymbolAfter: true, SymbolSpace: true, Currency: "SAR", DateLayout: "02/01/2006"},
+	{Tag: "ar-AE", Direction: RightToLeft, Decimal: ".", Group: ",", SymbolAfter: true, SymbolSpace: true, Currency: "AED", DateLayout: "02/01/2006"},
+	{Tag: "he-IL", Direction: RightToLeft, Decimal: ".", Group: ",", SymbolAfter: true, SymbolSpace: true, Currency: "ILS", DateLayout: "02.01.2006"},
+}
+
+// Default is the locale used when none is asked for.
+var Default = locales[0]
+
+// symbols are the currency symbols. Where several currencies share a symbol, it is
+// shown with the currency's country outside that country: US$ rather than $ outside
+// the United States. Currencies not listed are shown by their ISO 4217 code.
+var symbols = map[string]string{
+	"USD": "$", "EUR": "€", "GBP": "£", "JPY": "¥", "CNY": "¥", "INR": "₹", "BRL": "R$",
+	"CHF": "CHF", "ILS": "₪", "SAR": "ر.س.", "AED": "د.إ.", "KRW": "₩",
+	"CAD": "$", "AUD": "$", "MXN": "$",
+}
+
+// foreignSymbols are the symbols of shared-symbol currencies outside their country.
+var foreignSymbols = map[string]string{
+	"USD": "US$", "CAD": "CA$", "AUD": "A$", "MXN": "MX$", "JPY": "JP¥", "CNY": "CN¥",
+}
+
+// ErrUnknownLocale is returned for a locale tag that is not supported.
+var ErrUnknownLocale = errors.New("locale: unknown locale")
+
+// Tags returns the tags of the supported locales.
+func Tags() []string {
+	tags := make([]string, len(locales))
+	for i, l := range locales {
+		tags[i] = l.Tag
+	}
+	return tags
+}
+
+// Parse returns the locale for a BCP 47 tag such as "de-DE", "de_de", or "de". A tag
+// naming only a language, or a region that is not supported, gets the language's
+// first locale. An empty tag is the Default locale.
+func Parse(tag string) (Locale, error) {
+	tag = strings.ReplaceAll(strings.TrimSpace(tag), "_", "-")
+	if tag == "" {
+		return Default, nil
+	}
+	for _, l := range locales {
+		if strings.EqualFold(l.Tag, tag) {
+			return l, nil
+		}
+	}
+	lang, _, _ := strings.Cut(tag, "-")
+	for _, l := range locales {
+		if strings.EqualFold(l.language(), lang) {
+			return l, nil
+		}
+	}
+	return Locale{}, fmt.Errorf("%w %q", ErrUnknownLocale, tag)
+}
+
+// language returns the language subtag of l's tag.
+func (l Locale) language() string {
+	lang, _, _ := strings.Cut(l.Tag, "-")
+	return lang
+}
+
+// Number formats v with decimals decimals, or with as many as it needs if decimals is
+// negative.
+func (l Locale) Number(v float64, decimals int) string {
+	s := l.number(math.Abs(v), decimals)
+	if v < 0 && strings.Trim(s, "0"+l.Decimal+l.Group) != "" {
+		return l.minus() + l.digits(s)
+	}
+	return l.digits(s)
+}
+
+// number formats v, which is not negative, with ASCII digits.
+func (l Locale) number(v float64, decimals int) string {
+	s := strconv.FormatFloat(v, 'f', decimals, 64)
+	intPart, frac, _ := strings.Cut(s, ".")
+	s = l.group(intPart)
+	if frac != "" {
+		s += l.Decimal + frac
+	}
+	return s
+}
+
+// group separates the digit groups of an integer part.
+func (l Locale) group(digits string) string {
+	size := 3
+	var groups []string
+	for len(digits) > size {
+		groups = append(groups, digits[len(digits)-size:])
+		digits = digits[:len(digits)-size]
+		if l.Grouping == Indian {
+			size = 2
+		}
+	}
+	groups = append(groups, digits)
+	slices.Reverse(groups)
+	return strings.Join(groups, l.Group)
+}
+
+// digits replaces the ASCII digits of s with the locale's.
+func (l Locale) digits(s string) string {
+	if len(l.Digits) != 10 {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return l.Digits[r-'0']
+		}
+		return r
+	}, s)
+}
+
+// minus is the locale's minus sign.
+func (l Locale) minus() string {
+	if l.Direction == RightToLeft {
+		return rightToLeftMark + "-"
+	}
+	return "-"
+}
+
+// Symbol returns the symbol of currency in l.
+func (l Locale) Symbol(currency string) string {
+	currency = strings.ToUpper(currency)
+	if foreign, ok := foreignSymbols[currency]; ok && currency != l.Currency {
+		return foreign
+	}
+	if symbol, ok := symbols[currency]; ok {
+		return symbol
+	}
+	return currency
+}
+
+// Amount formats an amount of currency with its symbol, rounded half up to the
+// currency's minor units, such as "1.234,56 €" in de-DE.
+func (l Locale) Amount(v float64, currency string) string {
+	v = rounding.ForCurrency(currency, rounding.HalfUp).Round(v)
+	return l.money(v, currency, rounding.Decimals(currency))
+}
+
+// Price formats a unit price of currency like Amount, but keeps the decimals of prices
+// finer than the currency's minor units, such as "$0.0008".
+func (l Locale) Price(v float64, currency string) string {
+	decimals := rounding.Decimals(currency)
+	if _, frac, ok := strings.Cut(strconv.FormatFloat(v, 'f', -1, 64), "."); ok && len(frac) > decimals {
+		decimals = len(frac)
+	}
+	return l.money(v, currency, decimals)
+}
+
+// money formats v with decimals decimals and the symbol of currency.
+func (l Locale) money(v float64, currency string, decimals int) string {
+	number := l.digits(l.number(math.Abs(v), decimals))
+	symbol := l.Symbol(currency)
+	sep := ""
+	// Codes such as CHF read as words, so they are always spaced from the number.
+	if l.SymbolSpace || !strings.ContainsFunc(symbol, isSymbolRune) {
+		sep = noBreakSpace
+	}
+	s := symbol + sep + number
+	if l.SymbolAfter {
+		s = number + sep + symbol
+	}
+	if v < 0 {
+		return l.minus() + s
+	}
+	return s
+}
+
+// isSymbolRune reports whether r is a currency sign rather than a letter.
+func isSymbolRune(r rune) bool {
+	return strings.ContainsRune("$€£¥₹₪₩", r)
+}
+
+// Date formats the date of t, in t's location, such as "31.01.2024" in de-DE.
+func (l Locale) Date(t time.Time) string {
+	return l.digits(t.Format(l.DateLayout))
+}
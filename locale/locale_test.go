@@ -0,0 +1,75 @@
+package locale
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mustParse(t *testing.T, tag string) Locale {
+	t.Helper()
+	l, err := Parse(tag)
+	require.NoError(t, err)
+	return l
+}
+
+func TestParse(t *testing.T) {
+	require.Equal(t, "en-US", mustParse(t, "").Tag)
+	require.Equal(t, "de-DE", mustParse(t, "de-DE").Tag)
+	require.Equal(t, "de-CH", mustParse(t, "de_ch").Tag)
+	require.Equal(t, "fr-FR", mustParse(t, "fr").Tag)
+	require.Equal(t, "fr-FR", mustParse(t, "fr-CA").Tag)
+	_, err := Parse("xx-YY")
+	require.True(t, errors.Is(err, ErrUnknownLocale))
+	require.Len(t, Tags(), len(locales))
+}
+
+func TestAmount(t *testing.T) {
+	for name, tc := range map[string]struct {
+		tag, currency string
+		v             float64
+		want          string
+	}{
+		"en-US":                       {"en-US", "USD", 1234567.891, "$1,234,567.89"},
+		"en-US negative":              {"en-US", "USD", -12.5, "-$12.50"},
+		"en-US foreign code":          {"en-US", "CHF", 12.5, "CHF\u00a012.50"},
+		"en-GB shared symbol":         {"en-GB", "USD", 12.5, "US$12.50"},
+		"en-IN groups lakhs":          {"en-IN", "INR", 1234567.5, "₹12,34,567.50"},
+		"de-DE":                       {"de-DE", "EUR", 1234.56, "1.234,56\u00a0€"},
+		"de-CH":                       {"de-CH", "CHF", 1234.5, "CHF\u00a01’234.50"},
+		"fr-FR":                       {"fr-FR", "EUR", 1234.56, "1\u202f234,56\u00a0€"},
+		"ja-JP has no minor units":    {"ja-JP", "JPY", 1234.5, "¥1,235"},
+		"BHD has three decimals":      {"en-US", "BHD", 1.2345, "BHD\u00a01.235"},
+		"ar-SA digits":                {"ar-SA", "SAR", 1234.56, "١٬٢٣٤٫٥٦\u00a0ر.س."},
+		"he-IL negative stays right":  {"he-IL", "ILS", -5, "\u200f-5.00\u00a0₪"},
+		"rounding to zero drops sign": {"en-US", "USD", -0.001, "$0.00"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.want, mustParse(t, tc.tag).Amount(tc.v, tc.currency))
+		})
+	}
+}
+
+func TestPrice(t *testing.T) {
+	require.Equal(t, "$0.0008", mustParse(t, "en-US").Price(0.0008, "USD"))
+	require.Equal(t, "$0.50", mustParse(t, "en-US").Price(0.5, "USD"))
+	require.Equal(t, "0,125\u00a0€", mustParse(t, "de-DE").Price(0.125, "EUR"))
+}
+
+func TestNumber(t *testing.T) {
+	require.Equal(t, "12.5", mustParse(t, "en-US").Number(12.5, -1))
+	require.Equal(t, "1.234,5", mustParse(t, "de-DE").Number(1234.5, -1))
+	require.Equal(t, "0,00", mustParse(t, "de-DE").Number(-0.001, 2))
+	require.Equal(t, "-1,000", mustParse(t, "en-US").Number(-1000, 0))
+}
+
+func TestDate(t *testing.T) {
+	d := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	require.Equal(t, "01/31/2024", mustParse(t, "en-US").Date(d))
+	require.Equal(t, "31.01.2024", mustParse(t, "de-DE").Date(d))
+	require.Equal(t, "2024/01/31", mustParse(t, "ja-JP").Date(d))
+	require.Equal(t, "٣١/٠١/٢٠٢٤", mustParse(t, "ar-SA").Date(d))
+	require.Equal(t, RightToLeft, mustParse(t, "ar").Direction)
+}
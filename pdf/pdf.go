@@ -0,0 +1,214 @@
+// Package pdf writes simple PDF documents of text and lines, such as a bill to attach
+// to an email.
+//
+// Documents use the Helvetica fonts every PDF reader has, so nothing is embedded and
+// files stay small. Those fonts only cover the Windows-1252 character set, which
+// holds the Latin scripts of Western Europe and the euro, pound, and yen signs; other
+// characters are drawn as "?". Encodable reports whether a text can be drawn as is.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Page sizes, in points: A4, 210 by 297 millimeters.
+const (
+	PageWidth  = 595.0
+	PageHeight = 842.0
+)
+
+// Font is a font text can be drawn in.
+type Font int
+
+const (
+	Regular Font = iota
+	Bold
+)
+
+// name is the PDF name of the font's resource on every page.
+func (f Font) name() string {
+	if f == Bold {
+		return "/F2"
+	}
+	return "/F1"
+}
+
+// Document is a PDF document being written.
+type Document struct {
+	title string
+	pages []*Page
+}
+
+// Page is a page of a Document. Coordinates are in points from the bottom left corner.
+type Page struct {
+	content bytes.Buffer
+}
+
+// New returns an empty document with a title.
+func New(title string) *Document {
+	return &Document{title: title}
+}
+
+// AddPage adds an A4 page to the end of the document and returns it.
+func (d *Document) AddPage() *Page {
+	p := &Page{}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Text draws s in font f at size points, with its baseline starting at x, y.
+func (p *Page) Text(x, y float64, f Font, size float64, s string) {
+	fmt.Fprintf(&p.content, "BT %s %s Tf %s %s Td (%s) Tj ET\n", f.name(), num(size), num(x), num(y), escape(encode(s)))
+}
+
+// TextRight draws s like Text, but ending at x, for right-aligned columns.
+func (p *Page) TextRight(x, y float64, f Font, size float64, s string) {
+	p.Text(x-Width(f, size, s), y, f, size, s)
+}
+
+// Line draws a line width points wide from x1, y1 to x2, y2.
+func (p *Page) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.content, "%s w %s %s m %s %s l S\n", num(width), num(x1), num(y1), num(x2), num(y2))
+}
+
+// Bytes returns the document as a PDF file. A document without pages gets an empty one.
+func (d *Document) Bytes() []byte {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// The comment of bytes above 127 tells readers the file holds binary data.
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	// Objects 1 to 5 are the catalog, page tree, fonts, and info; each page then takes
+	// two, itself and its content.
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title (%s) /Producer (feeMS) >>", escape(encode(d.title))))
+	for i, p := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			num(PageWidth), num(PageHeight), 7+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.Bytes()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes()
+}
+
+// num formats a coordinate or size.
+func num(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 32)
+}
+
+// escape escapes the delimiters of a PDF string.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`, "\r", `\r`, "\n", `\n`).Replace(s)
+}
+
+// winAnsi holds the Windows-1252 bytes of the characters outside Latin-1, which have the
+// same codes in both.
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86,
+	'‡': 0x87, 'ˆ': 0x88, '‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c,
+	'Ž': 0x8e, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95,
+	'–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9a, '›': 0x9b,
+	'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+	// Narrow no-break spaces, which group thousands in French, are drawn as no-break
+	// spaces.
+	'\u202f': 0xa0,
+}
+
+// encodeRune returns the Windows-1252 byte of r.
+func encodeRune(r rune) (byte, bool) {
+	if b, ok := winAnsi[r]; ok {
+		return b, true
+	}
+	if r >= 0x20 && r < 0x7f || r >= 0xa0 && r <= 0xff {
+		return byte(r), true
+	}
+	return 0, false
+}
+
+// encode converts s to Windows-1252, replacing characters it does not have with "?".
+func encode(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		c, ok := encodeRune(r)
+		if !ok {
+			c = '?'
+		}
+		b = append(b, c)
+	}
+	return string(b)
+}
+
+// Encodable reports whether every character of s can be drawn.
+func Encodable(s string) bool {
+	for _, r := range s {
+		if _, ok := encodeRune(r); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Width returns how wide s is drawn in font f at size points.
+func Width(f Font, size float64, s string) float64 {
+	widths := &helvetica
+	if f == Bold {
+		widths = &helveticaBold
+	}
+	units := 0
+	for _, c := range []byte(encode(s)) {
+		switch {
+		case c >= 0x20 && c < 0x7f:
+			units += int(widths[c-0x20])
+		case c == 0xa0:
+			units += int(widths[0])
+		default:
+			// Outside ASCII, most characters are about as wide as a digit.
+			units += int(widths['0'-0x20])
+		}
+	}
+	return float64(units) * size / 1000
+}
+
+// helvetica and helveticaBold are the widths of the ASCII characters from the space to
+// the tilde, in thousandths of the font size, from the fonts' metrics.
+var (
+	helvetica = [95]int16{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}
+	helveticaBold = [95]int16{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}
+)
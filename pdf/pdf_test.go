@@ -0,0 +1,50 @@
+package pdf
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBytes(t *testing.T) {
+	doc := New("Bill (1)")
+	page := doc.AddPage()
+	page.Text(50, 800, Bold, 18, "Bill CUST-2024-00001")
+	page.TextRight(545, 780, Regular, 10, "1.234,56\u00a0€")
+	page.Line(50, 770, 545, 770, 0.5)
+	doc.AddPage().Text(50, 800, Regular, 10, "Page 2")
+	b := doc.Bytes()
+
+	require.True(t, bytes.HasPrefix(b, []byte("%PDF-1.4\n")))
+	require.True(t, bytes.HasSuffix(b, []byte("%%EOF\n")))
+	require.Contains(t, string(b), "/Count 2")
+	require.Contains(t, string(b), "/Title (Bill \\(1\\))")
+	require.Contains(t, string(b), "(1.234,56\xa0\x80) Tj")
+
+	// The cross-reference table points at each object.
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(b)
+	require.NotNil(t, m)
+	xref, err := strconv.Atoi(string(m[1]))
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(b[xref:], []byte("xref\n0 10\n")))
+	for i, offset := range regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(b[xref:], -1) {
+		at, err := strconv.Atoi(string(offset[1]))
+		require.NoError(t, err)
+		require.True(t, bytes.HasPrefix(b[at:], []byte(strconv.Itoa(i+1)+" 0 obj\n")), "object %d", i+1)
+	}
+}
+
+func TestEncodable(t *testing.T) {
+	require.True(t, Encodable("Straße € £ ¥ 1\u202f234"))
+	require.False(t, Encodable("₹"))
+	require.Equal(t, "? 12", encode("₹ 12"))
+}
+
+func TestWidth(t *testing.T) {
+	require.InDelta(t, 5.56*3, Width(Regular, 10, "123"), 1e-9)
+	require.InDelta(t, 6.11, Width(Bold, 10, "b"), 1e-9)
+	require.Greater(t, Width(Bold, 10, "mmm"), Width(Regular, 10, "mmm"))
+}
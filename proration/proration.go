@@ -0,0 +1,104 @@
+// Package proration splits a recurring charge across part of a billing period, for plan
+// changes and cancellations that take effect mid-period.
+//
+// A change from one amount to another at some instant within the period [start, end)
+// generates a credit for the unused part of the old amount and a charge for the rest of
+// the period at the new one. A cancellation is a change to zero.
+package proration
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Method decides how the remaining part of a period is measured.
+type Method string
+
+const (
+	// ByDay counts calendar days in the time zone of the period start. The day of the
+	// change is billed at the new amount, and days are never split, so daylight saving
+	// shifts do not change the result. A period within one calendar day is split by second.
+	ByDay Method = "day"
+	// BySecond splits the period at the exact instant of the change.
+	BySecond Method = "second"
+)
+
+// IsValid reports whether m is a known method.
+func (m Method) IsValid() bool {
+	return m == ByDay || m == BySecond
+}
+
+// ErrInvalidPeriod is returned for a period that does not end after it starts.
+var ErrInvalidPeriod = errors.New("proration: period must end after it starts")
+
+// Remaining returns the fraction of the period [start, end) left at at, from 1 at or
+// before the start down to 0 at or after the end.
+func Remaining(m Method, start, end, at time.Time) (float64, error) {
+	if !m.IsValid() {
+		return 0, fmt.Errorf("proration: unknown method %q", m)
+	}
+	if !end.After(start) {
+		return 0, ErrInvalidPeriod
+	}
+	if !at.After(start) {
+		return 1, nil
+	}
+	if !at.Before(end) {
+		return 0, nil
+	}
+
+	if m == ByDay {
+		loc := start.Location()
+		if total := calendarDays(start, end.In(loc)); total > 0 {
+			return float64(calendarDays(at.In(loc), end.In(loc))) / float64(total), nil
+		}
+	}
+	return float64(end.Sub(at)) / float64(end.Sub(start)), nil
+}
+
+// calendarDays counts the date changes from from to to, ignoring the time of day.
+func calendarDays(from, to time.Time) int {
+	y1, m1, d1 := from.Date()
+	y2, m2, d2 := to.Date()
+	days := time.Date(y2, m2, d2, 0, 0, 0, 0, time.UTC).Sub(time.Date(y1, m1, d1, 0, 0, 0, 0, time.UTC)) / (24 * time.Hour)
+	return int(days)
+}
+
+// Change is a switch from one recurring amount to another during a billing period.
+type Change struct {
+	// From and To are the full-period amounts before and after the change.
+	From, To    float64
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+	// At is when the change takes effect.
+	At     time.Time
+	Method Method
+}
+
+// Result is the outcome of prorating a Change.
+type Result struct {
+	// Remaining is the fraction of the period left when the change took effect.
+	Remaining float64
+	// Credit refunds the unused part of the old amount; it is zero or negative.
+	Credit float64
+	// Charge bills the rest of the period at the new amount.
+	Charge float64
+}
+
+// Prorate returns the credit and charge for c. Amounts are not rounded.
+func Prorate(c Change) (Result, error) {
+	if math.IsNaN(c.From) || math.IsInf(c.From, 0) || c.From < 0 || math.IsNaN(c.To) || math.IsInf(c.To, 0) || c.To < 0 {
+		return Result{}, fmt.Errorf("proration: amounts must be non-negative numbers, got %v and %v", c.From, c.To)
+	}
+	remaining, err := Remaining(c.Method, c.PeriodStart, c.PeriodEnd, c.At)
+	if err != nil {
+		return Result{}, err
+	}
+	credit := -c.From * remaining
+	if credit == 0 {
+		credit = 0 // not -0, which would encode as "-0"
+	}
+	return Result{Remaining: remaining, Credit: credit, Charge: c.To * remaining}, nil
+}
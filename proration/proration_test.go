@@ -0,0 +1,103 @@
+package proration
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/require"
+)
+
+func date(t *testing.T, loc string, year int, month time.Month, day, hour int) time.Time {
+	t.Helper()
+	l, err := time.LoadLocation(loc)
+	require.NoError(t, err)
+	return time.Date(year, month, day, hour, 0, 0, 0, l)
+}
+
+func TestRemaining(t *testing.T) {
+	utc := func(year int, month time.Month, day, hour int) time.Time {
+		return date(t, "UTC", year, month, day, hour)
+	}
+	ny := func(year int, month time.Month, day, hour int) time.Time {
+		return date(t, "America/New_York", year, month, day, hour)
+	}
+
+	for name, tc := range map[string]struct {
+		method         Method
+		start, end, at time.Time
+		want           float64
+	}{
+		"day: halfway through a 30-day month":                    {ByDay, utc(2026, 4, 1, 0), utc(2026, 5, 1, 0), utc(2026, 4, 16, 0), 15.0 / 30},
+		"day: the day of the change is billed at the new amount": {ByDay, utc(2026, 4, 1, 0), utc(2026, 5, 1, 0), utc(2026, 4, 16, 23), 15.0 / 30},
+		"day: last day of the period":                            {ByDay, utc(2026, 1, 1, 0), utc(2026, 2, 1, 0), utc(2026, 1, 31, 12), 1.0 / 31},
+		"day: leap day in February":                              {ByDay, utc(2024, 2, 1, 0), utc(2024, 3, 1, 0), utc(2024, 2, 29, 0), 1.0 / 29},
+		"day: February without leap":                             {ByDay, utc(2026, 2, 1, 0), utc(2026, 3, 1, 0), utc(2026, 2, 15, 0), 14.0 / 28},
+		"day: leap year":                                         {ByDay, utc(2024, 1, 1, 0), utc(2025, 1, 1, 0), utc(2024, 3, 1, 0), 306.0 / 366},
+		"day: DST starts mid-period":                             {ByDay, ny(2026, 3, 1, 0), ny(2026, 4, 1, 0), ny(2026, 3, 16, 0), 16.0 / 31},
+		"day: DST ends mid-period":                               {ByDay, ny(2026, 11, 1, 0), ny(2026, 12, 1, 0), ny(2026, 11, 16, 0), 15.0 / 30},
+		"day: change in another zone":                            {ByDay, ny(2026, 3, 1, 0), ny(2026, 4, 1, 0), utc(2026, 3, 16, 2), 17.0 / 31},
+		"day: sub-day period by second":                          {ByDay, utc(2026, 4, 1, 0), utc(2026, 4, 1, 12), utc(2026, 4, 1, 3), 9.0 / 12},
+		"second: halfway through":                                {BySecond, utc(2026, 4, 1, 0), utc(2026, 5, 1, 0), utc(2026, 4, 16, 0), 0.5},
+		"second: mid-day":                                        {BySecond, utc(2026, 4, 1, 0), utc(2026, 4, 2, 0), utc(2026, 4, 1, 18), 0.25},
+		"second: leap day":                                       {BySecond, utc(2024, 2, 1, 0), utc(2024, 3, 1, 0), utc(2024, 2, 29, 0), 1.0 / 29},
+		"second: DST starts mid-period":                          {BySecond, ny(2026, 3, 1, 0), ny(2026, 4, 1, 0), ny(2026, 3, 16, 0), float64(16*24) / float64(31*24-1)},
+		"second: DST ends mid-period":                            {BySecond, ny(2026, 11, 1, 0), ny(2026, 12, 1, 0), ny(2026, 11, 16, 0), float64(15*24) / float64(30*24+1)},
+		"at the period start":                                    {ByDay, utc(2026, 4, 1, 0), utc(2026, 5, 1, 0), utc(2026, 4, 1, 0), 1},
+		"before the period start":                                {BySecond, utc(2026, 4, 1, 0), utc(2026, 5, 1, 0), utc(2026, 3, 1, 0), 1},
+		"at the period end":                                      {ByDay, utc(2026, 4, 1, 0), utc(2026, 5, 1, 0), utc(2026, 5, 1, 0), 0},
+		"after the period end":                                   {BySecond, utc(2026, 4, 1, 0), utc(2026, 5, 1, 0), utc(2026, 6, 1, 0), 0},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := Remaining(tc.method, tc.start, tc.end, tc.at)
+			require.NoError(t, err)
+			require.InDelta(t, tc.want, got, 1e-12)
+		})
+	}
+}
+
+func TestRemaining_Errors(t *testing.T) {
+	start := time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := Remaining(ByDay, start, start, start)
+	require.True(t, errors.Is(err, ErrInvalidPeriod))
+	_, err = Remaining(BySecond, start, start.Add(-time.Hour), start)
+	require.True(t, errors.Is(err, ErrInvalidPeriod))
+	_, err = Remaining("hour", start, start.AddDate(0, 1, 0), start)
+	require.ErrorContains(t, err, "unknown method")
+}
+
+func TestProrate(t *testing.T) {
+	start := time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+
+	upgrade, err := Prorate(Change{From: 30, To: 90, PeriodStart: start, PeriodEnd: end, At: start.AddDate(0, 0, 20), Method: ByDay})
+	require.NoError(t, err)
+	require.InDelta(t, -10, upgrade.Credit, 1e-9)
+	require.InDelta(t, 30, upgrade.Charge, 1e-9)
+
+	downgrade, err := Prorate(Change{From: 90, To: 30, PeriodStart: start, PeriodEnd: end, At: start, Method: BySecond})
+	require.NoError(t, err)
+	require.Equal(t, 1.0, downgrade.Remaining)
+	require.Equal(t, -90.0, downgrade.Credit)
+	require.Equal(t, 30.0, downgrade.Charge)
+
+	cancel, err := Prorate(Change{From: 30, PeriodStart: start, PeriodEnd: end, At: start.AddDate(0, 0, 10), Method: ByDay})
+	require.NoError(t, err)
+	require.InDelta(t, -20, cancel.Credit, 1e-9)
+	require.Zero(t, cancel.Charge)
+
+	atEnd, err := Prorate(Change{From: 30, To: 90, PeriodStart: start, PeriodEnd: end, At: end, Method: ByDay})
+	require.NoError(t, err)
+	require.Equal(t, Result{}, atEnd)
+	require.False(t, math.Signbit(atEnd.Credit))
+
+	_, err = Prorate(Change{From: -1, To: 30, PeriodStart: start, PeriodEnd: end, At: start, Method: ByDay})
+	require.ErrorContains(t, err, "non-negative")
+	_, err = Prorate(Change{From: 30, To: math.NaN(), PeriodStart: start, PeriodEnd: end, At: start, Method: ByDay})
+	require.ErrorContains(t, err, "non-negative")
+	_, err = Prorate(Change{From: 30, To: 90, PeriodStart: end, PeriodEnd: start, At: start, Method: ByDay})
+	require.True(t, errors.Is(err, ErrInvalidPeriod))
+}
@@ -0,0 +1,77 @@
+// Package rounding rounds monetary amounts to the minor units of their currency.
+//
+// Currencies differ in how many decimals they are billed with: yen have none, dollars
+// and euros two, and the Bahraini dinar three. A Policy pairs a currency's decimals with
+// the Mode used to reach them.
+package rounding
+
+import (
+	"math"
+	"strings"
+)
+
+// Mode decides which way an amount between two minor units is rounded.
+type Mode string
+
+const (
+	// HalfUp rounds ties away from zero, so a credit rounds like the charge it offsets.
+	HalfUp Mode = "half_up"
+	// HalfEven rounds ties to the even minor unit, which keeps the rounding of many
+	// amounts from drifting in one direction.
+	HalfEven Mode = "half_even"
+	// Floor rounds down, towards negative infinity.
+	Floor Mode = "floor"
+)
+
+// IsValid reports whether m is a known mode.
+func (m Mode) IsValid() bool {
+	return m == HalfUp || m == HalfEven || m == Floor
+}
+
+// defaultDecimals is the number of minor unit decimals of currencies not listed in
+// currencyDecimals.
+const defaultDecimals = 2
+
+// currencyDecimals lists the ISO 4217 currencies whose minor unit is not a hundredth.
+var currencyDecimals = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// Decimals returns the number of decimals of currency's minor unit, such as 0 for JPY
+// and 3 for BHD.
+func Decimals(currency string) int {
+	if d, ok := currencyDecimals[strings.ToUpper(currency)]; ok {
+		return d
+	}
+	return defaultDecimals
+}
+
+// Policy rounds amounts to a fixed number of decimals.
+type Policy struct {
+	Mode     Mode `json:"mode"`
+	Decimals int  `json:"decimals"`
+}
+
+// ForCurrency returns the policy rounding amounts in currency with mode.
+func ForCurrency(currency string, mode Mode) Policy {
+	return Policy{Mode: mode, Decimals: Decimals(currency)}
+}
+
+// Round rounds v to the policy's decimals. An unknown mode rounds half up.
+func (p Policy) Round(v float64) float64 {
+	scale := math.Pow10(p.Decimals)
+	// Snap to a millionth of a minor unit first, so that the binary representation of
+	// an amount such as 2.675 (2.67499999…) does not decide a tie.
+	x := math.Round(v*scale*1e6) / 1e6
+	switch p.Mode {
+	case HalfEven:
+		x = math.RoundToEven(x)
+	case Floor:
+		x = math.Floor(x)
+	default:
+		x = math.Round(x)
+	}
+	return x / scale
+}
@@ -0,0 +1,51 @@
+package rounding
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecimals(t *testing.T) {
+	require.Equal(t, 0, Decimals("JPY"))
+	require.Equal(t, 0, Decimals("krw"))
+	require.Equal(t, 2, Decimals("USD"))
+	require.Equal(t, 2, Decimals("EUR"))
+	require.Equal(t, 3, Decimals("BHD"))
+	require.Equal(t, 3, Decimals("KWD"))
+}
+
+func TestRound(t *testing.T) {
+	for name, tc := range map[string]struct {
+		currency string
+		mode     Mode
+		v, want  float64
+	}{
+		"half up: USD tie rounds up":                  {"USD", HalfUp, 2.675, 2.68},
+		"half up: USD below tie rounds down":          {"USD", HalfUp, 2.6749, 2.67},
+		"half up: negative tie rounds away from zero": {"USD", HalfUp, -2.675, -2.68},
+		"half up: JPY has no decimals":                {"JPY", HalfUp, 1234.5, 1235},
+		"half up: BHD keeps three decimals":           {"BHD", HalfUp, 1.2345, 1.235},
+		"half even: tie rounds to even down":          {"USD", HalfEven, 2.665, 2.66},
+		"half even: tie rounds to even up":            {"USD", HalfEven, 2.675, 2.68},
+		"half even: JPY tie":                          {"JPY", HalfEven, 1234.5, 1234},
+		"half even: BHD non-tie":                      {"BHD", HalfEven, 1.2346, 1.235},
+		"floor: USD":                                  {"USD", Floor, 2.679, 2.67},
+		"floor: JPY":                                  {"JPY", Floor, 99.99, 99},
+		"floor: negative rounds down":                 {"USD", Floor, -2.671, -2.68},
+		"floor: exact amount is unchanged":            {"USD", Floor, 0.29, 0.29},
+		"unknown mode rounds half up":                 {"USD", "", 0.125, 0.13},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.InDelta(t, tc.want, ForCurrency(tc.currency, tc.mode).Round(tc.v), 1e-9)
+		})
+	}
+}
+
+func TestModeIsValid(t *testing.T) {
+	require.True(t, HalfUp.IsValid())
+	require.True(t, HalfEven.IsValid())
+	require.True(t, Floor.IsValid())
+	require.False(t, Mode("ceiling").IsValid())
+	require.False(t, Mode("").IsValid())
+}
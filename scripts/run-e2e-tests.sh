@@ -0,0 +1,18 @@
+#!/bin/bash
+# This script runs the tests for the fees service against a Temporal dev server started
+# in Docker for this run only. The tests register their own random namespace on it and
+# the server is removed afterwards, so no shared cluster is touched.
+set -euo pipefail
+
+cd "$(dirname "$0")/.."
+compose=(docker compose -p "fees-e2e-$$" -f scripts/docker-compose.e2e.yml)
+trap '"${compose[@]}" down --volumes --remove-orphans >/dev/null 2>&1' EXIT
+
+echo "Starting Temporal dev server..."
+"${compose[@]}" up -d --wait
+port=$("${compose[@]}" port temporal 7233 | awk -F: '{print $NF}')
+export FEES_TEMPORAL_ADDRESS="localhost:${port}"
+unset FEES_TEMPORAL_NAMESPACE
+
+echo "Running tests for the fees service against Temporal at ${FEES_TEMPORAL_ADDRESS}..."
+encore test ./services/fees -v "$@"
@@ -0,0 +1,374 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/storage/sqldb"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// AccrualSnapshotScheduleID names the Temporal Schedule that starts
+	// AccrualSnapshotWorkflow.
+	AccrualSnapshotScheduleID = "accrual-snapshot"
+	// SnapshotAccrualsActivityName records the accruals of one batch of bills.
+	SnapshotAccrualsActivityName = "SnapshotAccrualsActivity"
+
+	// defaultAccrualSnapshotSchedule snapshots the month just ended at 00:15 UTC on the
+	// first of every month.
+	defaultAccrualSnapshotSchedule = "15 0 1 * *"
+	// accrualSnapshotBatchSize is how many bills one SnapshotAccrualsActivity records.
+	accrualSnapshotBatchSize = 500
+)
+
+// AccrualSnapshotParams configures an AccrualSnapshotWorkflow run.
+type AccrualSnapshotParams struct {
+	// Period is the month, such as "2024-06", to snapshot at the end of. Empty is the
+	// month before the one the workflow runs in, as started by the schedule.
+	Period string
+}
+
+// AccrualSnapshotResult reports what an AccrualSnapshotWorkflow run recorded.
+type AccrualSnapshotResult struct {
+	Period string `json:"period"`
+	Bills  int    `json:"bills"`
+}
+
+// SnapshotAccrualsActivityParams defines parameters for SnapshotAccrualsActivity.
+type SnapshotAccrualsActivityParams struct {
+	Period    string
+	PeriodEnd time.Time
+	// AfterID resumes the snapshot after the last bill of the previous batch.
+	AfterID string
+	Limit   int
+}
+
+// SnapshotAccrualsActivityResult is the outcome of one batch of an accrual snapshot.
+type SnapshotAccrualsActivityResult struct {
+	Found  int
+	LastID string
+}
+
+// accrualPeriodEnd returns the end of a snapshot period: the first instant of the next
+// month, in UTC.
+func accrualPeriodEnd(period string) (time.Time, error) {
+	start, err := time.Parse(statementPeriodLayout, period)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return start.AddDate(0, 1, 0), nil
+}
+
+// AccrualSnapshotWorkflow records what every bill open at the end of a month had
+// accrued by then, in batches. Bills that closed or were deleted since still count,
+// and line items added after the month ended do not, so a late run records the same
+// snapshot. Running it again for a period replaces that period's snapshot.
+func AccrualSnapshotWorkflow(ctx workflow.Context, params AccrualSnapshotParams) (*AccrualSnapshotResult, error) {
+	logger := workflow.GetLogger(ctx)
+
+	period := params.Period
+	if period == "" {
+		now := workflow.Now(ctx).UTC()
+		period = time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC).Format(statementPeriodLayout)
+	}
+	periodEnd, err := accrualPeriodEnd(period)
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(fmt.Sprintf("invalid period %q", period), "InvalidPeriod", err)
+	}
+
+	result := &AccrualSnapshotResult{Period: period}
+	afterID := ""
+	for {
+		var batch SnapshotAccrualsActivityResult
+		err := workflow.ExecuteActivity(ctx, SnapshotAccrualsActivityName, SnapshotAccrualsActivityParams{
+			Period:    period,
+			PeriodEnd: periodEnd,
+			AfterID:   afterID,
+			Limit:     accrualSnapshotBatchSize,
+		}).Get(ctx, &batch)
+		if err != nil {
+			logger.Error("Failed to execute SnapshotAccrualsActivity", "period", period, "after_id", afterID, "error", err)
+			return result, err
+		}
+		result.Bills += batch.Found
+		if batch.Found < accrualSnapshotBatchSize {
+			break
+		}
+		afterID = batch.LastID
+	}
+	logger.Info("Accrual snapshot finished", "period", period, "bills", result.Bills)
+	return result, nil
+}
+
+// SnapshotAccrualsActivity records the accruals at params.PeriodEnd of up to
+// params.Limit bills after params.AfterID: the bills created before it that had not
+// closed or been deleted by then, with the line items added before it. Test bills are
+// never snapshotted. The last batch marks the period's snapshot complete.
+func (a *Activities) SnapshotAccrualsActivity(ctx context.Context, params SnapshotAccrualsActivityParams) (*SnapshotAccrualsActivityResult, error) {
+	defer keepAlive(ctx)()
+	ids, err := queryBillIDs(ctx, a.DB, `
+        WITH page AS (
+            SELECT id, tenant_id, COALESCE(customer_id, '') AS customer_id, currency FROM bills
+            WHERE id > $3 AND created_at < $2 AND NOT test
+              AND (closed_at IS NULL OR closed_at >= $2) AND (deleted_at IS NULL OR deleted_at >= $2)
+            ORDER BY id
+            LIMIT $4
+        ),
+        saved AS (
+            INSERT INTO accrual_snapshots (period, period_end, bill_id, tenant_id, customer_id, currency, accrued_amount, item_count, taken_at)
+            SELECT $1, $2, p.id, p.tenant_id, p.customer_id, p.currency, COALESCE(li.total, 0), COALESCE(li.count, 0), $5
+            FROM page p
+            LEFT JOIN LATERAL (
+                SELECT SUM(amount) AS total, COUNT(*) AS count FROM line_items
+                WHERE bill_id = p.id AND credit_note_id IS NULL AND created_at < $2
+            ) li ON true
+            ON CONFLICT (period, bill_id) DO UPDATE SET
+                accrued_amount = EXCLUDED.accrued_amount,
+                item_count = EXCLUDED.item_count,
+                taken_at = EXCLUDED.taken_at
+            RETURNING bill_id
+        )
+        SELECT bill_id FROM saved ORDER BY bill_id
+    `, params.Period, params.PeriodEnd, params.AfterID, params.Limit, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("SnapshotAccrualsActivity: failed to snapshot accruals of %s after %q: %w", params.Period, params.AfterID, err)
+	}
+	result := &SnapshotAccrualsActivityResult{Found: len(ids)}
+	if len(ids) > 0 {
+		result.LastID = ids[len(ids)-1]
+	}
+	if len(ids) < params.Limit {
+		_, err := a.DB.Exec(ctx, `
+            INSERT INTO accrual_snapshot_runs (period, period_end, bills, completed_at)
+            VALUES ($1, $2, (SELECT COUNT(*) FROM accrual_snapshots WHERE period = $1), $3)
+            ON CONFLICT (period) DO UPDATE SET bills = EXCLUDED.bills, completed_at = EXCLUDED.completed_at
+        `, params.Period, params.PeriodEnd, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("SnapshotAccrualsActivity: failed to complete snapshot of %s: %w", params.Period, err)
+		}
+	}
+	return result, nil
+}
+
+// ensureAccrualSnapshotSchedule creates the accrual snapshot schedule, or brings an
+// existing one in line with cfg. Turning it off deletes it.
+func ensureAccrualSnapshotSchedule(ctx context.Context, schedules client.ScheduleClient, cfg *Config) error {
+	if cfg.AccrualSnapshotSchedule == closeSweepOff {
+		err := schedules.GetHandle(ctx, AccrualSnapshotScheduleID).Delete(ctx)
+		var notFound *serviceerror.NotFound
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return err
+	}
+	spec := client.ScheduleSpec{CronExpressions: []string{cfg.AccrualSnapshotSchedule}}
+	action := &client.ScheduleWorkflowAction{
+		ID:        AccrualSnapshotScheduleID,
+		Workflow:  AccrualSnapshotWorkflow,
+		Args:      []interface{}{AccrualSnapshotParams{}},
+		TaskQueue: feesTaskQueue,
+	}
+	_, err := schedules.Create(ctx, client.ScheduleOptions{
+		ID:      AccrualSnapshotScheduleID,
+		Spec:    spec,
+		Action:  action,
+		Overlap: enums.SCHEDULE_OVERLAP_POLICY_SKIP,
+		Note:    "Snapshots the accruals of open bills at month end.",
+	})
+	if !errors.Is(err, temporal.ErrScheduleAlreadyRunning) {
+		return err
+	}
+	return schedules.GetHandle(ctx, AccrualSnapshotScheduleID).Update(ctx, client.ScheduleUpdateOptions{
+		DoUpdate: func(input client.ScheduleUpdateInput) (*client.ScheduleUpdate, error) {
+			schedule := input.Description.Schedule
+			schedule.Spec, schedule.Action = &spec, action
+			return &client.ScheduleUpdate{Schedule: &schedule}, nil
+		},
+	})
+}
+
+// ------ API ------
+
+// AccrualReportParams selects an accrual snapshot and what of it to export.
+type AccrualReportParams struct {
+	// Period is the month, such as "2024-06", whose end the snapshot was taken at.
+	Period     string `query:"period"`
+	Currency   string `query:"currency"`
+	CustomerID string `query:"customerId"`
+	// IncludeBills also exports the snapshot of every bill.
+	IncludeBills bool `query:"includeBills"`
+}
+
+// AccrualSnapshot is what one bill had accrued at the end of a period.
+type AccrualSnapshot struct {
+	BillID        string  `json:"billId"`
+	TenantID      string  `json:"tenantId"`
+	CustomerID    string  `json:"customerId,omitempty"`
+	Currency      string  `json:"currency"`
+	AccruedAmount float64 `json:"accruedAmount"`
+	ItemCount     int     `json:"itemCount"`
+}
+
+// CustomerAccrual sums what a customer's open bills of one currency had accrued at the
+// end of a period.
+type CustomerAccrual struct {
+	TenantID      string  `json:"tenantId"`
+	CustomerID    string  `json:"customerId,omitempty"`
+	Currency      string  `json:"currency"`
+	AccruedAmount float64 `json:"accruedAmount"`
+	BillCount     int     `json:"billCount"`
+}
+
+// AccrualCurrencyTotal sums a period's accruals of one currency.
+type AccrualCurrencyTotal struct {
+	Currency      string  `json:"currency"`
+	AccruedAmount float64 `json:"accruedAmount"`
+	BillCount     int     `json:"billCount"`
+}
+
+// AccrualReportResponse is the accrued-but-unbilled amount at the end of a period.
+type AccrualReportResponse struct {
+	Period    string    `json:"period"`
+	PeriodEnd time.Time `json:"periodEnd"`
+	// TakenAt is when the snapshot completed.
+	TakenAt   time.Time              `json:"takenAt"`
+	Totals    []AccrualCurrencyTotal `json:"totals"`
+	Customers []CustomerAccrual      `json:"customers"`
+	// Bills is set when includeBills was requested.
+	Bills []AccrualSnapshot `json:"bills,omitempty"`
+}
+
+// GetAccrualReport exports the accrual snapshot of a period: what the bills open at
+// its end had accrued, per customer and currency, and optionally per bill. Tenant-scoped
+// callers only see their own tenant's bills.
+//
+// encore:api auth method=GET path=/reports/accruals
+func (s *Service) GetAccrualReport(ctx context.Context, params *AccrualReportParams) (*AccrualReportResponse, error) {
+	if _, _, err := parseStatementPeriod(params.Period); err != nil {
+		return nil, err
+	}
+	if params.Currency != "" && !validCurrency(params.Currency) {
+		return nil, apierr.InvalidArgument(apierr.InvalidCurrency, "invalid currency %q: must be a three-letter ISO 4217 code such as \"USD\"", params.Currency)
+	}
+	resp := &AccrualReportResponse{Period: params.Period, Totals: []AccrualCurrencyTotal{}, Customers: []CustomerAccrual{}}
+	err := s.db.QueryRow(ctx, `SELECT period_end, completed_at FROM accrual_snapshot_runs WHERE period = $1`, params.Period).
+		Scan(&resp.PeriodEnd, &resp.TakenAt)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return nil, apierr.NotFound(apierr.AccrualSnapshotNotFound, "no accrual snapshot of %s has completed", params.Period)
+	}
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load accrual snapshot of %s", params.Period)
+	}
+
+	tenant := callerTenant(ctx)
+	rows, err := s.db.Query(ctx, `
+        SELECT bill_id, tenant_id, customer_id, currency, accrued_amount::float8, item_count
+        FROM accrual_snapshots
+        WHERE period = $1 AND ($2 = '' OR currency = $2) AND ($3 = '' OR customer_id = $3) AND ($4 = '' OR tenant_id = $4)
+        ORDER BY tenant_id, customer_id, currency, bill_id
+    `, params.Period, params.Currency, params.CustomerID, tenant)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load accrual snapshot of %s", params.Period)
+	}
+	defer rows.Close()
+	var snapshots []AccrualSnapshot
+	for rows.Next() {
+		var a AccrualSnapshot
+		if err := rows.Scan(&a.BillID, &a.TenantID, &a.CustomerID, &a.Currency, &a.AccruedAmount, &a.ItemCount); err != nil {
+			return nil, apierr.Wrap(err, "failed to read accrual snapshot of %s", params.Period)
+		}
+		snapshots = append(snapshots, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read accrual snapshot of %s", params.Period)
+	}
+
+	resp.Customers, resp.Totals = sumAccruals(snapshots)
+	if params.IncludeBills {
+		resp.Bills = snapshots
+	}
+	return resp, nil
+}
+
+// sumAccruals sums bill snapshots, ordered by tenant, customer and currency, per
+// customer and currency, and per currency.
+func sumAccruals(snapshots []AccrualSnapshot) ([]CustomerAccrual, []AccrualCurrencyTotal) {
+	customers := []CustomerAccrual{}
+	totals := map[string]*AccrualCurrencyTotal{}
+	for _, a := range snapshots {
+		n := len(customers)
+		if n == 0 || customers[n-1].TenantID != a.TenantID || customers[n-1].CustomerID != a.CustomerID || customers[n-1].Currency != a.Currency {
+			customers = append(customers, CustomerAccrual{TenantID: a.TenantID, CustomerID: a.CustomerID, Currency: a.Currency})
+			n++
+		}
+		c := &customers[n-1]
+		c.AccruedAmount = roundAmount(c.AccruedAmount + a.AccruedAmount)
+		c.BillCount++
+
+		t, ok := totals[a.Currency]
+		if !ok {
+			t = &AccrualCurrencyTotal{Currency: a.Currency}
+			totals[a.Currency] = t
+		}
+		t.AccruedAmount = roundAmount(t.AccruedAmount + a.AccruedAmount)
+		t.BillCount++
+	}
+	sorted := make([]AccrualCurrencyTotal, 0, len(totals))
+	for _, t := range totals {
+		sorted = append(sorted, *t)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Currency < sorted[j].Currency })
+	return customers, sorted
+}
+
+// SnapshotAccrualsRequest is the request payload for taking an accrual snapshot.
+type SnapshotAccrualsRequest struct {
+	// Period is the month, such as "2024-06", to snapshot at the end of. It must have
+	// ended.
+	Period string `json:"period"`
+}
+
+// SnapshotAccrualsResponse names the workflow taking the snapshot.
+type SnapshotAccrualsResponse struct {
+	Period     string `json:"period"`
+	WorkflowID string `json:"workflowId"`
+	RunID      string `json:"runId"`
+}
+
+// SnapshotAccruals takes the accrual snapshot of a period now, such as one the schedule
+// missed, replacing any taken before. It returns at once; GET /reports/accruals serves
+// the snapshot once it completes.
+//
+// encore:api private method=POST path=/admin/accruals/snapshots
+func (s *Service) SnapshotAccruals(ctx context.Context, req *SnapshotAccrualsRequest) (*SnapshotAccrualsResponse, error) {
+	if _, _, err := parseStatementPeriod(req.Period); err != nil {
+		return nil, err
+	}
+	periodEnd, _ := accrualPeriodEnd(req.Period)
+	if periodEnd.After(s.clock.Now()) {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "period %s has not ended yet", req.Period)
+	}
+	run, err := s.temporalClient.ExecuteWorkflow(ctx, client.StartWorkflowOptions{
+		ID:        "accrual-snapshot-" + req.Period,
+		TaskQueue: feesTaskQueue,
+	}, AccrualSnapshotWorkflow, AccrualSnapshotParams{Period: req.Period})
+	var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+	if err != nil && !errors.As(err, &alreadyStarted) {
+		return nil, apierr.FromTemporal(err, apierr.Internal, "failed to start accrual snapshot of %s", req.Period)
+	}
+	resp := &SnapshotAccrualsResponse{Period: req.Period, WorkflowID: "accrual-snapshot-" + req.Period}
+	if run != nil {
+		resp.RunID = run.GetRunID()
+	}
+	loggerFrom(ctx).Info("Accrual snapshot started", "period", req.Period, "run_id", resp.RunID)
+	return resp, nil
+}
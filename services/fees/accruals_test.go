@@ -0,0 +1,66 @@
+package fees
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/mocks"
+)
+
+// TestAccrualPeriodEnd tests that a period ends at the start of the next month, across
+// a year end.
+func TestAccrualPeriodEnd(t *testing.T) {
+	end, err := accrualPeriodEnd("2024-12")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), end)
+
+	_, err = accrualPeriodEnd("2024-13")
+	require.Error(t, err)
+}
+
+// TestSumAccruals tests that bill snapshots are summed per customer and currency, and
+// per currency.
+func TestSumAccruals(t *testing.T) {
+	customers, totals := sumAccruals([]AccrualSnapshot{
+		{BillID: "b1", TenantID: "acme", CustomerID: "c1", Currency: "EUR", AccruedAmount: 10.1},
+		{BillID: "b2", TenantID: "acme", CustomerID: "c1", Currency: "EUR", AccruedAmount: 0.2},
+		{BillID: "b3", TenantID: "acme", CustomerID: "c1", Currency: "USD", AccruedAmount: 5},
+		{BillID: "b4", TenantID: "acme", CustomerID: "c2", Currency: "EUR", AccruedAmount: 1},
+		{BillID: "b5", TenantID: "globex", CustomerID: "c2", Currency: "EUR", AccruedAmount: 2},
+	})
+	require.Equal(t, []CustomerAccrual{
+		{TenantID: "acme", CustomerID: "c1", Currency: "EUR", AccruedAmount: 10.3, BillCount: 2},
+		{TenantID: "acme", CustomerID: "c1", Currency: "USD", AccruedAmount: 5, BillCount: 1},
+		{TenantID: "acme", CustomerID: "c2", Currency: "EUR", AccruedAmount: 1, BillCount: 1},
+		{TenantID: "globex", CustomerID: "c2", Currency: "EUR", AccruedAmount: 2, BillCount: 1},
+	}, customers)
+	require.Equal(t, []AccrualCurrencyTotal{
+		{Currency: "EUR", AccruedAmount: 13.3, BillCount: 4},
+		{Currency: "USD", AccruedAmount: 5, BillCount: 1},
+	}, totals)
+
+	customers, totals = sumAccruals(nil)
+	require.Empty(t, customers)
+	require.Empty(t, totals)
+}
+
+// TestEnsureAccrualSnapshotSchedule tests that the schedule is created with the
+// configured cron expression, and that turning it off deletes a schedule left behind.
+func TestEnsureAccrualSnapshotSchedule(t *testing.T) {
+	schedules := mocks.NewScheduleClient(t)
+	schedules.On("Create", mock.Anything, mock.MatchedBy(func(o client.ScheduleOptions) bool {
+		return o.ID == AccrualSnapshotScheduleID && o.Spec.CronExpressions[0] == defaultAccrualSnapshotSchedule
+	})).Return(mocks.NewScheduleHandle(t), nil).Once()
+	require.NoError(t, ensureAccrualSnapshotSchedule(context.Background(), schedules, &Config{AccrualSnapshotSchedule: defaultAccrualSnapshotSchedule}))
+
+	schedules = mocks.NewScheduleClient(t)
+	handle := mocks.NewScheduleHandle(t)
+	schedules.On("GetHandle", mock.Anything, AccrualSnapshotScheduleID).Return(handle).Once()
+	handle.On("Delete", mock.Anything).Return(serviceerror.NewNotFound("schedule not found")).Once()
+	require.NoError(t, ensureAccrualSnapshotSchedule(context.Background(), schedules, &Config{AccrualSnapshotSchedule: closeSweepOff}))
+}
@@ -2,28 +2,69 @@ package fees
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
-	"encore.dev/storage/sqldb"
+	"encore.app/proration"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
 )
 
-// Activities holds a reference to the database for persistence operations.
+// Activities holds a reference to the database for persistence operations,
+// the payment gateway used to charge closed bills, the notifier used to reach customers,
+// the router that forwards late line items to a customer's next bill, and the Temporal
+// client that queues line items for repair.
 type Activities struct {
-	DB *sqldb.Database
+	DB       *tracedDB
+	Gateway  PaymentGateway
+	Notifier Notifier
+	Router   *LateItemRouter
+	Temporal client.Client
+	// Namespace holds the fees workflows, for activities that delete them.
+	Namespace string
+	// Fields encrypts line item descriptions and client references; nil stores them
+	// in plaintext.
+	Fields *fieldCipher
+	// Mailer emails closed bills to customers.
+	Mailer *billMailer
+	// Archiver archives settled bills; it is nil unless archive signing keys are set.
+	Archiver *billArchiver
 }
 
 // UpsertBillActivity creates or updates a bill in the database.
 func (a *Activities) UpsertBillActivity(ctx context.Context, params UpsertBillActivityParams) error {
-	_, err := a.DB.Exec(ctx, `
-        INSERT INTO bills (id, customer_id, currency, status, created_at, total_amount)
-        VALUES ($1, $2, $3, $4, $5, $6)
-        ON CONFLICT (id) DO UPDATE SET
-            customer_id = EXCLUDED.customer_id,
-            currency = EXCLUDED.currency,
-            status = EXCLUDED.status,
-            -- created_at should not change on conflict
-            total_amount = bills.total_amount -- ensure total_amount is not reset if bill already exists
-    `, params.BillID, params.CustomerID, params.Currency, params.Status, params.CreatedAt, 0.0)
+	rounding, err := jsonColumn(params.Rounding)
+	if err != nil {
+		return fmt.Errorf("UpsertBillActivity: failed to encode rounding policy of bill %s: %w", params.BillID, err)
+	}
+	createdAt := params.CreatedAt
+	ev := auditEvent{BillID: params.BillID, Action: AuditBillCreated, ActorKeyID: params.CreatedByKeyID, Version: params.Version, Event: &BillEventData{
+		CustomerID:   params.CustomerID,
+		Currency:     params.Currency,
+		TenantID:     tenantOrDefault(params.TenantID),
+		TemplateID:   params.TemplateID,
+		ParentBillID: params.ParentBillID,
+		CreatedAt:    &createdAt,
+		DueDate:      params.DueDate,
+		PeriodEnd:    params.PeriodEnd,
+		Rounding:     params.Rounding,
+	}}
+	err = a.audited(ctx, ev, func(tx *tracedTx) error {
+		_, err := tx.Exec(ctx, `
+            INSERT INTO bills (id, customer_id, currency, status, created_at, total_amount, created_by_key_id, template_id, tenant_id, version, due_date, rounding, period_end, parent_bill_id)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+            ON CONFLICT (id) DO UPDATE SET
+                customer_id = EXCLUDED.customer_id,
+                currency = EXCLUDED.currency,
+                status = EXCLUDED.status,
+                -- created_at should not change on conflict
+                total_amount = bills.total_amount, -- ensure total_amount is not reset if bill already exists
+                version = GREATEST(bills.version, EXCLUDED.version)
+        `, params.BillID, params.CustomerID, params.Currency, params.Status, params.CreatedAt, 0.0, nullIfEmpty(params.CreatedByKeyID), nullIfEmpty(params.TemplateID), tenantOrDefault(params.TenantID), params.Version, params.DueDate, rounding, params.PeriodEnd, nullIfEmpty(params.ParentBillID))
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("UpsertBillActivity: failed to upsert bill %s: %w", params.BillID, err)
 	}
@@ -32,25 +73,323 @@ func (a *Activities) UpsertBillActivity(ctx context.Context, params UpsertBillAc
 
 // SaveLineItemActivity saves a new line item to the database.
 func (a *Activities) SaveLineItemActivity(ctx context.Context, params SaveLineItemActivityParams) error {
-	_, err := a.DB.Exec(ctx, `
-        INSERT INTO line_items (id, bill_id, description, amount, created_at)
-        VALUES ($1, $2, $3, $4, $5)
-    `, params.LineItemID, params.BillID, params.Description, params.Amount, params.CreatedAt)
+	var routedFromBillID *string
+	var periodStart, periodEnd *time.Time
+	if params.RoutedFrom != nil {
+		routedFromBillID = &params.RoutedFrom.BillID
+		periodStart, periodEnd = params.RoutedFrom.PeriodStart, params.RoutedFrom.PeriodEnd
+	}
+	item, err := a.Fields.sealLineItem(LineItem{
+		ID:              params.LineItemID,
+		Description:     params.Description,
+		Amount:          params.Amount,
+		Type:            params.Type,
+		ReasonCode:      params.ReasonCode,
+		Reference:       params.Reference,
+		Quantity:        params.Quantity,
+		UnitPrice:       params.UnitPrice,
+		Unit:            params.Unit,
+		RoutedFrom:      params.RoutedFrom,
+		SubBillID:       params.SubBillID,
+		Late:            params.Late,
+		OverHardCap:     params.OverHardCap,
+		CreatedByKeyID:  params.CreatedByKeyID,
+		ClientReference: params.ClientReference,
+		Metric:          params.Metric,
+		Drawdown:        params.Drawdown,
+		Waived:          params.Waived,
+	})
+	if err != nil {
+		return fmt.Errorf("SaveLineItemActivity: failed to encrypt line item %s for bill %s: %w", params.LineItemID, params.BillID, err)
+	}
+	ev := auditEvent{BillID: params.BillID, Action: AuditLineItemAdded, ActorKeyID: params.CreatedByKeyID, SubjectID: params.LineItemID, Version: params.BillVersion, Event: &BillEventData{
+		LineItem: &item,
+	}}
+	err = a.audited(ctx, ev, func(tx *tracedTx) error {
+		_, err := tx.Exec(ctx, `
+            INSERT INTO line_items (id, bill_id, description, amount, created_at, routed_from_bill_id, original_period_start, original_period_end, created_by_key_id, late, client_reference, over_hard_cap, quantity, unit_price, unit, sub_bill_id, type, reason_code, reference_line_item_id, reference_external, metric, drawdown, waived)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23)
+        `, params.LineItemID, params.BillID, item.Description, params.Amount, params.CreatedAt, routedFromBillID, periodStart, periodEnd, nullIfEmpty(params.CreatedByKeyID), params.Late, nullIfEmpty(item.ClientReference), params.OverHardCap,
+			nullIfZero(params.Quantity), nullIfZero(params.UnitPrice), nullIfEmpty(params.Unit), nullIfEmpty(params.SubBillID), item.itemType(), nullIfEmpty(params.ReasonCode),
+			nullIfEmpty(item.Reference.lineItemID()), nullIfEmpty(item.Reference.external()), nullIfEmpty(params.Metric), nullIfZero(params.Drawdown), nullIfZero(params.Waived))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `UPDATE bills SET version = GREATEST(version, $2) WHERE id = $1`, params.BillID, params.BillVersion)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("SaveLineItemActivity: failed to save line item %s for bill %s: %w", params.LineItemID, params.BillID, err)
 	}
 	return nil
 }
 
-// UpdateBillOnCloseActivity updates the bill's status, total amount, and closed_at time.
+// UpdateBillOnCloseActivity updates the bill's status, total amount, closed_at time,
+// adjustment, and approval, and numbers the bill.
 func (a *Activities) UpdateBillOnCloseActivity(ctx context.Context, params UpdateBillOnCloseActivityParams) error {
-	_, err := a.DB.Exec(ctx, `
-        UPDATE bills
-        SET status = $2, total_amount = $3, closed_at = $4
-        WHERE id = $1
-    `, params.BillID, params.Status, params.TotalAmount, params.ClosedAt)
+	adjustment, err := jsonColumn(params.Adjustment)
+	if err != nil {
+		return fmt.Errorf("UpdateBillOnCloseActivity: failed to encode adjustment of bill %s: %w", params.BillID, err)
+	}
+	approval, err := jsonColumn(params.Approval)
+	if err != nil {
+		return fmt.Errorf("UpdateBillOnCloseActivity: failed to encode approval of bill %s: %w", params.BillID, err)
+	}
+	appliedCredit, err := jsonColumn(params.AppliedCredit)
+	if err != nil {
+		return fmt.Errorf("UpdateBillOnCloseActivity: failed to encode applied credit of bill %s: %w", params.BillID, err)
+	}
+	total, closedAt := params.TotalAmount, params.ClosedAt
+	ev := auditEvent{BillID: params.BillID, Action: AuditBillClosed, ActorKeyID: params.ClosedByKeyID, Version: params.Version, Event: &BillEventData{
+		TotalAmount:   &total,
+		ClosedAt:      &closedAt,
+		Adjustment:    params.Adjustment,
+		AppliedCredit: params.AppliedCredit,
+		Approval:      params.Approval,
+		DueDate:       params.DueDate,
+	}}
+	err = a.audited(ctx, ev, func(tx *tracedTx) error {
+		_, err := tx.Exec(ctx, `
+            UPDATE bills
+            SET status = $2, total_amount = $3, closed_at = $4, adjustment = $5, approval = COALESCE($6, approval),
+                version = GREATEST(version, $7), due_date = COALESCE($8, due_date), applied_credit = $9
+            WHERE id = $1
+        `, params.BillID, params.Status, params.TotalAmount, params.ClosedAt, adjustment, approval, params.Version, params.DueDate, appliedCredit)
+		if err != nil {
+			return err
+		}
+		if ev.Event.Number, err = assignBillNumber(ctx, tx, params.BillID, params.ClosedAt); err != nil {
+			return err
+		}
+		return postJournal(ctx, tx, closeJournal(params.BillID, params.TotalAmount, params.AppliedCredit, params.ClosedAt))
+	})
 	if err != nil {
 		return fmt.Errorf("UpdateBillOnCloseActivity: failed to update bill %s on close: %w", params.BillID, err)
 	}
 	return nil
 }
+
+// UpdateBillStatusActivity updates the bill's status while it closes and after it has closed.
+// Most status changes are made by the workflow itself, so their audit entries have no
+// actor; an approval decision is attributed to the key that made it. A bill becoming
+// OVERDUE is recorded as its own bill.overdue event.
+func (a *Activities) UpdateBillStatusActivity(ctx context.Context, params UpdateBillStatusActivityParams) error {
+	approval, err := jsonColumn(params.Approval)
+	if err != nil {
+		return fmt.Errorf("UpdateBillStatusActivity: failed to encode approval of bill %s: %w", params.BillID, err)
+	}
+	ev := auditEvent{BillID: params.BillID, Action: AuditStatusChanged, ActorKeyID: params.ActorKeyID, Version: params.Version, Event: &BillEventData{
+		Approval: params.Approval,
+	}}
+	if params.Status == BillStatusOverdue {
+		ev.Action, ev.Event.DueDate = AuditBillOverdue, params.DueDate
+	}
+	err = a.audited(ctx, ev, func(tx *tracedTx) error {
+		_, err := tx.Exec(ctx, `
+            UPDATE bills
+            SET status = $2, approval = COALESCE($3, approval), version = GREATEST(version, $4)
+            WHERE id = $1
+        `, params.BillID, params.Status, approval, params.Version)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("UpdateBillStatusActivity: failed to update bill %s to status %s: %w", params.BillID, params.Status, err)
+	}
+	return nil
+}
+
+// ChargePaymentActivity charges the customer through the configured payment gateway.
+// Declines are returned as non-retryable errors of type PaymentDeclinedErrorType.
+func (a *Activities) ChargePaymentActivity(ctx context.Context, params ChargePaymentActivityParams) (*ChargeResult, error) {
+	result, err := a.Gateway.Charge(ctx, ChargeRequest{
+		PaymentID:  params.PaymentID,
+		BillID:     params.BillID,
+		CustomerID: params.CustomerID,
+		Amount:     params.Amount,
+		Currency:   params.Currency,
+	})
+	if err != nil {
+		var declined *PaymentDeclinedError
+		if errors.As(err, &declined) {
+			return nil, temporal.NewNonRetryableApplicationError(declined.Reason, PaymentDeclinedErrorType, err)
+		}
+		return nil, fmt.Errorf("ChargePaymentActivity: failed to charge payment %s for bill %s: %w", params.PaymentID, params.BillID, err)
+	}
+	return result, nil
+}
+
+// RecordPaymentActivity persists the outcome of a payment attempt.
+func (a *Activities) RecordPaymentActivity(ctx context.Context, params RecordPaymentActivityParams) error {
+	createdAt, completedAt := params.CreatedAt, params.CompletedAt
+	ev := auditEvent{BillID: params.BillID, Action: AuditPaymentRecorded, ActorKeyID: params.ActorKeyID, SubjectID: params.PaymentID, Event: &BillEventData{
+		Payment: &Payment{
+			ID:               params.PaymentID,
+			Status:           params.Status,
+			Amount:           params.Amount,
+			GatewayReference: params.GatewayReference,
+			FailureReason:    params.FailureReason,
+			CreatedAt:        &createdAt,
+			CompletedAt:      &completedAt,
+			Method:           params.Method,
+		},
+	}}
+	err := a.audited(ctx, ev, func(tx *tracedTx) error {
+		_, err := tx.Exec(ctx, `
+            INSERT INTO payments (id, bill_id, amount, currency, status, gateway_reference, failure_reason, created_at, completed_at, method)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+            ON CONFLICT (id) DO UPDATE SET
+                status = EXCLUDED.status,
+                gateway_reference = EXCLUDED.gateway_reference,
+                failure_reason = EXCLUDED.failure_reason,
+                completed_at = EXCLUDED.completed_at
+        `, params.PaymentID, params.BillID, params.Amount, params.Currency, params.Status, params.GatewayReference, params.FailureReason, params.CreatedAt, params.CompletedAt, nullIfEmpty(params.Method))
+		switch {
+		case err != nil:
+			return err
+		case params.Reversed:
+			return postJournal(ctx, tx, paymentReversalJournal(params.BillID, params.PaymentID, params.Amount, params.CompletedAt))
+		case params.Status == PaymentStatusSucceeded:
+			return postJournal(ctx, tx, paymentJournal(params.BillID, params.PaymentID, params.Amount, params.CompletedAt))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("RecordPaymentActivity: failed to record payment %s for bill %s: %w", params.PaymentID, params.BillID, err)
+	}
+	return nil
+}
+
+// RecordCreditNoteActivity persists a pending credit note and its negative line items.
+func (a *Activities) RecordCreditNoteActivity(ctx context.Context, params RecordCreditNoteActivityParams) error {
+	createdAt := params.CreatedAt
+	lineItems := make([]LineItem, len(params.LineItems))
+	for i, item := range params.LineItems {
+		sealed, err := a.Fields.sealLineItem(item)
+		if err != nil {
+			return fmt.Errorf("RecordCreditNoteActivity: failed to encrypt line item %s for credit note %s: %w", item.ID, params.CreditNoteID, err)
+		}
+		lineItems[i] = sealed
+	}
+	ev := auditEvent{BillID: params.BillID, Action: AuditRefundIssued, ActorKeyID: params.ActorKeyID, SubjectID: params.CreditNoteID, Event: &BillEventData{
+		CreditNote: &CreditNote{
+			ID:        params.CreditNoteID,
+			Status:    RefundStatusPending,
+			Amount:    params.Amount,
+			Reason:    params.Reason,
+			LineItems: lineItems,
+			CreatedAt: &createdAt,
+		},
+	}}
+	return a.audited(ctx, ev, func(tx *tracedTx) error {
+		_, err := tx.Exec(ctx, `
+            INSERT INTO credit_notes (id, bill_id, amount, currency, reason, status, created_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7)
+            ON CONFLICT (id) DO NOTHING
+            `, params.CreditNoteID, params.BillID, params.Amount, params.Currency, params.Reason, RefundStatusPending, params.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("RecordCreditNoteActivity: failed to record credit note %s for bill %s: %w", params.CreditNoteID, params.BillID, err)
+		}
+
+		for _, item := range lineItems {
+			_, err := tx.Exec(ctx, `
+                INSERT INTO line_items (id, bill_id, credit_note_id, description, amount, created_at, type, reason_code, reference_line_item_id)
+                VALUES ($1, $2, $3, $4, $5, $6, 'CREDIT', $7, $8)
+                ON CONFLICT (id) DO NOTHING
+            `, item.ID, params.BillID, params.CreditNoteID, item.Description, item.Amount, params.CreatedAt, ReasonCodeRefund, nullIfEmpty(item.Reference.lineItemID()))
+			if err != nil {
+				return fmt.Errorf("RecordCreditNoteActivity: failed to save line item %s for credit note %s: %w", item.ID, params.CreditNoteID, err)
+			}
+		}
+		return nil
+	})
+}
+
+// RefundPaymentActivity refunds a settled payment through the configured payment gateway.
+// Declines are returned as non-retryable errors of type PaymentDeclinedErrorType.
+func (a *Activities) RefundPaymentActivity(ctx context.Context, params RefundPaymentActivityParams) (*RefundResult, error) {
+	result, err := a.Gateway.Refund(ctx, RefundRequest{
+		RefundID:         params.CreditNoteID,
+		BillID:           params.BillID,
+		PaymentReference: params.PaymentReference,
+		Amount:           params.Amount,
+		Currency:         params.Currency,
+	})
+	if err != nil {
+		var declined *PaymentDeclinedError
+		if errors.As(err, &declined) {
+			return nil, temporal.NewNonRetryableApplicationError(declined.Reason, PaymentDeclinedErrorType, err)
+		}
+		return nil, fmt.Errorf("RefundPaymentActivity: failed to refund credit note %s for bill %s: %w", params.CreditNoteID, params.BillID, err)
+	}
+	return result, nil
+}
+
+// UpdateCreditNoteActivity records the final status of a credit note's refund.
+func (a *Activities) UpdateCreditNoteActivity(ctx context.Context, params UpdateCreditNoteActivityParams) error {
+	completedAt := params.CompletedAt
+	ev := auditEvent{BillID: params.BillID, Action: AuditRefundCompleted, ActorKeyID: params.ActorKeyID, SubjectID: params.CreditNoteID, Event: &BillEventData{
+		CreditNote: &CreditNote{
+			ID:               params.CreditNoteID,
+			Status:           params.Status,
+			GatewayReference: params.GatewayReference,
+			FailureReason:    params.FailureReason,
+			CompletedAt:      &completedAt,
+		},
+	}}
+	err := a.audited(ctx, ev, func(tx *tracedTx) error {
+		_, err := tx.Exec(ctx, `
+            UPDATE credit_notes
+            SET status = $2, gateway_reference = $3, failure_reason = $4, completed_at = $5
+            WHERE id = $1
+        `, params.CreditNoteID, params.Status, params.GatewayReference, params.FailureReason, params.CompletedAt)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("UpdateCreditNoteActivity: failed to update credit note %s: %w", params.CreditNoteID, err)
+	}
+	return nil
+}
+
+// SendNotificationActivity delivers a notification through the configured notifier.
+func (a *Activities) SendNotificationActivity(ctx context.Context, params SendNotificationActivityParams) error {
+	if err := a.Notifier.Notify(ctx, params.Notification); err != nil {
+		return fmt.Errorf("SendNotificationActivity: failed to send %s notification for bill %s: %w", params.Notification.Event, params.Notification.BillID, err)
+	}
+	return nil
+}
+
+// ProrateActivity prices a mid-period plan change or cancellation, rounding the credit
+// and charge to the precision line items are stored with. It runs as an activity so the
+// proration rules can change without breaking the replay of running subscriptions.
+func (a *Activities) ProrateActivity(ctx context.Context, params ProrateActivityParams) (*proration.Result, error) {
+	result, err := proration.Prorate(params.Change)
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), InvalidProrationErrorType, err)
+	}
+	result.Credit, result.Charge = roundAmount(result.Credit), roundAmount(result.Charge)
+	return &result, nil
+}
+
+// nullIfEmpty maps "" to SQL NULL for optional foreign keys.
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// nullIfZero maps 0 to SQL NULL for optional numbers.
+func nullIfZero(v float64) *float64 {
+	if v == 0 {
+		return nil
+	}
+	return &v
+}
+
+// jsonColumn encodes v for a JSONB column, mapping nil to SQL NULL.
+func jsonColumn[T any](v *T) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,281 @@
+package fees
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// defaultHeartbeatInterval is how often keepAlive heartbeats an activity without a
+	// heartbeat timeout.
+	defaultHeartbeatInterval = 10 * time.Second
+	// activityBackoff multiplies the interval between an activity's retries after each one.
+	activityBackoff = 2.0
+)
+
+// ActivityPolicy sets the timeouts and retries of one activity type.
+type ActivityPolicy struct {
+	// StartToClose bounds each attempt.
+	StartToClose time.Duration
+	// Heartbeat, if set, fails an attempt that has not heartbeated for this long, so a
+	// worker that died midway is noticed before StartToClose runs out. Activities
+	// with a heartbeat timeout must heartbeat, with heartbeat or keepAlive.
+	Heartbeat time.Duration
+	// InitialInterval is the wait before the first retry, doubling up to
+	// MaximumInterval.
+	InitialInterval time.Duration
+	MaximumInterval time.Duration
+	// MaximumAttempts caps how often the activity is attempted. Zero retries until it
+	// succeeds.
+	MaximumAttempts int
+	// NonRetryableErrorTypes lists the application error types that fail the activity
+	// without a retry.
+	NonRetryableErrorTypes []string
+}
+
+// retryPolicy returns the Temporal retry policy of p.
+func (p ActivityPolicy) retryPolicy() temporal.RetryPolicy {
+	return temporal.RetryPolicy{
+		InitialInterval:        p.InitialInterval,
+		BackoffCoefficient:     activityBackoff,
+		MaximumInterval:        p.MaximumInterval,
+		MaximumAttempts:        int32(p.MaximumAttempts),
+		NonRetryableErrorTypes: p.NonRetryableErrorTypes,
+	}
+}
+
+var (
+	// defaultActivityPolicy applies to activities not in activityPolicies. They are
+	// retried until they succeed, so a database blip only delays them.
+	defaultActivityPolicy = ActivityPolicy{StartToClose: 10 * time.Second, InitialInterval: time.Second, MaximumInterval: time.Minute}
+
+	// saveActivityPolicy retries saves from a bill's workflow with backoff, but bounded,
+	// so that a persistent failure is compensated for rather than blocking the bill.
+	saveActivityPolicy = ActivityPolicy{StartToClose: 10 * time.Second, InitialInterval: time.Second, MaximumInterval: 10 * time.Second, MaximumAttempts: saveMaxAttempts}
+
+	// gatewayActivityPolicy retries payment gateway calls, except declines.
+	gatewayActivityPolicy = ActivityPolicy{StartToClose: 30 * time.Second, InitialInterval: time.Second, MaximumInterval: time.Minute, MaximumAttempts: DefaultPaymentMaxAttempts, NonRetryableErrorTypes: []string{PaymentDeclinedErrorType}}
+)
+
+// pageActivityPolicy returns the policy of an activity working through a page of bills
+// or line items for a job or sweep, which heartbeats if heartbeatTimeout is set.
+func pageActivityPolicy(startToClose, heartbeatTimeout time.Duration) ActivityPolicy {
+	return ActivityPolicy{StartToClose: startToClose, Heartbeat: heartbeatTimeout, InitialInterval: 10 * time.Second, MaximumAttempts: 5}
+}
+
+// activityPolicies holds the policies of activities that differ from
+// defaultActivityPolicy. FEES_ACTIVITY_POLICIES overrides them per deployment.
+var activityPolicies = map[string]ActivityPolicy{
+	SaveLineItemActivityName:           saveActivityPolicy,
+	SaveAggregatedLineItemActivityName: saveActivityPolicy,
+	SavePayerSharesActivityName:        saveActivityPolicy,
+	ApplyCreditActivityName:            saveActivityPolicy,
+	CarryForwardCreditActivityName:     saveActivityPolicy,
+	// A bill is only closed once its close is saved, so closes ride out longer outages
+	// than line items before the bill moves to CLOSE_FAILED.
+	UpdateBillOnCloseActivityName: {StartToClose: 10 * time.Second, InitialInterval: time.Second, MaximumInterval: 30 * time.Second, MaximumAttempts: 8},
+
+	ChargePaymentActivityName: gatewayActivityPolicy,
+	RefundPaymentActivityName: gatewayActivityPolicy,
+	// Emails are retried for a few minutes; a bill that still could not be emailed can
+	// be resent.
+	DeliverBillActivityName: {StartToClose: time.Minute, InitialInterval: 10 * time.Second, MaximumInterval: 2 * time.Minute, MaximumAttempts: 5, NonRetryableErrorTypes: []string{EmailRejectedErrorType}},
+
+	RollUpSubBillActivityName: {StartToClose: 10 * time.Second, InitialInterval: time.Second, MaximumInterval: time.Minute, MaximumAttempts: 10},
+	UpdateJobActivityName:     {StartToClose: 10 * time.Second, InitialInterval: time.Second, MaximumInterval: time.Minute, MaximumAttempts: 10},
+
+	FindCloseBatchBillsActivityName:  pageActivityPolicy(time.Minute, 0),
+	SweepBillsActivityName:           pageActivityPolicy(5*time.Minute, 30*time.Second),
+	RebuildBillSummariesActivityName: pageActivityPolicy(2*time.Minute, 30*time.Second),
+	EncryptLineItemsActivityName:     pageActivityPolicy(2*time.Minute, 30*time.Second),
+	PurgeExpiredBillsActivityName:    pageActivityPolicy(10*time.Minute, time.Minute),
+	ArchiveBillsActivityName:         pageActivityPolicy(10*time.Minute, time.Minute),
+	SnapshotAccrualsActivityName:     pageActivityPolicy(5*time.Minute, 30*time.Second),
+	PurgeTestBillsActivityName:       pageActivityPolicy(10*time.Minute, time.Minute),
+}
+
+// policyFor returns the policy of activityType, applying overrides.
+func policyFor(activityType string, overrides map[string]ActivityPolicy) ActivityPolicy {
+	if p, ok := overrides[activityType]; ok {
+		return p
+	}
+	if p, ok := activityPolicies[activityType]; ok {
+		return p
+	}
+	return defaultActivityPolicy
+}
+
+// parseActivityPolicies parses FEES_ACTIVITY_POLICIES,
+// "<activity>=<setting>:<value>;...,<activity>=...", into the policies it overrides.
+// Settings left out keep the activity's policy. The settings are startToClose,
+// heartbeat, initialInterval, maxInterval, maxAttempts, and nonRetryable, a
+// '|'-separated list of error types.
+func parseActivityPolicies(v string) (map[string]ActivityPolicy, error) {
+	if v == "" {
+		return nil, nil
+	}
+	policies := make(map[string]ActivityPolicy)
+	for _, entry := range strings.Split(v, ",") {
+		name, settings, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || !strings.HasSuffix(name, "Activity") || settings == "" {
+			return nil, fmt.Errorf("invalid entry %q: must be <activity>=<setting>:<value>;...", entry)
+		}
+		if _, dup := policies[name]; dup {
+			return nil, fmt.Errorf("%s is listed more than once", name)
+		}
+		p := policyFor(name, nil)
+		heartbeats := p.Heartbeat > 0
+		for _, setting := range strings.Split(settings, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(setting), ":")
+			if !ok {
+				return nil, fmt.Errorf("%s: invalid setting %q: must be <setting>:<value>", name, setting)
+			}
+			var err error
+			switch key {
+			case "startToClose":
+				p.StartToClose, err = parsePositiveDuration(value)
+			case "heartbeat":
+				if !heartbeats {
+					return nil, fmt.Errorf("%s does not heartbeat, so it cannot have a heartbeat timeout", name)
+				}
+				p.Heartbeat, err = parsePositiveDuration(value)
+			case "initialInterval":
+				p.InitialInterval, err = parsePositiveDuration(value)
+			case "maxInterval":
+				p.MaximumInterval, err = parsePositiveDuration(value)
+			case "maxAttempts":
+				p.MaximumAttempts, err = strconv.Atoi(value)
+				if err == nil && p.MaximumAttempts < 0 {
+					err = fmt.Errorf("must not be negative")
+				}
+			case "nonRetryable":
+				p.NonRetryableErrorTypes = strings.Split(value, "|")
+			default:
+				return nil, fmt.Errorf("%s: unknown setting %q", name, key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid %s %q: %w", name, key, value, err)
+			}
+		}
+		if p.Heartbeat >= p.StartToClose && p.Heartbeat > 0 {
+			return nil, fmt.Errorf("%s: heartbeat %s must be shorter than startToClose %s", name, p.Heartbeat, p.StartToClose)
+		}
+		if p.MaximumInterval > 0 && p.MaximumInterval < p.InitialInterval {
+			return nil, fmt.Errorf("%s: maxInterval %s must not be shorter than initialInterval %s", name, p.MaximumInterval, p.InitialInterval)
+		}
+		policies[name] = p
+	}
+	return policies, nil
+}
+
+// parsePositiveDuration parses a duration greater than zero.
+func parsePositiveDuration(v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return d, nil
+}
+
+// ------ Workflow side: per-type policies ------
+
+// activityOptionsInterceptor applies the policy of each activity a workflow starts, so
+// workflows do not set timeouts or retries themselves. A workflow that needs other
+// retries for one call, such as a payment's own attempts, sets a retry policy on its
+// context, which is kept. Neither timeouts nor retries are part of a workflow's
+// determinism, so changing them does not break running workflows.
+type activityOptionsInterceptor struct {
+	interceptor.WorkerInterceptorBase
+	overrides map[string]ActivityPolicy
+}
+
+// newActivityOptionsInterceptor returns the interceptor applying activityPolicies, with
+// overrides taking precedence.
+func newActivityOptionsInterceptor(overrides map[string]ActivityPolicy) *activityOptionsInterceptor {
+	return &activityOptionsInterceptor{overrides: overrides}
+}
+
+func (i *activityOptionsInterceptor) InterceptWorkflow(ctx workflow.Context, next interceptor.WorkflowInboundInterceptor) interceptor.WorkflowInboundInterceptor {
+	return &activityOptionsInbound{WorkflowInboundInterceptorBase: interceptor.WorkflowInboundInterceptorBase{Next: next}, overrides: i.overrides}
+}
+
+type activityOptionsInbound struct {
+	interceptor.WorkflowInboundInterceptorBase
+	overrides map[string]ActivityPolicy
+}
+
+func (i *activityOptionsInbound) Init(outbound interceptor.WorkflowOutboundInterceptor) error {
+	return i.Next.Init(&activityOptionsOutbound{WorkflowOutboundInterceptorBase: interceptor.WorkflowOutboundInterceptorBase{Next: outbound}, overrides: i.overrides})
+}
+
+type activityOptionsOutbound struct {
+	interceptor.WorkflowOutboundInterceptorBase
+	overrides map[string]ActivityPolicy
+}
+
+func (o *activityOptionsOutbound) ExecuteActivity(ctx workflow.Context, activityType string, args ...interface{}) workflow.Future {
+	p := policyFor(activityType, o.overrides)
+	ctx = workflow.WithStartToCloseTimeout(ctx, p.StartToClose)
+	ctx = workflow.WithHeartbeatTimeout(ctx, p.Heartbeat)
+	if workflow.GetActivityOptions(ctx).RetryPolicy == nil {
+		ctx = workflow.WithRetryPolicy(ctx, p.retryPolicy())
+	}
+	return o.Next.ExecuteActivity(ctx, activityType, args...)
+}
+
+// ------ Activity side: heartbeats and cancellation ------
+
+// heartbeat records the progress of a long-running activity, such as the last row it
+// handled, and returns ctx's error once the activity should stop. Temporal only
+// delivers the cancellation of an activity, or of its workflow, to an activity that
+// heartbeats. Outside an activity, as in tests, it only checks ctx.
+func heartbeat(ctx context.Context, details ...interface{}) error {
+	if activity.IsActivity(ctx) {
+		activity.RecordHeartbeat(ctx, details...)
+	}
+	return ctx.Err()
+}
+
+// keepAlive heartbeats a long-running activity in the background while it waits on
+// work that cannot report progress, such as one long query. Heartbeats stop when the
+// returned func is called or ctx is done; queries running on ctx then fail with its
+// error.
+func keepAlive(ctx context.Context) (stop func()) {
+	if !activity.IsActivity(ctx) {
+		return func() {}
+	}
+	interval := defaultHeartbeatInterval
+	if timeout := activity.GetInfo(ctx).HeartbeatTimeout; timeout > 0 {
+		// Heartbeating three times per timeout tolerates a late or lost heartbeat.
+		interval = timeout / 3
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				activity.RecordHeartbeat(ctx)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
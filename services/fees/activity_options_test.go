@@ -0,0 +1,128 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+)
+
+// TestActivityOptionsInterceptor tests that activities get the timeouts of their type,
+// and the default without a policy of their own, though the workflow sets none.
+func TestActivityOptionsInterceptor(t *testing.T) {
+	timeouts := func(ctx context.Context) ([]time.Duration, error) {
+		info := activity.GetInfo(ctx)
+		return []time.Duration{info.Deadline.Sub(info.StartedTime), info.HeartbeatTimeout}, nil
+	}
+	callsActivity := func(ctx workflow.Context, activityType string) ([]time.Duration, error) {
+		var result []time.Duration
+		err := workflow.ExecuteActivity(ctx, activityType).Get(ctx, &result)
+		return result, err
+	}
+
+	for activityType, want := range map[string][]time.Duration{
+		SweepBillsActivityName:    {5 * time.Minute, 30 * time.Second},
+		ChargePaymentActivityName: {30 * time.Second, 0},
+		UpsertBillActivityName:    {defaultActivityPolicy.StartToClose, 0},
+		UpdateJobActivityName:     {time.Minute, 0},
+	} {
+		var suite testsuite.WorkflowTestSuite
+		env := suite.NewTestWorkflowEnvironment()
+		overrides := map[string]ActivityPolicy{UpdateJobActivityName: {StartToClose: time.Minute}}
+		env.SetWorkerOptions(worker.Options{Interceptors: []interceptor.WorkerInterceptor{newActivityOptionsInterceptor(overrides)}})
+		env.RegisterActivityWithOptions(timeouts, activity.RegisterOptions{Name: activityType})
+		env.RegisterWorkflowWithOptions(callsActivity, workflow.RegisterOptions{Name: "CallsActivity"})
+		env.ExecuteWorkflow("CallsActivity", activityType)
+		require.True(t, env.IsWorkflowCompleted())
+		require.NoError(t, env.GetWorkflowError())
+		var got []time.Duration
+		require.NoError(t, env.GetWorkflowResult(&got))
+		require.Equal(t, want, got, activityType)
+	}
+}
+
+// TestActivityOptionsInterceptor_Retries tests that activities are retried by the
+// policy of their type unless the workflow set one, and not for non-retryable errors.
+func TestActivityOptionsInterceptor_Retries(t *testing.T) {
+	run := func(overrides map[string]ActivityPolicy, retry *temporal.RetryPolicy, err error) int {
+		attempts := 0
+		failing := func(ctx context.Context) error {
+			attempts++
+			return err
+		}
+		callsActivity := func(ctx workflow.Context) error {
+			if retry != nil {
+				ctx = workflow.WithRetryPolicy(ctx, *retry)
+			}
+			return workflow.ExecuteActivity(ctx, UpdateJobActivityName).Get(ctx, nil)
+		}
+		var suite testsuite.WorkflowTestSuite
+		env := suite.NewTestWorkflowEnvironment()
+		env.SetWorkerOptions(worker.Options{Interceptors: []interceptor.WorkerInterceptor{newActivityOptionsInterceptor(overrides)}})
+		env.RegisterActivityWithOptions(failing, activity.RegisterOptions{Name: UpdateJobActivityName})
+		env.RegisterWorkflowWithOptions(callsActivity, workflow.RegisterOptions{Name: "CallsActivity"})
+		env.ExecuteWorkflow("CallsActivity")
+		require.Error(t, env.GetWorkflowError())
+		return attempts
+	}
+
+	transient := errors.New("connection reset")
+	require.Equal(t, activityPolicies[UpdateJobActivityName].MaximumAttempts, run(nil, nil, transient))
+
+	overrides, err := parseActivityPolicies("UpdateJobActivity=maxAttempts:3;nonRetryable:Fatal")
+	require.NoError(t, err)
+	require.Equal(t, 3, run(overrides, nil, transient))
+	require.Equal(t, 1, run(overrides, nil, temporal.NewApplicationError("bad job", "Fatal")))
+	require.Equal(t, 2, run(overrides, &temporal.RetryPolicy{MaximumAttempts: 2}, transient))
+}
+
+// TestParseActivityPolicies tests that overrides keep the settings they leave out, and
+// that invalid ones are rejected.
+func TestParseActivityPolicies(t *testing.T) {
+	policies, err := parseActivityPolicies("UpdateBillOnCloseActivity=maxAttempts:12;maxInterval:1m, SweepBillsActivity=heartbeat:1m;startToClose:10m")
+	require.NoError(t, err)
+	closePolicy := policies[UpdateBillOnCloseActivityName]
+	require.Equal(t, 12, closePolicy.MaximumAttempts)
+	require.Equal(t, time.Minute, closePolicy.MaximumInterval)
+	require.Equal(t, activityPolicies[UpdateBillOnCloseActivityName].StartToClose, closePolicy.StartToClose)
+	require.Equal(t, time.Minute, policies[SweepBillsActivityName].Heartbeat)
+	require.Equal(t, 10*time.Minute, policies[SweepBillsActivityName].StartToClose)
+
+	policies, err = parseActivityPolicies("")
+	require.NoError(t, err)
+	require.Nil(t, policies)
+
+	for _, v := range []string{
+		"UpdateBillOnClose=maxAttempts:3",
+		"UpdateBillOnCloseActivity",
+		"UpdateBillOnCloseActivity=retries:3",
+		"UpdateBillOnCloseActivity=maxAttempts:-1",
+		"UpdateBillOnCloseActivity=startToClose:0s",
+		"UpdateBillOnCloseActivity=heartbeat:5s",
+		"SweepBillsActivity=heartbeat:10m",
+		"UpdateBillOnCloseActivity=initialInterval:1m;maxInterval:10s",
+		"UpdateJobActivity=maxAttempts:3,UpdateJobActivity=maxAttempts:4",
+	} {
+		_, err := parseActivityPolicies(v)
+		require.Error(t, err, v)
+	}
+}
+
+// TestHeartbeat tests that heartbeat reports when an activity should stop, also outside
+// an activity.
+func TestHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, heartbeat(ctx, "bill-1"))
+	keepAlive(ctx)()
+
+	cancel()
+	require.ErrorIs(t, heartbeat(ctx, "bill-2"), context.Canceled)
+}
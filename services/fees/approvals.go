@@ -0,0 +1,258 @@
+package fees
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"encore.app/apierr"
+	"go.temporal.io/sdk/workflow"
+)
+
+// ApprovalPolicy decides which bills need approval before they finalize.
+type ApprovalPolicy struct {
+	// Threshold is the total at or above which a bill is held for approval. Zero
+	// disables approvals.
+	Threshold float64
+	// EscalateAfter is how long a bill may wait for a decision before approvers are
+	// reminded through the escalation notification. Zero never escalates.
+	EscalateAfter time.Duration
+}
+
+// requires reports whether a bill totalling total must be approved.
+func (p ApprovalPolicy) requires(total float64) bool {
+	return p.Threshold > 0 && total >= p.Threshold
+}
+
+// ApprovalDecision is the outcome of a bill's approval.
+type ApprovalDecision string
+
+const (
+	ApprovalApproved ApprovalDecision = "approved"
+	ApprovalRejected ApprovalDecision = "rejected"
+)
+
+// BillApproval records a bill's hold for approval and its outcome.
+type BillApproval struct {
+	RequestedAt time.Time `json:"requestedAt"`
+	// Threshold and Total are the approval threshold and the bill's total, including
+	// any adjustment against the customer's bill limits, when approval was requested.
+	Threshold float64 `json:"threshold"`
+	Total     float64 `json:"total"`
+	// EscalatesAt is when approvers are reminded if no decision has been made.
+	EscalatesAt *time.Time `json:"escalatesAt,omitempty"`
+	EscalatedAt *time.Time `json:"escalatedAt,omitempty"`
+	// Decision, DecidedAt, DecidedByKeyID, and Reason are set once the bill is
+	// approved or rejected.
+	Decision       ApprovalDecision `json:"decision,omitempty"`
+	DecidedAt      *time.Time       `json:"decidedAt,omitempty"`
+	DecidedByKeyID string           `json:"decidedByKeyId,omitempty"`
+	Reason         string           `json:"reason,omitempty"`
+}
+
+// ApproveBillSignal approves a bill held for approval, finalizing it.
+type ApproveBillSignal struct {
+	// RequestedByKeyID is the API key that approved the bill.
+	RequestedByKeyID string
+	// IfVersion, when set, drops the signal unless the bill is at this version.
+	IfVersion int64
+}
+
+// RejectBillSignal rejects a bill held for approval, reopening it for corrections.
+type RejectBillSignal struct {
+	Reason string
+	// RequestedByKeyID is the API key that rejected the bill.
+	RequestedByKeyID string
+	// IfVersion, when set, drops the signal unless the bill is at this version.
+	IfVersion int64
+}
+
+// ------ Workflow ------
+
+// requestApproval holds the bill for approval instead of closing it, notifies
+// approvers, and starts the escalation timer.
+func (w *billWorkflow) requestApproval(total float64) {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	policy := w.params.Approval
+	approval := &BillApproval{
+		RequestedAt: workflow.Now(ctx),
+		Threshold:   policy.Threshold,
+		Total:       total,
+	}
+	if policy.EscalateAfter > 0 {
+		escalatesAt := approval.RequestedAt.Add(policy.EscalateAfter)
+		approval.EscalatesAt = &escalatesAt
+		timerCtx, cancel := workflow.WithCancel(ctx)
+		w.approvalTimer, w.cancelApprovalTimer = workflow.NewTimer(timerCtx, policy.EscalateAfter), cancel
+	}
+	bill.Approval = approval
+	w.setStatusBy(BillStatusPendingApproval, "", approval)
+	logger.Info("Bill held for approval", "bill_id", bill.ID, "total", total, "threshold", policy.Threshold, "escalates_at", approval.EscalatesAt)
+
+	notify(ctx, Notification{
+		Event:      NotificationBillApprovalRequested,
+		BillID:     bill.ID,
+		CustomerID: bill.CustomerID,
+		Message:    fmt.Sprintf("Bill total of %.2f %s reaches the approval threshold of %.2f and awaits approval.", total, bill.Currency, policy.Threshold),
+	})
+}
+
+// escalateApproval reminds approvers of a bill that is still waiting for a decision.
+func (w *billWorkflow) escalateApproval() {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	w.approvalTimer, w.cancelApprovalTimer = nil, nil
+	if bill.Status != BillStatusPendingApproval {
+		return
+	}
+	escalatedAt := workflow.Now(ctx)
+	bill.Approval.EscalatedAt = &escalatedAt
+	w.touch()
+	logger.Warn("Bill approval overdue, escalating", "bill_id", bill.ID, "requested_at", bill.Approval.RequestedAt)
+
+	notify(ctx, Notification{
+		Event:      NotificationBillApprovalEscalated,
+		BillID:     bill.ID,
+		CustomerID: bill.CustomerID,
+		Message:    fmt.Sprintf("Bill total of %.2f %s has been awaiting approval since %s.", bill.Approval.Total, bill.Currency, bill.Approval.RequestedAt.Format(time.RFC3339)),
+	})
+}
+
+// approve finalizes a bill held for approval.
+func (w *billWorkflow) approve(signal ApproveBillSignal) {
+	if w.outdated(ApproveBillSignalName, signal.IfVersion) {
+		return
+	}
+	if !w.decide(ApprovalApproved, signal.RequestedByKeyID, "") {
+		return
+	}
+	w.closeRequestedBy = signal.RequestedByKeyID
+	w.close()
+}
+
+// reject reopens a bill held for approval so it can be corrected and closed again.
+func (w *billWorkflow) reject(signal RejectBillSignal) {
+	bill := w.bill
+	if w.outdated(RejectBillSignalName, signal.IfVersion) {
+		return
+	}
+	if !w.decide(ApprovalRejected, signal.RequestedByKeyID, signal.Reason) {
+		return
+	}
+	bill.CloseRequestedAt = nil
+	bill.FinalizesAt = nil
+	w.closeRequestedBy = ""
+	w.setStatusBy(BillStatusOpen, signal.RequestedByKeyID, bill.Approval)
+}
+
+// decide records an approval decision, stopping the escalation timer. It reports
+// false, changing nothing, if the bill is not awaiting approval.
+func (w *billWorkflow) decide(decision ApprovalDecision, keyID, reason string) bool {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	if bill.Status != BillStatusPendingApproval {
+		logger.Warn("Approval decision received for a bill not awaiting approval, ignoring.", "bill_id", bill.ID, "bill_status", bill.Status, "decision", decision)
+		return false
+	}
+	if w.cancelApprovalTimer != nil {
+		w.cancelApprovalTimer()
+	}
+	w.approvalTimer, w.cancelApprovalTimer = nil, nil
+
+	decidedAt := workflow.Now(ctx)
+	bill.Approval.Decision = decision
+	bill.Approval.DecidedAt = &decidedAt
+	bill.Approval.DecidedByKeyID = keyID
+	bill.Approval.Reason = reason
+	logger.Info("Bill approval decided", "bill_id", bill.ID, "decision", decision, "decided_by", keyID)
+	return true
+}
+
+// ------ API ------
+
+// ApproveBillRequest holds the optional parameters for approving a bill.
+type ApproveBillRequest struct {
+	// IfMatch, when set, approves the bill only if it is still at this version.
+	IfMatch string `header:"If-Match"`
+}
+
+// RejectBillRequest is the request payload for rejecting a bill held for approval.
+type RejectBillRequest struct {
+	// Reason tells whoever corrects the bill why it was rejected.
+	Reason string `json:"reason,omitempty"`
+	// IfMatch, when set, rejects the bill only if it is still at this version.
+	IfMatch string `header:"If-Match"`
+}
+
+// BillApprovalResponse is the response payload after approving or rejecting a bill.
+type BillApprovalResponse struct {
+	RetryMetadata
+	BillID          string        `json:"billId"`
+	Approval        *BillApproval `json:"approval"`
+	ConfirmationMsg string        `json:"confirmationMsg"`
+	// StateToken identifies the bill's state once this change is applied. Pass it to
+	// GetBill as minStateToken to read the bill with the change.
+	StateToken string `json:"stateToken,omitempty"`
+}
+
+// ApproveBill approves a bill held for approval, which then finalizes.
+//
+// encore:api auth method=POST path=/bills/:billID/approve
+func (s *Service) ApproveBill(ctx context.Context, billID string, params *ApproveBillRequest) (*BillApprovalResponse, error) {
+	ifVersion, err := parseIfMatch(params.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+	signal := ApproveBillSignal{RequestedByKeyID: callerKeyID(ctx), IfVersion: ifVersion}
+	return s.decideApproval(ctx, billID, ApprovalApproved, ifVersion, ApproveBillSignalName, signal)
+}
+
+// RejectBill rejects a bill held for approval, returning it to OPEN so it can be
+// corrected and closed again.
+//
+// encore:api auth method=POST path=/bills/:billID/reject
+func (s *Service) RejectBill(ctx context.Context, billID string, params *RejectBillRequest) (*BillApprovalResponse, error) {
+	ifVersion, err := parseIfMatch(params.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+	signal := RejectBillSignal{Reason: params.Reason, RequestedByKeyID: callerKeyID(ctx), IfVersion: ifVersion}
+	return s.decideApproval(ctx, billID, ApprovalRejected, ifVersion, RejectBillSignalName, signal)
+}
+
+// decideApproval delivers an approval decision to a bill awaiting approval, if it is at
+// ifVersion when set. Repeating the decision that was already made succeeds without
+// signaling again.
+func (s *Service) decideApproval(ctx context.Context, billID string, decision ApprovalDecision, ifVersion int64, signalName string, signal any) (*BillApprovalResponse, error) {
+	getResp, err := s.getBill(ctx, billID)
+	if err != nil {
+		return nil, err
+	}
+	bill := getResp.RetrievedBill
+
+	if bill.Status != BillStatusPendingApproval {
+		if bill.Approval != nil && bill.Approval.Decision == decision {
+			return &BillApprovalResponse{
+				BillID:          billID,
+				Approval:        bill.Approval,
+				ConfirmationMsg: "Bill was already " + string(decision) + ".",
+				StateToken:      stateToken(bill.Version),
+			}, nil
+		}
+		return nil, apierr.FailedPrecondition(apierr.BillNotPendingApproval, "bill %s is %s and not awaiting approval", billID, bill.Status)
+	}
+	if err := checkVersion(&bill, ifVersion); err != nil {
+		return nil, err
+	}
+
+	if err := s.temporalClient.SignalWorkflow(ctx, billWorkflowID(billID), "", signalName, signal); err != nil {
+		return nil, apierr.FromTemporal(err, apierr.BillNotFound, "bill %s not found", billID)
+	}
+	return &BillApprovalResponse{
+		BillID:          billID,
+		Approval:        bill.Approval,
+		ConfirmationMsg: "Bill " + string(decision) + ".",
+		StateToken:      nextStateToken(&bill),
+	}, nil
+}
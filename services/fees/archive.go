@@ -0,0 +1,506 @@
+package fees
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"encore.app/apierr"
+	"encore.app/locale"
+	"encore.dev/storage/objects"
+	"encore.dev/storage/sqldb"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// ArchiveSweepScheduleID names the Temporal Schedule that starts ArchiveSweepWorkflow.
+	ArchiveSweepScheduleID = "archive-sweep"
+	// ArchiveBillsActivityName archives one batch of settled bills.
+	ArchiveBillsActivityName = "ArchiveBillsActivity"
+
+	// defaultArchiveSweepSchedule runs the archive sweep nightly at 02:30 UTC, after the
+	// close sweep and before the retention sweep.
+	defaultArchiveSweepSchedule = "30 2 * * *"
+	// archiveBatchSize is how many bills an ArchiveBillsActivity run archives.
+	archiveBatchSize = 100
+	// archiveDocumentVersion is the format of archived bills.
+	archiveDocumentVersion = 1
+	// archiveDownloadTTL is how long a signed download URL of an archive stays valid.
+	archiveDownloadTTL = 15 * time.Minute
+
+	// ArchivingDisabledErrorType is the application error type of an
+	// ArchiveBillsActivity run by a worker without archive signing keys.
+	ArchivingDisabledErrorType = "ArchivingDisabled"
+)
+
+// archiveBucket holds archived bills, one signed JSON document per bill.
+var archiveBucket = objects.NewBucket("bill-archives", objects.BucketConfig{})
+
+// archiveObject is the name of the object holding a bill's archive.
+func archiveObject(billID string) string {
+	return "bills/" + billID + ".json"
+}
+
+// BillArchive records where a settled bill was archived.
+type BillArchive struct {
+	BillID     string `json:"billId"`
+	TenantID   string `json:"tenantId"`
+	CustomerID string `json:"customerId,omitempty"`
+	// Object names the archive in the bill-archives bucket.
+	Object string `json:"object"`
+	// EventSequence is the last of the bill's events the archive holds.
+	EventSequence int64 `json:"eventSequence"`
+	// KeyID names the key that signed the archive, and Signature is its base64
+	// HMAC-SHA256 of the archived document.
+	KeyID      string    `json:"keyId"`
+	Signature  string    `json:"signature"`
+	Size       int64     `json:"size"`
+	ArchivedAt time.Time `json:"archivedAt"`
+}
+
+// BillArchiveResponse reports where a bill was archived.
+type BillArchiveResponse struct {
+	Archive BillArchive `json:"archive"`
+	// DownloadURL is a short-lived signed URL of the archive, valid until ExpiresAt.
+	DownloadURL string    `json:"downloadUrl"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// archiveDocument is an archived bill: the bill as it closed and was settled, the
+// events that led there, and the invoice the customer was shown.
+type archiveDocument struct {
+	Version     int         `json:"version"`
+	ArchivedAt  time.Time   `json:"archivedAt"`
+	Bill        Bill        `json:"bill"`
+	Events      []BillEvent `json:"events"`
+	InvoiceHTML string      `json:"invoiceHtml"`
+}
+
+// archiveEnvelope is the object stored for an archived bill. Signature covers the
+// document's JSON, which is encrypted with the field encryption key when line items
+// are, so the archive does not expose what the database does not.
+type archiveEnvelope struct {
+	KeyID             string          `json:"keyId"`
+	Signature         string          `json:"signature"`
+	Document          json.RawMessage `json:"document,omitempty"`
+	EncryptedDocument string          `json:"encryptedDocument,omitempty"`
+}
+
+// billArchiver archives settled bills to object storage and reads them back.
+type billArchiver struct {
+	db     *tracedDB
+	fields *fieldCipher
+	// keys sign archives with the first key and verify them with any.
+	keys []EncryptionKey
+	// clock dates the archives of the sweep.
+	clock Clock
+}
+
+// newBillArchiver returns an archiver signing with keys, or nil for no keys.
+func newBillArchiver(db *tracedDB, fields *fieldCipher, keys []EncryptionKey, clock Clock) *billArchiver {
+	if len(keys) == 0 {
+		return nil
+	}
+	return &billArchiver{db: db, fields: fields, keys: keys, clock: clock}
+}
+
+// archiveSignature returns the base64 HMAC-SHA256 of data under key.
+func archiveSignature(key, data []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// seal encodes doc as a signed envelope, and returns it with its signature.
+func (a *billArchiver) seal(doc *archiveDocument) ([]byte, string, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode archive: %w", err)
+	}
+	envelope := archiveEnvelope{KeyID: a.keys[0].ID, Signature: archiveSignature(a.keys[0].Key, data)}
+	if a.fields == nil {
+		envelope.Document = data
+	} else if envelope.EncryptedDocument, err = a.fields.encrypt(string(data)); err != nil {
+		return nil, "", fmt.Errorf("failed to encrypt archive: %w", err)
+	}
+	sealed, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode archive: %w", err)
+	}
+	return sealed, envelope.Signature, nil
+}
+
+// open verifies the signature of a sealed archive and decodes its document.
+func (a *billArchiver) open(sealed []byte) (*archiveDocument, error) {
+	var envelope archiveEnvelope
+	if err := json.Unmarshal(sealed, &envelope); err != nil {
+		return nil, fmt.Errorf("archive is malformed: %w", err)
+	}
+	data := []byte(envelope.Document)
+	if envelope.EncryptedDocument != "" {
+		plaintext, err := a.fields.decrypt(envelope.EncryptedDocument)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt archive: %w", err)
+		}
+		data = []byte(plaintext)
+	}
+	var key []byte
+	for _, k := range a.keys {
+		if k.ID == envelope.KeyID {
+			key = k.Key
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("archive was signed with unknown key %q", envelope.KeyID)
+	}
+	if !hmac.Equal([]byte(archiveSignature(key, data)), []byte(envelope.Signature)) {
+		return nil, fmt.Errorf("archive signature does not match its document")
+	}
+	var doc archiveDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("archive is malformed: %w", err)
+	}
+	return &doc, nil
+}
+
+// document assembles the archive of a bill from the database: the bill and its line
+// items, its payments and credit notes replayed from its events, and its invoice
+// rendered with the tenant's template. It returns nil if the bill has not been saved.
+func (a *billArchiver) document(ctx context.Context, billID string, archivedAt time.Time) (*archiveDocument, error) {
+	bill, err := loadStaleBill(ctx, a.db, a.fields, billID)
+	if bill == nil || err != nil {
+		return nil, err
+	}
+	bill.Stale = false
+	events, err := loadBillEvents(ctx, a.db, a.fields, billID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load events of bill %s: %w", billID, err)
+	}
+	// Bills saved before the event stream was recorded keep what their row holds.
+	if len(events) > 0 {
+		replayed, err := replayBill(billID, events)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replay bill %s: %w", billID, err)
+		}
+		bill.Payments, bill.Allocations, bill.AmountPaid, bill.BalanceDue = replayed.Payments, replayed.Allocations, replayed.AmountPaid, replayed.BalanceDue
+		bill.RefundedAmount, bill.CreditNotes, bill.Approval = replayed.RefundedAmount, replayed.CreditNotes, replayed.Approval
+	}
+	settings, err := loadBillHTMLSettings(ctx, a.db, bill.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bill HTML settings of tenant %s: %w", bill.TenantID, err)
+	}
+	invoice, err := renderBillHTML(ctx, bill, locale.Default, settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render bill %s: %w", billID, err)
+	}
+	return &archiveDocument{
+		Version:     archiveDocumentVersion,
+		ArchivedAt:  archivedAt,
+		Bill:        *bill,
+		Events:      events,
+		InvoiceHTML: string(invoice),
+	}, nil
+}
+
+// archive writes the archive of a bill and records where. A bill archived before is
+// archived again, replacing the earlier archive. It returns nil if the bill has not
+// been saved.
+func (a *billArchiver) archive(ctx context.Context, billID string, now time.Time) (*BillArchive, error) {
+	doc, err := a.document(ctx, billID, now)
+	if doc == nil || err != nil {
+		return nil, err
+	}
+	sealed, signature, err := a.seal(doc)
+	if err != nil {
+		return nil, err
+	}
+	record := &BillArchive{
+		BillID:     billID,
+		TenantID:   doc.Bill.TenantID,
+		CustomerID: doc.Bill.CustomerID,
+		Object:     archiveObject(billID),
+		KeyID:      a.keys[0].ID,
+		Signature:  signature,
+		Size:       int64(len(sealed)),
+		ArchivedAt: now,
+	}
+	if n := len(doc.Events); n > 0 {
+		record.EventSequence = doc.Events[n-1].Sequence
+	}
+
+	w := archiveBucket.Upload(ctx, record.Object, objects.WithUploadAttrs(objects.UploadAttrs{ContentType: "application/json"}))
+	if _, err := w.Write(sealed); err != nil {
+		w.Abort(err)
+		return nil, fmt.Errorf("failed to store archive of bill %s: %w", billID, err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to store archive of bill %s: %w", billID, err)
+	}
+	_, err = a.db.Exec(ctx, `
+        INSERT INTO bill_archives (bill_id, tenant_id, customer_id, object, event_sequence, key_id, signature, size_bytes, archived_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        ON CONFLICT (bill_id) DO UPDATE SET
+            tenant_id = EXCLUDED.tenant_id,
+            customer_id = EXCLUDED.customer_id,
+            event_sequence = EXCLUDED.event_sequence,
+            key_id = EXCLUDED.key_id,
+            signature = EXCLUDED.signature,
+            size_bytes = EXCLUDED.size_bytes,
+            archived_at = EXCLUDED.archived_at
+    `, record.BillID, record.TenantID, record.CustomerID, record.Object, record.EventSequence,
+		record.KeyID, record.Signature, record.Size, record.ArchivedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record archive of bill %s: %w", billID, err)
+	}
+	return record, nil
+}
+
+const billArchiveColumns = `bill_id, tenant_id, customer_id, object, event_sequence, key_id, signature, size_bytes, archived_at`
+
+func scanBillArchive(row interface{ Scan(...any) error }) (*BillArchive, error) {
+	var r BillArchive
+	err := row.Scan(&r.BillID, &r.TenantID, &r.CustomerID, &r.Object, &r.EventSequence, &r.KeyID, &r.Signature, &r.Size, &r.ArchivedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// load reads and verifies the archive of a bill. It returns nil if the bill was not
+// archived.
+func (a *billArchiver) load(ctx context.Context, billID string) (*BillArchive, *archiveDocument, error) {
+	record, err := scanBillArchive(a.db.QueryRow(ctx, `SELECT `+billArchiveColumns+` FROM bill_archives WHERE bill_id = $1`, billID))
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load archive of bill %s: %w", billID, err)
+	}
+	r := archiveBucket.Download(ctx, record.Object)
+	defer r.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		return nil, nil, fmt.Errorf("failed to read archive of bill %s: %w", billID, err)
+	}
+	doc, err := a.open(buf.Bytes())
+	if err != nil {
+		return nil, nil, fmt.Errorf("archive of bill %s: %w", billID, err)
+	}
+	return record, doc, nil
+}
+
+// removeCustomerArchives removes the archives of a tenant's customer, objects first, so
+// a removal that fails halfway finds the same archives again when retried. It needs no
+// signing keys, so archives are removed even after archiving was turned off.
+func removeCustomerArchives(ctx context.Context, db *tracedDB, tenantID, customerID string) error {
+	rows, err := db.Query(ctx, `SELECT object FROM bill_archives WHERE tenant_id = $1 AND customer_id = $2`, tenantID, customerID)
+	if err != nil {
+		return fmt.Errorf("failed to find archives: %w", err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to find archives: %w", err)
+	}
+	for _, name := range names {
+		if err := archiveBucket.Remove(ctx, name); err != nil && !errors.Is(err, objects.ErrObjectNotFound) {
+			return fmt.Errorf("failed to remove archive object %s: %w", name, err)
+		}
+	}
+	if _, err := db.Exec(ctx, `DELETE FROM bill_archives WHERE tenant_id = $1 AND customer_id = $2`, tenantID, customerID); err != nil {
+		return fmt.Errorf("failed to delete archives: %w", err)
+	}
+	return nil
+}
+
+// archivedBill reads a bill whose workflow Temporal no longer has from its archive, such
+// as one the retention sweep deleted. It returns nil unless the query failed with
+// NotFound and the bill was archived.
+func (s *Service) archivedBill(ctx context.Context, billID string, queryErr error) (*BillArchive, *Bill, error) {
+	var notFound *serviceerror.NotFound
+	if s.archiver == nil || !errors.As(queryErr, &notFound) {
+		return nil, nil, nil
+	}
+	record, doc, err := s.archiver.load(ctx, billID)
+	if record == nil || err != nil {
+		return nil, nil, err
+	}
+	return record, &doc.Bill, nil
+}
+
+// ------ API ------
+
+// GetBillArchive reports where a settled bill was archived, with a short-lived signed
+// URL of its archive. Archives outlive their bills, so this works after the retention
+// sweep deleted the bill.
+//
+// encore:api auth method=GET path=/bills/:billID/archive
+func (s *Service) GetBillArchive(ctx context.Context, billID string) (*BillArchiveResponse, error) {
+	record, err := scanBillArchive(s.db.QueryRow(ctx, `SELECT `+billArchiveColumns+` FROM bill_archives WHERE bill_id = $1`, billID))
+	if errors.Is(err, sqldb.ErrNoRows) || (err == nil && !visibleToCaller(ctx, record.TenantID)) {
+		return nil, apierr.NotFound(apierr.ArchiveNotFound, "bill %s has not been archived", billID)
+	}
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load archive of bill %s", billID)
+	}
+	signed, err := archiveBucket.SignedDownloadURL(ctx, record.Object, objects.WithTTL(archiveDownloadTTL))
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to sign download of archive of bill %s", billID)
+	}
+	return &BillArchiveResponse{Archive: *record, DownloadURL: signed.URL, ExpiresAt: s.clock.Now().Add(archiveDownloadTTL)}, nil
+}
+
+// ------ Archive sweep ------
+
+// ArchiveSweepParams configures an ArchiveSweepWorkflow run.
+type ArchiveSweepParams struct {
+	// AfterDays is how long after they close settled bills are archived.
+	AfterDays int
+}
+
+// ArchiveSweepResult counts what an ArchiveSweepWorkflow run archived.
+type ArchiveSweepResult struct {
+	Archived int `json:"archived"`
+	Failed   int `json:"failed"`
+}
+
+// ArchiveBillsActivityParams defines parameters for ArchiveBillsActivity.
+type ArchiveBillsActivityParams struct {
+	// Before is the archive cutoff: settled bills that closed before it are archived.
+	Before time.Time
+	// AfterID resumes after the last bill of the previous batch.
+	AfterID string
+	Limit   int
+}
+
+// ArchiveBillsActivityResult is the outcome of one batch of the archive sweep.
+type ArchiveBillsActivityResult struct {
+	Found    int
+	Archived int
+	Failed   int
+	LastID   string
+}
+
+// ArchiveSweepWorkflow archives settled bills that closed more than params.AfterDays
+// ago and have not been archived since their last event, in batches. It is started by
+// the archive sweep schedule.
+func ArchiveSweepWorkflow(ctx workflow.Context, params ArchiveSweepParams) (*ArchiveSweepResult, error) {
+	logger := workflow.GetLogger(ctx)
+
+	before := workflow.Now(ctx).AddDate(0, 0, -params.AfterDays)
+	result := &ArchiveSweepResult{}
+	afterID := ""
+	for {
+		var batch ArchiveBillsActivityResult
+		err := workflow.ExecuteActivity(ctx, ArchiveBillsActivityName, ArchiveBillsActivityParams{
+			Before:  before,
+			AfterID: afterID,
+			Limit:   archiveBatchSize,
+		}).Get(ctx, &batch)
+		if err != nil {
+			logger.Error("Failed to execute ArchiveBillsActivity", "before", before, "after_id", afterID, "error", err)
+			return result, err
+		}
+		result.Archived += batch.Archived
+		result.Failed += batch.Failed
+		if batch.Found < archiveBatchSize {
+			break
+		}
+		afterID = batch.LastID
+	}
+	logger.Info("Archive sweep finished", "before", before, "archived", result.Archived, "failed", result.Failed)
+	return result, nil
+}
+
+// ArchiveBillsActivity archives up to params.Limit settled bills that closed before
+// params.Before, after params.AfterID. A bill that fails to archive is logged and left
+// for the next sweep, so one bad bill does not hold up the rest.
+func (a *Activities) ArchiveBillsActivity(ctx context.Context, params ArchiveBillsActivityParams) (*ArchiveBillsActivityResult, error) {
+	if a.Archiver == nil {
+		return nil, temporal.NewNonRetryableApplicationError("archive signing keys are not configured", ArchivingDisabledErrorType, nil)
+	}
+	defer keepAlive(ctx)()
+	ids, err := queryBillIDs(ctx, a.DB, `
+        SELECT id FROM bills
+        WHERE `+settledBillCondition+` AND closed_at < $1 AND id > $2
+          AND NOT EXISTS (
+              SELECT 1 FROM bill_archives a
+              WHERE a.bill_id = bills.id
+                AND a.event_sequence >= (SELECT COALESCE(MAX(e.sequence), 0) FROM bill_events e WHERE e.bill_id = bills.id)
+          )
+        ORDER BY id
+        LIMIT $3
+    `, params.Before, params.AfterID, params.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("ArchiveBillsActivity: failed to find bills closed before %s: %w", params.Before, err)
+	}
+	result := &ArchiveBillsActivityResult{Found: len(ids)}
+	for _, id := range ids {
+		if _, err := a.Archiver.archive(ctx, id, a.Archiver.clock.Now()); err != nil {
+			loggerFrom(ctx).Error("Failed to archive bill", "bill_id", id, "error", err)
+			result.Failed++
+			continue
+		}
+		result.Archived++
+	}
+	if len(ids) > 0 {
+		result.LastID = ids[len(ids)-1]
+	}
+	return result, nil
+}
+
+// ensureArchiveSweepSchedule creates the archive sweep schedule, or brings an existing
+// one in line with cfg. A schedule left from before archiving was turned off is
+// deleted.
+func ensureArchiveSweepSchedule(ctx context.Context, schedules client.ScheduleClient, cfg *Config) error {
+	if cfg.ArchiveAfterDays == 0 || cfg.ArchiveSweepSchedule == closeSweepOff {
+		err := schedules.GetHandle(ctx, ArchiveSweepScheduleID).Delete(ctx)
+		var notFound *serviceerror.NotFound
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return err
+	}
+	spec := client.ScheduleSpec{CronExpressions: []string{cfg.ArchiveSweepSchedule}}
+	action := &client.ScheduleWorkflowAction{
+		ID:        ArchiveSweepScheduleID,
+		Workflow:  ArchiveSweepWorkflow,
+		Args:      []interface{}{ArchiveSweepParams{AfterDays: cfg.ArchiveAfterDays}},
+		TaskQueue: feesTaskQueue,
+	}
+	_, err := schedules.Create(ctx, client.ScheduleOptions{
+		ID:      ArchiveSweepScheduleID,
+		Spec:    spec,
+		Action:  action,
+		Overlap: enums.SCHEDULE_OVERLAP_POLICY_SKIP,
+		Note:    "Archives settled bills to object storage.",
+	})
+	if !errors.Is(err, temporal.ErrScheduleAlreadyRunning) {
+		return err
+	}
+	return schedules.GetHandle(ctx, ArchiveSweepScheduleID).Update(ctx, client.ScheduleUpdateOptions{
+		DoUpdate: func(input client.ScheduleUpdateInput) (*client.ScheduleUpdate, error) {
+			schedule := input.Description.Schedule
+			schedule.Spec, schedule.Action = &spec, action
+			return &client.ScheduleUpdate{Schedule: &schedule}, nil
+		},
+	})
+}
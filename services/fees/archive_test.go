@@ -0,0 +1,96 @@
+package fees
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/mocks"
+)
+
+// testArchiveDocument returns an archived bill for tests.
+func testArchiveDocument() *archiveDocument {
+	closedAt := time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)
+	return &archiveDocument{
+		Version:    archiveDocumentVersion,
+		ArchivedAt: closedAt.AddDate(0, 0, 90),
+		Bill: Bill{
+			ID: "bill-1", TenantID: "acme", CustomerID: "cust-1", Currency: "USD", Status: BillStatusPaid,
+			LineItems:   []LineItem{{ID: "item-1", Description: "API calls", Amount: 12.5}},
+			TotalAmount: 12.5, ClosedAt: &closedAt,
+		},
+		Events:      []BillEvent{{Sequence: 1, Type: AuditBillCreated, OccurredAt: closedAt}},
+		InvoiceHTML: "<html>bill-1</html>",
+	}
+}
+
+// TestBillArchiverSeal tests that archives round-trip through signing, encrypted when
+// line items are, that rotated keys still verify old archives, and that tampered or
+// unknown-key archives are rejected.
+func TestBillArchiverSeal(t *testing.T) {
+	oldKeys, err := parseEncryptionKeys(testEncryptionKey("s1", 1))
+	require.NoError(t, err)
+	rotated, err := parseEncryptionKeys(testEncryptionKey("s2", 2) + "," + testEncryptionKey("s1", 1))
+	require.NoError(t, err)
+	doc := testArchiveDocument()
+
+	old := newBillArchiver(nil, nil, oldKeys, systemClock{})
+	sealed, signature, err := old.seal(doc)
+	require.NoError(t, err)
+	require.NotEmpty(t, signature)
+	require.Contains(t, string(sealed), `"keyId":"s1"`)
+	opened, err := newBillArchiver(nil, nil, rotated, systemClock{}).open(sealed)
+	require.NoError(t, err)
+	require.Equal(t, doc, opened)
+
+	tampered := strings.Replace(string(sealed), "12.5", "1.5", 1)
+	_, err = old.open([]byte(tampered))
+	require.ErrorContains(t, err, "signature")
+	_, err = newBillArchiver(nil, nil, rotated[:1], systemClock{}).open(sealed)
+	require.ErrorContains(t, err, "unknown key")
+
+	fieldKeys, err := parseEncryptionKeys(testEncryptionKey("f1", 3))
+	require.NoError(t, err)
+	fields, err := newFieldCipher(fieldKeys)
+	require.NoError(t, err)
+	encrypted := newBillArchiver(nil, fields, oldKeys, systemClock{})
+	sealed, _, err = encrypted.seal(doc)
+	require.NoError(t, err)
+	require.NotContains(t, string(sealed), "API calls")
+	var envelope archiveEnvelope
+	require.NoError(t, json.Unmarshal(sealed, &envelope))
+	require.Empty(t, envelope.Document)
+	opened, err = encrypted.open(sealed)
+	require.NoError(t, err)
+	require.Equal(t, doc, opened)
+	_, err = old.open(sealed)
+	require.Error(t, err)
+
+	require.Nil(t, newBillArchiver(nil, nil, nil, systemClock{}))
+}
+
+// TestEnsureArchiveSweepSchedule tests that the schedule is created with the configured
+// delay, and that turning archiving off deletes a schedule left behind.
+func TestEnsureArchiveSweepSchedule(t *testing.T) {
+	schedules := mocks.NewScheduleClient(t)
+	cfg := &Config{ArchiveAfterDays: 90, ArchiveSweepSchedule: defaultArchiveSweepSchedule}
+	schedules.On("Create", mock.Anything, mock.MatchedBy(func(o client.ScheduleOptions) bool {
+		action := o.Action.(*client.ScheduleWorkflowAction)
+		return o.ID == ArchiveSweepScheduleID && action.Args[0].(ArchiveSweepParams).AfterDays == 90
+	})).Return(mocks.NewScheduleHandle(t), nil).Once()
+	require.NoError(t, ensureArchiveSweepSchedule(context.Background(), schedules, cfg))
+
+	schedules = mocks.NewScheduleClient(t)
+	handle := mocks.NewScheduleHandle(t)
+	schedules.On("GetHandle", mock.Anything, ArchiveSweepScheduleID).Return(handle).Twice()
+	handle.On("Delete", mock.Anything).Return(nil).Once()
+	require.NoError(t, ensureArchiveSweepSchedule(context.Background(), schedules, &Config{ArchiveSweepSchedule: defaultArchiveSweepSchedule}))
+	handle.On("Delete", mock.Anything).Return(serviceerror.NewNotFound("schedule not found")).Once()
+	require.NoError(t, ensureArchiveSweepSchedule(context.Background(), schedules, &Config{ArchiveAfterDays: 90, ArchiveSweepSchedule: closeSweepOff}))
+}
@@ -0,0 +1,243 @@
+package fees
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/storage/objects"
+	"encore.dev/storage/sqldb"
+)
+
+const (
+	// maxAttachmentSize caps the size of one attachment.
+	maxAttachmentSize = 10 << 20
+	// maxAttachmentNameLength caps an attachment's file name.
+	maxAttachmentNameLength = 255
+	// attachmentDownloadTTL is how long a signed download URL stays valid.
+	attachmentDownloadTTL = 15 * time.Minute
+)
+
+// attachmentTypes are the content types bills accept as attachments. Uploads are
+// checked against their sniffed content, so a declared type cannot disguise another.
+var attachmentTypes = map[string]bool{
+	"application/pdf": true,
+	"image/png":       true,
+	"image/jpeg":      true,
+	"text/plain":      true,
+	"text/csv":        true,
+}
+
+// attachmentBucket stores the contents of bill attachments under bills/<billID>/<attachmentID>.
+var attachmentBucket = objects.NewBucket("bill-attachments", objects.BucketConfig{})
+
+// Attachment is a document attached to a bill, such as dispute evidence or a contract.
+type Attachment struct {
+	ID          string `json:"id"`
+	BillID      string `json:"billId"`
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	// SHA256 is the hex-encoded SHA-256 digest of the contents.
+	SHA256      string `json:"sha256"`
+	Description string `json:"description,omitempty"`
+	// UploadedByKeyID is the API key that attached the document.
+	UploadedByKeyID string    `json:"uploadedByKeyId,omitempty"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// AddAttachmentRequest is the request payload for attaching a document to a bill.
+type AddAttachmentRequest struct {
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType"`
+	// Content is the document itself, base64-encoded in JSON.
+	Content     []byte `json:"content"`
+	Description string `json:"description,omitempty"`
+}
+
+// AttachmentResponse is the response payload for an attachment.
+type AttachmentResponse struct {
+	RetryMetadata
+	Attachment Attachment `json:"attachment"`
+}
+
+// ListAttachmentsResponse is the response payload for a bill's attachments.
+type ListAttachmentsResponse struct {
+	BillID      string       `json:"billId"`
+	Attachments []Attachment `json:"attachments"`
+}
+
+// AttachmentDownloadResponse is the response payload for downloading an attachment.
+type AttachmentDownloadResponse struct {
+	// URL is a signed URL the attachment can be downloaded from until ExpiresAt.
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// checkAttachment enforces the attachment size and type policy and returns the
+// normalized content type.
+func checkAttachment(req *AddAttachmentRequest) (string, error) {
+	if strings.TrimSpace(req.FileName) == "" || len(req.FileName) > maxAttachmentNameLength || strings.ContainsAny(req.FileName, "/\\") {
+		return "", apierr.InvalidArgument(apierr.InvalidParameter, "fileName must be a file name of 1 to %d characters without path separators", maxAttachmentNameLength)
+	}
+	if len(req.Content) == 0 {
+		return "", apierr.InvalidArgument(apierr.AttachmentRejected, "attachment %s is empty", req.FileName)
+	}
+	if len(req.Content) > maxAttachmentSize {
+		return "", apierr.InvalidArgument(apierr.AttachmentRejected, "attachment %s is %d bytes; attachments may be at most %d bytes", req.FileName, len(req.Content), maxAttachmentSize)
+	}
+
+	declared, _, err := mime.ParseMediaType(req.ContentType)
+	if err != nil || !attachmentTypes[declared] {
+		return "", apierr.InvalidArgument(apierr.AttachmentRejected, "content type %q is not accepted; use PDF, PNG, JPEG, plain text or CSV", req.ContentType)
+	}
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(req.Content))
+	// CSV has no signature of its own and sniffs as plain text.
+	if sniffed != declared && !(declared == "text/csv" && sniffed == "text/plain") {
+		return "", apierr.InvalidArgument(apierr.AttachmentRejected, "attachment %s was declared as %s but its content is %s", req.FileName, declared, sniffed)
+	}
+	return declared, nil
+}
+
+// attachmentObject names the object holding an attachment's contents.
+func attachmentObject(billID, attachmentID string) string {
+	return "bills/" + billID + "/" + attachmentID
+}
+
+// checkBillVisible returns a not-found error unless the bill is saved and visible to the caller.
+func (s *Service) checkBillVisible(ctx context.Context, billID string) error {
+	var tenantID string
+	err := s.db.QueryRow(ctx, `SELECT tenant_id FROM bills WHERE id = $1`, billID).Scan(&tenantID)
+	if errors.Is(err, sqldb.ErrNoRows) || (err == nil && !visibleToCaller(ctx, tenantID)) {
+		return apierr.NotFound(apierr.BillNotFound, "bill %s not found", billID)
+	}
+	if err != nil {
+		return apierr.Wrap(err, "failed to load bill %s", billID)
+	}
+	return nil
+}
+
+const attachmentColumns = `id, bill_id, file_name, content_type, size_bytes, sha256, COALESCE(description, ''), COALESCE(uploaded_by_key_id, ''), created_at`
+
+func scanAttachment(row interface{ Scan(...any) error }) (*Attachment, error) {
+	var a Attachment
+	if err := row.Scan(&a.ID, &a.BillID, &a.FileName, &a.ContentType, &a.Size, &a.SHA256, &a.Description, &a.UploadedByKeyID, &a.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// AddAttachment attaches a document to a bill. Attachments are accepted in any bill
+// status, so evidence can still be added to a disputed paid bill.
+//
+// encore:api auth method=POST path=/bills/:billID/attachments
+func (s *Service) AddAttachment(ctx context.Context, billID string, req *AddAttachmentRequest) (*AttachmentResponse, error) {
+	contentType, err := checkAttachment(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkBillVisible(ctx, billID); err != nil {
+		return nil, err
+	}
+
+	attachmentID := keyedID(ctx, "attachment", billID)
+	existing, err := scanAttachment(s.db.QueryRow(ctx, `SELECT `+attachmentColumns+` FROM bill_attachments WHERE id = $1`, attachmentID))
+	if err == nil {
+		return &AttachmentResponse{Attachment: *existing}, nil
+	}
+	if !errors.Is(err, sqldb.ErrNoRows) {
+		return nil, apierr.Wrap(err, "failed to load attachment %s", attachmentID)
+	}
+
+	object := attachmentObject(billID, attachmentID)
+	w := attachmentBucket.Upload(ctx, object, objects.WithUploadAttrs(objects.UploadAttrs{ContentType: contentType}))
+	if _, err := w.Write(req.Content); err != nil {
+		w.Abort(err)
+		return nil, apierr.Wrap(err, "failed to store attachment %s", req.FileName)
+	}
+	if err := w.Close(); err != nil {
+		return nil, apierr.Wrap(err, "failed to store attachment %s", req.FileName)
+	}
+
+	digest := sha256.Sum256(req.Content)
+	attachment, err := scanAttachment(s.db.QueryRow(ctx, `
+        INSERT INTO bill_attachments (id, bill_id, file_name, content_type, size_bytes, sha256, description, uploaded_by_key_id, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        ON CONFLICT (id) DO NOTHING
+        RETURNING `+attachmentColumns,
+		attachmentID, billID, req.FileName, contentType, len(req.Content), hex.EncodeToString(digest[:]),
+		nullIfEmpty(req.Description), nullIfEmpty(callerKeyID(ctx)), s.clock.Now()))
+	if errors.Is(err, sqldb.ErrNoRows) {
+		// A concurrent request with the same idempotency key saved it first.
+		attachment, err = scanAttachment(s.db.QueryRow(ctx, `SELECT `+attachmentColumns+` FROM bill_attachments WHERE id = $1`, attachmentID))
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to load attachment %s", attachmentID)
+		}
+		return &AttachmentResponse{Attachment: *attachment}, nil
+	}
+	if err != nil {
+		// Without its row the object is unreachable, so do not leave it behind.
+		if removeErr := attachmentBucket.Remove(ctx, object); removeErr != nil {
+			loggerFrom(ctx).Error("Failed to remove orphaned attachment object", "object", object, "error", removeErr)
+		}
+		return nil, apierr.Wrap(err, "failed to save attachment %s", req.FileName)
+	}
+	loggerFrom(ctx).Info("Attachment added", "attachment_id", attachment.ID, "size", attachment.Size)
+	return &AttachmentResponse{Attachment: *attachment}, nil
+}
+
+// ListAttachments lists the documents attached to a bill, oldest first.
+//
+// encore:api auth method=GET path=/bills/:billID/attachments
+func (s *Service) ListAttachments(ctx context.Context, billID string) (*ListAttachmentsResponse, error) {
+	if err := s.checkBillVisible(ctx, billID); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(ctx, `SELECT `+attachmentColumns+` FROM bill_attachments WHERE bill_id = $1 ORDER BY created_at, id`, billID)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to list attachments of bill %s", billID)
+	}
+	defer rows.Close()
+
+	resp := &ListAttachmentsResponse{BillID: billID, Attachments: []Attachment{}}
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to read attachments of bill %s", billID)
+		}
+		resp.Attachments = append(resp.Attachments, *a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read attachments of bill %s", billID)
+	}
+	return resp, nil
+}
+
+// DownloadAttachment returns a short-lived signed URL for an attachment's contents.
+//
+// encore:api auth method=GET path=/bills/:billID/attachments/:attachmentID/download
+func (s *Service) DownloadAttachment(ctx context.Context, billID string, attachmentID string) (*AttachmentDownloadResponse, error) {
+	if err := s.checkBillVisible(ctx, billID); err != nil {
+		return nil, err
+	}
+	var exists bool
+	err := s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM bill_attachments WHERE id = $1 AND bill_id = $2)`, attachmentID, billID).Scan(&exists)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load attachment %s", attachmentID)
+	}
+	if !exists {
+		return nil, apierr.NotFound(apierr.AttachmentNotFound, "attachment %s not found on bill %s", attachmentID, billID)
+	}
+
+	signed, err := attachmentBucket.SignedDownloadURL(ctx, attachmentObject(billID, attachmentID), objects.WithTTL(attachmentDownloadTTL))
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to sign download of attachment %s", attachmentID)
+	}
+	return &AttachmentDownloadResponse{URL: signed.URL, ExpiresAt: s.clock.Now().Add(attachmentDownloadTTL)}, nil
+}
@@ -0,0 +1,36 @@
+package fees
+
+import (
+	"bytes"
+	"testing"
+
+	"encore.app/apierr"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCheckAttachment tests the attachment size and type policy.
+func TestCheckAttachment(t *testing.T) {
+	pdf := []byte("%PDF-1.7\n1 0 obj\n<<>>\nendobj\n")
+
+	contentType, err := checkAttachment(&AddAttachmentRequest{FileName: "contract.pdf", ContentType: "application/pdf", Content: pdf})
+	require.NoError(t, err)
+	require.Equal(t, "application/pdf", contentType)
+
+	contentType, err = checkAttachment(&AddAttachmentRequest{FileName: "usage.csv", ContentType: "text/csv; charset=utf-8", Content: []byte("date,amount\n2024-06-01,12.50\n")})
+	require.NoError(t, err)
+	require.Equal(t, "text/csv", contentType)
+
+	for name, req := range map[string]AddAttachmentRequest{
+		"empty":      {FileName: "empty.pdf", ContentType: "application/pdf"},
+		"too large":  {FileName: "big.txt", ContentType: "text/plain", Content: bytes.Repeat([]byte("a"), maxAttachmentSize+1)},
+		"type":       {FileName: "page.html", ContentType: "text/html", Content: []byte("<html></html>")},
+		"disguised":  {FileName: "evidence.pdf", ContentType: "application/pdf", Content: []byte("<html><body>hi</body></html>")},
+		"no name":    {ContentType: "application/pdf", Content: pdf},
+		"path":       {FileName: "../etc/passwd", ContentType: "text/plain", Content: []byte("root")},
+		"bad header": {FileName: "x.pdf", ContentType: "application/", Content: pdf},
+	} {
+		_, err := checkAttachment(&req)
+		require.Error(t, err, name)
+		require.Contains(t, []apierr.Reason{apierr.AttachmentRejected, apierr.InvalidParameter}, apierr.ReasonOf(err), name)
+	}
+}
@@ -0,0 +1,189 @@
+package fees
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/storage/sqldb"
+	"go.temporal.io/sdk/activity"
+)
+
+// AuditAction names a bill mutation recorded in the audit log.
+type AuditAction string
+
+const (
+	AuditBillCreated     AuditAction = "bill.created"
+	AuditLineItemAdded   AuditAction = "line_item.added"
+	AuditBillClosed      AuditAction = "bill.closed"
+	AuditStatusChanged   AuditAction = "bill.status_changed"
+	AuditBillOverdue     AuditAction = "bill.overdue"
+	AuditPaymentRecorded AuditAction = "payment.recorded"
+	AuditRefundIssued    AuditAction = "refund.issued"
+	AuditRefundCompleted AuditAction = "refund.completed"
+	AuditBillDeleted     AuditAction = "bill.deleted"
+	AuditBillRestored    AuditAction = "bill.restored"
+)
+
+// AuditEntry is one record of a bill's audit trail.
+type AuditEntry struct {
+	ID     int64       `json:"id"`
+	BillID string      `json:"billId"`
+	Action AuditAction `json:"action"`
+	// ActorKeyID is the API key that requested the change. It is empty for changes the
+	// service made on its own, such as dunning retries and automatic collection.
+	ActorKeyID string `json:"actorKeyId,omitempty"`
+	// SubjectID is the line item, payment, or credit note the change concerns, if any.
+	SubjectID string `json:"subjectId,omitempty"`
+	// Before and After are snapshots of the bill with its line items, payments, and
+	// credit notes. Before is absent for bill.created.
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after"`
+	OccurredAt time.Time       `json:"occurredAt"`
+}
+
+// GetBillAuditResponse is the response payload for a bill's audit trail.
+type GetBillAuditResponse struct {
+	BillID  string       `json:"billId"`
+	Entries []AuditEntry `json:"entries"`
+}
+
+// auditEvent describes a mutation about to be recorded.
+type auditEvent struct {
+	BillID     string
+	Action     AuditAction
+	ActorKeyID string
+	SubjectID  string
+	// Event, when set, is also appended to the bill's event stream, with Version as the
+	// bill's version after the change.
+	Event   *BillEventData
+	Version int64
+}
+
+// billSnapshotQuery renders a bill and everything attached to it as one JSON document.
+const billSnapshotQuery = `
+    SELECT jsonb_build_object(
+        'bill', to_jsonb(b),
+        'lineItems', COALESCE((SELECT jsonb_agg(to_jsonb(li) ORDER BY li.created_at, li.id) FROM line_items li WHERE li.bill_id = b.id), '[]'::jsonb),
+        'payments', COALESCE((SELECT jsonb_agg(to_jsonb(p) ORDER BY p.created_at, p.id) FROM payments p WHERE p.bill_id = b.id), '[]'::jsonb),
+        'creditNotes', COALESCE((SELECT jsonb_agg(to_jsonb(c) ORDER BY c.created_at, c.id) FROM credit_notes c WHERE c.bill_id = b.id), '[]'::jsonb)
+    )
+    FROM bills b WHERE b.id = $1
+`
+
+// audited runs mutate and records ev with before/after snapshots of the bill, all in
+// one transaction, so the log cannot miss a change or record one that rolled back.
+// Entries are keyed by the activity execution, so a retried activity that had already
+// committed does not record its change twice. A transaction aborted by a concurrent one
+// is run again, mutate included; see inTx.
+func (a *Activities) audited(ctx context.Context, ev auditEvent, mutate func(tx *tracedTx) error) error {
+	return a.DB.inTx(ctx, func(tx *tracedTx) error {
+		return auditInTx(ctx, tx, auditEventID(ctx), ev, mutate)
+	})
+}
+
+// auditInTx runs mutate and records ev, identified by eventID, in tx. It serves
+// changes made outside activities, such as deleting a bill, as audited serves the
+// activities' changes.
+func auditInTx(ctx context.Context, tx *tracedTx, eventID string, ev auditEvent, mutate func(tx *tracedTx) error) error {
+	before, err := billSnapshot(ctx, tx, ev.BillID)
+	if err != nil {
+		return err
+	}
+	if err := mutate(tx); err != nil {
+		return err
+	}
+	after, err := billSnapshot(ctx, tx, ev.BillID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+        INSERT INTO bill_audit_log (event_id, bill_id, action, actor_key_id, subject_id, before, after)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (event_id) DO NOTHING
+    `, eventID, ev.BillID, ev.Action, nullIfEmpty(ev.ActorKeyID), nullIfEmpty(ev.SubjectID), before, after)
+	if err != nil {
+		return fmt.Errorf("failed to record %s audit entry for bill %s: %w", ev.Action, ev.BillID, err)
+	}
+	if ev.Event != nil {
+		if err := recordBillEvent(ctx, tx, eventID, ev, before, after); err != nil {
+			return err
+		}
+	}
+	if after != nil {
+		if err := refreshBillSummary(ctx, tx, ev.BillID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// billSnapshot returns the bill's current snapshot, or nil if it does not exist yet.
+func billSnapshot(ctx context.Context, tx *tracedTx, billID string) ([]byte, error) {
+	var snapshot []byte
+	err := tx.QueryRow(ctx, billSnapshotQuery, billID).Scan(&snapshot)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot bill %s: %w", billID, err)
+	}
+	return snapshot, nil
+}
+
+// auditEventID identifies the activity execution recording an audit entry. It is the
+// same across retries of that execution.
+func auditEventID(ctx context.Context) string {
+	info := activity.GetInfo(ctx)
+	return info.WorkflowExecution.ID + "/" + info.WorkflowExecution.RunID + "/" + info.ActivityID
+}
+
+// GetBillAudit returns the audit trail of a bill, oldest first.
+//
+// encore:api auth method=GET path=/bills/:billID/audit
+func (s *Service) GetBillAudit(ctx context.Context, billID string) (*GetBillAuditResponse, error) {
+	var tenantID string
+	err := s.db.QueryRow(ctx, `SELECT tenant_id FROM bills WHERE id = $1`, billID).Scan(&tenantID)
+	if errors.Is(err, sqldb.ErrNoRows) || (err == nil && !visibleToCaller(ctx, tenantID)) {
+		return nil, apierr.NotFound(apierr.BillNotFound, "bill %s not found", billID)
+	}
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load bill %s", billID)
+	}
+
+	rows, err := s.db.Query(ctx, `
+        SELECT id, bill_id, action, COALESCE(actor_key_id, ''), COALESCE(subject_id, ''), before, after, occurred_at
+        FROM bill_audit_log
+        WHERE bill_id = $1
+        ORDER BY id
+    `, billID)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load audit log for bill %s", billID)
+	}
+	defer rows.Close()
+
+	resp := &GetBillAuditResponse{BillID: billID, Entries: []AuditEntry{}}
+	for rows.Next() {
+		var e AuditEntry
+		var before, after []byte
+		if err := rows.Scan(&e.ID, &e.BillID, &e.Action, &e.ActorKeyID, &e.SubjectID, &before, &after, &e.OccurredAt); err != nil {
+			return nil, apierr.Wrap(err, "failed to read audit log for bill %s", billID)
+		}
+		if before, err = s.fields.openSnapshot(before); err != nil {
+			return nil, apierr.Wrap(err, "failed to decrypt audit entry %d of bill %s", e.ID, billID)
+		}
+		if after, err = s.fields.openSnapshot(after); err != nil {
+			return nil, apierr.Wrap(err, "failed to decrypt audit entry %d of bill %s", e.ID, billID)
+		}
+		e.Before, e.After = before, after
+		resp.Entries = append(resp.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read audit log for bill %s", billID)
+	}
+	return resp, nil
+}
@@ -0,0 +1,472 @@
+package fees
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/beta/auth"
+	"encore.dev/beta/errs"
+	"encore.dev/middleware"
+	"encore.dev/storage/sqldb"
+)
+
+// Scope grants an API key access to a group of endpoints.
+type Scope string
+
+const (
+	ScopeBillsRead      Scope = "bills:read"
+	ScopeBillsWrite     Scope = "bills:write"
+	ScopePaymentsWrite  Scope = "payments:write"
+	ScopeQuotasRead     Scope = "quotas:read"
+	ScopeAuditRead      Scope = "audit:read"
+	ScopeBillsApprove   Scope = "bills:approve"
+	ScopeReportsRead    Scope = "reports:read"
+	ScopeCustomersErase Scope = "customers:erase"
+	ScopeBillsDelete    Scope = "bills:delete"
+	ScopePayloadsDecode Scope = "payloads:decode"
+)
+
+// IsValid reports whether s is a known scope.
+func (s Scope) IsValid() bool {
+	switch s {
+	case ScopeBillsRead, ScopeBillsWrite, ScopePaymentsWrite, ScopeQuotasRead, ScopeAuditRead, ScopeBillsApprove, ScopeReportsRead, ScopeCustomersErase, ScopeBillsDelete, ScopePayloadsDecode:
+		return true
+	}
+	return false
+}
+
+// endpointScopes lists the scope each authenticated endpoint requires. The same names
+// are used for the gRPC methods.
+var endpointScopes = map[string]Scope{
+	"GetBill":       ScopeBillsRead,
+	"WaitForBill":   ScopeBillsRead,
+	"ListBills":     ScopeBillsRead,
+	"WatchBill":     ScopeBillsRead,
+	"GetDunning":    ScopeBillsRead,
+	"CreateBill":    ScopeBillsWrite,
+	"AddLineItem":   ScopeBillsWrite,
+	"CloseBill":     ScopeBillsWrite,
+	"ApproveBill":   ScopeBillsApprove,
+	"RejectBill":    ScopeBillsApprove,
+	"PayBill":       ScopePaymentsWrite,
+	"CreateRefund":  ScopePaymentsWrite,
+	"PauseDunning":  ScopePaymentsWrite,
+	"ResumeDunning": ScopePaymentsWrite,
+	"GetQuotaUsage": ScopeQuotasRead,
+	"GetBillAudit":  ScopeAuditRead,
+	"GetBillEvents": ScopeAuditRead,
+
+	"GetSubscription":        ScopeBillsRead,
+	"CreateSubscription":     ScopeBillsWrite,
+	"ChangeSubscriptionPlan": ScopeBillsWrite,
+	"CancelSubscription":     ScopeBillsWrite,
+
+	"RetryCloseBill": ScopeBillsWrite,
+
+	"GetCustomerStatement": ScopeBillsRead,
+	"SearchBills":          ScopeBillsRead,
+	"StreamBills":          ScopeBillsRead,
+	"StreamBillEvents":     ScopeBillsRead,
+	"ListBillSummaries":    ScopeBillsRead,
+	"ListBillChildren":     ScopeBillsRead,
+	"ListPayerShares":      ScopeBillsRead,
+	"GetBillDisplay":       ScopeBillsRead,
+	"GetBillHTML":          ScopeBillsRead,
+	"ListBillDeliveries":   ScopeBillsRead,
+	"ResendBill":           ScopeBillsWrite,
+	"ListLineItemEvents":   ScopeBillsRead,
+	"GetRevenueReport":     ScopeReportsRead,
+	"GetAccrualReport":     ScopeReportsRead,
+
+	"AddAttachment":      ScopeBillsWrite,
+	"ListAttachments":    ScopeBillsRead,
+	"DownloadAttachment": ScopeBillsRead,
+	"AddComment":         ScopeBillsWrite,
+	"ListComments":       ScopeBillsRead,
+	"GetBillArchive":     ScopeBillsRead,
+
+	"GrantCredit":        ScopePaymentsWrite,
+	"GetCreditBalances":  ScopeBillsRead,
+	"GetCustomerBalance": ScopeBillsRead,
+
+	"RecordPayment": ScopePaymentsWrite,
+
+	"ListDisputes":          ScopeBillsRead,
+	"SubmitDisputeEvidence": ScopePaymentsWrite,
+
+	"GetLedgerEntries": ScopeReportsRead,
+
+	"CloseBills": ScopeBillsWrite,
+	"GetJob":     ScopeBillsRead,
+	"CancelJob":  ScopeBillsWrite,
+
+	"SetBillSpendingAlerts": ScopeBillsWrite,
+	"SetPayerSplits":        ScopeBillsWrite,
+	"ApplyCoupon":           ScopeBillsWrite,
+
+	"SimulatePricing": ScopeBillsRead,
+
+	"EraseCustomerData": ScopeCustomersErase,
+	"GetErasure":        ScopeCustomersErase,
+
+	"DeleteBill":  ScopeBillsDelete,
+	"RestoreBill": ScopeBillsDelete,
+
+	"PayloadCodec": ScopePayloadsDecode,
+}
+
+// apiKeyPrefix starts every API key so leaked keys are easy to recognize.
+const apiKeyPrefix = "fms_"
+
+// AuthData describes the API key a request authenticated with.
+type AuthData struct {
+	KeyID    string
+	TenantID string
+	Scopes   []Scope
+	Roles    []Role
+	// Test is set for test mode keys, which only create and see test bills.
+	Test bool
+}
+
+// HasScope reports whether the key was granted scope, directly or through a role.
+func (d *AuthData) HasScope(scope Scope) bool {
+	for _, s := range d.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	for _, r := range d.Roles {
+		if r.grants(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey is an API key as shown to administrators. The secret is never stored.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	TenantID   string     `json:"tenantId"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []Scope    `json:"scopes"`
+	Roles      []Role     `json:"roles"`
+	Test       bool       `json:"test,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// CreateAPIKeyRequest is the request payload for issuing an API key.
+type CreateAPIKeyRequest struct {
+	Name     string  `json:"name"`
+	TenantID string  `json:"tenantId"`
+	Scopes   []Scope `json:"scopes,omitempty"`
+	Roles    []Role  `json:"roles,omitempty"`
+	// Test issues a test mode key: the bills it creates are test bills, left out of
+	// reports, and it cannot see live bills.
+	Test bool `json:"test,omitempty"`
+}
+
+// CreateAPIKeyResponse returns a new API key. Secret is shown only once.
+type CreateAPIKeyResponse struct {
+	RetryMetadata
+	Key    APIKey `json:"key"`
+	Secret string `json:"secret"`
+}
+
+// ListAPIKeysParams filters the keys returned by ListAPIKeys.
+type ListAPIKeysParams struct {
+	TenantID string `query:"tenantId"`
+}
+
+// ListAPIKeysResponse is the response payload for listing API keys.
+type ListAPIKeysResponse struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// RevokeAPIKeyResponse is the response payload after revoking an API key.
+type RevokeAPIKeyResponse struct {
+	RetryMetadata
+	Key APIKey `json:"key"`
+}
+
+// AuthHandler authenticates requests to the public endpoints with an API key sent as
+// "Authorization: Bearer <key>".
+//
+// encore:authhandler
+func (s *Service) AuthHandler(ctx context.Context, token string) (auth.UID, *AuthData, error) {
+	data, err := s.authenticate(ctx, token)
+	if err != nil {
+		return "", nil, err
+	}
+	return auth.UID(data.KeyID), data, nil
+}
+
+// authenticate resolves an API key to its AuthData, recording its use.
+func (s *Service) authenticate(ctx context.Context, token string) (*AuthData, error) {
+	if !strings.HasPrefix(token, apiKeyPrefix) {
+		return nil, apierr.Unauthenticated(apierr.InvalidAPIKey, "invalid API key")
+	}
+	data := &AuthData{}
+	var scopes, roles []string
+	err := s.db.QueryRow(ctx, `
+        UPDATE api_keys SET last_used_at = NOW()
+        WHERE key_hash = $1 AND revoked_at IS NULL
+        RETURNING id, tenant_id, scopes, roles, test
+    `, hashAPIKey(token)).Scan(&data.KeyID, &data.TenantID, &scopes, &roles, &data.Test)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return nil, apierr.Unauthenticated(apierr.InvalidAPIKey, "invalid API key")
+	}
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to look up API key")
+	}
+	for _, scope := range scopes {
+		data.Scopes = append(data.Scopes, Scope(scope))
+	}
+	for _, role := range roles {
+		data.Roles = append(data.Roles, Role(role))
+	}
+	return data, nil
+}
+
+// RequireScopes rejects requests whose API key lacks the scope the endpoint requires,
+// whether granted directly or through one of the key's roles.
+//
+// encore:middleware target=all
+func (s *Service) RequireScopes(req middleware.Request, next middleware.Next) middleware.Response {
+	endpoint := req.Data().Endpoint
+	if err := checkScope(endpoint, caller(req.Context())); err != nil {
+		return middleware.Response{Err: err}
+	}
+	return next(req)
+}
+
+// publicEndpoints lists the endpoints anyone may call, which need no scope even when
+// called with an API key.
+var publicEndpoints = map[string]bool{
+	"GetStatusFeed":  true,
+	"Metrics":        true,
+	"OpenAPI":        true,
+	"PaymentWebhook": true,
+}
+
+// checkScope verifies that the caller may invoke endpoint. API keys may not call an
+// endpoint missing from endpointScopes unless it is public, so an endpoint added
+// without a scope is closed rather than open to every key. Calls without a key reach
+// such endpoints only as private endpoints called by internal tooling.
+func checkScope(endpoint string, data *AuthData) error {
+	scope, ok := endpointScopes[endpoint]
+	if !ok {
+		if data == nil || publicEndpoints[endpoint] {
+			return nil
+		}
+		return apierr.PermissionDenied(apierr.InsufficientScope, "%s has no scope API keys can be granted", endpoint)
+	}
+	if data == nil {
+		return apierr.Unauthenticated(apierr.InvalidAPIKey, "%s requires an API key", endpoint)
+	}
+	if !data.HasScope(scope) {
+		if roles := rolesGranting(scope); len(roles) > 0 {
+			return apierr.PermissionDenied(apierr.InsufficientScope, "API key lacks the %s scope required by %s, granted by the %s roles", scope, endpoint, strings.Join(roles, ", "))
+		}
+		return apierr.PermissionDenied(apierr.InsufficientScope, "API key lacks the %s scope required by %s", scope, endpoint)
+	}
+	return nil
+}
+
+type callerCtxKey struct{}
+
+// withCaller returns a context carrying the API key of a request authenticated outside
+// Encore's auth handler, such as a gRPC call.
+func withCaller(ctx context.Context, data *AuthData) context.Context {
+	return context.WithValue(ctx, callerCtxKey{}, data)
+}
+
+// caller returns the API key the current request authenticated with, or nil.
+func caller(ctx context.Context) *AuthData {
+	if data, ok := ctx.Value(callerCtxKey{}).(*AuthData); ok {
+		return data
+	}
+	if data, ok := auth.Data().(*AuthData); ok {
+		return data
+	}
+	return nil
+}
+
+// callerKeyID returns the ID of the calling API key, or "" for internal calls.
+func callerKeyID(ctx context.Context) string {
+	if data := caller(ctx); data != nil {
+		return data.KeyID
+	}
+	return ""
+}
+
+// requestTenant returns the tenant a request is accounted to: the calling API key's
+// tenant, falling back to the X-Tenant-ID header for internal calls.
+func requestTenant(ctx context.Context, header string) string {
+	if data := caller(ctx); data != nil && data.TenantID != "" {
+		return data.TenantID
+	}
+	return tenantOrDefault(header)
+}
+
+// callerTenant returns the tenant the calling API key is scoped to, or "" for internal
+// calls made without a key, which may act on every tenant.
+func callerTenant(ctx context.Context) string {
+	if data := caller(ctx); data != nil {
+		return tenantOrDefault(data.TenantID)
+	}
+	return ""
+}
+
+// visibleToCaller reports whether the caller may see a resource owned by tenantID.
+// Callers are told other tenants' resources do not exist rather than that they are
+// forbidden, so IDs cannot be probed across tenants.
+func visibleToCaller(ctx context.Context, tenantID string) bool {
+	scope := callerTenant(ctx)
+	return scope == "" || scope == tenantOrDefault(tenantID)
+}
+
+// newAPIKeySecret generates a random API key.
+func newAPIKeySecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashAPIKey returns the stored form of an API key. Keys are random, so a fast hash suffices.
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey issues a new API key for a tenant.
+// It is private so it can only be called by internal admin tooling.
+//
+// encore:api private method=POST path=/admin/api-keys
+func (s *Service) CreateAPIKey(ctx context.Context, params *CreateAPIKeyRequest) (*CreateAPIKeyResponse, error) {
+	if params.Name == "" {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "name is required")
+	}
+	if len(params.Scopes) == 0 && len(params.Roles) == 0 {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "at least one scope or role is required")
+	}
+	scopes := make([]string, 0, len(params.Scopes))
+	for _, scope := range params.Scopes {
+		if !scope.IsValid() {
+			return nil, apierr.InvalidArgument(apierr.InvalidParameter, "unknown scope %q", scope)
+		}
+		scopes = append(scopes, string(scope))
+	}
+	roles, err := validateRoles(params.Roles)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := newAPIKeySecret()
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to generate API key")
+	}
+	key := APIKey{
+		ID:        keyedID(ctx, "api-key"),
+		Name:      params.Name,
+		TenantID:  tenantOrDefault(params.TenantID),
+		Prefix:    secret[:len(apiKeyPrefix)+6],
+		Scopes:    append([]Scope{}, params.Scopes...),
+		Roles:     append([]Role{}, params.Roles...),
+		Test:      params.Test,
+		CreatedAt: s.clock.Now().UTC(),
+	}
+	res, err := s.db.Exec(ctx, `
+        INSERT INTO api_keys (id, name, tenant_id, key_prefix, key_hash, scopes, roles, test, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        ON CONFLICT (id) DO NOTHING
+    `, key.ID, key.Name, key.TenantID, key.Prefix, hashAPIKey(secret), scopes, roles, key.Test, key.CreatedAt)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to save API key")
+	}
+	if res.RowsAffected() == 0 {
+		// A retry of a keyed request; the secret cannot be shown again.
+		return nil, apierr.New(errs.AlreadyExists, apierr.APIKeyExists, "API key %s was already created for this idempotency key", key.ID)
+	}
+	return &CreateAPIKeyResponse{Key: key, Secret: secret}, nil
+}
+
+// ListAPIKeys lists API keys, optionally for one tenant.
+//
+// encore:api private method=GET path=/admin/api-keys
+func (s *Service) ListAPIKeys(ctx context.Context, params *ListAPIKeysParams) (*ListAPIKeysResponse, error) {
+	rows, err := s.db.Query(ctx, `
+        SELECT `+apiKeyColumns+`
+        FROM api_keys
+        WHERE $1 = '' OR tenant_id = $1
+        ORDER BY created_at
+    `, params.TenantID)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to list API keys")
+	}
+	defer rows.Close()
+
+	resp := &ListAPIKeysResponse{Keys: []APIKey{}}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to read API key")
+		}
+		resp.Keys = append(resp.Keys, *key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to list API keys")
+	}
+	return resp, nil
+}
+
+// RevokeAPIKey permanently disables an API key.
+//
+// encore:api private method=DELETE path=/admin/api-keys/:keyID
+func (s *Service) RevokeAPIKey(ctx context.Context, keyID string) (*RevokeAPIKeyResponse, error) {
+	row := s.db.QueryRow(ctx, `
+        UPDATE api_keys SET revoked_at = COALESCE(revoked_at, NOW())
+        WHERE id = $1
+        RETURNING `+apiKeyColumns, keyID)
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return nil, apierr.NotFound(apierr.APIKeyNotFound, "API key %s not found", keyID)
+	}
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to revoke API key %s", keyID)
+	}
+	return &RevokeAPIKeyResponse{Key: *key}, nil
+}
+
+// apiKeyColumns are the api_keys columns scanAPIKey reads, in order.
+const apiKeyColumns = `id, name, tenant_id, key_prefix, scopes, roles, test, created_at, last_used_at, revoked_at`
+
+// scanAPIKey reads an api_keys row selected with apiKeyColumns.
+func scanAPIKey(row interface{ Scan(...any) error }) (*APIKey, error) {
+	var key APIKey
+	var scopes, roles []string
+	err := row.Scan(&key.ID, &key.Name, &key.TenantID, &key.Prefix, &scopes, &roles, &key.Test, &key.CreatedAt, &key.LastUsedAt, &key.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = []Scope{}
+	for _, scope := range scopes {
+		key.Scopes = append(key.Scopes, Scope(scope))
+	}
+	key.Roles = []Role{}
+	for _, role := range roles {
+		key.Roles = append(key.Roles, Role(role))
+	}
+	return &key, nil
+}
@@ -0,0 +1,88 @@
+package fees
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"encore.app/apierr"
+	"encore.app/services/fees/feespb"
+)
+
+// TestCheckScope tests that endpoints require their scope, and that unscoped endpoints
+// are only open to API keys when public.
+func TestCheckScope(t *testing.T) {
+	reader := &AuthData{KeyID: "key-1", Scopes: []Scope{ScopeBillsRead}}
+
+	require.NoError(t, checkScope("GetBill", reader))
+	require.NoError(t, checkScope("GetStatus", nil))
+	require.Equal(t, apierr.InsufficientScope, apierr.ReasonOf(checkScope("CreateBill", reader)))
+	require.Equal(t, apierr.InsufficientScope, apierr.ReasonOf(checkScope("GetBillAudit", reader)))
+	require.Equal(t, apierr.InsufficientScope, apierr.ReasonOf(checkScope("ApproveBill", &AuthData{KeyID: "key-2", Scopes: []Scope{ScopeBillsWrite}})))
+	require.Equal(t, apierr.InvalidAPIKey, apierr.ReasonOf(checkScope("GetBill", nil)))
+
+	// Endpoints without a scope are refused to API keys, unless they are public.
+	require.NoError(t, checkScope("GetStatusFeed", reader))
+	require.NoError(t, checkScope("CreateCoupon", nil))
+	require.Equal(t, apierr.InsufficientScope, apierr.ReasonOf(checkScope("CreateCoupon", reader)))
+}
+
+// TestEndpointScopesComplete tests that every authenticated endpoint and gRPC method
+// has a scope, so a new one is not callable by every API key.
+func TestEndpointScopesComplete(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	require.NoError(t, err)
+	funcName := regexp.MustCompile(`^func \([^)]*\) (\w+)\(`)
+	var endpoints []string
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		auth := false
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "// encore:api auth") {
+				auth = true
+			} else if m := funcName.FindStringSubmatch(line); m != nil && auth {
+				endpoints = append(endpoints, m[1])
+				auth = false
+			}
+		}
+		require.NoError(t, scanner.Err())
+		f.Close()
+	}
+	require.NotEmpty(t, endpoints)
+
+	for _, m := range feespb.FeesService_ServiceDesc.Methods {
+		endpoints = append(endpoints, m.MethodName)
+	}
+	for _, s := range feespb.FeesService_ServiceDesc.Streams {
+		endpoints = append(endpoints, s.StreamName)
+	}
+	for _, endpoint := range endpoints {
+		_, ok := endpointScopes[endpoint]
+		require.True(t, ok, "endpoint %s has no scope in endpointScopes", endpoint)
+	}
+}
+
+// TestAPIKeySecret tests that generated keys are prefixed, unique, and hashed deterministically.
+func TestAPIKeySecret(t *testing.T) {
+	a, err := newAPIKeySecret()
+	require.NoError(t, err)
+	b, err := newAPIKeySecret()
+	require.NoError(t, err)
+
+	require.True(t, strings.HasPrefix(a, apiKeyPrefix))
+	require.NotEqual(t, a, b)
+	require.Equal(t, hashAPIKey(a), hashAPIKey(a))
+	require.NotEqual(t, hashAPIKey(a), hashAPIKey(b))
+	require.NotContains(t, hashAPIKey(a), a)
+}
@@ -0,0 +1,468 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"encore.app/apierr"
+	"encore.app/locale"
+	"encore.dev/storage/sqldb"
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// DeliverBillActivityName emails a closed bill to its customer.
+	DeliverBillActivityName = "DeliverBillActivity"
+	// maxDeliveryRecipients caps the addresses a bill is emailed to, the contact's and
+	// its copies together.
+	maxDeliveryRecipients = 10
+)
+
+// DeliveryTrigger says why a bill was emailed.
+type DeliveryTrigger string
+
+const (
+	DeliveryTriggerClose  DeliveryTrigger = "close"
+	DeliveryTriggerResend DeliveryTrigger = "resend"
+)
+
+// DeliveryStatus is how sending a bill's email went.
+type DeliveryStatus string
+
+const (
+	// DeliveryPending is an email being sent, or whose sending was interrupted.
+	DeliveryPending DeliveryStatus = "pending"
+	// DeliverySent is an email the provider accepted.
+	DeliverySent   DeliveryStatus = "sent"
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// errNoContact is returned when a bill's customer has no contact to email it to.
+var errNoContact = errors.New("customer has no contact")
+
+// CustomerContact is where a customer's bills are emailed.
+type CustomerContact struct {
+	CustomerID string `json:"customerId"`
+	Email      string `json:"email"`
+	// Cc are addresses copied on the emails.
+	Cc []string `json:"cc"`
+	// Locale formats the amounts and dates of the emails, as for GET
+	// /bills/:billID/display. Empty is en-US.
+	Locale string `json:"locale,omitempty"`
+	// AttachPDF attaches the bill as a PDF.
+	AttachPDF bool       `json:"attachPdf"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// SetCustomerContactRequest is the request payload for setting a customer's contact.
+type SetCustomerContactRequest struct {
+	CustomerSettingsRequest
+	Email string   `json:"email"`
+	Cc    []string `json:"cc,omitempty"`
+	// Locale is a BCP 47 tag such as "de-DE".
+	Locale string `json:"locale,omitempty"`
+	// AttachPDF attaches the bill as a PDF; it defaults to true.
+	AttachPDF *bool `json:"attachPdf,omitempty"`
+}
+
+// CustomerContactResponse reports a customer's contact.
+type CustomerContactResponse struct {
+	RetryMetadata
+	CustomerID string `json:"customerId"`
+	// Contact is absent when the customer has none, so their bills are not emailed.
+	Contact *CustomerContact `json:"contact,omitempty"`
+}
+
+// BillDelivery is an email of a bill to its customer.
+type BillDelivery struct {
+	ID      string          `json:"id"`
+	BillID  string          `json:"billId"`
+	Trigger DeliveryTrigger `json:"trigger"`
+	// Recipients are the addresses the email went to, copies included.
+	Recipients []string       `json:"recipients"`
+	Provider   EmailProvider  `json:"provider"`
+	Status     DeliveryStatus `json:"status"`
+	// Attempts counts how often sending was tried.
+	Attempts int `json:"attempts"`
+	// ProviderMessageID is the provider's ID of the email, for tracing it there.
+	ProviderMessageID string `json:"providerMessageId,omitempty"`
+	// Error is why the last attempt failed.
+	Error string `json:"error,omitempty"`
+	// RequestedByKeyID is the API key that resent the bill.
+	RequestedByKeyID string     `json:"requestedByKeyId,omitempty"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	SentAt           *time.Time `json:"sentAt,omitempty"`
+}
+
+// ResendBillRequest is the request payload for emailing a bill again.
+type ResendBillRequest struct {
+	// To sends the bill to these addresses instead of the customer's contact.
+	To []string `json:"to,omitempty"`
+}
+
+// BillDeliveryResponse is the response payload for a bill's email.
+type BillDeliveryResponse struct {
+	RetryMetadata
+	Delivery BillDelivery `json:"delivery"`
+}
+
+// ListBillDeliveriesResponse is the response payload for a bill's emails.
+type ListBillDeliveriesResponse struct {
+	BillID     string         `json:"billId"`
+	Deliveries []BillDelivery `json:"deliveries"`
+}
+
+// validEmail reports whether s is a bare email address, such as "ap@example.com".
+func validEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Name == "" && addr.Address == s
+}
+
+// checkRecipients fails with InvalidArgument unless emails are valid addresses, at
+// most limit of them.
+func checkRecipients(field string, emails []string, limit int) error {
+	if len(emails) > limit {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "%s must have at most %d addresses", field, limit)
+	}
+	for _, email := range emails {
+		if !validEmail(email) {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "invalid %s address %q: must be an email address such as \"ap@example.com\"", field, email)
+		}
+	}
+	return nil
+}
+
+// loadCustomerContact returns a customer's contact, or nil if they have none.
+func loadCustomerContact(ctx context.Context, db *tracedDB, tenantID, customerID string) (*CustomerContact, error) {
+	c := CustomerContact{CustomerID: customerID}
+	err := db.QueryRow(ctx, `
+        SELECT email, cc, locale, attach_pdf, updated_at FROM customer_contacts WHERE tenant_id = $2 AND customer_id = $1
+    `, customerID, tenantOrDefault(tenantID)).Scan(&c.Email, &c.Cc, &c.Locale, &c.AttachPDF, &c.UpdatedAt)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load contact of customer %s: %w", customerID, err)
+	}
+	return &c, nil
+}
+
+const deliveryColumns = `id, bill_id, trigger, recipients, provider, status, attempts, COALESCE(provider_message_id, ''), COALESCE(error, ''), COALESCE(requested_by_key_id, ''), created_at, sent_at`
+
+func scanDelivery(row interface{ Scan(...any) error }) (*BillDelivery, error) {
+	var d BillDelivery
+	err := row.Scan(&d.ID, &d.BillID, &d.Trigger, &d.Recipients, &d.Provider, &d.Status, &d.Attempts, &d.ProviderMessageID, &d.Error, &d.RequestedByKeyID, &d.CreatedAt, &d.SentAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// billMailer emails bills to customers and records each email in bill_deliveries.
+type billMailer struct {
+	DB     *tracedDB
+	Sender EmailSender
+	Config EmailConfig
+}
+
+// deliver emails bill to to, or to its customer's contact if to is empty, recording
+// the email as deliveryID. A delivery already sent is returned without sending it
+// again, so retries with the same ID do not email the customer twice; an email whose
+// sending was interrupted midway may still arrive twice. It fails with errNoContact if
+// to is empty and the customer has no contact, and returns the failed delivery with the
+// error if the provider does not accept the email.
+func (m *billMailer) deliver(ctx context.Context, bill *Bill, deliveryID string, trigger DeliveryTrigger, to []string, keyID string) (*BillDelivery, error) {
+	contact, err := loadCustomerContact(ctx, m.DB, bill.TenantID, bill.CustomerID)
+	if err != nil {
+		return nil, err
+	}
+	var cc []string
+	if len(to) == 0 {
+		if contact == nil {
+			return nil, errNoContact
+		}
+		to, cc = []string{contact.Email}, contact.Cc
+	}
+
+	delivery, err := scanDelivery(m.DB.QueryRow(ctx, `
+        INSERT INTO bill_deliveries (id, bill_id, customer_id, trigger, recipients, provider, status, attempts, requested_by_key_id, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, 1, $8, NOW())
+        ON CONFLICT (id) DO UPDATE
+        SET attempts = bill_deliveries.attempts + CASE WHEN bill_deliveries.status = $9 THEN 0 ELSE 1 END
+        RETURNING `+deliveryColumns,
+		deliveryID, bill.ID, bill.CustomerID, trigger, append(append([]string{}, to...), cc...), m.Config.Provider, DeliveryPending, nullIfEmpty(keyID), DeliverySent))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record delivery %s of bill %s: %w", deliveryID, bill.ID, err)
+	}
+	if delivery.Status == DeliverySent {
+		return delivery, nil
+	}
+
+	l := locale.Default
+	if contact != nil && contact.Locale != "" {
+		// Contacts are checked when saved, but the supported locales may change since.
+		if parsed, err := locale.Parse(contact.Locale); err == nil {
+			l = parsed
+		}
+	}
+	settings, err := loadBillHTMLSettings(ctx, m.DB, tenantOrDefault(bill.TenantID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load HTML settings of tenant %s: %w", bill.TenantID, err)
+	}
+	email, err := billEmail(ctx, bill, l, settings, contact == nil || contact.AttachPDF)
+	if err != nil {
+		return nil, err
+	}
+	email.From, email.To, email.Cc = m.Config.From, to, cc
+
+	messageID, sendErr := m.Sender.Send(ctx, email)
+	if sendErr != nil {
+		delivery.Status, delivery.Error = DeliveryFailed, sendErr.Error()
+	} else {
+		delivery.Status, delivery.ProviderMessageID, delivery.Error = DeliverySent, messageID, ""
+	}
+	delivery, err = scanDelivery(m.DB.QueryRow(ctx, `
+        UPDATE bill_deliveries
+        SET status = $2, provider_message_id = $3, error = $4, sent_at = CASE WHEN $2 = $5 THEN NOW() END
+        WHERE id = $1
+        RETURNING `+deliveryColumns,
+		deliveryID, delivery.Status, nullIfEmpty(delivery.ProviderMessageID), nullIfEmpty(delivery.Error), DeliverySent))
+	if err != nil {
+		// The email went out, or not, either way; only its record is behind.
+		loggerFrom(ctx).Error("Failed to record delivery outcome", "bill_id", bill.ID, "delivery_id", deliveryID, "error", err)
+		if sendErr == nil {
+			return nil, fmt.Errorf("failed to record delivery %s of bill %s: %w", deliveryID, bill.ID, err)
+		}
+	}
+	if sendErr != nil {
+		return delivery, sendErr
+	}
+	return delivery, nil
+}
+
+// billEmail builds the email of a bill, formatted for l: a summary in the text body,
+// the bill as GET /bills/:billID/html renders it in the HTML body, and the bill as a
+// PDF if attachPDF is set.
+func billEmail(ctx context.Context, bill *Bill, l locale.Locale, settings *BillHTMLSettings, attachPDF bool) (*Email, error) {
+	d := displayBill(bill, l)
+	name := bill.ID
+	if bill.Number != "" {
+		name = bill.Number
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Bill %s\n\n", name)
+	fmt.Fprintf(&text, "Total: %s\n", d.Total)
+	if d.BalanceDue != "" {
+		fmt.Fprintf(&text, "Balance due: %s\n", d.BalanceDue)
+	}
+	if d.DueDate != "" {
+		fmt.Fprintf(&text, "Due: %s\n", d.DueDate)
+	}
+	fmt.Fprintf(&text, "\n")
+	for _, item := range d.LineItems {
+		if item.Quantity != "" {
+			fmt.Fprintf(&text, "- %s (%s): %s\n", item.Description, item.Quantity, item.Amount)
+		} else {
+			fmt.Fprintf(&text, "- %s: %s\n", item.Description, item.Amount)
+		}
+	}
+	if attachPDF {
+		fmt.Fprintf(&text, "\nThe bill is attached as a PDF.\n")
+	}
+	if settings.Footer != "" {
+		fmt.Fprintf(&text, "\n%s\n", settings.Footer)
+	}
+
+	html, err := renderBillHTML(ctx, bill, l, settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render bill %s: %w", bill.ID, err)
+	}
+	email := &Email{
+		Subject: fmt.Sprintf("Bill %s: %s", name, d.Total),
+		Text:    text.String(),
+		HTML:    string(html),
+	}
+	if attachPDF {
+		email.Attachments = []EmailAttachment{{
+			Filename:    "bill-" + name + ".pdf",
+			ContentType: "application/pdf",
+			Data:        renderBillPDF(bill, l, settings.Footer),
+		}}
+	}
+	return email, nil
+}
+
+// emailBill emails the bill that just closed to its customer's contact. Sub-bills are
+// billed through their parent and test bills are for integrators, so neither is
+// emailed. A bill that could not be emailed stays closed; it can be resent with
+// POST /bills/:billID/deliveries.
+func (w *billWorkflow) emailBill() {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+	if !w.params.EmailOnClose || bill.CustomerID == "" || w.params.ParentBillID != "" || bill.Test {
+		return
+	}
+	err := workflow.ExecuteActivity(ctx, DeliverBillActivityName, DeliverBillActivityParams{Bill: *bill}).Get(ctx, nil)
+	if err != nil {
+		logger.Error("Failed to execute DeliverBillActivity", "bill_id", bill.ID, "error", err)
+	}
+}
+
+// DeliverBillActivityParams defines parameters for DeliverBillActivity.
+type DeliverBillActivityParams struct {
+	// Bill is the bill as it closed.
+	Bill Bill
+}
+
+// DeliverBillActivity emails a closed bill to its customer's contact. Customers
+// without a contact are skipped. Emails the provider refuses are returned as
+// non-retryable errors of type EmailRejectedErrorType.
+func (a *Activities) DeliverBillActivity(ctx context.Context, params DeliverBillActivityParams) error {
+	bill := params.Bill
+	// The number is assigned when the close is saved, after the workflow sent it.
+	saved, err := savedBills(ctx, a.DB, []string{bill.ID})
+	if err != nil {
+		return fmt.Errorf("DeliverBillActivity: failed to load bill %s: %w", bill.ID, err)
+	}
+	bill.Number = saved[bill.ID].Number
+
+	deliveryID := uuid.NewSHA1(uuid.NameSpaceOID, []byte("feems/delivery/close/"+bill.ID)).String()
+	_, err = a.Mailer.deliver(ctx, &bill, deliveryID, DeliveryTriggerClose, nil, "")
+	var rejected *EmailRejectedError
+	switch {
+	case errors.Is(err, errNoContact):
+		loggerFrom(ctx).Info("Customer has no contact, not emailing bill", "bill_id", bill.ID, "customer_id", bill.CustomerID)
+		return nil
+	case errors.As(err, &rejected):
+		return temporal.NewNonRetryableApplicationError(rejected.Reason, EmailRejectedErrorType, err)
+	case err != nil:
+		return fmt.Errorf("DeliverBillActivity: failed to email bill %s: %w", bill.ID, err)
+	}
+	return nil
+}
+
+// ------ API ------
+
+// ResendBill emails a closed bill again, to its customer's contact or to the addresses
+// in to. The email is sent before the call returns; if the provider does not accept
+// it, the call fails with delivery_failed and the failed delivery is listed by GET
+// /bills/:billID/deliveries.
+//
+// encore:api auth method=POST path=/bills/:billID/deliveries
+func (s *Service) ResendBill(ctx context.Context, billID string, req *ResendBillRequest) (*BillDeliveryResponse, error) {
+	if err := checkRecipients("to", req.To, maxDeliveryRecipients); err != nil {
+		return nil, err
+	}
+	resp, err := s.getBill(ctx, billID)
+	if err != nil {
+		return nil, err
+	}
+	bill := &resp.RetrievedBill
+	if bill.ClosedAt == nil {
+		return nil, apierr.FailedPrecondition(apierr.BillNotClosed, "bill %s is %s; only closed bills are emailed", billID, bill.Status)
+	}
+
+	delivery, err := s.mailer.deliver(ctx, bill, keyedID(ctx, "delivery", billID), DeliveryTriggerResend, req.To, callerKeyID(ctx))
+	var rejected *EmailRejectedError
+	switch {
+	case errors.Is(err, errNoContact):
+		return nil, apierr.FailedPrecondition(apierr.ContactNotFound, "customer %s has no contact; set one or send the bill to addresses in to", bill.CustomerID)
+	case errors.As(err, &rejected):
+		return nil, apierr.FailedPrecondition(apierr.DeliveryFailed, "the email of bill %s was rejected: %s", billID, rejected.Reason)
+	case err != nil && delivery != nil:
+		return nil, apierr.Unavailable(apierr.DeliveryFailed, "the email of bill %s could not be sent; try again later", billID)
+	case err != nil:
+		return nil, apierr.Wrap(err, "failed to email bill %s", billID)
+	}
+	return &BillDeliveryResponse{Delivery: *delivery}, nil
+}
+
+// ListBillDeliveries lists the emails of a bill, oldest first.
+//
+// encore:api auth method=GET path=/bills/:billID/deliveries
+func (s *Service) ListBillDeliveries(ctx context.Context, billID string) (*ListBillDeliveriesResponse, error) {
+	if err := s.checkBillVisible(ctx, billID); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(ctx, `SELECT `+deliveryColumns+` FROM bill_deliveries WHERE bill_id = $1 ORDER BY created_at, id`, billID)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to list deliveries of bill %s", billID)
+	}
+	defer rows.Close()
+
+	resp := &ListBillDeliveriesResponse{BillID: billID, Deliveries: []BillDelivery{}}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to read deliveries of bill %s", billID)
+		}
+		resp.Deliveries = append(resp.Deliveries, *d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read deliveries of bill %s", billID)
+	}
+	return resp, nil
+}
+
+// SetCustomerContact sets where a customer's bills are emailed. It is private so it
+// can only be called by internal admin tooling.
+//
+// encore:api private method=PUT path=/admin/customers/:customerID/contact
+func (s *Service) SetCustomerContact(ctx context.Context, customerID string, params *SetCustomerContactRequest) (*CustomerContactResponse, error) {
+	if !validEmail(params.Email) {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "invalid email %q: must be an email address such as \"ap@example.com\"", params.Email)
+	}
+	if err := checkRecipients("cc", params.Cc, maxDeliveryRecipients-1); err != nil {
+		return nil, err
+	}
+	if params.Locale != "" {
+		if _, err := parseLocale(params.Locale); err != nil {
+			return nil, err
+		}
+	}
+	cc := params.Cc
+	if cc == nil {
+		cc = []string{}
+	}
+	attachPDF := params.AttachPDF == nil || *params.AttachPDF
+
+	_, err := s.db.Exec(ctx, `
+        INSERT INTO customer_contacts (tenant_id, customer_id, email, cc, locale, attach_pdf, updated_at)
+        VALUES ($6, $1, $2, $3, $4, $5, NOW())
+        ON CONFLICT (tenant_id, customer_id) DO UPDATE
+        SET email = EXCLUDED.email, cc = EXCLUDED.cc, locale = EXCLUDED.locale,
+            attach_pdf = EXCLUDED.attach_pdf, updated_at = EXCLUDED.updated_at
+    `, customerID, params.Email, cc, params.Locale, attachPDF, tenantOrDefault(params.TenantID))
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to save contact of customer %s", customerID)
+	}
+	return s.GetCustomerContact(ctx, customerID, &params.CustomerSettingsRequest)
+}
+
+// GetCustomerContact returns where a customer's bills are emailed.
+//
+// encore:api private method=GET path=/admin/customers/:customerID/contact
+func (s *Service) GetCustomerContact(ctx context.Context, customerID string, params *CustomerSettingsRequest) (*CustomerContactResponse, error) {
+	contact, err := loadCustomerContact(ctx, s.db, params.TenantID, customerID)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load contact of customer %s", customerID)
+	}
+	return &CustomerContactResponse{CustomerID: customerID, Contact: contact}, nil
+}
+
+// ClearCustomerContact removes a customer's contact, so their bills are no longer
+// emailed.
+//
+// encore:api private method=DELETE path=/admin/customers/:customerID/contact
+func (s *Service) ClearCustomerContact(ctx context.Context, customerID string, params *CustomerSettingsRequest) (*CustomerContactResponse, error) {
+	if _, err := s.db.Exec(ctx, `DELETE FROM customer_contacts WHERE tenant_id = $2 AND customer_id = $1`, customerID, tenantOrDefault(params.TenantID)); err != nil {
+		return nil, apierr.Wrap(err, "failed to clear contact of customer %s", customerID)
+	}
+	return &CustomerContactResponse{CustomerID: customerID}, nil
+}
@@ -0,0 +1,64 @@
+package fees
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"encore.app/locale"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBillEmail tests that a bill's email summarizes it, carries its HTML view, and
+// attaches its PDF only when asked to.
+func TestBillEmail(t *testing.T) {
+	created := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	bill := &Bill{ID: "b1", Number: "CUST-2024-00001", Status: BillStatusClosed, Currency: "USD", TotalAmount: 12.5, CreatedAt: &created, ClosedAt: &created,
+		LineItems: []LineItem{{ID: "li1", Description: "Storage", Amount: 12.5}}}
+	settings := &BillHTMLSettings{Footer: "Questions? billing@example.com", AccentColor: "#1f6feb"}
+
+	email, err := billEmail(context.Background(), bill, locale.Default, settings, true)
+	require.NoError(t, err)
+	require.Equal(t, "Bill CUST-2024-00001: $12.50", email.Subject)
+	require.Contains(t, email.Text, "Total: $12.50\n")
+	require.Contains(t, email.Text, "- Storage: $12.50\n")
+	require.Contains(t, email.Text, "Questions? billing@example.com")
+	require.Contains(t, email.HTML, "<title>Bill CUST-2024-00001</title>")
+	require.Len(t, email.Attachments, 1)
+	require.Equal(t, "bill-CUST-2024-00001.pdf", email.Attachments[0].Filename)
+	require.Equal(t, "application/pdf", email.Attachments[0].ContentType)
+	require.True(t, bytes.HasPrefix(email.Attachments[0].Data, []byte("%PDF-")))
+
+	email, err = billEmail(context.Background(), bill, locale.Default, settings, false)
+	require.NoError(t, err)
+	require.Empty(t, email.Attachments)
+	require.NotContains(t, email.Text, "attached")
+}
+
+// TestPDFDisplay tests that bills are formatted with characters the PDF fonts have.
+func TestPDFDisplay(t *testing.T) {
+	bill := &Bill{ID: "b1", Status: BillStatusOpen, Currency: "EUR", TotalAmount: 1234.5}
+	arabic, err := locale.Parse("ar-SA")
+	require.NoError(t, err)
+	require.Equal(t, "€1,234.50", pdfDisplay(bill, arabic).Total)
+
+	german, err := locale.Parse("de-DE")
+	require.NoError(t, err)
+	require.Equal(t, "1.234,50\u00a0€", pdfDisplay(bill, german).Total)
+
+	bill.Currency = "INR"
+	indian, err := locale.Parse("en-IN")
+	require.NoError(t, err)
+	require.Equal(t, "INR\u00a01,234.50", pdfDisplay(bill, indian).Total)
+}
+
+// TestCheckRecipients tests that recipients must be bare email addresses, within the
+// limit.
+func TestCheckRecipients(t *testing.T) {
+	require.NoError(t, checkRecipients("cc", []string{"ap@example.com", "cfo@example.co.uk"}, 2))
+	require.Error(t, checkRecipients("cc", []string{"a@example.com", "b@example.com", "c@example.com"}, 2))
+	require.Error(t, checkRecipients("cc", []string{"AP <ap@example.com>"}, 2))
+	require.Error(t, checkRecipients("cc", []string{"not an email"}, 2))
+	require.False(t, validEmail(" ap@example.com"))
+}
@@ -0,0 +1,135 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"encore.app/apierr"
+	"encore.app/locale"
+)
+
+// BillDisplayParams is the query of a bill's display.
+type BillDisplayParams struct {
+	// Locale is a BCP 47 tag such as "de-DE". It defaults to en-US; a language alone,
+	// such as "de", gets the language's first supported locale.
+	Locale string `query:"locale"`
+}
+
+// BillDisplay is a bill with its amounts and dates formatted for a locale, for showing
+// it to people. Amounts are rounded to the currency's minor units and dates are in UTC.
+type BillDisplay struct {
+	BillID string `json:"billId"`
+	Number string `json:"number,omitempty"`
+	// Locale is the locale the bill is formatted for, and Direction the way its text
+	// runs, ltr or rtl.
+	Locale    string           `json:"locale"`
+	Direction locale.Direction `json:"direction"`
+	Status    BillStatus       `json:"status"`
+	Currency  string           `json:"currency"`
+	// Subtotal sums the line items other than the bill limits adjustment and the
+	// applied credit, which Adjustment and Credit show. Total is the bill's running
+	// total while it is open.
+	Subtotal   string            `json:"subtotal"`
+	Adjustment string            `json:"adjustment,omitempty"`
+	Credit     string            `json:"credit,omitempty"`
+	Total      string            `json:"total"`
+	AmountPaid string            `json:"amountPaid,omitempty"`
+	BalanceDue string            `json:"balanceDue,omitempty"`
+	CreatedAt  string            `json:"createdAt"`
+	ClosedAt   string            `json:"closedAt,omitempty"`
+	DueDate    string            `json:"dueDate,omitempty"`
+	LineItems  []LineItemDisplay `json:"lineItems"`
+}
+
+// LineItemDisplay is a line item formatted for a locale.
+type LineItemDisplay struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	// Quantity and UnitPrice are set on items priced per unit; the quantity carries
+	// its unit, as in "12.5 GB".
+	Quantity  string `json:"quantity,omitempty"`
+	UnitPrice string `json:"unitPrice,omitempty"`
+	Amount    string `json:"amount"`
+}
+
+// parseLocale returns the locale for a tag, failing with InvalidArgument for tags
+// that are not supported.
+func parseLocale(tag string) (locale.Locale, error) {
+	l, err := locale.Parse(tag)
+	if errors.Is(err, locale.ErrUnknownLocale) {
+		return locale.Locale{}, apierr.InvalidArgument(apierr.InvalidParameter, "unsupported locale %q: must be one of %s", tag, strings.Join(locale.Tags(), ", "))
+	}
+	return l, err
+}
+
+// displayBill formats bill for l.
+func displayBill(bill *Bill, l locale.Locale) *BillDisplay {
+	date := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return l.Date(t.UTC())
+	}
+	d := &BillDisplay{
+		BillID:    bill.ID,
+		Number:    bill.Number,
+		Locale:    l.Tag,
+		Direction: l.Direction,
+		Status:    bill.Status,
+		Currency:  bill.Currency,
+		Total:     l.Amount(bill.TotalAmount, bill.Currency),
+		CreatedAt: date(bill.CreatedAt),
+		ClosedAt:  date(bill.ClosedAt),
+		DueDate:   date(bill.DueDate),
+		LineItems: make([]LineItemDisplay, 0, len(bill.LineItems)),
+	}
+	var adjustmentItem, creditItem string
+	if bill.Adjustment != nil && bill.Adjustment.LineItemID != "" {
+		adjustmentItem = bill.Adjustment.LineItemID
+		d.Adjustment = l.Amount(bill.Adjustment.Amount, bill.Currency)
+	}
+	if bill.AppliedCredit != nil {
+		creditItem = bill.AppliedCredit.LineItemID
+		d.Credit = l.Amount(-bill.AppliedCredit.Amount, bill.Currency)
+	}
+	subtotal := 0.0
+	for _, item := range bill.LineItems {
+		if item.ID != adjustmentItem && item.ID != creditItem {
+			subtotal += item.Amount
+		}
+	}
+	d.Subtotal = l.Amount(subtotal, bill.Currency)
+	if bill.AmountPaid != 0 {
+		d.AmountPaid = l.Amount(bill.AmountPaid, bill.Currency)
+	}
+	if bill.BalanceDue != nil {
+		d.BalanceDue = l.Amount(*bill.BalanceDue, bill.Currency)
+	}
+	for _, item := range bill.LineItems {
+		line := LineItemDisplay{ID: item.ID, Description: item.Description, Amount: l.Amount(item.Amount, bill.Currency)}
+		if item.Quantity != 0 {
+			line.Quantity = strings.TrimSpace(l.Number(item.Quantity, -1) + " " + item.Unit)
+			line.UnitPrice = l.Price(item.UnitPrice, bill.Currency)
+		}
+		d.LineItems = append(d.LineItems, line)
+	}
+	return d
+}
+
+// GetBillDisplay returns a bill with its amounts and dates formatted for a locale,
+// with separators, currency symbols, and digits as readers there expect them.
+//
+// encore:api auth method=GET path=/bills/:billID/display
+func (s *Service) GetBillDisplay(ctx context.Context, billID string, params *BillDisplayParams) (*BillDisplay, error) {
+	l, err := parseLocale(params.Locale)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.getBill(ctx, billID)
+	if err != nil {
+		return nil, err
+	}
+	return displayBill(&resp.RetrievedBill, l), nil
+}
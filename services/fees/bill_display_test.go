@@ -0,0 +1,49 @@
+package fees
+
+import (
+	"testing"
+	"time"
+
+	"encore.app/apierr"
+	"encore.app/locale"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDisplayBill tests that a bill's amounts and dates are formatted for the locale.
+func TestDisplayBill(t *testing.T) {
+	created := time.Date(2024, 1, 31, 23, 0, 0, 0, time.FixedZone("CET", 3600))
+	balance := 0.0
+	bill := &Bill{
+		ID: "b1", Number: "c1-2024-00001", Status: BillStatusPaid, Currency: "EUR",
+		TotalAmount: 1234.5, AmountPaid: 1234.5, BalanceDue: &balance, CreatedAt: &created,
+		LineItems: []LineItem{
+			{ID: "li1", Description: "Storage", Amount: 1234, Quantity: 12340, UnitPrice: 0.1, Unit: "GB"},
+			{ID: "li2", Description: "Support", Amount: 0.5},
+		},
+	}
+	de, err := parseLocale("de-DE")
+	require.NoError(t, err)
+
+	d := displayBill(bill, de)
+	require.Equal(t, "de-DE", d.Locale)
+	require.Equal(t, locale.LeftToRight, d.Direction)
+	require.Equal(t, "1.234,50\u00a0€", d.Total)
+	require.Equal(t, "1.234,50\u00a0€", d.Subtotal)
+	require.Empty(t, d.Adjustment)
+	require.Equal(t, "0,00\u00a0€", d.BalanceDue)
+	require.Equal(t, "31.01.2024", d.CreatedAt, "dates are in UTC")
+	require.Empty(t, d.ClosedAt)
+	require.Equal(t, "12.340 GB", d.LineItems[0].Quantity)
+	require.Equal(t, "0,10\u00a0€", d.LineItems[0].UnitPrice)
+	require.Empty(t, d.LineItems[1].Quantity)
+	require.Equal(t, "0,50\u00a0€", d.LineItems[1].Amount)
+}
+
+// TestParseLocale tests that unsupported locales are rejected as invalid parameters.
+func TestParseLocale(t *testing.T) {
+	l, err := parseLocale("")
+	require.NoError(t, err)
+	require.Equal(t, locale.Default.Tag, l.Tag)
+	_, err = parseLocale("tlh-KX")
+	require.Equal(t, apierr.InvalidParameter, apierr.ReasonOf(err))
+}
@@ -0,0 +1,170 @@
+package fees
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev"
+	"encore.dev/beta/errs"
+	"encore.dev/storage/sqldb"
+)
+
+const (
+	// billEventStreamPollInterval is how often StreamBillEvents looks for new events.
+	billEventStreamPollInterval = time.Second
+	// billEventStreamKeepAlive is how long a stream may go without writing before it
+	// sends a comment, so proxies do not close it as idle.
+	billEventStreamKeepAlive = 15 * time.Second
+	// billEventStreamBatchSize caps the events StreamBillEvents reads per query.
+	billEventStreamBatchSize = 100
+	// billEventStreamContentType is the media type of server-sent events.
+	billEventStreamContentType = "text/event-stream"
+)
+
+// parseBillEventStreamAfter returns the sequence of the last event the client has: the
+// Last-Event-ID header an EventSource sends when it reconnects, else the after query
+// parameter, else 0 for the whole stream.
+func parseBillEventStreamAfter(req *http.Request) (int64, error) {
+	v, name := req.Header.Get("Last-Event-ID"), "Last-Event-ID"
+	if v == "" {
+		v, name = req.URL.Query().Get("after"), "after"
+	}
+	if v == "" {
+		return 0, nil
+	}
+	after, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || after < 0 {
+		return 0, apierr.InvalidArgument(apierr.InvalidParameter, "invalid %s %q: must be the sequence of an event", name, v)
+	}
+	return after, nil
+}
+
+// writeBillEventSSE writes e as a server-sent event: its sequence is the event's id, so
+// a reconnecting client resumes after it, its type the event name, and the event itself
+// the JSON data.
+func writeBillEventSSE(w io.Writer, e *BillEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode event %d: %w", e.Sequence, err)
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Sequence, e.Type, data)
+	return err
+}
+
+// loadBillEventsAfter returns up to limit events of a bill after sequence after, oldest
+// first.
+func loadBillEventsAfter(ctx context.Context, db *tracedDB, fields *fieldCipher, billID string, after int64, limit int) ([]BillEvent, error) {
+	return queryBillEvents(ctx, db, fields, `
+        SELECT sequence, type, bill_version, data, occurred_at
+        FROM bill_events
+        WHERE bill_id = $1 AND sequence > $2
+        ORDER BY sequence
+        LIMIT $3
+    `, billID, after, limit)
+}
+
+// authorizeBillEvents checks that the caller may see the events of billID.
+func (s *Service) authorizeBillEvents(ctx context.Context, billID string) error {
+	if !callerBillModes(ctx, true).covers(billID) {
+		return apierr.NotFound(apierr.BillNotFound, "bill %s not found", billID)
+	}
+	var tenantID string
+	err := s.db.QueryRow(ctx, `SELECT tenant_id FROM bills WHERE id = $1`, billID).Scan(&tenantID)
+	if errors.Is(err, sqldb.ErrNoRows) || (err == nil && !visibleToCaller(ctx, tenantID)) {
+		return apierr.NotFound(apierr.BillNotFound, "bill %s not found", billID)
+	}
+	if err != nil {
+		return apierr.Wrap(err, "failed to load bill %s", billID)
+	}
+	return nil
+}
+
+// StreamBillEvents pushes a bill's events as server-sent events as they are recorded,
+// such as line_item.added, bill.closed and payment.recorded, for live dashboards of a
+// session's fees. It sends the events the client does not have yet, then new ones as
+// they happen, until the client disconnects. A client reconnecting with Last-Event-ID
+// resumes after that event.
+//
+// The stream reads the bill_events table, from which the event relay publishes to the
+// bill-events topic, rather than subscribing to the topic: a subscription delivers each
+// event to one replica, not to every replica holding a stream.
+//
+// encore:api auth raw method=GET path=/bills/:billID/events/stream
+func (s *Service) StreamBillEvents(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	// Raw endpoints write their own response, so the scope is checked here.
+	if err := checkScope("StreamBillEvents", caller(ctx)); err != nil {
+		errs.HTTPError(w, err)
+		return
+	}
+	billID := encore.CurrentRequest().PathParams.Get("billID")
+	after, err := parseBillEventStreamAfter(req)
+	if err != nil {
+		errs.HTTPError(w, err)
+		return
+	}
+	if err := s.authorizeBillEvents(ctx, billID); err != nil {
+		errs.HTTPError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", billEventStreamContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	// Proxies such as nginx would otherwise hold events back until their buffer fills.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	flush()
+	logger := loggerFrom(ctx)
+
+	lastWrite := s.clock.Now()
+	for {
+		events, err := loadBillEventsAfter(ctx, s.db, s.fields, billID, after, billEventStreamBatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				// Ending the stream makes the client reconnect and resume after the last event.
+				logger.Error("Failed to stream bill events", "bill_id", billID, "after", after, "error", err)
+			}
+			return
+		}
+		for i := range events {
+			if err := writeBillEventSSE(w, &events[i]); err != nil {
+				// The client went away.
+				return
+			}
+			after = events[i].Sequence
+		}
+		switch now := s.clock.Now(); {
+		case len(events) > 0:
+			flush()
+			lastWrite = now
+		case now.Sub(lastWrite) >= billEventStreamKeepAlive:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flush()
+			lastWrite = now
+		}
+		if len(events) == billEventStreamBatchSize {
+			// More events are waiting.
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(billEventStreamPollInterval):
+		}
+	}
+}
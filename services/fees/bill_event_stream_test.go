@@ -0,0 +1,55 @@
+package fees
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"encore.app/apierr"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseBillEventStreamAfter tests that a reconnecting EventSource's Last-Event-ID
+// wins over the after parameter, and that neither defaults to the whole stream.
+func TestParseBillEventStreamAfter(t *testing.T) {
+	req := httptest.NewRequest("GET", "/bills/b1/events/stream", nil)
+	after, err := parseBillEventStreamAfter(req)
+	require.NoError(t, err)
+	require.Zero(t, after)
+
+	req = httptest.NewRequest("GET", "/bills/b1/events/stream?after=4", nil)
+	after, err = parseBillEventStreamAfter(req)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), after)
+
+	req.Header.Set("Last-Event-ID", "7")
+	after, err = parseBillEventStreamAfter(req)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), after)
+
+	for _, v := range []string{"x", "-1"} {
+		req := httptest.NewRequest("GET", "/bills/b1/events/stream?after="+v, nil)
+		_, err := parseBillEventStreamAfter(req)
+		require.Equal(t, apierr.InvalidParameter, apierr.ReasonOf(err), v)
+	}
+}
+
+// TestWriteBillEventSSE tests that an event is framed with its sequence as the id and
+// its type as the event name.
+func TestWriteBillEventSSE(t *testing.T) {
+	var b strings.Builder
+	e := &BillEvent{
+		Sequence:   3,
+		Type:       AuditLineItemAdded,
+		Data:       BillEventData{LineItem: &LineItem{ID: "li-1", Amount: 2.5}},
+		OccurredAt: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+	require.NoError(t, writeBillEventSSE(&b, e))
+
+	frame := b.String()
+	require.True(t, strings.HasPrefix(frame, "id: 3\nevent: line_item.added\ndata: {"), frame)
+	require.True(t, strings.HasSuffix(frame, "}\n\n"), frame)
+	require.Contains(t, frame, `"lineItem":{"id":"li-1"`)
+	require.Equal(t, 1, strings.Count(strings.TrimSuffix(frame, "\n\n"), "data:"))
+}
@@ -0,0 +1,287 @@
+package fees
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"encore.app/apierr"
+	"encore.app/locale"
+	"encore.dev"
+	"encore.dev/beta/errs"
+	"encore.dev/storage/sqldb"
+)
+
+const (
+	// defaultAccentColor colors the default template's header and total.
+	defaultAccentColor = "#1f6feb"
+	// maxBillHTMLTemplateSize bounds a tenant's template, and maxFrameAncestors the
+	// origins it may allow to embed bills.
+	maxBillHTMLTemplateSize = 64 << 10
+	maxFrameAncestors       = 20
+	maxBillHTMLFooterLength = 2000
+)
+
+//go:embed templates/bill.html
+var defaultBillHTMLSource string
+
+// defaultBillHTMLTemplate renders bills of tenants without a template of their own.
+var defaultBillHTMLTemplate = template.Must(template.New("bill").Parse(defaultBillHTMLSource))
+
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// BillHTMLTax is a tax line of a rendered bill. Bills carry no taxes yet, so templates
+// get none; they are passed so templates can show them once bills do.
+type BillHTMLTax struct {
+	Name   string
+	Amount string
+}
+
+// billHTMLData is what bill templates render: the bill formatted for the locale, and
+// the tenant's footer and accent color.
+type billHTMLData struct {
+	Title       string
+	Bill        *BillDisplay
+	Taxes       []BillHTMLTax
+	Footer      string
+	AccentColor string
+}
+
+// BillHTMLSettings is how a tenant's bills are rendered by GET /bills/:billID/html.
+type BillHTMLSettings struct {
+	TenantID string `json:"tenantId"`
+	// Template is a Go html/template replacing the default one, or empty to keep it.
+	// It renders .Title, .Bill (as GET /bills/:billID/display returns it), .Taxes,
+	// .Footer, and .AccentColor.
+	Template    string `json:"template,omitempty"`
+	Footer      string `json:"footer,omitempty"`
+	AccentColor string `json:"accentColor"`
+	// FrameAncestors are the origins allowed to embed the page in a frame, such as
+	// "https://dashboard.example.com". Empty allows any.
+	FrameAncestors []string   `json:"frameAncestors"`
+	UpdatedAt      *time.Time `json:"updatedAt,omitempty"`
+}
+
+// BillHTMLSettingsRequest is the request payload for replacing a tenant's settings.
+type BillHTMLSettingsRequest struct {
+	Template string `json:"template,omitempty"`
+	Footer   string `json:"footer,omitempty"`
+	// AccentColor is a hex color such as "#1f6feb"; empty keeps the default.
+	AccentColor    string   `json:"accentColor,omitempty"`
+	FrameAncestors []string `json:"frameAncestors,omitempty"`
+}
+
+// validate rejects settings that could not render a bill.
+func (r *BillHTMLSettingsRequest) validate() error {
+	if len(r.Template) > maxBillHTMLTemplateSize {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "template must be at most %d bytes", maxBillHTMLTemplateSize)
+	}
+	if len([]rune(r.Footer)) > maxBillHTMLFooterLength {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "footer must be at most %d characters", maxBillHTMLFooterLength)
+	}
+	if r.AccentColor != "" && !hexColor.MatchString(r.AccentColor) {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "invalid accentColor %q: must be a hex color such as \"#1f6feb\"", r.AccentColor)
+	}
+	if len(r.FrameAncestors) > maxFrameAncestors {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "at most %d frameAncestors are allowed", maxFrameAncestors)
+	}
+	for _, origin := range r.FrameAncestors {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme != "https" || u.Host == "" || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "invalid frameAncestors origin %q: must be an https origin such as \"https://dashboard.example.com\"", origin)
+		}
+	}
+	if r.Template != "" {
+		tmpl, err := template.New("bill").Parse(r.Template)
+		if err != nil {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "invalid template: %v", err)
+		}
+		// Rendering a sample bill catches fields the template names that do not exist.
+		if err := tmpl.Execute(&bytes.Buffer{}, sampleBillHTMLData()); err != nil {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "invalid template: %v", err)
+		}
+	}
+	return nil
+}
+
+// sampleBillHTMLData is a bill with every field set, for checking templates.
+func sampleBillHTMLData() *billHTMLData {
+	now := time.Now()
+	balance := 0.0
+	bill := &Bill{
+		ID: "sample", Number: "CUST123-2024-00042", Status: BillStatusPaid, Currency: "USD",
+		TotalAmount: 10, AmountPaid: 10, BalanceDue: &balance, CreatedAt: &now, ClosedAt: &now, DueDate: &now,
+		Adjustment:    &BillAdjustment{Kind: AdjustmentMinimumCommitment, Amount: 5, LineItemID: "adjustment"},
+		AppliedCredit: &AppliedCredit{Amount: 1, LineItemID: "credit"},
+		LineItems: []LineItem{
+			{ID: "usage", Description: "Storage", Amount: 6, Quantity: 75, UnitPrice: 0.08, Unit: "GB"},
+			{ID: "adjustment", Description: "Minimum commitment", Amount: 5},
+			{ID: "credit", Description: "Credit", Amount: -1},
+		},
+	}
+	return newBillHTMLData(bill, locale.Default, &BillHTMLSettings{Footer: "Thank you.", AccentColor: defaultAccentColor})
+}
+
+// newBillHTMLData formats bill for l with the tenant's settings.
+func newBillHTMLData(bill *Bill, l locale.Locale, settings *BillHTMLSettings) *billHTMLData {
+	title := "Bill " + bill.ID
+	if bill.Number != "" {
+		title = "Bill " + bill.Number
+	}
+	return &billHTMLData{
+		Title:       title,
+		Bill:        displayBill(bill, l),
+		Taxes:       []BillHTMLTax{},
+		Footer:      settings.Footer,
+		AccentColor: settings.AccentColor,
+	}
+}
+
+// loadBillHTMLSettings returns the tenant's settings, or the defaults if it has none.
+func loadBillHTMLSettings(ctx context.Context, db *tracedDB, tenantID string) (*BillHTMLSettings, error) {
+	settings := &BillHTMLSettings{TenantID: tenantID, AccentColor: defaultAccentColor, FrameAncestors: []string{}}
+	var accentColor *string
+	err := db.QueryRow(ctx, `
+        SELECT COALESCE(template, ''), footer, accent_color, frame_ancestors, updated_at
+        FROM bill_html_settings WHERE tenant_id = $1
+    `, tenantID).Scan(&settings.Template, &settings.Footer, &accentColor, &settings.FrameAncestors, &settings.UpdatedAt)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if accentColor != nil {
+		settings.AccentColor = *accentColor
+	}
+	return settings, nil
+}
+
+// renderBillHTML renders the bill with the tenant's template. A tenant template that
+// fails to render, such as one written for fields since removed, falls back to the
+// default template.
+func renderBillHTML(ctx context.Context, bill *Bill, l locale.Locale, settings *BillHTMLSettings) ([]byte, error) {
+	data := newBillHTMLData(bill, l, settings)
+	var buf bytes.Buffer
+	if settings.Template != "" {
+		tmpl, err := template.New("bill").Parse(settings.Template)
+		if err == nil {
+			err = tmpl.Execute(&buf, data)
+		}
+		if err == nil {
+			return buf.Bytes(), nil
+		}
+		loggerFrom(ctx).Warn("Failed to render tenant bill template; using the default", "tenant_id", settings.TenantID, "error", err)
+		buf.Reset()
+	}
+	if err := defaultBillHTMLTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// frameAncestorsPolicy is the Content-Security-Policy of a rendered bill. Pages run no
+// scripts and load nothing but inline styles, and may be framed by the tenant's
+// dashboard origins, or by any if it named none.
+func frameAncestorsPolicy(origins []string) string {
+	ancestors := "*"
+	if len(origins) > 0 {
+		ancestors = strings.Join(origins, " ")
+	}
+	return "default-src 'none'; style-src 'unsafe-inline'; img-src https: data:; frame-ancestors " + ancestors
+}
+
+// ------ API ------
+
+// GetBillHTML renders a bill as a styled, printable HTML page, formatted for the locale
+// in ?locale= like GET /bills/:billID/display, with the template and footer of the
+// bill's tenant. The page is self-contained, for embedding in a customer dashboard.
+//
+// encore:api auth raw method=GET path=/bills/:billID/html
+func (s *Service) GetBillHTML(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	// Raw endpoints write their own response, so the scope is checked here.
+	if err := checkScope("GetBillHTML", caller(ctx)); err != nil {
+		errs.HTTPError(w, err)
+		return
+	}
+	l, err := parseLocale(req.URL.Query().Get("locale"))
+	if err != nil {
+		errs.HTTPError(w, err)
+		return
+	}
+	resp, err := s.getBill(ctx, encore.CurrentRequest().PathParams.Get("billID"))
+	if err != nil {
+		errs.HTTPError(w, err)
+		return
+	}
+	bill := &resp.RetrievedBill
+	settings, err := loadBillHTMLSettings(ctx, s.db, tenantOrDefault(bill.TenantID))
+	if err != nil {
+		errs.HTTPError(w, apierr.Wrap(err, "failed to load HTML settings of tenant %s", bill.TenantID))
+		return
+	}
+	page, err := renderBillHTML(ctx, bill, l, settings)
+	if err != nil {
+		errs.HTTPError(w, apierr.Wrap(err, "failed to render bill %s", bill.ID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", frameAncestorsPolicy(settings.FrameAncestors))
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(page)
+}
+
+// SetBillHTMLSettings replaces how a tenant's bills are rendered as HTML. It is private
+// so it can only be called by internal admin tooling.
+//
+// encore:api private method=PUT path=/admin/html-settings/:tenantID
+func (s *Service) SetBillHTMLSettings(ctx context.Context, tenantID string, params *BillHTMLSettingsRequest) (*BillHTMLSettings, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	ancestors := params.FrameAncestors
+	if ancestors == nil {
+		ancestors = []string{}
+	}
+	_, err := s.db.Exec(ctx, `
+        INSERT INTO bill_html_settings (tenant_id, template, footer, accent_color, frame_ancestors, updated_at)
+        VALUES ($1, $2, $3, $4, $5, NOW())
+        ON CONFLICT (tenant_id) DO UPDATE
+        SET template = EXCLUDED.template, footer = EXCLUDED.footer, accent_color = EXCLUDED.accent_color,
+            frame_ancestors = EXCLUDED.frame_ancestors, updated_at = EXCLUDED.updated_at
+    `, tenantID, nullIfEmpty(params.Template), params.Footer, nullIfEmpty(params.AccentColor), ancestors)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to save HTML settings of tenant %s", tenantID)
+	}
+	return s.GetBillHTMLSettings(ctx, tenantID)
+}
+
+// GetBillHTMLSettings returns how a tenant's bills are rendered as HTML.
+//
+// encore:api private method=GET path=/admin/html-settings/:tenantID
+func (s *Service) GetBillHTMLSettings(ctx context.Context, tenantID string) (*BillHTMLSettings, error) {
+	settings, err := loadBillHTMLSettings(ctx, s.db, tenantID)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load HTML settings of tenant %s", tenantID)
+	}
+	return settings, nil
+}
+
+// ClearBillHTMLSettings restores the default rendering of a tenant's bills.
+//
+// encore:api private method=DELETE path=/admin/html-settings/:tenantID
+func (s *Service) ClearBillHTMLSettings(ctx context.Context, tenantID string) (*BillHTMLSettings, error) {
+	if _, err := s.db.Exec(ctx, `DELETE FROM bill_html_settings WHERE tenant_id = $1`, tenantID); err != nil {
+		return nil, apierr.Wrap(err, "failed to clear HTML settings of tenant %s", tenantID)
+	}
+	return s.GetBillHTMLSettings(ctx, tenantID)
+}
@@ -0,0 +1,66 @@
+package fees
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"encore.app/apierr"
+	"encore.app/locale"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRenderBillHTML tests that bills render with the default template, escaped, and
+// that a tenant template that fails falls back to it.
+func TestRenderBillHTML(t *testing.T) {
+	created := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	bill := &Bill{ID: "b1", Status: BillStatusOpen, Currency: "USD", TotalAmount: 12.5, CreatedAt: &created,
+		LineItems: []LineItem{{ID: "li1", Description: "<script>alert(1)</script>", Amount: 12.5}}}
+	settings := &BillHTMLSettings{Footer: "Questions? billing@example.com", AccentColor: "#aa0000"}
+
+	page, err := renderBillHTML(context.Background(), bill, locale.Default, settings)
+	require.NoError(t, err)
+	html := string(page)
+	require.Contains(t, html, "<title>Bill b1</title>")
+	require.Contains(t, html, "$12.50")
+	require.Contains(t, html, "&lt;script&gt;alert(1)&lt;/script&gt;")
+	require.NotContains(t, html, "<script>")
+	require.Contains(t, html, "#aa0000")
+	require.Contains(t, html, "Questions? billing@example.com")
+
+	settings.Template = `{{.Bill.Missing}}`
+	page, err = renderBillHTML(context.Background(), bill, locale.Default, settings)
+	require.NoError(t, err)
+	require.Contains(t, string(page), "<title>Bill b1</title>")
+}
+
+// TestBillHTMLSettingsValidate tests that settings that could not render bills are
+// rejected.
+func TestBillHTMLSettingsValidate(t *testing.T) {
+	valid := BillHTMLSettingsRequest{
+		Template:       `<h1>{{.Title}}</h1>{{range .Bill.LineItems}}{{.Amount}}{{end}}`,
+		AccentColor:    "#1F6FEB",
+		FrameAncestors: []string{"https://dashboard.example.com"},
+	}
+	require.NoError(t, valid.validate())
+
+	for name, r := range map[string]BillHTMLSettingsRequest{
+		"unparsable template": {Template: `{{.Title`},
+		"unknown field":       {Template: `{{.Bill.Tax}}`},
+		"named color":         {AccentColor: "red"},
+		"insecure origin":     {FrameAncestors: []string{"http://dashboard.example.com"}},
+		"origin with path":    {FrameAncestors: []string{"https://example.com/bills"}},
+		"oversized template":  {Template: strings.Repeat("x", maxBillHTMLTemplateSize+1)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, apierr.InvalidParameter, apierr.ReasonOf(r.validate()))
+		})
+	}
+}
+
+// TestFrameAncestorsPolicy tests that bills may be framed by the tenant's origins only.
+func TestFrameAncestorsPolicy(t *testing.T) {
+	require.True(t, strings.HasSuffix(frameAncestorsPolicy(nil), "frame-ancestors *"))
+	require.True(t, strings.HasSuffix(frameAncestorsPolicy([]string{"https://a.example", "https://b.example"}), "frame-ancestors https://a.example https://b.example"))
+}
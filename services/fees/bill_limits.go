@@ -0,0 +1,211 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"encore.app/apierr"
+	"encore.dev/storage/sqldb"
+)
+
+// OverMaximumAction decides what happens to a bill whose total exceeds the customer's maximum.
+type OverMaximumAction string
+
+const (
+	// OverMaximumCap adds a negative line item that brings the total down to the maximum.
+	OverMaximumCap OverMaximumAction = "cap"
+	// OverMaximumFlag leaves the total as is and only records that the maximum was exceeded.
+	OverMaximumFlag OverMaximumAction = "flag"
+)
+
+// AdjustmentKind names how a bill's total was adjusted against its customer's limits.
+type AdjustmentKind string
+
+const (
+	AdjustmentMinimumCommitment AdjustmentKind = "minimum_commitment"
+	AdjustmentMaximumCap        AdjustmentKind = "maximum_cap"
+	AdjustmentMaximumExceeded   AdjustmentKind = "maximum_exceeded"
+)
+
+// BillLimits bounds the total of a customer's bills in one currency. A zero bound is unset.
+type BillLimits struct {
+	MinimumTotal float64           `json:"minimumTotal,omitempty"`
+	MaximumTotal float64           `json:"maximumTotal,omitempty"`
+	OverMaximum  OverMaximumAction `json:"overMaximum,omitempty"`
+	// HardCap is enforced as line items arrive rather than on close, per OverHardCap.
+	HardCap     float64       `json:"hardCap,omitempty"`
+	OverHardCap HardCapAction `json:"overHardCap,omitempty"`
+}
+
+// BillAdjustment records the adjustment applied to a bill's total when it closed.
+type BillAdjustment struct {
+	Kind AdjustmentKind `json:"kind"`
+	// Limit is the minimum or maximum total that triggered the adjustment.
+	Limit float64 `json:"limit"`
+	// TotalBefore is the bill's total before the adjustment.
+	TotalBefore float64 `json:"totalBefore"`
+	// Amount and LineItemID describe the line item added to reach Limit. They are
+	// empty when the bill was only flagged.
+	Amount     float64 `json:"amount,omitempty"`
+	LineItemID string  `json:"lineItemId,omitempty"`
+}
+
+// description is the text of the line item an adjustment adds.
+func (k AdjustmentKind) description() string {
+	switch k {
+	case AdjustmentMinimumCommitment:
+		return "Minimum commitment"
+	case AdjustmentMaximumCap:
+		return "Maximum bill cap"
+	}
+	return ""
+}
+
+// adjustment returns the adjustment a bill totalling total needs to respect l, or nil.
+func (l *BillLimits) adjustment(total float64) *BillAdjustment {
+	if l == nil {
+		return nil
+	}
+	switch {
+	case l.MinimumTotal > 0 && total < l.MinimumTotal:
+		return &BillAdjustment{Kind: AdjustmentMinimumCommitment, Limit: l.MinimumTotal, TotalBefore: total, Amount: roundAmount(l.MinimumTotal - total)}
+	case l.MaximumTotal > 0 && total > l.MaximumTotal && l.OverMaximum == OverMaximumFlag:
+		return &BillAdjustment{Kind: AdjustmentMaximumExceeded, Limit: l.MaximumTotal, TotalBefore: total}
+	case l.MaximumTotal > 0 && total > l.MaximumTotal:
+		return &BillAdjustment{Kind: AdjustmentMaximumCap, Limit: l.MaximumTotal, TotalBefore: total, Amount: roundAmount(l.MaximumTotal - total)}
+	}
+	return nil
+}
+
+// normalize validates the limits and fills in the default actions.
+func (l *BillLimits) normalize() error {
+	if l.MinimumTotal < 0 || l.MaximumTotal < 0 || l.HardCap < 0 {
+		return apierr.InvalidArgument(apierr.InvalidAmount, "bill limits must not be negative")
+	}
+	if l.MaximumTotal > 0 && l.MaximumTotal < l.MinimumTotal {
+		return apierr.InvalidArgument(apierr.InvalidAmount, "maximumTotal %v is below minimumTotal %v", l.MaximumTotal, l.MinimumTotal)
+	}
+	if l.OverMaximum == "" {
+		l.OverMaximum = OverMaximumCap
+	}
+	if l.OverMaximum != OverMaximumCap && l.OverMaximum != OverMaximumFlag {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "invalid overMaximum %q: must be \"cap\" or \"flag\"", l.OverMaximum)
+	}
+	if l.HardCap > 0 && l.HardCap < l.MinimumTotal {
+		return apierr.InvalidArgument(apierr.InvalidAmount, "hardCap %v is below minimumTotal %v", l.HardCap, l.MinimumTotal)
+	}
+	if l.OverHardCap == "" {
+		l.OverHardCap = HardCapReject
+	}
+	if l.OverHardCap != HardCapReject && l.OverHardCap != HardCapFlag {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "invalid overHardCap %q: must be \"reject\" or \"flag\"", l.OverHardCap)
+	}
+	return nil
+}
+
+// roundAmount rounds to the four decimal places amounts are stored with.
+func roundAmount(v float64) float64 {
+	return math.Round(v*1e4) / 1e4
+}
+
+// loadBillLimits returns the customer's limits for bills in currency, or nil if none are set.
+func loadBillLimits(ctx context.Context, db *tracedDB, tenantID, customerID, currency string) (*BillLimits, error) {
+	var l BillLimits
+	err := db.QueryRow(ctx, `
+        SELECT minimum_total, maximum_total, over_maximum, hard_cap, over_hard_cap
+        FROM customer_bill_limits
+        WHERE tenant_id = $3 AND customer_id = $1 AND currency = $2
+    `, customerID, currency, tenantOrDefault(tenantID)).Scan(&l.MinimumTotal, &l.MaximumTotal, &l.OverMaximum, &l.HardCap, &l.OverHardCap)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bill limits for customer %s: %w", customerID, err)
+	}
+	return &l, nil
+}
+
+// SetBillLimitsRequest is the request payload for setting a customer's bill limits.
+type SetBillLimitsRequest struct {
+	CustomerSettingsRequest
+	// MinimumTotal tops up bills below it with a "Minimum commitment" line item; 0 unsets it.
+	MinimumTotal float64 `json:"minimumTotal"`
+	// MaximumTotal caps or flags bills above it, per OverMaximum; 0 unsets it.
+	MaximumTotal float64 `json:"maximumTotal"`
+	// OverMaximum is "cap" (the default) or "flag".
+	OverMaximum OverMaximumAction `json:"overMaximum,omitempty"`
+	// HardCap rejects or flags line items that would take a bill's total above it, per
+	// OverHardCap; 0 unsets it.
+	HardCap float64 `json:"hardCap"`
+	// OverHardCap is "reject" (the default) or "flag".
+	OverHardCap HardCapAction `json:"overHardCap,omitempty"`
+}
+
+// BillLimitsResponse reports a customer's bill limits in one currency.
+type BillLimitsResponse struct {
+	RetryMetadata
+	CustomerID string `json:"customerId"`
+	Currency   string `json:"currency"`
+	// Limits is absent when the customer has no limits in the currency.
+	Limits *BillLimits `json:"limits,omitempty"`
+}
+
+// SetBillLimits sets a customer's minimum and maximum bill totals and hard cap in one
+// currency. They apply to bills created afterwards. It is private so it can only be
+// called by internal admin tooling.
+//
+// encore:api private method=PUT path=/admin/customers/:customerID/bill-limits/:currency
+func (s *Service) SetBillLimits(ctx context.Context, customerID string, currency string, params *SetBillLimitsRequest) (*BillLimitsResponse, error) {
+	if !validCurrency(currency) {
+		return nil, apierr.InvalidArgument(apierr.InvalidCurrency, "invalid currency %q: must be a three-letter ISO 4217 code such as \"USD\"", currency)
+	}
+	limits := BillLimits{
+		MinimumTotal: params.MinimumTotal,
+		MaximumTotal: params.MaximumTotal,
+		OverMaximum:  params.OverMaximum,
+		HardCap:      params.HardCap,
+		OverHardCap:  params.OverHardCap,
+	}
+	if err := limits.normalize(); err != nil {
+		return nil, err
+	}
+
+	_, err := s.db.Exec(ctx, `
+        INSERT INTO customer_bill_limits (tenant_id, customer_id, currency, minimum_total, maximum_total, over_maximum, hard_cap, over_hard_cap, updated_at)
+        VALUES ($8, $1, $2, $3, $4, $5, $6, $7, NOW())
+        ON CONFLICT (tenant_id, customer_id, currency) DO UPDATE
+        SET minimum_total = EXCLUDED.minimum_total, maximum_total = EXCLUDED.maximum_total,
+            over_maximum = EXCLUDED.over_maximum, hard_cap = EXCLUDED.hard_cap,
+            over_hard_cap = EXCLUDED.over_hard_cap, updated_at = EXCLUDED.updated_at
+    `, customerID, currency, limits.MinimumTotal, limits.MaximumTotal, limits.OverMaximum, limits.HardCap, limits.OverHardCap, tenantOrDefault(params.TenantID))
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to save bill limits for customer %s", customerID)
+	}
+	return s.GetBillLimits(ctx, customerID, currency, &params.CustomerSettingsRequest)
+}
+
+// GetBillLimits returns a customer's bill limits in one currency.
+//
+// encore:api private method=GET path=/admin/customers/:customerID/bill-limits/:currency
+func (s *Service) GetBillLimits(ctx context.Context, customerID string, currency string, params *CustomerSettingsRequest) (*BillLimitsResponse, error) {
+	limits, err := loadBillLimits(ctx, s.db, params.TenantID, customerID, currency)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load bill limits for customer %s", customerID)
+	}
+	return &BillLimitsResponse{CustomerID: customerID, Currency: currency, Limits: limits}, nil
+}
+
+// ClearBillLimits removes a customer's bill limits in one currency.
+//
+// encore:api private method=DELETE path=/admin/customers/:customerID/bill-limits/:currency
+func (s *Service) ClearBillLimits(ctx context.Context, customerID string, currency string, params *CustomerSettingsRequest) (*BillLimitsResponse, error) {
+	_, err := s.db.Exec(ctx, `
+        DELETE FROM customer_bill_limits WHERE tenant_id = $3 AND customer_id = $1 AND currency = $2
+    `, customerID, currency, tenantOrDefault(params.TenantID))
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to clear bill limits for customer %s", customerID)
+	}
+	return &BillLimitsResponse{CustomerID: customerID, Currency: currency}, nil
+}
@@ -0,0 +1,22 @@
+package fees
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestBillLimits_Adjustment tests that totals outside the limits are topped up, capped, or flagged.
+func TestBillLimits_Adjustment(t *testing.T) {
+	var none *BillLimits
+	require.Nil(t, none.adjustment(5))
+
+	limits := &BillLimits{MinimumTotal: 100, MaximumTotal: 500}
+	require.Nil(t, limits.adjustment(100))
+	require.Nil(t, limits.adjustment(500))
+	require.Equal(t, &BillAdjustment{Kind: AdjustmentMinimumCommitment, Limit: 100, TotalBefore: 40.1, Amount: 59.9}, limits.adjustment(40.1))
+	require.Equal(t, &BillAdjustment{Kind: AdjustmentMaximumCap, Limit: 500, TotalBefore: 620, Amount: -120}, limits.adjustment(620))
+
+	limits.OverMaximum = OverMaximumFlag
+	require.Equal(t, &BillAdjustment{Kind: AdjustmentMaximumExceeded, Limit: 500, TotalBefore: 620}, limits.adjustment(620))
+}
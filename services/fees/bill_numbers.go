@@ -0,0 +1,53 @@
+package fees
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// billNumberDigits is how many digits a bill number's sequence is padded to.
+const billNumberDigits = 5
+
+// formatBillNumber formats the seq'th number of a customer's sequence for year, such
+// as CUST123-2024-00042.
+func formatBillNumber(customerID string, year int, seq int64) string {
+	return fmt.Sprintf("%s-%04d-%0*d", customerID, year, billNumberDigits, seq)
+}
+
+// assignBillNumber numbers a closing bill from its customer's sequence for the year
+// it closed, and returns the number. A bill that already has a number keeps it, so a
+// retried close does not use up another; bills without a customer are not numbered.
+//
+// The sequence is a row locked until tx commits, so bills of one customer close one
+// at a time, and a close that rolls back gives its number back. Numbers are never
+// reused, but a customer's sequence has gaps where bills were erased.
+func assignBillNumber(ctx context.Context, tx *tracedTx, billID string, closedAt time.Time) (string, error) {
+	var number, tenantID, customerID string
+	err := tx.QueryRow(ctx, `
+        SELECT COALESCE(number, ''), tenant_id, customer_id FROM bills WHERE id = $1 FOR UPDATE
+    `, billID).Scan(&number, &tenantID, &customerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to load bill %s: %w", billID, err)
+	}
+	if number != "" || customerID == "" {
+		return number, nil
+	}
+
+	year := closedAt.UTC().Year()
+	var seq int64
+	err = tx.QueryRow(ctx, `
+        INSERT INTO bill_number_sequences (tenant_id, customer_id, year, last_value)
+        VALUES ($1, $2, $3, 1)
+        ON CONFLICT (tenant_id, customer_id, year) DO UPDATE SET last_value = bill_number_sequences.last_value + 1
+        RETURNING last_value
+    `, tenantID, customerID, year).Scan(&seq)
+	if err != nil {
+		return "", fmt.Errorf("failed to advance bill number sequence of customer %s: %w", customerID, err)
+	}
+	number = formatBillNumber(customerID, year, seq)
+	if _, err := tx.Exec(ctx, `UPDATE bills SET number = $2 WHERE id = $1`, billID, number); err != nil {
+		return "", fmt.Errorf("failed to number bill %s: %w", billID, err)
+	}
+	return number, nil
+}
@@ -0,0 +1,14 @@
+package fees
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestFormatBillNumber tests that sequences are padded to five digits and grow past them.
+func TestFormatBillNumber(t *testing.T) {
+	require.Equal(t, "CUST123-2024-00042", formatBillNumber("CUST123", 2024, 42))
+	require.Equal(t, "cust-1-2025-00001", formatBillNumber("cust-1", 2025, 1))
+	require.Equal(t, "CUST123-2024-123456", formatBillNumber("CUST123", 2024, 123456))
+}
@@ -0,0 +1,157 @@
+package fees
+
+import (
+	"fmt"
+	"strings"
+
+	"encore.app/locale"
+	"encore.app/pdf"
+)
+
+// Layout of a bill's PDF, in points.
+const (
+	pdfMargin      = 50.0
+	pdfRight       = pdf.PageWidth - pdfMargin
+	pdfLineHeight  = 15.0
+	pdfTextSize    = 9.0
+	pdfTitleSize   = 18.0
+	pdfQuantityX   = 360.0
+	pdfUnitPriceX  = 450.0
+	pdfDescription = pdfQuantityX - pdfMargin - 90
+	// pdfBottom is how low rows go before the table continues on the next page, which
+	// leaves room for pdfFooterLines lines of footer below the table.
+	pdfBottom      = 90.0
+	pdfFooterLines = 3
+)
+
+// pdfDisplay formats bill for l like displayBill, for drawing with the PDF fonts. Bills
+// in right-to-left locales or in locales with characters the fonts lack are formatted
+// for the default locale, and a currency symbol the fonts lack is replaced by the
+// currency's code.
+func pdfDisplay(bill *Bill, l locale.Locale) *BillDisplay {
+	d := displayBill(bill, l)
+	if l.Direction == locale.RightToLeft || !pdf.Encodable(d.Total+d.CreatedAt) {
+		l = locale.Default
+		d = displayBill(bill, l)
+	}
+	symbol := l.Symbol(bill.Currency)
+	if pdf.Encodable(symbol) {
+		return d
+	}
+	r := strings.NewReplacer(symbol, bill.Currency+"\u00a0")
+	for _, s := range []*string{&d.Subtotal, &d.Adjustment, &d.Credit, &d.Total, &d.AmountPaid, &d.BalanceDue} {
+		*s = r.Replace(*s)
+	}
+	for i := range d.LineItems {
+		d.LineItems[i].UnitPrice = r.Replace(d.LineItems[i].UnitPrice)
+		d.LineItems[i].Amount = r.Replace(d.LineItems[i].Amount)
+	}
+	return d
+}
+
+// renderBillPDF draws bill as an A4 PDF, formatted for l as pdfDisplay formats it:
+// its number, dates, line items, totals, and footer, with the line item table
+// continuing over as many pages as it needs.
+func renderBillPDF(bill *Bill, l locale.Locale, footer string) []byte {
+	d := pdfDisplay(bill, l)
+	title := "Bill " + bill.ID
+	if d.Number != "" {
+		title = "Bill " + d.Number
+	}
+	doc := pdf.New(title)
+	var pages []*pdf.Page
+	page := doc.AddPage()
+	pages = append(pages, page)
+
+	y := pdf.PageHeight - pdfMargin - pdfTitleSize
+	page.Text(pdfMargin, y, pdf.Bold, pdfTitleSize, title)
+	page.TextRight(pdfRight, y, pdf.Bold, pdfTextSize+2, string(d.Status))
+	y -= 2 * pdfLineHeight
+	for _, meta := range [][2]string{
+		{"Bill ID", d.BillID}, {"Created", d.CreatedAt}, {"Closed", d.ClosedAt}, {"Due", d.DueDate},
+	} {
+		if meta[1] == "" {
+			continue
+		}
+		page.Text(pdfMargin, y, pdf.Bold, pdfTextSize, meta[0])
+		page.Text(pdfMargin+60, y, pdf.Regular, pdfTextSize, meta[1])
+		y -= pdfLineHeight
+	}
+	y -= pdfLineHeight
+
+	header := func() {
+		page.Text(pdfMargin, y, pdf.Bold, pdfTextSize, "Description")
+		page.TextRight(pdfQuantityX, y, pdf.Bold, pdfTextSize, "Quantity")
+		page.TextRight(pdfUnitPriceX, y, pdf.Bold, pdfTextSize, "Unit price")
+		page.TextRight(pdfRight, y, pdf.Bold, pdfTextSize, "Amount")
+		page.Line(pdfMargin, y-5, pdfRight, y-5, 0.75)
+		y -= pdfLineHeight + 3
+	}
+	header()
+	if len(d.LineItems) == 0 {
+		page.Text(pdfMargin, y, pdf.Regular, pdfTextSize, "No line items.")
+		y -= pdfLineHeight
+	}
+	for _, item := range d.LineItems {
+		if y < pdfBottom {
+			page = doc.AddPage()
+			pages = append(pages, page)
+			y = pdf.PageHeight - pdfMargin - pdfTextSize
+			header()
+		}
+		page.Text(pdfMargin, y, pdf.Regular, pdfTextSize, truncateToWidth(item.Description, pdfDescription))
+		page.TextRight(pdfQuantityX, y, pdf.Regular, pdfTextSize, item.Quantity)
+		page.TextRight(pdfUnitPriceX, y, pdf.Regular, pdfTextSize, item.UnitPrice)
+		page.TextRight(pdfRight, y, pdf.Regular, pdfTextSize, item.Amount)
+		y -= pdfLineHeight
+	}
+
+	totals := [][2]string{{"Subtotal", d.Subtotal}, {"Adjustment", d.Adjustment}, {"Credit applied", d.Credit}, {"Total", d.Total}, {"Paid", d.AmountPaid}, {"Balance due", d.BalanceDue}}
+	if y-float64(len(totals)+1)*pdfLineHeight < pdfBottom {
+		page = doc.AddPage()
+		pages = append(pages, page)
+		y = pdf.PageHeight - pdfMargin - pdfTextSize
+	}
+	page.Line(pdfQuantityX-60, y+pdfLineHeight-5, pdfRight, y+pdfLineHeight-5, 0.75)
+	for _, total := range totals {
+		if total[1] == "" {
+			continue
+		}
+		font := pdf.Regular
+		if total[0] == "Total" {
+			font = pdf.Bold
+		}
+		page.TextRight(pdfUnitPriceX, y, font, pdfTextSize, total[0])
+		page.TextRight(pdfRight, y, font, pdfTextSize, total[1])
+		y -= pdfLineHeight
+	}
+
+	// The footer goes at the bottom of the last page, and page numbers below it.
+	lines := strings.Split(strings.TrimSpace(footer), "\n")
+	lines = lines[:min(len(lines), pdfFooterLines)]
+	for i := len(lines) - 1; i >= 0 && footer != ""; i-- {
+		page.Text(pdfMargin, pdfMargin+float64(len(lines)-1-i)*(pdfTextSize+3), pdf.Regular, pdfTextSize-1, lines[i])
+	}
+	for i, p := range pages {
+		p.TextRight(pdfRight, pdfMargin-20, pdf.Regular, pdfTextSize-1, pageNumber(i+1, len(pages)))
+	}
+	return doc.Bytes()
+}
+
+// pageNumber labels page n of count.
+func pageNumber(n, count int) string {
+	return fmt.Sprintf("Page %d of %d", n, count)
+}
+
+// truncateToWidth shortens s with an ellipsis until it fits width points in the PDF's
+// table text.
+func truncateToWidth(s string, width float64) string {
+	if pdf.Width(pdf.Regular, pdfTextSize, s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && pdf.Width(pdf.Regular, pdfTextSize, string(runes)+"…") > width {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimSpace(string(runes)) + "…"
+}
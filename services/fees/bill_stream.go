@@ -0,0 +1,292 @@
+package fees
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// billStreamBatchSize is how many bills StreamBills reads per query. Each batch
+	// continues after the last bill of the one before, so no transaction or database
+	// cursor is held open while the client reads.
+	billStreamBatchSize = 500
+	// billStreamContentType is the media type of newline-delimited JSON.
+	billStreamContentType = "application/x-ndjson"
+)
+
+// BillStreamRecord is a line of StreamBills' response.
+type BillStreamRecord struct {
+	// Bill is set on every record but the last.
+	Bill *Bill `json:"bill,omitempty"`
+	// Cursor resumes the stream after this record.
+	Cursor string `json:"cursor,omitempty"`
+	// End is set on the last record of a stream that ended normally. More is set on
+	// it when limit ended the stream before its last bill.
+	End  bool `json:"end,omitempty"`
+	More bool `json:"more,omitempty"`
+	// Error is set on the last record of a stream that failed midway. Resume it from
+	// the cursor of the last bill received.
+	Error string `json:"error,omitempty"`
+}
+
+// billStreamCursor is the position after a bill in the stream's order. It is encoded
+// as an opaque token, so the order can change without breaking clients.
+type billStreamCursor struct {
+	CreatedAt time.Time `json:"c"`
+	ID        string    `json:"i"`
+}
+
+func (c billStreamCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeBillStreamCursor(token string) (*billStreamCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var c billStreamCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &c, nil
+}
+
+// billStreamParams are StreamBills' query parameters.
+type billStreamParams struct {
+	Status    BillStatus
+	Currency  string
+	TenantID  string
+	After     *billStreamCursor
+	Limit     int
+	LineItems bool
+	// IncludeDeleted streams deleted bills too, with their deletedAt.
+	IncludeDeleted bool
+	// Modes are the bills streamed: live ones, and test ones if includeTest or for test
+	// mode API keys.
+	Modes billModes
+}
+
+// parseBillStreamParams reads StreamBills' query parameters, limiting tenant-scoped
+// callers to their own tenant.
+func parseBillStreamParams(ctx context.Context, query map[string][]string) (*billStreamParams, int, error) {
+	get := func(name string) string {
+		if v := query[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	params := &billStreamParams{
+		Status:   BillStatus(get("status")),
+		Currency: get("currency"),
+		TenantID: get("tenantId"),
+	}
+	if params.Status != "" && !params.Status.IsValid() {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid status %q", params.Status)
+	}
+	if params.Currency != "" && !validCurrency(params.Currency) {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid currency %q: must be a three-letter ISO 4217 code such as \"USD\"", params.Currency)
+	}
+	if tenant := callerTenant(ctx); tenant != "" {
+		if params.TenantID != "" && params.TenantID != tenant {
+			return nil, http.StatusForbidden, fmt.Errorf("API key may not stream bills of tenant %s", params.TenantID)
+		}
+		params.TenantID = tenant
+	}
+	if v := get("cursor"); v != "" {
+		after, err := decodeBillStreamCursor(v)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		params.After = after
+	}
+	if v := get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid limit %q: must be a non-negative integer", v)
+		}
+		params.Limit = limit
+	}
+	switch v := strings.ToLower(get("lineItems")); v {
+	case "", "false":
+	case "true":
+		params.LineItems = true
+	default:
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid lineItems %q: must be true or false", v)
+	}
+	switch v := strings.ToLower(get("includeDeleted")); v {
+	case "", "false":
+	case "true":
+		if err := checkIncludeDeleted(ctx, true); err != nil {
+			return nil, http.StatusForbidden, err
+		}
+		params.IncludeDeleted = true
+	default:
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid includeDeleted %q: must be true or false", v)
+	}
+	switch v := strings.ToLower(get("includeTest")); v {
+	case "", "false":
+		params.Modes = callerBillModes(ctx, false)
+	case "true":
+		params.Modes = callerBillModes(ctx, true)
+	default:
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid includeTest %q: must be true or false", v)
+	}
+	return params, http.StatusOK, nil
+}
+
+// StreamBills streams bills as newline-delimited JSON, oldest first, for syncing them
+// into a data warehouse. Unlike ListBills, it reads the bills as saved in the
+// database, and pages by cursor rather than offset, so the millionth bill costs no
+// more to reach than the first. Each line is a BillStreamRecord whose cursor resumes
+// the stream after it; a stream that was cut off can be resumed from the last line
+// received. It takes the status, currency, tenantId, includeDeleted and includeTest
+// parameters of ListBills, an optional limit on the bills returned, and lineItems=true
+// to include line items.
+//
+// encore:api auth raw method=GET path=/bills/stream
+func (s *Service) StreamBills(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	// Raw endpoints write their own response, so the scope is checked here.
+	if err := checkScope("StreamBills", caller(ctx)); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	params, status, err := parseBillStreamParams(ctx, req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", billStreamContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	logger := loggerFrom(ctx)
+
+	sent := 0
+	cursor := ""
+	if params.After != nil {
+		cursor = params.After.encode()
+	}
+	for {
+		batch := billStreamBatchSize
+		if params.Limit > 0 {
+			batch = min(batch, params.Limit-sent)
+		}
+		bills, err := s.streamBatch(ctx, params, batch)
+		if err != nil {
+			logger.Error("Failed to stream bills", "sent", sent, "error", err)
+			if sent == 0 {
+				http.Error(w, "failed to read bills", http.StatusInternalServerError)
+				return
+			}
+			_ = enc.Encode(BillStreamRecord{Cursor: cursor, Error: "failed to read bills; resume from the last cursor"})
+			return
+		}
+		for i := range bills {
+			after := billStreamCursor{CreatedAt: *bills[i].CreatedAt, ID: bills[i].ID}
+			params.After, cursor = &after, after.encode()
+			if err := enc.Encode(BillStreamRecord{Bill: &bills[i], Cursor: cursor}); err != nil {
+				// The client went away.
+				return
+			}
+		}
+		sent += len(bills)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		limited := params.Limit > 0 && sent >= params.Limit
+		if len(bills) < batch || limited {
+			more := false
+			if limited {
+				more, err = s.streamHasMore(ctx, params)
+				if err != nil {
+					logger.Error("Failed to check for more bills to stream", "error", err)
+					more = true
+				}
+			}
+			_ = enc.Encode(BillStreamRecord{Cursor: cursor, End: true, More: more})
+			return
+		}
+	}
+}
+
+// streamWhere selects the bills of a stream after its cursor.
+const streamWhere = `($1 = '' OR status = $1) AND ($2 = '' OR currency = $2) AND ($3 = '' OR tenant_id = $3)
+        AND ($4::timestamptz IS NULL OR (created_at, id) > ($4, $5)) AND ($6 OR deleted_at IS NULL)
+        AND (($7 AND NOT test) OR ($8 AND test))`
+
+// streamBatch reads up to limit bills of a stream after its cursor.
+func (s *Service) streamBatch(ctx context.Context, params *billStreamParams, limit int) ([]Bill, error) {
+	var afterAt *time.Time
+	var afterID string
+	if params.After != nil {
+		afterAt, afterID = &params.After.CreatedAt, params.After.ID
+	}
+	rows, err := s.db.Query(ctx, `
+        SELECT `+billColumns+` FROM bills
+        WHERE `+streamWhere+`
+        ORDER BY created_at, id
+        LIMIT $9
+    `, string(params.Status), params.Currency, params.TenantID, afterAt, afterID, params.IncludeDeleted, params.Modes.Live, params.Modes.Test, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bills: %w", err)
+	}
+	defer rows.Close()
+
+	bills := []Bill{}
+	index := map[string]int{}
+	for rows.Next() {
+		bill, err := scanBill(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bills: %w", err)
+		}
+		bill.Stale = false
+		index[bill.ID] = len(bills)
+		bills = append(bills, *bill)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read bills: %w", err)
+	}
+	if !params.LineItems || len(bills) == 0 {
+		return bills, nil
+	}
+
+	billIDs := make([]string, len(bills))
+	for i, b := range bills {
+		billIDs[i] = b.ID
+	}
+	items, err := s.db.Query(ctx, `SELECT `+lineItemColumns+` FROM line_items WHERE bill_id = ANY($1::text[]) ORDER BY created_at, id`, billIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load line items: %w", err)
+	}
+	defer items.Close()
+	for items.Next() {
+		billID, item, err := scanLineItem(items, s.fields)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read line items: %w", err)
+		}
+		bill := &bills[index[billID]]
+		bill.LineItems = append(bill.LineItems, item)
+	}
+	if err := items.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read line items: %w", err)
+	}
+	return bills, nil
+}
+
+// streamHasMore reports whether a stream has bills after its cursor.
+func (s *Service) streamHasMore(ctx context.Context, params *billStreamParams) (bool, error) {
+	var more bool
+	err := s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM bills WHERE `+streamWhere+`)`,
+		string(params.Status), params.Currency, params.TenantID, params.After.CreatedAt, params.After.ID, params.IncludeDeleted, params.Modes.Live, params.Modes.Test).Scan(&more)
+	return more, err
+}
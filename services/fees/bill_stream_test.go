@@ -0,0 +1,65 @@
+package fees
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBillStreamCursor(t *testing.T) {
+	c := billStreamCursor{CreatedAt: time.Date(2026, 3, 2, 9, 0, 0, 123456789, time.UTC), ID: "bill-1"}
+	decoded, err := decodeBillStreamCursor(c.encode())
+	require.NoError(t, err)
+	require.True(t, c.CreatedAt.Equal(decoded.CreatedAt))
+	require.Equal(t, c.ID, decoded.ID)
+
+	for _, token := range []string{"not base64!", "e30", "bm90IGpzb24"} {
+		_, err := decodeBillStreamCursor(token)
+		require.Error(t, err, token)
+	}
+}
+
+// TestParseBillStreamParams tests that stream filters are validated, and that
+// tenant-scoped keys only stream their own tenant's bills.
+func TestParseBillStreamParams(t *testing.T) {
+	cursor := billStreamCursor{CreatedAt: time.Unix(1_700_000_000, 0).UTC(), ID: "bill-1"}.encode()
+	query, _ := url.ParseQuery("status=PAID&currency=EUR&limit=10&lineItems=true&cursor=" + cursor)
+	params, _, err := parseBillStreamParams(context.Background(), query)
+	require.NoError(t, err)
+	require.Equal(t, BillStatusPaid, params.Status)
+	require.Equal(t, "EUR", params.Currency)
+	require.Equal(t, 10, params.Limit)
+	require.True(t, params.LineItems)
+	require.Equal(t, "bill-1", params.After.ID)
+	require.Equal(t, billModes{Live: true}, params.Modes)
+
+	params, _, err = parseBillStreamParams(context.Background(), url.Values{"includeTest": {"true"}})
+	require.NoError(t, err)
+	require.Equal(t, billModes{Live: true, Test: true}, params.Modes)
+	testKey := withCaller(context.Background(), &AuthData{KeyID: "key-2", TenantID: "acme", Test: true})
+	params, _, err = parseBillStreamParams(testKey, url.Values{})
+	require.NoError(t, err)
+	require.Equal(t, billModes{Test: true}, params.Modes)
+
+	scoped := withCaller(context.Background(), &AuthData{KeyID: "key-1", TenantID: "acme"})
+	params, _, err = parseBillStreamParams(scoped, url.Values{})
+	require.NoError(t, err)
+	require.Equal(t, "acme", params.TenantID)
+	_, status, err := parseBillStreamParams(scoped, url.Values{"tenantId": {"globex"}})
+	require.Error(t, err)
+	require.Equal(t, http.StatusForbidden, status)
+	_, status, err = parseBillStreamParams(scoped, url.Values{"includeDeleted": {"true"}})
+	require.Error(t, err)
+	require.Equal(t, http.StatusForbidden, status)
+
+	for _, q := range []string{"status=SETTLED", "currency=dollars", "limit=-1", "limit=x", "lineItems=yes", "includeDeleted=1", "includeTest=yes", "cursor=e30"} {
+		query, _ := url.ParseQuery(q)
+		_, status, err := parseBillStreamParams(context.Background(), query)
+		require.Error(t, err, q)
+		require.Equal(t, http.StatusBadRequest, status, q)
+	}
+}
@@ -0,0 +1,258 @@
+package fees
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"encore.app/apierr"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// RebuildBillSummariesActivityName rebuilds a page of the bill_summaries projection.
+	RebuildBillSummariesActivityName = "RebuildBillSummariesActivity"
+
+	// rebuildBillSummariesPageSize is how many bills RebuildBillSummariesActivity
+	// rebuilds at once.
+	rebuildBillSummariesPageSize = 500
+	// rebuildBillSummariesPagesPerRun is how many pages a rebuild_bill_summaries job
+	// run handles before it continues as new.
+	rebuildBillSummariesPagesPerRun = 100
+
+	defaultBillSummariesLimit = 50
+	maxBillSummariesLimit     = 500
+)
+
+// BillSummary is what lists and dashboards show of a bill, read from the
+// bill_summaries projection rather than the bill's workflow.
+type BillSummary struct {
+	BillID string `json:"billId"`
+	// Number is the bill's number, set once it closed.
+	Number     string     `json:"number,omitempty"`
+	TenantID   string     `json:"tenantId"`
+	CustomerID string     `json:"customerId,omitempty"`
+	Currency   string     `json:"currency"`
+	Status     BillStatus `json:"status"`
+	// TotalAmount is the sum of the bill's line items while it is open, and its total
+	// once it closed.
+	TotalAmount float64    `json:"totalAmount"`
+	ItemCount   int        `json:"itemCount"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ClosedAt    *time.Time `json:"closedAt,omitempty"`
+	DueDate     *time.Time `json:"dueDate,omitempty"`
+	Version     int64      `json:"version"`
+	// LastActivityAt is when the bill last changed.
+	LastActivityAt time.Time `json:"lastActivityAt"`
+}
+
+// billSummarySelect computes bill_summaries rows from the bills matching a condition.
+// The last activity is the bill's latest audit log entry, so a rebuild derives the
+// same time the activity that made the change recorded.
+const billSummarySelect = `
+    INSERT INTO bill_summaries (bill_id, tenant_id, customer_id, currency, status, total_amount, item_count, created_at, closed_at, due_date, version, last_activity_at, deleted_at, number, amount_paid)
+    SELECT b.id, b.tenant_id, b.customer_id, b.currency, b.status,
+           CASE WHEN b.closed_at IS NULL THEN COALESCE(li.total, 0) ELSE b.total_amount END,
+           COALESCE(li.count, 0), b.created_at, b.closed_at, b.due_date, b.version,
+           COALESCE((SELECT a.occurred_at FROM bill_audit_log a WHERE a.bill_id = b.id ORDER BY a.id DESC LIMIT 1), b.created_at),
+           b.deleted_at, b.number,
+           COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.bill_id = b.id AND p.status = 'SUCCEEDED'), 0)
+    FROM bills b
+    LEFT JOIN LATERAL (
+        SELECT SUM(amount) AS total, COUNT(*) AS count FROM line_items WHERE bill_id = b.id AND credit_note_id IS NULL
+    ) li ON true
+`
+
+const billSummaryUpsert = `
+    ON CONFLICT (bill_id) DO UPDATE SET
+        tenant_id = EXCLUDED.tenant_id,
+        customer_id = EXCLUDED.customer_id,
+        currency = EXCLUDED.currency,
+        status = EXCLUDED.status,
+        total_amount = EXCLUDED.total_amount,
+        item_count = EXCLUDED.item_count,
+        closed_at = EXCLUDED.closed_at,
+        due_date = EXCLUDED.due_date,
+        version = EXCLUDED.version,
+        last_activity_at = EXCLUDED.last_activity_at,
+        deleted_at = EXCLUDED.deleted_at,
+        number = EXCLUDED.number,
+        amount_paid = EXCLUDED.amount_paid
+`
+
+// refreshBillSummary brings the bill's summary up to date in tx, the transaction that
+// changed the bill, so the summary commits or rolls back with the change.
+func refreshBillSummary(ctx context.Context, tx *tracedTx, billID string) error {
+	if _, err := tx.Exec(ctx, billSummarySelect+`WHERE b.id = $1`+billSummaryUpsert, billID); err != nil {
+		return fmt.Errorf("failed to refresh summary of bill %s: %w", billID, err)
+	}
+	return nil
+}
+
+// ------ Rebuild ------
+
+// RebuildBillSummariesActivityParams defines parameters for RebuildBillSummariesActivity.
+type RebuildBillSummariesActivityParams struct {
+	AfterID string
+	Limit   int
+}
+
+// RebuildBillSummariesResult is the outcome of one RebuildBillSummariesActivity page.
+type RebuildBillSummariesResult struct {
+	// LastID is the ID of the last bill rebuilt, where the next page starts.
+	LastID string
+	Read   int
+}
+
+// runRebuildBillSummaries runs a JobKindRebuildBillSummaries job: it recomputes the
+// summary of every bill, a page at a time in bill ID order.
+func runRebuildBillSummaries(run *jobRun) error {
+	ctx, logger := run.ctx, workflow.GetLogger(run.ctx)
+	progress := &run.params.Progress
+
+	for page := 0; ; page++ {
+		if page == rebuildBillSummariesPagesPerRun {
+			return errJobContinue
+		}
+		var result RebuildBillSummariesResult
+		err := workflow.ExecuteActivity(ctx, RebuildBillSummariesActivityName, RebuildBillSummariesActivityParams{
+			AfterID: run.params.Cursor,
+			Limit:   rebuildBillSummariesPageSize,
+		}).Get(ctx, &result)
+		if err != nil {
+			logger.Error("Failed to execute RebuildBillSummariesActivity", "job_id", run.params.JobID, "after_id", run.params.Cursor, "error", err)
+			return err
+		}
+
+		progress.Processed += result.Read
+		progress.Succeeded += result.Read
+		cursor := run.params.Cursor
+		if result.Read > 0 {
+			cursor = result.LastID
+		}
+		if err := run.checkpoint(cursor); err != nil {
+			return err
+		}
+		if result.Read < rebuildBillSummariesPageSize {
+			return nil
+		}
+	}
+}
+
+// RebuildBillSummariesActivity recomputes the summaries of up to params.Limit bills
+// after params.AfterID.
+func (a *Activities) RebuildBillSummariesActivity(ctx context.Context, params RebuildBillSummariesActivityParams) (*RebuildBillSummariesResult, error) {
+	defer keepAlive(ctx)()
+	rows, err := a.DB.Query(ctx, `
+        WITH page AS (SELECT id FROM bills WHERE id > $1 ORDER BY id LIMIT $2),
+        rebuilt AS (`+billSummarySelect+`WHERE b.id IN (SELECT id FROM page)`+billSummaryUpsert+` RETURNING bill_id)
+        SELECT bill_id FROM rebuilt ORDER BY bill_id
+    `, params.AfterID, params.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("RebuildBillSummariesActivity: failed to rebuild summaries after %q: %w", params.AfterID, err)
+	}
+	defer rows.Close()
+
+	result := &RebuildBillSummariesResult{}
+	for rows.Next() {
+		if err := rows.Scan(&result.LastID); err != nil {
+			return nil, fmt.Errorf("RebuildBillSummariesActivity: failed to read rebuilt bill: %w", err)
+		}
+		result.Read++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("RebuildBillSummariesActivity: failed to rebuild summaries after %q: %w", params.AfterID, err)
+	}
+	return result, nil
+}
+
+// ------ API ------
+
+// ListBillSummariesParams filters the bill summaries listed.
+type ListBillSummariesParams struct {
+	Status     string `query:"status"`
+	Currency   string `query:"currency"`
+	CustomerID string `query:"customerId"`
+	// TenantID filters internal callers' listings to one tenant. Tenant-scoped API keys
+	// only ever list their own tenant's bills.
+	TenantID string `query:"tenantId"`
+	Limit    int    `query:"limit"`
+	Offset   int    `query:"offset"`
+	// IncludeTest lists test bills too. Test mode API keys only ever list test bills.
+	IncludeTest bool `query:"includeTest"`
+}
+
+// ListBillSummariesResponse is the response payload for listing bill summaries.
+type ListBillSummariesResponse struct {
+	Summaries []BillSummary `json:"summaries"`
+	Limit     int           `json:"limit"`
+	Offset    int           `json:"offset"`
+}
+
+// ListBillSummaries lists the summaries of bills that are not deleted, most recently
+// active first, leaving out test bills unless params.IncludeTest. It reads the bill_summaries projection only, so it answers without
+// querying workflows, and while Temporal is unavailable.
+//
+// encore:api auth method=GET path=/bills/summaries
+func (s *Service) ListBillSummaries(ctx context.Context, params *ListBillSummariesParams) (*ListBillSummariesResponse, error) {
+	if tenant := callerTenant(ctx); tenant != "" {
+		if params.TenantID != "" && params.TenantID != tenant {
+			return nil, apierr.PermissionDenied(apierr.InsufficientScope, "API key may not list bills of tenant %s", params.TenantID)
+		}
+		params.TenantID = tenant
+	}
+	if params.Status != "" && !BillStatus(params.Status).IsValid() {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "invalid status %q", params.Status)
+	}
+	if params.Currency != "" && !validCurrency(params.Currency) {
+		return nil, apierr.InvalidArgument(apierr.InvalidCurrency, "invalid currency %q: must be a three-letter ISO 4217 code such as \"USD\"", params.Currency)
+	}
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultBillSummariesLimit
+	}
+	limit = min(limit, maxBillSummariesLimit)
+	offset := max(params.Offset, 0)
+	modes := callerBillModes(ctx, params.IncludeTest)
+
+	rows, err := s.db.Query(ctx, `
+        SELECT bill_id, tenant_id, COALESCE(customer_id, ''), currency, status, total_amount::float8, item_count,
+               created_at, closed_at, due_date, version, last_activity_at, COALESCE(number, '')
+        FROM bill_summaries
+        WHERE ($1 = '' OR status = $1) AND ($2 = '' OR currency = $2) AND ($3 = '' OR customer_id = $3) AND ($4 = '' OR tenant_id = $4)
+          AND deleted_at IS NULL AND (($7 AND NOT test) OR ($8 AND test))
+        ORDER BY last_activity_at DESC, bill_id
+        LIMIT $5 OFFSET $6
+    `, params.Status, params.Currency, params.CustomerID, params.TenantID, limit, offset, modes.Live, modes.Test)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to list bill summaries")
+	}
+	defer rows.Close()
+
+	resp := &ListBillSummariesResponse{Summaries: []BillSummary{}, Limit: limit, Offset: offset}
+	for rows.Next() {
+		var b BillSummary
+		if err := rows.Scan(&b.BillID, &b.TenantID, &b.CustomerID, &b.Currency, &b.Status, &b.TotalAmount, &b.ItemCount,
+			&b.CreatedAt, &b.ClosedAt, &b.DueDate, &b.Version, &b.LastActivityAt, &b.Number); err != nil {
+			return nil, apierr.Wrap(err, "failed to read bill summaries")
+		}
+		resp.Summaries = append(resp.Summaries, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read bill summaries")
+	}
+	return resp, nil
+}
+
+// RebuildBillSummaries starts recomputing the summary of every bill, such as after
+// the projection was restored from a backup older than the bills. It returns at once
+// with the rebuild_bill_summaries job, whose progress GetJob reports.
+//
+// encore:api private method=POST path=/admin/bill-summaries/rebuild
+func (s *Service) RebuildBillSummaries(ctx context.Context) (*JobResponse, error) {
+	job, err := s.startJob(ctx, JobKindRebuildBillSummaries, "", struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	return &JobResponse{Job: *job}, nil
+}
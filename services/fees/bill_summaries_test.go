@@ -0,0 +1,29 @@
+package fees
+
+import (
+	"context"
+	"testing"
+
+	"encore.app/apierr"
+	"github.com/stretchr/testify/require"
+)
+
+// TestListBillSummaries_InvalidParams tests that filters are checked before the
+// projection is read.
+func TestListBillSummaries_InvalidParams(t *testing.T) {
+	s := &Service{}
+	_, err := s.ListBillSummaries(context.Background(), &ListBillSummariesParams{Status: "NOPE"})
+	require.Equal(t, apierr.InvalidParameter, apierr.ReasonOf(err))
+
+	_, err = s.ListBillSummaries(context.Background(), &ListBillSummariesParams{Currency: "dollars"})
+	require.Equal(t, apierr.InvalidCurrency, apierr.ReasonOf(err))
+}
+
+// TestListBillSummaries_OtherTenant tests that a tenant-scoped key cannot list another
+// tenant's summaries.
+func TestListBillSummaries_OtherTenant(t *testing.T) {
+	s := &Service{}
+	ctx := withCaller(context.Background(), &AuthData{KeyID: "key-1", TenantID: "acme"})
+	_, err := s.ListBillSummaries(ctx, &ListBillSummariesParams{TenantID: "globex"})
+	require.Equal(t, apierr.InsufficientScope, apierr.ReasonOf(err))
+}
@@ -0,0 +1,230 @@
+package fees
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/storage/sqldb"
+	"github.com/google/uuid"
+)
+
+// maxTemplateLineItems caps how many line items a bill template seeds.
+const maxTemplateLineItems = 100
+
+// BillTemplate is a predefined fee structure, such as a standard card processing plan,
+// that new bills can be created from.
+type BillTemplate struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Currency restricts the template to bills in one currency; empty allows any.
+	Currency string `json:"currency,omitempty"`
+	// LineItems are added to every bill created from the template.
+	LineItems []TemplateLineItem `json:"lineItems"`
+	// AutoCollect and CloseGracePeriod are the bill settings the template applies
+	// unless the CreateBill request sets its own.
+	AutoCollect      bool      `json:"autoCollect,omitempty"`
+	CloseGracePeriod string    `json:"closeGracePeriod,omitempty"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+// TemplateLineItem is a recurring charge seeded onto bills created from a template.
+type TemplateLineItem struct {
+	Description string  `json:"description"`
+	Amount      float64 `json:"amount"`
+}
+
+// BillTemplateRequest is the request payload for creating or replacing a bill template.
+type BillTemplateRequest struct {
+	Name             string             `json:"name"`
+	Description      string             `json:"description,omitempty"`
+	Currency         string             `json:"currency,omitempty"`
+	LineItems        []TemplateLineItem `json:"lineItems"`
+	AutoCollect      bool               `json:"autoCollect,omitempty"`
+	CloseGracePeriod string             `json:"closeGracePeriod,omitempty"`
+}
+
+// validate rejects templates that could not seed a valid bill.
+func (r *BillTemplateRequest) validate() error {
+	if r.Name == "" {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "name is required")
+	}
+	if r.Currency != "" && !validCurrency(r.Currency) {
+		return apierr.InvalidArgument(apierr.InvalidCurrency, "invalid currency %q: must be a three-letter ISO 4217 code such as \"USD\"", r.Currency)
+	}
+	if len(r.LineItems) > maxTemplateLineItems {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "a template holds at most %d line items, got %d", maxTemplateLineItems, len(r.LineItems))
+	}
+	for i, item := range r.LineItems {
+		if item.Description == "" {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "lineItems[%d].description is required", i)
+		}
+		if math.IsNaN(item.Amount) || math.IsInf(item.Amount, 0) || item.Amount <= 0 {
+			return apierr.InvalidArgument(apierr.InvalidAmount, "lineItems[%d].amount must be a positive number, got %v", i, item.Amount)
+		}
+	}
+	if r.CloseGracePeriod != "" {
+		if _, err := parseGracePeriod("closeGracePeriod", r.CloseGracePeriod); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BillTemplateResponse returns one bill template.
+type BillTemplateResponse struct {
+	RetryMetadata
+	Template BillTemplate `json:"template"`
+}
+
+// ListBillTemplatesResponse lists bill templates by name.
+type ListBillTemplatesResponse struct {
+	Templates []BillTemplate `json:"templates"`
+}
+
+// DeleteBillTemplateResponse confirms a template was deleted.
+type DeleteBillTemplateResponse struct {
+	RetryMetadata
+	TemplateID string `json:"templateId"`
+}
+
+// templateItemID names the line item a template seeds at index i onto a bill, so a
+// replayed or retried seed adds each item once.
+func templateItemID(billID, templateID string, i int) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(fmt.Sprintf("feems/template-item/%s/%s/%d", billID, templateID, i))).String()
+}
+
+const templateColumns = `id, name, description, currency, line_items, auto_collect, close_grace_period, created_at, updated_at`
+
+// scanTemplate reads a row of templateColumns.
+func scanTemplate(row interface{ Scan(...any) error }) (*BillTemplate, error) {
+	var t BillTemplate
+	var lineItems []byte
+	if err := row.Scan(&t.ID, &t.Name, &t.Description, &t.Currency, &lineItems, &t.AutoCollect, &t.CloseGracePeriod, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(lineItems, &t.LineItems); err != nil {
+		return nil, fmt.Errorf("failed to decode line items of template %s: %w", t.ID, err)
+	}
+	return &t, nil
+}
+
+// loadBillTemplate returns a bill template, or nil if it does not exist.
+func loadBillTemplate(ctx context.Context, db *tracedDB, templateID string) (*BillTemplate, error) {
+	t, err := scanTemplate(db.QueryRow(ctx, `SELECT `+templateColumns+` FROM bill_templates WHERE id = $1`, templateID))
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bill template %s: %w", templateID, err)
+	}
+	return t, nil
+}
+
+// CreateBillTemplate saves a new bill template. It is private so it can only be called
+// by internal admin tooling.
+//
+// encore:api private method=POST path=/admin/bill-templates
+func (s *Service) CreateBillTemplate(ctx context.Context, params *BillTemplateRequest) (*BillTemplateResponse, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	lineItems, err := json.Marshal(params.LineItems)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to encode template line items")
+	}
+	templateID := keyedID(ctx, "bill-template")
+	now := s.clock.Now().UTC()
+	_, err = s.db.Exec(ctx, `
+        INSERT INTO bill_templates (id, name, description, currency, line_items, auto_collect, close_grace_period, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+        ON CONFLICT (id) DO NOTHING
+    `, templateID, params.Name, params.Description, params.Currency, lineItems, params.AutoCollect, params.CloseGracePeriod, now)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to save bill template")
+	}
+	// A retry of a keyed request returns the template created the first time.
+	return s.GetBillTemplate(ctx, templateID)
+}
+
+// GetBillTemplate returns a bill template.
+//
+// encore:api private method=GET path=/admin/bill-templates/:templateID
+func (s *Service) GetBillTemplate(ctx context.Context, templateID string) (*BillTemplateResponse, error) {
+	t, err := loadBillTemplate(ctx, s.db, templateID)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load bill template %s", templateID)
+	}
+	if t == nil {
+		return nil, apierr.NotFound(apierr.TemplateNotFound, "bill template %s not found", templateID)
+	}
+	return &BillTemplateResponse{Template: *t}, nil
+}
+
+// ListBillTemplates lists bill templates by name.
+//
+// encore:api private method=GET path=/admin/bill-templates
+func (s *Service) ListBillTemplates(ctx context.Context) (*ListBillTemplatesResponse, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+templateColumns+` FROM bill_templates ORDER BY name, id`)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to list bill templates")
+	}
+	defer rows.Close()
+
+	resp := &ListBillTemplatesResponse{Templates: []BillTemplate{}}
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to read bill templates")
+		}
+		resp.Templates = append(resp.Templates, *t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read bill templates")
+	}
+	return resp, nil
+}
+
+// UpdateBillTemplate replaces a bill template. Bills already created from it keep the
+// items and settings they were created with.
+//
+// encore:api private method=PUT path=/admin/bill-templates/:templateID
+func (s *Service) UpdateBillTemplate(ctx context.Context, templateID string, params *BillTemplateRequest) (*BillTemplateResponse, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	lineItems, err := json.Marshal(params.LineItems)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to encode template line items")
+	}
+	res, err := s.db.Exec(ctx, `
+        UPDATE bill_templates
+        SET name = $2, description = $3, currency = $4, line_items = $5, auto_collect = $6,
+            close_grace_period = $7, updated_at = $8
+        WHERE id = $1
+    `, templateID, params.Name, params.Description, params.Currency, lineItems, params.AutoCollect, params.CloseGracePeriod, s.clock.Now().UTC())
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to update bill template %s", templateID)
+	}
+	if res.RowsAffected() == 0 {
+		return nil, apierr.NotFound(apierr.TemplateNotFound, "bill template %s not found", templateID)
+	}
+	return s.GetBillTemplate(ctx, templateID)
+}
+
+// DeleteBillTemplate deletes a bill template. Bills already created from it are unaffected.
+//
+// encore:api private method=DELETE path=/admin/bill-templates/:templateID
+func (s *Service) DeleteBillTemplate(ctx context.Context, templateID string) (*DeleteBillTemplateResponse, error) {
+	_, err := s.db.Exec(ctx, `DELETE FROM bill_templates WHERE id = $1`, templateID)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to delete bill template %s", templateID)
+	}
+	return &DeleteBillTemplateResponse{TemplateID: templateID}, nil
+}
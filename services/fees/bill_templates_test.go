@@ -0,0 +1,39 @@
+package fees
+
+import (
+	"testing"
+
+	"encore.app/apierr"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBillTemplateRequest_Validate tests that templates which could not seed a valid bill are rejected.
+func TestBillTemplateRequest_Validate(t *testing.T) {
+	valid := BillTemplateRequest{
+		Name:             "Standard card processing",
+		Currency:         "USD",
+		LineItems:        []TemplateLineItem{{Description: "Platform fee", Amount: 25}},
+		CloseGracePeriod: "5m",
+	}
+	require.NoError(t, valid.validate())
+
+	tests := []struct {
+		name   string
+		mutate func(r *BillTemplateRequest)
+		reason apierr.Reason
+	}{
+		{"missing name", func(r *BillTemplateRequest) { r.Name = "" }, apierr.InvalidParameter},
+		{"bad currency", func(r *BillTemplateRequest) { r.Currency = "dollars" }, apierr.InvalidCurrency},
+		{"item without description", func(r *BillTemplateRequest) { r.LineItems[0].Description = "" }, apierr.InvalidParameter},
+		{"non-positive amount", func(r *BillTemplateRequest) { r.LineItems[0].Amount = 0 }, apierr.InvalidAmount},
+		{"bad grace period", func(r *BillTemplateRequest) { r.CloseGracePeriod = "soon" }, apierr.InvalidParameter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid
+			r.LineItems = append([]TemplateLineItem(nil), valid.LineItems...)
+			tt.mutate(&r)
+			require.Equal(t, tt.reason, apierr.ReasonOf(r.validate()))
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"encore.app/apierr"
+)
+
+const (
+	// defaultBillWaitTimeout is how long WaitForBill waits unless told otherwise.
+	defaultBillWaitTimeout = 30 * time.Second
+	// maxBillWaitTimeout caps WaitForBill's timeout, so a long poll does not outlive the
+	// proxies in front of the service.
+	maxBillWaitTimeout = 60 * time.Second
+	// billWaitPollInterval is how often WaitForBill re-reads the bill.
+	billWaitPollInterval = 250 * time.Millisecond
+	// billQueryTimeout bounds each query of a bill's workflow while polling it, so one
+	// slow query does not use up the whole wait.
+	billQueryTimeout = 2 * time.Second
+)
+
+// errPollTimeout is returned by poll when its timeout passes first.
+var errPollTimeout = errors.New("timed out polling bill")
+
+// WaitForBillParams defines the query parameters for waiting on a bill's status.
+type WaitForBillParams struct {
+	// Status is the status to wait for. Defaults to CLOSED, which the statuses a bill
+	// moves on to once closed, such as PAID, satisfy too.
+	Status string `query:"status"`
+	// Timeout is how long to wait, such as "30s". Defaults to 30 seconds; at most 60.
+	Timeout string `query:"timeout"`
+}
+
+// WaitForBillResponse is the bill once it reached the status waited for, or as it was
+// when the wait timed out.
+type WaitForBillResponse struct {
+	RetrievedBill Bill `json:"bill"`
+	// Reached reports whether the bill reached the status. When false, the wait timed
+	// out and the request can be repeated.
+	Reached bool `json:"reached"`
+	// ETag is the bill's version, for use in If-Match.
+	ETag string `header:"ETag"`
+}
+
+// reachedStatus reports whether the bill is at status, or past it in the case of
+// CLOSED.
+func (b *Bill) reachedStatus(status BillStatus) bool {
+	if status == BillStatusClosed {
+		return !b.isFinalizing()
+	}
+	return b.Status == status
+}
+
+// parseBillWait parses WaitForBill's parameters into the status to wait for and how
+// long to wait.
+func parseBillWait(params *WaitForBillParams) (BillStatus, time.Duration, error) {
+	status, timeout := BillStatusClosed, defaultBillWaitTimeout
+	if params == nil {
+		return status, timeout, nil
+	}
+	if params.Status != "" {
+		status = BillStatus(params.Status)
+		if !status.IsValid() {
+			return "", 0, apierr.InvalidArgument(apierr.InvalidParameter, "invalid status %q: must be a bill status such as CLOSED or PAID", params.Status)
+		}
+	}
+	if params.Timeout != "" {
+		d, err := time.ParseDuration(params.Timeout)
+		if err != nil || d <= 0 || d > maxBillWaitTimeout {
+			return "", 0, apierr.InvalidArgument(apierr.InvalidParameter, "invalid timeout %q: must be a positive duration of at most %s, such as \"30s\"", params.Timeout, maxBillWaitTimeout)
+		}
+		timeout = d
+	}
+	return status, timeout, nil
+}
+
+// WaitForBill long-polls a bill until it reaches a status, by default CLOSED, so
+// scripts need not write their own polling loops against GetBill. It returns the bill
+// as soon as it gets there. After the timeout it returns the bill as it is, with
+// reached false. It stops early if the request is canceled.
+//
+// encore:api auth method=GET path=/bills/:billID/wait
+func (s *Service) WaitForBill(ctx context.Context, billID string, params *WaitForBillParams) (*WaitForBillResponse, error) {
+	status, timeout, err := parseBillWait(params)
+	if err != nil {
+		return nil, err
+	}
+	var resp *GetBillResponse
+	err = s.poll(ctx, timeout, billWaitPollInterval, func() (bool, error) {
+		var err error
+		resp, err = s.getBill(ctx, billID)
+		return err == nil && resp.RetrievedBill.reachedStatus(status), err
+	})
+	switch {
+	case errors.Is(err, errPollTimeout):
+		loggerFrom(ctx).Debug("Bill did not reach status before timeout", "bill_id", billID, "status", resp.RetrievedBill.Status, "awaited_status", status, "timeout", timeout)
+		return &WaitForBillResponse{RetrievedBill: resp.RetrievedBill, ETag: resp.ETag}, nil
+	case err != nil && ctx.Err() != nil:
+		return nil, apierr.FromContext(ctx, "bill %s did not reach %s", billID, status)
+	case err != nil:
+		return nil, err
+	}
+	return &WaitForBillResponse{RetrievedBill: resp.RetrievedBill, Reached: true, ETag: resp.ETag}, nil
+}
+
+// poll calls attempt, and again every interval, until it reports done or fails, and
+// returns its error. It returns errPollTimeout once timeout passes, and the context's
+// error if ctx ends first, leaving callers to say what may still happen to what they
+// waited for.
+func (s *Service) poll(ctx context.Context, timeout, interval time.Duration, attempt func() (done bool, err error)) error {
+	deadline := s.clock.After(timeout)
+	for {
+		if done, err := attempt(); done || err != nil {
+			return err
+		}
+		select {
+		case <-s.clock.After(interval):
+		case <-deadline:
+			return errPollTimeout
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// pollBillWorkflow queries the bill's workflow, as poll does, until check accepts the
+// bill or fails, and returns the bill. A failed query is logged and retried, since the
+// workflow may be busy applying the change waited for.
+func (s *Service) pollBillWorkflow(ctx context.Context, billID string, timeout, interval time.Duration, check func(*Bill) (bool, error)) (*Bill, error) {
+	wfID := billWorkflowID(billID)
+	var bill Bill
+	err := s.poll(ctx, timeout, interval, func() (bool, error) {
+		queryCtx, cancelQueryCtx := context.WithTimeout(ctx, billQueryTimeout)
+		defer cancelQueryCtx()
+		resp, err := s.temporalClient.QueryWorkflow(queryCtx, wfID, "", GetBillDetailsQueryName)
+		if err == nil {
+			bill = Bill{}
+			err = resp.Get(&bill)
+		}
+		if err != nil {
+			loggerFrom(ctx).Warn("Query while polling bill failed", "workflow_id", wfID, "error", err)
+			return false, nil
+		}
+		return check(&bill)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &bill, nil
+}
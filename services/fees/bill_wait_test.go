@@ -0,0 +1,96 @@
+package fees
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"encore.app/apierr"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseBillWait tests WaitForBill's defaults and the statuses and timeouts it accepts.
+func TestParseBillWait(t *testing.T) {
+	status, timeout, err := parseBillWait(&WaitForBillParams{})
+	require.NoError(t, err)
+	require.Equal(t, BillStatusClosed, status)
+	require.Equal(t, defaultBillWaitTimeout, timeout)
+
+	status, timeout, err = parseBillWait(&WaitForBillParams{Status: "PAID", Timeout: "5s"})
+	require.NoError(t, err)
+	require.Equal(t, BillStatusPaid, status)
+	require.Equal(t, 5*time.Second, timeout)
+
+	for _, params := range []WaitForBillParams{
+		{Status: "closed"},
+		{Timeout: "soon"},
+		{Timeout: "0s"},
+		{Timeout: "2m"},
+	} {
+		_, _, err := parseBillWait(&params)
+		require.Equal(t, apierr.InvalidParameter, apierr.ReasonOf(err), "%+v", params)
+	}
+}
+
+// TestBillReachedStatus tests that CLOSED is reached by the statuses that follow it too.
+func TestBillReachedStatus(t *testing.T) {
+	require.True(t, (&Bill{Status: BillStatusPaid}).reachedStatus(BillStatusClosed))
+	require.True(t, (&Bill{Status: BillStatusOverdue}).reachedStatus(BillStatusClosed))
+	require.False(t, (&Bill{Status: BillStatusPendingApproval}).reachedStatus(BillStatusClosed))
+	require.False(t, (&Bill{Status: BillStatusClosed}).reachedStatus(BillStatusPaid))
+	require.True(t, (&Bill{Status: BillStatusClosing}).reachedStatus(BillStatusClosing))
+}
+
+// TestWaitForBill tests that WaitForBill re-reads the bill until it closes, and returns
+// it unreached once the timeout passes.
+func TestWaitForBill(t *testing.T) {
+	type result struct {
+		resp *WaitForBillResponse
+		err  error
+	}
+
+	t.Run("reached", func(t *testing.T) {
+		svc, tc, clock := newClockedService(t)
+		tc.On("QueryWorkflow", mock.Anything, "bill-b1", "", GetBillDetailsQueryName).
+			Return(encodedBill{Bill{ID: "b1", Status: BillStatusClosing, Version: 4}}, nil).Once()
+		tc.On("QueryWorkflow", mock.Anything, "bill-b1", "", GetBillDetailsQueryName).
+			Return(encodedBill{Bill{ID: "b1", Status: BillStatusClosed, Version: 5}}, nil).Once()
+
+		done := make(chan result)
+		go func() {
+			resp, err := svc.WaitForBill(context.Background(), "b1", &WaitForBillParams{})
+			done <- result{resp, err}
+		}()
+
+		// The timeout and the first poll interval are pending.
+		clock.BlockUntil(2)
+		clock.Advance(billWaitPollInterval)
+
+		res := <-done
+		require.NoError(t, res.err)
+		require.True(t, res.resp.Reached)
+		require.Equal(t, BillStatusClosed, res.resp.RetrievedBill.Status)
+		require.Equal(t, billETag(5), res.resp.ETag)
+	})
+
+	t.Run("timed out", func(t *testing.T) {
+		svc, tc, clock := newClockedService(t)
+		tc.On("QueryWorkflow", mock.Anything, "bill-b1", "", GetBillDetailsQueryName).
+			Return(encodedBill{Bill{ID: "b1", Status: BillStatusOpen}}, nil)
+
+		done := make(chan result)
+		go func() {
+			resp, err := svc.WaitForBill(context.Background(), "b1", &WaitForBillParams{Timeout: "1s"})
+			done <- result{resp, err}
+		}()
+
+		clock.BlockUntil(2)
+		clock.Advance(time.Second)
+
+		res := <-done
+		require.NoError(t, res.err)
+		require.False(t, res.resp.Reached)
+		require.Equal(t, BillStatusOpen, res.resp.RetrievedBill.Status)
+	})
+}
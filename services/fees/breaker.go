@@ -0,0 +1,137 @@
+package fees
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"encore.app/apierr"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+)
+
+// errBreakerOpen is returned instead of calling Temporal while the breaker is open.
+// It is a serviceerror.Unavailable so callers treat it like an unreachable server.
+var errBreakerOpen = serviceerror.NewUnavailable("temporal circuit breaker is open")
+
+// circuitBreaker stops calls to Temporal after consecutive unavailability errors, so
+// requests fail fast instead of each waiting out a timeout. After cooldown one probe
+// call is let through; its success closes the breaker and its failure reopens it.
+// It is safe for concurrent use.
+type circuitBreaker struct {
+	clock     Clock
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// newCircuitBreaker returns a breaker that opens after threshold consecutive failures.
+// A threshold of zero never opens.
+func newCircuitBreaker(clock Clock, threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{clock: clock, threshold: threshold, cooldown: cooldown}
+}
+
+// allow returns errBreakerOpen if a call should not be attempted.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.isOpen() {
+		return nil
+	}
+	if b.probing || b.clock.Now().Before(b.openedAt.Add(b.cooldown)) {
+		return errBreakerOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// record updates the breaker with the outcome of a call it allowed, made for ctx. Only
+// errors that mean Temporal is unavailable count as failures. A call cut short because
+// its request was canceled or passed its deadline says nothing about Temporal, so it
+// neither counts nor resets the count.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	if err == nil || !apierr.IsTemporalUnavailable(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.isOpen() {
+		b.openedAt = b.clock.Now()
+	}
+}
+
+// Open reports whether calls are currently being refused.
+func (b *circuitBreaker) Open() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.isOpen()
+}
+
+func (b *circuitBreaker) isOpen() bool {
+	return b.threshold > 0 && b.failures >= b.threshold
+}
+
+// do runs call, made for ctx, unless the breaker is open, and records its outcome. A
+// nil breaker always runs call.
+func (b *circuitBreaker) do(ctx context.Context, call func() error) error {
+	if b == nil {
+		return call()
+	}
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := call()
+	b.record(ctx, err)
+	return err
+}
+
+// breakerClient is a Temporal client whose workflow calls go through a circuitBreaker.
+// The worker keeps the unwrapped client, since it needs the SDK's own implementation.
+type breakerClient struct {
+	client.Client
+	breaker *circuitBreaker
+}
+
+func (c *breakerClient) ExecuteWorkflow(ctx context.Context, options client.StartWorkflowOptions, workflow interface{}, args ...interface{}) (run client.WorkflowRun, err error) {
+	err = c.breaker.do(ctx, func() error {
+		run, err = c.Client.ExecuteWorkflow(ctx, options, workflow, args...)
+		return err
+	})
+	return run, err
+}
+
+func (c *breakerClient) SignalWorkflow(ctx context.Context, workflowID string, runID string, signalName string, arg interface{}) error {
+	return c.breaker.do(ctx, func() error {
+		return c.Client.SignalWorkflow(ctx, workflowID, runID, signalName, arg)
+	})
+}
+
+func (c *breakerClient) SignalWithStartWorkflow(ctx context.Context, workflowID string, signalName string, signalArg interface{}, options client.StartWorkflowOptions, workflow interface{}, workflowArgs ...interface{}) (run client.WorkflowRun, err error) {
+	err = c.breaker.do(ctx, func() error {
+		run, err = c.Client.SignalWithStartWorkflow(ctx, workflowID, signalName, signalArg, options, workflow, workflowArgs...)
+		return err
+	})
+	return run, err
+}
+
+func (c *breakerClient) QueryWorkflow(ctx context.Context, workflowID string, runID string, queryType string, args ...interface{}) (value converter.EncodedValue, err error) {
+	err = c.breaker.do(ctx, func() error {
+		value, err = c.Client.QueryWorkflow(ctx, workflowID, runID, queryType, args...)
+		return err
+	})
+	return value, err
+}
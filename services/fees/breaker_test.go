@@ -0,0 +1,65 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/serviceerror"
+)
+
+// TestCircuitBreaker tests that the breaker opens after consecutive unavailability
+// errors, fails fast while open, and lets a single probe through after the cooldown.
+func TestCircuitBreaker(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	b := newCircuitBreaker(clock, 2, 30*time.Second)
+	unavailable := serviceerror.NewUnavailable("connection refused")
+	ctx := context.Background()
+	calls := 0
+	call := func(err error) func() error {
+		return func() error { calls++; return err }
+	}
+
+	// Errors that do not mean Temporal is down reset the count.
+	require.Error(t, b.do(ctx, call(unavailable)))
+	require.Error(t, b.do(ctx, call(serviceerror.NewNotFound("no such workflow"))))
+	require.Error(t, b.do(ctx, call(unavailable)))
+	require.False(t, b.Open())
+
+	require.Error(t, b.do(ctx, call(unavailable)))
+	require.True(t, b.Open())
+	require.Equal(t, 4, calls)
+
+	// While open, calls are refused without reaching Temporal.
+	require.ErrorIs(t, b.do(ctx, call(nil)), errBreakerOpen)
+	require.Equal(t, 4, calls)
+
+	// After the cooldown one probe goes through; its failure reopens the breaker.
+	clock.Advance(30 * time.Second)
+	require.True(t, errors.Is(b.do(ctx, call(unavailable)), unavailable))
+	require.Equal(t, 5, calls)
+	require.ErrorIs(t, b.do(ctx, call(nil)), errBreakerOpen)
+
+	// A successful probe closes it.
+	clock.Advance(30 * time.Second)
+	require.NoError(t, b.do(ctx, call(nil)))
+	require.False(t, b.Open())
+	require.NoError(t, b.do(ctx, call(nil)))
+	require.Equal(t, 7, calls)
+
+	// Calls whose request ended do not count, even though Temporal reports them as
+	// past their deadline.
+	ended, cancel := context.WithCancel(ctx)
+	cancel()
+	deadline := serviceerror.NewDeadlineExceeded("context deadline exceeded")
+	require.Error(t, b.do(ended, call(deadline)))
+	require.Error(t, b.do(ended, call(deadline)))
+	require.False(t, b.Open())
+
+	// A nil breaker always calls through.
+	var none *circuitBreaker
+	require.NoError(t, none.do(ctx, call(nil)))
+	require.False(t, none.Open())
+}
@@ -0,0 +1,18 @@
+package fees
+
+import "time"
+
+// Clock is the source of time for service-layer code: quota periods, rate limiting,
+// status windows, and the CloseBill polling loop. Tests inject a fake to control time
+// instead of sleeping. Workflow code must keep using workflow.Now and workflow timers.
+type Clock interface {
+	Now() time.Time
+	// After returns a channel that receives the current time once d has elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the real wall clock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
@@ -0,0 +1,95 @@
+package fees
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a Clock that only moves when Advance is called.
+type fakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	c := &fakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeTimer{at: c.now.Add(d), ch: ch})
+	c.cond.Broadcast()
+	return ch
+}
+
+// Advance moves the clock forward by d and fires every timer that has come due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = pending
+}
+
+// BlockUntil waits until n timers are pending, so a test can advance the clock only
+// once the code under test is waiting on it.
+func (c *fakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// TestFakeClock tests that timers fire only once the clock has been advanced past them.
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+
+	short := clock.After(time.Second)
+	long := clock.After(time.Minute)
+	clock.BlockUntil(2)
+
+	clock.Advance(999 * time.Millisecond)
+	require.Empty(t, short)
+
+	clock.Advance(time.Millisecond)
+	require.Equal(t, start.Add(time.Second), <-short)
+	require.Empty(t, long)
+
+	clock.Advance(time.Hour)
+	require.Equal(t, start.Add(time.Hour+time.Second), <-long)
+	require.Equal(t, start.Add(time.Hour+time.Second), clock.Now())
+	require.Equal(t, start.Add(time.Hour+time.Second), <-clock.After(0))
+}
@@ -0,0 +1,193 @@
+package fees
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"encore.app/apierr"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// FindCloseBatchBillsActivityName finds one page of the bills a close batch closes.
+	FindCloseBatchBillsActivityName = "FindCloseBatchBillsActivity"
+
+	// closeBatchPageSize is how many bills FindCloseBatchBillsActivity returns at once.
+	closeBatchPageSize = 500
+	// closeBatchPagesPerRun is how many pages a CloseBatchWorkflow run handles before
+	// continuing as new, bounding its history.
+	closeBatchPagesPerRun = 10
+	// closeBatchConcurrency is how many close signals a close batch has in flight.
+	closeBatchConcurrency = 20
+	// maxCloseBatchFailures caps the failed bill IDs a close batch reports.
+	maxCloseBatchFailures = 100
+)
+
+// CloseBatchFilter selects the open bills a close batch closes. Bills must match every
+// field that is set.
+type CloseBatchFilter struct {
+	CustomerIDs   []string   `json:"customerIds,omitempty"`
+	CreatedBefore *time.Time `json:"createdBefore,omitempty"`
+	// TenantID limits the batch to one tenant's bills; tenant-scoped API keys only ever
+	// close their own tenant's bills.
+	TenantID string `json:"tenantId,omitempty"`
+}
+
+// FindCloseBatchBillsActivityParams defines parameters for FindCloseBatchBillsActivity.
+type FindCloseBatchBillsActivityParams struct {
+	Filter  CloseBatchFilter
+	AfterID string
+	Limit   int
+}
+
+// ------ Workflow ------
+
+// runCloseBatch runs a JobKindCloseBatch job: it asks every open bill matching the
+// job's CloseBatchFilter to close, a page of bills at a time in bill ID order, with at
+// most closeBatchConcurrency signals in flight.
+func runCloseBatch(run *jobRun) error {
+	ctx, logger := run.ctx, workflow.GetLogger(run.ctx)
+	var filter CloseBatchFilter
+	if err := run.decode(&filter); err != nil {
+		return err
+	}
+	signal := CloseBillSignal{RequestedByKeyID: run.params.RequestedByKeyID}
+	progress := &run.params.Progress
+
+	for page := 0; ; page++ {
+		if page == closeBatchPagesPerRun {
+			return errJobContinue
+		}
+		var billIDs []string
+		err := workflow.ExecuteActivity(ctx, FindCloseBatchBillsActivityName, FindCloseBatchBillsActivityParams{
+			Filter:  filter,
+			AfterID: run.params.Cursor,
+			Limit:   closeBatchPageSize,
+		}).Get(ctx, &billIDs)
+		if err != nil {
+			logger.Error("Failed to execute FindCloseBatchBillsActivity", "job_id", run.params.JobID, "after_id", run.params.Cursor, "error", err)
+			return err
+		}
+
+		progress.Processed += len(billIDs)
+		signalCloseBatch(ctx, billIDs, signal, progress)
+		if ctx.Err() != nil {
+			return temporal.NewCanceledError()
+		}
+		cursor := run.params.Cursor
+		if len(billIDs) > 0 {
+			cursor = billIDs[len(billIDs)-1]
+		}
+		if err := run.checkpoint(cursor); err != nil {
+			return err
+		}
+		if len(billIDs) < closeBatchPageSize {
+			return nil
+		}
+	}
+}
+
+// signalCloseBatch asks each of billIDs to close, with at most closeBatchConcurrency
+// signals in flight, and counts the outcomes in progress. It stops sending signals once
+// ctx is canceled.
+func signalCloseBatch(ctx workflow.Context, billIDs []string, signal CloseBillSignal, progress *JobProgress) {
+	selector := workflow.NewSelector(ctx)
+	inFlight := 0
+	for _, billID := range billIDs {
+		if inFlight == closeBatchConcurrency {
+			selector.Select(ctx)
+			inFlight--
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		billID := billID
+		future := workflow.SignalExternalWorkflow(ctx, billWorkflowID(billID), "", CloseBillSignalName, signal)
+		selector.AddFuture(future, func(f workflow.Future) {
+			if err := f.Get(ctx, nil); err != nil {
+				if ctx.Err() == nil {
+					workflow.GetLogger(ctx).Warn("Close batch could not signal bill", "bill_id", billID, "error", err)
+					progress.fail(billID)
+				}
+				return
+			}
+			progress.Succeeded++
+		})
+		inFlight++
+	}
+	for ; inFlight > 0; inFlight-- {
+		selector.Select(ctx)
+	}
+}
+
+// FindCloseBatchBillsActivity returns the IDs of up to params.Limit open bills matching
+// params.Filter, in bill ID order after params.AfterID.
+func (a *Activities) FindCloseBatchBillsActivity(ctx context.Context, params FindCloseBatchBillsActivityParams) ([]string, error) {
+	f := params.Filter
+	rows, err := a.DB.Query(ctx, `
+        SELECT id FROM bills
+        WHERE status = 'OPEN' AND id > $1
+          AND ($2 = '' OR tenant_id = $2)
+          AND (COALESCE(cardinality($3::text[]), 0) = 0 OR customer_id = ANY($3))
+          AND ($4::timestamptz IS NULL OR created_at < $4)
+        ORDER BY id
+        LIMIT $5
+    `, params.AfterID, f.TenantID, f.CustomerIDs, f.CreatedBefore, params.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("FindCloseBatchBillsActivity: failed to find bills after %q: %w", params.AfterID, err)
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("FindCloseBatchBillsActivity: failed to read bill ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("FindCloseBatchBillsActivity: failed to find bills after %q: %w", params.AfterID, err)
+	}
+	return ids, nil
+}
+
+// ------ API ------
+
+// CloseBillsRequest is the request payload for closing a batch of bills.
+type CloseBillsRequest struct {
+	// CustomerIDs closes the open bills of these customers.
+	CustomerIDs []string `json:"customerIds,omitempty"`
+	// CreatedBefore closes the open bills created before this time.
+	CreatedBefore *time.Time `json:"createdBefore,omitempty"`
+	// TenantID limits an internal caller's batch to one tenant. Tenant-scoped API keys
+	// only ever close their own tenant's bills.
+	TenantID string `json:"tenantId,omitempty"`
+}
+
+// CloseBills starts closing every open bill matching a filter, such as all open bills
+// of a set of customers at month end. It returns at once with the close_batch job,
+// whose progress GetJob reports.
+//
+// encore:api auth method=POST path=/bills/close-batch
+func (s *Service) CloseBills(ctx context.Context, params *CloseBillsRequest) (*JobResponse, error) {
+	if len(params.CustomerIDs) == 0 && params.CreatedBefore == nil {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "customerIds or createdBefore is required")
+	}
+	for _, id := range params.CustomerIDs {
+		if id == "" {
+			return nil, apierr.InvalidArgument(apierr.InvalidParameter, "customerIds must not contain empty IDs")
+		}
+	}
+	filter := CloseBatchFilter{CustomerIDs: params.CustomerIDs, CreatedBefore: params.CreatedBefore, TenantID: params.TenantID}
+	if tenant := callerTenant(ctx); tenant != "" {
+		filter.TenantID = tenant
+	}
+
+	job, err := s.startJob(ctx, JobKindCloseBatch, filter.TenantID, filter)
+	if err != nil {
+		return nil, err
+	}
+	return &JobResponse{Job: *job}, nil
+}
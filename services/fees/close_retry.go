@@ -0,0 +1,148 @@
+package fees
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"encore.app/apierr"
+	"go.temporal.io/sdk/workflow"
+)
+
+// closeRetryInterval is how long a CLOSE_FAILED bill waits before the workflow retries
+// its close on its own.
+const closeRetryInterval = 15 * time.Minute
+
+// CloseFailure records why a CLOSE_FAILED bill's close could not be saved.
+type CloseFailure struct {
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failedAt"`
+	// Retries counts the retries of the close that have failed since.
+	Retries int `json:"retries,omitempty"`
+	// RetryAt is when the close is retried unless an operator retries it first.
+	RetryAt time.Time `json:"retryAt"`
+}
+
+// RetryCloseSignal retries saving the close of a CLOSE_FAILED bill.
+type RetryCloseSignal struct {
+	// RequestedByKeyID is the API key that asked for the retry, operatorActor for
+	// retries from the admin API, and empty for the workflow's own retries.
+	RequestedByKeyID string
+}
+
+// operatorActor stands in for an API key on requests made through the admin API.
+const operatorActor = "operator"
+
+// ------ Workflow ------
+
+// saveClose persists the close of the bill, retrying transient failures, and only then
+// marks the bill closed. If the close cannot be saved, the bill moves to CLOSE_FAILED
+// and holds params until a retry succeeds.
+func (w *billWorkflow) saveClose(params UpdateBillOnCloseActivityParams) {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	logger.Info("Executing UpdateBillOnCloseActivity", "bill_id", bill.ID)
+	actErr := workflow.ExecuteActivity(ctx, UpdateBillOnCloseActivityName, params).Get(ctx, nil)
+	if actErr != nil {
+		logger.Error("Failed to execute UpdateBillOnCloseActivity", "bill_id", bill.ID, "error", actErr)
+		w.failClose(params, actErr)
+		return
+	}
+
+	closedAt := params.ClosedAt
+	w.pendingClose = nil
+	bill.Status = BillStatusClosed
+	bill.ClosedAt = &closedAt
+	bill.TotalAmount = params.TotalAmount
+	bill.refreshBalance()
+	bill.CloseFailure = nil
+	logger.Info("Bill marked as closed in workflow state", "bill_id", bill.ID, "total_amount", bill.TotalAmount)
+	w.rollUpToParent()
+	w.issuePayerShares()
+	w.emailBill()
+}
+
+// failClose moves the bill to CLOSE_FAILED, keeping params to retry after
+// closeRetryInterval or when an operator asks. Operators are notified of the first
+// failure only.
+func (w *billWorkflow) failClose(params UpdateBillOnCloseActivityParams, cause error) {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	now := workflow.Now(ctx)
+	failure := &CloseFailure{Error: cause.Error(), FailedAt: now, RetryAt: now.Add(closeRetryInterval)}
+	retried := bill.CloseFailure != nil
+	if retried {
+		failure.Retries = bill.CloseFailure.Retries + 1
+	}
+	bill.CloseFailure = failure
+	w.pendingClose = &params
+	timerCtx, cancel := workflow.WithCancel(ctx)
+	w.closeRetryTimer, w.cancelCloseRetryTimer = workflow.NewTimer(timerCtx, closeRetryInterval), cancel
+	w.setStatus(BillStatusCloseFailed)
+	logger.Warn("Bill close could not be saved, holding it for retry", "bill_id", bill.ID, "retries", failure.Retries, "retry_at", failure.RetryAt)
+
+	if retried {
+		return
+	}
+	notify(ctx, Notification{
+		Event:      NotificationBillCloseFailed,
+		BillID:     bill.ID,
+		CustomerID: bill.CustomerID,
+		Message:    fmt.Sprintf("Bill close could not be saved (%s); it is retried at %s, or can be retried now.", failure.Error, failure.RetryAt.Format(time.RFC3339)),
+	})
+}
+
+// retryClose saves the held close of a CLOSE_FAILED bill again.
+func (w *billWorkflow) retryClose(signal RetryCloseSignal) {
+	logger, bill := w.logger, w.bill
+
+	if bill.Status != BillStatusCloseFailed || w.pendingClose == nil {
+		logger.Warn("RetryCloseSignal received for a bill without a failed close, ignoring.", "bill_id", bill.ID, "bill_status", bill.Status)
+		return
+	}
+	if w.cancelCloseRetryTimer != nil {
+		w.cancelCloseRetryTimer()
+	}
+	w.closeRetryTimer, w.cancelCloseRetryTimer = nil, nil
+	w.touch()
+	params := *w.pendingClose
+	params.Version = bill.Version
+	logger.Info("Retrying bill close", "bill_id", bill.ID, "requested_by", signal.RequestedByKeyID)
+	w.saveClose(params)
+}
+
+// ------ API ------
+
+// RetryCloseBill retries saving the close of a CLOSE_FAILED bill. The bill is CLOSED
+// once the close is saved; if saving fails again, it stays CLOSE_FAILED with the new
+// error in its closeFailure.
+//
+// encore:api auth method=POST path=/bills/:billID/close/retry
+func (s *Service) RetryCloseBill(ctx context.Context, billID string) (*CloseBillResponse, error) {
+	return s.retryClose(ctx, billID, RetryCloseSignal{RequestedByKeyID: callerKeyID(ctx)})
+}
+
+// retryClose sends signal to a CLOSE_FAILED bill's workflow.
+func (s *Service) retryClose(ctx context.Context, billID string, signal RetryCloseSignal) (*CloseBillResponse, error) {
+	getResp, err := s.getBill(ctx, billID)
+	if err != nil {
+		return nil, err
+	}
+	bill := getResp.RetrievedBill
+
+	switch {
+	case bill.Status == BillStatusCloseFailed:
+	case !bill.isFinalizing():
+		return &CloseBillResponse{Bill: bill, ConfirmationMsg: "Bill is already closed."}, nil
+	default:
+		return nil, apierr.FailedPrecondition(apierr.BillNotCloseFailed, "bill %s is %s and has no failed close to retry", billID, bill.Status)
+	}
+
+	if err := s.temporalClient.SignalWorkflow(ctx, billWorkflowID(billID), "", RetryCloseSignalName, signal); err != nil {
+		return nil, apierr.FromTemporal(err, apierr.BillNotFound, "bill %s not found", billID)
+	}
+	return &CloseBillResponse{
+		Bill:            bill,
+		ConfirmationMsg: "Close retry requested; the bill is CLOSED once its close is saved.",
+	}, nil
+}
@@ -0,0 +1,273 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"encore.app/apierr"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// CloseSweepScheduleID names the Temporal Schedule that starts CloseSweepWorkflow.
+	CloseSweepScheduleID = "close-sweep"
+	// SweepBillsActivityName closes one batch of bills due for the close sweep.
+	SweepBillsActivityName = "SweepBillsActivity"
+
+	// defaultCloseSweepSchedule runs the close sweep nightly at 02:00 UTC.
+	defaultCloseSweepSchedule = "0 2 * * *"
+	// closeSweepOff disables the close sweep schedule.
+	closeSweepOff = "off"
+	// closeSweepBatchSize is how many bills one SweepBillsActivity closes.
+	closeSweepBatchSize = 500
+)
+
+// CloseSweepParams configures a CloseSweepWorkflow run.
+type CloseSweepParams struct {
+	// BillTTL also closes bills open for longer than this. Zero only closes bills past
+	// their period end.
+	BillTTL time.Duration
+}
+
+// CloseSweepResult counts the bills a CloseSweepWorkflow run asked to close.
+type CloseSweepResult struct {
+	Signaled int `json:"signaled"`
+	// Failed counts bills whose workflow could not be signaled, such as bills whose
+	// workflow is gone. They are retried by the next sweep.
+	Failed int `json:"failed"`
+}
+
+// SweepBillsActivityParams defines parameters for SweepBillsActivity.
+type SweepBillsActivityParams struct {
+	// AsOf is when the sweep started; bills are due if their period ended by then.
+	AsOf    time.Time
+	BillTTL time.Duration
+	// AfterID resumes the sweep after the last bill of the previous batch.
+	AfterID string
+	Limit   int
+}
+
+// SweepBillsActivityResult is the outcome of one batch of the close sweep.
+type SweepBillsActivityResult struct {
+	Found    int
+	Signaled int
+	Failed   int
+	// LastID is the ID of the batch's last bill, to resume the sweep after.
+	LastID string
+}
+
+// ------ Workflow ------
+
+// CloseSweepWorkflow closes open bills past their period end, or open for longer than
+// params.BillTTL, in batches ordered by bill ID. Each bill is closed by its own
+// workflow, so bills do not need a timer of their own for their period end. It is
+// started by the close sweep schedule.
+func CloseSweepWorkflow(ctx workflow.Context, params CloseSweepParams) (*CloseSweepResult, error) {
+	logger := workflow.GetLogger(ctx)
+
+	asOf := workflow.Now(ctx)
+	result := &CloseSweepResult{}
+	afterID := ""
+	for {
+		var batch SweepBillsActivityResult
+		err := workflow.ExecuteActivity(ctx, SweepBillsActivityName, SweepBillsActivityParams{
+			AsOf:    asOf,
+			BillTTL: params.BillTTL,
+			AfterID: afterID,
+			Limit:   closeSweepBatchSize,
+		}).Get(ctx, &batch)
+		if err != nil {
+			logger.Error("Failed to execute SweepBillsActivity", "after_id", afterID, "error", err)
+			return result, err
+		}
+		result.Signaled += batch.Signaled
+		result.Failed += batch.Failed
+		if batch.Found < closeSweepBatchSize {
+			break
+		}
+		afterID = batch.LastID
+	}
+	logger.Info("Close sweep finished", "as_of", asOf, "signaled", result.Signaled, "failed", result.Failed)
+	return result, nil
+}
+
+// SweepBillsActivity asks up to params.Limit open bills due for the close sweep to
+// close, in bill ID order after params.AfterID. Bills whose workflow cannot be signaled
+// are counted as failed rather than failing the batch.
+func (a *Activities) SweepBillsActivity(ctx context.Context, params SweepBillsActivityParams) (*SweepBillsActivityResult, error) {
+	rows, err := a.DB.Query(ctx, `
+        SELECT id FROM bills
+        WHERE status = 'OPEN' AND id > $1
+          AND (period_end <= $2 OR ($3 > 0 AND created_at <= $2 - make_interval(secs => $3)))
+        ORDER BY id
+        LIMIT $4
+    `, params.AfterID, params.AsOf, params.BillTTL.Seconds(), params.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("SweepBillsActivity: failed to find bills due after %q: %w", params.AfterID, err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("SweepBillsActivity: failed to read bill ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("SweepBillsActivity: failed to find bills due after %q: %w", params.AfterID, err)
+	}
+
+	result := &SweepBillsActivityResult{Found: len(ids)}
+	for _, id := range ids {
+		if err := heartbeat(ctx, result.LastID); err != nil {
+			return nil, fmt.Errorf("SweepBillsActivity: stopped after bill %q: %w", result.LastID, err)
+		}
+		result.LastID = id
+		if err := a.Temporal.SignalWorkflow(ctx, billWorkflowID(id), "", CloseBillSignalName, CloseBillSignal{}); err != nil {
+			slog.Warn("Close sweep could not signal bill", "bill_id", id, "error", err)
+			result.Failed++
+			continue
+		}
+		result.Signaled++
+	}
+	return result, nil
+}
+
+// ------ Schedule ------
+
+// closeSweepSchedule returns the schedule spec and action for cfg.
+func closeSweepSchedule(cfg *Config) (client.ScheduleSpec, *client.ScheduleWorkflowAction) {
+	spec := client.ScheduleSpec{CronExpressions: []string{cfg.CloseSweepSchedule}}
+	action := &client.ScheduleWorkflowAction{
+		ID:        CloseSweepScheduleID,
+		Workflow:  CloseSweepWorkflow,
+		Args:      []interface{}{CloseSweepParams{BillTTL: cfg.BillTTL}},
+		TaskQueue: feesTaskQueue,
+	}
+	return spec, action
+}
+
+// ensureCloseSweepSchedule creates the close sweep schedule, or brings an existing one
+// in line with cfg while keeping whether it is paused. It does nothing when the sweep is
+// off.
+func ensureCloseSweepSchedule(ctx context.Context, schedules client.ScheduleClient, cfg *Config) error {
+	if cfg.CloseSweepSchedule == closeSweepOff {
+		return nil
+	}
+	spec, action := closeSweepSchedule(cfg)
+	_, err := schedules.Create(ctx, client.ScheduleOptions{
+		ID:      CloseSweepScheduleID,
+		Spec:    spec,
+		Action:  action,
+		Overlap: enums.SCHEDULE_OVERLAP_POLICY_SKIP,
+		Note:    "Closes bills past their period end.",
+	})
+	if !errors.Is(err, temporal.ErrScheduleAlreadyRunning) {
+		return err
+	}
+	return schedules.GetHandle(ctx, CloseSweepScheduleID).Update(ctx, client.ScheduleUpdateOptions{
+		DoUpdate: func(input client.ScheduleUpdateInput) (*client.ScheduleUpdate, error) {
+			schedule := input.Description.Schedule
+			schedule.Spec, schedule.Action = &spec, action
+			return &client.ScheduleUpdate{Schedule: &schedule}, nil
+		},
+	})
+}
+
+// ------ API ------
+
+// CloseSweepScheduleResponse describes the close sweep schedule.
+type CloseSweepScheduleResponse struct {
+	ID string `json:"id"`
+	// Schedule is the configured cron expression, in UTC.
+	Schedule string `json:"schedule"`
+	BillTTL  string `json:"billTtl,omitempty"`
+	Paused   bool   `json:"paused"`
+	// Note is the note left by the last pause or resume.
+	Note string `json:"note,omitempty"`
+	// NextRuns lists when the sweep runs next, unless it is paused.
+	NextRuns []time.Time `json:"nextRuns"`
+	// RecentRuns lists the sweep's most recent runs, oldest first.
+	RecentRuns []CloseSweepRun `json:"recentRuns"`
+}
+
+// CloseSweepRun is one run started by the close sweep schedule.
+type CloseSweepRun struct {
+	ScheduledAt time.Time `json:"scheduledAt"`
+	StartedAt   time.Time `json:"startedAt"`
+	WorkflowID  string    `json:"workflowId,omitempty"`
+	RunID       string    `json:"runId,omitempty"`
+}
+
+// CloseSweepScheduleActionRequest is the request payload for pausing or resuming the
+// close sweep schedule.
+type CloseSweepScheduleActionRequest struct {
+	// Note explains why the schedule was paused or resumed.
+	Note string `json:"note,omitempty"`
+}
+
+// GetCloseSweepSchedule describes the close sweep schedule and its recent runs.
+//
+// encore:api private method=GET path=/admin/schedules/close-sweep
+func (s *Service) GetCloseSweepSchedule(ctx context.Context) (*CloseSweepScheduleResponse, error) {
+	desc, err := s.temporalClient.ScheduleClient().GetHandle(ctx, CloseSweepScheduleID).Describe(ctx)
+	if err != nil {
+		return nil, apierr.FromTemporal(err, apierr.ScheduleNotFound, "schedule %s not found", CloseSweepScheduleID)
+	}
+	resp := &CloseSweepScheduleResponse{
+		ID:         CloseSweepScheduleID,
+		Schedule:   s.cfg.CloseSweepSchedule,
+		NextRuns:   desc.Info.NextActionTimes,
+		RecentRuns: []CloseSweepRun{},
+	}
+	if s.cfg.BillTTL > 0 {
+		resp.BillTTL = s.cfg.BillTTL.String()
+	}
+	if state := desc.Schedule.State; state != nil {
+		resp.Paused, resp.Note = state.Paused, state.Note
+	}
+	if resp.NextRuns == nil {
+		resp.NextRuns = []time.Time{}
+	}
+	for _, action := range desc.Info.RecentActions {
+		run := CloseSweepRun{ScheduledAt: action.ScheduleTime, StartedAt: action.ActualTime}
+		if started := action.StartWorkflowResult; started != nil {
+			run.WorkflowID, run.RunID = started.WorkflowID, started.FirstExecutionRunID
+		}
+		resp.RecentRuns = append(resp.RecentRuns, run)
+	}
+	return resp, nil
+}
+
+// PauseCloseSweepSchedule pauses the close sweep schedule. Bills past their period end
+// stay open until it is resumed.
+//
+// encore:api private method=POST path=/admin/schedules/close-sweep/pause
+func (s *Service) PauseCloseSweepSchedule(ctx context.Context, params *CloseSweepScheduleActionRequest) (*CloseSweepScheduleResponse, error) {
+	handle := s.temporalClient.ScheduleClient().GetHandle(ctx, CloseSweepScheduleID)
+	if err := handle.Pause(ctx, client.SchedulePauseOptions{Note: params.Note}); err != nil {
+		return nil, apierr.FromTemporal(err, apierr.ScheduleNotFound, "schedule %s not found", CloseSweepScheduleID)
+	}
+	loggerFrom(ctx).Info("Close sweep schedule paused", "note", params.Note)
+	return s.GetCloseSweepSchedule(ctx)
+}
+
+// ResumeCloseSweepSchedule resumes a paused close sweep schedule. Runs missed while it
+// was paused are not made up; the next run closes every bill that came due meanwhile.
+//
+// encore:api private method=POST path=/admin/schedules/close-sweep/resume
+func (s *Service) ResumeCloseSweepSchedule(ctx context.Context, params *CloseSweepScheduleActionRequest) (*CloseSweepScheduleResponse, error) {
+	handle := s.temporalClient.ScheduleClient().GetHandle(ctx, CloseSweepScheduleID)
+	if err := handle.Unpause(ctx, client.ScheduleUnpauseOptions{Note: params.Note}); err != nil {
+		return nil, apierr.FromTemporal(err, apierr.ScheduleNotFound, "schedule %s not found", CloseSweepScheduleID)
+	}
+	loggerFrom(ctx).Info("Close sweep schedule resumed", "note", params.Note)
+	return s.GetCloseSweepSchedule(ctx)
+}
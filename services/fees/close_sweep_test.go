@@ -0,0 +1,51 @@
+package fees
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/mocks"
+	"go.temporal.io/sdk/temporal"
+)
+
+// TestEnsureCloseSweepSchedule_Create tests that the schedule is created from the
+// configured cron expression and bill TTL.
+func TestEnsureCloseSweepSchedule_Create(t *testing.T) {
+	schedules := mocks.NewScheduleClient(t)
+	cfg := &Config{CloseSweepSchedule: "30 1 * * *", BillTTL: 90 * 24 * time.Hour}
+	schedules.On("Create", mock.Anything, mock.MatchedBy(func(o client.ScheduleOptions) bool {
+		action := o.Action.(*client.ScheduleWorkflowAction)
+		return o.ID == CloseSweepScheduleID && o.Spec.CronExpressions[0] == "30 1 * * *" &&
+			action.Args[0].(CloseSweepParams).BillTTL == cfg.BillTTL
+	})).Return(mocks.NewScheduleHandle(t), nil).Once()
+
+	require.NoError(t, ensureCloseSweepSchedule(context.Background(), schedules, cfg))
+}
+
+// TestEnsureCloseSweepSchedule_Update tests that an existing schedule takes the
+// configured spec but stays paused.
+func TestEnsureCloseSweepSchedule_Update(t *testing.T) {
+	schedules := mocks.NewScheduleClient(t)
+	handle := mocks.NewScheduleHandle(t)
+	cfg := &Config{CloseSweepSchedule: "0 3 * * *"}
+	schedules.On("Create", mock.Anything, mock.Anything).Return(nil, temporal.ErrScheduleAlreadyRunning).Once()
+	schedules.On("GetHandle", mock.Anything, CloseSweepScheduleID).Return(handle).Once()
+	handle.On("Update", mock.Anything, mock.MatchedBy(func(o client.ScheduleUpdateOptions) bool {
+		update, err := o.DoUpdate(client.ScheduleUpdateInput{Description: client.ScheduleDescription{
+			Schedule: client.Schedule{State: &client.ScheduleState{Paused: true}},
+		}})
+		return err == nil && update.Schedule.Spec.CronExpressions[0] == "0 3 * * *" && update.Schedule.State.Paused
+	})).Return(nil).Once()
+
+	require.NoError(t, ensureCloseSweepSchedule(context.Background(), schedules, cfg))
+}
+
+// TestEnsureCloseSweepSchedule_Off tests that no schedule is created when the sweep is off.
+func TestEnsureCloseSweepSchedule_Off(t *testing.T) {
+	schedules := mocks.NewScheduleClient(t)
+	require.NoError(t, ensureCloseSweepSchedule(context.Background(), schedules, &Config{CloseSweepSchedule: closeSweepOff}))
+}
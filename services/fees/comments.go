@@ -0,0 +1,131 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/storage/sqldb"
+)
+
+const (
+	// maxCommentLength caps the body of a comment.
+	maxCommentLength = 4000
+	// maxCommentAuthorLength caps the author name of a comment.
+	maxCommentAuthorLength = 255
+)
+
+// Comment is a note left on a bill by operations or support staff. Comments do not
+// change the bill.
+type Comment struct {
+	ID     string `json:"id"`
+	BillID string `json:"billId"`
+	Body   string `json:"body"`
+	// Author is the person who wrote the comment, as given by the caller.
+	Author string `json:"author,omitempty"`
+	// AuthorKeyID is the API key the comment was posted with.
+	AuthorKeyID string    `json:"authorKeyId,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// AddCommentRequest is the request payload for commenting on a bill.
+type AddCommentRequest struct {
+	Body string `json:"body"`
+	// Author names the person writing the comment, such as a support agent's email.
+	Author string `json:"author,omitempty"`
+}
+
+// CommentResponse is the response payload for a comment.
+type CommentResponse struct {
+	RetryMetadata
+	Comment Comment `json:"comment"`
+}
+
+// ListCommentsResponse is the response payload for a bill's comments.
+type ListCommentsResponse struct {
+	BillID   string    `json:"billId"`
+	Comments []Comment `json:"comments"`
+}
+
+// checkComment validates a comment and returns its trimmed body.
+func checkComment(req *AddCommentRequest) (string, error) {
+	body := strings.TrimSpace(req.Body)
+	if body == "" {
+		return "", apierr.InvalidArgument(apierr.InvalidParameter, "comment body must not be empty")
+	}
+	if len(body) > maxCommentLength {
+		return "", apierr.InvalidArgument(apierr.InvalidParameter, "comment body must be at most %d characters", maxCommentLength)
+	}
+	if len(req.Author) > maxCommentAuthorLength {
+		return "", apierr.InvalidArgument(apierr.InvalidParameter, "author must be at most %d characters", maxCommentAuthorLength)
+	}
+	return body, nil
+}
+
+const commentColumns = `id, bill_id, body, COALESCE(author, ''), COALESCE(author_key_id, ''), created_at`
+
+func scanComment(row interface{ Scan(...any) error }) (*Comment, error) {
+	var c Comment
+	if err := row.Scan(&c.ID, &c.BillID, &c.Body, &c.Author, &c.AuthorKeyID, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// AddComment adds a comment to a bill in any status.
+//
+// encore:api auth method=POST path=/bills/:billID/comments
+func (s *Service) AddComment(ctx context.Context, billID string, req *AddCommentRequest) (*CommentResponse, error) {
+	body, err := checkComment(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkBillVisible(ctx, billID); err != nil {
+		return nil, err
+	}
+
+	commentID := keyedID(ctx, "comment", billID)
+	comment, err := scanComment(s.db.QueryRow(ctx, `
+        INSERT INTO bill_comments (id, bill_id, body, author, author_key_id, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (id) DO NOTHING
+        RETURNING `+commentColumns,
+		commentID, billID, body, nullIfEmpty(strings.TrimSpace(req.Author)), nullIfEmpty(callerKeyID(ctx)), s.clock.Now()))
+	if errors.Is(err, sqldb.ErrNoRows) {
+		// A request with the same idempotency key saved it before.
+		comment, err = scanComment(s.db.QueryRow(ctx, `SELECT `+commentColumns+` FROM bill_comments WHERE id = $1`, commentID))
+	}
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to save comment on bill %s", billID)
+	}
+	return &CommentResponse{Comment: *comment}, nil
+}
+
+// ListComments lists the comments on a bill, oldest first.
+//
+// encore:api auth method=GET path=/bills/:billID/comments
+func (s *Service) ListComments(ctx context.Context, billID string) (*ListCommentsResponse, error) {
+	if err := s.checkBillVisible(ctx, billID); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(ctx, `SELECT `+commentColumns+` FROM bill_comments WHERE bill_id = $1 ORDER BY created_at, id`, billID)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to list comments on bill %s", billID)
+	}
+	defer rows.Close()
+
+	resp := &ListCommentsResponse{BillID: billID, Comments: []Comment{}}
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to read comments on bill %s", billID)
+		}
+		resp.Comments = append(resp.Comments, *c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read comments on bill %s", billID)
+	}
+	return resp, nil
+}
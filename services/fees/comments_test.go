@@ -0,0 +1,24 @@
+package fees
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestCheckComment tests that comment bodies are trimmed and bounded.
+func TestCheckComment(t *testing.T) {
+	body, err := checkComment(&AddCommentRequest{Body: "  Customer disputes the June overage.\n", Author: "support@example.com"})
+	require.NoError(t, err)
+	require.Equal(t, "Customer disputes the June overage.", body)
+
+	for name, req := range map[string]AddCommentRequest{
+		"empty":       {Body: " \n "},
+		"long body":   {Body: strings.Repeat("x", maxCommentLength+1)},
+		"long author": {Body: "ok", Author: strings.Repeat("x", maxCommentAuthorLength+1)},
+	} {
+		_, err := checkComment(&req)
+		require.Error(t, err, name)
+	}
+}
@@ -0,0 +1,477 @@
+package fees
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"encore.app/proration"
+	"encore.app/rounding"
+)
+
+// Config holds deployment-level settings for the fees service.
+// Values are read from FEES_* environment variables at startup; unset variables keep their defaults.
+type Config struct {
+	// CloseGracePeriod is how long a bill keeps accepting line items after CloseBill
+	// is requested, unless the bill was created with its own grace period. Zero closes immediately.
+	CloseGracePeriod time.Duration
+
+	// DunningSchedule lists when failed payments are retried, as offsets from the
+	// first failure (e.g. "24h,72h,168h" for day 1/3/7). Empty disables dunning.
+	DunningSchedule []time.Duration
+
+	// LateItemPolicy decides what happens to line items that arrive after their bill
+	// closed: reject them, park them on the bill's follow-up bill, or append them to the
+	// customer's next open bill. Routed items are tagged with the original period.
+	LateItemPolicy LateItemPolicy
+
+	// SaveFailurePolicy decides how bills compensate for a line item that still could
+	// not be saved after retries: repair it from a durable queue, or remove it from the
+	// bill and notify the customer.
+	SaveFailurePolicy SaveFailurePolicy
+
+	// ReasonCodes lists the reason codes credits and adjustments may give.
+	ReasonCodes []string
+
+	// Approval holds bills whose total reaches Approval.Threshold in PENDING_APPROVAL
+	// until ApproveBill or RejectBill is called, escalating to approvers after
+	// Approval.EscalateAfter. A zero threshold disables approvals.
+	Approval ApprovalPolicy
+
+	// ProrationMethod measures the unused part of a subscription period when its plan
+	// changes or it is canceled mid-period: by calendar day or by second.
+	ProrationMethod proration.Method
+
+	// RoundingMode rounds line items and totals of new bills to their currency's minor
+	// units: half_up, half_even, or floor.
+	RoundingMode rounding.Mode
+
+	// CloseSweepSchedule is the cron expression, in UTC, of the Temporal Schedule that
+	// closes open bills past their period end or older than BillTTL. "off" disables it.
+	CloseSweepSchedule string
+
+	// BillTTL is how long a bill may stay open before the close sweep closes it, even
+	// without a period end. Zero only closes bills past their period end.
+	BillTTL time.Duration
+
+	// RetentionYears is how many years settled bills are kept after they close before
+	// the retention sweep deletes them. Zero keeps them forever.
+	RetentionYears int
+
+	// RetentionSweepSchedule is the cron expression, in UTC, of the Temporal Schedule
+	// that deletes bills past RetentionYears. "off" disables it.
+	RetentionSweepSchedule string
+
+	// ArchiveAfterDays is how many days after they close settled bills are archived to
+	// object storage, so they can still be read once the retention sweep deletes them.
+	// Zero disables archiving.
+	ArchiveAfterDays int
+
+	// ArchiveSweepSchedule is the cron expression, in UTC, of the Temporal Schedule
+	// that archives bills. "off" disables it.
+	ArchiveSweepSchedule string
+
+	// ArchiveSigningKeys sign archived bills with HMAC-SHA256, so a tampered archive is
+	// not served. The first key signs new archives; the others only verify archives
+	// signed before a rotation. They are required when archiving is enabled.
+	ArchiveSigningKeys []EncryptionKey
+
+	// AccrualSnapshotSchedule is the cron expression, in UTC, of the Temporal Schedule
+	// that snapshots the accruals of open bills at the end of the previous month.
+	// "off" disables it.
+	AccrualSnapshotSchedule string
+
+	// GRPCAddr is the listen address (e.g. ":9090") of the gRPC API served alongside
+	// the Encore HTTP endpoints. Empty disables it.
+	GRPCAddr string
+
+	// MonthlyBillQuota and MonthlyLineItemQuota cap how many bills and line items each
+	// tenant may create per calendar month, unless overridden per tenant. Zero is unlimited.
+	MonthlyBillQuota     int
+	MonthlyLineItemQuota int
+
+	// KeyRateLimit and CustomerRateLimit throttle requests per API key and per customer,
+	// given as "<per second>" or "<per second>:<burst>" (e.g. "20:100"). Unset disables them.
+	KeyRateLimit      RateLimit
+	CustomerRateLimit RateLimit
+
+	// Temporal says how to reach the Temporal cluster; see TemporalConfig.
+	Temporal TemporalConfig
+
+	// DB tunes how the service uses the database; see DBConfig.
+	DB DBConfig
+
+	// Worker tunes the Temporal worker; see WorkerConfig.
+	Worker WorkerConfig
+
+	// TaskQueues names the task queues workflows are started on and polled from; see
+	// TaskQueueConfig.
+	TaskQueues TaskQueueConfig
+
+	// LogLevel is the lowest level logged: debug, info (the default), warn, or error.
+	LogLevel slog.Level
+
+	// OTLPEndpoint is the OTLP/HTTP collector URL (e.g. "http://localhost:4318") that
+	// traces are exported to. Empty disables export.
+	OTLPEndpoint string
+
+	// FieldEncryptionKeys encrypt line item descriptions and client references in the
+	// database. The first key encrypts; the others only decrypt values written before a
+	// rotation. None stores them in plaintext.
+	FieldEncryptionKeys []EncryptionKey
+
+	// PaymentWebhookSecrets holds, by payment provider, the secrets that sign its
+	// callbacks to /webhooks/payments/:provider. A provider may have several during a
+	// secret rotation. Callbacks from providers without one are refused.
+	PaymentWebhookSecrets map[string][]string
+
+	// RevenueReportMaxAge is how stale the revenue report's materialized view may get
+	// before a report request refreshes it.
+	RevenueReportMaxAge time.Duration
+
+	// EventRelayInterval is how often bill events waiting in the event outbox are
+	// published to the bill-events topic and EventWebhookURL.
+	EventRelayInterval time.Duration
+
+	// EventWebhookURL receives every bill event as a POST signed with
+	// EventWebhookSecret. Empty only publishes to the bill-events topic.
+	EventWebhookURL    string
+	EventWebhookSecret string
+
+	// Email says how closed bills are emailed to customers; see EmailConfig.
+	Email EmailConfig
+
+	// Ops says which notifications are posted to billing operators' Slack and Teams
+	// channels; see OpsConfig.
+	Ops OpsConfig
+}
+
+// loadConfig reads the service configuration from the environment.
+func loadConfig() (*Config, error) {
+	cfg := &Config{}
+
+	if err := durationFromEnv("FEES_CLOSE_GRACE_PERIOD", &cfg.CloseGracePeriod); err != nil {
+		return nil, err
+	}
+	if cfg.CloseGracePeriod < 0 {
+		return nil, fmt.Errorf("FEES_CLOSE_GRACE_PERIOD must not be negative, got %s", cfg.CloseGracePeriod)
+	}
+
+	if v := os.Getenv("FEES_DUNNING_SCHEDULE"); v != "" {
+		var prev time.Duration
+		for _, part := range strings.Split(v, ",") {
+			d, err := time.ParseDuration(strings.TrimSpace(part))
+			if err != nil {
+				return nil, fmt.Errorf("invalid FEES_DUNNING_SCHEDULE %q: %w", v, err)
+			}
+			if d <= prev {
+				return nil, fmt.Errorf("invalid FEES_DUNNING_SCHEDULE %q: offsets must be positive and increasing", v)
+			}
+			cfg.DunningSchedule = append(cfg.DunningSchedule, d)
+			prev = d
+		}
+	}
+
+	cfg.LateItemPolicy = LateItemPolicyReject
+	if v := os.Getenv("FEES_ROUTE_LATE_ITEMS"); v != "" {
+		// Superseded by FEES_LATE_ITEM_POLICY; true is kept working as next_bill.
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FEES_ROUTE_LATE_ITEMS %q: %w", v, err)
+		}
+		if b {
+			cfg.LateItemPolicy = LateItemPolicyNextBill
+		}
+	}
+	if v := os.Getenv("FEES_LATE_ITEM_POLICY"); v != "" {
+		cfg.LateItemPolicy = LateItemPolicy(v)
+		if !cfg.LateItemPolicy.IsValid() {
+			return nil, fmt.Errorf("invalid FEES_LATE_ITEM_POLICY %q: must be reject, park, or next_bill", v)
+		}
+	}
+
+	cfg.SaveFailurePolicy = SaveFailurePolicyRepair
+	if v := os.Getenv("FEES_LINE_ITEM_SAVE_FAILURE_POLICY"); v != "" {
+		cfg.SaveFailurePolicy = SaveFailurePolicy(v)
+		if !cfg.SaveFailurePolicy.IsValid() {
+			return nil, fmt.Errorf("invalid FEES_LINE_ITEM_SAVE_FAILURE_POLICY %q: must be repair or remove", v)
+		}
+	}
+
+	cfg.ReasonCodes = defaultReasonCodes
+	if v := os.Getenv("FEES_LINE_ITEM_REASON_CODES"); v != "" {
+		codes, err := parseReasonCodes(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FEES_LINE_ITEM_REASON_CODES: %w", err)
+		}
+		cfg.ReasonCodes = codes
+	}
+
+	if v := os.Getenv("FEES_APPROVAL_THRESHOLD"); v != "" {
+		threshold, err := strconv.ParseFloat(v, 64)
+		if err != nil || threshold < 0 || math.IsInf(threshold, 0) {
+			return nil, fmt.Errorf("invalid FEES_APPROVAL_THRESHOLD %q: must be a non-negative amount", v)
+		}
+		cfg.Approval.Threshold = threshold
+	}
+	if err := durationFromEnv("FEES_APPROVAL_ESCALATE_AFTER", &cfg.Approval.EscalateAfter); err != nil {
+		return nil, err
+	}
+	if cfg.Approval.EscalateAfter < 0 {
+		return nil, fmt.Errorf("FEES_APPROVAL_ESCALATE_AFTER must not be negative, got %s", cfg.Approval.EscalateAfter)
+	}
+
+	cfg.ProrationMethod = proration.ByDay
+	if v := os.Getenv("FEES_PRORATION_METHOD"); v != "" {
+		cfg.ProrationMethod = proration.Method(v)
+		if !cfg.ProrationMethod.IsValid() {
+			return nil, fmt.Errorf("invalid FEES_PRORATION_METHOD %q: must be day or second", v)
+		}
+	}
+
+	cfg.RoundingMode = rounding.HalfUp
+	if v := os.Getenv("FEES_ROUNDING_MODE"); v != "" {
+		cfg.RoundingMode = rounding.Mode(v)
+		if !cfg.RoundingMode.IsValid() {
+			return nil, fmt.Errorf("invalid FEES_ROUNDING_MODE %q: must be half_up, half_even or floor", v)
+		}
+	}
+
+	cfg.CloseSweepSchedule = defaultCloseSweepSchedule
+	if v := os.Getenv("FEES_CLOSE_SWEEP_SCHEDULE"); v != "" {
+		cfg.CloseSweepSchedule = strings.TrimSpace(v)
+		if cfg.CloseSweepSchedule != closeSweepOff && len(strings.Fields(cfg.CloseSweepSchedule)) != 5 {
+			return nil, fmt.Errorf("invalid FEES_CLOSE_SWEEP_SCHEDULE %q: must be a five-field cron expression or \"off\"", v)
+		}
+	}
+	if err := durationFromEnv("FEES_BILL_TTL", &cfg.BillTTL); err != nil {
+		return nil, err
+	}
+	if cfg.BillTTL < 0 {
+		return nil, fmt.Errorf("FEES_BILL_TTL must not be negative, got %s", cfg.BillTTL)
+	}
+
+	if err := countFromEnv("FEES_RETENTION_YEARS", &cfg.RetentionYears); err != nil {
+		return nil, err
+	}
+	cfg.RetentionSweepSchedule = defaultRetentionSweepSchedule
+	if v := os.Getenv("FEES_RETENTION_SWEEP_SCHEDULE"); v != "" {
+		cfg.RetentionSweepSchedule = strings.TrimSpace(v)
+		if cfg.RetentionSweepSchedule != closeSweepOff && len(strings.Fields(cfg.RetentionSweepSchedule)) != 5 {
+			return nil, fmt.Errorf("invalid FEES_RETENTION_SWEEP_SCHEDULE %q: must be a five-field cron expression or \"off\"", v)
+		}
+	}
+
+	if err := countFromEnv("FEES_ARCHIVE_AFTER_DAYS", &cfg.ArchiveAfterDays); err != nil {
+		return nil, err
+	}
+	cfg.ArchiveSweepSchedule = defaultArchiveSweepSchedule
+	if v := os.Getenv("FEES_ARCHIVE_SWEEP_SCHEDULE"); v != "" {
+		cfg.ArchiveSweepSchedule = strings.TrimSpace(v)
+		if cfg.ArchiveSweepSchedule != closeSweepOff && len(strings.Fields(cfg.ArchiveSweepSchedule)) != 5 {
+			return nil, fmt.Errorf("invalid FEES_ARCHIVE_SWEEP_SCHEDULE %q: must be a five-field cron expression or \"off\"", v)
+		}
+	}
+	archiveKeys, err := loadEncryptionKeys("FEES_ARCHIVE_SIGNING_KEYS", "archive signing keys")
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ArchiveAfterDays > 0 && len(archiveKeys) == 0 {
+		return nil, fmt.Errorf("FEES_ARCHIVE_SIGNING_KEYS must be set when FEES_ARCHIVE_AFTER_DAYS is")
+	}
+	cfg.ArchiveSigningKeys = archiveKeys
+
+	cfg.AccrualSnapshotSchedule = defaultAccrualSnapshotSchedule
+	if v := os.Getenv("FEES_ACCRUAL_SNAPSHOT_SCHEDULE"); v != "" {
+		cfg.AccrualSnapshotSchedule = strings.TrimSpace(v)
+		if cfg.AccrualSnapshotSchedule != closeSweepOff && len(strings.Fields(cfg.AccrualSnapshotSchedule)) != 5 {
+			return nil, fmt.Errorf("invalid FEES_ACCRUAL_SNAPSHOT_SCHEDULE %q: must be a five-field cron expression or \"off\"", v)
+		}
+	}
+
+	cfg.GRPCAddr = os.Getenv("FEES_GRPC_ADDR")
+
+	if err := countFromEnv("FEES_QUOTA_BILLS_PER_MONTH", &cfg.MonthlyBillQuota); err != nil {
+		return nil, err
+	}
+	if err := countFromEnv("FEES_QUOTA_LINE_ITEMS_PER_MONTH", &cfg.MonthlyLineItemQuota); err != nil {
+		return nil, err
+	}
+
+	if err := rateLimitFromEnv("FEES_RATE_LIMIT_PER_KEY", &cfg.KeyRateLimit); err != nil {
+		return nil, err
+	}
+	if err := rateLimitFromEnv("FEES_RATE_LIMIT_PER_CUSTOMER", &cfg.CustomerRateLimit); err != nil {
+		return nil, err
+	}
+
+	fieldKeys, err := loadEncryptionKeys("FEES_FIELD_ENCRYPTION_KEYS", "field encryption keys")
+	if err != nil {
+		return nil, err
+	}
+	cfg.FieldEncryptionKeys = fieldKeys
+
+	webhookSecrets, err := loadPaymentWebhookSecrets()
+	if err != nil {
+		return nil, err
+	}
+	cfg.PaymentWebhookSecrets = webhookSecrets
+
+	temporalCfg, err := loadTemporalConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Temporal = temporalCfg
+
+	dbCfg, err := loadDBConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.DB = dbCfg
+
+	if err := countFromEnv("FEES_WORKER_MAX_CONCURRENT_ACTIVITIES", &cfg.Worker.MaxConcurrentActivities); err != nil {
+		return nil, err
+	}
+	if err := countFromEnv("FEES_WORKER_MAX_CONCURRENT_WORKFLOW_TASKS", &cfg.Worker.MaxConcurrentWorkflowTasks); err != nil {
+		return nil, err
+	}
+	if err := countFromEnv("FEES_WORKER_STICKY_CACHE_SIZE", &cfg.Worker.StickyCacheSize); err != nil {
+		return nil, err
+	}
+	cfg.Worker.Identity = os.Getenv("FEES_WORKER_IDENTITY")
+	if err := durationFromEnv("FEES_WORKER_STOP_TIMEOUT", &cfg.Worker.StopTimeout); err != nil {
+		return nil, err
+	}
+	activityPolicies, err := parseActivityPolicies(os.Getenv("FEES_ACTIVITY_POLICIES"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FEES_ACTIVITY_POLICIES: %w", err)
+	}
+	cfg.Worker.ActivityPolicies = activityPolicies
+	if err := cfg.Worker.validate(); err != nil {
+		return nil, err
+	}
+
+	taskQueues, err := loadTaskQueueConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.TaskQueues = taskQueues
+
+	if v := os.Getenv("FEES_LOG_LEVEL"); v != "" {
+		level, err := parseLogLevel(v)
+		if err != nil {
+			return nil, err
+		}
+		cfg.LogLevel = level
+	}
+
+	cfg.OTLPEndpoint = os.Getenv("FEES_OTLP_ENDPOINT")
+
+	cfg.RevenueReportMaxAge = defaultRevenueReportMaxAge
+	if err := durationFromEnv("FEES_REVENUE_REPORT_MAX_AGE", &cfg.RevenueReportMaxAge); err != nil {
+		return nil, err
+	}
+	if cfg.RevenueReportMaxAge < 0 {
+		return nil, fmt.Errorf("FEES_REVENUE_REPORT_MAX_AGE must not be negative, got %s", cfg.RevenueReportMaxAge)
+	}
+
+	cfg.EventRelayInterval = defaultEventRelayInterval
+	if err := durationFromEnv("FEES_EVENT_RELAY_INTERVAL", &cfg.EventRelayInterval); err != nil {
+		return nil, err
+	}
+	if cfg.EventRelayInterval <= 0 {
+		return nil, fmt.Errorf("FEES_EVENT_RELAY_INTERVAL must be positive, got %s", cfg.EventRelayInterval)
+	}
+	if cfg.EventWebhookURL = os.Getenv("FEES_EVENT_WEBHOOK_URL"); cfg.EventWebhookURL != "" {
+		if u, err := url.Parse(cfg.EventWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid FEES_EVENT_WEBHOOK_URL %q: must be an http or https URL", cfg.EventWebhookURL)
+		}
+		secret, err := secretFromEnv("FEES_EVENT_WEBHOOK_SECRET", "event webhook secret")
+		if err != nil {
+			return nil, err
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("FEES_EVENT_WEBHOOK_SECRET must be set with FEES_EVENT_WEBHOOK_URL")
+		}
+		cfg.EventWebhookSecret = secret
+	}
+
+	emailCfg, err := loadEmailConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Email = emailCfg
+
+	opsCfg, err := loadOpsConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Ops = opsCfg
+
+	return cfg, nil
+}
+
+// durationFromEnv parses the named environment variable into dst when it is set.
+func durationFromEnv(name string, dst *time.Duration) error {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, v, err)
+	}
+	*dst = d
+	return nil
+}
+
+// countFromEnv parses the named environment variable into dst as a non-negative count when it is set.
+func countFromEnv(name string, dst *int) error {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid %s %q: must be a non-negative integer", name, v)
+	}
+	*dst = n
+	return nil
+}
+
+// rateLimitFromEnv parses the named environment variable into dst when it is set. The burst
+// defaults to one second's worth of requests.
+func rateLimitFromEnv(name string, dst *RateLimit) error {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return nil
+	}
+	limit, err := parseRateLimit(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, v, err)
+	}
+	*dst = limit
+	return nil
+}
+
+// parseRateLimit parses "<per second>" or "<per second>:<burst>".
+func parseRateLimit(v string) (RateLimit, error) {
+	ratePart, burstPart, hasBurst := strings.Cut(v, ":")
+	perSecond, err := strconv.ParseFloat(strings.TrimSpace(ratePart), 64)
+	if err != nil || perSecond <= 0 || math.IsInf(perSecond, 0) {
+		return RateLimit{}, fmt.Errorf("rate must be a positive number of requests per second")
+	}
+	burst := max(1, int(math.Ceil(perSecond)))
+	if hasBurst {
+		burst, err = strconv.Atoi(strings.TrimSpace(burstPart))
+		if err != nil || burst < 1 {
+			return RateLimit{}, fmt.Errorf("burst must be a positive integer")
+		}
+	}
+	return RateLimit{PerSecond: perSecond, Burst: burst}, nil
+}
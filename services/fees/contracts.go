@@ -0,0 +1,217 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"encore.app/apierr"
+	"encore.dev/storage/sqldb"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultCommitmentDescription is the text of the line item billing a commitment,
+	// unless the contract gives its own.
+	defaultCommitmentDescription = "Committed use"
+	// maxCommitmentDescriptionLength caps the length of a contract's description.
+	maxCommitmentDescriptionLength = 200
+)
+
+// Contract is a customer's committed-use contract in one currency. Each of the
+// customer's bills in the currency bills CommittedAmount up front, and the bill's usage
+// draws it down; only usage beyond it is billed as it arrives.
+type Contract struct {
+	CommittedAmount float64 `json:"committedAmount"`
+	// Description is the text of the line item billing the commitment. Defaults to
+	// "Committed use".
+	Description string `json:"description,omitempty"`
+}
+
+// Commitment is the committed amount a bill billed up front under its customer's
+// contract, and how much of it the bill's usage has drawn down.
+type Commitment struct {
+	Amount float64 `json:"amount"`
+	// LineItemID is the item billing the commitment.
+	LineItemID string  `json:"lineItemId"`
+	DrawnDown  float64 `json:"drawnDown"`
+	Remaining  float64 `json:"remaining"`
+}
+
+// normalize validates the contract.
+func (c *Contract) normalize() error {
+	if math.IsNaN(c.CommittedAmount) || math.IsInf(c.CommittedAmount, 0) || c.CommittedAmount <= 0 {
+		return apierr.InvalidArgument(apierr.InvalidAmount, "committedAmount must be a positive number, got %v", c.CommittedAmount)
+	}
+	if len(c.Description) > maxCommitmentDescriptionLength {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "description must be at most %d characters", maxCommitmentDescriptionLength)
+	}
+	return nil
+}
+
+// description returns the text of the line item billing the contract's commitment.
+func (c *Contract) description() string {
+	if c.Description == "" {
+		return defaultCommitmentDescription
+	}
+	return c.Description
+}
+
+// commitmentLineItemID derives the ID of the item billing a bill's commitment, so a
+// replayed start adds the same item.
+func commitmentLineItemID(billID string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte("feems/commitment/"+billID)).String()
+}
+
+// drawsDown reports whether an item of itemType sent with signal is usage the bill's
+// commitment covers: a charge sent through the API or rolled up from a sub-bill, or the
+// repricing of rated usage. The service's own charges, and items forwarded from an
+// earlier period's bill, are not.
+func (b *Bill) drawsDown(signal AddLineItemSignal, itemType LineItemType) bool {
+	if b.Commitment == nil || signal.NotUsage || signal.RoutedFrom != nil {
+		return false
+	}
+	return itemType == LineItemCharge || signal.Metric != ""
+}
+
+// overage splits amount, the rounded amount of an item the commitment covers, into the
+// part still billed and the part drawn from the commitment. A negative amount, from
+// usage repricing, gives back what was drawn before billing the customer less.
+func (b *Bill) overage(amount float64) (overage, drawdown float64) {
+	c := b.Commitment
+	if amount > 0 {
+		drawdown = min(amount, c.Remaining)
+	} else {
+		drawdown = -min(-amount, c.DrawnDown)
+	}
+	return b.round(amount - drawdown), drawdown
+}
+
+// refreshCommitment recomputes how much of the bill's commitment its items have drawn
+// down, after items were added or removed.
+func (b *Bill) refreshCommitment() {
+	c := b.Commitment
+	if c == nil {
+		return
+	}
+	var drawn float64
+	for _, item := range b.LineItems {
+		drawn += item.Drawdown
+	}
+	c.DrawnDown = b.round(drawn)
+	c.Remaining = b.round(c.Amount - c.DrawnDown)
+}
+
+// ------ Workflow ------
+
+// billCommitment adds the line item billing the contract's commitment to a new bill,
+// and starts drawing it down. A commitment the bill refuses, such as one above its hard
+// cap, is not drawn down.
+func (w *billWorkflow) billCommitment(contract *Contract) {
+	bill := w.bill
+	id := commitmentLineItemID(bill.ID)
+	w.addLineItem(AddLineItemSignal{
+		LineItemID:     id,
+		Description:    contract.description(),
+		Amount:         contract.CommittedAmount,
+		CreatedByKeyID: w.params.CreatedByKeyID,
+		NotUsage:       true,
+		Commitment:     true,
+	})
+	item := bill.lineItem(id, "")
+	if item == nil {
+		w.logger.Warn("Commitment line item refused, usage is billed in full", "bill_id", bill.ID, "committed_amount", contract.CommittedAmount)
+		return
+	}
+	bill.Commitment = &Commitment{Amount: item.Amount, LineItemID: id}
+	bill.refreshCommitment()
+}
+
+// ------ Customer contracts ------
+
+// loadContract returns the customer's contract for bills in currency, or nil if none
+// is set.
+func loadContract(ctx context.Context, db *tracedDB, tenantID, customerID, currency string) (*Contract, error) {
+	var c Contract
+	err := db.QueryRow(ctx, `
+        SELECT committed_amount::float8, COALESCE(description, '')
+        FROM customer_contracts
+        WHERE tenant_id = $3 AND customer_id = $1 AND currency = $2
+    `, customerID, currency, tenantOrDefault(tenantID)).Scan(&c.CommittedAmount, &c.Description)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load contract for customer %s: %w", customerID, err)
+	}
+	return &c, nil
+}
+
+// ------ API ------
+
+// SetContractRequest is the request payload for setting a customer's contract.
+type SetContractRequest struct {
+	CustomerSettingsRequest
+	Contract
+}
+
+// ContractResponse reports a customer's contract in one currency.
+type ContractResponse struct {
+	RetryMetadata
+	CustomerID string `json:"customerId"`
+	Currency   string `json:"currency"`
+	// Contract is absent when the customer has no contract in the currency.
+	Contract *Contract `json:"contract,omitempty"`
+}
+
+// SetContract sets a customer's committed-use contract in one currency. It applies to
+// bills and subscriptions created afterwards; a subscription keeps the contract it
+// started with for all its periods, as it does the customer's bill limits. It is
+// private so it can only be called by internal admin tooling.
+//
+// encore:api private method=PUT path=/admin/customers/:customerID/contracts/:currency
+func (s *Service) SetContract(ctx context.Context, customerID string, currency string, params *SetContractRequest) (*ContractResponse, error) {
+	if !validCurrency(currency) {
+		return nil, apierr.InvalidArgument(apierr.InvalidCurrency, "invalid currency %q: must be a three-letter ISO 4217 code such as \"USD\"", currency)
+	}
+	if err := params.normalize(); err != nil {
+		return nil, err
+	}
+	_, err := s.db.Exec(ctx, `
+        INSERT INTO customer_contracts (tenant_id, customer_id, currency, committed_amount, description, updated_at)
+        VALUES ($5, $1, $2, $3, $4, NOW())
+        ON CONFLICT (tenant_id, customer_id, currency) DO UPDATE
+        SET committed_amount = EXCLUDED.committed_amount, description = EXCLUDED.description,
+            updated_at = EXCLUDED.updated_at
+    `, customerID, currency, params.CommittedAmount, nullIfEmpty(params.Description), tenantOrDefault(params.TenantID))
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to save contract for customer %s", customerID)
+	}
+	return s.GetContract(ctx, customerID, currency, &params.CustomerSettingsRequest)
+}
+
+// GetContract returns a customer's committed-use contract in one currency.
+//
+// encore:api private method=GET path=/admin/customers/:customerID/contracts/:currency
+func (s *Service) GetContract(ctx context.Context, customerID string, currency string, params *CustomerSettingsRequest) (*ContractResponse, error) {
+	contract, err := loadContract(ctx, s.db, params.TenantID, customerID, currency)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load contract for customer %s", customerID)
+	}
+	return &ContractResponse{CustomerID: customerID, Currency: currency, Contract: contract}, nil
+}
+
+// ClearContract removes a customer's committed-use contract in one currency. Bills
+// already created keep their commitment.
+//
+// encore:api private method=DELETE path=/admin/customers/:customerID/contracts/:currency
+func (s *Service) ClearContract(ctx context.Context, customerID string, currency string, params *CustomerSettingsRequest) (*ContractResponse, error) {
+	_, err := s.db.Exec(ctx, `
+        DELETE FROM customer_contracts WHERE tenant_id = $3 AND customer_id = $1 AND currency = $2
+    `, customerID, currency, tenantOrDefault(params.TenantID))
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to clear contract for customer %s", customerID)
+	}
+	return &ContractResponse{CustomerID: customerID, Currency: currency}, nil
+}
@@ -0,0 +1,56 @@
+package fees
+
+import (
+	"testing"
+
+	"encore.app/apierr"
+	"github.com/stretchr/testify/require"
+)
+
+// TestContractNormalize tests that a contract needs a positive commitment.
+func TestContractNormalize(t *testing.T) {
+	require.NoError(t, (&Contract{CommittedAmount: 500}).normalize())
+	require.Equal(t, defaultCommitmentDescription, (&Contract{CommittedAmount: 500}).description())
+
+	for _, c := range []Contract{{}, {CommittedAmount: -1}} {
+		require.Equal(t, apierr.InvalidAmount, apierr.ReasonOf(c.normalize()), "%+v", c)
+	}
+}
+
+// TestBillDrawsDown tests which items a bill's commitment covers.
+func TestBillDrawsDown(t *testing.T) {
+	bill := &Bill{Commitment: &Commitment{Amount: 100, Remaining: 100}}
+	require.True(t, bill.drawsDown(AddLineItemSignal{}, LineItemCharge))
+	require.True(t, bill.drawsDown(AddLineItemSignal{SubBillID: "sub-1"}, LineItemCharge))
+	require.True(t, bill.drawsDown(AddLineItemSignal{Metric: "api_calls"}, LineItemAdjustment))
+	require.False(t, bill.drawsDown(AddLineItemSignal{NotUsage: true}, LineItemCharge))
+	require.False(t, bill.drawsDown(AddLineItemSignal{RoutedFrom: &RoutedFrom{BillID: "b0"}}, LineItemCharge))
+	require.False(t, bill.drawsDown(AddLineItemSignal{}, LineItemCredit))
+	require.False(t, (&Bill{}).drawsDown(AddLineItemSignal{}, LineItemCharge))
+}
+
+// TestBillOverage tests that usage draws the commitment down until it is used up, and
+// that usage repricing gives back what was drawn.
+func TestBillOverage(t *testing.T) {
+	bill := &Bill{Commitment: &Commitment{Amount: 100, LineItemID: "commit"}, LineItems: []LineItem{{ID: "commit", Amount: 100}}}
+	bill.refreshCommitment()
+	require.Equal(t, 100.0, bill.Commitment.Remaining)
+
+	add := func(amount float64) (float64, float64) {
+		overage, drawdown := bill.overage(amount)
+		bill.LineItems = append(bill.LineItems, LineItem{Amount: overage, Drawdown: drawdown})
+		bill.refreshCommitment()
+		return overage, drawdown
+	}
+
+	overage, drawdown := add(60)
+	require.Equal(t, []float64{0, 60}, []float64{overage, drawdown})
+	overage, drawdown = add(50.5)
+	require.Equal(t, []float64{10.5, 40}, []float64{overage, drawdown})
+	require.Equal(t, &Commitment{Amount: 100, LineItemID: "commit", DrawnDown: 100, Remaining: 0}, bill.Commitment)
+
+	overage, drawdown = add(-30)
+	require.Equal(t, []float64{0, -30}, []float64{overage, drawdown})
+	require.Equal(t, 30.0, bill.Commitment.Remaining)
+	require.Equal(t, 110.5, bill.lineItemTotal())
+}
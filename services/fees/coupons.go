@@ -0,0 +1,700 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/beta/errs"
+	"encore.dev/storage/sqldb"
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/workflow"
+)
+
+// ReasonCodeCoupon is the credit taking a coupon's discount off a closing bill. It is
+// reserved, like the reason codes in line_item_reasons.go.
+const ReasonCodeCoupon = "coupon"
+
+const (
+	// maxCouponCodeLength caps the length of a coupon code.
+	maxCouponCodeLength = 64
+	// maxCouponCustomers caps the customers a coupon can be restricted to.
+	maxCouponCustomers = 1000
+	// maxBillCoupons caps the coupons applied to one bill.
+	maxBillCoupons = 5
+
+	// couponWaitTimeout bounds how long ApplyCoupon waits for the bill to apply the coupon.
+	couponWaitTimeout = 10 * time.Second
+	// couponPollInterval is the pause between ApplyCoupon's wait queries.
+	couponPollInterval = 100 * time.Millisecond
+)
+
+// couponCodePattern is the form of a coupon code, such as "SPRING-25". Codes are
+// matched case-insensitively and stored uppercase.
+var couponCodePattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9_-]*$`)
+
+// CouponKind is how a coupon discounts a bill.
+type CouponKind string
+
+const (
+	// CouponKindPercentage takes a percentage off the bill's total.
+	CouponKindPercentage CouponKind = "percentage"
+	// CouponKindFixed takes a fixed amount off the total of bills in one currency.
+	CouponKindFixed CouponKind = "fixed"
+)
+
+// Coupon is a discount customers redeem by code, taken off a bill's total when it
+// closes.
+type Coupon struct {
+	Code string     `json:"code"`
+	Name string     `json:"name,omitempty"`
+	Kind CouponKind `json:"kind"`
+	// PercentOff is set on percentage coupons, AmountOff and Currency on fixed ones.
+	PercentOff float64 `json:"percentOff,omitempty"`
+	AmountOff  float64 `json:"amountOff,omitempty"`
+	Currency   string  `json:"currency,omitempty"`
+	// MaxRedemptions caps how many bills the coupon can be applied to; 0 allows any
+	// number. Redemptions counts the bills it was applied to.
+	MaxRedemptions int `json:"maxRedemptions,omitempty"`
+	Redemptions    int `json:"redemptions"`
+	// ExpiresAt, when set, is when the coupon can no longer be applied. Bills it was
+	// applied to before keep their discount.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// CustomerIDs restricts the coupon to bills of these customers; empty allows any.
+	CustomerIDs []string  `json:"customerIds,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// CouponRequest is the request payload for creating or replacing a coupon.
+type CouponRequest struct {
+	CouponTenantRequest
+	// Code is required when creating a coupon, and ignored when replacing one.
+	Code           string     `json:"code,omitempty"`
+	Name           string     `json:"name,omitempty"`
+	Kind           CouponKind `json:"kind"`
+	PercentOff     float64    `json:"percentOff,omitempty"`
+	AmountOff      float64    `json:"amountOff,omitempty"`
+	Currency       string     `json:"currency,omitempty"`
+	MaxRedemptions int        `json:"maxRedemptions,omitempty"`
+	ExpiresAt      *time.Time `json:"expiresAt,omitempty"`
+	CustomerIDs    []string   `json:"customerIds,omitempty"`
+}
+
+// CouponTenantRequest names the tenant whose coupons an admin endpoint manages.
+type CouponTenantRequest struct {
+	// TenantID is the platform the coupon belongs to; only its bills can redeem it.
+	// Defaults to the default tenant.
+	TenantID string `header:"X-Tenant-ID"`
+}
+
+// normalizeCouponCode returns code as coupons are stored: trimmed and uppercase.
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// validCouponCode reports whether code, once normalized, can name a coupon.
+func validCouponCode(code string) bool {
+	return len(code) <= maxCouponCodeLength && couponCodePattern.MatchString(code)
+}
+
+// validate rejects coupons that could not discount a bill.
+func (r *CouponRequest) validate() error {
+	switch r.Kind {
+	case CouponKindPercentage:
+		if math.IsNaN(r.PercentOff) || r.PercentOff <= 0 || r.PercentOff > 100 {
+			return apierr.InvalidArgument(apierr.InvalidAmount, "percentOff must be above 0 and at most 100, got %v", r.PercentOff)
+		}
+		if r.AmountOff != 0 || r.Currency != "" {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "a percentage coupon cannot set amountOff or currency")
+		}
+	case CouponKindFixed:
+		if math.IsNaN(r.AmountOff) || math.IsInf(r.AmountOff, 0) || r.AmountOff <= 0 {
+			return apierr.InvalidArgument(apierr.InvalidAmount, "amountOff must be a positive number, got %v", r.AmountOff)
+		}
+		if !validCurrency(r.Currency) {
+			return apierr.InvalidArgument(apierr.InvalidCurrency, "invalid currency %q: must be a three-letter ISO 4217 code such as \"USD\"", r.Currency)
+		}
+		if r.PercentOff != 0 {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "a fixed coupon cannot set percentOff")
+		}
+	default:
+		return apierr.InvalidArgument(apierr.InvalidParameter, "invalid kind %q: must be %q or %q", r.Kind, CouponKindPercentage, CouponKindFixed)
+	}
+	if r.MaxRedemptions < 0 {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "maxRedemptions cannot be negative, got %d", r.MaxRedemptions)
+	}
+	if len(r.CustomerIDs) > maxCouponCustomers {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "a coupon can be restricted to at most %d customers", maxCouponCustomers)
+	}
+	for i, id := range r.CustomerIDs {
+		if id == "" {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "customerIds[%d] is empty", i)
+		}
+	}
+	return nil
+}
+
+// check reports why the coupon cannot be applied at now to a bill of customerID in
+// currency, or nil if it can.
+func (c *Coupon) check(customerID, currency string, now time.Time) error {
+	if c.ExpiresAt != nil && !now.Before(*c.ExpiresAt) {
+		return apierr.FailedPrecondition(apierr.CouponExpired, "coupon %s expired at %s", c.Code, c.ExpiresAt.Format(time.RFC3339))
+	}
+	if c.MaxRedemptions > 0 && c.Redemptions >= c.MaxRedemptions {
+		return apierr.FailedPrecondition(apierr.CouponExhausted, "coupon %s has already been redeemed %d times, its maximum", c.Code, c.MaxRedemptions)
+	}
+	if len(c.CustomerIDs) > 0 && !slices.Contains(c.CustomerIDs, customerID) {
+		return apierr.FailedPrecondition(apierr.CouponNotApplicable, "coupon %s is not available to customer %q", c.Code, customerID)
+	}
+	if c.Kind == CouponKindFixed && c.Currency != currency {
+		return apierr.FailedPrecondition(apierr.CouponNotApplicable, "coupon %s is for %s bills, not %s", c.Code, c.Currency, currency)
+	}
+	return nil
+}
+
+// applied returns the coupon as a bill holds it.
+func (c *Coupon) applied() AppliedCoupon {
+	return AppliedCoupon{Code: c.Code, Kind: c.Kind, PercentOff: c.PercentOff, AmountOff: c.AmountOff}
+}
+
+// AppliedCoupon is a coupon applied to a bill. Its discount is taken off when the bill
+// closes.
+type AppliedCoupon struct {
+	Code       string     `json:"code"`
+	Kind       CouponKind `json:"kind"`
+	PercentOff float64    `json:"percentOff,omitempty"`
+	AmountOff  float64    `json:"amountOff,omitempty"`
+	// Amount and LineItemID are set once the bill closed, to the discount taken off
+	// and the credit taking it off. A bill that totals nothing takes no discount.
+	Amount     float64 `json:"amount,omitempty"`
+	LineItemID string  `json:"lineItemId,omitempty"`
+}
+
+// coupon returns the coupon with code applied to the bill, or nil.
+func (b *Bill) coupon(code string) *AppliedCoupon {
+	for i := range b.Coupons {
+		if b.Coupons[i].Code == code {
+			return &b.Coupons[i]
+		}
+	}
+	return nil
+}
+
+// couponDiscount returns what c takes off a bill totalling total, rounded by the
+// bill's rounding policy. It never takes the total below zero.
+func (b *Bill) couponDiscount(c AppliedCoupon, total float64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	amount := b.round(c.AmountOff)
+	if c.Kind == CouponKindPercentage {
+		off := new(big.Rat).Mul(decimalRat(total), decimalRat(c.PercentOff))
+		amount = b.round(roundedAmount(off.Quo(off, big.NewRat(100, 1))))
+	}
+	return min(amount, total)
+}
+
+// discounted returns total less the discounts of the bill's coupons, each taken off
+// what the coupons before it left.
+func (b *Bill) discounted(total float64) float64 {
+	for _, c := range b.Coupons {
+		total = b.round(total - b.couponDiscount(c, total))
+	}
+	return total
+}
+
+// couponLineItemID derives the ID of the credit taking a coupon off a bill, so a
+// replayed or retried close reuses the same item.
+func couponLineItemID(billID, code string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte("feems/coupon/"+billID+"/"+code)).String()
+}
+
+// couponDescription describes the credit taking a coupon off a bill.
+func couponDescription(code string) string {
+	return "Coupon " + code
+}
+
+// ------ Workflow ------
+
+// ApplyCouponSignal applies a redeemed coupon to an open bill.
+type ApplyCouponSignal struct {
+	Coupon           AppliedCoupon
+	RequestedByKeyID string
+}
+
+// applyCoupon adds a redeemed coupon to the bill. Coupons applied once the bill stopped
+// taking line items, or applied twice, are ignored.
+func (w *billWorkflow) applyCoupon(signal ApplyCouponSignal) {
+	logger, bill := w.logger, w.bill
+	if !bill.acceptsLineItems() {
+		logger.Warn("ApplyCouponSignal received for a bill no longer taking line items, ignoring.", "bill_id", bill.ID, "bill_status", bill.Status, "code", signal.Coupon.Code)
+		return
+	}
+	if bill.coupon(signal.Coupon.Code) != nil {
+		logger.Info("Coupon already applied to bill, ignoring.", "bill_id", bill.ID, "code", signal.Coupon.Code)
+		return
+	}
+	bill.Coupons = append(bill.Coupons, signal.Coupon)
+	w.touch()
+	logger.Info("Coupon applied to bill", "bill_id", bill.ID, "code", signal.Coupon.Code, "requested_by", signal.RequestedByKeyID)
+}
+
+// addCouponItems takes the discount of each of the bill's coupons off total as a credit
+// line item, and returns the discounted total. Like applied customer credit, the items
+// are always repaired if they cannot be saved, since the coupons are already redeemed.
+func (w *billWorkflow) addCouponItems(total float64) float64 {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+	for i := range bill.Coupons {
+		c := &bill.Coupons[i]
+		if c.LineItemID != "" {
+			total = bill.round(total - c.Amount)
+			continue
+		}
+		amount := bill.couponDiscount(*c, total)
+		if amount == 0 {
+			continue
+		}
+		c.Amount, c.LineItemID = amount, couponLineItemID(bill.ID, c.Code)
+		total = bill.round(total - amount)
+
+		item := LineItem{
+			ID:          c.LineItemID,
+			Description: couponDescription(c.Code),
+			Amount:      -amount,
+			Type:        LineItemCredit,
+			ReasonCode:  ReasonCodeCoupon,
+			Reference:   &LineItemReference{External: c.Code},
+		}
+		bill.LineItems = append(bill.LineItems, item)
+		logger.Info("Coupon discount taken off bill", "bill_id", bill.ID, "code", c.Code, "amount", amount)
+
+		params := SaveLineItemActivityParams{
+			LineItemID:  item.ID,
+			BillID:      bill.ID,
+			Description: item.Description,
+			Amount:      item.Amount,
+			Type:        item.Type,
+			ReasonCode:  item.ReasonCode,
+			Reference:   item.Reference,
+			CreatedAt:   workflow.Now(ctx),
+			BillVersion: bill.Version,
+		}
+		if err := workflow.ExecuteActivity(ctx, SaveLineItemActivityName, params).Get(ctx, nil); err != nil {
+			logger.Error("Failed to execute SaveLineItemActivity for coupon", "bill_id", bill.ID, "line_item_id", item.ID, "error", err)
+			w.compensateSave(params, err, true)
+		}
+	}
+	return total
+}
+
+// ------ Redemption ------
+
+const couponColumns = `code, name, kind, percent_off::float8, amount_off::float8, currency, max_redemptions, redemptions, expires_at, customer_ids, created_at, updated_at`
+
+// scanCoupon reads a row of couponColumns.
+func scanCoupon(row interface{ Scan(...any) error }) (*Coupon, error) {
+	var c Coupon
+	if err := row.Scan(&c.Code, &c.Name, &c.Kind, &c.PercentOff, &c.AmountOff, &c.Currency, &c.MaxRedemptions, &c.Redemptions, &c.ExpiresAt, &c.CustomerIDs, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// loadCoupon returns a tenant's coupon, or nil if it does not exist.
+func loadCoupon(ctx context.Context, db *tracedDB, tenantID, code string) (*Coupon, error) {
+	c, err := scanCoupon(db.QueryRow(ctx, `SELECT `+couponColumns+` FROM coupons WHERE tenant_id = $1 AND code = $2`, tenantOrDefault(tenantID), code))
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load coupon %s: %w", code, err)
+	}
+	return c, nil
+}
+
+// normalizeCouponCodes normalizes the coupon codes given for one bill, and rejects
+// invalid codes, codes given twice, and more than a bill can take.
+func normalizeCouponCodes(codes []string) error {
+	if len(codes) > maxBillCoupons {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "at most %d coupons can be applied to a bill", maxBillCoupons)
+	}
+	for i := range codes {
+		codes[i] = normalizeCouponCode(codes[i])
+		if !validCouponCode(codes[i]) {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "invalid coupon code %q: must be at most %d letters, digits, dashes and underscores", codes[i], maxCouponCodeLength)
+		}
+		if slices.Contains(codes[:i], codes[i]) {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "coupon %s is given twice", codes[i])
+		}
+	}
+	return nil
+}
+
+// checkCoupons loads the tenant's coupons of codes and checks they can be applied now
+// to a bill of customerID in currency, without redeeming them.
+func (s *Service) checkCoupons(ctx context.Context, tenantID string, codes []string, customerID, currency string) ([]AppliedCoupon, error) {
+	var applied []AppliedCoupon
+	for _, code := range codes {
+		c, err := loadCoupon(ctx, s.db, tenantID, code)
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to load coupon %s", code)
+		}
+		if c == nil {
+			return nil, apierr.NotFound(apierr.CouponNotFound, "coupon %s not found", code)
+		}
+		if err := c.check(customerID, currency, s.clock.Now()); err != nil {
+			return nil, err
+		}
+		applied = append(applied, c.applied())
+	}
+	return applied, nil
+}
+
+// redeemCoupons redeems the tenant's coupons of codes for a bill of customerID in
+// currency, all or none of them, and returns them as the bill holds them. A coupon
+// already redeemed for the bill, such as by a retried request, is not checked or
+// counted again.
+func (s *Service) redeemCoupons(ctx context.Context, tenantID, billID, customerID, currency string, codes []string) ([]AppliedCoupon, error) {
+	var applied []AppliedCoupon
+	tenantID = tenantOrDefault(tenantID)
+	now := s.clock.Now().UTC()
+	err := s.db.inTx(ctx, func(tx *tracedTx) error {
+		applied = applied[:0]
+		for _, code := range codes {
+			c, err := scanCoupon(tx.QueryRow(ctx, `SELECT `+couponColumns+` FROM coupons WHERE tenant_id = $1 AND code = $2 FOR UPDATE`, tenantID, code))
+			if errors.Is(err, sqldb.ErrNoRows) {
+				return apierr.NotFound(apierr.CouponNotFound, "coupon %s not found", code)
+			}
+			if err != nil {
+				return err
+			}
+			res, err := tx.Exec(ctx, `
+                INSERT INTO coupon_redemptions (tenant_id, coupon_code, bill_id, customer_id, redeemed_at, redeemed_by_key_id)
+                VALUES ($1, $2, $3, $4, $5, $6)
+                ON CONFLICT (tenant_id, coupon_code, bill_id) DO NOTHING
+            `, tenantID, code, billID, customerID, now, nullIfEmpty(callerKeyID(ctx)))
+			if err != nil {
+				return err
+			}
+			if res.RowsAffected() > 0 {
+				// Only new redemptions are checked; a refusal rolls the insert back.
+				if err := c.check(customerID, currency, now); err != nil {
+					return err
+				}
+				if _, err := tx.Exec(ctx, `UPDATE coupons SET redemptions = redemptions + 1 WHERE tenant_id = $1 AND code = $2`, tenantID, code); err != nil {
+					return err
+				}
+			}
+			applied = append(applied, c.applied())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to redeem coupons for bill %s", billID)
+	}
+	return applied, nil
+}
+
+// releaseCoupons gives back the coupons of codes redeemed for a bill that was not
+// created, or that never got them. It is best-effort: a failure only leaves the
+// redemptions counted.
+func (s *Service) releaseCoupons(ctx context.Context, billID string, codes []string) {
+	_, err := s.db.Exec(ctx, `
+        WITH released AS (
+            DELETE FROM coupon_redemptions WHERE bill_id = $1 AND coupon_code = ANY($2::text[])
+            RETURNING tenant_id, coupon_code
+        )
+        UPDATE coupons SET redemptions = redemptions - 1
+        WHERE (tenant_id, code) IN (SELECT tenant_id, coupon_code FROM released)
+    `, billID, codes)
+	if err != nil {
+		loggerFrom(ctx).Warn("Failed to release coupons of bill", "bill_id", billID, "codes", codes, "error", err)
+	}
+}
+
+// awaitCoupon polls the bill's workflow until it holds the coupon of code, and returns
+// the bill. A bill returned without the coupon stopped taking line items first, so it
+// dropped the coupon. It gives up after couponWaitTimeout.
+func (s *Service) awaitCoupon(ctx context.Context, billID, code string) (*Bill, error) {
+	bill, err := s.pollBillWorkflow(ctx, billID, couponWaitTimeout, couponPollInterval, func(bill *Bill) (bool, error) {
+		return bill.coupon(code) != nil || !bill.acceptsLineItems(), nil
+	})
+	switch {
+	case errors.Is(err, errPollTimeout):
+		return nil, apierr.Unavailable(apierr.CouponTimeout, "timeout waiting for coupon %s to be applied to bill %s; it may still be applied", code, billID)
+	case err != nil && ctx.Err() != nil:
+		return nil, apierr.FromContext(ctx, "coupon %s was sent to bill %s but not yet confirmed; it may still be applied", code, billID)
+	}
+	return bill, err
+}
+
+// ------ API ------
+
+// ApplyCouponRequest is the request payload for applying a coupon to a bill.
+type ApplyCouponRequest struct {
+	// Code is the coupon's code, in any case.
+	Code string `json:"code"`
+}
+
+// ApplyCouponResponse is the response payload for applying a coupon to a bill.
+type ApplyCouponResponse struct {
+	RetryMetadata
+	BillID string        `json:"billId"`
+	Coupon AppliedCoupon `json:"coupon"`
+	// Duplicate is set when the coupon was already applied to the bill; nothing changed.
+	Duplicate       bool   `json:"duplicate,omitempty"`
+	ConfirmationMsg string `json:"confirmationMsg"`
+	// StateToken identifies the bill's state once this change is applied. Pass it to
+	// GetBill as minStateToken to read the bill with the coupon.
+	StateToken string `json:"stateToken,omitempty"`
+}
+
+// ApplyCoupon redeems a coupon for a bill that still takes line items. Its discount is
+// taken off the bill's total when it closes, before the bill limits and customer
+// credit. Expired and exhausted coupons, and coupons restricted to other customers or
+// currencies, are refused. The response waits until the bill holds the coupon; if the
+// bill stops taking line items first, the coupon is given back.
+//
+// encore:api auth method=POST path=/bills/:billID/coupons
+func (s *Service) ApplyCoupon(ctx context.Context, billID string, params *ApplyCouponRequest) (*ApplyCouponResponse, error) {
+	codes := []string{params.Code}
+	if err := normalizeCouponCodes(codes); err != nil {
+		return nil, err
+	}
+	code := codes[0]
+
+	getResp, err := s.getBill(ctx, billID)
+	if err != nil {
+		return nil, err
+	}
+	bill := getResp.RetrievedBill
+	if !bill.acceptsLineItems() {
+		return nil, apierr.FailedPrecondition(apierr.BillClosed, "bill %s is %s and no longer accepts coupons", billID, bill.Status)
+	}
+	if c := bill.coupon(code); c != nil {
+		return &ApplyCouponResponse{
+			BillID:          billID,
+			Coupon:          *c,
+			Duplicate:       true,
+			ConfirmationMsg: fmt.Sprintf("Coupon %s is already applied to the bill.", code),
+			StateToken:      stateToken(bill.Version),
+		}, nil
+	}
+	if len(bill.Coupons) >= maxBillCoupons {
+		return nil, apierr.FailedPrecondition(apierr.CouponNotApplicable, "bill %s already has %d coupons, the most a bill can take", billID, maxBillCoupons)
+	}
+
+	applied, err := s.redeemCoupons(ctx, bill.TenantID, billID, bill.CustomerID, bill.Currency, codes)
+	if err != nil {
+		return nil, err
+	}
+	signal := ApplyCouponSignal{Coupon: applied[0], RequestedByKeyID: callerKeyID(ctx)}
+	err = s.temporalClient.SignalWorkflow(ctx, billWorkflowID(billID), "", ApplyCouponSignalName, signal)
+	if err != nil && ctx.Err() != nil {
+		// As for CreateBill, the signal may have been delivered all the same, so the
+		// redemption is kept; a retry confirms the coupon without redeeming it again.
+		return nil, apierr.FromContext(ctx, "coupon %s may not have been applied to bill %s", code, billID)
+	}
+	if err != nil {
+		s.releaseCoupons(ctx, billID, codes)
+		return nil, apierr.FromTemporal(err, apierr.BillNotFound, "bill %s not found", billID)
+	}
+	// A timeout keeps the redemption, since the bill may still apply the coupon; a retry
+	// then confirms it without redeeming it again.
+	updated, err := s.awaitCoupon(ctx, billID, code)
+	if err != nil {
+		return nil, err
+	}
+	c := updated.coupon(code)
+	if c == nil {
+		s.releaseCoupons(ctx, billID, codes)
+		return nil, apierr.FailedPrecondition(apierr.BillClosed, "bill %s became %s before coupon %s was applied", billID, updated.Status, code)
+	}
+	return &ApplyCouponResponse{
+		BillID:          billID,
+		Coupon:          *c,
+		ConfirmationMsg: fmt.Sprintf("Coupon %s applied.", code),
+		StateToken:      stateToken(updated.Version),
+	}, nil
+}
+
+// CouponResponse returns one coupon.
+type CouponResponse struct {
+	RetryMetadata
+	Coupon Coupon `json:"coupon"`
+}
+
+// ListCouponsResponse lists coupons by code.
+type ListCouponsResponse struct {
+	Coupons []Coupon `json:"coupons"`
+}
+
+// DeleteCouponResponse confirms a coupon was deleted.
+type DeleteCouponResponse struct {
+	RetryMetadata
+	Code string `json:"code"`
+}
+
+// CouponRedemption records a coupon applied to a bill.
+type CouponRedemption struct {
+	BillID     string    `json:"billId"`
+	CustomerID string    `json:"customerId,omitempty"`
+	RedeemedAt time.Time `json:"redeemedAt"`
+}
+
+// ListCouponRedemptionsResponse lists a coupon's redemptions, latest first.
+type ListCouponRedemptionsResponse struct {
+	Code        string             `json:"code"`
+	Redemptions []CouponRedemption `json:"redemptions"`
+}
+
+// CreateCoupon saves a new coupon. It is private so it can only be called by internal
+// admin tooling.
+//
+// encore:api private method=POST path=/admin/coupons
+func (s *Service) CreateCoupon(ctx context.Context, params *CouponRequest) (*CouponResponse, error) {
+	code := normalizeCouponCode(params.Code)
+	if !validCouponCode(code) {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "invalid coupon code %q: must be at most %d letters, digits, dashes and underscores", params.Code, maxCouponCodeLength)
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	res, err := s.db.Exec(ctx, `
+        INSERT INTO coupons (tenant_id, code, name, kind, percent_off, amount_off, currency, max_redemptions, expires_at, customer_ids, created_at, updated_at)
+        VALUES ($11, $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10)
+        ON CONFLICT (tenant_id, code) DO NOTHING
+    `, code, params.Name, params.Kind, params.PercentOff, params.AmountOff, params.Currency, params.MaxRedemptions, params.ExpiresAt, customerIDs(params.CustomerIDs), s.clock.Now().UTC(), tenantOrDefault(params.TenantID))
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to save coupon %s", code)
+	}
+	if res.RowsAffected() == 0 {
+		return nil, apierr.New(errs.AlreadyExists, apierr.CouponExists, "coupon %s already exists", code)
+	}
+	return s.GetCoupon(ctx, code, &params.CouponTenantRequest)
+}
+
+// customerIDs returns ids, or an empty list for nil, as the customer_ids column needs.
+func customerIDs(ids []string) []string {
+	if ids == nil {
+		return []string{}
+	}
+	return ids
+}
+
+// GetCoupon returns a coupon.
+//
+// encore:api private method=GET path=/admin/coupons/:code
+func (s *Service) GetCoupon(ctx context.Context, code string, params *CouponTenantRequest) (*CouponResponse, error) {
+	code = normalizeCouponCode(code)
+	c, err := loadCoupon(ctx, s.db, params.TenantID, code)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load coupon %s", code)
+	}
+	if c == nil {
+		return nil, apierr.NotFound(apierr.CouponNotFound, "coupon %s not found", code)
+	}
+	return &CouponResponse{Coupon: *c}, nil
+}
+
+// ListCoupons lists a tenant's coupons by code.
+//
+// encore:api private method=GET path=/admin/coupons
+func (s *Service) ListCoupons(ctx context.Context, params *CouponTenantRequest) (*ListCouponsResponse, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+couponColumns+` FROM coupons WHERE tenant_id = $1 ORDER BY code`, tenantOrDefault(params.TenantID))
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to list coupons")
+	}
+	defer rows.Close()
+
+	resp := &ListCouponsResponse{Coupons: []Coupon{}}
+	for rows.Next() {
+		c, err := scanCoupon(rows)
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to read coupons")
+		}
+		resp.Coupons = append(resp.Coupons, *c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read coupons")
+	}
+	return resp, nil
+}
+
+// UpdateCoupon replaces a coupon, keeping its redemptions. Bills it was already
+// applied to keep the discount they were given.
+//
+// encore:api private method=PUT path=/admin/coupons/:code
+func (s *Service) UpdateCoupon(ctx context.Context, code string, params *CouponRequest) (*CouponResponse, error) {
+	code = normalizeCouponCode(code)
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	res, err := s.db.Exec(ctx, `
+        UPDATE coupons
+        SET name = $2, kind = $3, percent_off = $4, amount_off = $5, currency = $6,
+            max_redemptions = $7, expires_at = $8, customer_ids = $9, updated_at = $10
+        WHERE tenant_id = $11 AND code = $1
+    `, code, params.Name, params.Kind, params.PercentOff, params.AmountOff, params.Currency, params.MaxRedemptions, params.ExpiresAt, customerIDs(params.CustomerIDs), s.clock.Now().UTC(), tenantOrDefault(params.TenantID))
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to update coupon %s", code)
+	}
+	if res.RowsAffected() == 0 {
+		return nil, apierr.NotFound(apierr.CouponNotFound, "coupon %s not found", code)
+	}
+	return s.GetCoupon(ctx, code, &params.CouponTenantRequest)
+}
+
+// DeleteCoupon deletes a coupon and its redemptions. Bills it was already applied to
+// keep their discount.
+//
+// encore:api private method=DELETE path=/admin/coupons/:code
+func (s *Service) DeleteCoupon(ctx context.Context, code string, params *CouponTenantRequest) (*DeleteCouponResponse, error) {
+	code = normalizeCouponCode(code)
+	_, err := s.db.Exec(ctx, `DELETE FROM coupons WHERE tenant_id = $1 AND code = $2`, tenantOrDefault(params.TenantID), code)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to delete coupon %s", code)
+	}
+	return &DeleteCouponResponse{Code: code}, nil
+}
+
+// ListCouponRedemptions lists the bills a coupon was applied to, latest first.
+//
+// encore:api private method=GET path=/admin/coupons/:code/redemptions
+func (s *Service) ListCouponRedemptions(ctx context.Context, code string, params *CouponTenantRequest) (*ListCouponRedemptionsResponse, error) {
+	code = normalizeCouponCode(code)
+	if _, err := s.GetCoupon(ctx, code, params); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(ctx, `
+        SELECT bill_id, customer_id, redeemed_at
+        FROM coupon_redemptions
+        WHERE tenant_id = $1 AND coupon_code = $2
+        ORDER BY redeemed_at DESC, bill_id
+    `, tenantOrDefault(params.TenantID), code)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to list redemptions of coupon %s", code)
+	}
+	defer rows.Close()
+
+	resp := &ListCouponRedemptionsResponse{Code: code, Redemptions: []CouponRedemption{}}
+	for rows.Next() {
+		var r CouponRedemption
+		if err := rows.Scan(&r.BillID, &r.CustomerID, &r.RedeemedAt); err != nil {
+			return nil, apierr.Wrap(err, "failed to read redemptions of coupon %s", code)
+		}
+		resp.Redemptions = append(resp.Redemptions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read redemptions of coupon %s", code)
+	}
+	return resp, nil
+}
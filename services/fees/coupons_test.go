@@ -0,0 +1,138 @@
+package fees
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"encore.app/apierr"
+	"encore.app/rounding"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCouponRequestValidate tests that a coupon discounts by a percentage up to 100,
+// or by a positive amount in one currency, and not both.
+func TestCouponRequestValidate(t *testing.T) {
+	require.NoError(t, (&CouponRequest{Kind: CouponKindPercentage, PercentOff: 100}).validate())
+	require.NoError(t, (&CouponRequest{Kind: CouponKindFixed, AmountOff: 5, Currency: "USD", MaxRedemptions: 10, CustomerIDs: []string{"cust-1"}}).validate())
+
+	for _, r := range []CouponRequest{
+		{},
+		{Kind: "bogo", PercentOff: 10},
+		{Kind: CouponKindPercentage, PercentOff: 10, Currency: "USD"},
+		{Kind: CouponKindFixed, AmountOff: 5, Currency: "USD", PercentOff: 10},
+		{Kind: CouponKindPercentage, PercentOff: 10, MaxRedemptions: -1},
+		{Kind: CouponKindPercentage, PercentOff: 10, CustomerIDs: []string{""}},
+	} {
+		require.Equal(t, apierr.InvalidParameter, apierr.ReasonOf(r.validate()), "%+v", r)
+	}
+	for _, r := range []CouponRequest{
+		{Kind: CouponKindPercentage},
+		{Kind: CouponKindPercentage, PercentOff: 100.5},
+		{Kind: CouponKindFixed, AmountOff: -5, Currency: "USD"},
+	} {
+		require.Equal(t, apierr.InvalidAmount, apierr.ReasonOf(r.validate()), "%+v", r)
+	}
+	require.Equal(t, apierr.InvalidCurrency, apierr.ReasonOf((&CouponRequest{Kind: CouponKindFixed, AmountOff: 5}).validate()))
+}
+
+// TestNormalizeCouponCodes tests that codes are matched in any case, and that a bill
+// takes each coupon once.
+func TestNormalizeCouponCodes(t *testing.T) {
+	codes := []string{" spring-25 ", "Welcome_10"}
+	require.NoError(t, normalizeCouponCodes(codes))
+	require.Equal(t, []string{"SPRING-25", "WELCOME_10"}, codes)
+
+	for _, codes := range [][]string{{""}, {"-SPRING"}, {"SPRING 25"}, {"SPRING", "spring"}, {"A", "B", "C", "D", "E", "F"}} {
+		require.Equal(t, apierr.InvalidParameter, apierr.ReasonOf(normalizeCouponCodes(codes)), "%v", codes)
+	}
+}
+
+// TestCouponCheck tests that expired and exhausted coupons, and coupons for other
+// customers or currencies, are refused.
+func TestCouponCheck(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	expires := now.Add(time.Hour)
+	c := &Coupon{Code: "SPRING", Kind: CouponKindFixed, AmountOff: 5, Currency: "USD", MaxRedemptions: 2, Redemptions: 1, ExpiresAt: &expires, CustomerIDs: []string{"cust-1"}}
+	require.NoError(t, c.check("cust-1", "USD", now))
+
+	require.Equal(t, apierr.CouponExpired, apierr.ReasonOf(c.check("cust-1", "USD", expires)))
+	require.Equal(t, apierr.CouponNotApplicable, apierr.ReasonOf(c.check("cust-2", "USD", now)))
+	require.Equal(t, apierr.CouponNotApplicable, apierr.ReasonOf(c.check("cust-1", "EUR", now)))
+	c.Redemptions = 2
+	require.Equal(t, apierr.CouponExhausted, apierr.ReasonOf(c.check("cust-1", "USD", now)))
+}
+
+// TestBillDiscounted tests that each coupon is taken off what the ones before it left,
+// rounded, and never below zero.
+func TestBillDiscounted(t *testing.T) {
+	policy := rounding.Policy{Mode: rounding.HalfUp, Decimals: 2}
+	bill := &Bill{Currency: "USD", Rounding: &policy, Coupons: []AppliedCoupon{
+		{Code: "THIRD", Kind: CouponKindPercentage, PercentOff: 33.333},
+		{Code: "FLAT5", Kind: CouponKindFixed, AmountOff: 5},
+	}}
+	require.Equal(t, 61.67, bill.discounted(100))
+	require.Equal(t, 0.0, bill.discounted(6))
+	require.Equal(t, -10.0, bill.discounted(-10))
+	require.Equal(t, 4.0, bill.couponDiscount(AppliedCoupon{Kind: CouponKindFixed, AmountOff: 5}, 4))
+}
+
+// TestAwaitCoupon tests that ApplyCoupon re-queries the bill until it holds the coupon,
+// and stops once the bill no longer takes line items, so the coupon can be given back.
+func TestAwaitCoupon(t *testing.T) {
+	applied := AppliedCoupon{Code: "SPRING-25", Kind: CouponKindPercentage, PercentOff: 25}
+
+	t.Run("applied", func(t *testing.T) {
+		svc, tc, clock := newClockedService(t)
+		tc.On("QueryWorkflow", mock.Anything, "bill-b1", "", GetBillDetailsQueryName).
+			Return(encodedBill{Bill{ID: "b1", Status: BillStatusOpen, Version: 3}}, nil).Once()
+		tc.On("QueryWorkflow", mock.Anything, "bill-b1", "", GetBillDetailsQueryName).
+			Return(encodedBill{Bill{ID: "b1", Status: BillStatusOpen, Version: 4, Coupons: []AppliedCoupon{applied}}}, nil).Once()
+
+		type result struct {
+			bill *Bill
+			err  error
+		}
+		done := make(chan result)
+		go func() {
+			bill, err := svc.awaitCoupon(context.Background(), "b1", applied.Code)
+			done <- result{bill, err}
+		}()
+
+		// The overall timeout and the first poll interval are pending.
+		clock.BlockUntil(2)
+		clock.Advance(couponPollInterval)
+
+		res := <-done
+		require.NoError(t, res.err)
+		require.NotNil(t, res.bill.coupon(applied.Code))
+		require.EqualValues(t, 4, res.bill.Version)
+	})
+
+	t.Run("bill closed first", func(t *testing.T) {
+		svc, tc, _ := newClockedService(t)
+		tc.On("QueryWorkflow", mock.Anything, "bill-b1", "", GetBillDetailsQueryName).
+			Return(encodedBill{Bill{ID: "b1", Status: BillStatusClosed}}, nil).Once()
+
+		bill, err := svc.awaitCoupon(context.Background(), "b1", applied.Code)
+		require.NoError(t, err)
+		require.Nil(t, bill.coupon(applied.Code))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		svc, tc, clock := newClockedService(t)
+		tc.On("QueryWorkflow", mock.Anything, "bill-b1", "", GetBillDetailsQueryName).
+			Return(encodedBill{Bill{ID: "b1", Status: BillStatusClosing}}, nil)
+
+		done := make(chan error)
+		go func() {
+			_, err := svc.awaitCoupon(context.Background(), "b1", applied.Code)
+			done <- err
+		}()
+
+		clock.BlockUntil(2)
+		clock.Advance(couponWaitTimeout)
+		require.Equal(t, apierr.CouponTimeout, apierr.ReasonOf(<-done))
+	})
+}
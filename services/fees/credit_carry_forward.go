@@ -0,0 +1,101 @@
+package fees
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/workflow"
+)
+
+// CarryForwardCreditActivityName adds the part of a credit note beyond its bill's
+// refundable amount to the customer's credit balance.
+const CarryForwardCreditActivityName = "CarryForwardCreditActivity"
+
+// carryForwardEntryID is the ID of the credit ledger entry carrying forward the rest of
+// a credit note, derived from it so a retried activity adds the credit once.
+func carryForwardEntryID(creditNoteID string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte("feems/carry-forward/"+creditNoteID)).String()
+}
+
+// splitCarryForward splits a credit note of amount against a bill with remaining left
+// to refund: the part refunded, and the rest carried forward to the customer's next
+// bills.
+func (b *Bill) splitCarryForward(amount, remaining float64) (refund, carry float64) {
+	if amount <= remaining {
+		return amount, 0
+	}
+	return remaining, b.round(amount - remaining)
+}
+
+// carryForward adds the carried-forward part of a credit note that succeeded to the
+// customer's credit balance, where the credit step of their next bill's close applies
+// it. If the credit cannot be added, the note records nothing carried forward.
+func (w *billWorkflow) carryForward(note *CreditNote, amount float64) {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	err := workflow.ExecuteActivity(ctx, CarryForwardCreditActivityName, CarryForwardCreditActivityParams{
+		EntryID:      carryForwardEntryID(note.ID),
+		BillID:       bill.ID,
+		CreditNoteID: note.ID,
+		TenantID:     bill.TenantID,
+		CustomerID:   bill.CustomerID,
+		Currency:     bill.Currency,
+		Amount:       amount,
+		CreatedAt:    workflow.Now(ctx),
+	}).Get(ctx, nil)
+	if err != nil {
+		logger.Error("Failed to execute CarryForwardCreditActivity", "bill_id", bill.ID, "credit_note_id", note.ID, "amount", amount, "error", err)
+		return
+	}
+	note.CarriedForward = amount
+	logger.Info("Credit note remainder carried forward", "bill_id", bill.ID, "credit_note_id", note.ID, "customer_id", bill.CustomerID, "amount", amount)
+}
+
+// CarryForwardCreditActivityParams defines parameters for CarryForwardCreditActivity.
+type CarryForwardCreditActivityParams struct {
+	EntryID      string
+	BillID       string
+	CreditNoteID string
+	TenantID     string
+	CustomerID   string
+	Currency     string
+	Amount       float64
+	CreatedAt    time.Time
+}
+
+// CarryForwardCreditActivity adds a credit note's remainder to the customer's credit
+// balance, recording it in the credit ledger against the bill it came from.
+func (a *Activities) CarryForwardCreditActivity(ctx context.Context, params CarryForwardCreditActivityParams) error {
+	tenantID := tenantOrDefault(params.TenantID)
+	err := a.DB.inTx(ctx, func(tx *tracedTx) error {
+		// The entry is inserted first, so a retry neither fails nor adds credit twice.
+		result, err := tx.Exec(ctx, `
+            INSERT INTO customer_credit_entries (id, tenant_id, customer_id, currency, amount, bill_id, description, created_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+            ON CONFLICT (id) DO NOTHING
+        `, params.EntryID, tenantID, params.CustomerID, params.Currency, params.Amount, params.BillID,
+			fmt.Sprintf("Carried forward from credit note %s", params.CreditNoteID), params.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to record credit entry %s: %w", params.EntryID, err)
+		}
+		if result.RowsAffected() == 0 {
+			return nil
+		}
+		_, err = tx.Exec(ctx, `
+            INSERT INTO customer_credit_balances (tenant_id, customer_id, currency, balance, updated_at)
+            VALUES ($1, $2, $3, $4, $5)
+            ON CONFLICT (tenant_id, customer_id, currency) DO UPDATE
+            SET balance = customer_credit_balances.balance + EXCLUDED.balance, updated_at = EXCLUDED.updated_at
+        `, tenantID, params.CustomerID, params.Currency, params.Amount, params.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to credit customer %s: %w", params.CustomerID, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("CarryForwardCreditActivity: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package fees
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestSplitCarryForward tests that only the part of a credit note beyond the
+// refundable amount is carried forward, rounded like the bill.
+func TestSplitCarryForward(t *testing.T) {
+	bill := &Bill{Currency: "USD"}
+
+	refund, carry := bill.splitCarryForward(40, 100)
+	require.True(t, refund == 40)
+	require.True(t, carry == 0)
+
+	refund, carry = bill.splitCarryForward(100, 100)
+	require.True(t, refund == 100)
+	require.True(t, carry == 0)
+
+	refund, carry = bill.splitCarryForward(150.1, 100.05)
+	require.True(t, refund == 100.05)
+	require.True(t, carry == 50.05)
+}
+
+// TestCarryForwardEntryID tests that a credit note carries forward under one ledger
+// entry, distinct from other credit notes'.
+func TestCarryForwardEntryID(t *testing.T) {
+	require.Equal(t, carryForwardEntryID("cn-1"), carryForwardEntryID("cn-1"))
+	require.NotEqual(t, carryForwardEntryID("cn-1"), carryForwardEntryID("cn-2"))
+	require.NotEqual(t, carryForwardEntryID("cn-1"), subBillLineItemID("cn-1"))
+}
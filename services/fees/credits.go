@@ -0,0 +1,394 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"encore.app/apierr"
+	"encore.app/rounding"
+	"encore.dev/storage/sqldb"
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// ApplyCreditActivityName reserves a customer's credit for a closing bill.
+	ApplyCreditActivityName = "ApplyCreditActivity"
+
+	// creditLineItemDescription describes the line item of credit applied to a bill.
+	creditLineItemDescription = "Credit applied"
+	// maxCreditEntries caps the ledger entries returned with a customer's balances.
+	maxCreditEntries = 100
+	// unroundedDecimals are the decimals amounts of bills without a rounding policy keep.
+	unroundedDecimals = 4
+)
+
+// CreditBalance is a customer's available credit in one currency.
+type CreditBalance struct {
+	TenantID  string    `json:"tenantId"`
+	Currency  string    `json:"currency"`
+	Balance   float64   `json:"balance"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Frozen balances are not applied to bills while one of the customer's payments in
+	// the currency is disputed.
+	Frozen bool `json:"frozen,omitempty"`
+}
+
+// CreditEntry is a change to a customer's credit balance: a grant, or credit applied
+// to a bill.
+type CreditEntry struct {
+	ID       string `json:"id"`
+	TenantID string `json:"tenantId"`
+	Currency string `json:"currency"`
+	// Amount is positive for grants and negative for credit applied to a bill.
+	Amount float64 `json:"amount"`
+	// BillID is the bill the credit was applied to, or the bill whose credit note it
+	// was carried forward from.
+	BillID      string `json:"billId,omitempty"`
+	Description string `json:"description,omitempty"`
+	// CreatedByKeyID is the API key that granted the credit.
+	CreatedByKeyID string    `json:"createdByKeyId,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// AppliedCredit records the customer credit applied to a bill when it closed.
+type AppliedCredit struct {
+	// Amount is the credit taken from the customer's balance, as a positive amount.
+	Amount float64 `json:"amount"`
+	// LineItemID is the negative line item that deducts Amount from the bill.
+	LineItemID string `json:"lineItemId"`
+}
+
+// GrantCreditRequest is the request payload for granting credit to a customer.
+type GrantCreditRequest struct {
+	// TenantID identifies the platform the customer belongs to. Tenant-scoped API keys
+	// always grant credit in their own tenant.
+	TenantID    string  `header:"X-Tenant-ID"`
+	Currency    string  `json:"currency"`
+	Amount      float64 `json:"amount"`
+	Description string  `json:"description,omitempty"`
+}
+
+// GrantCreditResponse is the response payload for a credit grant.
+type GrantCreditResponse struct {
+	RetryMetadata
+	Entry   CreditEntry   `json:"entry"`
+	Balance CreditBalance `json:"balance"`
+}
+
+// CreditBalancesParams filters a customer's credit balances.
+type CreditBalancesParams struct {
+	// Currency limits the balances and entries to one currency.
+	Currency string `query:"currency"`
+}
+
+// CreditBalancesResponse is the response payload for a customer's credit balances.
+type CreditBalancesResponse struct {
+	CustomerID string          `json:"customerId"`
+	Balances   []CreditBalance `json:"balances"`
+	// Entries holds the most recent changes to the balances, newest first.
+	Entries []CreditEntry `json:"entries"`
+}
+
+// ApplyCreditActivityParams defines parameters for ApplyCreditActivity.
+type ApplyCreditActivityParams struct {
+	// EntryID identifies the ledger entry of the reservation, so a retried activity
+	// does not take credit twice.
+	EntryID    string
+	BillID     string
+	TenantID   string
+	CustomerID string
+	Currency   string
+	// Amount is the most credit to take: the bill's total.
+	Amount float64
+	// Decimals is the precision of the bill's amounts. The credit taken is rounded down
+	// to it, so it never exceeds the balance.
+	Decimals  int
+	AppliedAt time.Time
+}
+
+// ------ Bill workflow ------
+
+// creditLineItemID is the ID of the line item of credit applied to a bill, and of the
+// ledger entry that reserved it. It is derived from the bill so a replayed or retried
+// close reuses both.
+func creditLineItemID(billID string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte("feems/credit/"+billID)).String()
+}
+
+// applyCredit reserves the customer's available credit, up to total, and deducts it
+// from the bill with a negative line item. If the credit cannot be reserved, the bill
+// closes without it and the credit stays available for a later bill. Test bills never
+// use the customer's credit.
+func (w *billWorkflow) applyCredit(total float64) *AppliedCredit {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+	if !w.params.ApplyCredits || total <= 0 || bill.CustomerID == "" || bill.Test {
+		return nil
+	}
+
+	decimals := unroundedDecimals
+	if bill.Rounding != nil {
+		decimals = bill.Rounding.Decimals
+	}
+	lineItemID := creditLineItemID(bill.ID)
+	var applied float64
+	err := workflow.ExecuteActivity(ctx, ApplyCreditActivityName, ApplyCreditActivityParams{
+		EntryID:    lineItemID,
+		BillID:     bill.ID,
+		TenantID:   bill.TenantID,
+		CustomerID: bill.CustomerID,
+		Currency:   bill.Currency,
+		Amount:     total,
+		Decimals:   decimals,
+		AppliedAt:  workflow.Now(ctx),
+	}).Get(ctx, &applied)
+	if err != nil {
+		logger.Error("Failed to execute ApplyCreditActivity, closing bill without credit", "bill_id", bill.ID, "customer_id", bill.CustomerID, "error", err)
+		return nil
+	}
+	if applied <= 0 {
+		return nil
+	}
+
+	item := LineItem{ID: lineItemID, Description: creditLineItemDescription, Amount: -applied, Type: LineItemCredit, ReasonCode: ReasonCodeCustomerCredit}
+	bill.LineItems = append(bill.LineItems, item)
+	logger.Info("Customer credit applied to bill", "bill_id", bill.ID, "customer_id", bill.CustomerID, "amount", applied)
+
+	params := SaveLineItemActivityParams{
+		LineItemID:  item.ID,
+		BillID:      bill.ID,
+		Description: item.Description,
+		Amount:      item.Amount,
+		Type:        item.Type,
+		ReasonCode:  item.ReasonCode,
+		CreatedAt:   workflow.Now(ctx),
+		BillVersion: bill.Version,
+	}
+	if err := workflow.ExecuteActivity(ctx, SaveLineItemActivityName, params).Get(ctx, nil); err != nil {
+		logger.Error("Failed to execute SaveLineItemActivity for applied credit", "bill_id", bill.ID, "line_item_id", item.ID, "error", err)
+		// The credit is already taken from the balance, so the item must not be dropped.
+		w.compensateSave(params, err, true)
+	}
+	return &AppliedCredit{Amount: applied, LineItemID: lineItemID}
+}
+
+// creditToApply is the credit a balance covers of a bill totalling total, rounded down
+// to decimals so it never exceeds the balance.
+func creditToApply(balance, total float64, decimals int) float64 {
+	return rounding.Policy{Mode: rounding.Floor, Decimals: decimals}.Round(math.Min(balance, total))
+}
+
+// ------ Activities ------
+
+// ApplyCreditActivity takes up to params.Amount from the customer's credit balance for
+// a closing bill and returns how much it took. The balance row is locked while the
+// credit is taken, so concurrent closes of the customer's bills cannot spend the same
+// credit twice.
+func (a *Activities) ApplyCreditActivity(ctx context.Context, params ApplyCreditActivityParams) (float64, error) {
+	var applied float64
+	err := a.DB.inTx(ctx, func(tx *tracedTx) (err error) {
+		applied, err = applyCredit(ctx, tx, params)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("ApplyCreditActivity: %w", err)
+	}
+	return applied, nil
+}
+
+// applyCredit takes the credit for ApplyCreditActivity within tx.
+func applyCredit(ctx context.Context, tx *tracedTx, params ApplyCreditActivityParams) (applied float64, err error) {
+	// A retry of a reservation that was already committed returns its amount.
+	err = tx.QueryRow(ctx, `SELECT -amount::float8 FROM customer_credit_entries WHERE id = $1`, params.EntryID).Scan(&applied)
+	if err == nil {
+		return applied, nil
+	}
+	if !errors.Is(err, sqldb.ErrNoRows) {
+		return 0, fmt.Errorf("failed to load credit entry %s: %w", params.EntryID, err)
+	}
+
+	tenantID := tenantOrDefault(params.TenantID)
+	var frozen bool
+	err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM disputes WHERE `+openDisputesOfCustomer+`)`, tenantID, params.CustomerID, params.Currency).Scan(&frozen)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load disputes of customer %s: %w", params.CustomerID, err)
+	}
+	if frozen {
+		return 0, nil
+	}
+
+	var balance float64
+	err = tx.QueryRow(ctx, `
+        SELECT balance::float8 FROM customer_credit_balances
+        WHERE tenant_id = $1 AND customer_id = $2 AND currency = $3
+        FOR UPDATE
+    `, tenantID, params.CustomerID, params.Currency).Scan(&balance)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load credit balance of customer %s: %w", params.CustomerID, err)
+	}
+
+	applied = creditToApply(balance, params.Amount, params.Decimals)
+	if applied <= 0 {
+		return 0, nil
+	}
+	_, err = tx.Exec(ctx, `
+        UPDATE customer_credit_balances SET balance = balance - $4, updated_at = $5
+        WHERE tenant_id = $1 AND customer_id = $2 AND currency = $3
+    `, tenantID, params.CustomerID, params.Currency, applied, params.AppliedAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to take credit of customer %s: %w", params.CustomerID, err)
+	}
+	_, err = tx.Exec(ctx, `
+        INSERT INTO customer_credit_entries (id, tenant_id, customer_id, currency, amount, bill_id, description, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    `, params.EntryID, tenantID, params.CustomerID, params.Currency, -applied, params.BillID, "Applied to bill "+params.BillID, params.AppliedAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record credit entry %s: %w", params.EntryID, err)
+	}
+	return applied, nil
+}
+
+// ------ API ------
+
+// checkCreditGrant validates the currency and amount of a credit grant.
+func checkCreditGrant(req *GrantCreditRequest) error {
+	if !validCurrency(req.Currency) {
+		return apierr.InvalidArgument(apierr.InvalidCurrency, "invalid currency %q: must be a three-letter ISO 4217 code such as \"USD\"", req.Currency)
+	}
+	if req.Amount <= 0 || math.IsInf(req.Amount, 0) || math.IsNaN(req.Amount) {
+		return apierr.InvalidArgument(apierr.InvalidAmount, "credit amount must be positive, got %v", req.Amount)
+	}
+	return nil
+}
+
+const creditEntryColumns = `id, tenant_id, currency, amount::float8, COALESCE(bill_id, ''), COALESCE(description, ''), COALESCE(created_by_key_id, ''), created_at`
+
+func scanCreditEntry(row interface{ Scan(...any) error }) (*CreditEntry, error) {
+	var e CreditEntry
+	if err := row.Scan(&e.ID, &e.TenantID, &e.Currency, &e.Amount, &e.BillID, &e.Description, &e.CreatedByKeyID, &e.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// GrantCredit adds credit to a customer's balance in one currency. Available credit is
+// applied to the customer's bills in that currency as they close.
+//
+// encore:api auth method=POST path=/customers/:customerID/credits
+func (s *Service) GrantCredit(ctx context.Context, customerID string, req *GrantCreditRequest) (*GrantCreditResponse, error) {
+	if err := checkCreditGrant(req); err != nil {
+		return nil, err
+	}
+	tenantID := requestTenant(ctx, req.TenantID)
+	entryID := keyedID(ctx, "credit", tenantID, customerID)
+	amount := roundAmount(req.Amount)
+	now := s.clock.Now()
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to grant credit to customer %s", customerID)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// The entry is inserted first, so a retried grant neither fails nor adds credit twice.
+	result, err := tx.Exec(ctx, `
+        INSERT INTO customer_credit_entries (id, tenant_id, customer_id, currency, amount, description, created_by_key_id, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        ON CONFLICT (id) DO NOTHING
+    `, entryID, tenantID, customerID, req.Currency, amount, nullIfEmpty(req.Description), nullIfEmpty(callerKeyID(ctx)), now)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to grant credit to customer %s", customerID)
+	}
+	if result.RowsAffected() > 0 {
+		_, err = tx.Exec(ctx, `
+            INSERT INTO customer_credit_balances (tenant_id, customer_id, currency, balance, updated_at)
+            VALUES ($1, $2, $3, $4, $5)
+            ON CONFLICT (tenant_id, customer_id, currency) DO UPDATE
+            SET balance = customer_credit_balances.balance + EXCLUDED.balance, updated_at = EXCLUDED.updated_at
+        `, tenantID, customerID, req.Currency, amount, now)
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to grant credit to customer %s", customerID)
+		}
+	}
+
+	entry, err := scanCreditEntry(tx.QueryRow(ctx, `SELECT `+creditEntryColumns+` FROM customer_credit_entries WHERE id = $1`, entryID))
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load credit entry %s", entryID)
+	}
+	resp := &GrantCreditResponse{Entry: *entry, Balance: CreditBalance{TenantID: tenantID, Currency: entry.Currency}}
+	err = tx.QueryRow(ctx, `
+        SELECT balance::float8, updated_at FROM customer_credit_balances
+        WHERE tenant_id = $1 AND customer_id = $2 AND currency = $3
+    `, tenantID, customerID, entry.Currency).Scan(&resp.Balance.Balance, &resp.Balance.UpdatedAt)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load credit balance of customer %s", customerID)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, apierr.Wrap(err, "failed to grant credit to customer %s", customerID)
+	}
+	loggerFrom(ctx).Info("Credit granted", "currency", entry.Currency, "amount", entry.Amount, "balance", resp.Balance.Balance)
+	return resp, nil
+}
+
+// GetCreditBalances returns a customer's credit balances and their most recent
+// changes. Tenant-scoped callers only see their own tenant's credit.
+//
+// encore:api auth method=GET path=/customers/:customerID/credits
+func (s *Service) GetCreditBalances(ctx context.Context, customerID string, params *CreditBalancesParams) (*CreditBalancesResponse, error) {
+	if params.Currency != "" && !validCurrency(params.Currency) {
+		return nil, apierr.InvalidArgument(apierr.InvalidCurrency, "invalid currency %q: must be a three-letter ISO 4217 code such as \"USD\"", params.Currency)
+	}
+	tenant := callerTenant(ctx)
+	resp := &CreditBalancesResponse{CustomerID: customerID, Balances: []CreditBalance{}, Entries: []CreditEntry{}}
+
+	rows, err := s.db.Query(ctx, `
+        SELECT b.tenant_id, b.currency, b.balance::float8, b.updated_at,
+               EXISTS (SELECT 1 FROM disputes d WHERE d.tenant_id = b.tenant_id AND d.customer_id = b.customer_id
+                       AND d.currency = b.currency AND d.status IN ('NEEDS_EVIDENCE', 'EVIDENCE_SUBMITTED', 'EVIDENCE_OVERDUE'))
+        FROM customer_credit_balances b
+        WHERE b.customer_id = $1 AND ($2 = '' OR b.currency = $2) AND ($3 = '' OR b.tenant_id = $3)
+        ORDER BY b.tenant_id, b.currency
+    `, customerID, params.Currency, tenant)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load credit balances of customer %s", customerID)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b CreditBalance
+		if err := rows.Scan(&b.TenantID, &b.Currency, &b.Balance, &b.UpdatedAt, &b.Frozen); err != nil {
+			return nil, apierr.Wrap(err, "failed to read credit balances of customer %s", customerID)
+		}
+		resp.Balances = append(resp.Balances, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read credit balances of customer %s", customerID)
+	}
+
+	rows, err = s.db.Query(ctx, `
+        SELECT `+creditEntryColumns+` FROM customer_credit_entries
+        WHERE customer_id = $1 AND ($2 = '' OR currency = $2) AND ($3 = '' OR tenant_id = $3)
+        ORDER BY created_at DESC, id
+        LIMIT $4
+    `, customerID, params.Currency, tenant, maxCreditEntries)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load credit entries of customer %s", customerID)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e, err := scanCreditEntry(rows)
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to read credit entries of customer %s", customerID)
+		}
+		resp.Entries = append(resp.Entries, *e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read credit entries of customer %s", customerID)
+	}
+	return resp, nil
+}
@@ -0,0 +1,28 @@
+package fees
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestCheckCreditGrant tests that credit grants need a valid currency and a positive amount.
+func TestCheckCreditGrant(t *testing.T) {
+	require.NoError(t, checkCreditGrant(&GrantCreditRequest{Currency: "USD", Amount: 25}))
+
+	for name, req := range map[string]GrantCreditRequest{
+		"invalid currency": {Currency: "usd", Amount: 25},
+		"zero amount":      {Currency: "USD"},
+		"negative amount":  {Currency: "USD", Amount: -5},
+		"infinite amount":  {Currency: "USD", Amount: math.Inf(1)},
+	} {
+		require.Error(t, checkCreditGrant(&req), name)
+	}
+}
+
+// TestCreditLineItemID tests that a bill's credit line item ID is stable per bill.
+func TestCreditLineItemID(t *testing.T) {
+	require.Equal(t, creditLineItemID("bill-1"), creditLineItemID("bill-1"))
+	require.NotEqual(t, creditLineItemID("bill-1"), creditLineItemID("bill-2"))
+}
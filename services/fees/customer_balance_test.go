@@ -0,0 +1,34 @@
+package fees
+
+import (
+	"context"
+	"testing"
+
+	"encore.app/apierr"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGetCustomerBalance_InvalidParams tests that filters are checked before the
+// projection is read, and that a tenant-scoped key cannot read another tenant's balance.
+func TestGetCustomerBalance_InvalidParams(t *testing.T) {
+	s := &Service{}
+	_, err := s.GetCustomerBalance(context.Background(), "cust-1", &CustomerBalanceParams{Currency: "dollars"})
+	require.Equal(t, apierr.InvalidCurrency, apierr.ReasonOf(err))
+
+	ctx := withCaller(context.Background(), &AuthData{KeyID: "key-1", TenantID: "acme"})
+	_, err = s.GetCustomerBalance(ctx, "cust-1", &CustomerBalanceParams{TenantID: "globex"})
+	require.Equal(t, apierr.InsufficientScope, apierr.ReasonOf(err))
+}
+
+// TestSortedBalances tests that balances are rounded, netted against credit, and sorted
+// by currency.
+func TestSortedBalances(t *testing.T) {
+	balances := sortedBalances(map[string]*CurrencyBalance{
+		"USD": {Currency: "USD", Outstanding: 100.00004, OutstandingBills: 2, Overdue: 40, Accrued: 12.5, OpenBills: 1, Credit: 20},
+		"EUR": {Currency: "EUR", Credit: 5},
+	})
+	require.Equal(t, []CurrencyBalance{
+		{Currency: "EUR", Credit: 5, Net: -5},
+		{Currency: "USD", Outstanding: 100, OutstandingBills: 2, Overdue: 40, Accrued: 12.5, OpenBills: 1, Credit: 20, Net: 92.5},
+	}, balances)
+}
@@ -0,0 +1,230 @@
+package fees
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"encore.dev/storage/sqldb"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// defaultSlowQueryThreshold is how long a statement may take before it is logged,
+	// unless FEES_DB_SLOW_QUERY_THRESHOLD says otherwise.
+	defaultSlowQueryThreshold = 500 * time.Millisecond
+	// slowQueryMaxLength caps how much of a slow statement is logged.
+	slowQueryMaxLength = 500
+)
+
+// DBConfig tunes how the service uses the database. The connection pool itself is
+// Encore's, sized by max_connections and min_connections in the infrastructure config.
+type DBConfig struct {
+	// MaxConns caps the connections the service uses at once, below the pool's size, so
+	// ingestion bursts queue in the service instead of exhausting the database. Zero
+	// leaves it to the pool.
+	MaxConns int
+	// StatementTimeout cancels statements that run longer. Zero disables it.
+	StatementTimeout time.Duration
+	// SlowQueryThreshold logs statements that take at least this long. Zero disables
+	// slow query logging.
+	SlowQueryThreshold time.Duration
+}
+
+// loadDBConfig reads FEES_DB_MAX_CONNS, FEES_DB_STATEMENT_TIMEOUT and
+// FEES_DB_SLOW_QUERY_THRESHOLD.
+func loadDBConfig() (DBConfig, error) {
+	c := DBConfig{SlowQueryThreshold: defaultSlowQueryThreshold}
+	if err := countFromEnv("FEES_DB_MAX_CONNS", &c.MaxConns); err != nil {
+		return c, err
+	}
+	if err := durationFromEnv("FEES_DB_STATEMENT_TIMEOUT", &c.StatementTimeout); err != nil {
+		return c, err
+	}
+	if err := durationFromEnv("FEES_DB_SLOW_QUERY_THRESHOLD", &c.SlowQueryThreshold); err != nil {
+		return c, err
+	}
+	if c.StatementTimeout < 0 || c.SlowQueryThreshold < 0 {
+		return c, fmt.Errorf("FEES_DB_STATEMENT_TIMEOUT and FEES_DB_SLOW_QUERY_THRESHOLD must not be negative")
+	}
+	if c.StatementTimeout > 0 && c.StatementTimeout < time.Millisecond {
+		return c, fmt.Errorf("FEES_DB_STATEMENT_TIMEOUT must be at least 1ms, got %s", c.StatementTimeout)
+	}
+	return c, nil
+}
+
+// newTracedDB wraps db with the limits of cfg.
+func newTracedDB(db *sqldb.Database, cfg DBConfig) *tracedDB {
+	t := &tracedDB{Database: db, cfg: cfg}
+	if cfg.MaxConns > 0 {
+		t.conns = make(chan struct{}, cfg.MaxConns)
+	}
+	return t
+}
+
+// dbStats counts how the service used its connections since it started.
+type dbStats struct {
+	waits       atomic.Int64
+	waitTime    atomic.Int64
+	slowQueries atomic.Int64
+	timeouts    atomic.Int64
+}
+
+// acquire takes a connection token, waiting while MaxConns are in use, and applies the
+// statement timeout to ctx. The returned release gives both back; calling it again
+// does nothing.
+func (db *tracedDB) acquire(ctx context.Context) (context.Context, func(), error) {
+	if db.conns != nil {
+		select {
+		case db.conns <- struct{}{}:
+		default:
+			start := time.Now()
+			select {
+			case db.conns <- struct{}{}:
+				db.stats.waits.Add(1)
+				db.stats.waitTime.Add(int64(time.Since(start)))
+			case <-ctx.Done():
+				return ctx, nil, fmt.Errorf("gave up waiting for a database connection: %w", ctx.Err())
+			}
+		}
+	}
+	cancel := context.CancelFunc(func() {})
+	if db.cfg.StatementTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, db.cfg.StatementTimeout)
+	}
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			if db.cfg.StatementTimeout > 0 && ctx.Err() == context.DeadlineExceeded {
+				db.stats.timeouts.Add(1)
+			}
+			cancel()
+			if db.conns != nil {
+				<-db.conns
+			}
+		})
+	}, nil
+}
+
+// observe starts a client span for query and returns the function that ends it,
+// logging the statement, without its arguments, when it took at least
+// SlowQueryThreshold.
+func (db *tracedDB) observe(ctx context.Context, query string) (context.Context, func(error)) {
+	ctx, span := startDBSpan(ctx, query)
+	start := time.Now()
+	return ctx, func(err error) {
+		endDBSpan(span, err)
+		span.End()
+		threshold := db.cfg.SlowQueryThreshold
+		if d := time.Since(start); threshold > 0 && d >= threshold {
+			db.stats.slowQueries.Add(1)
+			loggerFrom(ctx).Warn("Slow query", "operation", sqlOperation(query), "statement", compactSQL(query), "duration", d, "threshold", threshold)
+		}
+	}
+}
+
+// compactSQL collapses the whitespace of query and shortens it to slowQueryMaxLength.
+func compactSQL(query string) string {
+	s := strings.Join(strings.Fields(query), " ")
+	if len(s) > slowQueryMaxLength {
+		s = s[:slowQueryMaxLength] + "..."
+	}
+	return s
+}
+
+// tracedRows gives back their connection when they are closed.
+type tracedRows struct {
+	*sqldb.Rows
+	release func()
+}
+
+func (r *tracedRows) Close() {
+	r.Rows.Close()
+	r.release()
+}
+
+// tracedRow gives back its connection once it is scanned. err is set instead of row
+// when no connection could be had.
+type tracedRow struct {
+	row     *sqldb.Row
+	release func()
+	err     error
+}
+
+func (r *tracedRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	defer r.release()
+	return r.row.Scan(dest...)
+}
+
+// ------ API ------
+
+// DBPoolStats describes Encore's connection pool.
+type DBPoolStats struct {
+	MaxConns      int32 `json:"maxConns"`
+	TotalConns    int32 `json:"totalConns"`
+	IdleConns     int32 `json:"idleConns"`
+	AcquiredConns int32 `json:"acquiredConns"`
+	// ConstructingConns are connections being opened.
+	ConstructingConns int32 `json:"constructingConns"`
+	AcquireCount      int64 `json:"acquireCount"`
+	// EmptyAcquireCount counts acquisitions that waited for a connection because none
+	// was idle.
+	EmptyAcquireCount    int64 `json:"emptyAcquireCount"`
+	CanceledAcquireCount int64 `json:"canceledAcquireCount"`
+	AcquireDurationMs    int64 `json:"acquireDurationMs"`
+}
+
+// DBStatsResponse is the response payload for the database statistics.
+type DBStatsResponse struct {
+	Pool DBPoolStats `json:"pool"`
+	// MaxConns is FEES_DB_MAX_CONNS, or 0 without a cap, and InUse the connections the
+	// service holds under it.
+	MaxConns int `json:"maxConns"`
+	InUse    int `json:"inUse"`
+	// Waits counts statements that waited for a connection under the cap, for WaitMs in
+	// total.
+	Waits  int64 `json:"waits"`
+	WaitMs int64 `json:"waitMs"`
+	// SlowQueries counts statements that took SlowQueryThresholdMs or longer, and
+	// Timeouts those canceled after StatementTimeoutMs.
+	SlowQueries          int64 `json:"slowQueries"`
+	SlowQueryThresholdMs int64 `json:"slowQueryThresholdMs"`
+	Timeouts             int64 `json:"timeouts"`
+	StatementTimeoutMs   int64 `json:"statementTimeoutMs"`
+}
+
+// GetDBStats reports how the service uses its database connections since it started:
+// the pool's figures, waits under FEES_DB_MAX_CONNS, slow queries, and statement
+// timeouts.
+//
+// encore:api private method=GET path=/admin/db/stats
+func (s *Service) GetDBStats(ctx context.Context) (*DBStatsResponse, error) {
+	stat := sqldb.Driver[*pgxpool.Pool](db).Stat()
+	return &DBStatsResponse{
+		Pool: DBPoolStats{
+			MaxConns:             stat.MaxConns(),
+			TotalConns:           stat.TotalConns(),
+			IdleConns:            stat.IdleConns(),
+			AcquiredConns:        stat.AcquiredConns(),
+			ConstructingConns:    stat.ConstructingConns(),
+			AcquireCount:         stat.AcquireCount(),
+			EmptyAcquireCount:    stat.EmptyAcquireCount(),
+			CanceledAcquireCount: stat.CanceledAcquireCount(),
+			AcquireDurationMs:    stat.AcquireDuration().Milliseconds(),
+		},
+		MaxConns:             s.db.cfg.MaxConns,
+		InUse:                len(s.db.conns),
+		Waits:                s.db.stats.waits.Load(),
+		WaitMs:               time.Duration(s.db.stats.waitTime.Load()).Milliseconds(),
+		SlowQueries:          s.db.stats.slowQueries.Load(),
+		SlowQueryThresholdMs: s.db.cfg.SlowQueryThreshold.Milliseconds(),
+		Timeouts:             s.db.stats.timeouts.Load(),
+		StatementTimeoutMs:   s.db.cfg.StatementTimeout.Milliseconds(),
+	}, nil
+}
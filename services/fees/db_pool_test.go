@@ -0,0 +1,62 @@
+package fees
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestTracedDBAcquire tests that statements wait for a connection while MaxConns are in
+// use, give up when their context ends, and count the waits.
+func TestTracedDBAcquire(t *testing.T) {
+	db := newTracedDB(nil, DBConfig{MaxConns: 1, StatementTimeout: time.Minute})
+	ctx, release, err := db.acquire(context.Background())
+	require.NoError(t, err)
+	_, hasDeadline := ctx.Deadline()
+	require.True(t, hasDeadline)
+
+	waiting, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = db.acquire(waiting)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	acquired := make(chan func())
+	go func() {
+		_, next, err := db.acquire(context.Background())
+		require.NoError(t, err)
+		acquired <- next
+	}()
+	time.Sleep(10 * time.Millisecond)
+	release()
+	release()
+	(<-acquired)()
+	require.Equal(t, int64(1), db.stats.waits.Load())
+	require.Empty(t, db.conns)
+}
+
+// TestLoadDBConfig tests the defaults and that invalid settings are rejected.
+func TestLoadDBConfig(t *testing.T) {
+	cfg, err := loadDBConfig()
+	require.NoError(t, err)
+	require.Equal(t, DBConfig{SlowQueryThreshold: defaultSlowQueryThreshold}, cfg)
+
+	t.Setenv("FEES_DB_MAX_CONNS", "20")
+	t.Setenv("FEES_DB_STATEMENT_TIMEOUT", "30s")
+	t.Setenv("FEES_DB_SLOW_QUERY_THRESHOLD", "0")
+	cfg, err = loadDBConfig()
+	require.NoError(t, err)
+	require.Equal(t, DBConfig{MaxConns: 20, StatementTimeout: 30 * time.Second}, cfg)
+
+	t.Setenv("FEES_DB_STATEMENT_TIMEOUT", "100us")
+	_, err = loadDBConfig()
+	require.Error(t, err)
+}
+
+// TestCompactSQL tests that logged statements fit on one line and are shortened.
+func TestCompactSQL(t *testing.T) {
+	require.Equal(t, "SELECT 1 FROM bills WHERE id = $1", compactSQL("\n        SELECT 1\n        FROM bills WHERE id = $1\n    "))
+	require.Len(t, compactSQL(strings.Repeat("x ", 1000)), slowQueryMaxLength+len("..."))
+}
@@ -0,0 +1,205 @@
+package fees
+
+import (
+	"fmt"
+	"time"
+
+	enums "go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/workflow"
+)
+
+// DisputeWorkflowParams defines the parameters for starting the DisputeWorkflow.
+type DisputeWorkflowParams struct {
+	Dispute    Dispute
+	TenantID   string
+	CustomerID string
+}
+
+// SubmitDisputeEvidenceSignal contests a dispute with evidence.
+type SubmitDisputeEvidenceSignal struct {
+	Evidence DisputeEvidence
+}
+
+// ResolveDisputeSignal delivers the provider's decision on a dispute.
+type ResolveDisputeSignal struct {
+	// Outcome is DisputeStatusWon or DisputeStatusLost.
+	Outcome DisputeStatus
+}
+
+// DisputeWorkflow tracks a chargeback from the moment it is opened until the provider
+// decides it. Operators are told when it opens, reminded disputeEvidenceReminder
+// before its evidence deadline, and told again if the deadline passes without
+// evidence. While it is open, the customer's credit in its currency is frozen. The
+// bill's payment is settled by the bill's workflow, which forwards the decision here.
+func DisputeWorkflow(ctx workflow.Context, params *DisputeWorkflowParams) (*Dispute, error) {
+	logger := workflow.GetLogger(ctx)
+	dispute := params.Dispute
+	dispute.Status = DisputeStatusNeedsEvidence
+	dispute.OpenedAt = workflow.Now(ctx)
+	save := func() {
+		err := workflow.ExecuteActivity(ctx, SaveDisputeActivityName, SaveDisputeActivityParams{
+			Dispute:    dispute,
+			TenantID:   params.TenantID,
+			CustomerID: params.CustomerID,
+		}).Get(ctx, nil)
+		if err != nil {
+			logger.Error("Failed to execute SaveDisputeActivity", "dispute_id", dispute.ID, "bill_id", dispute.BillID, "status", dispute.Status, "error", err)
+		}
+	}
+	notifyOperators := func(event NotificationEvent, message string) {
+		notify(ctx, Notification{Event: event, BillID: dispute.BillID, CustomerID: params.CustomerID, Message: message})
+	}
+
+	save()
+	logger.Info("DisputeWorkflow started", "dispute_id", dispute.ID, "bill_id", dispute.BillID, "payment_id", dispute.PaymentID, "evidence_due_by", dispute.EvidenceDueBy)
+	notifyOperators(NotificationDisputeOpened, fmt.Sprintf("Payment %s of %.2f %s was charged back (%s). Submit evidence by %s.",
+		dispute.PaymentID, dispute.Amount, dispute.Currency, dispute.Reason, dispute.EvidenceDueBy.Format(time.RFC3339)))
+
+	timerCtx, cancelTimers := workflow.WithCancel(ctx)
+	defer cancelTimers()
+	untilDue := dispute.EvidenceDueBy.Sub(workflow.Now(ctx))
+	var reminder workflow.Future
+	if untilDue > disputeEvidenceReminder {
+		reminder = workflow.NewTimer(timerCtx, untilDue-disputeEvidenceReminder)
+	}
+	deadline := workflow.NewTimer(timerCtx, max(untilDue, 0))
+
+	evidenceCh := workflow.GetSignalChannel(ctx, SubmitDisputeEvidenceSignalName)
+	resolveCh := workflow.GetSignalChannel(ctx, ResolveDisputeSignalName)
+	for dispute.Status.open() {
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(evidenceCh, func(c workflow.ReceiveChannel, more bool) {
+			var signal SubmitDisputeEvidenceSignal
+			c.Receive(ctx, &signal)
+			if dispute.Status != DisputeStatusNeedsEvidence && dispute.Status != DisputeStatusEvidenceSubmitted {
+				logger.Warn("Evidence submitted for a dispute that no longer accepts it, ignoring.", "dispute_id", dispute.ID, "status", dispute.Status)
+				return
+			}
+			dispute.Evidence = &signal.Evidence
+			dispute.Status = DisputeStatusEvidenceSubmitted
+			save()
+			logger.Info("Dispute evidence submitted", "dispute_id", dispute.ID, "bill_id", dispute.BillID)
+		})
+		selector.AddReceive(resolveCh, func(c workflow.ReceiveChannel, more bool) {
+			var signal ResolveDisputeSignal
+			c.Receive(ctx, &signal)
+			if signal.Outcome != DisputeStatusWon && signal.Outcome != DisputeStatusLost {
+				logger.Warn("Unknown dispute outcome, ignoring.", "dispute_id", dispute.ID, "outcome", signal.Outcome)
+				return
+			}
+			resolvedAt := workflow.Now(ctx)
+			dispute.Status = signal.Outcome
+			dispute.ResolvedAt = &resolvedAt
+			save()
+			logger.Info("Dispute resolved", "dispute_id", dispute.ID, "bill_id", dispute.BillID, "outcome", signal.Outcome)
+			notifyOperators(NotificationDisputeResolved, fmt.Sprintf("The chargeback of payment %s was %s.", dispute.PaymentID, map[DisputeStatus]string{DisputeStatusWon: "won", DisputeStatusLost: "lost"}[signal.Outcome]))
+		})
+		if reminder != nil {
+			selector.AddFuture(reminder, func(f workflow.Future) {
+				reminder = nil
+				if dispute.Status == DisputeStatusNeedsEvidence {
+					notifyOperators(NotificationDisputeEvidenceDue, fmt.Sprintf("Evidence for the chargeback of payment %s is due by %s.", dispute.PaymentID, dispute.EvidenceDueBy.Format(time.RFC3339)))
+				}
+			})
+		}
+		if deadline != nil {
+			selector.AddFuture(deadline, func(f workflow.Future) {
+				deadline = nil
+				if dispute.Status == DisputeStatusNeedsEvidence {
+					dispute.Status = DisputeStatusEvidenceOverdue
+					save()
+					logger.Warn("Dispute evidence deadline passed without evidence", "dispute_id", dispute.ID, "bill_id", dispute.BillID)
+					notifyOperators(NotificationDisputeEvidenceOverdue, fmt.Sprintf("No evidence was submitted for the chargeback of payment %s by its deadline.", dispute.PaymentID))
+				}
+			})
+		}
+		selector.Select(ctx)
+	}
+
+	logger.Info("DisputeWorkflow completed", "dispute_id", dispute.ID, "bill_id", dispute.BillID, "status", dispute.Status)
+	return &dispute, nil
+}
+
+// openDispute starts the DisputeWorkflow of a payment the customer charged back. The
+// dispute outlives the bill's run, which may complete while the dispute is open.
+func (w *billWorkflow) openDispute(payment *Payment, signal PaymentWebhookSignal) {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	dueBy := workflow.Now(ctx).Add(defaultDisputeEvidenceWindow)
+	if signal.EvidenceDueBy != nil {
+		dueBy = *signal.EvidenceDueBy
+	}
+	payment.DisputeID = disputeID(signal.Provider, signal.EventID)
+
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID:        disputeWorkflowID(payment.DisputeID),
+		ParentClosePolicy: enums.PARENT_CLOSE_POLICY_ABANDON,
+	})
+	child := workflow.ExecuteChildWorkflow(childCtx, DisputeWorkflow, &DisputeWorkflowParams{
+		Dispute: Dispute{
+			ID:            payment.DisputeID,
+			BillID:        bill.ID,
+			PaymentID:     payment.ID,
+			Amount:        payment.Amount,
+			Currency:      bill.Currency,
+			Reason:        signal.Reason,
+			EvidenceDueBy: dueBy,
+		},
+		TenantID:   bill.TenantID,
+		CustomerID: bill.CustomerID,
+	})
+	// Wait until it started, so it is not lost if this run completes first.
+	if err := child.GetChildWorkflowExecution().Get(ctx, nil); err != nil {
+		logger.Error("Failed to start DisputeWorkflow", "bill_id", bill.ID, "payment_id", payment.ID, "dispute_id", payment.DisputeID, "error", err)
+		return
+	}
+	logger.Info("Dispute opened for payment", "bill_id", bill.ID, "payment_id", payment.ID, "dispute_id", payment.DisputeID, "evidence_due_by", dueBy)
+}
+
+// resolveDispute applies the provider's decision on a disputed payment: a won dispute
+// leaves the payment successful, while a lost one reverses it like a returned payment,
+// without dunning, since the customer contested the charge. The decision is forwarded
+// to the payment's DisputeWorkflow.
+func (w *billWorkflow) resolveDispute(payment *Payment, signal PaymentWebhookSignal) {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	if payment.Status != PaymentStatusDisputed {
+		logger.Info("Payment is not disputed, ignoring dispute decision.", "bill_id", bill.ID, "payment_id", payment.ID, "payment_status", payment.Status, "event_id", signal.EventID)
+		return
+	}
+
+	outcome := DisputeStatusWon
+	if signal.Type == PaymentWebhookDisputeLost {
+		outcome = DisputeStatusLost
+	}
+	if outcome == DisputeStatusWon {
+		payment.Status = PaymentStatusSucceeded
+		payment.FailureReason = ""
+		w.touch()
+		w.savePayment(*payment, false)
+	} else {
+		lostAt := workflow.Now(ctx)
+		payment.Status = PaymentStatusFailed
+		payment.FailureReason = "chargeback lost"
+		if signal.Reason != "" {
+			payment.FailureReason += ": " + signal.Reason
+		}
+		payment.CompletedAt = &lostAt
+		bill.reversePayment(*payment, lostAt)
+		w.touch()
+		w.savePayment(*payment, true)
+		switch bill.Status {
+		case BillStatusPaid, BillStatusPartiallyPaid, BillStatusClosed:
+			w.setStatus(BillStatusPaymentFailed)
+		}
+	}
+	logger.Info("Dispute decided", "bill_id", bill.ID, "payment_id", payment.ID, "dispute_id", payment.DisputeID, "outcome", outcome)
+
+	if payment.DisputeID == "" {
+		return
+	}
+	err := workflow.SignalExternalWorkflow(ctx, disputeWorkflowID(payment.DisputeID), "", ResolveDisputeSignalName, ResolveDisputeSignal{Outcome: outcome}).Get(ctx, nil)
+	if err != nil {
+		logger.Error("Failed to signal DisputeWorkflow", "bill_id", bill.ID, "dispute_id", payment.DisputeID, "error", err)
+	}
+}
@@ -0,0 +1,248 @@
+package fees
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/storage/sqldb"
+	"github.com/google/uuid"
+)
+
+// DisputeStatus is the state of a chargeback dispute.
+type DisputeStatus string
+
+const (
+	// DisputeStatusNeedsEvidence disputes wait for evidence until their deadline.
+	DisputeStatusNeedsEvidence DisputeStatus = "NEEDS_EVIDENCE"
+	// DisputeStatusEvidenceSubmitted disputes wait for the provider's decision.
+	DisputeStatusEvidenceSubmitted DisputeStatus = "EVIDENCE_SUBMITTED"
+	// DisputeStatusEvidenceOverdue disputes passed their deadline without evidence. They
+	// wait for the provider's decision, which will usually go to the customer.
+	DisputeStatusEvidenceOverdue DisputeStatus = "EVIDENCE_OVERDUE"
+	DisputeStatusWon             DisputeStatus = "WON"
+	DisputeStatusLost            DisputeStatus = "LOST"
+)
+
+// open reports whether a dispute in status s awaits the provider's decision.
+func (s DisputeStatus) open() bool {
+	return s != DisputeStatusWon && s != DisputeStatusLost
+}
+
+const (
+	// SaveDisputeActivityName persists a dispute's state.
+	SaveDisputeActivityName = "SaveDisputeActivity"
+
+	// defaultDisputeEvidenceWindow is how long evidence may be submitted for a chargeback
+	// whose callback gives no deadline.
+	defaultDisputeEvidenceWindow = 7 * 24 * time.Hour
+	// disputeEvidenceReminder is how long before its deadline operators are reminded of a
+	// dispute still without evidence.
+	disputeEvidenceReminder = 48 * time.Hour
+	// maxDisputeEvidenceLength caps the text of dispute evidence.
+	maxDisputeEvidenceLength = 20000
+)
+
+// Dispute is a customer's chargeback of a bill payment.
+type Dispute struct {
+	ID        string  `json:"id"`
+	BillID    string  `json:"billId"`
+	PaymentID string  `json:"paymentId"`
+	Amount    float64 `json:"amount"`
+	Currency  string  `json:"currency"`
+	// Reason is the provider's reason for the chargeback, such as "fraudulent".
+	Reason        string           `json:"reason,omitempty"`
+	Status        DisputeStatus    `json:"status"`
+	EvidenceDueBy time.Time        `json:"evidenceDueBy"`
+	Evidence      *DisputeEvidence `json:"evidence,omitempty"`
+	OpenedAt      time.Time        `json:"openedAt"`
+	ResolvedAt    *time.Time       `json:"resolvedAt,omitempty"`
+}
+
+// DisputeEvidence is what the biller submitted to contest a dispute.
+type DisputeEvidence struct {
+	Text string `json:"text"`
+	// AttachmentIDs are attachments of the bill supporting the evidence, such as a
+	// signed contract or delivery receipt.
+	AttachmentIDs    []string  `json:"attachmentIds,omitempty"`
+	SubmittedAt      time.Time `json:"submittedAt"`
+	SubmittedByKeyID string    `json:"submittedByKeyId,omitempty"`
+}
+
+// disputeID identifies the dispute opened by a provider's chargeback event, so a
+// redelivered event does not open a second one.
+func disputeID(provider, eventID string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte("feems/dispute/"+provider+"/"+eventID)).String()
+}
+
+// disputeWorkflowID is the ID of the DisputeWorkflow of a dispute.
+func disputeWorkflowID(disputeID string) string {
+	return "dispute-" + disputeID
+}
+
+// disputed reports whether a payment of the bill is charged back and undecided.
+func (b *Bill) disputed() bool {
+	for _, p := range b.Payments {
+		if p.Status == PaymentStatusDisputed {
+			return true
+		}
+	}
+	return false
+}
+
+// openDisputesOfCustomer matches the open disputes of customer $2 of tenant $1 in
+// currency $3, which freeze the customer's credit in the currency.
+const openDisputesOfCustomer = `tenant_id = $1 AND customer_id = $2 AND currency = $3 AND status IN ('NEEDS_EVIDENCE', 'EVIDENCE_SUBMITTED', 'EVIDENCE_OVERDUE')`
+
+// ------ Activities ------
+
+// SaveDisputeActivityParams defines parameters for SaveDisputeActivity.
+type SaveDisputeActivityParams struct {
+	Dispute    Dispute
+	TenantID   string
+	CustomerID string
+}
+
+// SaveDisputeActivity writes a dispute's current state.
+func (a *Activities) SaveDisputeActivity(ctx context.Context, params SaveDisputeActivityParams) error {
+	d := params.Dispute
+	evidence, err := jsonColumn(d.Evidence)
+	if err != nil {
+		return fmt.Errorf("SaveDisputeActivity: failed to encode evidence of dispute %s: %w", d.ID, err)
+	}
+	_, err = a.DB.Exec(ctx, `
+        INSERT INTO disputes (id, bill_id, tenant_id, customer_id, payment_id, amount, currency, reason, status, evidence_due_by, evidence, opened_at, resolved_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+        ON CONFLICT (id) DO UPDATE SET
+            status = EXCLUDED.status,
+            evidence = EXCLUDED.evidence,
+            resolved_at = EXCLUDED.resolved_at
+    `, d.ID, d.BillID, tenantOrDefault(params.TenantID), nullIfEmpty(params.CustomerID), d.PaymentID, d.Amount, d.Currency, nullIfEmpty(d.Reason),
+		d.Status, d.EvidenceDueBy, evidence, d.OpenedAt, d.ResolvedAt)
+	if err != nil {
+		return fmt.Errorf("SaveDisputeActivity: failed to save dispute %s of bill %s: %w", d.ID, d.BillID, err)
+	}
+	return nil
+}
+
+// ------ API ------
+
+// ListDisputesResponse is the response payload for a bill's disputes.
+type ListDisputesResponse struct {
+	BillID   string    `json:"billId"`
+	Disputes []Dispute `json:"disputes"`
+}
+
+// SubmitDisputeEvidenceRequest is the request payload for contesting a dispute.
+type SubmitDisputeEvidenceRequest struct {
+	Text          string   `json:"text"`
+	AttachmentIDs []string `json:"attachmentIds,omitempty"`
+}
+
+// SubmitDisputeEvidenceResponse is the response payload after submitting evidence.
+type SubmitDisputeEvidenceResponse struct {
+	RetryMetadata
+	Dispute Dispute `json:"dispute"`
+}
+
+const disputeColumns = `id, bill_id, payment_id, amount::float8, currency, COALESCE(reason, ''), status, evidence_due_by, evidence, opened_at, resolved_at`
+
+func scanDispute(row interface{ Scan(...any) error }) (*Dispute, error) {
+	var d Dispute
+	var evidence []byte
+	if err := row.Scan(&d.ID, &d.BillID, &d.PaymentID, &d.Amount, &d.Currency, &d.Reason, &d.Status, &d.EvidenceDueBy, &evidence, &d.OpenedAt, &d.ResolvedAt); err != nil {
+		return nil, err
+	}
+	if evidence != nil {
+		d.Evidence = &DisputeEvidence{}
+		if err := json.Unmarshal(evidence, d.Evidence); err != nil {
+			return nil, fmt.Errorf("failed to decode evidence of dispute %s: %w", d.ID, err)
+		}
+	}
+	return &d, nil
+}
+
+// ListDisputes lists the chargeback disputes of a bill's payments, oldest first, with
+// their status and evidence deadline.
+//
+// encore:api auth method=GET path=/bills/:billID/disputes
+func (s *Service) ListDisputes(ctx context.Context, billID string) (*ListDisputesResponse, error) {
+	if err := s.checkBillVisible(ctx, billID); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(ctx, `SELECT `+disputeColumns+` FROM disputes WHERE bill_id = $1 ORDER BY opened_at, id`, billID)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to list disputes of bill %s", billID)
+	}
+	defer rows.Close()
+
+	resp := &ListDisputesResponse{BillID: billID, Disputes: []Dispute{}}
+	for rows.Next() {
+		d, err := scanDispute(rows)
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to read disputes of bill %s", billID)
+		}
+		resp.Disputes = append(resp.Disputes, *d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read disputes of bill %s", billID)
+	}
+	return resp, nil
+}
+
+// SubmitDisputeEvidence contests a dispute with evidence, such as the bill's usage
+// records, before its deadline. Submitting again before the deadline replaces the
+// evidence.
+//
+// encore:api auth method=POST path=/bills/:billID/disputes/:disputeID/evidence
+func (s *Service) SubmitDisputeEvidence(ctx context.Context, billID, disputeID string, params *SubmitDisputeEvidenceRequest) (*SubmitDisputeEvidenceResponse, error) {
+	params.Text = strings.TrimSpace(params.Text)
+	if params.Text == "" || len(params.Text) > maxDisputeEvidenceLength {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "text is required and must be at most %d characters", maxDisputeEvidenceLength)
+	}
+	if err := s.checkBillVisible(ctx, billID); err != nil {
+		return nil, err
+	}
+	row := s.db.QueryRow(ctx, `SELECT `+disputeColumns+` FROM disputes WHERE id = $1 AND bill_id = $2`, disputeID, billID)
+	dispute, err := scanDispute(row)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return nil, apierr.NotFound(apierr.DisputeNotFound, "dispute %s not found on bill %s", disputeID, billID)
+	}
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load dispute %s", disputeID)
+	}
+
+	now := s.clock.Now()
+	if (dispute.Status != DisputeStatusNeedsEvidence && dispute.Status != DisputeStatusEvidenceSubmitted) || now.After(dispute.EvidenceDueBy) {
+		return nil, apierr.FailedPrecondition(apierr.DisputeEvidenceClosed, "dispute %s no longer accepts evidence: it is %s and evidence was due by %s", disputeID, dispute.Status, dispute.EvidenceDueBy.Format(time.RFC3339))
+	}
+	if len(params.AttachmentIDs) > 0 {
+		var found int
+		err := s.db.QueryRow(ctx, `
+            SELECT COUNT(*) FROM bill_attachments WHERE bill_id = $1 AND id = ANY($2::text[])
+        `, billID, params.AttachmentIDs).Scan(&found)
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to load attachments of bill %s", billID)
+		}
+		if found != len(params.AttachmentIDs) {
+			return nil, apierr.NotFound(apierr.AttachmentNotFound, "evidence names attachments that are not on bill %s", billID)
+		}
+	}
+
+	evidence := DisputeEvidence{
+		Text:             params.Text,
+		AttachmentIDs:    params.AttachmentIDs,
+		SubmittedAt:      now,
+		SubmittedByKeyID: callerKeyID(ctx),
+	}
+	if err := s.temporalClient.SignalWorkflow(ctx, disputeWorkflowID(disputeID), "", SubmitDisputeEvidenceSignalName, SubmitDisputeEvidenceSignal{Evidence: evidence}); err != nil {
+		return nil, apierr.FromTemporal(err, apierr.DisputeNotFound, "dispute %s is no longer open", disputeID)
+	}
+	dispute.Status = DisputeStatusEvidenceSubmitted
+	dispute.Evidence = &evidence
+	return &SubmitDisputeEvidenceResponse{Dispute: *dispute}, nil
+}
@@ -0,0 +1,31 @@
+package fees
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisputeStatusOpen(t *testing.T) {
+	for _, s := range []DisputeStatus{DisputeStatusNeedsEvidence, DisputeStatusEvidenceSubmitted, DisputeStatusEvidenceOverdue} {
+		require.True(t, s.open(), s)
+	}
+	require.False(t, DisputeStatusWon.open())
+	require.False(t, DisputeStatusLost.open())
+}
+
+// TestDisputeID tests that a redelivered chargeback maps to the same dispute, and that
+// events of different providers do not collide.
+func TestDisputeID(t *testing.T) {
+	require.Equal(t, disputeID("acme", "evt-1"), disputeID("acme", "evt-1"))
+	require.NotEqual(t, disputeID("acme", "evt-1"), disputeID("acme", "evt-2"))
+	require.NotEqual(t, disputeID("acme", "evt-1"), disputeID("globex", "evt-1"))
+}
+
+func TestBillDisputed(t *testing.T) {
+	bill := &Bill{Payments: []Payment{{ID: "pay-1", Status: PaymentStatusSucceeded}}}
+	require.False(t, bill.disputed())
+
+	bill.Payments = append(bill.Payments, Payment{ID: "pay-2", Status: PaymentStatusDisputed})
+	require.True(t, bill.disputed())
+}
@@ -0,0 +1,90 @@
+package fees
+
+import (
+	"context"
+	"time"
+
+	"encore.app/apierr"
+)
+
+// DunningStatus represents the state of a bill's dunning process.
+type DunningStatus string
+
+const (
+	DunningStatusActive     DunningStatus = "ACTIVE"
+	DunningStatusPaused     DunningStatus = "PAUSED"
+	DunningStatusRecovered  DunningStatus = "RECOVERED"
+	DunningStatusDelinquent DunningStatus = "DELINQUENT"
+)
+
+// DunningState is the queryable state of a DunningWorkflow.
+type DunningState struct {
+	BillID        string        `json:"billId"`
+	Status        DunningStatus `json:"status"`
+	Step          int           `json:"step"`
+	TotalSteps    int           `json:"totalSteps"`
+	StartedAt     *time.Time    `json:"startedAt"`
+	NextAttemptAt *time.Time    `json:"nextAttemptAt,omitempty"`
+	Attempts      []Payment     `json:"attempts"`
+}
+
+// DunningResponse is the response payload for retrieving a bill's dunning state.
+type DunningResponse struct {
+	Dunning DunningState `json:"dunning"`
+}
+
+// DunningActionResponse is the response payload after pausing or resuming dunning.
+type DunningActionResponse struct {
+	RetryMetadata
+	BillID          string `json:"billId"`
+	ConfirmationMsg string `json:"confirmationMsg"`
+}
+
+// GetDunning retrieves the dunning state of a bill whose payment failed.
+//
+// encore:api auth method=GET path=/bills/:billID/dunning
+func (s *Service) GetDunning(ctx context.Context, billID string) (*DunningResponse, error) {
+	if err := s.authorizeBill(ctx, billID); err != nil {
+		return nil, err
+	}
+	wfID := "dunning-" + billID
+	resp, err := s.temporalClient.QueryWorkflow(ctx, wfID, "", GetDunningStateQueryName)
+	if err != nil {
+		return nil, apierr.FromTemporal(err, apierr.DunningNotFound, "no dunning in progress for bill %s", billID)
+	}
+
+	var state DunningState
+	if err := resp.Get(&state); err != nil {
+		return nil, apierr.Wrap(err, "failed to decode dunning state of bill %s", billID)
+	}
+	return &DunningResponse{Dunning: state}, nil
+}
+
+// PauseDunning stops further payment retries for a bill until dunning is resumed.
+//
+// encore:api auth method=POST path=/bills/:billID/dunning/pause
+func (s *Service) PauseDunning(ctx context.Context, billID string) (*DunningActionResponse, error) {
+	if err := s.authorizeBill(ctx, billID); err != nil {
+		return nil, err
+	}
+	wfID := "dunning-" + billID
+	if err := s.temporalClient.SignalWorkflow(ctx, wfID, "", PauseDunningSignalName, nil); err != nil {
+		return nil, apierr.FromTemporal(err, apierr.DunningNotFound, "no dunning in progress for bill %s", billID)
+	}
+	return &DunningActionResponse{BillID: billID, ConfirmationMsg: "Dunning pause requested."}, nil
+}
+
+// ResumeDunning resumes payment retries for a paused bill. Attempts whose scheduled
+// time passed while paused run immediately.
+//
+// encore:api auth method=POST path=/bills/:billID/dunning/resume
+func (s *Service) ResumeDunning(ctx context.Context, billID string) (*DunningActionResponse, error) {
+	if err := s.authorizeBill(ctx, billID); err != nil {
+		return nil, err
+	}
+	wfID := "dunning-" + billID
+	if err := s.temporalClient.SignalWorkflow(ctx, wfID, "", ResumeDunningSignalName, nil); err != nil {
+		return nil, apierr.FromTemporal(err, apierr.DunningNotFound, "no dunning in progress for bill %s", billID)
+	}
+	return &DunningActionResponse{BillID: billID, ConfirmationMsg: "Dunning resume requested."}, nil
+}
@@ -0,0 +1,224 @@
+package fees
+
+import (
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// DunningWorkflowParams defines the parameters for starting the DunningWorkflow.
+type DunningWorkflowParams struct {
+	BillID     string
+	CustomerID string
+	Amount     float64
+	Currency   string
+	// Schedule lists retry offsets measured from the start of dunning.
+	Schedule []time.Duration
+}
+
+// DunningResult is the outcome returned by the DunningWorkflow.
+type DunningResult struct {
+	Status   DunningStatus
+	Attempts []Payment
+}
+
+// SendNotificationActivityParams defines parameters for SendNotificationActivity.
+type SendNotificationActivityParams struct {
+	Notification Notification
+}
+
+// DunningWorkflow retries payment of a bill on a fixed schedule after its first
+// payment failed, notifying the customer at each step. It ends RECOVERED once a
+// retry succeeds, or DELINQUENT when the schedule is exhausted. Retries can be
+// paused and resumed by signal; attempts missed while paused run on resume.
+func DunningWorkflow(ctx workflow.Context, params *DunningWorkflowParams) (*DunningResult, error) {
+	logger := workflow.GetLogger(ctx)
+	startedAt := workflow.Now(ctx)
+	state := &DunningState{
+		BillID:     params.BillID,
+		Status:     DunningStatusActive,
+		TotalSteps: len(params.Schedule),
+		StartedAt:  &startedAt,
+		Attempts:   make([]Payment, 0, len(params.Schedule)),
+	}
+	if err := workflow.SetQueryHandler(ctx, GetDunningStateQueryName, func() (*DunningState, error) {
+		return state, nil
+	}); err != nil {
+		logger.Error("Failed to register dunning query handler", "error", err)
+		return nil, err
+	}
+
+	pauseCh := workflow.GetSignalChannel(ctx, PauseDunningSignalName)
+	resumeCh := workflow.GetSignalChannel(ctx, ResumeDunningSignalName)
+
+	logger.Info("DunningWorkflow started", "bill_id", params.BillID, "steps", len(params.Schedule))
+
+	for i, offset := range params.Schedule {
+		state.Step = i + 1
+		attemptAt := startedAt.Add(offset)
+		state.NextAttemptAt = &attemptAt
+
+		// Wait for the scheduled attempt time, honoring pause/resume in between.
+		for {
+			wait := attemptAt.Sub(workflow.Now(ctx))
+			if state.Status == DunningStatusActive && wait <= 0 {
+				break
+			}
+
+			timerCtx, cancelTimer := workflow.WithCancel(ctx)
+			selector := workflow.NewSelector(ctx)
+			if state.Status == DunningStatusActive {
+				selector.AddFuture(workflow.NewTimer(timerCtx, wait), func(f workflow.Future) {})
+			}
+			selector.AddReceive(pauseCh, func(c workflow.ReceiveChannel, more bool) {
+				c.Receive(ctx, nil)
+				if state.Status == DunningStatusActive {
+					state.Status = DunningStatusPaused
+					logger.Info("Dunning paused", "bill_id", params.BillID, "step", state.Step)
+				}
+			})
+			selector.AddReceive(resumeCh, func(c workflow.ReceiveChannel, more bool) {
+				c.Receive(ctx, nil)
+				if state.Status == DunningStatusPaused {
+					state.Status = DunningStatusActive
+					logger.Info("Dunning resumed", "bill_id", params.BillID, "step", state.Step)
+				}
+			})
+			selector.Select(ctx)
+			cancelTimer()
+		}
+
+		payment := dunningAttempt(ctx, params)
+		state.Attempts = append(state.Attempts, payment)
+
+		if payment.Status == PaymentStatusSucceeded {
+			state.Status = DunningStatusRecovered
+			state.NextAttemptAt = nil
+			notify(ctx, Notification{
+				Event:      NotificationPaymentRecovered,
+				BillID:     params.BillID,
+				CustomerID: params.CustomerID,
+				Message:    fmt.Sprintf("Payment of %.2f %s succeeded on retry %d of %d.", params.Amount, params.Currency, state.Step, state.TotalSteps),
+			})
+			logger.Info("DunningWorkflow recovered payment", "bill_id", params.BillID, "step", state.Step)
+			return &DunningResult{Status: state.Status, Attempts: state.Attempts}, nil
+		}
+
+		notify(ctx, Notification{
+			Event:      NotificationPaymentRetryFailed,
+			BillID:     params.BillID,
+			CustomerID: params.CustomerID,
+			Message:    fmt.Sprintf("Payment retry %d of %d for %.2f %s failed: %s", state.Step, state.TotalSteps, params.Amount, params.Currency, payment.FailureReason),
+		})
+	}
+
+	state.Status = DunningStatusDelinquent
+	state.NextAttemptAt = nil
+	notify(ctx, Notification{
+		Event:      NotificationBillDelinquent,
+		BillID:     params.BillID,
+		CustomerID: params.CustomerID,
+		Message:    fmt.Sprintf("Bill is delinquent after %d failed payment retries.", state.TotalSteps),
+	})
+	logger.Info("DunningWorkflow exhausted retries, bill is delinquent", "bill_id", params.BillID)
+	return &DunningResult{Status: state.Status, Attempts: state.Attempts}, nil
+}
+
+// dunningAttempt charges the bill once through a child PaymentWorkflow.
+func dunningAttempt(ctx workflow.Context, params *DunningWorkflowParams) Payment {
+	logger := workflow.GetLogger(ctx)
+	createdAt := workflow.Now(ctx)
+
+	paymentID, err := generateID(ctx)
+	if err != nil {
+		return Payment{Status: PaymentStatusFailed, Amount: params.Amount, FailureReason: err.Error(), CreatedAt: &createdAt}
+	}
+
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID: "payment-" + paymentID,
+	})
+	var result PaymentResult
+	err = workflow.ExecuteChildWorkflow(childCtx, PaymentWorkflow, &PaymentWorkflowParams{
+		PaymentID:  paymentID,
+		BillID:     params.BillID,
+		CustomerID: params.CustomerID,
+		Amount:     params.Amount,
+		Currency:   params.Currency,
+	}).Get(childCtx, &result)
+	if err != nil {
+		logger.Error("Dunning PaymentWorkflow failed", "bill_id", params.BillID, "payment_id", paymentID, "error", err)
+		result = PaymentResult{PaymentID: paymentID, Status: PaymentStatusFailed, FailureReason: err.Error(), CompletedAt: workflow.Now(ctx)}
+	}
+
+	return Payment{
+		ID:               paymentID,
+		Status:           result.Status,
+		Amount:           params.Amount,
+		GatewayReference: result.GatewayReference,
+		FailureReason:    result.FailureReason,
+		CreatedAt:        &createdAt,
+		CompletedAt:      &result.CompletedAt,
+	}
+}
+
+// notify sends a notification through SendNotificationActivity. Failures are logged
+// and never block the calling workflow.
+func notify(ctx workflow.Context, n Notification) {
+	err := workflow.ExecuteActivity(ctx, SendNotificationActivityName, SendNotificationActivityParams{Notification: n}).Get(ctx, nil)
+	if err != nil {
+		workflow.GetLogger(ctx).Error("Failed to execute SendNotificationActivity", "bill_id", n.BillID, "event", n.Event, "error", err)
+	}
+}
+
+// startDunning launches a child DunningWorkflow for a bill whose payment failed.
+func (w *billWorkflow) startDunning() {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	dunningCtx, cancel := workflow.WithCancel(ctx)
+	dunningCtx = workflow.WithChildOptions(dunningCtx, workflow.ChildWorkflowOptions{
+		WorkflowID: "dunning-" + bill.ID,
+	})
+	w.dunning = workflow.ExecuteChildWorkflow(dunningCtx, DunningWorkflow, &DunningWorkflowParams{
+		BillID:     bill.ID,
+		CustomerID: bill.CustomerID,
+		Amount:     bill.balanceDue(),
+		Currency:   bill.Currency,
+		Schedule:   w.params.DunningSchedule,
+	})
+	w.cancelDunning = cancel
+	logger.Info("Dunning started for bill", "bill_id", bill.ID, "steps", len(w.params.DunningSchedule))
+}
+
+// finishDunning applies the outcome of the bill's DunningWorkflow.
+func (w *billWorkflow) finishDunning(f workflow.Future) {
+	logger, bill := w.logger, w.bill
+	w.dunning, w.cancelDunning = nil, nil
+
+	var result DunningResult
+	if err := f.Get(w.ctx, &result); err != nil {
+		if temporal.IsCanceledError(err) {
+			logger.Info("Dunning canceled", "bill_id", bill.ID)
+			return
+		}
+		logger.Error("DunningWorkflow failed", "bill_id", bill.ID, "error", err)
+		return
+	}
+
+	if len(result.Attempts) > 0 {
+		bill.Payments = append(bill.Payments, result.Attempts...)
+		for _, p := range result.Attempts {
+			if p.Status == PaymentStatusSucceeded {
+				bill.allocatePayment(p)
+			}
+		}
+		w.touch()
+	}
+	switch result.Status {
+	case DunningStatusRecovered:
+		w.setStatus(BillStatusPaid)
+	case DunningStatusDelinquent:
+		w.setStatus(BillStatusDelinquent)
+	}
+}
@@ -0,0 +1,402 @@
+package fees
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EmailProvider names the service that sends email.
+type EmailProvider string
+
+const (
+	// EmailProviderLog writes emails to the structured log instead of sending them.
+	EmailProviderLog      EmailProvider = "log"
+	EmailProviderSMTP     EmailProvider = "smtp"
+	EmailProviderSES      EmailProvider = "ses"
+	EmailProviderSendGrid EmailProvider = "sendgrid"
+)
+
+const (
+	// defaultSendGridEndpoint is SendGrid's v3 mail send API.
+	defaultSendGridEndpoint = "https://api.sendgrid.com/v3/mail/send"
+	// emailSendTimeout bounds one call to the email provider's HTTP API.
+	emailSendTimeout = 30 * time.Second
+)
+
+// EmailRejectedErrorType is the application error type of emails the provider refused,
+// such as for an invalid recipient. They are not retried.
+const EmailRejectedErrorType = "EmailRejected"
+
+// Email is a message to send.
+type Email struct {
+	From    mail.Address
+	To      []string
+	Cc      []string
+	Subject string
+	// Text and HTML are alternative bodies; mail clients show the HTML one if they can.
+	Text        string
+	HTML        string
+	Attachments []EmailAttachment
+}
+
+// EmailAttachment is a file attached to an Email.
+type EmailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// EmailRejectedError is returned by an EmailSender when the provider refuses an email
+// for good, so sending it again would fail the same way.
+type EmailRejectedError struct {
+	Reason string
+}
+
+func (e *EmailRejectedError) Error() string {
+	return "email rejected: " + e.Reason
+}
+
+// EmailSender sends emails. Implementations wrap an email provider and return the
+// provider's ID of each message sent.
+type EmailSender interface {
+	Send(ctx context.Context, e *Email) (string, error)
+}
+
+// LogEmailSender is an EmailSender that writes emails to the structured log.
+type LogEmailSender struct{}
+
+// Send implements EmailSender.
+func (LogEmailSender) Send(ctx context.Context, e *Email) (string, error) {
+	id := uuid.NewString()
+	loggerFrom(ctx).Info("Email", "message_id", id, "to", e.To, "cc", e.Cc, "subject", e.Subject, "attachments", len(e.Attachments))
+	return id, nil
+}
+
+// SMTPSender sends emails through an SMTP server, upgrading the connection with
+// STARTTLS when the server offers it.
+type SMTPSender struct {
+	// Addr is the server's host and port, such as "smtp.example.com:587".
+	Addr string
+	// Username and Password authenticate with PLAIN auth; empty sends unauthenticated.
+	Username string
+	Password string
+	// Clock dates the messages; nil uses the wall clock.
+	Clock Clock
+}
+
+// Send implements EmailSender. The message ID is the Message-ID header of the email.
+func (s *SMTPSender) Send(ctx context.Context, e *Email) (string, error) {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid SMTP address %q: %w", s.Addr, err)
+	}
+	messageID := uuid.NewString() + "@" + host
+	clock := s.Clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+	msg, err := buildMIMEMessage(e, messageID, clock.Now())
+	if err != nil {
+		return "", err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	recipients := append(append([]string{}, e.To...), e.Cc...)
+	if err := smtp.SendMail(s.Addr, auth, e.From.Address, recipients, msg); err != nil {
+		// 5xx replies are permanent failures, such as a mailbox that does not exist.
+		var reply *textproto.Error
+		if errors.As(err, &reply) && reply.Code >= 500 {
+			return "", &EmailRejectedError{Reason: reply.Error()}
+		}
+		return "", fmt.Errorf("failed to send email through %s: %w", s.Addr, err)
+	}
+	return messageID, nil
+}
+
+// newSESSender returns a sender for Amazon SES, through its SMTP interface in region
+// with SMTP credentials created for SES.
+func newSESSender(region, username, password string, clock Clock) *SMTPSender {
+	return &SMTPSender{Addr: "email-smtp." + region + ".amazonaws.com:587", Username: username, Password: password, Clock: clock}
+}
+
+// buildMIMEMessage formats e as a MIME message: its text and HTML bodies as
+// alternatives, followed by its attachments.
+func buildMIMEMessage(e *Email, messageID string, date time.Time) ([]byte, error) {
+	var alt bytes.Buffer
+	altWriter := multipart.NewWriter(&alt)
+	for _, body := range []struct{ contentType, text string }{
+		{"text/plain; charset=utf-8", e.Text},
+		{"text/html; charset=utf-8", e.HTML},
+	} {
+		if body.text == "" {
+			continue
+		}
+		part, err := altWriter.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {body.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(part)
+		if _, err := io.WriteString(qp, body.text); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := altWriter.Close(); err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	mixed := multipart.NewWriter(&body)
+	part, err := mixed.CreatePart(textproto.MIMEHeader{"Content-Type": {"multipart/alternative; boundary=" + altWriter.Boundary()}})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(alt.Bytes()); err != nil {
+		return nil, err
+	}
+	for _, a := range e.Attachments {
+		part, err := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(a.ContentType, map[string]string{"name": a.Filename})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(a.Data)
+		for len(encoded) > 76 {
+			fmt.Fprintf(part, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(part, "%s\r\n", encoded)
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&msg, "%s: %s\r\n", name, value)
+	}
+	header("From", e.From.String())
+	header("To", strings.Join(e.To, ", "))
+	if len(e.Cc) > 0 {
+		header("Cc", strings.Join(e.Cc, ", "))
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", e.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", "<"+messageID+">")
+	header("MIME-Version", "1.0")
+	header("Content-Type", "multipart/mixed; boundary="+mixed.Boundary())
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// SendGridSender sends emails through SendGrid's mail send API.
+type SendGridSender struct {
+	APIKey string
+	// Endpoint is the mail send URL; empty uses SendGrid's.
+	Endpoint string
+	Client   *http.Client
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridMessage struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Attachments      []sendGridAttachment      `json:"attachments,omitempty"`
+}
+
+type sendGridPersonalization struct {
+	To []sendGridAddress `json:"to"`
+	Cc []sendGridAddress `json:"cc,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridAttachment struct {
+	Content     string `json:"content"`
+	Filename    string `json:"filename"`
+	Type        string `json:"type"`
+	Disposition string `json:"disposition"`
+}
+
+// Send implements EmailSender. The message ID is SendGrid's X-Message-Id.
+func (s *SendGridSender) Send(ctx context.Context, e *Email) (string, error) {
+	addresses := func(emails []string) []sendGridAddress {
+		out := make([]sendGridAddress, len(emails))
+		for i, email := range emails {
+			out[i] = sendGridAddress{Email: email}
+		}
+		return out
+	}
+	msg := sendGridMessage{
+		Personalizations: []sendGridPersonalization{{To: addresses(e.To), Cc: addresses(e.Cc)}},
+		From:             sendGridAddress{Email: e.From.Address, Name: e.From.Name},
+		Subject:          e.Subject,
+	}
+	// SendGrid wants the text body before the HTML one.
+	if e.Text != "" {
+		msg.Content = append(msg.Content, sendGridContent{Type: "text/plain", Value: e.Text})
+	}
+	if e.HTML != "" {
+		msg.Content = append(msg.Content, sendGridContent{Type: "text/html", Value: e.HTML})
+	}
+	for _, a := range e.Attachments {
+		msg.Attachments = append(msg.Attachments, sendGridAttachment{
+			Content: base64.StdEncoding.EncodeToString(a.Data), Filename: a.Filename, Type: a.ContentType, Disposition: "attachment",
+		})
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = defaultSendGridEndpoint
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: emailSendTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send email through SendGrid: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return resp.Header.Get("X-Message-Id"), nil
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	// Throttling and server errors pass; other client errors do not.
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return "", fmt.Errorf("SendGrid returned %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	return "", &EmailRejectedError{Reason: fmt.Sprintf("SendGrid returned %s: %s", resp.Status, bytes.TrimSpace(detail))}
+}
+
+// EmailConfig says how emails to customers are sent.
+type EmailConfig struct {
+	// Provider sends the emails: log (the default), smtp, ses, or sendgrid.
+	Provider EmailProvider
+	// From is the sender of the emails, such as "Billing <billing@example.com>".
+	From mail.Address
+	// SMTPAddr, SMTPUsername, and SMTPPassword reach the smtp provider. The ses
+	// provider uses the username and password as SES SMTP credentials.
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	// SESRegion is the AWS region of the ses provider, such as "eu-west-1".
+	SESRegion string
+	// SendGridAPIKey authenticates with the sendgrid provider.
+	SendGridAPIKey string
+}
+
+// loadEmailConfig reads FEES_EMAIL_* and the settings of the provider it names.
+func loadEmailConfig() (EmailConfig, error) {
+	cfg := EmailConfig{Provider: EmailProviderLog}
+	if v := os.Getenv("FEES_EMAIL_PROVIDER"); v != "" {
+		cfg.Provider = EmailProvider(v)
+	}
+	from := os.Getenv("FEES_EMAIL_FROM")
+	if from == "" && cfg.Provider != EmailProviderLog {
+		return EmailConfig{}, fmt.Errorf("FEES_EMAIL_FROM must be set with FEES_EMAIL_PROVIDER=%s", cfg.Provider)
+	}
+	if from != "" {
+		addr, err := mail.ParseAddress(from)
+		if err != nil {
+			return EmailConfig{}, fmt.Errorf("invalid FEES_EMAIL_FROM %q: %w", from, err)
+		}
+		cfg.From = *addr
+	}
+
+	var err error
+	switch cfg.Provider {
+	case EmailProviderLog:
+	case EmailProviderSMTP:
+		cfg.SMTPAddr = os.Getenv("FEES_SMTP_ADDR")
+		if _, _, err := net.SplitHostPort(cfg.SMTPAddr); err != nil {
+			return EmailConfig{}, fmt.Errorf("invalid FEES_SMTP_ADDR %q: must be a host and port such as \"smtp.example.com:587\"", cfg.SMTPAddr)
+		}
+		cfg.SMTPUsername = os.Getenv("FEES_SMTP_USERNAME")
+		if cfg.SMTPPassword, err = secretFromEnv("FEES_SMTP_PASSWORD", "SMTP password"); err != nil {
+			return EmailConfig{}, err
+		}
+	case EmailProviderSES:
+		if cfg.SESRegion = os.Getenv("FEES_SES_REGION"); cfg.SESRegion == "" {
+			return EmailConfig{}, fmt.Errorf("FEES_SES_REGION must be set with FEES_EMAIL_PROVIDER=ses")
+		}
+		cfg.SMTPUsername = os.Getenv("FEES_SMTP_USERNAME")
+		if cfg.SMTPPassword, err = secretFromEnv("FEES_SMTP_PASSWORD", "SES SMTP password"); err != nil {
+			return EmailConfig{}, err
+		}
+		if cfg.SMTPUsername == "" || cfg.SMTPPassword == "" {
+			return EmailConfig{}, fmt.Errorf("FEES_SMTP_USERNAME and FEES_SMTP_PASSWORD must be set with FEES_EMAIL_PROVIDER=ses")
+		}
+	case EmailProviderSendGrid:
+		if cfg.SendGridAPIKey, err = secretFromEnv("FEES_SENDGRID_API_KEY", "SendGrid API key"); err != nil {
+			return EmailConfig{}, err
+		}
+		if cfg.SendGridAPIKey == "" {
+			return EmailConfig{}, fmt.Errorf("FEES_SENDGRID_API_KEY must be set with FEES_EMAIL_PROVIDER=sendgrid")
+		}
+	default:
+		return EmailConfig{}, fmt.Errorf("invalid FEES_EMAIL_PROVIDER %q: must be log, smtp, ses, or sendgrid", cfg.Provider)
+	}
+	return cfg, nil
+}
+
+// sender returns the EmailSender of the configured provider, dating its messages by clock.
+func (c EmailConfig) sender(clock Clock) EmailSender {
+	switch c.Provider {
+	case EmailProviderSMTP:
+		return &SMTPSender{Addr: c.SMTPAddr, Username: c.SMTPUsername, Password: c.SMTPPassword, Clock: clock}
+	case EmailProviderSES:
+		return newSESSender(c.SESRegion, c.SMTPUsername, c.SMTPPassword, clock)
+	case EmailProviderSendGrid:
+		return &SendGridSender{APIKey: c.SendGridAPIKey}
+	}
+	return LogEmailSender{}
+}
@@ -0,0 +1,106 @@
+package fees
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testEmail() *Email {
+	return &Email{
+		From:        mail.Address{Name: "Billing", Address: "billing@example.com"},
+		To:          []string{"ap@example.com"},
+		Cc:          []string{"cfo@example.com"},
+		Subject:     "Bill CUST-2024-00001: 1.234,50\u00a0€",
+		Text:        "Total: 1.234,50\u00a0€\n",
+		HTML:        "<p>Total: 1.234,50&nbsp;€</p>",
+		Attachments: []EmailAttachment{{Filename: "bill.pdf", ContentType: "application/pdf", Data: bytes.Repeat([]byte("%PDF-1.4"), 20)}},
+	}
+}
+
+// TestBuildMIMEMessage tests that a message parses back into its headers, alternative
+// bodies, and attachments.
+func TestBuildMIMEMessage(t *testing.T) {
+	e := testEmail()
+	raw, err := buildMIMEMessage(e, "d1@feems", time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	require.NoError(t, err)
+	require.Equal(t, `"Billing" <billing@example.com>`, msg.Header.Get("From"))
+	require.Equal(t, "ap@example.com", msg.Header.Get("To"))
+	require.Equal(t, "cfo@example.com", msg.Header.Get("Cc"))
+	require.Equal(t, "<d1@feems>", msg.Header.Get("Message-ID"))
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	require.Equal(t, e.Subject, subject)
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/mixed", mediaType)
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+
+	alt, err := parts.NextPart()
+	require.NoError(t, err)
+	_, altParams, err := mime.ParseMediaType(alt.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	bodies := multipart.NewReader(alt, altParams["boundary"])
+	for _, want := range []string{e.Text, e.HTML} {
+		// The multipart reader decodes quoted-printable parts, whose line breaks are CRLF.
+		body, err := bodies.NextPart()
+		require.NoError(t, err)
+		b, err := io.ReadAll(body)
+		require.NoError(t, err)
+		require.Equal(t, want, strings.ReplaceAll(string(b), "\r\n", "\n"))
+	}
+
+	attachment, err := parts.NextPart()
+	require.NoError(t, err)
+	require.Equal(t, "bill.pdf", attachment.FileName())
+	_, err = parts.NextPart()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+// TestSendGridSender tests that SendGrid's responses decide whether an email was sent,
+// is worth sending again, or was rejected.
+func TestSendGridSender(t *testing.T) {
+	status := http.StatusAccepted
+	var got sendGridMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.Header().Set("X-Message-Id", "sg-1")
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	sender := &SendGridSender{APIKey: "key", Endpoint: server.URL}
+
+	id, err := sender.Send(context.Background(), testEmail())
+	require.NoError(t, err)
+	require.Equal(t, "sg-1", id)
+	require.Equal(t, "ap@example.com", got.Personalizations[0].To[0].Email)
+	require.Equal(t, "text/plain", got.Content[0].Type)
+	require.Equal(t, "bill.pdf", got.Attachments[0].Filename)
+
+	var rejected *EmailRejectedError
+	status = http.StatusBadRequest
+	_, err = sender.Send(context.Background(), testEmail())
+	require.True(t, errors.As(err, &rejected))
+
+	status = http.StatusServiceUnavailable
+	_, err = sender.Send(context.Background(), testEmail())
+	require.Error(t, err)
+	require.False(t, errors.As(err, &rejected))
+}
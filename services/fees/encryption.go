@@ -0,0 +1,92 @@
+package fees
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// encryptionKeySize is the size of AES-256 keys.
+const encryptionKeySize = 32
+
+// EncryptionKey is an AES-256 key, named so that what it encrypted can say which key
+// to decrypt it with.
+type EncryptionKey struct {
+	ID  string
+	Key []byte
+}
+
+// parseEncryptionKeys parses "<id>:<base64 key>,..." into keys. The first key encrypts
+// new data; the others only decrypt data written before a rotation.
+func parseEncryptionKeys(v string) ([]EncryptionKey, error) {
+	var keys []EncryptionKey
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(v, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid key entry: must be <id>:<base64 key>")
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("key %s is listed twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != encryptionKeySize {
+			return nil, fmt.Errorf("key %s must be %d bytes, base64-encoded", id, encryptionKeySize)
+		}
+		seen[id] = true
+		keys = append(keys, EncryptionKey{ID: id, Key: key})
+	}
+	return keys, nil
+}
+
+// secretFromEnv reads a secret from the environment variable name, or from the file
+// named by name_FILE, where a KMS or secret manager can place it. what names the
+// secret in errors.
+func secretFromEnv(name, what string) (string, error) {
+	v := os.Getenv(name)
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		if v != "" {
+			return "", fmt.Errorf("%s and %s_FILE cannot both be set", name, name)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", what, err)
+		}
+		v = strings.TrimSpace(string(b))
+	}
+	return v, nil
+}
+
+// loadEncryptionKeys reads keys from the environment variable name or the file named
+// by name_FILE; see secretFromEnv. Neither set returns no keys.
+func loadEncryptionKeys(name, what string) ([]EncryptionKey, error) {
+	v, err := secretFromEnv(name, what)
+	if err != nil || v == "" {
+		return nil, err
+	}
+	keys, err := parseEncryptionKeys(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", what, err)
+	}
+	return keys, nil
+}
+
+// newAEADs returns an AES-GCM cipher for each of keys, by key ID.
+func newAEADs(keys []EncryptionKey) (map[string]cipher.AEAD, error) {
+	aeads := make(map[string]cipher.AEAD, len(keys))
+	for _, k := range keys {
+		block, err := aes.NewCipher(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %w", k.ID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %w", k.ID, err)
+		}
+		aeads[k.ID] = aead
+	}
+	return aeads, nil
+}
@@ -0,0 +1,702 @@
+package fees
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/storage/objects"
+	"encore.dev/storage/sqldb"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// ErasureMode says how a customer's bills are erased.
+type ErasureMode string
+
+const (
+	// ErasureAnonymize keeps the bills and their amounts for accounting, but replaces
+	// the customer's ID with a pseudonym and removes the free text, attachments, and
+	// comments they hold.
+	ErasureAnonymize ErasureMode = "anonymize"
+	// ErasureDelete deletes the bills with everything recorded about them.
+	ErasureDelete ErasureMode = "delete"
+)
+
+// IsValid reports whether m is a known erasure mode.
+func (m ErasureMode) IsValid() bool {
+	return m == ErasureAnonymize || m == ErasureDelete
+}
+
+const (
+	// RetentionSweepScheduleID names the Temporal Schedule that starts RetentionSweepWorkflow.
+	RetentionSweepScheduleID = "retention-sweep"
+	// PurgeExpiredBillsActivityName deletes one batch of bills past the retention period.
+	PurgeExpiredBillsActivityName = "PurgeExpiredBillsActivity"
+
+	// defaultRetentionSweepSchedule runs the retention sweep nightly at 03:00 UTC, after
+	// the close sweep.
+	defaultRetentionSweepSchedule = "0 3 * * *"
+	// erasureBatchSize is how many bills are erased per transaction.
+	erasureBatchSize = 100
+	// redactedText replaces the free text of anonymized bills.
+	redactedText = "Redacted"
+)
+
+// settledBillCondition matches bills nothing more is expected of: paid, or closed with
+// nothing to pay, and with no payment charged back and undecided. A charged-back bill
+// stays PAID, but its DisputeWorkflow still writes to it.
+const settledBillCondition = `((status = 'PAID' OR (status = 'CLOSED' AND total_amount <= 0))
+    AND NOT EXISTS (SELECT 1 FROM payments p WHERE p.bill_id = bills.id AND p.status = 'DISPUTED')
+    AND NOT EXISTS (SELECT 1 FROM disputes d WHERE d.bill_id = bills.id AND d.status IN ('NEEDS_EVIDENCE', 'EVIDENCE_SUBMITTED', 'EVIDENCE_OVERDUE')))`
+
+// ErasureCertificate records that a customer's data was erased. It names the customer
+// only by SubjectHash, so keeping it does not undo the erasure.
+type ErasureCertificate struct {
+	ID       string `json:"id"`
+	TenantID string `json:"tenantId"`
+	// SubjectHash is the hex SHA-256 of "<tenant>/<customer>". Hashing the customer ID
+	// again shows whether a certificate covers them.
+	SubjectHash string      `json:"subjectHash"`
+	Mode        ErasureMode `json:"mode"`
+	// Bills and LineItems count the bills and line items erased, and Workflows the
+	// workflow runs deleted with them.
+	Bills     int `json:"bills"`
+	LineItems int `json:"lineItems"`
+	Workflows int `json:"workflows"`
+	// RequestedByKeyID is the API key that requested the erasure.
+	RequestedByKeyID string    `json:"requestedByKeyId,omitempty"`
+	StartedAt        time.Time `json:"startedAt"`
+	CompletedAt      time.Time `json:"completedAt"`
+}
+
+// EraseCustomerDataRequest is the request payload for erasing a customer's data.
+type EraseCustomerDataRequest struct {
+	// TenantID identifies the platform the customer belongs to. Tenant-scoped API keys
+	// always erase customers of their own tenant.
+	TenantID string `header:"X-Tenant-ID"`
+	// Mode is anonymize (the default) or delete.
+	Mode ErasureMode `query:"mode"`
+	// Force also erases bills that are not settled yet, ending their workflows.
+	Force bool `query:"force"`
+}
+
+// ErasureResponse is the response payload for a customer data erasure.
+type ErasureResponse struct {
+	RetryMetadata
+	Certificate ErasureCertificate `json:"certificate"`
+}
+
+// erasureSubjectHash hashes a customer's ID for their erasure certificate.
+func erasureSubjectHash(tenantID, customerID string) string {
+	sum := sha256.Sum256([]byte(tenantOrDefault(tenantID) + "/" + customerID))
+	return hex.EncodeToString(sum[:])
+}
+
+// erasedCustomerID is the pseudonym that replaces the customer ID of anonymized bills.
+func erasedCustomerID(erasureID string) string {
+	return "erased-" + erasureID
+}
+
+// ------ Erasing bills ------
+
+// eraser erases bills, and the workflows that ran them, for customer erasures and the
+// retention sweep.
+type eraser struct {
+	db        *tracedDB
+	temporal  client.Client
+	namespace string
+}
+
+// erasedBills counts what erasing bills removed.
+type erasedBills struct {
+	Bills     int
+	LineItems int
+	Workflows int
+}
+
+func (e *erasedBills) add(o erasedBills) {
+	e.Bills += o.Bills
+	e.LineItems += o.LineItems
+	e.Workflows += o.Workflows
+}
+
+// erase erases billIDs in mode, replacing the customer ID of anonymized bills with
+// pseudonym. Workflows and attachment objects go first and the rows last, so an
+// erasure that fails halfway finds the same bills again when retried.
+func (e *eraser) erase(ctx context.Context, billIDs []string, mode ErasureMode, pseudonym string) (erasedBills, error) {
+	erased := erasedBills{Bills: len(billIDs)}
+	workflowIDs, err := e.workflowIDs(ctx, billIDs)
+	if err != nil {
+		return erased, err
+	}
+	for _, id := range workflowIDs {
+		n, err := e.deleteWorkflow(ctx, id)
+		if err != nil {
+			return erased, err
+		}
+		erased.Workflows += n
+	}
+	if err := e.removeAttachments(ctx, billIDs); err != nil {
+		return erased, err
+	}
+
+	err = e.db.inTx(ctx, func(tx *tracedTx) (err error) {
+		// Lets the transaction change the append-only audit and event logs.
+		if _, err := tx.Exec(ctx, `SET LOCAL fees.erasure = 'on'`); err != nil {
+			return fmt.Errorf("failed to start erasure: %w", err)
+		}
+		if mode == ErasureDelete {
+			erased.LineItems, err = deleteBills(ctx, tx, billIDs)
+		} else {
+			erased.LineItems, err = anonymizeBills(ctx, tx, billIDs, pseudonym)
+		}
+		return err
+	})
+	return erased, err
+}
+
+// workflowIDs returns the IDs of the workflows that ran billIDs: their own, their
+// dunning, their payment and refund workflows, and the dispute workflows of their
+// chargebacks, whose histories hold the customer ID and the provider's reason.
+func (e *eraser) workflowIDs(ctx context.Context, billIDs []string) ([]string, error) {
+	var ids []string
+	for _, billID := range billIDs {
+		ids = append(ids, billWorkflowID(billID), "dunning-"+billID)
+	}
+	rows, err := e.db.Query(ctx, `
+        SELECT 'payment-' || id FROM payments WHERE bill_id = ANY($1::text[])
+        UNION ALL
+        SELECT 'refund-' || id FROM credit_notes WHERE bill_id = ANY($1::text[])
+        UNION ALL
+        SELECT 'dispute-' || id FROM disputes WHERE bill_id = ANY($1::text[])
+    `, billIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find payment workflows: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to read payment workflow ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// deleteWorkflow deletes the history of every run of the workflow with ID id, which
+// terminates a run still going. It returns how many runs it deleted.
+func (e *eraser) deleteWorkflow(ctx context.Context, id string) (int, error) {
+	request := &workflowservice.ListWorkflowExecutionsRequest{
+		Namespace: e.namespace,
+		Query:     fmt.Sprintf("WorkflowId = '%s'", id),
+	}
+	var runs []*commonpb.WorkflowExecution
+	for {
+		resp, err := e.temporal.ListWorkflow(ctx, request)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list runs of workflow %s: %w", id, err)
+		}
+		for _, info := range resp.GetExecutions() {
+			runs = append(runs, info.GetExecution())
+		}
+		if len(resp.GetNextPageToken()) == 0 {
+			break
+		}
+		request.NextPageToken = resp.GetNextPageToken()
+	}
+
+	deleted := 0
+	for _, run := range runs {
+		_, err := e.temporal.WorkflowService().DeleteWorkflowExecution(ctx, &workflowservice.DeleteWorkflowExecutionRequest{
+			Namespace:         e.namespace,
+			WorkflowExecution: run,
+		})
+		var notFound *serviceerror.NotFound
+		if errors.As(err, &notFound) {
+			continue
+		}
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete run %s of workflow %s: %w", run.GetRunId(), id, err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// removeAttachments removes the objects holding the attachments of billIDs. Their
+// rows go with the bills.
+func (e *eraser) removeAttachments(ctx context.Context, billIDs []string) error {
+	rows, err := e.db.Query(ctx, `SELECT bill_id, id FROM bill_attachments WHERE bill_id = ANY($1::text[])`, billIDs)
+	if err != nil {
+		return fmt.Errorf("failed to find attachments: %w", err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var billID, id string
+		if err := rows.Scan(&billID, &id); err != nil {
+			return fmt.Errorf("failed to read attachment: %w", err)
+		}
+		names = append(names, attachmentObject(billID, id))
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to find attachments: %w", err)
+	}
+	for _, name := range names {
+		if err := attachmentBucket.Remove(ctx, name); err != nil && !errors.Is(err, objects.ErrObjectNotFound) {
+			return fmt.Errorf("failed to remove attachment object %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// deleteBills deletes billIDs with everything recorded about them, and returns how
+// many line items it deleted. Line items, payments, credit notes, attachments, and
+// comments go with their bill.
+func deleteBills(ctx context.Context, tx *tracedTx, billIDs []string) (int, error) {
+	for _, table := range []string{"event_outbox", "bill_events", "bill_audit_log", "ledger_journal_entries", "temporal_outbox", "line_item_events"} {
+		if _, err := tx.Exec(ctx, `DELETE FROM `+table+` WHERE bill_id = ANY($1::text[])`, billIDs); err != nil {
+			return 0, fmt.Errorf("failed to delete %s of bills: %w", table, err)
+		}
+	}
+	result, err := tx.Exec(ctx, `DELETE FROM line_items WHERE bill_id = ANY($1::text[])`, billIDs)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete line items: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM bills WHERE id = ANY($1::text[])`, billIDs); err != nil {
+		return 0, fmt.Errorf("failed to delete bills: %w", err)
+	}
+	return int(result.RowsAffected()), nil
+}
+
+// anonymizeBills replaces the customer ID of billIDs with pseudonym, also in their
+// numbers, and removes the free text they hold, and returns how many line items it redacted. Amounts, dates,
+// and statuses are kept, so the bills still add up in reports and the ledger. Audit
+// log snapshots are replaced by a redaction marker, as is dispute evidence; bill events keep their amounts,
+// so the bills can still be rebuilt from them. Attachments, comments, and the record of
+// the bills' emails are deleted.
+func anonymizeBills(ctx context.Context, tx *tracedTx, billIDs []string, pseudonym string) (int, error) {
+	if _, err := tx.Exec(ctx, `
+        UPDATE bills SET customer_id = $2, approval = approval - 'reason', number = `+pseudonymNumber("number")+`
+        WHERE id = ANY($1::text[])
+    `, billIDs, pseudonym); err != nil {
+		return 0, fmt.Errorf("failed to anonymize bills: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+        UPDATE bill_summaries SET customer_id = $2, number = `+pseudonymNumber("number")+` WHERE bill_id = ANY($1::text[])
+    `, billIDs, pseudonym); err != nil {
+		return 0, fmt.Errorf("failed to anonymize bill summaries: %w", err)
+	}
+	result, err := tx.Exec(ctx, `
+        UPDATE line_items SET description = $2, client_reference = NULL WHERE bill_id = ANY($1::text[])
+    `, billIDs, redactedText)
+	if err != nil {
+		return 0, fmt.Errorf("failed to redact line items: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+        UPDATE line_item_events SET description = $2, client_reference = NULL WHERE bill_id = ANY($1::text[])
+    `, billIDs, redactedText); err != nil {
+		return 0, fmt.Errorf("failed to redact line item events: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+        UPDATE ledger_journal_entries SET customer_id = $2 WHERE bill_id = ANY($1::text[]) AND customer_id IS NOT NULL
+    `, billIDs, pseudonym); err != nil {
+		return 0, fmt.Errorf("failed to anonymize ledger entries: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+        UPDATE disputes SET customer_id = $2,
+            evidence = CASE WHEN evidence IS NULL THEN NULL ELSE evidence || jsonb_build_object('text', $3::text) END
+        WHERE bill_id = ANY($1::text[])
+    `, billIDs, pseudonym, redactedText); err != nil {
+		return 0, fmt.Errorf("failed to anonymize disputes: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+        UPDATE bill_events SET data = ((data
+            || CASE WHEN data ? 'customerId' THEN jsonb_build_object('customerId', $2::text) ELSE '{}' END
+            || CASE WHEN data ? 'number' THEN jsonb_build_object('number', `+pseudonymNumber("data->>'number'")+`) ELSE '{}' END
+            || CASE WHEN data ? 'lineItem'
+                THEN jsonb_build_object('lineItem', (data->'lineItem' - 'clientReference') || jsonb_build_object('description', $3::text))
+                ELSE '{}' END
+            || CASE WHEN data ? 'creditNote'
+                THEN jsonb_build_object('creditNote', (data->'creditNote' - 'reason') || jsonb_build_object('lineItems', COALESCE(
+                    (SELECT jsonb_agg((li.item - 'clientReference') || jsonb_build_object('description', $3::text))
+                     FROM jsonb_array_elements(CASE WHEN jsonb_typeof(data->'creditNote'->'lineItems') = 'array'
+                         THEN data->'creditNote'->'lineItems' ELSE '[]' END) AS li(item)), '[]')))
+                ELSE '{}' END
+        ) #- '{approval,reason}')
+        WHERE bill_id = ANY($1::text[])
+    `, billIDs, pseudonym, redactedText); err != nil {
+		return 0, fmt.Errorf("failed to redact bill events: %w", err)
+	}
+	for _, query := range []string{
+		`UPDATE credit_notes SET reason = '' WHERE bill_id = ANY($1::text[])`,
+		`UPDATE bill_audit_log SET before = CASE WHEN before IS NULL THEN NULL ELSE '{"redacted": true}'::jsonb END, after = '{"redacted": true}' WHERE bill_id = ANY($1::text[])`,
+		`DELETE FROM bill_attachments WHERE bill_id = ANY($1::text[])`,
+		`DELETE FROM bill_comments WHERE bill_id = ANY($1::text[])`,
+		`DELETE FROM bill_deliveries WHERE bill_id = ANY($1::text[])`,
+		`DELETE FROM temporal_outbox WHERE bill_id = ANY($1::text[])`,
+	} {
+		if _, err := tx.Exec(ctx, query, billIDs); err != nil {
+			return 0, fmt.Errorf("failed to anonymize bills: %w", err)
+		}
+	}
+	return int(result.RowsAffected()), nil
+}
+
+// pseudonymNumber is the SQL for the bill number in column with its customer ID
+// replaced by the pseudonym in $2. The year and sequence are kept.
+func pseudonymNumber(column string) string {
+	return `$2::text || substring(` + column + ` from '-[0-9]{4}-[0-9]+$')`
+}
+
+// eraseCustomerRecords erases what is kept about a customer apart from their bills:
+// their credit, accrual snapshots and coupon redemptions, which are anonymized or
+// deleted like the bills, the coupons restricted to them, which name the pseudonym
+// instead, and their bill limits, spending alerts, contracts, contact, and bill number
+// sequences, which are deleted. Only the records of tenantID are touched: the same
+// customer ID in another tenant is another customer.
+func eraseCustomerRecords(ctx context.Context, db *tracedDB, tenantID, customerID string, mode ErasureMode, pseudonym string) error {
+	return db.inTx(ctx, func(tx *tracedTx) (err error) {
+		if mode == ErasureDelete {
+			_, err = tx.Exec(ctx, `DELETE FROM customer_credit_entries WHERE tenant_id = $1 AND customer_id = $2`, tenantID, customerID)
+			if err == nil {
+				_, err = tx.Exec(ctx, `DELETE FROM customer_credit_balances WHERE tenant_id = $1 AND customer_id = $2`, tenantID, customerID)
+			}
+		} else {
+			_, err = tx.Exec(ctx, `
+                UPDATE customer_credit_entries SET customer_id = $3, description = NULL WHERE tenant_id = $1 AND customer_id = $2
+            `, tenantID, customerID, pseudonym)
+			if err == nil {
+				_, err = tx.Exec(ctx, `
+                    UPDATE customer_credit_balances SET customer_id = $3 WHERE tenant_id = $1 AND customer_id = $2
+                `, tenantID, customerID, pseudonym)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to erase credit: %w", err)
+		}
+		if mode == ErasureDelete {
+			_, err = tx.Exec(ctx, `DELETE FROM accrual_snapshots WHERE tenant_id = $1 AND customer_id = $2`, tenantID, customerID)
+		} else {
+			_, err = tx.Exec(ctx, `
+                UPDATE accrual_snapshots SET customer_id = $3 WHERE tenant_id = $1 AND customer_id = $2
+            `, tenantID, customerID, pseudonym)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to erase accrual snapshots: %w", err)
+		}
+		// Redemptions stay counted against their coupons either way.
+		if mode == ErasureDelete {
+			_, err = tx.Exec(ctx, `DELETE FROM coupon_redemptions WHERE tenant_id = $1 AND customer_id = $2`, tenantID, customerID)
+		} else {
+			_, err = tx.Exec(ctx, `
+                UPDATE coupon_redemptions SET customer_id = $3 WHERE tenant_id = $1 AND customer_id = $2
+            `, tenantID, customerID, pseudonym)
+		}
+		if err == nil {
+			// Dropping the customer could leave a coupon with no customers, which would
+			// open it to all of them, so the pseudonym takes their place.
+			_, err = tx.Exec(ctx, `
+                UPDATE coupons SET customer_ids = array_replace(customer_ids, $2, $3)
+                WHERE tenant_id = $1 AND $2 = ANY(customer_ids)
+            `, tenantID, customerID, pseudonym)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to erase coupon redemptions: %w", err)
+		}
+		for _, table := range []string{"customer_bill_limits", "customer_spending_alerts", "customer_contracts", "customer_contacts"} {
+			if _, err := tx.Exec(ctx, `DELETE FROM `+table+` WHERE tenant_id = $1 AND customer_id = $2`, tenantID, customerID); err != nil {
+				return fmt.Errorf("failed to delete %s: %w", table, err)
+			}
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM bill_number_sequences WHERE tenant_id = $1 AND customer_id = $2`, tenantID, customerID); err != nil {
+			return fmt.Errorf("failed to delete bill number sequences: %w", err)
+		}
+		return nil
+	})
+}
+
+// ------ API ------
+
+// EraseCustomerData erases a customer's data: their bills and line items, the
+// workflows that ran them, their credit, bill limits, spending alerts, contracts, and
+// contact. Bills are anonymized unless mode=delete. Customers with bills that are not
+// settled yet, including paid bills with an undecided chargeback, are refused unless
+// force=true, which ends those bills' workflows and their disputes'.
+// Subscriptions are not erased; cancel them first.
+//
+// The erasure is recorded in a certificate that names the customer only by a hash. A
+// retry with the same idempotency key returns the certificate of the first erasure.
+//
+// encore:api auth method=DELETE path=/customers/:customerID/data
+func (s *Service) EraseCustomerData(ctx context.Context, customerID string, params *EraseCustomerDataRequest) (*ErasureResponse, error) {
+	mode := params.Mode
+	if mode == "" {
+		mode = ErasureAnonymize
+	}
+	if !mode.IsValid() {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "invalid mode %q: must be anonymize or delete", params.Mode)
+	}
+	tenantID := requestTenant(ctx, params.TenantID)
+	erasureID := keyedID(ctx, "erasure", tenantID, customerID)
+	cert, err := loadErasure(ctx, s.db, erasureID)
+	if err == nil {
+		return &ErasureResponse{Certificate: *cert}, nil
+	}
+	if !errors.Is(err, sqldb.ErrNoRows) {
+		return nil, apierr.Wrap(err, "failed to load erasure %s", erasureID)
+	}
+
+	if !params.Force {
+		var unsettled int
+		err := s.db.QueryRow(ctx, `
+            SELECT COUNT(*) FROM bills WHERE tenant_id = $1 AND customer_id = $2 AND NOT `+settledBillCondition,
+			tenantID, customerID).Scan(&unsettled)
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to check bills of customer %s", customerID)
+		}
+		if unsettled > 0 {
+			return nil, apierr.FailedPrecondition(apierr.UnsettledBills,
+				"customer %s has %d unsettled bills; settle them first or erase with force=true", customerID, unsettled)
+		}
+	}
+
+	cert = &ErasureCertificate{
+		ID:               erasureID,
+		TenantID:         tenantID,
+		SubjectHash:      erasureSubjectHash(tenantID, customerID),
+		Mode:             mode,
+		RequestedByKeyID: callerKeyID(ctx),
+		StartedAt:        s.clock.Now(),
+	}
+	e := &eraser{db: s.db, temporal: s.temporalClient, namespace: s.cfg.Temporal.Namespace}
+	pseudonym := erasedCustomerID(erasureID)
+	var erased erasedBills
+	for {
+		// Erased bills no longer match, so every batch starts from the top.
+		ids, err := queryBillIDs(ctx, s.db, `
+            SELECT id FROM bills WHERE tenant_id = $1 AND customer_id = $2 ORDER BY id LIMIT $3
+        `, tenantID, customerID, erasureBatchSize)
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to find bills of customer %s", customerID)
+		}
+		if len(ids) == 0 {
+			break
+		}
+		batch, err := e.erase(ctx, ids, mode, pseudonym)
+		erased.add(batch)
+		if err != nil {
+			loggerFrom(ctx).Error("Customer erasure failed", "erasure_id", erasureID, "bills", erased.Bills, "error", err)
+			return nil, apierr.Wrap(err, "failed to erase bills of customer %s", customerID)
+		}
+	}
+	if err := eraseCustomerRecords(ctx, s.db, tenantID, customerID, mode, pseudonym); err != nil {
+		return nil, apierr.Wrap(err, "failed to erase customer %s", customerID)
+	}
+	// Archives outlive their bills, so they are removed by customer, including those of
+	// bills the retention sweep already deleted. The archive sweep archives anonymized
+	// bills again under their pseudonym.
+	if err := removeCustomerArchives(ctx, s.db, tenantID, customerID); err != nil {
+		return nil, apierr.Wrap(err, "failed to erase archives of customer %s", customerID)
+	}
+
+	cert.Bills, cert.LineItems, cert.Workflows = erased.Bills, erased.LineItems, erased.Workflows
+	cert.CompletedAt = s.clock.Now()
+	_, err = s.db.Exec(ctx, `
+        INSERT INTO customer_erasures (id, tenant_id, subject_hash, mode, bills, line_items, workflows, requested_by_key_id, started_at, completed_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+        ON CONFLICT (id) DO NOTHING
+    `, cert.ID, cert.TenantID, cert.SubjectHash, cert.Mode, cert.Bills, cert.LineItems, cert.Workflows,
+		nullIfEmpty(cert.RequestedByKeyID), cert.StartedAt, cert.CompletedAt)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to record erasure %s", erasureID)
+	}
+	loggerFrom(ctx).Info("Customer data erased", "erasure_id", erasureID, "mode", mode, "bills", cert.Bills, "line_items", cert.LineItems, "workflows", cert.Workflows)
+	return &ErasureResponse{Certificate: *cert}, nil
+}
+
+// GetErasure returns the certificate of a customer data erasure. Tenant-scoped callers
+// only see their own tenant's erasures.
+//
+// encore:api auth method=GET path=/erasures/:erasureID
+func (s *Service) GetErasure(ctx context.Context, erasureID string) (*ErasureCertificate, error) {
+	cert, err := loadErasure(ctx, s.db, erasureID)
+	if errors.Is(err, sqldb.ErrNoRows) || (err == nil && !visibleToCaller(ctx, cert.TenantID)) {
+		return nil, apierr.NotFound(apierr.ErasureNotFound, "erasure %s not found", erasureID)
+	}
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load erasure %s", erasureID)
+	}
+	return cert, nil
+}
+
+// loadErasure loads the certificate of an erasure.
+func loadErasure(ctx context.Context, db *tracedDB, erasureID string) (*ErasureCertificate, error) {
+	var cert ErasureCertificate
+	err := db.QueryRow(ctx, `
+        SELECT id, tenant_id, subject_hash, mode, bills, line_items, workflows,
+               COALESCE(requested_by_key_id, ''), started_at, completed_at
+        FROM customer_erasures WHERE id = $1
+    `, erasureID).Scan(&cert.ID, &cert.TenantID, &cert.SubjectHash, &cert.Mode, &cert.Bills, &cert.LineItems,
+		&cert.Workflows, &cert.RequestedByKeyID, &cert.StartedAt, &cert.CompletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// queryBillIDs runs a query selecting bill IDs.
+func queryBillIDs(ctx context.Context, db *tracedDB, query string, args ...any) ([]string, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ------ Retention sweep ------
+
+// RetentionSweepParams configures a RetentionSweepWorkflow run.
+type RetentionSweepParams struct {
+	// Years is how long settled bills are kept after they closed.
+	Years int
+	// RequireArchive only deletes bills that were archived, so they can still be read.
+	RequireArchive bool
+}
+
+// RetentionSweepResult counts what a RetentionSweepWorkflow run deleted.
+type RetentionSweepResult struct {
+	Bills     int `json:"bills"`
+	LineItems int `json:"lineItems"`
+	Workflows int `json:"workflows"`
+}
+
+// PurgeExpiredBillsActivityParams defines parameters for PurgeExpiredBillsActivity.
+type PurgeExpiredBillsActivityParams struct {
+	// Before is the retention cutoff: settled bills that closed before it are deleted.
+	Before time.Time
+	Limit  int
+	// RequireArchive skips bills that have not been archived.
+	RequireArchive bool
+}
+
+// PurgeExpiredBillsActivityResult is the outcome of one batch of the retention sweep.
+type PurgeExpiredBillsActivityResult struct {
+	Found     int
+	LineItems int
+	Workflows int
+}
+
+// RetentionSweepWorkflow deletes settled bills that closed more than params.Years ago,
+// with their line items and workflows, in batches. It is started by the retention
+// sweep schedule.
+func RetentionSweepWorkflow(ctx workflow.Context, params RetentionSweepParams) (*RetentionSweepResult, error) {
+	logger := workflow.GetLogger(ctx)
+
+	before := workflow.Now(ctx).AddDate(-params.Years, 0, 0)
+	result := &RetentionSweepResult{}
+	for {
+		var batch PurgeExpiredBillsActivityResult
+		err := workflow.ExecuteActivity(ctx, PurgeExpiredBillsActivityName, PurgeExpiredBillsActivityParams{
+			Before:         before,
+			Limit:          erasureBatchSize,
+			RequireArchive: params.RequireArchive,
+		}).Get(ctx, &batch)
+		if err != nil {
+			logger.Error("Failed to execute PurgeExpiredBillsActivity", "before", before, "error", err)
+			return result, err
+		}
+		result.Bills += batch.Found
+		result.LineItems += batch.LineItems
+		result.Workflows += batch.Workflows
+		if batch.Found < erasureBatchSize {
+			break
+		}
+	}
+	logger.Info("Retention sweep finished", "before", before, "bills", result.Bills, "line_items", result.LineItems, "workflows", result.Workflows)
+	return result, nil
+}
+
+// PurgeExpiredBillsActivity deletes up to params.Limit settled bills that closed
+// before params.Before, and were archived if params.RequireArchive is set. Deleted bills
+// no longer match, so every batch starts from the lowest bill ID.
+func (a *Activities) PurgeExpiredBillsActivity(ctx context.Context, params PurgeExpiredBillsActivityParams) (*PurgeExpiredBillsActivityResult, error) {
+	defer keepAlive(ctx)()
+	ids, err := queryBillIDs(ctx, a.DB, `
+        SELECT id FROM bills
+        WHERE `+settledBillCondition+` AND COALESCE(closed_at, created_at) < $1
+          AND ($3 = false OR EXISTS (SELECT 1 FROM bill_archives a WHERE a.bill_id = bills.id))
+        ORDER BY id
+        LIMIT $2
+    `, params.Before, params.Limit, params.RequireArchive)
+	if err != nil {
+		return nil, fmt.Errorf("PurgeExpiredBillsActivity: failed to find bills closed before %s: %w", params.Before, err)
+	}
+	if len(ids) == 0 {
+		return &PurgeExpiredBillsActivityResult{}, nil
+	}
+	e := &eraser{db: a.DB, temporal: a.Temporal, namespace: a.Namespace}
+	erased, err := e.erase(ctx, ids, ErasureDelete, "")
+	if err != nil {
+		return nil, fmt.Errorf("PurgeExpiredBillsActivity: failed to delete bills closed before %s: %w", params.Before, err)
+	}
+	return &PurgeExpiredBillsActivityResult{Found: len(ids), LineItems: erased.LineItems, Workflows: erased.Workflows}, nil
+}
+
+// ensureRetentionSweepSchedule creates the retention sweep schedule, or brings an
+// existing one in line with cfg. Unlike the close sweep, a schedule left from before
+// retention was turned off is deleted, so it does not keep purging bills.
+func ensureRetentionSweepSchedule(ctx context.Context, schedules client.ScheduleClient, cfg *Config) error {
+	if cfg.RetentionYears == 0 || cfg.RetentionSweepSchedule == closeSweepOff {
+		err := schedules.GetHandle(ctx, RetentionSweepScheduleID).Delete(ctx)
+		var notFound *serviceerror.NotFound
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return err
+	}
+	spec := client.ScheduleSpec{CronExpressions: []string{cfg.RetentionSweepSchedule}}
+	action := &client.ScheduleWorkflowAction{
+		ID:        RetentionSweepScheduleID,
+		Workflow:  RetentionSweepWorkflow,
+		Args:      []interface{}{RetentionSweepParams{Years: cfg.RetentionYears, RequireArchive: cfg.ArchiveAfterDays > 0}},
+		TaskQueue: feesTaskQueue,
+	}
+	_, err := schedules.Create(ctx, client.ScheduleOptions{
+		ID:      RetentionSweepScheduleID,
+		Spec:    spec,
+		Action:  action,
+		Overlap: enums.SCHEDULE_OVERLAP_POLICY_SKIP,
+		Note:    "Deletes settled bills past the retention period.",
+	})
+	if !errors.Is(err, temporal.ErrScheduleAlreadyRunning) {
+		return err
+	}
+	return schedules.GetHandle(ctx, RetentionSweepScheduleID).Update(ctx, client.ScheduleUpdateOptions{
+		DoUpdate: func(input client.ScheduleUpdateInput) (*client.ScheduleUpdate, error) {
+			schedule := input.Description.Schedule
+			schedule.Spec, schedule.Action = &spec, action
+			return &client.ScheduleUpdate{Schedule: &schedule}, nil
+		},
+	})
+}
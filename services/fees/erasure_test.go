@@ -0,0 +1,106 @@
+package fees
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"encore.app/apierr"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/mocks"
+)
+
+// TestErasureSubjectHash tests that certificates name customers by a hash scoped to
+// their tenant, with requests without a tenant hashed like the default tenant.
+func TestErasureSubjectHash(t *testing.T) {
+	hash := erasureSubjectHash("acme", "cust-1")
+	require.Len(t, hash, 64)
+	require.NotContains(t, hash, "cust-1")
+	require.Equal(t, hash, erasureSubjectHash("acme", "cust-1"))
+	require.NotEqual(t, hash, erasureSubjectHash("globex", "cust-1"))
+	require.Equal(t, erasureSubjectHash(DefaultTenantID, "cust-1"), erasureSubjectHash("", "cust-1"))
+
+	require.True(t, ErasureAnonymize.IsValid())
+	require.True(t, ErasureDelete.IsValid())
+	require.False(t, ErasureMode("purge").IsValid())
+}
+
+// TestEnsureRetentionSweepSchedule tests that the schedule is created with the
+// configured retention, and that turning retention off deletes a schedule left behind.
+func TestEnsureRetentionSweepSchedule(t *testing.T) {
+	schedules := mocks.NewScheduleClient(t)
+	cfg := &Config{RetentionYears: 7, RetentionSweepSchedule: defaultRetentionSweepSchedule}
+	schedules.On("Create", mock.Anything, mock.MatchedBy(func(o client.ScheduleOptions) bool {
+		action := o.Action.(*client.ScheduleWorkflowAction)
+		return o.ID == RetentionSweepScheduleID && action.Args[0] == RetentionSweepParams{Years: 7}
+	})).Return(mocks.NewScheduleHandle(t), nil).Once()
+	require.NoError(t, ensureRetentionSweepSchedule(context.Background(), schedules, cfg))
+
+	// With archiving on, only archived bills are deleted.
+	schedules = mocks.NewScheduleClient(t)
+	cfg.ArchiveAfterDays = 90
+	schedules.On("Create", mock.Anything, mock.MatchedBy(func(o client.ScheduleOptions) bool {
+		action := o.Action.(*client.ScheduleWorkflowAction)
+		return action.Args[0] == RetentionSweepParams{Years: 7, RequireArchive: true}
+	})).Return(mocks.NewScheduleHandle(t), nil).Once()
+	require.NoError(t, ensureRetentionSweepSchedule(context.Background(), schedules, cfg))
+
+	schedules = mocks.NewScheduleClient(t)
+	handle := mocks.NewScheduleHandle(t)
+	schedules.On("GetHandle", mock.Anything, RetentionSweepScheduleID).Return(handle).Twice()
+	handle.On("Delete", mock.Anything).Return(nil).Once()
+	require.NoError(t, ensureRetentionSweepSchedule(context.Background(), schedules, &Config{RetentionSweepSchedule: defaultRetentionSweepSchedule}))
+	handle.On("Delete", mock.Anything).Return(serviceerror.NewNotFound("schedule not found")).Once()
+	require.NoError(t, ensureRetentionSweepSchedule(context.Background(), schedules, &Config{RetentionYears: 7, RetentionSweepSchedule: closeSweepOff}))
+}
+
+// TestEraseCustomerData_OpenDispute tests that a paid bill with an undecided chargeback
+// holds up the erasure of its customer, and that a forced erasure ends the dispute's
+// workflow before deleting the bill.
+func TestEraseCustomerData_OpenDispute(t *testing.T) {
+	ctx := context.Background()
+	tdb := newTracedDB(db, DBConfig{})
+	tc := mocks.NewClient(t)
+	clock := newFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	svc := &Service{db: tdb, temporalClient: tc, clock: clock, cfg: &Config{}}
+
+	const tenantID = "erasure-test"
+	customerID := "cust-" + uuid.NewString()
+	billID, disputeID := uuid.NewString(), uuid.NewString()
+	_, err := tdb.Exec(ctx, `
+        INSERT INTO bills (id, customer_id, currency, status, created_at, closed_at, total_amount, tenant_id, version)
+        VALUES ($1, $2, 'USD', 'PAID', $3, $3, 10, $4, 1)
+    `, billID, customerID, clock.Now(), tenantID)
+	require.NoError(t, err)
+	_, err = tdb.Exec(ctx, `
+        INSERT INTO payments (id, bill_id, amount, currency, status, created_at) VALUES ($1, $2, 10, 'USD', 'DISPUTED', $3)
+    `, "pay-"+billID, billID, clock.Now())
+	require.NoError(t, err)
+	_, err = tdb.Exec(ctx, `
+        INSERT INTO disputes (id, bill_id, tenant_id, customer_id, payment_id, amount, currency, reason, status, evidence_due_by, opened_at)
+        VALUES ($1, $2, $3, $4, $5, 10, 'USD', 'fraudulent', 'NEEDS_EVIDENCE', $6, $7)
+    `, disputeID, billID, tenantID, customerID, "pay-"+billID, clock.Now().Add(defaultDisputeEvidenceWindow), clock.Now())
+	require.NoError(t, err)
+
+	_, err = svc.EraseCustomerData(ctx, customerID, &EraseCustomerDataRequest{TenantID: tenantID, Mode: ErasureDelete})
+	require.Equal(t, apierr.UnsettledBills, apierr.ReasonOf(err))
+
+	// The mocked Temporal has no runs left, so only the lookups are checked.
+	var listed []string
+	tc.On("ListWorkflow", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		listed = append(listed, args.Get(1).(*workflowservice.ListWorkflowExecutionsRequest).GetQuery())
+	}).Return(&workflowservice.ListWorkflowExecutionsResponse{}, nil)
+	resp, err := svc.EraseCustomerData(ctx, customerID, &EraseCustomerDataRequest{TenantID: tenantID, Mode: ErasureDelete, Force: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, resp.Certificate.Bills)
+	require.Contains(t, listed, "WorkflowId = '"+disputeWorkflowID(disputeID)+"'")
+
+	var left int
+	require.NoError(t, tdb.QueryRow(ctx, `SELECT COUNT(*) FROM disputes WHERE id = $1`, disputeID).Scan(&left))
+	require.Zero(t, left)
+}
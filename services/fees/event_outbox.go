@@ -0,0 +1,244 @@
+package fees
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"encore.dev/pubsub"
+)
+
+const (
+	// defaultEventRelayInterval is how often pending events are published unless
+	// FEES_EVENT_RELAY_INTERVAL says otherwise.
+	defaultEventRelayInterval = time.Second
+	// eventRelayBatchSize caps how many events one relay pass publishes.
+	eventRelayBatchSize = 100
+	// eventRelayMaxBackoff caps how long an event that failed to publish waits before
+	// it is tried again.
+	eventRelayMaxBackoff = 10 * time.Minute
+	// eventOutboxRetention is how long published events stay in the outbox.
+	eventOutboxRetention = 7 * 24 * time.Hour
+	// eventWebhookTimeout bounds one event webhook delivery.
+	eventWebhookTimeout = 10 * time.Second
+)
+
+// BillEventMessage is a bill event as published to the bill-events topic and the event
+// webhook. Events may be delivered more than once; EventID tells redeliveries apart.
+type BillEventMessage struct {
+	EventID  string    `json:"eventId"`
+	BillID   string    `json:"billId" pubsub-attr:"bill_id"`
+	TenantID string    `json:"tenantId"`
+	Event    BillEvent `json:"event"`
+}
+
+// BillEvents receives every bill event once the change that recorded it committed,
+// in order per bill.
+var BillEvents = pubsub.NewTopic[*BillEventMessage]("bill-events", pubsub.TopicConfig{
+	DeliveryGuarantee: pubsub.AtLeastOnce,
+	OrderingAttribute: "bill_id",
+})
+
+// EventPublisher delivers bill events from the outbox.
+type EventPublisher interface {
+	Publish(ctx context.Context, msg *BillEventMessage) error
+}
+
+// topicPublisher publishes bill events to a Pub/Sub topic.
+type topicPublisher struct {
+	topic *pubsub.Topic[*BillEventMessage]
+}
+
+func (p topicPublisher) Publish(ctx context.Context, msg *BillEventMessage) error {
+	if _, err := p.topic.Publish(ctx, msg); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", p.topic.Meta().Name, err)
+	}
+	return nil
+}
+
+// webhookPublisher POSTs bill events to a URL, signed like payment webhooks: the
+// Webhook-Signature header holds t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">.
+type webhookPublisher struct {
+	url     string
+	secret  string
+	client  *http.Client
+	clock   Clock
+	metrics *statusMetrics
+}
+
+func (p *webhookPublisher) Publish(ctx context.Context, msg *BillEventMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode event %s: %w", msg.EventID, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build event webhook request: %w", err)
+	}
+	t := p.clock.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(PaymentWebhookSignatureHeader, fmt.Sprintf("t=%d,v1=%s", t, signPaymentWebhook(p.secret, t, body)))
+
+	resp, err := p.client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			err = fmt.Errorf("event webhook returned %s", resp.Status)
+		}
+	}
+	p.metrics.recordWebhookDelivery(err == nil)
+	if err != nil {
+		return fmt.Errorf("failed to deliver event %s: %w", msg.EventID, err)
+	}
+	return nil
+}
+
+// eventRelay publishes the bill events waiting in the event outbox. recordBillEvent
+// adds them in the transaction that records the event, so an event is published if and
+// only if its change committed.
+type eventRelay struct {
+	db         *tracedDB
+	fields     *fieldCipher
+	publishers []EventPublisher
+	clock      Clock
+	interval   time.Duration
+}
+
+// run publishes pending events every interval until ctx is done.
+func (r *eventRelay) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.clock.After(r.interval):
+		}
+		if n, err := r.relay(ctx); err != nil {
+			slog.Warn("Event relay stopped", "published", n, "error", err)
+		} else if n > 0 {
+			slog.Info("Event relay published events", "published", n)
+		}
+	}
+}
+
+// pendingEvent is an outbox row with the event it publishes.
+type pendingEvent struct {
+	id       int64
+	attempts int
+	msg      BillEventMessage
+	data     []byte
+}
+
+// relay publishes the events that are due, oldest first, and returns how many it
+// published. Only one relay runs at a time across replicas. An event that fails to
+// publish is retried with backoff, and holds back the later events of its bill so each
+// bill's events are published in order.
+func (r *eventRelay) relay(ctx context.Context) (int, error) {
+	now := r.clock.Now()
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var locked bool
+	if err := tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock(hashtext('event_outbox'))`).Scan(&locked); err != nil {
+		return 0, fmt.Errorf("failed to lock event outbox: %w", err)
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	rows, err := tx.Query(ctx, `
+        SELECT o.id, o.attempts, o.event_id, o.bill_id, b.tenant_id, e.sequence, e.type, e.bill_version, e.data, e.occurred_at
+        FROM event_outbox o
+        JOIN bill_events e ON e.event_id = o.event_id
+        JOIN bills b ON b.id = o.bill_id
+        WHERE o.published_at IS NULL AND o.next_attempt_at <= $1
+          AND NOT EXISTS (
+              SELECT 1 FROM event_outbox p
+              WHERE p.bill_id = o.bill_id AND p.published_at IS NULL AND p.id < o.id AND p.next_attempt_at > $1
+          )
+        ORDER BY o.id
+        LIMIT $2
+    `, now, eventRelayBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load pending events: %w", err)
+	}
+	var pending []pendingEvent
+	for rows.Next() {
+		var p pendingEvent
+		e := &p.msg.Event
+		if err := rows.Scan(&p.id, &p.attempts, &p.msg.EventID, &p.msg.BillID, &p.msg.TenantID,
+			&e.Sequence, &e.Type, &e.BillVersion, &p.data, &e.OccurredAt); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to read pending events: %w", err)
+		}
+		pending = append(pending, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read pending events: %w", err)
+	}
+
+	published := 0
+	heldBack := map[string]bool{}
+	for _, p := range pending {
+		if heldBack[p.msg.BillID] {
+			continue
+		}
+		if publishErr := r.publish(ctx, &p); publishErr != nil {
+			heldBack[p.msg.BillID] = true
+			slog.Warn("Failed to publish bill event", "bill_id", p.msg.BillID, "event_id", p.msg.EventID, "attempts", p.attempts+1, "error", publishErr)
+			_, err = tx.Exec(ctx, `
+                UPDATE event_outbox SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3 WHERE id = $1
+            `, p.id, publishErr.Error(), now.Add(eventRelayBackoff(r.interval, p.attempts+1)))
+		} else {
+			_, err = tx.Exec(ctx, `
+                UPDATE event_outbox SET attempts = attempts + 1, last_error = NULL, published_at = $2 WHERE id = $1
+            `, p.id, now)
+			published++
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to update event %s: %w", p.msg.EventID, err)
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM event_outbox WHERE published_at < $1`, now.Add(-eventOutboxRetention)); err != nil {
+		return 0, fmt.Errorf("failed to prune published events: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit published events: %w", err)
+	}
+	return published, nil
+}
+
+// publish decodes a pending event and hands it to every publisher. A publisher that
+// fails has the event retried by all of them.
+func (r *eventRelay) publish(ctx context.Context, p *pendingEvent) error {
+	if err := json.Unmarshal(p.data, &p.msg.Event.Data); err != nil {
+		return fmt.Errorf("failed to decode event: %w", err)
+	}
+	if err := r.fields.openEventData(&p.msg.Event.Data); err != nil {
+		return fmt.Errorf("failed to decrypt event: %w", err)
+	}
+	for _, publisher := range r.publishers {
+		if err := publisher.Publish(ctx, &p.msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventRelayBackoff is how long an event waits after its attempts-th failed attempt:
+// interval, doubling with every attempt, up to eventRelayMaxBackoff.
+func eventRelayBackoff(interval time.Duration, attempts int) time.Duration {
+	d := interval
+	for i := 1; i < attempts && d < eventRelayMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, eventRelayMaxBackoff)
+}
@@ -0,0 +1,56 @@
+package fees
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestWebhookPublisher tests that events are POSTed with a signature receivers can
+// verify, and that deliveries the receiver does not accept fail and are counted.
+func TestWebhookPublisher(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	var received BillEventMessage
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, verifyPaymentWebhook([]string{"whsec"}, r.Header.Get(PaymentWebhookSignatureHeader), body, clock.Now()))
+		require.NoError(t, json.Unmarshal(body, &received))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	metrics := &statusMetrics{clock: clock}
+	publisher := &webhookPublisher{url: server.URL, secret: "whsec", client: server.Client(), clock: clock, metrics: metrics}
+	msg := &BillEventMessage{
+		EventID:  "event-1",
+		BillID:   "bill-1",
+		TenantID: "tenant-1",
+		Event:    BillEvent{Sequence: 2, Type: AuditLineItemAdded, Data: BillEventData{LineItem: &LineItem{ID: "item-1", Amount: 5}}},
+	}
+	require.NoError(t, publisher.Publish(context.Background(), msg))
+	require.Equal(t, "bill-1", received.BillID)
+	require.Equal(t, int64(2), received.Event.Sequence)
+	require.Equal(t, "item-1", received.Event.Data.LineItem.ID)
+
+	status = http.StatusInternalServerError
+	require.Error(t, publisher.Publish(context.Background(), msg))
+	succeeded, failed := metrics.webhookDeliveries.totals(clock.Now())
+	require.Equal(t, 1, succeeded)
+	require.Equal(t, 1, failed)
+}
+
+// TestEventRelayBackoff tests that retries of an event back off exponentially, up to
+// the cap.
+func TestEventRelayBackoff(t *testing.T) {
+	require.Equal(t, time.Second, eventRelayBackoff(time.Second, 1))
+	require.Equal(t, 4*time.Second, eventRelayBackoff(time.Second, 3))
+	require.Equal(t, eventRelayMaxBackoff, eventRelayBackoff(time.Second, 50))
+}
@@ -0,0 +1,411 @@
+package fees
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"encore.app/apierr"
+	"encore.app/rounding"
+	"encore.dev/storage/sqldb"
+)
+
+// BillEvent is one state transition of a bill, as recorded in its event stream. Unlike
+// the audit log, which keeps snapshots, an event holds just the change, so the bill can be
+// rebuilt by replaying its events in order.
+type BillEvent struct {
+	// Sequence orders the bill's events, starting at 1.
+	Sequence int64 `json:"sequence"`
+	// Type is the audit action the event was recorded with.
+	Type AuditAction `json:"type"`
+	// BillVersion is the bill's version after the change, or 0 for changes made outside
+	// the bill's workflow, such as payment and refund outcomes.
+	BillVersion int64         `json:"billVersion,omitempty"`
+	Data        BillEventData `json:"data"`
+	OccurredAt  time.Time     `json:"occurredAt"`
+}
+
+// BillEventData holds what changed. Which fields are set depends on the event type.
+type BillEventData struct {
+	// From and To are set when the bill's status changed.
+	From BillStatus `json:"from,omitempty"`
+	To   BillStatus `json:"to,omitempty"`
+	// CustomerID, Currency, TenantID, TemplateID, ParentBillID and CreatedAt are set on
+	// bill.created.
+	CustomerID   string     `json:"customerId,omitempty"`
+	Currency     string     `json:"currency,omitempty"`
+	TenantID     string     `json:"tenantId,omitempty"`
+	TemplateID   string     `json:"templateId,omitempty"`
+	ParentBillID string     `json:"parentBillId,omitempty"`
+	CreatedAt    *time.Time `json:"createdAt,omitempty"`
+	// Rounding is set on bill.created when the bill has a rounding policy.
+	Rounding *rounding.Policy `json:"rounding,omitempty"`
+	// DueDate is set on bill.created and bill.closed when the bill has a due date, and
+	// on bill.overdue.
+	DueDate *time.Time `json:"dueDate,omitempty"`
+	// PeriodEnd is set on bill.created when the bill has a period end.
+	PeriodEnd *time.Time `json:"periodEnd,omitempty"`
+	// LineItem is set on line_item.added.
+	LineItem *LineItem `json:"lineItem,omitempty"`
+	// TotalAmount, ClosedAt, Adjustment and AppliedCredit are set on bill.closed, and
+	// Number when the bill was numbered.
+	TotalAmount   *float64        `json:"totalAmount,omitempty"`
+	ClosedAt      *time.Time      `json:"closedAt,omitempty"`
+	Number        string          `json:"number,omitempty"`
+	Adjustment    *BillAdjustment `json:"adjustment,omitempty"`
+	AppliedCredit *AppliedCredit  `json:"appliedCredit,omitempty"`
+	// Approval is set when the bill's approval was saved with the change.
+	Approval *BillApproval `json:"approval,omitempty"`
+	// Payment is set on payment.recorded.
+	Payment *Payment `json:"payment,omitempty"`
+	// CreditNote is set on refund.issued, and holds the outcome on refund.completed.
+	CreditNote *CreditNote `json:"creditNote,omitempty"`
+	// DeletedAt is set on bill.deleted.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// GetBillEventsResponse is the response payload for a bill's event stream.
+type GetBillEventsResponse struct {
+	BillID string      `json:"billId"`
+	Events []BillEvent `json:"events"`
+	// Replayed is the bill rebuilt from Events. ReplayError is set instead when the
+	// events could not be replayed.
+	Replayed    *Bill  `json:"replayed,omitempty"`
+	ReplayError string `json:"replayError,omitempty"`
+	// Reconciled is set when Replayed was compared to the bill's workflow state, and
+	// Discrepancies then lists where they disagree.
+	Reconciled    bool     `json:"reconciled"`
+	Discrepancies []string `json:"discrepancies,omitempty"`
+}
+
+// recordBillEvent appends ev's event to the bill's event stream, and queues it in the
+// event outbox for publishing, within the transaction that made the change. The status
+// transition is read from the before and after snapshots. A retried activity that had
+// already committed records nothing.
+func recordBillEvent(ctx context.Context, tx *tracedTx, eventID string, ev auditEvent, before, after []byte) error {
+	data := *ev.Event
+	from, to := snapshotStatus(before), snapshotStatus(after)
+	if from != to {
+		data.From, data.To = from, to
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event for bill %s: %w", ev.Action, ev.BillID, err)
+	}
+
+	// Lock the bill so concurrent changes cannot take the same sequence number.
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM bills WHERE id = $1 FOR UPDATE`, ev.BillID); err != nil {
+		return fmt.Errorf("failed to lock bill %s: %w", ev.BillID, err)
+	}
+	_, err = tx.Exec(ctx, `
+        INSERT INTO bill_events (event_id, bill_id, sequence, type, bill_version, data)
+        SELECT $1, $2, COALESCE(MAX(sequence), 0) + 1, $3, $4, $5 FROM bill_events WHERE bill_id = $2
+        ON CONFLICT (event_id) DO NOTHING
+    `, eventID, ev.BillID, ev.Action, ev.Version, payload)
+	if err != nil {
+		return fmt.Errorf("failed to record %s event for bill %s: %w", ev.Action, ev.BillID, err)
+	}
+	// The outbox entry commits with the event, so the relay publishes exactly the
+	// events whose changes committed.
+	_, err = tx.Exec(ctx, `
+        INSERT INTO event_outbox (event_id, bill_id) VALUES ($1, $2)
+        ON CONFLICT (event_id) DO NOTHING
+    `, eventID, ev.BillID)
+	if err != nil {
+		return fmt.Errorf("failed to queue %s event of bill %s for publishing: %w", ev.Action, ev.BillID, err)
+	}
+	return nil
+}
+
+// snapshotStatus returns the bill status in a billSnapshotQuery snapshot, or "" for none.
+func snapshotStatus(snapshot []byte) BillStatus {
+	var s struct {
+		Bill struct {
+			Status BillStatus `json:"status"`
+		} `json:"bill"`
+	}
+	if snapshot == nil || json.Unmarshal(snapshot, &s) != nil {
+		return ""
+	}
+	return s.Bill.Status
+}
+
+// replayBill rebuilds a bill from its events, oldest first. The result holds what the
+// events record: the bill's status, line items, totals, approval, payments and credit
+// notes. Settings no event records, such as AutoCollect, are left unset.
+func replayBill(billID string, events []BillEvent) (*Bill, error) {
+	if len(events) == 0 || events[0].Type != AuditBillCreated {
+		return nil, fmt.Errorf("events of bill %s do not start with %s", billID, AuditBillCreated)
+	}
+
+	bill := &Bill{ID: billID, LineItems: []LineItem{}}
+	for _, e := range events {
+		d := e.Data
+		if d.To != "" {
+			if d.From != bill.Status {
+				return nil, fmt.Errorf("event %d moves bill %s from %s, but it is %s", e.Sequence, billID, d.From, bill.Status)
+			}
+			bill.Status = d.To
+		}
+		bill.Version = max(bill.Version, e.BillVersion)
+		if d.Approval != nil {
+			bill.Approval = d.Approval
+		}
+
+		switch e.Type {
+		case AuditBillCreated:
+			bill.CustomerID, bill.Currency, bill.TenantID, bill.TemplateID = d.CustomerID, d.Currency, d.TenantID, d.TemplateID
+			bill.ParentBillID = d.ParentBillID
+			bill.CreatedAt, bill.DueDate, bill.PeriodEnd, bill.Rounding = d.CreatedAt, d.DueDate, d.PeriodEnd, d.Rounding
+		case AuditLineItemAdded:
+			if d.LineItem == nil {
+				return nil, fmt.Errorf("event %d of bill %s has no line item", e.Sequence, billID)
+			}
+			// An aggregated item is recorded again, whole, each time an item is added to it.
+			if existing := bill.lineItem(d.LineItem.ID, ""); existing == nil {
+				bill.LineItems = append(bill.LineItems, *d.LineItem)
+				bill.TotalAmount = bill.lineItemTotal()
+			} else if d.LineItem.Aggregate != nil {
+				*existing = *d.LineItem
+				bill.TotalAmount = bill.lineItemTotal()
+			}
+		case AuditBillClosed:
+			if d.TotalAmount != nil {
+				bill.TotalAmount = *d.TotalAmount
+			}
+			bill.ClosedAt, bill.Adjustment, bill.AppliedCredit = d.ClosedAt, d.Adjustment, d.AppliedCredit
+			bill.Number = d.Number
+			bill.refreshBalance()
+			if d.DueDate != nil {
+				bill.DueDate = d.DueDate
+			}
+		case AuditPaymentRecorded:
+			if d.Payment == nil {
+				return nil, fmt.Errorf("event %d of bill %s has no payment", e.Sequence, billID)
+			}
+			replayPayment(bill, *d.Payment)
+		case AuditRefundIssued:
+			if d.CreditNote == nil {
+				return nil, fmt.Errorf("event %d of bill %s has no credit note", e.Sequence, billID)
+			}
+			bill.CreditNotes = append(bill.CreditNotes, *d.CreditNote)
+		case AuditRefundCompleted:
+			if err := replayRefund(bill, e); err != nil {
+				return nil, err
+			}
+		case AuditBillDeleted:
+			bill.DeletedAt = d.DeletedAt
+		case AuditBillRestored:
+			bill.DeletedAt = nil
+		}
+	}
+	return bill, nil
+}
+
+// replayPayment applies a recorded payment attempt, updating the attempt if it was
+// recorded before. An attempt that succeeds is allocated to the bill's balance, and
+// one that fails after it succeeded is reversed.
+func replayPayment(bill *Bill, payment Payment) {
+	allocate, reverse := payment.Status.allocated(), false
+	if prior := bill.payment(payment.ID); prior != nil {
+		allocate = allocate && !prior.Status.allocated()
+		reverse = prior.Status.allocated() && !payment.Status.allocated()
+		*prior = payment
+	} else {
+		bill.Payments = append(bill.Payments, payment)
+	}
+	switch {
+	case allocate:
+		bill.allocatePayment(payment)
+	case reverse:
+		var reversedAt time.Time
+		if payment.CompletedAt != nil {
+			reversedAt = *payment.CompletedAt
+		}
+		bill.reversePayment(payment, reversedAt)
+	}
+}
+
+// replayRefund applies the outcome of a credit note issued by an earlier event.
+func replayRefund(bill *Bill, e BillEvent) error {
+	outcome := e.Data.CreditNote
+	if outcome == nil {
+		return fmt.Errorf("event %d of bill %s has no credit note", e.Sequence, bill.ID)
+	}
+	for i := range bill.CreditNotes {
+		note := &bill.CreditNotes[i]
+		if note.ID != outcome.ID {
+			continue
+		}
+		if outcome.Status == RefundStatusSucceeded && note.Status != RefundStatusSucceeded {
+			bill.RefundedAmount += note.Amount
+		}
+		note.Status, note.GatewayReference, note.FailureReason, note.CompletedAt = outcome.Status, outcome.GatewayReference, outcome.FailureReason, outcome.CompletedAt
+		return nil
+	}
+	return fmt.Errorf("event %d of bill %s completes unknown credit note %s", e.Sequence, bill.ID, outcome.ID)
+}
+
+// reconcileBill lists where a bill replayed from its events disagrees with the live bill
+// of its workflow. Versions are not compared, since payment and refund outcomes are
+// recorded without one.
+func reconcileBill(live, replayed *Bill) []string {
+	var diffs []string
+	if live.Status != replayed.Status {
+		diffs = append(diffs, fmt.Sprintf("status: workflow has %s, events have %s", live.Status, replayed.Status))
+	}
+	if math.Abs(live.TotalAmount-replayed.TotalAmount) > 1e-9 {
+		diffs = append(diffs, fmt.Sprintf("totalAmount: workflow has %v, events have %v", live.TotalAmount, replayed.TotalAmount))
+	}
+	if math.Abs(live.RefundedAmount-replayed.RefundedAmount) > 1e-9 {
+		diffs = append(diffs, fmt.Sprintf("refundedAmount: workflow has %v, events have %v", live.RefundedAmount, replayed.RefundedAmount))
+	}
+	for _, item := range live.LineItems {
+		if other := replayed.lineItem(item.ID, ""); other == nil {
+			diffs = append(diffs, fmt.Sprintf("line item %s: missing from events", item.ID))
+		} else if other.Amount != item.Amount {
+			diffs = append(diffs, fmt.Sprintf("line item %s: workflow has amount %v, events have %v", item.ID, item.Amount, other.Amount))
+		}
+	}
+	for _, item := range replayed.LineItems {
+		if live.lineItem(item.ID, "") == nil {
+			diffs = append(diffs, fmt.Sprintf("line item %s: missing from workflow", item.ID))
+		}
+	}
+	replayedPayments := make(map[string]PaymentStatus, len(replayed.Payments))
+	for _, p := range replayed.Payments {
+		replayedPayments[p.ID] = p.Status
+	}
+	for _, p := range live.Payments {
+		status, ok := replayedPayments[p.ID]
+		if !ok && p.Status == PaymentStatusPending {
+			continue // recorded once the attempt completes
+		}
+		if status != p.Status {
+			diffs = append(diffs, fmt.Sprintf("payment %s: workflow has %s, events have %q", p.ID, p.Status, status))
+		}
+	}
+	replayedNotes := make(map[string]RefundStatus, len(replayed.CreditNotes))
+	for _, n := range replayed.CreditNotes {
+		replayedNotes[n.ID] = n.Status
+	}
+	for _, n := range live.CreditNotes {
+		if status := replayedNotes[n.ID]; status != n.Status {
+			diffs = append(diffs, fmt.Sprintf("credit note %s: workflow has %s, events have %q", n.ID, n.Status, status))
+		}
+	}
+	return diffs
+}
+
+// GetBillEvents returns the event stream of a bill, oldest first, with the bill rebuilt
+// from it and reconciled against the bill's workflow.
+//
+// encore:api auth method=GET path=/bills/:billID/events
+func (s *Service) GetBillEvents(ctx context.Context, billID string) (*GetBillEventsResponse, error) {
+	var tenantID string
+	err := s.db.QueryRow(ctx, `SELECT tenant_id FROM bills WHERE id = $1`, billID).Scan(&tenantID)
+	if errors.Is(err, sqldb.ErrNoRows) || (err == nil && !visibleToCaller(ctx, tenantID)) {
+		return nil, apierr.NotFound(apierr.BillNotFound, "bill %s not found", billID)
+	}
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load bill %s", billID)
+	}
+
+	events, err := loadBillEvents(ctx, s.db, s.fields, billID, nil)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load events of bill %s", billID)
+	}
+	resp := &GetBillEventsResponse{BillID: billID, Events: events}
+
+	replayed, err := replayBill(billID, resp.Events)
+	if err != nil {
+		resp.ReplayError = err.Error()
+		return resp, nil
+	}
+	resp.Replayed = replayed
+
+	live, err := s.getBill(ctx, billID)
+	if err != nil || live.RetrievedBill.Stale {
+		loggerFrom(ctx).Warn("Bill workflow unavailable, skipping reconciliation", "error", err)
+		return resp, nil
+	}
+	resp.Reconciled = true
+	resp.Discrepancies = reconcileBill(&live.RetrievedBill, replayed)
+	if len(resp.Discrepancies) > 0 {
+		err := s.notifier.Notify(ctx, Notification{
+			Event:      NotificationReconciliationDiscrepancy,
+			BillID:     billID,
+			CustomerID: live.RetrievedBill.CustomerID,
+			Message:    fmt.Sprintf("Replaying the bill's events disagrees with its workflow: %s.", strings.Join(resp.Discrepancies, "; ")),
+		})
+		if err != nil {
+			loggerFrom(ctx).Warn("Failed to send reconciliation notification", "bill_id", billID, "error", err)
+		}
+	}
+	return resp, nil
+}
+
+// loadBillEvents returns the event stream of a bill, oldest first. With until set, only
+// the events that occurred up to and including it are returned.
+func loadBillEvents(ctx context.Context, db *tracedDB, fields *fieldCipher, billID string, until *time.Time) ([]BillEvent, error) {
+	return queryBillEvents(ctx, db, fields, `
+        SELECT sequence, type, bill_version, data, occurred_at
+        FROM bill_events
+        WHERE bill_id = $1 AND ($2::timestamptz IS NULL OR occurred_at <= $2)
+        ORDER BY sequence
+    `, billID, until)
+}
+
+// queryBillEvents runs query, which selects the sequence, type, bill_version, data and
+// occurred_at of bill events, and decodes and decrypts the events it returns.
+func queryBillEvents(ctx context.Context, db *tracedDB, fields *fieldCipher, query string, args ...any) ([]BillEvent, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []BillEvent{}
+	for rows.Next() {
+		var e BillEvent
+		var data []byte
+		if err := rows.Scan(&e.Sequence, &e.Type, &e.BillVersion, &data, &e.OccurredAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &e.Data); err != nil {
+			return nil, fmt.Errorf("failed to decode event %d: %w", e.Sequence, err)
+		}
+		if err := fields.openEventData(&e.Data); err != nil {
+			return nil, fmt.Errorf("failed to decrypt event %d: %w", e.Sequence, err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// billAsOf rebuilds a bill as it was at asOf by replaying the events recorded up to
+// then. A bill with no events by then did not exist yet and is reported as not found.
+func (s *Service) billAsOf(ctx context.Context, billID string, asOf time.Time) (*GetBillResponse, error) {
+	if asOf.After(s.clock.Now()) {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "asOf %s is in the future", asOf.Format(time.RFC3339))
+	}
+	if err := s.checkBillVisible(ctx, billID); err != nil {
+		return nil, err
+	}
+	events, err := loadBillEvents(ctx, s.db, s.fields, billID, &asOf)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load events of bill %s", billID)
+	}
+	if len(events) == 0 {
+		return nil, apierr.NotFound(apierr.BillNotFound, "bill %s did not exist at %s", billID, asOf.Format(time.RFC3339))
+	}
+	bill, err := replayBill(billID, events)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to rebuild bill %s as of %s", billID, asOf.Format(time.RFC3339))
+	}
+	return &GetBillResponse{RetrievedBill: *bill, AsOf: &asOf}, nil
+}
@@ -0,0 +1,96 @@
+package fees
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func billEvents() []BillEvent {
+	total := 15.0
+	return []BillEvent{
+		{Sequence: 1, Type: AuditBillCreated, BillVersion: 1, Data: BillEventData{To: BillStatusOpen, CustomerID: "c1", Currency: "USD"}},
+		{Sequence: 2, Type: AuditLineItemAdded, BillVersion: 2, Data: BillEventData{LineItem: &LineItem{ID: "li1", Amount: 10}}},
+		{Sequence: 3, Type: AuditLineItemAdded, BillVersion: 3, Data: BillEventData{LineItem: &LineItem{ID: "li2", Amount: 5}}},
+		{Sequence: 4, Type: AuditStatusChanged, BillVersion: 4, Data: BillEventData{From: BillStatusOpen, To: BillStatusClosing}},
+		{Sequence: 5, Type: AuditBillClosed, BillVersion: 5, Data: BillEventData{From: BillStatusClosing, To: BillStatusClosed, TotalAmount: &total, Number: "c1-2024-00001"}},
+		{Sequence: 6, Type: AuditPaymentRecorded, Data: BillEventData{Payment: &Payment{ID: "p1", Status: PaymentStatusSucceeded, Amount: 15}}},
+		{Sequence: 7, Type: AuditStatusChanged, BillVersion: 6, Data: BillEventData{From: BillStatusClosed, To: BillStatusPaid}},
+		{Sequence: 8, Type: AuditRefundIssued, Data: BillEventData{CreditNote: &CreditNote{ID: "cn1", Status: RefundStatusPending, Amount: 5}}},
+		{Sequence: 9, Type: AuditRefundCompleted, Data: BillEventData{CreditNote: &CreditNote{ID: "cn1", Status: RefundStatusSucceeded}}},
+	}
+}
+
+func TestReplayBill(t *testing.T) {
+	bill, err := replayBill("b1", billEvents())
+	require.NoError(t, err)
+	require.Equal(t, BillStatusPaid, bill.Status)
+	require.Equal(t, "c1", bill.CustomerID)
+	require.Equal(t, "c1-2024-00001", bill.Number)
+	require.Equal(t, int64(6), bill.Version)
+	require.Len(t, bill.LineItems, 2)
+	require.Equal(t, 15.0, bill.TotalAmount)
+	require.Equal(t, 5.0, bill.RefundedAmount)
+	require.Equal(t, PaymentStatusSucceeded, bill.Payments[0].Status)
+	require.Equal(t, RefundStatusSucceeded, bill.CreditNotes[0].Status)
+	require.Equal(t, 15.0, bill.AmountPaid)
+	require.Len(t, bill.Allocations, 1)
+}
+
+func TestReplayBill_PartialPayments(t *testing.T) {
+	events := billEvents()[:5]
+	events = append(events,
+		BillEvent{Sequence: 6, Type: AuditPaymentRecorded, Data: BillEventData{Payment: &Payment{ID: "p1", Status: PaymentStatusSucceeded, Amount: 4, Method: "check"}}},
+		BillEvent{Sequence: 7, Type: AuditStatusChanged, BillVersion: 6, Data: BillEventData{From: BillStatusClosed, To: BillStatusPartiallyPaid}},
+		BillEvent{Sequence: 8, Type: AuditPaymentRecorded, Data: BillEventData{Payment: &Payment{ID: "p2", Status: PaymentStatusPending, Amount: 11}}},
+		BillEvent{Sequence: 9, Type: AuditPaymentRecorded, Data: BillEventData{Payment: &Payment{ID: "p2", Status: PaymentStatusFailed, Amount: 11}}},
+	)
+	bill, err := replayBill("b1", events)
+	require.NoError(t, err)
+	require.Equal(t, BillStatusPartiallyPaid, bill.Status)
+	require.Equal(t, 4.0, bill.AmountPaid)
+	require.Equal(t, 11.0, *bill.BalanceDue)
+	require.Equal(t, []PaymentAllocation{{PaymentID: "p1", Amount: 4, BalanceAfter: 11}}, bill.Allocations)
+}
+
+func TestReplayBill_ReversedPayment(t *testing.T) {
+	events := billEvents()[:7]
+	events = append(events,
+		BillEvent{Sequence: 8, Type: AuditPaymentRecorded, Data: BillEventData{Payment: &Payment{ID: "p1", Status: PaymentStatusDisputed, Amount: 15}}},
+		BillEvent{Sequence: 9, Type: AuditPaymentRecorded, Data: BillEventData{Payment: &Payment{ID: "p1", Status: PaymentStatusFailed, Amount: 15}}},
+		BillEvent{Sequence: 10, Type: AuditStatusChanged, BillVersion: 7, Data: BillEventData{From: BillStatusPaid, To: BillStatusPaymentFailed}},
+	)
+	bill, err := replayBill("b1", events)
+	require.NoError(t, err)
+	require.Equal(t, BillStatusPaymentFailed, bill.Status)
+	require.Equal(t, 0.0, bill.AmountPaid)
+	require.Equal(t, 15.0, *bill.BalanceDue)
+	require.Len(t, bill.Allocations, 2)
+	require.Equal(t, -15.0, bill.Allocations[1].Amount)
+}
+
+func TestReplayBill_Invalid(t *testing.T) {
+	events := billEvents()
+	_, err := replayBill("b1", events[1:])
+	require.ErrorContains(t, err, "do not start with")
+
+	events[4].Data.From = BillStatusOpen
+	_, err = replayBill("b1", events)
+	require.ErrorContains(t, err, "from OPEN, but it is CLOSING")
+}
+
+func TestReconcileBill(t *testing.T) {
+	replayed, err := replayBill("b1", billEvents())
+	require.NoError(t, err)
+
+	live := *replayed
+	live.Payments = append(live.Payments, Payment{ID: "p2", Status: PaymentStatusPending})
+	require.Empty(t, reconcileBill(&live, replayed))
+
+	live.Status = BillStatusDelinquent
+	live.LineItems = append(live.LineItems, LineItem{ID: "li3", Amount: 1})
+	require.ElementsMatch(t, []string{
+		"status: workflow has DELINQUENT, events have PAID",
+		"line item li3: missing from events",
+	}, reconcileBill(&live, replayed))
+}
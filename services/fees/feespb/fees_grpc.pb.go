@@ -0,0 +1,401 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: fees.proto
+
+package feespb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	FeesService_CreateBill_FullMethodName   = "/fees.v1.FeesService/CreateBill"
+	FeesService_AddLineItem_FullMethodName  = "/fees.v1.FeesService/AddLineItem"
+	FeesService_CloseBill_FullMethodName    = "/fees.v1.FeesService/CloseBill"
+	FeesService_GetBill_FullMethodName      = "/fees.v1.FeesService/GetBill"
+	FeesService_ListBills_FullMethodName    = "/fees.v1.FeesService/ListBills"
+	FeesService_PayBill_FullMethodName      = "/fees.v1.FeesService/PayBill"
+	FeesService_CreateRefund_FullMethodName = "/fees.v1.FeesService/CreateRefund"
+	FeesService_WatchBill_FullMethodName    = "/fees.v1.FeesService/WatchBill"
+)
+
+// FeesServiceClient is the client API for FeesService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// FeesService exposes bill lifecycle operations over gRPC. It is served by the same
+// Service as the Encore HTTP endpoints and mirrors their behavior.
+type FeesServiceClient interface {
+	CreateBill(ctx context.Context, in *CreateBillRequest, opts ...grpc.CallOption) (*CreateBillResponse, error)
+	AddLineItem(ctx context.Context, in *AddLineItemRequest, opts ...grpc.CallOption) (*AddLineItemResponse, error)
+	CloseBill(ctx context.Context, in *CloseBillRequest, opts ...grpc.CallOption) (*CloseBillResponse, error)
+	GetBill(ctx context.Context, in *GetBillRequest, opts ...grpc.CallOption) (*Bill, error)
+	ListBills(ctx context.Context, in *ListBillsRequest, opts ...grpc.CallOption) (*ListBillsResponse, error)
+	PayBill(ctx context.Context, in *PayBillRequest, opts ...grpc.CallOption) (*PayBillResponse, error)
+	CreateRefund(ctx context.Context, in *CreateRefundRequest, opts ...grpc.CallOption) (*CreateRefundResponse, error)
+	// WatchBill streams the bill's state, sending a new snapshot whenever it changes.
+	// The stream ends once the bill reaches a terminal status or the client cancels.
+	WatchBill(ctx context.Context, in *WatchBillRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Bill], error)
+}
+
+type feesServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFeesServiceClient(cc grpc.ClientConnInterface) FeesServiceClient {
+	return &feesServiceClient{cc}
+}
+
+func (c *feesServiceClient) CreateBill(ctx context.Context, in *CreateBillRequest, opts ...grpc.CallOption) (*CreateBillResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateBillResponse)
+	err := c.cc.Invoke(ctx, FeesService_CreateBill_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *feesServiceClient) AddLineItem(ctx context.Context, in *AddLineItemRequest, opts ...grpc.CallOption) (*AddLineItemResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AddLineItemResponse)
+	err := c.cc.Invoke(ctx, FeesService_AddLineItem_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *feesServiceClient) CloseBill(ctx context.Context, in *CloseBillRequest, opts ...grpc.CallOption) (*CloseBillResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CloseBillResponse)
+	err := c.cc.Invoke(ctx, FeesService_CloseBill_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *feesServiceClient) GetBill(ctx context.Context, in *GetBillRequest, opts ...grpc.CallOption) (*Bill, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Bill)
+	err := c.cc.Invoke(ctx, FeesService_GetBill_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *feesServiceClient) ListBills(ctx context.Context, in *ListBillsRequest, opts ...grpc.CallOption) (*ListBillsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListBillsResponse)
+	err := c.cc.Invoke(ctx, FeesService_ListBills_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *feesServiceClient) PayBill(ctx context.Context, in *PayBillRequest, opts ...grpc.CallOption) (*PayBillResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PayBillResponse)
+	err := c.cc.Invoke(ctx, FeesService_PayBill_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *feesServiceClient) CreateRefund(ctx context.Context, in *CreateRefundRequest, opts ...grpc.CallOption) (*CreateRefundResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateRefundResponse)
+	err := c.cc.Invoke(ctx, FeesService_CreateRefund_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *feesServiceClient) WatchBill(ctx context.Context, in *WatchBillRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Bill], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &FeesService_ServiceDesc.Streams[0], FeesService_WatchBill_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchBillRequest, Bill]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FeesService_WatchBillClient = grpc.ServerStreamingClient[Bill]
+
+// FeesServiceServer is the server API for FeesService service.
+// All implementations must embed UnimplementedFeesServiceServer
+// for forward compatibility.
+//
+// FeesService exposes bill lifecycle operations over gRPC. It is served by the same
+// Service as the Encore HTTP endpoints and mirrors their behavior.
+type FeesServiceServer interface {
+	CreateBill(context.Context, *CreateBillRequest) (*CreateBillResponse, error)
+	AddLineItem(context.Context, *AddLineItemRequest) (*AddLineItemResponse, error)
+	CloseBill(context.Context, *CloseBillRequest) (*CloseBillResponse, error)
+	GetBill(context.Context, *GetBillRequest) (*Bill, error)
+	ListBills(context.Context, *ListBillsRequest) (*ListBillsResponse, error)
+	PayBill(context.Context, *PayBillRequest) (*PayBillResponse, error)
+	CreateRefund(context.Context, *CreateRefundRequest) (*CreateRefundResponse, error)
+	// WatchBill streams the bill's state, sending a new snapshot whenever it changes.
+	// The stream ends once the bill reaches a terminal status or the client cancels.
+	WatchBill(*WatchBillRequest, grpc.ServerStreamingServer[Bill]) error
+	mustEmbedUnimplementedFeesServiceServer()
+}
+
+// UnimplementedFeesServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedFeesServiceServer struct{}
+
+func (UnimplementedFeesServiceServer) CreateBill(context.Context, *CreateBillRequest) (*CreateBillResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateBill not implemented")
+}
+func (UnimplementedFeesServiceServer) AddLineItem(context.Context, *AddLineItemRequest) (*AddLineItemResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddLineItem not implemented")
+}
+func (UnimplementedFeesServiceServer) CloseBill(context.Context, *CloseBillRequest) (*CloseBillResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CloseBill not implemented")
+}
+func (UnimplementedFeesServiceServer) GetBill(context.Context, *GetBillRequest) (*Bill, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBill not implemented")
+}
+func (UnimplementedFeesServiceServer) ListBills(context.Context, *ListBillsRequest) (*ListBillsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBills not implemented")
+}
+func (UnimplementedFeesServiceServer) PayBill(context.Context, *PayBillRequest) (*PayBillResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PayBill not implemented")
+}
+func (UnimplementedFeesServiceServer) CreateRefund(context.Context, *CreateRefundRequest) (*CreateRefundResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateRefund not implemented")
+}
+func (UnimplementedFeesServiceServer) WatchBill(*WatchBillRequest, grpc.ServerStreamingServer[Bill]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchBill not implemented")
+}
+func (UnimplementedFeesServiceServer) mustEmbedUnimplementedFeesServiceServer() {}
+func (UnimplementedFeesServiceServer) testEmbeddedByValue()                     {}
+
+// UnsafeFeesServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FeesServiceServer will
+// result in compilation errors.
+type UnsafeFeesServiceServer interface {
+	mustEmbedUnimplementedFeesServiceServer()
+}
+
+func RegisterFeesServiceServer(s grpc.ServiceRegistrar, srv FeesServiceServer) {
+	// If the following call pancis, it indicates UnimplementedFeesServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&FeesService_ServiceDesc, srv)
+}
+
+func _FeesService_CreateBill_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateBillRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FeesServiceServer).CreateBill(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FeesService_CreateBill_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FeesServiceServer).CreateBill(ctx, req.(*CreateBillRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FeesService_AddLineItem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddLineItemRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FeesServiceServer).AddLineItem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FeesService_AddLineItem_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FeesServiceServer).AddLineItem(ctx, req.(*AddLineItemRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FeesService_CloseBill_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CloseBillRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FeesServiceServer).CloseBill(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FeesService_CloseBill_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FeesServiceServer).CloseBill(ctx, req.(*CloseBillRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FeesService_GetBill_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBillRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FeesServiceServer).GetBill(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FeesService_GetBill_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FeesServiceServer).GetBill(ctx, req.(*GetBillRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FeesService_ListBills_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListBillsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FeesServiceServer).ListBills(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FeesService_ListBills_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FeesServiceServer).ListBills(ctx, req.(*ListBillsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FeesService_PayBill_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PayBillRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FeesServiceServer).PayBill(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FeesService_PayBill_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FeesServiceServer).PayBill(ctx, req.(*PayBillRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FeesService_CreateRefund_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateRefundRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FeesServiceServer).CreateRefund(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FeesService_CreateRefund_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FeesServiceServer).CreateRefund(ctx, req.(*CreateRefundRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FeesService_WatchBill_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchBillRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FeesServiceServer).WatchBill(m, &grpc.GenericServerStream[WatchBillRequest, Bill]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type FeesService_WatchBillServer = grpc.ServerStreamingServer[Bill]
+
+// FeesService_ServiceDesc is the grpc.ServiceDesc for FeesService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FeesService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fees.v1.FeesService",
+	HandlerType: (*FeesServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateBill",
+			Handler:    _FeesService_CreateBill_Handler,
+		},
+		{
+			MethodName: "AddLineItem",
+			Handler:    _FeesService_AddLineItem_Handler,
+		},
+		{
+			MethodName: "CloseBill",
+			Handler:    _FeesService_CloseBill_Handler,
+		},
+		{
+			MethodName: "GetBill",
+			Handler:    _FeesService_GetBill_Handler,
+		},
+		{
+			MethodName: "ListBills",
+			Handler:    _FeesService_ListBills_Handler,
+		},
+		{
+			MethodName: "PayBill",
+			Handler:    _FeesService_PayBill_Handler,
+		},
+		{
+			MethodName: "CreateRefund",
+			Handler:    _FeesService_CreateRefund_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchBill",
+			Handler:       _FeesService_WatchBill_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "fees.proto",
+}
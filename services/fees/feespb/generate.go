@@ -0,0 +1,4 @@
+// Package feespb contains the protobuf and gRPC definitions of the fees service API.
+package feespb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative fees.proto
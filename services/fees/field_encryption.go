@@ -0,0 +1,312 @@
+package fees
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"encore.app/apierr"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// encryptedFieldPrefix starts every encrypted column value, followed by the key ID
+	// and the base64 nonce and ciphertext: "enc:v1:<key id>:<base64>".
+	encryptedFieldPrefix = "enc:v1:"
+
+	// EncryptLineItemsActivityName encrypts one page of line items.
+	EncryptLineItemsActivityName = "EncryptLineItemsActivity"
+
+	// encryptLineItemsPageSize is how many line items EncryptLineItemsActivity reads at once.
+	encryptLineItemsPageSize = 500
+	// encryptLineItemsPagesPerRun is how many pages an encrypt_line_items job run handles
+	// before continuing as new, bounding its history.
+	encryptLineItemsPagesPerRun = 10
+
+	// FieldEncryptionDisabledErrorType is the application error type of an
+	// EncryptLineItemsActivity run by a worker without field encryption keys.
+	FieldEncryptionDisabledErrorType = "FieldEncryptionDisabled"
+)
+
+// fieldCipher encrypts sensitive columns (line item descriptions and client references)
+// with AES-256-GCM before they are written, so a database dump or replica does not
+// expose them. Each value records the ID of its key, so keys can be rotated; values
+// written before encryption was turned on are read unchanged. A nil fieldCipher leaves
+// values in plaintext.
+type fieldCipher struct {
+	activeID string
+	keys     map[string]cipher.AEAD
+}
+
+// newFieldCipher returns a cipher encrypting with the first of keys, or nil for no keys.
+func newFieldCipher(keys []EncryptionKey) (*fieldCipher, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	aeads, err := newAEADs(keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid field encryption keys: %w", err)
+	}
+	return &fieldCipher{activeID: keys[0].ID, keys: aeads}, nil
+}
+
+// encrypt returns v encrypted with the active key. Empty values stay empty, so optional
+// columns remain NULL.
+func (c *fieldCipher) encrypt(v string) (string, error) {
+	if c == nil || v == "" {
+		return v, nil
+	}
+	aead := c.keys[c.activeID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to encrypt field: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(v), []byte(c.activeID))
+	return encryptedFieldPrefix + c.activeID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt returns the plaintext of v, which may be encrypted with any of the cipher's
+// keys or not encrypted at all.
+func (c *fieldCipher) decrypt(v string) (string, error) {
+	rest, ok := strings.CutPrefix(v, encryptedFieldPrefix)
+	if !ok {
+		return v, nil
+	}
+	keyID, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", fmt.Errorf("encrypted field is malformed")
+	}
+	if c == nil {
+		return "", fmt.Errorf("field is encrypted with key %q, but no field encryption keys are configured", keyID)
+	}
+	aead, ok := c.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("field was encrypted with unknown key %q", keyID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("encrypted field is malformed")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(keyID))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt field with key %q: %w", keyID, err)
+	}
+	return string(plaintext), nil
+}
+
+// current reports whether v needs no rewriting: it is empty or encrypted with the
+// active key.
+func (c *fieldCipher) current(v string) bool {
+	return v == "" || strings.HasPrefix(v, encryptedFieldPrefix+c.activeID+":")
+}
+
+// sealLineItem returns item with its description and client reference encrypted.
+func (c *fieldCipher) sealLineItem(item LineItem) (LineItem, error) {
+	var err error
+	if item.Description, err = c.encrypt(item.Description); err != nil {
+		return item, err
+	}
+	if item.ClientReference, err = c.encrypt(item.ClientReference); err != nil {
+		return item, err
+	}
+	return item, nil
+}
+
+// openLineItem decrypts the description and client reference of item in place.
+func (c *fieldCipher) openLineItem(item *LineItem) error {
+	var err error
+	if item.Description, err = c.decrypt(item.Description); err != nil {
+		return fmt.Errorf("line item %s: %w", item.ID, err)
+	}
+	if item.ClientReference, err = c.decrypt(item.ClientReference); err != nil {
+		return fmt.Errorf("line item %s: %w", item.ID, err)
+	}
+	return nil
+}
+
+// openEventData decrypts the line items an event carries.
+func (c *fieldCipher) openEventData(data *BillEventData) error {
+	if data.LineItem != nil {
+		if err := c.openLineItem(data.LineItem); err != nil {
+			return err
+		}
+	}
+	if data.CreditNote != nil {
+		for i := range data.CreditNote.LineItems {
+			if err := c.openLineItem(&data.CreditNote.LineItems[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// openSnapshot decrypts the line item columns of a billSnapshotQuery snapshot. Other
+// snapshots, and snapshots with nothing encrypted, are returned unchanged.
+func (c *fieldCipher) openSnapshot(snapshot []byte) ([]byte, error) {
+	if !strings.Contains(string(snapshot), encryptedFieldPrefix) {
+		return snapshot, nil
+	}
+	var s map[string]any
+	if err := json.Unmarshal(snapshot, &s); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	items, _ := s["lineItems"].([]any)
+	for _, item := range items {
+		columns, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		for _, column := range []string{"description", "client_reference"} {
+			v, ok := columns[column].(string)
+			if !ok {
+				continue
+			}
+			plaintext, err := c.decrypt(v)
+			if err != nil {
+				return nil, fmt.Errorf("line item %v: %w", columns["id"], err)
+			}
+			columns[column] = plaintext
+		}
+	}
+	return json.Marshal(s)
+}
+
+// ------ Migration ------
+
+// EncryptLineItemsActivityParams defines parameters for EncryptLineItemsActivity.
+type EncryptLineItemsActivityParams struct {
+	AfterID string
+	Limit   int
+}
+
+// EncryptLineItemsResult is the outcome of one EncryptLineItemsActivity page.
+type EncryptLineItemsResult struct {
+	// LastID is the ID of the last line item read, where the next page starts.
+	LastID string
+	// Read counts the line items read; Encrypted counts those rewritten.
+	Read      int
+	Encrypted int
+}
+
+// runEncryptLineItems runs a JobKindEncryptLineItems job: it encrypts every line item
+// still in plaintext, or encrypted with a key other than the active one, a page at a
+// time in line item ID order.
+func runEncryptLineItems(run *jobRun) error {
+	ctx, logger := run.ctx, workflow.GetLogger(run.ctx)
+	progress := &run.params.Progress
+
+	for page := 0; ; page++ {
+		if page == encryptLineItemsPagesPerRun {
+			return errJobContinue
+		}
+		var result EncryptLineItemsResult
+		err := workflow.ExecuteActivity(ctx, EncryptLineItemsActivityName, EncryptLineItemsActivityParams{
+			AfterID: run.params.Cursor,
+			Limit:   encryptLineItemsPageSize,
+		}).Get(ctx, &result)
+		if err != nil {
+			logger.Error("Failed to execute EncryptLineItemsActivity", "job_id", run.params.JobID, "after_id", run.params.Cursor, "error", err)
+			return err
+		}
+
+		progress.Processed += result.Read
+		progress.Succeeded += result.Encrypted
+		cursor := run.params.Cursor
+		if result.Read > 0 {
+			cursor = result.LastID
+		}
+		if err := run.checkpoint(cursor); err != nil {
+			return err
+		}
+		if result.Read < encryptLineItemsPageSize {
+			return nil
+		}
+	}
+}
+
+// EncryptLineItemsActivity reads up to params.Limit line items after params.AfterID and
+// rewrites those not encrypted with the active key. A row changed since it was read is
+// left for the writer, which encrypts it itself.
+func (a *Activities) EncryptLineItemsActivity(ctx context.Context, params EncryptLineItemsActivityParams) (*EncryptLineItemsResult, error) {
+	if a.Fields == nil {
+		return nil, temporal.NewNonRetryableApplicationError("field encryption keys are not configured", FieldEncryptionDisabledErrorType, nil)
+	}
+	rows, err := a.DB.Query(ctx, `
+        SELECT id, description, COALESCE(client_reference, '')
+        FROM line_items
+        WHERE id > $1
+        ORDER BY id
+        LIMIT $2
+    `, params.AfterID, params.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("EncryptLineItemsActivity: failed to read line items after %q: %w", params.AfterID, err)
+	}
+	type row struct{ id, description, reference string }
+	var page []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.description, &r.reference); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("EncryptLineItemsActivity: failed to read line item: %w", err)
+		}
+		page = append(page, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("EncryptLineItemsActivity: failed to read line items after %q: %w", params.AfterID, err)
+	}
+
+	result := &EncryptLineItemsResult{Read: len(page)}
+	for _, r := range page {
+		if err := heartbeat(ctx, result.LastID); err != nil {
+			return nil, fmt.Errorf("EncryptLineItemsActivity: stopped after line item %q: %w", result.LastID, err)
+		}
+		result.LastID = r.id
+		if a.Fields.current(r.description) && a.Fields.current(r.reference) {
+			continue
+		}
+		item := LineItem{ID: r.id, Description: r.description, ClientReference: r.reference}
+		if err := a.Fields.openLineItem(&item); err != nil {
+			return nil, fmt.Errorf("EncryptLineItemsActivity: %w", err)
+		}
+		sealed, err := a.Fields.sealLineItem(item)
+		if err != nil {
+			return nil, fmt.Errorf("EncryptLineItemsActivity: line item %s: %w", r.id, err)
+		}
+		res, err := a.DB.Exec(ctx, `
+            UPDATE line_items SET description = $2, client_reference = $3
+            WHERE id = $1 AND description = $4 AND COALESCE(client_reference, '') = $5
+        `, r.id, sealed.Description, nullIfEmpty(sealed.ClientReference), r.description, r.reference)
+		if err != nil {
+			return nil, fmt.Errorf("EncryptLineItemsActivity: failed to encrypt line item %s: %w", r.id, err)
+		}
+		result.Encrypted += int(res.RowsAffected())
+	}
+	return result, nil
+}
+
+// ------ API ------
+
+// EncryptLineItems starts encrypting the descriptions and client references of line
+// items written before field encryption was turned on, or re-encrypting those written
+// under a key that has since been rotated out. It returns at once with the
+// encrypt_line_items job, whose progress GetJob reports. Once it succeeds, retired keys
+// can be removed from FEES_FIELD_ENCRYPTION_KEYS.
+//
+// encore:api private method=POST path=/admin/encryption/line-items
+func (s *Service) EncryptLineItems(ctx context.Context) (*JobResponse, error) {
+	if s.fields == nil {
+		return nil, apierr.FailedPrecondition(apierr.FieldEncryptionDisabled, "field encryption keys are not configured")
+	}
+	job, err := s.startJob(ctx, JobKindEncryptLineItems, "", struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	return &JobResponse{Job: *job}, nil
+}
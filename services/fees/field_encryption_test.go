@@ -0,0 +1,78 @@
+package fees
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testFieldCipher(t *testing.T, keys string) *fieldCipher {
+	parsed, err := parseEncryptionKeys(keys)
+	require.NoError(t, err)
+	c, err := newFieldCipher(parsed)
+	require.NoError(t, err)
+	return c
+}
+
+// TestFieldCipher tests that line item columns round-trip through encryption, that
+// rotated keys still decrypt old values, and that plaintext rows are read unchanged.
+func TestFieldCipher(t *testing.T) {
+	old := testFieldCipher(t, testEncryptionKey("k1", 1))
+	item, err := old.sealLineItem(LineItem{ID: "li-1", Description: "Consulting for Jane Doe", ClientReference: "usage-42"})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(item.Description, "enc:v1:k1:"))
+	require.NotContains(t, item.Description, "Jane")
+	require.NotContains(t, item.ClientReference, "usage-42")
+
+	rotated := testFieldCipher(t, testEncryptionKey("k2", 2)+","+testEncryptionKey("k1", 1))
+	require.False(t, rotated.current(item.Description))
+	opened := item
+	require.NoError(t, rotated.openLineItem(&opened))
+	require.Equal(t, "Consulting for Jane Doe", opened.Description)
+	require.Equal(t, "usage-42", opened.ClientReference)
+
+	resealed, err := rotated.sealLineItem(opened)
+	require.NoError(t, err)
+	require.True(t, rotated.current(resealed.Description))
+	_, err = old.decrypt(resealed.Description)
+	require.ErrorContains(t, err, `unknown key "k2"`)
+
+	plain := LineItem{ID: "li-2", Description: "Written before encryption"}
+	require.NoError(t, rotated.openLineItem(&plain))
+	require.Equal(t, "Written before encryption", plain.Description)
+
+	var disabled *fieldCipher
+	unsealed, err := disabled.sealLineItem(plain)
+	require.NoError(t, err)
+	require.Equal(t, plain, unsealed)
+	_, err = disabled.decrypt(item.Description)
+	require.ErrorContains(t, err, "no field encryption keys")
+
+	tampered := item.Description[:len(item.Description)-2] + "AA"
+	_, err = old.decrypt(tampered)
+	require.Error(t, err)
+}
+
+// TestFieldCipherSnapshot tests that audit snapshots are returned with their line item
+// columns decrypted.
+func TestFieldCipherSnapshot(t *testing.T) {
+	c := testFieldCipher(t, testEncryptionKey("k1", 1))
+	description, err := c.encrypt("Consulting")
+	require.NoError(t, err)
+	snapshot, err := json.Marshal(map[string]any{
+		"bill":      map[string]any{"id": "b1", "status": "OPEN"},
+		"lineItems": []map[string]any{{"id": "li-1", "description": description, "client_reference": nil}},
+	})
+	require.NoError(t, err)
+
+	opened, err := c.openSnapshot(snapshot)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"bill": {"id": "b1", "status": "OPEN"}, "lineItems": [{"id": "li-1", "description": "Consulting", "client_reference": null}]}`, string(opened))
+
+	plain := []byte(`{"bill": {"id": "b1"}}`)
+	opened, err = c.openSnapshot(plain)
+	require.NoError(t, err)
+	require.Equal(t, plain, opened)
+}
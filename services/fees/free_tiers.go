@@ -0,0 +1,244 @@
+package fees
+
+import (
+	"math"
+	"math/big"
+	"time"
+
+	"encore.app/apierr"
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// maxFreeTiers caps the free-tier rules of a bill or plan.
+	maxFreeTiers = 20
+	// maxTrialDays caps the length of a trial.
+	maxTrialDays = 365
+)
+
+// FreeTier is a rule waiving part of what a bill charges: the first Units units of a
+// metric's usage on each bill, or everything charged in the first Days days of a
+// trial. A rule sets one of Units and Days.
+type FreeTier struct {
+	// Metric is the metric the rule waives usage of. Units need one; a trial without
+	// one waives every charge.
+	Metric string  `json:"metric,omitempty"`
+	Units  float64 `json:"units,omitempty"`
+	Days   int     `json:"days,omitempty"`
+}
+
+// normalize rejects rules that could not waive anything.
+func (t *FreeTier) normalize() error {
+	if (t.Units == 0) == (t.Days == 0) {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "a free tier must set one of units and days")
+	}
+	if t.Metric != "" && (len(t.Metric) > maxMetricLength || !metricPattern.MatchString(t.Metric)) {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "invalid metric %q: must be at most %d lowercase letters, digits, dots, dashes and underscores", t.Metric, maxMetricLength)
+	}
+	if t.Days != 0 {
+		if t.Days < 0 || t.Days > maxTrialDays {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "trial days must be between 1 and %d, got %d", maxTrialDays, t.Days)
+		}
+		return nil
+	}
+	if t.Metric == "" {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "free units need a metric")
+	}
+	if math.IsNaN(t.Units) || math.IsInf(t.Units, 0) || t.Units < 0 {
+		return apierr.InvalidArgument(apierr.InvalidAmount, "free units of metric %s must be a positive number, got %v", t.Metric, t.Units)
+	}
+	return nil
+}
+
+// normalizeFreeTiers normalizes each rule, and rejects free units of metrics prices
+// does not price and rules given twice.
+func normalizeFreeTiers(tiers []FreeTier, prices []UsagePrice) error {
+	if len(tiers) > maxFreeTiers {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "at most %d free tiers can be given", maxFreeTiers)
+	}
+	units := make(map[string]bool, len(tiers))
+	trials := make(map[string]bool, len(tiers))
+	for i := range tiers {
+		t := &tiers[i]
+		if err := t.normalize(); err != nil {
+			return err
+		}
+		if t.Days != 0 {
+			if trials[t.Metric] {
+				return apierr.InvalidArgument(apierr.InvalidParameter, "only one trial can be given per metric, and one for all charges")
+			}
+			trials[t.Metric] = true
+			continue
+		}
+		if !priced(prices, t.Metric) {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "free units of metric %s need a usage price for it", t.Metric)
+		}
+		if units[t.Metric] {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "metric %s has free units twice", t.Metric)
+		}
+		units[t.Metric] = true
+	}
+	return nil
+}
+
+// priced reports whether prices price metric.
+func priced(prices []UsagePrice, metric string) bool {
+	for _, p := range prices {
+		if p.Metric == metric {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTrial reports whether the bill's free tiers include a trial.
+func (p *BillWorkflowParams) hasTrial() bool {
+	for _, t := range p.FreeTiers {
+		if t.Days != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// freeAmount returns the price of the first free units of quantity units of usage. Under
+// volume pricing every unit costs the price of the tier the whole usage falls in, so
+// the free units do too.
+func (p *UsagePrice) freeAmount(quantity, free float64) float64 {
+	if quantity <= free {
+		return p.amount(quantity)
+	}
+	if p.Mode != TierModeVolume {
+		return p.amount(free)
+	}
+	return roundedAmount(new(big.Rat).Mul(decimalRat(free), p.volumeUnitPrice(decimalRat(quantity))))
+}
+
+// freeUnits returns the units of metric the bill's free tiers waive, or 0.
+func (b *Bill) freeUnits(metric string) float64 {
+	for _, t := range b.FreeTiers {
+		if t.Units != 0 && t.Metric == metric {
+			return t.Units
+		}
+	}
+	return 0
+}
+
+// inTrial reports whether one of the bill's trials waives what it charges for metric
+// at at. An empty metric is a charge that rates no usage.
+func (b *Bill) inTrial(metric string, at time.Time) bool {
+	if b.TrialStart == nil {
+		return false
+	}
+	for _, t := range b.FreeTiers {
+		if t.Days == 0 || (t.Metric != "" && t.Metric != metric) {
+			continue
+		}
+		if at.Before(b.TrialStart.AddDate(0, 0, t.Days)) {
+			return true
+		}
+	}
+	return false
+}
+
+// waivedUsage returns what the bill's free tiers have waived of metric's usage so far.
+func (b *Bill) waivedUsage(metric string) float64 {
+	var waived float64
+	for _, item := range b.LineItems {
+		if item.Metric == metric {
+			waived += item.Waived
+		}
+	}
+	return waived
+}
+
+// waive splits amount, what an item of itemType sent with signal at at bills, into the
+// part still billed and the part the bill's free tiers waive, and reports whether a
+// trial waived it. Free tiers apply to charges and rated usage, but not to the bill's
+// commitment, sub-bill totals, or items forwarded from an earlier period's bill.
+//
+// For usage, amount is the rated amount of the item. What the metric's items waive in
+// all is kept at the price of its free units, or of all of it during a trial, so once a
+// trial ends its usage still counts against the free units. It never exceeds the price
+// of the usage, and, like usage repricing, only volume pricing lowers it.
+func (b *Bill) waive(signal AddLineItemSignal, itemType LineItemType, amount float64, at time.Time) (billed, waived float64, trial bool) {
+	if len(b.FreeTiers) == 0 || signal.Commitment || signal.SubBillID != "" || signal.RoutedFrom != nil {
+		return amount, 0, false
+	}
+	if signal.Metric == "" {
+		if itemType == LineItemCharge && amount > 0 && b.inTrial("", at) {
+			return 0, b.round(amount), true
+		}
+		return amount, 0, false
+	}
+	price := b.usagePrice(signal.Metric)
+	if price == nil {
+		return amount, 0, false
+	}
+	used, _ := b.metricUsage(signal.Metric)
+	quantity := used + signal.Quantity
+	priced := b.round(price.amount(quantity))
+	before := b.waivedUsage(signal.Metric)
+	target := before
+	trial = b.inTrial(signal.Metric, at)
+	if trial {
+		target = priced
+	} else if free := b.freeUnits(signal.Metric); free > 0 {
+		target = max(target, b.round(price.freeAmount(quantity, free)))
+	}
+	waived = b.round(min(target, priced) - before)
+	return b.round(amount - waived), waived, trial
+}
+
+// ------ Workflow ------
+
+// waiverLineItemID derives the ID of the item recording what was waived of a line item,
+// so a replayed item records the same waiver.
+func waiverLineItemID(lineItemID string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte("feems/waiver/"+lineItemID)).String()
+}
+
+// waiverDescription describes the item recording what was waived of an item.
+func waiverDescription(description string, trial bool) string {
+	if trial {
+		return "Free trial: " + description
+	}
+	return "Free tier: " + description
+}
+
+// addWaiverItem records what the bill's free tiers waived of item as a zero-amount line
+// item referencing it, so the customer sees what they would have paid. Like the bill
+// limits adjustment, it is always repaired if it cannot be saved, since the free units
+// left are counted from it.
+func (w *billWorkflow) addWaiverItem(item LineItem, waived float64, trial bool) {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+	waiver := LineItem{
+		ID:             waiverLineItemID(item.ID),
+		Description:    waiverDescription(item.Description, trial),
+		Type:           LineItemCharge,
+		Reference:      &LineItemReference{LineItemID: item.ID},
+		Metric:         item.Metric,
+		Waived:         waived,
+		CreatedByKeyID: item.CreatedByKeyID,
+	}
+	bill.LineItems = append(bill.LineItems, waiver)
+	logger.Info("Free tier waived part of line item", "bill_id", bill.ID, "line_item_id", item.ID, "waived", waived, "trial", trial)
+
+	params := SaveLineItemActivityParams{
+		LineItemID:     waiver.ID,
+		BillID:         bill.ID,
+		Description:    waiver.Description,
+		Type:           waiver.Type,
+		Reference:      waiver.Reference,
+		Metric:         waiver.Metric,
+		Waived:         waiver.Waived,
+		CreatedAt:      workflow.Now(ctx),
+		CreatedByKeyID: waiver.CreatedByKeyID,
+		BillVersion:    bill.Version,
+	}
+	if err := workflow.ExecuteActivity(ctx, SaveLineItemActivityName, params).Get(ctx, nil); err != nil {
+		logger.Error("Failed to execute SaveLineItemActivity for waiver", "bill_id", bill.ID, "line_item_id", waiver.ID, "error", err)
+		w.compensateSave(params, err, true)
+	}
+}
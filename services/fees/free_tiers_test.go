@@ -0,0 +1,104 @@
+package fees
+
+import (
+	"testing"
+	"time"
+
+	"encore.app/apierr"
+	"github.com/stretchr/testify/require"
+)
+
+// flatCalls prices API calls at 0.01 each.
+var flatCalls = UsagePrice{Metric: "api_calls", Mode: TierModeGraduated, Tiers: []PriceTier{{UnitPrice: 0.01}}}
+
+// TestNormalizeFreeTiers tests that a rule sets one of units and days, and that free
+// units are only given for priced metrics, once.
+func TestNormalizeFreeTiers(t *testing.T) {
+	prices := []UsagePrice{flatCalls}
+	require.NoError(t, normalizeFreeTiers([]FreeTier{{Metric: "api_calls", Units: 1000}, {Days: 14}, {Metric: "api_calls", Days: 30}}, prices))
+
+	for _, tiers := range [][]FreeTier{
+		{{Metric: "api_calls"}},
+		{{Metric: "api_calls", Units: 1000, Days: 14}},
+		{{Units: 1000}},
+		{{Metric: "storage.gb", Units: 100}},
+		{{Days: maxTrialDays + 1}},
+		{{Metric: "API Calls", Days: 14}},
+		{{Metric: "api_calls", Units: 1000}, {Metric: "api_calls", Units: 500}},
+		{{Days: 14}, {Days: 30}},
+	} {
+		require.Equal(t, apierr.InvalidParameter, apierr.ReasonOf(normalizeFreeTiers(tiers, prices)), "%+v", tiers)
+	}
+	require.Equal(t, apierr.InvalidAmount, apierr.ReasonOf(normalizeFreeTiers([]FreeTier{{Metric: "api_calls", Units: -1}}, prices)))
+}
+
+// TestUsagePriceFreeAmount tests the price of free units under both tier modes: at the
+// tiers they fall in when graduated, and at the tier of the whole usage when volume.
+func TestUsagePriceFreeAmount(t *testing.T) {
+	graduated, volume := tieredPrices[0], tieredPrices[1]
+	require.Equal(t, 5.0, graduated.freeAmount(500, 1000))
+	require.Equal(t, 10.0, graduated.freeAmount(11001, 1000))
+	require.Equal(t, 5.0, volume.freeAmount(500, 1000))
+	require.Equal(t, 5.0, volume.freeAmount(11001, 1000))
+}
+
+// TestBillWaive tests that free units and trials split what items bill from what they
+// waive, and that usage waived in a trial counts against the free units after it.
+func TestBillWaive(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	inTrial, afterTrial := start.Add(time.Hour), start.AddDate(0, 0, 15)
+	bill := &Bill{
+		UsagePrices: []UsagePrice{flatCalls},
+		FreeTiers:   []FreeTier{{Metric: "api_calls", Units: 1000}, {Days: 14}},
+		TrialStart:  &start,
+	}
+	add := func(signal AddLineItemSignal, at time.Time) (float64, float64, bool) {
+		amount := signal.Amount
+		if signal.Metric != "" {
+			amount, _ = bill.rateUsage(signal.Metric, signal.Quantity)
+		}
+		billed, waived, trial := bill.waive(signal, LineItemCharge, amount, at)
+		bill.LineItems = append(bill.LineItems, LineItem{Amount: billed, Metric: signal.Metric, Quantity: signal.Quantity}, LineItem{Metric: signal.Metric, Waived: waived})
+		return billed, waived, trial
+	}
+
+	billed, waived, trial := add(AddLineItemSignal{Amount: 50}, inTrial)
+	require.Equal(t, []any{0.0, 50.0, true}, []any{billed, waived, trial})
+	billed, waived, trial = add(AddLineItemSignal{Metric: "api_calls", Quantity: 600}, inTrial)
+	require.Equal(t, []any{0.0, 6.0, true}, []any{billed, waived, trial})
+	billed, waived, trial = add(AddLineItemSignal{Metric: "api_calls", Quantity: 600}, afterTrial)
+	require.Equal(t, []any{2.0, 4.0, false}, []any{billed, waived, trial})
+	billed, waived, _ = add(AddLineItemSignal{Metric: "api_calls", Quantity: 100}, afterTrial)
+	require.Equal(t, []float64{1, 0}, []float64{billed, waived})
+	billed, waived, _ = add(AddLineItemSignal{Amount: 30}, afterTrial)
+	require.Equal(t, []float64{30, 0}, []float64{billed, waived})
+	require.Equal(t, 33.0, bill.lineItemTotal())
+
+	// The commitment, sub-bill totals and forwarded items are never waived.
+	for _, signal := range []AddLineItemSignal{{Amount: 10, Commitment: true}, {Amount: 10, SubBillID: "sub-1"}, {Amount: 10, RoutedFrom: &RoutedFrom{BillID: "b0"}}} {
+		billed, waived, _ := bill.waive(signal, LineItemCharge, 10, inTrial)
+		require.Equal(t, []float64{10, 0}, []float64{billed, waived}, "%+v", signal)
+	}
+}
+
+// TestBillWaiveVolume tests that, under volume pricing, what a trial waived is lowered
+// to the price of the usage once it reaches a cheaper tier, rather than billing the
+// customer less than nothing.
+func TestBillWaiveVolume(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	bill := &Bill{UsagePrices: tieredPrices[1:2], FreeTiers: []FreeTier{{Metric: "api_calls", Days: 1}}, TrialStart: &start}
+	rate := func(quantity float64, at time.Time) (float64, float64) {
+		amount, _ := bill.rateUsage("api_calls", quantity)
+		billed, waived, _ := bill.waive(AddLineItemSignal{Metric: "api_calls", Quantity: quantity}, LineItemCharge, amount, at)
+		bill.LineItems = append(bill.LineItems, LineItem{Amount: billed, Metric: "api_calls", Quantity: quantity}, LineItem{Metric: "api_calls", Waived: waived})
+		return billed, waived
+	}
+
+	billed, waived := rate(1000, start)
+	require.Equal(t, []float64{0, 10}, []float64{billed, waived})
+	// 1,001 calls are priced at 8.008, less than the trial waived.
+	billed, waived = rate(1, start.Add(48*time.Hour))
+	require.Equal(t, []float64{0, -1.992}, []float64{billed, waived})
+	billed, waived = rate(1, start.Add(48*time.Hour))
+	require.Equal(t, []float64{0.008, 0}, []float64{billed, waived})
+}
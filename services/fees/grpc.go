@@ -0,0 +1,552 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"encore.dev/beta/errs"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"encore.app/apierr"
+	"encore.app/services/fees/feespb"
+)
+
+// grpcErrorDomain is the domain of the ErrorInfo details attached to gRPC errors.
+const grpcErrorDomain = "fees"
+
+// watchBillInterval is how often WatchBill polls the bill workflow for changes.
+const watchBillInterval = time.Second
+
+// grpcServer serves feespb.FeesService by delegating to the same Service methods
+// that back the Encore HTTP endpoints.
+type grpcServer struct {
+	feespb.UnimplementedFeesServiceServer
+	svc *Service
+}
+
+// startGRPCServer listens on addr and serves the fees gRPC API in the background.
+func startGRPCServer(addr string, svc *Service) (*grpc.Server, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on %s: %w", addr, err)
+	}
+
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(svc.instrumentUnaryInterceptor, svc.authUnaryInterceptor, svc.logUnaryInterceptor, svc.throttleUnaryInterceptor, retrySafetyInterceptor),
+		grpc.ChainStreamInterceptor(svc.instrumentStreamInterceptor, svc.authStreamInterceptor, svc.logStreamInterceptor, svc.throttleStreamInterceptor),
+	)
+	feespb.RegisterFeesServiceServer(srv, &grpcServer{svc: svc})
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			slog.Error("gRPC server stopped", "addr", addr, "error", err)
+		}
+	}()
+	slog.Info("gRPC server listening", "addr", addr)
+	return srv, nil
+}
+
+func (g *grpcServer) CreateBill(ctx context.Context, req *feespb.CreateBillRequest) (*feespb.CreateBillResponse, error) {
+	if req.GetCurrency() == "" {
+		return nil, grpcError(apierr.InvalidArgument(apierr.InvalidCurrency, "currency is required"))
+	}
+	resp, err := g.svc.CreateBill(ctx, &CreateBillRequest{
+		CustomerID:       req.GetCustomerId(),
+		Currency:         req.GetCurrency(),
+		AutoCollect:      req.GetAutoCollect(),
+		CloseGracePeriod: req.GetCloseGracePeriod(),
+		TemplateID:       req.GetTemplateId(),
+		DueDate:          timeFromProto(req.GetDueDate()),
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &feespb.CreateBillResponse{
+		BillId:          resp.BillID,
+		WorkflowId:      resp.WorkflowID,
+		RunId:           resp.RunID,
+		InitialStatus:   billStatusToProto(resp.InitialStatus),
+		ConfirmationMsg: resp.ConfirmationMsg,
+	}, nil
+}
+
+func (g *grpcServer) AddLineItem(ctx context.Context, req *feespb.AddLineItemRequest) (*feespb.AddLineItemResponse, error) {
+	if req.GetBillId() == "" {
+		return nil, grpcError(apierr.InvalidArgument(apierr.InvalidParameter, "bill_id is required"))
+	}
+	resp, err := g.svc.AddLineItem(ctx, req.GetBillId(), &AddLineItemRequest{
+		Description:     req.GetDescription(),
+		Amount:          req.GetAmount(),
+		Quantity:        req.GetQuantity(),
+		UnitPrice:       req.GetUnitPrice(),
+		Unit:            req.GetUnit(),
+		Currency:        req.GetCurrency(),
+		Metric:          req.GetMetric(),
+		Type:            LineItemType(req.GetType()),
+		ReasonCode:      req.GetReasonCode(),
+		Reference:       lineItemReferenceFromProto(req.GetReference()),
+		ClientReference: req.GetClientReference(),
+		Wait:            req.GetWait(),
+		IfMatch:         ifMatch(req.GetIfVersion()),
+
+		AllowNegativeTotal: req.GetAllowNegativeTotal(),
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	out := &feespb.AddLineItemResponse{
+		LineItemId:      resp.LineItemID,
+		BillId:          resp.BillID,
+		ItemCount:       int32(resp.ItemCount),
+		Duplicate:       resp.Duplicate,
+		ConfirmationMsg: resp.ConfirmationMsg,
+	}
+	if resp.TotalAmount != nil {
+		out.TotalAmount = *resp.TotalAmount
+		out.Status = billStatusToProto(resp.Status)
+	}
+	return out, nil
+}
+
+func (g *grpcServer) CloseBill(ctx context.Context, req *feespb.CloseBillRequest) (*feespb.CloseBillResponse, error) {
+	if req.GetBillId() == "" {
+		return nil, grpcError(apierr.InvalidArgument(apierr.InvalidParameter, "bill_id is required"))
+	}
+	closeReq := &CloseBillRequest{GracePeriod: req.GetGracePeriod(), IfMatch: ifMatch(req.GetIfVersion())}
+	if dueDate := timeFromProto(req.GetDueDate()); dueDate != nil {
+		closeReq.DueDate = dueDate.Format(time.RFC3339Nano)
+	}
+	resp, err := g.svc.CloseBill(ctx, req.GetBillId(), closeReq)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &feespb.CloseBillResponse{
+		Bill:            billToProto(&resp.Bill),
+		ConfirmationMsg: resp.ConfirmationMsg,
+	}, nil
+}
+
+func (g *grpcServer) GetBill(ctx context.Context, req *feespb.GetBillRequest) (*feespb.Bill, error) {
+	if req.GetBillId() == "" {
+		return nil, grpcError(apierr.InvalidArgument(apierr.InvalidParameter, "bill_id is required"))
+	}
+	resp, err := g.svc.getBill(ctx, req.GetBillId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return billToProto(&resp.RetrievedBill), nil
+}
+
+func (g *grpcServer) ListBills(ctx context.Context, req *feespb.ListBillsRequest) (*feespb.ListBillsResponse, error) {
+	params := &ListBillsParams{
+		Currency: req.GetCurrency(),
+		Limit:    int(req.GetLimit()),
+		Offset:   int(req.GetOffset()),
+	}
+	if req.GetStatus() != feespb.BillStatus_BILL_STATUS_UNSPECIFIED {
+		params.Status = string(billStatusFromProto(req.GetStatus()))
+	}
+	resp, err := g.svc.ListBills(ctx, params)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	out := &feespb.ListBillsResponse{
+		Bills:      make([]*feespb.Bill, 0, len(resp.Bills)),
+		TotalCount: int32(resp.TotalCount),
+		Limit:      int32(resp.Limit),
+		Offset:     int32(resp.Offset),
+	}
+	for i := range resp.Bills {
+		out.Bills = append(out.Bills, billToProto(&resp.Bills[i]))
+	}
+	return out, nil
+}
+
+func (g *grpcServer) PayBill(ctx context.Context, req *feespb.PayBillRequest) (*feespb.PayBillResponse, error) {
+	if req.GetBillId() == "" {
+		return nil, grpcError(apierr.InvalidArgument(apierr.InvalidParameter, "bill_id is required"))
+	}
+	resp, err := g.svc.PayBill(ctx, req.GetBillId(), &PayBillRequest{IfMatch: ifMatch(req.GetIfVersion())})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &feespb.PayBillResponse{
+		BillId:          resp.BillID,
+		PaymentId:       resp.PaymentID,
+		Status:          billStatusToProto(resp.Status),
+		ConfirmationMsg: resp.ConfirmationMsg,
+	}, nil
+}
+
+func (g *grpcServer) CreateRefund(ctx context.Context, req *feespb.CreateRefundRequest) (*feespb.CreateRefundResponse, error) {
+	if req.GetBillId() == "" {
+		return nil, grpcError(apierr.InvalidArgument(apierr.InvalidParameter, "bill_id is required"))
+	}
+	resp, err := g.svc.CreateRefund(ctx, req.GetBillId(), &CreateRefundRequest{
+		Amount:  req.GetAmount(),
+		Reason:  req.GetReason(),
+		IfMatch: ifMatch(req.GetIfVersion()),
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &feespb.CreateRefundResponse{
+		BillId:          resp.BillID,
+		CreditNoteId:    resp.CreditNoteID,
+		Amount:          resp.Amount,
+		Status:          string(resp.Status),
+		ConfirmationMsg: resp.ConfirmationMsg,
+	}, nil
+}
+
+// WatchBill polls the bill workflow and streams a snapshot each time the bill changes,
+// until the bill is PAID or DELINQUENT or the client goes away.
+func (g *grpcServer) WatchBill(req *feespb.WatchBillRequest, stream feespb.FeesService_WatchBillServer) error {
+	if req.GetBillId() == "" {
+		return grpcError(apierr.InvalidArgument(apierr.InvalidParameter, "bill_id is required"))
+	}
+	ctx := stream.Context()
+	ticker := time.NewTicker(watchBillInterval)
+	defer ticker.Stop()
+
+	var last *feespb.Bill
+	for {
+		resp, err := g.svc.getBill(ctx, req.GetBillId())
+		if err != nil {
+			return grpcError(err)
+		}
+		current := billToProto(&resp.RetrievedBill)
+		if last == nil || !proto.Equal(last, current) {
+			if err := stream.Send(current); err != nil {
+				return err
+			}
+			last = current
+		}
+		if resp.RetrievedBill.Status == BillStatusPaid || resp.RetrievedBill.Status == BillStatusDelinquent {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// retrySafetyInterceptor applies the RetrySafety rules to unary RPCs, reading the
+// idempotency key and retry attempt from request metadata and reporting the retry
+// semantics in response header metadata.
+func retrySafetyInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	rpc := rpcName(info.FullMethod)
+	semantics, mutating := mutatingEndpoints[rpc]
+	if !mutating {
+		return handler(ctx, req)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	key := firstMetadataValue(md, IdempotencyKeyHeader)
+	if semantics == idempotentWithKey && key == "" && isRetry(firstMetadataValue(md, RetryAttemptHeader)) {
+		return nil, grpcError(apierr.FailedPrecondition(apierr.UnsafeRetry, "%s is not idempotent; retries must carry an %s header", rpc, IdempotencyKeyHeader))
+	}
+	if key != "" {
+		ctx = withIdempotencyKey(ctx, key)
+	}
+
+	header := metadata.Pairs("idempotent", strconv.FormatBool(semantics == idempotent || key != ""))
+	if key != "" {
+		header.Set(IdempotencyKeyHeader, key)
+	}
+	if err := grpc.SetHeader(ctx, header); err != nil {
+		loggerFrom(ctx).Warn("Failed to set gRPC retry metadata", "method", info.FullMethod, "error", err)
+	}
+	return handler(ctx, req)
+}
+
+// authUnaryInterceptor authenticates unary RPCs with the API key sent as
+// "authorization: Bearer <key>" metadata and enforces the endpoint scopes.
+func (s *Service) authUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := s.authenticateRPC(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authStreamInterceptor applies the same authentication to streaming RPCs.
+func (s *Service) authStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticateRPC(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticateRPC returns a context carrying the caller's API key, or a gRPC error
+// if the key is missing, invalid, or lacks the scope of the method.
+func (s *Service) authenticateRPC(ctx context.Context, fullMethod string) (context.Context, error) {
+	rpc := rpcName(fullMethod)
+	md, _ := metadata.FromIncomingContext(ctx)
+	token := strings.TrimPrefix(firstMetadataValue(md, "authorization"), "Bearer ")
+	if token == "" {
+		return nil, grpcError(apierr.Unauthenticated(apierr.InvalidAPIKey, "%s requires an API key", rpc))
+	}
+	data, err := s.authenticate(ctx, token)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := checkScope(rpc, data); err != nil {
+		return nil, grpcError(err)
+	}
+	return withCaller(ctx, data), nil
+}
+
+// instrumentUnaryInterceptor records the count, result code, and latency of unary RPCs.
+// It runs first so rejected calls are counted too.
+func (s *Service) instrumentUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	s.metrics.observeRequest("grpc", rpcName(info.FullMethod), grpcCode(err), time.Since(start))
+	return resp, err
+}
+
+// instrumentStreamInterceptor records the outcome and duration of streaming RPCs.
+func (s *Service) instrumentStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	s.metrics.observeRequest("grpc", rpcName(info.FullMethod), grpcCode(err), time.Since(start))
+	return err
+}
+
+// grpcCode names the status of an RPC error with the matching Encore error code, so
+// both transports report the same code labels.
+func grpcCode(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return errs.ErrCode(status.Code(err)).String()
+}
+
+// throttleUnaryInterceptor applies the per-key rate limit to unary RPCs.
+func (s *Service) throttleUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.limits.checkKey(ctx); err != nil {
+		return nil, grpcError(err)
+	}
+	return handler(ctx, req)
+}
+
+// throttleStreamInterceptor applies the per-key rate limit to opening a stream.
+func (s *Service) throttleStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.limits.checkKey(ss.Context()); err != nil {
+		return grpcError(err)
+	}
+	return handler(srv, ss)
+}
+
+// authenticatedStream overrides the context of a server stream.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context { return s.ctx }
+
+// rpcName returns the method name of a full gRPC method, e.g. "CreateBill" for
+// "/fees.v1.FeesService/CreateBill".
+func rpcName(fullMethod string) string {
+	return fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+}
+
+// firstMetadataValue returns the first value of the metadata key, or "".
+func firstMetadataValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// grpcError converts a service error into a gRPC status. API errors keep their code and
+// carry their reason as an ErrorInfo detail; otherwise the code of any Temporal service
+// error (e.g. NotFound for an unknown bill) in the chain is used.
+func grpcError(err error) error {
+	var apiErr *errs.Error
+	if errors.As(err, &apiErr) {
+		// Encore error codes share their numeric values with gRPC codes.
+		st := status.New(codes.Code(apiErr.Code), apiErr.Message)
+		var details []protoadapt.MessageV1
+		if reason := apierr.ReasonOf(err); reason != "" {
+			details = append(details, &errdetails.ErrorInfo{Reason: string(reason), Domain: grpcErrorDomain})
+		}
+		if retryAfter := apierr.RetryAfter(err); retryAfter > 0 {
+			details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+		}
+		if len(details) > 0 {
+			if withDetails, detailErr := st.WithDetails(details...); detailErr == nil {
+				st = withDetails
+			}
+		}
+		return st.Err()
+	}
+	var svcErr interface{ Status() *status.Status }
+	if errors.As(err, &svcErr) {
+		return status.Error(svcErr.Status().Code(), err.Error())
+	}
+	if ctxErr := status.FromContextError(err); ctxErr.Code() != codes.Unknown {
+		return ctxErr.Err()
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// ------ Conversions ------
+
+var billStatusProtoValues = map[BillStatus]feespb.BillStatus{
+	BillStatusOpen:            feespb.BillStatus_BILL_STATUS_OPEN,
+	BillStatusClosing:         feespb.BillStatus_BILL_STATUS_CLOSING,
+	BillStatusPendingApproval: feespb.BillStatus_BILL_STATUS_PENDING_APPROVAL,
+	BillStatusClosed:          feespb.BillStatus_BILL_STATUS_CLOSED,
+	BillStatusPaid:            feespb.BillStatus_BILL_STATUS_PAID,
+	BillStatusPaymentFailed:   feespb.BillStatus_BILL_STATUS_PAYMENT_FAILED,
+	BillStatusDelinquent:      feespb.BillStatus_BILL_STATUS_DELINQUENT,
+	BillStatusCloseFailed:     feespb.BillStatus_BILL_STATUS_CLOSE_FAILED,
+	BillStatusOverdue:         feespb.BillStatus_BILL_STATUS_OVERDUE,
+	BillStatusPartiallyPaid:   feespb.BillStatus_BILL_STATUS_PARTIALLY_PAID,
+}
+
+func billStatusToProto(s BillStatus) feespb.BillStatus {
+	return billStatusProtoValues[s]
+}
+
+func billStatusFromProto(s feespb.BillStatus) BillStatus {
+	for bs, value := range billStatusProtoValues {
+		if value == s {
+			return bs
+		}
+	}
+	return BillStatus(s.String())
+}
+
+// ifMatch formats an RPC's if_version as the If-Match header the HTTP API takes.
+func ifMatch(version int64) string {
+	if version == 0 {
+		return ""
+	}
+	return strconv.FormatInt(version, 10)
+}
+
+func billToProto(b *Bill) *feespb.Bill {
+	out := &feespb.Bill{
+		Id:               b.ID,
+		TenantId:         tenantOrDefault(b.TenantID),
+		CustomerId:       b.CustomerID,
+		Currency:         b.Currency,
+		Status:           billStatusToProto(b.Status),
+		LineItems:        lineItemsToProto(b.LineItems),
+		TotalAmount:      b.TotalAmount,
+		CreatedAt:        timeToProto(b.CreatedAt),
+		ClosedAt:         timeToProto(b.ClosedAt),
+		CloseRequestedAt: timeToProto(b.CloseRequestedAt),
+		FinalizesAt:      timeToProto(b.FinalizesAt),
+		DueDate:          timeToProto(b.DueDate),
+		AutoCollect:      b.AutoCollect,
+		RefundedAmount:   b.RefundedAmount,
+		TemplateId:       b.TemplateID,
+		Version:          b.Version,
+		AmountPaid:       b.AmountPaid,
+	}
+	if b.BalanceDue != nil {
+		out.BalanceDue = *b.BalanceDue
+	}
+	for _, p := range b.Payments {
+		out.Payments = append(out.Payments, &feespb.Payment{
+			Id:               p.ID,
+			Status:           string(p.Status),
+			Amount:           p.Amount,
+			GatewayReference: p.GatewayReference,
+			FailureReason:    p.FailureReason,
+			CreatedAt:        timeToProto(p.CreatedAt),
+			CompletedAt:      timeToProto(p.CompletedAt),
+			Method:           p.Method,
+		})
+	}
+	for _, cn := range b.CreditNotes {
+		out.CreditNotes = append(out.CreditNotes, &feespb.CreditNote{
+			Id:               cn.ID,
+			Status:           string(cn.Status),
+			Amount:           cn.Amount,
+			Reason:           cn.Reason,
+			LineItems:        lineItemsToProto(cn.LineItems),
+			GatewayReference: cn.GatewayReference,
+			FailureReason:    cn.FailureReason,
+			CreatedAt:        timeToProto(cn.CreatedAt),
+			CompletedAt:      timeToProto(cn.CompletedAt),
+		})
+	}
+	return out
+}
+
+func lineItemsToProto(items []LineItem) []*feespb.LineItem {
+	out := make([]*feespb.LineItem, 0, len(items))
+	for _, item := range items {
+		pbItem := &feespb.LineItem{
+			Id:              item.ID,
+			Description:     item.Description,
+			Amount:          item.Amount,
+			Type:            string(item.itemType()),
+			ReasonCode:      item.ReasonCode,
+			Quantity:        item.Quantity,
+			UnitPrice:       item.UnitPrice,
+			Unit:            item.Unit,
+			Metric:          item.Metric,
+			Late:            item.Late,
+			ClientReference: item.ClientReference,
+		}
+		if item.Reference != nil {
+			pbItem.Reference = &feespb.LineItemReference{LineItemId: item.Reference.LineItemID, External: item.Reference.External}
+		}
+		if item.RoutedFrom != nil {
+			pbItem.RoutedFrom = &feespb.RoutedFrom{
+				BillId:      item.RoutedFrom.BillID,
+				PeriodStart: timeToProto(item.RoutedFrom.PeriodStart),
+				PeriodEnd:   timeToProto(item.RoutedFrom.PeriodEnd),
+			}
+		}
+		out = append(out, pbItem)
+	}
+	return out
+}
+
+func lineItemReferenceFromProto(r *feespb.LineItemReference) *LineItemReference {
+	if r == nil {
+		return nil
+	}
+	return &LineItemReference{LineItemID: r.GetLineItemId(), External: r.GetExternal()}
+}
+
+func timeToProto(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func timeFromProto(t *timestamppb.Timestamp) *time.Time {
+	if t == nil {
+		return nil
+	}
+	v := t.AsTime()
+	return &v
+}
@@ -0,0 +1,84 @@
+package fees
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/serviceerror"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"encore.app/apierr"
+	"encore.app/services/fees/feespb"
+)
+
+// TestBillToProto tests that a bill and its nested items convert to the gRPC representation.
+func TestBillToProto(t *testing.T) {
+	createdAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	closedAt := createdAt.Add(time.Hour)
+	bill := &Bill{
+		ID:          "bill-1",
+		CustomerID:  "cust-1",
+		Currency:    "USD",
+		Status:      BillStatusClosed,
+		TotalAmount: 15,
+		CreatedAt:   &createdAt,
+		ClosedAt:    &closedAt,
+		LineItems: []LineItem{
+			{ID: "li-1", Description: "Usage", Amount: 10, Quantity: 125, UnitPrice: 0.08, Unit: "GB"},
+			{ID: "li-2", Description: "Late usage", Amount: 5, Quantity: 500, Metric: "api_calls", RoutedFrom: &RoutedFrom{BillID: "bill-0", PeriodEnd: &createdAt}},
+			{ID: "li-3", Description: "Outage credit", Amount: -2, Type: LineItemCredit, ReasonCode: "service_credit", Reference: &LineItemReference{LineItemID: "li-1"}},
+			{ID: "li-4", Description: "Old credit", Amount: -1},
+		},
+		Payments: []Payment{{ID: "pay-1", Status: PaymentStatusDeclined, Amount: 15, CreatedAt: &closedAt}},
+	}
+
+	pb := billToProto(bill)
+	require.Equal(t, "bill-1", pb.GetId())
+	require.Equal(t, feespb.BillStatus_BILL_STATUS_CLOSED, pb.GetStatus())
+	require.Equal(t, closedAt, pb.GetClosedAt().AsTime())
+	require.Nil(t, pb.GetFinalizesAt())
+	require.Len(t, pb.GetLineItems(), 4)
+	require.Nil(t, pb.GetLineItems()[0].GetRoutedFrom())
+	require.Equal(t, 125.0, pb.GetLineItems()[0].GetQuantity())
+	require.Equal(t, 0.08, pb.GetLineItems()[0].GetUnitPrice())
+	require.Equal(t, "GB", pb.GetLineItems()[0].GetUnit())
+	require.Equal(t, "bill-0", pb.GetLineItems()[1].GetRoutedFrom().GetBillId())
+	require.Equal(t, "api_calls", pb.GetLineItems()[1].GetMetric())
+	require.Equal(t, "CHARGE", pb.GetLineItems()[0].GetType())
+	require.Equal(t, "CREDIT", pb.GetLineItems()[2].GetType())
+	require.Equal(t, "service_credit", pb.GetLineItems()[2].GetReasonCode())
+	require.Equal(t, "li-1", pb.GetLineItems()[2].GetReference().GetLineItemId())
+	require.Equal(t, "CREDIT", pb.GetLineItems()[3].GetType())
+	require.Equal(t, string(PaymentStatusDeclined), pb.GetPayments()[0].GetStatus())
+}
+
+// TestBillStatusProtoRoundTrip tests that every bill status survives conversion to and from the gRPC enum.
+func TestBillStatusProtoRoundTrip(t *testing.T) {
+	for s := range billStatusProtoValues {
+		require.True(t, s.IsValid())
+		require.Equal(t, s, billStatusFromProto(billStatusToProto(s)))
+	}
+	require.Equal(t, feespb.BillStatus_BILL_STATUS_UNSPECIFIED, billStatusToProto("UNKNOWN"))
+}
+
+// TestGRPCError tests that Temporal service errors keep their status code when returned over gRPC.
+func TestGRPCError(t *testing.T) {
+	err := grpcError(fmt.Errorf("failed to query BillWorkflow bill-x: %w", serviceerror.NewNotFound("workflow not found")))
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	err = grpcError(fmt.Errorf("bill %s is already paid", "bill-x"))
+	require.Equal(t, codes.Unknown, status.Code(err))
+
+	err = grpcError(apierr.FailedPrecondition(apierr.BillAlreadyPaid, "bill %s is already paid", "bill-x"))
+	st := status.Convert(err)
+	require.Equal(t, codes.FailedPrecondition, st.Code())
+	require.Equal(t, "bill bill-x is already paid", st.Message())
+	require.Len(t, st.Details(), 1)
+	info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+	require.True(t, ok)
+	require.Equal(t, "bill_already_paid", info.Reason)
+}
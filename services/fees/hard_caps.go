@@ -0,0 +1,75 @@
+package fees
+
+import (
+	"fmt"
+	"time"
+
+	"encore.app/apierr"
+)
+
+// HardCapAction decides what happens to a line item that would take a bill's total
+// above its hard cap.
+type HardCapAction string
+
+const (
+	// HardCapReject rejects the line item; the caller gets a quota_exceeded error.
+	HardCapReject HardCapAction = "reject"
+	// HardCapFlag accepts the line item and flags it as over the cap.
+	HardCapFlag HardCapAction = "flag"
+)
+
+// maxRejectedLineItems caps the rejected line items a bill keeps, oldest dropped first.
+const maxRejectedLineItems = 50
+
+// HardCap bounds a bill's total as its line items arrive, such as a prepaid customer's
+// balance.
+type HardCap struct {
+	Amount float64       `json:"amount"`
+	Action HardCapAction `json:"action"`
+}
+
+// RejectedLineItem is a line item refused because it would have taken its bill above
+// the bill's hard cap, or below zero without allowNegativeTotal.
+type RejectedLineItem struct {
+	LineItem
+	Reason     string    `json:"reason"`
+	RejectedAt time.Time `json:"rejectedAt"`
+}
+
+// hardCap returns the hard cap a bill created under l gets, or nil.
+func (l *BillLimits) hardCap() *HardCap {
+	if l == nil || l.HardCap <= 0 {
+		return nil
+	}
+	action := l.OverHardCap
+	if action == "" {
+		action = HardCapReject
+	}
+	return &HardCap{Amount: l.HardCap, Action: action}
+}
+
+// exceededBy reports whether adding amount to a bill totalling total goes above the cap.
+func (c *HardCap) exceededBy(total, amount float64) bool {
+	return c != nil && roundAmount(total+amount) > c.Amount
+}
+
+// rejects reports whether the cap refuses amount on a bill totalling total.
+func (c *HardCap) rejects(total, amount float64) bool {
+	return c.exceededBy(total, amount) && c.Action != HardCapFlag
+}
+
+// hardCapExceeded is the error returned for a line item the bill's hard cap rejects.
+func hardCapExceeded(bill *Bill, amount float64) error {
+	return apierr.ResourceExhausted(apierr.QuotaExceeded, "line item of %.2f %s would take bill %s to %.2f %s, above its hard cap of %.2f %s",
+		amount, bill.Currency, bill.ID, bill.lineItemTotal()+amount, bill.Currency, bill.HardCap.Amount, bill.Currency)
+}
+
+// ------ Workflow ------
+
+// rejectLineItem records a line item refused by the bill's hard cap, so a caller
+// waiting for it learns why.
+func (w *billWorkflow) rejectLineItem(item LineItem) {
+	bill := w.bill
+	w.recordRejectedLineItem(item, fmt.Sprintf("total would be %.2f %s, above the hard cap of %.2f %s", bill.lineItemTotal()+item.Amount, bill.Currency, bill.HardCap.Amount, bill.Currency))
+	w.logger.Warn("Line item rejected by the bill's hard cap", "bill_id", bill.ID, "line_item_id", item.ID, "amount", item.Amount, "total_amount", bill.TotalAmount, "hard_cap", bill.HardCap.Amount)
+}
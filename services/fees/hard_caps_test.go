@@ -0,0 +1,25 @@
+package fees
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestHardCap_Rejects tests that only line items going above the cap are refused, and
+// only under the reject action.
+func TestHardCap_Rejects(t *testing.T) {
+	var none *BillLimits
+	require.Nil(t, none.hardCap())
+	require.Nil(t, (&BillLimits{MaximumTotal: 100}).hardCap())
+	require.False(t, none.hardCap().rejects(1e9, 1))
+
+	limit := (&BillLimits{HardCap: 100}).hardCap()
+	require.Equal(t, &HardCap{Amount: 100, Action: HardCapReject}, limit)
+	require.False(t, limit.rejects(60, 40))
+	require.True(t, limit.rejects(60, 40.01))
+
+	flag := (&BillLimits{HardCap: 100, OverHardCap: HardCapFlag}).hardCap()
+	require.False(t, flag.rejects(60, 50))
+	require.True(t, flag.exceededBy(60, 50))
+}
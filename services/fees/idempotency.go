@@ -0,0 +1,179 @@
+package fees
+
+import (
+	"context"
+	"strconv"
+
+	"encore.app/apierr"
+	"encore.dev/middleware"
+	"github.com/google/uuid"
+)
+
+const (
+	// IdempotencyKeyHeader carries a client-chosen key identifying one logical operation.
+	// Retries of a keyed request reuse it and receive the original outcome.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// RetryAttemptHeader is sent by clients on retries (2 for the first retry, and so on)
+	// so unsafe retries of non-idempotent operations can be rejected.
+	RetryAttemptHeader = "X-Retry-Attempt"
+)
+
+// RetryMetadata is embedded in the responses of mutating endpoints to tell clients
+// whether the call may be retried safely.
+type RetryMetadata struct {
+	// Idempotent is true when repeating the call has no further effect.
+	Idempotent bool `header:"Idempotent"`
+	// IdempotencyKey echoes the key the request was processed under, if any.
+	IdempotencyKey string `header:"Idempotency-Key"`
+}
+
+func (m *RetryMetadata) setRetryMetadata(md RetryMetadata) { *m = md }
+
+// retrySemantics describes how an endpoint behaves when repeated.
+type retrySemantics int
+
+const (
+	// idempotent endpoints converge on the same state however often they are called.
+	idempotent retrySemantics = iota
+	// idempotentWithKey endpoints create something new on every call unless an
+	// idempotency key is given, in which case repeats return the original result.
+	idempotentWithKey
+)
+
+// mutatingEndpoints classifies every endpoint that changes state. Read-only endpoints
+// are not listed and pass through the middleware untouched.
+var mutatingEndpoints = map[string]retrySemantics{
+	"CreateBill":            idempotentWithKey,
+	"AddLineItem":           idempotentWithKey,
+	"CloseBill":             idempotent,
+	"RetryCloseBill":        idempotent,
+	"ApproveBill":           idempotent,
+	"RejectBill":            idempotent,
+	"PayBill":               idempotentWithKey,
+	"CreateRefund":          idempotentWithKey,
+	"PauseDunning":          idempotent,
+	"ResumeDunning":         idempotent,
+	"SetQuotaOverride":      idempotent,
+	"ClearQuotaOverride":    idempotent,
+	"SetBillLimits":         idempotent,
+	"ClearBillLimits":       idempotent,
+	"SetContract":           idempotent,
+	"ClearContract":         idempotent,
+	"CreateBillTemplate":    idempotentWithKey,
+	"UpdateBillTemplate":    idempotent,
+	"DeleteBillTemplate":    idempotent,
+	"CreateCoupon":          idempotentWithKey,
+	"UpdateCoupon":          idempotent,
+	"DeleteCoupon":          idempotent,
+	"SetBillHTMLSettings":   idempotent,
+	"ClearBillHTMLSettings": idempotent,
+	"SetCustomerContact":    idempotent,
+	"ClearCustomerContact":  idempotent,
+	"CreateAPIKey":          idempotentWithKey,
+	"RevokeAPIKey":          idempotent,
+	"SetAPIKeyRoles":        idempotent,
+
+	"CreateSubscription":     idempotentWithKey,
+	"ChangeSubscriptionPlan": idempotentWithKey,
+	"CancelSubscription":     idempotent,
+
+	"AddAttachment": idempotentWithKey,
+	"AddComment":    idempotentWithKey,
+	"ResendBill":    idempotentWithKey,
+
+	"GrantCredit": idempotentWithKey,
+
+	"RecordPayment": idempotentWithKey,
+
+	"SubmitDisputeEvidence": idempotent,
+
+	"CloseBills": idempotentWithKey,
+	"CancelJob":  idempotent,
+
+	"SetBillSpendingAlerts":       idempotent,
+	"SetPayerSplits":              idempotent,
+	"ApplyCoupon":                 idempotent,
+	"SetCustomerSpendingAlerts":   idempotent,
+	"ClearCustomerSpendingAlerts": idempotent,
+
+	"EraseCustomerData": idempotent,
+
+	"DeleteBill":  idempotent,
+	"RestoreBill": idempotent,
+
+	"EncryptLineItems":     idempotentWithKey,
+	"RebuildBillSummaries": idempotentWithKey,
+	"SnapshotAccruals":     idempotent,
+	"PurgeTestBills":       idempotent,
+}
+
+type idempotencyKeyCtxKey struct{}
+
+// withIdempotencyKey returns a context carrying the request's idempotency key.
+func withIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtxKey{}, key)
+}
+
+// idempotencyKey returns the idempotency key of the current request, or "".
+func idempotencyKey(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyCtxKey{}).(string)
+	return key
+}
+
+// keyedID derives a stable ID for the entity an idempotent request creates, scoped so the
+// same key used against different bills or tenants yields different IDs. Without a key it
+// returns a fresh random ID.
+func keyedID(ctx context.Context, scope ...string) string {
+	key := idempotencyKey(ctx)
+	if key == "" {
+		return uuid.NewString()
+	}
+	name := key
+	for _, s := range scope {
+		name = s + "/" + name
+	}
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte("feems/idempotency/"+name)).String()
+}
+
+// referenceID derives the ID of a line item from its client reference, so concurrent or
+// retried requests for the same usage record name the same item.
+func referenceID(billID, reference string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte("feems/client-reference/"+billID+"/"+reference)).String()
+}
+
+// RetrySafety enforces the retry semantics of mutating endpoints: it rejects retries of
+// non-idempotent calls that carry no idempotency key, passes the key to handlers, and
+// reports the semantics on the response.
+//
+// encore:middleware target=all
+func (s *Service) RetrySafety(req middleware.Request, next middleware.Next) middleware.Response {
+	data := req.Data()
+	semantics, mutating := mutatingEndpoints[data.Endpoint]
+	if !mutating {
+		return next(req)
+	}
+
+	key := data.Headers.Get(IdempotencyKeyHeader)
+	if semantics == idempotentWithKey && key == "" && isRetry(data.Headers.Get(RetryAttemptHeader)) {
+		return middleware.Response{Err: apierr.FailedPrecondition(apierr.UnsafeRetry,
+			"%s is not idempotent; retries must carry an %s header", data.Endpoint, IdempotencyKeyHeader)}
+	}
+	if key != "" {
+		req = req.WithContext(withIdempotencyKey(req.Context(), key))
+	}
+
+	resp := next(req)
+	if md, ok := resp.Payload.(interface{ setRetryMetadata(RetryMetadata) }); ok {
+		md.setRetryMetadata(RetryMetadata{
+			Idempotent:     semantics == idempotent || key != "",
+			IdempotencyKey: key,
+		})
+	}
+	return resp
+}
+
+// isRetry reports whether a retry-attempt header value marks a repeated request.
+func isRetry(attempt string) bool {
+	n, err := strconv.Atoi(attempt)
+	return err == nil && n > 1
+}
@@ -0,0 +1,35 @@
+package fees
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestKeyedID tests that keyed IDs are stable per key and scope, and random without a key.
+func TestKeyedID(t *testing.T) {
+	ctx := withIdempotencyKey(context.Background(), "usage-42")
+
+	require.Equal(t, keyedID(ctx, "line-item", "bill-1"), keyedID(ctx, "line-item", "bill-1"))
+	require.NotEqual(t, keyedID(ctx, "line-item", "bill-1"), keyedID(ctx, "line-item", "bill-2"))
+	require.NotEqual(t, keyedID(ctx, "line-item", "bill-1"), keyedID(ctx, "refund", "bill-1"))
+
+	unkeyed := context.Background()
+	require.NotEqual(t, keyedID(unkeyed, "line-item", "bill-1"), keyedID(unkeyed, "line-item", "bill-1"))
+}
+
+// TestReferenceID tests that line item IDs derived from client references are stable per bill.
+func TestReferenceID(t *testing.T) {
+	require.Equal(t, referenceID("bill-1", "usage-42"), referenceID("bill-1", "usage-42"))
+	require.NotEqual(t, referenceID("bill-1", "usage-42"), referenceID("bill-2", "usage-42"))
+	require.NotEqual(t, referenceID("bill-1", "usage-42"), referenceID("bill-1", "usage-43"))
+}
+
+// TestIsRetry tests parsing of the retry attempt header.
+func TestIsRetry(t *testing.T) {
+	require.False(t, isRetry(""))
+	require.False(t, isRetry("1"))
+	require.True(t, isRetry("2"))
+	require.False(t, isRetry("again"))
+}
@@ -0,0 +1,365 @@
+package fees
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/storage/sqldb"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// JobKind names the operation a job runs.
+type JobKind string
+
+const (
+	// JobKindCloseBatch closes the open bills matching a CloseBatchFilter.
+	JobKindCloseBatch JobKind = "close_batch"
+	// JobKindEncryptLineItems encrypts line items with the active field encryption key.
+	JobKindEncryptLineItems JobKind = "encrypt_line_items"
+	// JobKindRebuildBillSummaries recomputes the bill_summaries projection.
+	JobKindRebuildBillSummaries JobKind = "rebuild_bill_summaries"
+)
+
+// JobStatus is the lifecycle state of a job.
+type JobStatus string
+
+const (
+	JobStatusQueued    JobStatus = "QUEUED"
+	JobStatusRunning   JobStatus = "RUNNING"
+	JobStatusSucceeded JobStatus = "SUCCEEDED"
+	JobStatusFailed    JobStatus = "FAILED"
+	JobStatusCanceled  JobStatus = "CANCELED"
+)
+
+// IsFinal reports whether a job in status s has stopped.
+func (s JobStatus) IsFinal() bool {
+	return s == JobStatusSucceeded || s == JobStatusFailed || s == JobStatusCanceled
+}
+
+const (
+	// UpdateJobActivityName saves a job's status and progress.
+	UpdateJobActivityName = "UpdateJobActivity"
+
+	// maxJobFailedIDs caps the IDs of failed items a job reports.
+	maxJobFailedIDs = 100
+)
+
+// errJobContinue is returned by a job handler to continue the job in a new run,
+// bounding its history. The handler resumes from the job's cursor.
+var errJobContinue = errors.New("job continues as new")
+
+// JobProgress reports how many items of its work a job has handled.
+type JobProgress struct {
+	Processed int `json:"processed"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	// FailedIDs lists the first of the items that failed, such as bill IDs.
+	FailedIDs []string `json:"failedIds,omitempty"`
+}
+
+// fail counts a failed item, keeping its ID if there is room.
+func (p *JobProgress) fail(id string) {
+	p.Failed++
+	if len(p.FailedIDs) < maxJobFailedIDs {
+		p.FailedIDs = append(p.FailedIDs, id)
+	}
+}
+
+// Job is a long-running operation run by a JobWorkflow.
+type Job struct {
+	ID       string    `json:"id"`
+	Kind     JobKind   `json:"kind"`
+	TenantID string    `json:"tenantId,omitempty"`
+	Status   JobStatus `json:"status"`
+	// Params are the parameters the job was started with.
+	Params   json.RawMessage `json:"params"`
+	Progress JobProgress     `json:"progress"`
+	// Result is the outcome of a SUCCEEDED job, for kinds that produce one.
+	Result         json.RawMessage `json:"result,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	CreatedByKeyID string          `json:"createdByKeyId,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	StartedAt      *time.Time      `json:"startedAt,omitempty"`
+	// CancelRequestedAt is set once cancellation was requested; the job is CANCELED
+	// once it stops.
+	CancelRequestedAt *time.Time `json:"cancelRequestedAt,omitempty"`
+	CompletedAt       *time.Time `json:"completedAt,omitempty"`
+}
+
+// JobWorkflowParams defines parameters for JobWorkflow.
+type JobWorkflowParams struct {
+	JobID            string
+	Kind             JobKind
+	TenantID         string
+	RequestedByKeyID string
+	Params           json.RawMessage
+	// Started, Cursor and Progress carry the job over when it continues as new.
+	Started  bool
+	Cursor   string
+	Progress JobProgress
+}
+
+// UpdateJobActivityParams defines parameters for UpdateJobActivity.
+type UpdateJobActivityParams struct {
+	JobID    string
+	Status   JobStatus
+	Progress *JobProgress
+	Result   json.RawMessage
+	Error    string
+	At       time.Time
+}
+
+// jobWorkflowID returns the ID of the workflow that runs a job.
+func jobWorkflowID(jobID string) string {
+	return "job-" + jobID
+}
+
+// ------ Workflow ------
+
+// jobRun is the state a job handler runs with.
+type jobRun struct {
+	ctx    workflow.Context
+	params *JobWorkflowParams
+}
+
+// decode decodes the job's parameters into v.
+func (r *jobRun) decode(v any) error {
+	if err := json.Unmarshal(r.params.Params, v); err != nil {
+		return temporal.NewNonRetryableApplicationError(fmt.Sprintf("invalid %s job parameters: %v", r.params.Kind, err), "InvalidJobParams", err)
+	}
+	return nil
+}
+
+// checkpoint saves the job's progress, and cursor as where to resume it.
+func (r *jobRun) checkpoint(cursor string) error {
+	r.params.Cursor = cursor
+	return updateJob(r.ctx, UpdateJobActivityParams{JobID: r.params.JobID, Status: JobStatusRunning, Progress: &r.params.Progress, At: workflow.Now(r.ctx)})
+}
+
+// updateJob runs UpdateJobActivity.
+func updateJob(ctx workflow.Context, params UpdateJobActivityParams) error {
+	return workflow.ExecuteActivity(ctx, UpdateJobActivityName, params).Get(ctx, nil)
+}
+
+// JobWorkflow runs a job with the handler of its kind and records its outcome. A
+// canceled workflow cancels the job: the handler stops at its next step and the job is
+// saved as CANCELED with the progress it made.
+func JobWorkflow(ctx workflow.Context, params JobWorkflowParams) error {
+	logger := workflow.GetLogger(ctx)
+
+	if !params.Started {
+		if err := updateJob(ctx, UpdateJobActivityParams{JobID: params.JobID, Status: JobStatusRunning, At: workflow.Now(ctx)}); err != nil {
+			logger.Error("Failed to mark job running", "job_id", params.JobID, "error", err)
+			return err
+		}
+		params.Started = true
+	}
+
+	run := &jobRun{ctx: ctx, params: &params}
+	var result any
+	var err error
+	switch params.Kind {
+	case JobKindCloseBatch:
+		err = runCloseBatch(run)
+	case JobKindEncryptLineItems:
+		err = runEncryptLineItems(run)
+	case JobKindRebuildBillSummaries:
+		err = runRebuildBillSummaries(run)
+	default:
+		err = temporal.NewNonRetryableApplicationError(fmt.Sprintf("unknown job kind %q", params.Kind), "UnknownJobKind", nil)
+	}
+	if errors.Is(err, errJobContinue) {
+		return workflow.NewContinueAsNewError(ctx, JobWorkflow, params)
+	}
+
+	final := UpdateJobActivityParams{JobID: params.JobID, Progress: &params.Progress}
+	switch {
+	case err == nil:
+		final.Status = JobStatusSucceeded
+		if result != nil {
+			if final.Result, err = json.Marshal(result); err != nil {
+				final.Status, final.Error = JobStatusFailed, fmt.Sprintf("failed to encode result: %v", err)
+			}
+		}
+	case temporal.IsCanceledError(err) || errors.Is(ctx.Err(), workflow.ErrCanceled):
+		final.Status = JobStatusCanceled
+	default:
+		final.Status, final.Error = JobStatusFailed, err.Error()
+	}
+
+	// The outcome is saved even when the job was canceled.
+	saveCtx, _ := workflow.NewDisconnectedContext(ctx)
+	final.At = workflow.Now(saveCtx)
+	if saveErr := updateJob(saveCtx, final); saveErr != nil {
+		logger.Error("Failed to save job outcome", "job_id", params.JobID, "status", final.Status, "error", saveErr)
+		if err == nil {
+			return saveErr
+		}
+	}
+	logger.Info("Job finished", "job_id", params.JobID, "kind", params.Kind, "status", final.Status)
+	return err
+}
+
+// UpdateJobActivity saves a job's status, progress and outcome. A job that has already
+// stopped is left as it is.
+func (a *Activities) UpdateJobActivity(ctx context.Context, params UpdateJobActivityParams) error {
+	progress, err := jsonColumn(params.Progress)
+	if err != nil {
+		return fmt.Errorf("UpdateJobActivity: failed to encode progress of job %s: %w", params.JobID, err)
+	}
+	var result []byte
+	if len(params.Result) > 0 {
+		result = params.Result
+	}
+	_, err = a.DB.Exec(ctx, `
+        UPDATE jobs SET
+            status = $2,
+            progress = COALESCE($3::jsonb, progress),
+            result = COALESCE($4::jsonb, result),
+            error = COALESCE($5, error),
+            started_at = CASE WHEN $2 = 'RUNNING' THEN COALESCE(started_at, $6) ELSE started_at END,
+            completed_at = CASE WHEN $2 IN ('SUCCEEDED', 'FAILED', 'CANCELED') THEN $6 ELSE completed_at END
+        WHERE id = $1 AND status NOT IN ('SUCCEEDED', 'FAILED', 'CANCELED')
+    `, params.JobID, params.Status, progress, result, nullIfEmpty(params.Error), params.At)
+	if err != nil {
+		return fmt.Errorf("UpdateJobActivity: failed to update job %s to %s: %w", params.JobID, params.Status, err)
+	}
+	return nil
+}
+
+// ------ Service ------
+
+const jobColumns = `id, kind, tenant_id, status, params, progress, result, COALESCE(error, ''), COALESCE(created_by_key_id, ''), created_at, started_at, cancel_requested_at, completed_at`
+
+// scanJob reads a jobs row selected with jobColumns.
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	var job Job
+	var progress, result []byte
+	err := row.Scan(&job.ID, &job.Kind, &job.TenantID, &job.Status, &job.Params, &progress, &result, &job.Error,
+		&job.CreatedByKeyID, &job.CreatedAt, &job.StartedAt, &job.CancelRequestedAt, &job.CompletedAt)
+	if err != nil {
+		return nil, err
+	}
+	if progress != nil {
+		if err := json.Unmarshal(progress, &job.Progress); err != nil {
+			return nil, fmt.Errorf("failed to decode progress of job %s: %w", job.ID, err)
+		}
+	}
+	if result != nil {
+		job.Result = result
+	}
+	return &job, nil
+}
+
+// startJob saves a QUEUED job of kind with params and starts its JobWorkflow. A keyed
+// request that already started the job returns it as it is now.
+func (s *Service) startJob(ctx context.Context, kind JobKind, tenantID string, params any) (*Job, error) {
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to encode %s job parameters", kind)
+	}
+	jobID := keyedID(ctx, "job", string(kind), tenantID)
+	keyID := callerKeyID(ctx)
+	_, err = s.db.Exec(ctx, `
+        INSERT INTO jobs (id, tenant_id, kind, status, params, created_by_key_id, created_at)
+        VALUES ($1, $2, $3, 'QUEUED', $4, $5, $6)
+        ON CONFLICT (id) DO NOTHING
+    `, jobID, tenantID, kind, encoded, nullIfEmpty(keyID), s.clock.Now().UTC())
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to save %s job", kind)
+	}
+
+	options := client.StartWorkflowOptions{
+		ID:        jobWorkflowID(jobID),
+		TaskQueue: s.cfg.TaskQueues.forTenant(tenantID),
+	}
+	if idempotencyKey(ctx) != "" {
+		options.WorkflowIDReusePolicy = enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE
+		options.WorkflowExecutionErrorWhenAlreadyStarted = true
+	}
+	workflowParams := JobWorkflowParams{JobID: jobID, Kind: kind, TenantID: tenantID, RequestedByKeyID: keyID, Params: encoded}
+	_, err = s.temporalClient.ExecuteWorkflow(ctx, options, JobWorkflow, workflowParams)
+	var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+	if err != nil && !errors.As(err, &alreadyStarted) {
+		_, failErr := s.db.Exec(ctx, `
+            UPDATE jobs SET status = 'FAILED', error = $2, completed_at = $3 WHERE id = $1 AND status = 'QUEUED'
+        `, jobID, "failed to start: "+err.Error(), s.clock.Now().UTC())
+		if failErr != nil {
+			loggerFrom(ctx).Error("Failed to mark unstarted job failed", "job_id", jobID, "error", failErr)
+		}
+		return nil, apierr.FromTemporal(err, apierr.Internal, "failed to start %s job", kind)
+	}
+	return s.loadJob(ctx, jobID)
+}
+
+// loadJob reads a job visible to the caller.
+func (s *Service) loadJob(ctx context.Context, jobID string) (*Job, error) {
+	job, err := scanJob(s.db.QueryRow(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, jobID))
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return nil, apierr.NotFound(apierr.JobNotFound, "job %s not found", jobID)
+	}
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load job %s", jobID)
+	}
+	// A job across every tenant is only visible to internal callers.
+	if (job.TenantID == "" && callerTenant(ctx) != "") || !visibleToCaller(ctx, job.TenantID) {
+		return nil, apierr.NotFound(apierr.JobNotFound, "job %s not found", jobID)
+	}
+	return job, nil
+}
+
+// ------ API ------
+
+// JobResponse is the response payload for a job.
+type JobResponse struct {
+	Job Job `json:"job"`
+}
+
+// GetJob retrieves a job with its status, progress and, once it has succeeded, its
+// result.
+//
+// encore:api auth method=GET path=/jobs/:jobID
+func (s *Service) GetJob(ctx context.Context, jobID string) (*JobResponse, error) {
+	job, err := s.loadJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	return &JobResponse{Job: *job}, nil
+}
+
+// CancelJob asks a job to stop. The job stops at its next step and is CANCELED with the
+// progress it made; work it already did is not undone. Canceling a job that was already
+// canceled succeeds without doing anything.
+//
+// encore:api auth method=POST path=/jobs/:jobID/cancel
+func (s *Service) CancelJob(ctx context.Context, jobID string) (*JobResponse, error) {
+	job, err := s.loadJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case job.Status == JobStatusCanceled:
+		return &JobResponse{Job: *job}, nil
+	case job.Status.IsFinal():
+		return nil, apierr.FailedPrecondition(apierr.JobFinished, "job %s is %s and can no longer be canceled", jobID, job.Status)
+	}
+
+	if err := s.temporalClient.CancelWorkflow(ctx, jobWorkflowID(jobID), ""); err != nil {
+		return nil, apierr.FromTemporal(err, apierr.JobNotFound, "job %s not found", jobID)
+	}
+	_, err = s.db.Exec(ctx, `
+        UPDATE jobs SET cancel_requested_at = COALESCE(cancel_requested_at, $2) WHERE id = $1
+    `, jobID, s.clock.Now().UTC())
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to record cancellation of job %s", jobID)
+	}
+	return s.GetJob(ctx, jobID)
+}
@@ -0,0 +1,29 @@
+package fees
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestJobProgress_Fail tests that every failure is counted but only the first
+// maxJobFailedIDs are listed.
+func TestJobProgress_Fail(t *testing.T) {
+	var p JobProgress
+	for i := 0; i < maxJobFailedIDs+5; i++ {
+		p.fail(fmt.Sprintf("bill-%d", i))
+	}
+	require.Equal(t, maxJobFailedIDs+5, p.Failed)
+	require.Len(t, p.FailedIDs, maxJobFailedIDs)
+	require.Equal(t, "bill-0", p.FailedIDs[0])
+}
+
+// TestJobStatus_IsFinal tests which statuses mean a job has stopped.
+func TestJobStatus_IsFinal(t *testing.T) {
+	require.False(t, JobStatusQueued.IsFinal())
+	require.False(t, JobStatusRunning.IsFinal())
+	require.True(t, JobStatusSucceeded.IsFinal())
+	require.True(t, JobStatusFailed.IsFinal())
+	require.True(t, JobStatusCanceled.IsFinal())
+}
@@ -0,0 +1,172 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.dev/storage/sqldb"
+	"github.com/google/uuid"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+)
+
+// LateItemPolicy decides what happens to a line item that arrives after its bill closed.
+type LateItemPolicy string
+
+const (
+	// LateItemPolicyReject refuses the item with a bill_closed error.
+	LateItemPolicyReject LateItemPolicy = "reject"
+	// LateItemPolicyPark puts the item on the closed bill's follow-up bill, which holds
+	// its trailing charges and is opened automatically for the first of them.
+	LateItemPolicyPark LateItemPolicy = "park"
+	// LateItemPolicyNextBill appends the item to the customer's next open bill in the
+	// same currency, falling back to the follow-up bill when there is none.
+	LateItemPolicyNextBill LateItemPolicy = "next_bill"
+)
+
+// IsValid reports whether p is a known late item policy.
+func (p LateItemPolicy) IsValid() bool {
+	switch p {
+	case LateItemPolicyReject, LateItemPolicyPark, LateItemPolicyNextBill:
+		return true
+	}
+	return false
+}
+
+// maxNextBillHops bounds how far down a chain of already-closed follow-up bills
+// a late item is forwarded before giving up.
+const maxNextBillHops = 10
+
+// RoutedFrom tags a line item that arrived after its original bill closed and was
+// forwarded to a later bill.
+type RoutedFrom struct {
+	BillID      string     `json:"billId"`
+	PeriodStart *time.Time `json:"periodStart,omitempty"`
+	PeriodEnd   *time.Time `json:"periodEnd,omitempty"`
+}
+
+// LateItemRouter forwards line items that arrive after their bill closed to a later
+// bill, starting the follow-up bill via signal-with-start when needed.
+type LateItemRouter struct {
+	DB       *tracedDB
+	Temporal client.Client
+	Config   *Config
+}
+
+// Route delivers signal to the bill chosen by policy and returns that bill's ID.
+func (r *LateItemRouter) Route(ctx context.Context, closed *Bill, signal AddLineItemSignal, policy LateItemPolicy) (string, error) {
+	if policy == LateItemPolicyReject {
+		return "", fmt.Errorf("late line items are rejected for bill %s", closed.ID)
+	}
+	// The caller's If-Match applied to the closed bill, not to the bill the item lands on.
+	signal.IfVersion = 0
+	if signal.RoutedFrom == nil {
+		signal.RoutedFrom = &RoutedFrom{
+			BillID:      closed.ID,
+			PeriodStart: closed.CreatedAt,
+			PeriodEnd:   closed.ClosedAt,
+		}
+	}
+
+	if policy != LateItemPolicyPark {
+		openBillID, err := r.openBill(ctx, closed)
+		if err != nil {
+			return "", err
+		}
+		if openBillID != "" {
+			signalErr := r.Temporal.SignalWorkflow(ctx, billWorkflowID(openBillID), "", AddLineItemSignalName, signal)
+			if signalErr == nil {
+				return openBillID, nil
+			}
+			// The bill closed since it was looked up; fall back to the follow-up bill.
+		}
+	}
+
+	return r.parkOnFollowUp(ctx, closed, signal)
+}
+
+// openBill returns another bill the customer has open in the closed bill's currency,
+// or "" if there is none. Late items of test bills only go to test bills, and those of
+// live bills only to live ones.
+func (r *LateItemRouter) openBill(ctx context.Context, closed *Bill) (string, error) {
+	var openBillID string
+	err := r.DB.QueryRow(ctx, `
+        SELECT id FROM bills
+        WHERE tenant_id = $5 AND customer_id = $1 AND currency = $2 AND status = $3 AND id <> $4 AND test = $6
+        ORDER BY created_at
+        LIMIT 1
+    `, closed.CustomerID, closed.Currency, BillStatusOpen, closed.ID, tenantOrDefault(closed.TenantID), isTestBill(closed.ID)).Scan(&openBillID)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up open bill for customer %s: %w", closed.CustomerID, err)
+	}
+	return openBillID, nil
+}
+
+// parkOnFollowUp signal-with-starts the closed bill's deterministic follow-up bill,
+// walking past follow-ups that have themselves been closed already.
+func (r *LateItemRouter) parkOnFollowUp(ctx context.Context, closed *Bill, signal AddLineItemSignal) (string, error) {
+	// Late items are held to the customer's limits and alerts like any other bill.
+	base, err := newBillParams(ctx, r.DB, r.Config, closed.TenantID, closed.CustomerID, closed.Currency)
+	if err != nil {
+		return "", fmt.Errorf("failed to load billing settings for follow-up of bill %s: %w", closed.ID, err)
+	}
+	base.AutoCollect = closed.AutoCollect
+	prevID, nextID := "", closed.ID
+	for hop := 0; hop < maxNextBillHops; hop++ {
+		prevID, nextID = nextID, nextBillID(nextID)
+		wfID := billWorkflowID(nextID)
+		options := client.StartWorkflowOptions{
+			ID:                    wfID,
+			TaskQueue:             r.Config.TaskQueues.forTenant(closed.TenantID),
+			WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
+		}
+		params := base
+		params.BillID = nextID
+		params.FollowUpOf = prevID
+
+		_, err := r.Temporal.SignalWithStartWorkflow(ctx, wfID, AddLineItemSignalName, signal, options, BillWorkflow, &params)
+		if err == nil {
+			return nextID, nil
+		}
+		var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+		if !errors.As(err, &alreadyStarted) {
+			return "", fmt.Errorf("failed to signal-with-start follow-up bill %s: %w", nextID, err)
+		}
+	}
+	return "", fmt.Errorf("no open follow-up bill for bill %s within %d hops", closed.ID, maxNextBillHops)
+}
+
+// nextBillID derives the deterministic ID of the bill that receives items arriving
+// after billID closed, so concurrent late items converge on the same follow-up bill.
+// A test bill's follow-up is a test bill too.
+func nextBillID(billID string) string {
+	id := uuid.NewSHA1(uuid.NameSpaceOID, []byte("feems/next-bill/"+billID)).String()
+	if isTestBill(billID) {
+		return testBillIDPrefix + id
+	}
+	return id
+}
+
+// RouteLateLineItemActivityParams defines parameters for RouteLateLineItemActivity.
+type RouteLateLineItemActivityParams struct {
+	Bill   Bill
+	Signal AddLineItemSignal
+	// Policy is empty for activities scheduled before policies existed, which routed
+	// like LateItemPolicyNextBill.
+	Policy LateItemPolicy
+}
+
+// RouteLateLineItemActivity forwards a line item signaled to a closed bill to the customer's next bill.
+func (a *Activities) RouteLateLineItemActivity(ctx context.Context, params RouteLateLineItemActivityParams) (string, error) {
+	nextID, err := a.Router.Route(ctx, &params.Bill, params.Signal, params.Policy)
+	if err != nil {
+		return "", fmt.Errorf("RouteLateLineItemActivity: failed to route line item %s from bill %s: %w", params.Signal.LineItemID, params.Bill.ID, err)
+	}
+	return nextID, nil
+}
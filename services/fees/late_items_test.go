@@ -0,0 +1,45 @@
+package fees
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/mocks"
+)
+
+// TestLateItemRouter_Park tests that parked items go to the closed bill's follow-up bill
+// without looking for another open bill.
+func TestLateItemRouter_Park(t *testing.T) {
+	tc := mocks.NewClient(t)
+	router := &LateItemRouter{Temporal: tc, Config: &Config{LateItemPolicy: LateItemPolicyPark, CloseGracePeriod: time.Hour}}
+
+	closedAt := time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)
+	closed := &Bill{ID: "bill-1", CustomerID: "cust-1", Currency: "USD", Status: BillStatusClosed, ClosedAt: &closedAt}
+	followUpID := nextBillID(closed.ID)
+	tc.On("SignalWithStartWorkflow", mock.Anything, "bill-"+followUpID, AddLineItemSignalName,
+		mock.MatchedBy(func(s AddLineItemSignal) bool { return s.RoutedFrom.BillID == closed.ID }),
+		mock.Anything, mock.Anything,
+		mock.MatchedBy(func(p *BillWorkflowParams) bool {
+			return p.BillID == followUpID && p.FollowUpOf == closed.ID && p.LateItemPolicy == LateItemPolicyPark &&
+				p.CloseGracePeriod == time.Hour && p.ApplyCredits && p.EmailOnClose
+		}),
+	).Return(mocks.NewWorkflowRun(t), nil).Once()
+
+	nextID, err := router.Route(context.Background(), closed, AddLineItemSignal{LineItemID: "li-1", Amount: 5}, LateItemPolicyPark)
+	require.NoError(t, err)
+	require.Equal(t, followUpID, nextID)
+
+	_, err = router.Route(context.Background(), closed, AddLineItemSignal{LineItemID: "li-2", Amount: 5}, LateItemPolicyReject)
+	require.Error(t, err)
+}
+
+// TestBillWorkflowParams_LateItemPolicy tests that runs started before late item
+// policies existed keep their routing behavior.
+func TestBillWorkflowParams_LateItemPolicy(t *testing.T) {
+	require.Equal(t, LateItemPolicyReject, (&BillWorkflowParams{}).lateItemPolicy())
+	require.Equal(t, LateItemPolicyNextBill, (&BillWorkflowParams{RouteLateItems: true}).lateItemPolicy())
+	require.Equal(t, LateItemPolicyPark, (&BillWorkflowParams{RouteLateItems: true, LateItemPolicy: LateItemPolicyPark}).lateItemPolicy())
+}
@@ -0,0 +1,297 @@
+package fees
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"encore.app/apierr"
+	"github.com/google/uuid"
+)
+
+// LedgerAccount is an account of the fees ledger's chart of accounts.
+type LedgerAccount string
+
+const (
+	// AccountReceivable holds what customers owe on closed bills.
+	AccountReceivable LedgerAccount = "accounts_receivable"
+	// AccountRevenue holds what closed bills charged, before customer credit.
+	AccountRevenue LedgerAccount = "revenue"
+	// AccountTaxPayable holds tax collected on behalf of tax authorities. Bills carry
+	// no tax yet, so nothing posts to it.
+	AccountTaxPayable LedgerAccount = "tax_payable"
+	// AccountCash holds payments received for bills.
+	AccountCash LedgerAccount = "cash"
+	// AccountCreditsApplied holds customer credit deducted from closed bills. It offsets
+	// revenue, which is recorded before credit.
+	AccountCreditsApplied LedgerAccount = "credits_applied"
+)
+
+// ledgerAccounts maps each account to its normal side: the side that increases its
+// balance.
+var ledgerAccounts = map[LedgerAccount]LedgerSide{
+	AccountReceivable:     LedgerDebit,
+	AccountRevenue:        LedgerCredit,
+	AccountTaxPayable:     LedgerCredit,
+	AccountCash:           LedgerDebit,
+	AccountCreditsApplied: LedgerDebit,
+}
+
+// LedgerSide is the side of an account a posting is made to.
+type LedgerSide string
+
+const (
+	LedgerDebit  LedgerSide = "debit"
+	LedgerCredit LedgerSide = "credit"
+)
+
+// JournalKind is the bill lifecycle event a journal entry records.
+type JournalKind string
+
+const (
+	// JournalBillClosed recognizes a closed bill's revenue as receivable.
+	JournalBillClosed JournalKind = "bill.closed"
+	// JournalPaymentReceived settles receivables with a successful payment.
+	JournalPaymentReceived JournalKind = "payment.received"
+	// JournalPaymentReversed restores receivables for a payment the provider reported
+	// as failed after it succeeded.
+	JournalPaymentReversed JournalKind = "payment.reversed"
+)
+
+// LedgerPosting is one side of a journal entry.
+type LedgerPosting struct {
+	Account LedgerAccount `json:"account"`
+	Side    LedgerSide    `json:"side"`
+	Amount  float64       `json:"amount"`
+}
+
+// journalEntry is a set of postings recording one event of a bill. Its debits and
+// credits balance.
+type journalEntry struct {
+	ID       string
+	BillID   string
+	Kind     JournalKind
+	PostedAt time.Time
+	Postings []LedgerPosting
+}
+
+// post adds a posting of amount to side of account. A negative amount posts to the
+// other side, and a zero amount posts nothing.
+func (j *journalEntry) post(account LedgerAccount, side LedgerSide, amount float64) {
+	if amount < 0 {
+		side, amount = side.opposite(), -amount
+	}
+	if amount = roundAmount(amount); amount == 0 {
+		return
+	}
+	j.Postings = append(j.Postings, LedgerPosting{Account: account, Side: side, Amount: amount})
+}
+
+// balanced reports whether the entry's debits equal its credits.
+func (j *journalEntry) balanced() bool {
+	var sum float64
+	for _, p := range j.Postings {
+		if p.Side == LedgerDebit {
+			sum += p.Amount
+		} else {
+			sum -= p.Amount
+		}
+	}
+	return math.Abs(sum) < 1e-9
+}
+
+// opposite returns the other side.
+func (s LedgerSide) opposite() LedgerSide {
+	if s == LedgerDebit {
+		return LedgerCredit
+	}
+	return LedgerDebit
+}
+
+// journalID identifies the journal entry of kind for subject, a bill or payment ID, so a
+// retried activity posts it once.
+func journalID(kind JournalKind, subject string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte("feems/ledger/"+string(kind)+"/"+subject)).String()
+}
+
+// closeJournal records a bill closing at total, after credit was applied: the revenue
+// charged is receivable, less the credit applied.
+func closeJournal(billID string, total float64, credit *AppliedCredit, closedAt time.Time) journalEntry {
+	j := journalEntry{ID: journalID(JournalBillClosed, billID), BillID: billID, Kind: JournalBillClosed, PostedAt: closedAt}
+	revenue := total
+	if credit != nil {
+		revenue += credit.Amount
+		j.post(AccountCreditsApplied, LedgerDebit, credit.Amount)
+	}
+	j.post(AccountReceivable, LedgerDebit, total)
+	j.post(AccountRevenue, LedgerCredit, revenue)
+	return j
+}
+
+// paymentJournal records a successful payment of a bill settling its receivable.
+func paymentJournal(billID, paymentID string, amount float64, paidAt time.Time) journalEntry {
+	j := journalEntry{ID: journalID(JournalPaymentReceived, paymentID), BillID: billID, Kind: JournalPaymentReceived, PostedAt: paidAt}
+	j.post(AccountCash, LedgerDebit, amount)
+	j.post(AccountReceivable, LedgerCredit, amount)
+	return j
+}
+
+// paymentReversalJournal undoes the paymentJournal of a payment that was reversed.
+func paymentReversalJournal(billID, paymentID string, amount float64, reversedAt time.Time) journalEntry {
+	j := journalEntry{ID: journalID(JournalPaymentReversed, paymentID), BillID: billID, Kind: JournalPaymentReversed, PostedAt: reversedAt}
+	j.post(AccountReceivable, LedgerDebit, amount)
+	j.post(AccountCash, LedgerCredit, amount)
+	return j
+}
+
+// postJournal writes j to the ledger in tx, taking the tenant, customer and currency
+// from its bill. An entry that was already posted is left as it is.
+func postJournal(ctx context.Context, tx *tracedTx, j journalEntry) error {
+	if !j.balanced() {
+		return fmt.Errorf("journal entry %s of bill %s does not balance: %+v", j.ID, j.BillID, j.Postings)
+	}
+	if len(j.Postings) == 0 {
+		return nil
+	}
+	result, err := tx.Exec(ctx, `
+        INSERT INTO ledger_journal_entries (id, tenant_id, bill_id, customer_id, currency, kind, posted_at)
+        SELECT $1, tenant_id, id, customer_id, currency, $3, $4 FROM bills WHERE id = $2
+        ON CONFLICT (id) DO NOTHING
+    `, j.ID, j.BillID, j.Kind, j.PostedAt)
+	if err != nil {
+		return fmt.Errorf("failed to post %s journal entry of bill %s: %w", j.Kind, j.BillID, err)
+	}
+	if result.RowsAffected() == 0 {
+		return nil
+	}
+	for _, p := range j.Postings {
+		_, err := tx.Exec(ctx, `
+            INSERT INTO ledger_postings (journal_id, account, side, amount)
+            VALUES ($1, $2, $3, $4)
+        `, j.ID, p.Account, p.Side, p.Amount)
+		if err != nil {
+			return fmt.Errorf("failed to post %s to %s for bill %s: %w", p.Side, p.Account, j.BillID, err)
+		}
+	}
+	return nil
+}
+
+// ------ API ------
+
+// maxLedgerEntries caps the entries returned for an account.
+const maxLedgerEntries = 100
+
+// LedgerEntriesParams filters the entries of a ledger account.
+type LedgerEntriesParams struct {
+	// Currency limits the balances and entries to one currency.
+	Currency string `query:"currency"`
+	// CustomerID limits the balances and entries to one customer's bills.
+	CustomerID string `query:"customerId"`
+	// IncludeTest also includes the postings of test bills. Test mode API keys only
+	// ever see test bills' postings.
+	IncludeTest bool `query:"includeTest"`
+}
+
+// LedgerBalance is an account's balance in one currency. Balance is positive when the
+// account's normal side exceeds the other.
+type LedgerBalance struct {
+	Currency string  `json:"currency"`
+	Debits   float64 `json:"debits"`
+	Credits  float64 `json:"credits"`
+	Balance  float64 `json:"balance"`
+}
+
+// LedgerEntry is a posting to an account with the journal entry it belongs to.
+type LedgerEntry struct {
+	JournalID  string      `json:"journalId"`
+	Kind       JournalKind `json:"kind"`
+	BillID     string      `json:"billId"`
+	CustomerID string      `json:"customerId,omitempty"`
+	TenantID   string      `json:"tenantId"`
+	Currency   string      `json:"currency"`
+	Side       LedgerSide  `json:"side"`
+	Amount     float64     `json:"amount"`
+	PostedAt   time.Time   `json:"postedAt"`
+}
+
+// LedgerEntriesResponse is the response payload for a ledger account's entries.
+type LedgerEntriesResponse struct {
+	Account    LedgerAccount   `json:"account"`
+	NormalSide LedgerSide      `json:"normalSide"`
+	Balances   []LedgerBalance `json:"balances"`
+	// Entries holds the account's most recent postings, newest first.
+	Entries []LedgerEntry `json:"entries"`
+}
+
+// GetLedgerEntries returns the balances and most recent postings of a ledger account.
+// Tenant-scoped callers only see their own tenant's postings, and test bills' postings
+// are left out unless params.IncludeTest.
+//
+// encore:api auth method=GET path=/ledger/accounts/:id/entries
+func (s *Service) GetLedgerEntries(ctx context.Context, id string, params *LedgerEntriesParams) (*LedgerEntriesResponse, error) {
+	account := LedgerAccount(id)
+	normal, ok := ledgerAccounts[account]
+	if !ok {
+		return nil, apierr.NotFound(apierr.LedgerAccountNotFound, "ledger account %q not found", id)
+	}
+	if params.Currency != "" && !validCurrency(params.Currency) {
+		return nil, apierr.InvalidArgument(apierr.InvalidCurrency, "invalid currency %q: must be a three-letter ISO 4217 code such as \"USD\"", params.Currency)
+	}
+	tenant := callerTenant(ctx)
+	modes := callerBillModes(ctx, params.IncludeTest)
+	resp := &LedgerEntriesResponse{Account: account, NormalSide: normal, Balances: []LedgerBalance{}, Entries: []LedgerEntry{}}
+
+	rows, err := s.db.Query(ctx, `
+        SELECT j.currency,
+               COALESCE(SUM(p.amount) FILTER (WHERE p.side = 'debit'), 0)::float8,
+               COALESCE(SUM(p.amount) FILTER (WHERE p.side = 'credit'), 0)::float8
+        FROM ledger_postings p JOIN ledger_journal_entries j ON j.id = p.journal_id
+        WHERE p.account = $1 AND ($2 = '' OR j.currency = $2) AND ($3 = '' OR j.tenant_id = $3) AND ($4 = '' OR j.customer_id = $4)
+          AND (($5 AND NOT j.test) OR ($6 AND j.test))
+        GROUP BY j.currency
+        ORDER BY j.currency
+    `, account, params.Currency, tenant, params.CustomerID, modes.Live, modes.Test)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load balances of ledger account %s", account)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b LedgerBalance
+		if err := rows.Scan(&b.Currency, &b.Debits, &b.Credits); err != nil {
+			return nil, apierr.Wrap(err, "failed to read balances of ledger account %s", account)
+		}
+		b.Balance = roundAmount(b.Debits - b.Credits)
+		if normal == LedgerCredit {
+			b.Balance = -b.Balance
+		}
+		resp.Balances = append(resp.Balances, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read balances of ledger account %s", account)
+	}
+
+	rows, err = s.db.Query(ctx, `
+        SELECT j.id, j.kind, j.bill_id, COALESCE(j.customer_id, ''), j.tenant_id, j.currency, p.side, p.amount::float8, j.posted_at
+        FROM ledger_postings p JOIN ledger_journal_entries j ON j.id = p.journal_id
+        WHERE p.account = $1 AND ($2 = '' OR j.currency = $2) AND ($3 = '' OR j.tenant_id = $3) AND ($4 = '' OR j.customer_id = $4)
+          AND (($5 AND NOT j.test) OR ($6 AND j.test))
+        ORDER BY j.posted_at DESC, j.id
+        LIMIT $7
+    `, account, params.Currency, tenant, params.CustomerID, modes.Live, modes.Test, maxLedgerEntries)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load entries of ledger account %s", account)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e LedgerEntry
+		if err := rows.Scan(&e.JournalID, &e.Kind, &e.BillID, &e.CustomerID, &e.TenantID, &e.Currency, &e.Side, &e.Amount, &e.PostedAt); err != nil {
+			return nil, apierr.Wrap(err, "failed to read entries of ledger account %s", account)
+		}
+		resp.Entries = append(resp.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read entries of ledger account %s", account)
+	}
+	return resp, nil
+}
@@ -0,0 +1,50 @@
+package fees
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseJournal(t *testing.T) {
+	closedAt := time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)
+
+	j := closeJournal("b1", 80, &AppliedCredit{Amount: 20}, closedAt)
+	require.True(t, j.balanced())
+	require.Equal(t, JournalBillClosed, j.Kind)
+	require.Equal(t, []LedgerPosting{
+		{Account: AccountCreditsApplied, Side: LedgerDebit, Amount: 20},
+		{Account: AccountReceivable, Side: LedgerDebit, Amount: 80},
+		{Account: AccountRevenue, Side: LedgerCredit, Amount: 100},
+	}, j.Postings)
+	require.Equal(t, j.ID, closeJournal("b1", 80, nil, closedAt).ID, "a retried close posts the same entry")
+
+	// A bill closing below zero owes the customer, reversing both sides.
+	j = closeJournal("b2", -5, nil, closedAt)
+	require.True(t, j.balanced())
+	require.Equal(t, []LedgerPosting{
+		{Account: AccountReceivable, Side: LedgerCredit, Amount: 5},
+		{Account: AccountRevenue, Side: LedgerDebit, Amount: 5},
+	}, j.Postings)
+
+	require.Empty(t, closeJournal("b3", 0, nil, closedAt).Postings)
+}
+
+func TestPaymentJournal(t *testing.T) {
+	j := paymentJournal("b1", "p1", 42.5, time.Now())
+	require.True(t, j.balanced())
+	require.Equal(t, JournalPaymentReceived, j.Kind)
+	require.Equal(t, []LedgerPosting{
+		{Account: AccountCash, Side: LedgerDebit, Amount: 42.5},
+		{Account: AccountReceivable, Side: LedgerCredit, Amount: 42.5},
+	}, j.Postings)
+	require.NotEqual(t, j.ID, paymentJournal("b1", "p2", 42.5, time.Now()).ID)
+}
+
+func TestJournalEntry_Unbalanced(t *testing.T) {
+	j := journalEntry{ID: "j1", BillID: "b1"}
+	j.post(AccountCash, LedgerDebit, 10)
+	j.post(AccountReceivable, LedgerCredit, 9.99)
+	require.False(t, j.balanced())
+}
@@ -0,0 +1,377 @@
+package fees
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/storage/sqldb"
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// SaveAggregatedLineItemActivityName saves a line item event and the aggregated
+	// line item it adds to.
+	SaveAggregatedLineItemActivityName = "SaveAggregatedLineItemActivity"
+
+	// defaultAggregationInterval is the interval of a bill's aggregation that sets none.
+	defaultAggregationInterval = time.Hour
+	// minAggregationInterval and maxAggregationInterval bound an aggregation's interval.
+	minAggregationInterval = time.Minute
+	maxAggregationInterval = 24 * time.Hour
+	// maxLineItemCategoryLength caps a line item's aggregation category.
+	maxLineItemCategoryLength = 100
+
+	defaultLineItemEventsLimit = 100
+	maxLineItemEventsLimit     = 1000
+)
+
+// errDuplicateLineItemEvent rolls back the save of a line item event the bill already
+// holds, by ID or client reference.
+var errDuplicateLineItemEvent = errors.New("line item event already recorded")
+
+// LineItemAggregation consolidates a bill's small line items, such as thousands of
+// sub-cent charges an hour, into one line item per category and interval. Each item
+// is kept as a line item event for audit.
+type LineItemAggregation struct {
+	// Interval (e.g. "1h") is the period each aggregated item covers. It must divide
+	// a day, from 1m to 24h. Defaults to 1h.
+	Interval string `json:"interval,omitempty"`
+	// MaxAmount aggregates only items whose amount is at most this, in either
+	// direction; larger items are added as they are. Zero aggregates every item.
+	MaxAmount float64 `json:"maxAmount,omitempty"`
+}
+
+// LineItemAggregate describes a line item consolidating the small items of one
+// category and interval.
+type LineItemAggregate struct {
+	Category    string    `json:"category"`
+	PeriodStart time.Time `json:"periodStart"`
+	PeriodEnd   time.Time `json:"periodEnd"`
+	// Count is how many items the line item consolidates.
+	Count int `json:"count"`
+	// RawAmount is the exact sum of the items; the line item's amount is it rounded
+	// by the bill's rounding policy.
+	RawAmount float64 `json:"rawAmount"`
+}
+
+// LineItemEvent is an item a bill aggregated, as it was sent.
+type LineItemEvent struct {
+	ID string `json:"id"`
+	// LineItemID is the aggregated line item the event was added to.
+	LineItemID      string    `json:"lineItemId"`
+	Category        string    `json:"category"`
+	Description     string    `json:"description"`
+	Amount          float64   `json:"amount"`
+	ClientReference string    `json:"clientReference,omitempty"`
+	CreatedByKeyID  string    `json:"createdByKeyId,omitempty"`
+	ReceivedAt      time.Time `json:"receivedAt"`
+}
+
+// normalize validates the aggregation and fills in its default interval.
+func (a *LineItemAggregation) normalize() error {
+	if a.Interval == "" {
+		a.Interval = defaultAggregationInterval.String()
+	}
+	d, err := time.ParseDuration(a.Interval)
+	if err != nil || d < minAggregationInterval || d > maxAggregationInterval || maxAggregationInterval%d != 0 {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "invalid aggregation interval %q: must divide a day, from 1m to 24h, such as \"1h\"", a.Interval)
+	}
+	if a.MaxAmount < 0 || math.IsNaN(a.MaxAmount) || math.IsInf(a.MaxAmount, 0) {
+		return apierr.InvalidArgument(apierr.InvalidAmount, "aggregation maxAmount must not be negative, got %v", a.MaxAmount)
+	}
+	return nil
+}
+
+// interval returns the period each aggregated item covers.
+func (a *LineItemAggregation) interval() time.Duration {
+	d, err := time.ParseDuration(a.Interval)
+	if err != nil || d <= 0 {
+		return defaultAggregationInterval
+	}
+	return d
+}
+
+// aggregates reports whether a bill under a aggregates the item signal carries. Items
+// forwarded from other bills, sub-bill totals, and credits and adjustments are always
+// added as they are.
+func (a *LineItemAggregation) aggregates(signal AddLineItemSignal) bool {
+	if a == nil || signal.RoutedFrom != nil || signal.SubBillID != "" || signal.Metric != "" || (signal.Type != "" && signal.Type != LineItemCharge) {
+		return false
+	}
+	amount := signal.Amount
+	if signal.Quantity != 0 {
+		amount = lineItemAmount(signal.Quantity, signal.UnitPrice)
+	}
+	return a.MaxAmount == 0 || math.Abs(amount) <= a.MaxAmount
+}
+
+// aggregateLineItemID derives the ID of a bill's aggregated line item for category and
+// the interval starting at periodStart.
+func aggregateLineItemID(billID, category string, periodStart time.Time) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte("feems/aggregate/"+billID+"/"+category+"/"+periodStart.UTC().Format(time.RFC3339))).String()
+}
+
+// ------ Workflow ------
+
+// aggregateLineItem adds an item to the aggregated line item of its category and the
+// current interval, creating it for the interval's first item. The item itself is
+// saved as a line item event along with the aggregated item.
+func (w *billWorkflow) aggregateLineItem(signal AddLineItemSignal, eventID string, amount float64) {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	receivedAt := workflow.Now(ctx)
+	category := signal.Category
+	if category == "" {
+		category = signal.Description
+	}
+	interval := bill.Aggregation.interval()
+	periodStart := receivedAt.UTC().Truncate(interval)
+	itemID := aggregateLineItemID(bill.ID, category, periodStart)
+
+	var previous *LineItem
+	next := LineItem{
+		ID:          itemID,
+		Description: category,
+		Late:        bill.Status == BillStatusClosing,
+		Aggregate:   &LineItemAggregate{Category: category, PeriodStart: periodStart, PeriodEnd: periodStart.Add(interval)},
+	}
+	if existing := bill.lineItem(itemID, ""); existing != nil && existing.Aggregate != nil {
+		saved := *existing
+		previous = &saved
+		next = *existing
+		aggregate := *existing.Aggregate
+		next.Aggregate = &aggregate
+	}
+	next.Aggregate.Count++
+	next.Aggregate.RawAmount += amount
+	next.Amount = bill.round(next.Aggregate.RawAmount)
+
+	event := LineItemEvent{
+		ID:              eventID,
+		LineItemID:      itemID,
+		Category:        category,
+		Description:     signal.Description,
+		Amount:          amount,
+		ClientReference: signal.ClientReference,
+		CreatedByKeyID:  signal.CreatedByKeyID,
+		ReceivedAt:      receivedAt,
+	}
+	growth := next.Amount
+	if previous != nil {
+		growth -= previous.Amount
+	}
+	if bill.HardCap.rejects(bill.lineItemTotal(), growth) {
+		w.rejectLineItem(LineItem{ID: eventID, Description: signal.Description, Amount: amount, CreatedByKeyID: signal.CreatedByKeyID, ClientReference: signal.ClientReference})
+		return
+	}
+	next.OverHardCap = next.OverHardCap || bill.HardCap.exceededBy(bill.lineItemTotal(), growth)
+
+	w.setAggregatedItem(itemID, &next)
+	w.touch()
+
+	var applied bool
+	err := workflow.ExecuteActivity(ctx, SaveAggregatedLineItemActivityName, SaveAggregatedLineItemActivityParams{
+		BillID:      bill.ID,
+		Event:       event,
+		LineItem:    next,
+		BillVersion: bill.Version,
+	}).Get(ctx, &applied)
+	if err != nil {
+		logger.Error("Failed to execute SaveAggregatedLineItemActivity", "bill_id", bill.ID, "line_item_id", itemID, "event_id", eventID, "amount", amount, "error", err)
+		w.setAggregatedItem(itemID, previous)
+		bill.DroppedLineItems = append(bill.DroppedLineItems, DroppedLineItem{
+			LineItem:  LineItem{ID: eventID, Description: signal.Description, Amount: amount, CreatedByKeyID: signal.CreatedByKeyID, ClientReference: signal.ClientReference},
+			Reason:    err.Error(),
+			DroppedAt: workflow.Now(ctx),
+		})
+		w.touch()
+		notify(ctx, Notification{
+			Event:      NotificationLineItemDropped,
+			BillID:     bill.ID,
+			CustomerID: bill.CustomerID,
+			Message:    fmt.Sprintf("Line item %s (%s, %v %s) could not be saved and was removed from the bill; send it again.", eventID, signal.Description, amount, bill.Currency),
+		})
+		return
+	}
+	if !applied {
+		logger.Info("Duplicate aggregated line item received, ignoring.", "bill_id", bill.ID, "event_id", eventID, "client_reference", signal.ClientReference)
+		w.setAggregatedItem(itemID, previous)
+		w.touch()
+		return
+	}
+	logger.Info("Line item aggregated", "bill_id", bill.ID, "line_item_id", itemID, "event_id", eventID, "amount", amount, "count", next.Aggregate.Count)
+	w.checkSpendingAlerts()
+}
+
+// setAggregatedItem replaces the bill's line item itemID with item, adding it if the
+// bill does not hold it yet and removing it when item is nil.
+func (w *billWorkflow) setAggregatedItem(itemID string, item *LineItem) {
+	bill := w.bill
+	for i := range bill.LineItems {
+		if bill.LineItems[i].ID != itemID {
+			continue
+		}
+		if item == nil {
+			bill.LineItems = append(bill.LineItems[:i], bill.LineItems[i+1:]...)
+		} else {
+			bill.LineItems[i] = *item
+		}
+		bill.TotalAmount = bill.lineItemTotal()
+		return
+	}
+	if item != nil {
+		bill.LineItems = append(bill.LineItems, *item)
+		bill.TotalAmount = bill.lineItemTotal()
+	}
+}
+
+// ------ Activities ------
+
+// SaveAggregatedLineItemActivityParams defines parameters for SaveAggregatedLineItemActivity.
+type SaveAggregatedLineItemActivityParams struct {
+	BillID string
+	Event  LineItemEvent
+	// LineItem is the aggregated line item with the event added.
+	LineItem    LineItem
+	BillVersion int64
+}
+
+// SaveAggregatedLineItemActivity records a line item event and saves the aggregated
+// line item it was added to, in one transaction. It reports false, saving nothing,
+// for an event the bill already holds by ID or client reference. A retry of an attempt
+// that had committed finds the event at the same bill version and reports true.
+func (a *Activities) SaveAggregatedLineItemActivity(ctx context.Context, params SaveAggregatedLineItemActivityParams) (bool, error) {
+	sealedEvent, err := a.Fields.sealLineItem(LineItem{Description: params.Event.Description, ClientReference: params.Event.ClientReference})
+	if err != nil {
+		return false, fmt.Errorf("SaveAggregatedLineItemActivity: failed to encrypt line item event %s for bill %s: %w", params.Event.ID, params.BillID, err)
+	}
+	item, err := a.Fields.sealLineItem(params.LineItem)
+	if err != nil {
+		return false, fmt.Errorf("SaveAggregatedLineItemActivity: failed to encrypt line item %s for bill %s: %w", params.LineItem.ID, params.BillID, err)
+	}
+	aggregate, err := jsonColumn(params.LineItem.Aggregate)
+	if err != nil {
+		return false, fmt.Errorf("SaveAggregatedLineItemActivity: failed to encode aggregate of line item %s: %w", params.LineItem.ID, err)
+	}
+
+	ev := auditEvent{BillID: params.BillID, Action: AuditLineItemAdded, ActorKeyID: params.Event.CreatedByKeyID, SubjectID: params.LineItem.ID, Version: params.BillVersion, Event: &BillEventData{
+		LineItem: &item,
+	}}
+	err = a.audited(ctx, ev, func(tx *tracedTx) error {
+		result, err := tx.Exec(ctx, `
+            INSERT INTO line_item_events (id, bill_id, line_item_id, category, description, amount, client_reference, created_by_key_id, bill_version, received_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+            ON CONFLICT DO NOTHING
+        `, params.Event.ID, params.BillID, params.LineItem.ID, params.Event.Category, sealedEvent.Description, params.Event.Amount,
+			nullIfEmpty(sealedEvent.ClientReference), nullIfEmpty(params.Event.CreatedByKeyID), params.BillVersion, params.Event.ReceivedAt)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return errDuplicateLineItemEvent
+		}
+		_, err = tx.Exec(ctx, `
+            INSERT INTO line_items (id, bill_id, description, amount, created_at, late, over_hard_cap, aggregate)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+            ON CONFLICT (id) DO UPDATE SET amount = EXCLUDED.amount, over_hard_cap = EXCLUDED.over_hard_cap, aggregate = EXCLUDED.aggregate
+        `, item.ID, params.BillID, item.Description, item.Amount, params.Event.ReceivedAt, item.Late, item.OverHardCap, aggregate)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `UPDATE bills SET version = GREATEST(version, $2) WHERE id = $1`, params.BillID, params.BillVersion)
+		return err
+	})
+	if errors.Is(err, errDuplicateLineItemEvent) {
+		var version int64
+		lookupErr := a.DB.QueryRow(ctx, `SELECT bill_version FROM line_item_events WHERE id = $1`, params.Event.ID).Scan(&version)
+		if lookupErr != nil && !errors.Is(lookupErr, sqldb.ErrNoRows) {
+			return false, fmt.Errorf("SaveAggregatedLineItemActivity: failed to load line item event %s: %w", params.Event.ID, lookupErr)
+		}
+		return lookupErr == nil && version == params.BillVersion, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("SaveAggregatedLineItemActivity: failed to save line item event %s for bill %s: %w", params.Event.ID, params.BillID, err)
+	}
+	return true, nil
+}
+
+// ------ API ------
+
+// ListLineItemEventsParams pages through the events of an aggregated line item.
+type ListLineItemEventsParams struct {
+	Limit  int `query:"limit"`
+	Offset int `query:"offset"`
+}
+
+// ListLineItemEventsResponse lists the events an aggregated line item consolidates.
+type ListLineItemEventsResponse struct {
+	BillID     string          `json:"billId"`
+	LineItemID string          `json:"lineItemId"`
+	Events     []LineItemEvent `json:"events"`
+	Limit      int             `json:"limit"`
+	Offset     int             `json:"offset"`
+}
+
+// ListLineItemEvents lists the items an aggregated line item consolidates, as they were
+// sent, oldest first.
+//
+// encore:api auth method=GET path=/bills/:billID/items/:lineItemID/events
+func (s *Service) ListLineItemEvents(ctx context.Context, billID, lineItemID string, params *ListLineItemEventsParams) (*ListLineItemEventsResponse, error) {
+	if err := s.checkBillVisible(ctx, billID); err != nil {
+		return nil, err
+	}
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultLineItemEventsLimit
+	}
+	limit = min(limit, maxLineItemEventsLimit)
+	offset := max(params.Offset, 0)
+
+	rows, err := s.db.Query(ctx, `
+        SELECT id, line_item_id, category, description, amount::float8, COALESCE(client_reference, ''), COALESCE(created_by_key_id, ''), received_at
+        FROM line_item_events
+        WHERE bill_id = $1 AND line_item_id = $2
+        ORDER BY received_at, id
+        LIMIT $3 OFFSET $4
+    `, billID, lineItemID, limit, offset)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to list events of line item %s", lineItemID)
+	}
+	defer rows.Close()
+
+	resp := &ListLineItemEventsResponse{BillID: billID, LineItemID: lineItemID, Events: []LineItemEvent{}, Limit: limit, Offset: offset}
+	for rows.Next() {
+		var e LineItemEvent
+		if err := rows.Scan(&e.ID, &e.LineItemID, &e.Category, &e.Description, &e.Amount, &e.ClientReference, &e.CreatedByKeyID, &e.ReceivedAt); err != nil {
+			return nil, apierr.Wrap(err, "failed to read events of line item %s", lineItemID)
+		}
+		opened := LineItem{ID: e.ID, Description: e.Description, ClientReference: e.ClientReference}
+		if err := s.fields.openLineItem(&opened); err != nil {
+			return nil, apierr.Wrap(err, "failed to decrypt events of line item %s", lineItemID)
+		}
+		e.Description, e.ClientReference = opened.Description, opened.ClientReference
+		resp.Events = append(resp.Events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read events of line item %s", lineItemID)
+	}
+	return resp, nil
+}
+
+// scanLineItemAggregate decodes the aggregate column of a line item.
+func scanLineItemAggregate(column []byte) (*LineItemAggregate, error) {
+	if column == nil {
+		return nil, nil
+	}
+	var aggregate LineItemAggregate
+	if err := json.Unmarshal(column, &aggregate); err != nil {
+		return nil, err
+	}
+	return &aggregate, nil
+}
@@ -0,0 +1,52 @@
+package fees
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestLineItemAggregation_Normalize tests that an aggregation defaults to hourly and
+// accepts only intervals dividing a day.
+func TestLineItemAggregation_Normalize(t *testing.T) {
+	aggregation := &LineItemAggregation{}
+	require.NoError(t, aggregation.normalize())
+	require.Equal(t, "1h0m0s", aggregation.Interval)
+	require.Equal(t, time.Hour, aggregation.interval())
+
+	for _, interval := range []string{"1m", "15m", "6h", "24h"} {
+		require.NoError(t, (&LineItemAggregation{Interval: interval}).normalize(), interval)
+	}
+	for _, interval := range []string{"hourly", "30s", "7m", "48h", "-1h"} {
+		require.Error(t, (&LineItemAggregation{Interval: interval}).normalize(), interval)
+	}
+	require.Error(t, (&LineItemAggregation{MaxAmount: -1}).normalize())
+}
+
+// TestLineItemAggregation_Aggregates tests which items a bill aggregates.
+func TestLineItemAggregation_Aggregates(t *testing.T) {
+	var none *LineItemAggregation
+	require.False(t, none.aggregates(AddLineItemSignal{Amount: 0.001}))
+
+	all := &LineItemAggregation{}
+	require.True(t, all.aggregates(AddLineItemSignal{Amount: 500}))
+
+	small := &LineItemAggregation{MaxAmount: 1}
+	require.True(t, small.aggregates(AddLineItemSignal{Amount: 0.004}))
+	require.True(t, small.aggregates(AddLineItemSignal{Amount: -1}))
+	require.False(t, small.aggregates(AddLineItemSignal{Amount: 1.5}))
+	require.False(t, small.aggregates(AddLineItemSignal{Quantity: 3, UnitPrice: 0.5}))
+	require.False(t, small.aggregates(AddLineItemSignal{Amount: 0.5, SubBillID: "sub-1"}))
+	require.False(t, small.aggregates(AddLineItemSignal{Amount: 0.5, RoutedFrom: &RoutedFrom{}}))
+}
+
+// TestAggregateLineItemID tests that items of one category and interval share a line
+// item, and other categories or intervals get their own.
+func TestAggregateLineItemID(t *testing.T) {
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	require.Equal(t, aggregateLineItemID("bill-1", "api", start), aggregateLineItemID("bill-1", "api", start.In(time.FixedZone("x", 3600))))
+	require.NotEqual(t, aggregateLineItemID("bill-1", "api", start), aggregateLineItemID("bill-1", "storage", start))
+	require.NotEqual(t, aggregateLineItemID("bill-1", "api", start), aggregateLineItemID("bill-1", "api", start.Add(time.Hour)))
+	require.NotEqual(t, aggregateLineItemID("bill-1", "api", start), aggregateLineItemID("bill-2", "api", start))
+}
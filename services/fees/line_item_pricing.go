@@ -0,0 +1,82 @@
+package fees
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+
+	"encore.app/apierr"
+)
+
+// maxUnitLength caps the length of a line item's unit, such as "GB" or "transaction".
+const maxUnitLength = 32
+
+// lineItemAmount returns quantity × unitPrice at the four decimals amounts are stored
+// with, rounded half away from zero. The product is taken of the decimal values the
+// caller sent, so 0.1 GB at 0.3 comes to 0.03 and not 0.030000000000000002.
+func lineItemAmount(quantity, unitPrice float64) float64 {
+	return roundedAmount(new(big.Rat).Mul(decimalRat(quantity), decimalRat(unitPrice)))
+}
+
+// roundedAmount returns v at four decimals, rounded half away from zero.
+func roundedAmount(v *big.Rat) float64 {
+	scaled := new(big.Rat).Mul(v, big.NewRat(1e4, 1))
+	// Round half away from zero: add or subtract a half and truncate.
+	half := big.NewRat(1, 2)
+	if scaled.Sign() < 0 {
+		half.Neg(half)
+	}
+	scaled.Add(scaled, half)
+	units := new(big.Int).Quo(scaled.Num(), scaled.Denom())
+	amount, _ := new(big.Rat).SetFrac(units, big.NewInt(1e4)).Float64()
+	return amount
+}
+
+// decimalRat returns the decimal number v is the shortest representation of.
+func decimalRat(v float64) *big.Rat {
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(v, 'f', -1, 64))
+	return r
+}
+
+// priced reports whether the request gives its item as a quantity and unit price.
+func (r *AddLineItemRequest) priced() bool {
+	return r.Quantity != 0 || r.UnitPrice != 0
+}
+
+// validateAmount checks the request's amount, or its quantity and unit price, and
+// sets Amount to their product. Requests may give both, as long as they agree. The
+// amount and unit price take the sign of the item's type. Items rating usage of a
+// metric give only a quantity, checked by validateUsage.
+func (r *AddLineItemRequest) validateAmount() error {
+	if r.Metric != "" {
+		return r.validateUsage()
+	}
+	if len(r.Unit) > maxUnitLength {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "unit must be at most %d characters", maxUnitLength)
+	}
+	if !r.priced() {
+		if r.Unit != "" {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "unit requires a quantity and unitPrice")
+		}
+		if math.IsNaN(r.Amount) || math.IsInf(r.Amount, 0) || !r.Type.signed(r.Amount) {
+			return apierr.InvalidArgument(apierr.InvalidAmount, "line item amount must be %s, got %v", r.Type.sign(), r.Amount)
+		}
+		return nil
+	}
+
+	if math.IsNaN(r.Quantity) || math.IsInf(r.Quantity, 0) || r.Quantity <= 0 {
+		return apierr.InvalidArgument(apierr.InvalidAmount, "line item quantity must be a positive number, got %v", r.Quantity)
+	}
+	if math.IsNaN(r.UnitPrice) || math.IsInf(r.UnitPrice, 0) || !r.Type.signed(r.UnitPrice) {
+		return apierr.InvalidArgument(apierr.InvalidAmount, "line item unitPrice must be %s, got %v", r.Type.sign(), r.UnitPrice)
+	}
+	amount := lineItemAmount(r.Quantity, r.UnitPrice)
+	if !r.Type.signed(amount) {
+		return apierr.InvalidArgument(apierr.InvalidAmount, "line item quantity %v at unit price %v comes to less than the smallest amount", r.Quantity, r.UnitPrice)
+	}
+	if r.Amount != 0 && roundAmount(r.Amount) != amount {
+		return apierr.InvalidArgument(apierr.InvalidAmount, "line item amount %v does not match quantity %v at unit price %v, which comes to %v", r.Amount, r.Quantity, r.UnitPrice, amount)
+	}
+	r.Amount = amount
+	return nil
+}
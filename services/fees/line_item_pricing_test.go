@@ -0,0 +1,56 @@
+package fees
+
+import (
+	"errors"
+	"testing"
+
+	"encore.dev/beta/errs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineItemAmount(t *testing.T) {
+	cases := []struct {
+		quantity, unitPrice, want float64
+	}{
+		{3, 2.5, 7.5},
+		{0.1, 0.3, 0.03},
+		{12.5, 0.08, 1},
+		{1000000, 0.000123, 123},
+		// 0.00005 is the tie between two ten-thousandths, and rounds away from zero.
+		{0.5, 0.0001, 0.0001},
+		{1.1, 1.1, 1.21},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.want, lineItemAmount(c.quantity, c.unitPrice), "%v × %v", c.quantity, c.unitPrice)
+	}
+}
+
+// TestValidateAmount tests that items are given as an amount, or as a quantity and unit
+// price whose product sets the amount.
+func TestValidateAmount(t *testing.T) {
+	req := &AddLineItemRequest{Amount: 12}
+	require.NoError(t, req.validateAmount())
+	require.Equal(t, 12.0, req.Amount)
+
+	req = &AddLineItemRequest{Quantity: 1500, UnitPrice: 0.002, Unit: "transaction"}
+	require.NoError(t, req.validateAmount())
+	require.Equal(t, 3.0, req.Amount)
+
+	req = &AddLineItemRequest{Amount: 3, Quantity: 1500, UnitPrice: 0.002}
+	require.NoError(t, req.validateAmount())
+
+	for _, req := range []*AddLineItemRequest{
+		{},
+		{Amount: -1},
+		{Amount: 4, Quantity: 1500, UnitPrice: 0.002},
+		{Quantity: 2},
+		{Quantity: -2, UnitPrice: 1},
+		{Quantity: 0.00001, UnitPrice: 0.001},
+		{Amount: 1, Unit: "GB"},
+	} {
+		err := req.validateAmount()
+		var apiErr *errs.Error
+		require.True(t, errors.As(err, &apiErr), "%+v", req)
+		require.Equal(t, errs.InvalidArgument, apiErr.Code, "%+v", req)
+	}
+}
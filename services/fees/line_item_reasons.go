@@ -0,0 +1,104 @@
+package fees
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"encore.app/apierr"
+)
+
+// Reason codes of the credits and adjustments the service adds itself. They are
+// reserved: API callers cannot use them, so accounting can tell them apart.
+const (
+	// ReasonCodeBillLimits is the adjustment bringing a bill to the customer's limits.
+	ReasonCodeBillLimits = "bill_limits"
+	// ReasonCodeCustomerCredit is the customer credit applied to a closing bill.
+	ReasonCodeCustomerCredit = "customer_credit"
+	// ReasonCodeRefund is an item of a credit note.
+	ReasonCodeRefund = "refund"
+)
+
+// defaultReasonCodes are the reason codes accepted unless FEES_LINE_ITEM_REASON_CODES
+// lists others.
+var defaultReasonCodes = []string{"goodwill", "service_credit", "promotion", "overbilled", "underbilled", "billing_error", "other"}
+
+// maxExternalReferenceLength caps a line item's external reference.
+const maxExternalReferenceLength = 200
+
+// reasonCodePattern is the form of a reason code, such as "service_credit".
+var reasonCodePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_]*$`)
+
+// LineItemReference links a credit or adjustment to what it corrects.
+type LineItemReference struct {
+	// LineItemID is the item of the same bill that is credited or adjusted.
+	LineItemID string `json:"lineItemId,omitempty"`
+	// External identifies the correction outside the service, such as a support
+	// ticket's ID or URL.
+	External string `json:"external,omitempty"`
+}
+
+// lineItemID returns the referenced line item's ID; r may be nil.
+func (r *LineItemReference) lineItemID() string {
+	if r == nil {
+		return ""
+	}
+	return r.LineItemID
+}
+
+// external returns the external reference; r may be nil.
+func (r *LineItemReference) external() string {
+	if r == nil {
+		return ""
+	}
+	return r.External
+}
+
+// parseReasonCodes parses a comma-separated list of reason codes.
+func parseReasonCodes(v string) ([]string, error) {
+	var codes []string
+	for _, part := range strings.Split(v, ",") {
+		code := strings.TrimSpace(part)
+		if code == "" {
+			continue
+		}
+		if len(code) > maxReasonCodeLength || !reasonCodePattern.MatchString(code) {
+			return nil, fmt.Errorf("invalid reason code %q: must be at most %d lowercase letters, digits and underscores", code, maxReasonCodeLength)
+		}
+		if code == ReasonCodeBillLimits || code == ReasonCodeCustomerCredit || code == ReasonCodeRefund || code == ReasonCodeUsageRepricing || code == ReasonCodeCoupon {
+			return nil, fmt.Errorf("reason code %q is reserved for the service's own items", code)
+		}
+		if !slices.Contains(codes, code) {
+			codes = append(codes, code)
+		}
+	}
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("no reason codes given")
+	}
+	return codes, nil
+}
+
+// checkReason checks the request's reason code against codes, and that its reference
+// names an item of bill.
+func (r *AddLineItemRequest) checkReason(codes []string, bill *Bill) error {
+	if r.ReasonCode != "" && !slices.Contains(codes, r.ReasonCode) {
+		return apierr.InvalidArgument(apierr.UnknownReasonCode, "unknown reasonCode %q: must be one of %s", r.ReasonCode, strings.Join(codes, ", "))
+	}
+	if r.Reference == nil {
+		return nil
+	}
+	if r.Type == LineItemCharge {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "reference is only accepted on CREDIT and ADJUSTMENT items")
+	}
+	if r.Reference.LineItemID == "" && r.Reference.External == "" {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "reference must give a lineItemId or an external reference")
+	}
+	if len(r.Reference.External) > maxExternalReferenceLength {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "reference.external must be at most %d characters", maxExternalReferenceLength)
+	}
+	if id := r.Reference.LineItemID; id != "" && bill.lineItem(id, "") == nil {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "reference.lineItemId %q is not a line item of bill %s", id, bill.ID)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package fees
+
+import (
+	"errors"
+	"testing"
+
+	"encore.app/apierr"
+	"encore.dev/beta/errs"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseReasonCodes tests that reason codes are deduplicated, and that malformed
+// and reserved codes are refused.
+func TestParseReasonCodes(t *testing.T) {
+	codes, err := parseReasonCodes(" goodwill, sla_breach ,goodwill,")
+	require.NoError(t, err)
+	require.Equal(t, []string{"goodwill", "sla_breach"}, codes)
+
+	for _, v := range []string{"", ",", "Goodwill", "sla-breach", "refund", "goodwill,customer_credit"} {
+		_, err := parseReasonCodes(v)
+		require.Error(t, err, v)
+	}
+}
+
+// TestCheckReason tests that reason codes must be configured, and that references
+// name an item of the bill and are only accepted on credits and adjustments.
+func TestCheckReason(t *testing.T) {
+	bill := &Bill{ID: "bill-1", LineItems: []LineItem{{ID: "item-1", Amount: 10}}}
+	for _, req := range []*AddLineItemRequest{
+		{Type: LineItemCharge},
+		{Type: LineItemCredit, ReasonCode: "goodwill"},
+		{Type: LineItemCredit, ReasonCode: "overbilled", Reference: &LineItemReference{LineItemID: "item-1"}},
+		{Type: LineItemAdjustment, ReasonCode: "billing_error", Reference: &LineItemReference{External: "SUPPORT-123"}},
+	} {
+		require.NoError(t, req.checkReason(defaultReasonCodes, bill), "%+v", req)
+	}
+
+	for reason, req := range map[apierr.Reason]*AddLineItemRequest{
+		apierr.UnknownReasonCode: {Type: LineItemCredit, ReasonCode: "refund"},
+		apierr.InvalidParameter:  {Type: LineItemCredit, ReasonCode: "goodwill", Reference: &LineItemReference{LineItemID: "item-2"}},
+	} {
+		err := req.checkReason(defaultReasonCodes, bill)
+		var apiErr *errs.Error
+		require.True(t, errors.As(err, &apiErr), "%+v", req)
+		require.Equal(t, errs.InvalidArgument, apiErr.Code)
+		require.Equal(t, reason, apiErr.Details.(apierr.Details).Reason)
+	}
+	for _, req := range []*AddLineItemRequest{
+		{Type: LineItemCharge, Reference: &LineItemReference{External: "SUPPORT-123"}},
+		{Type: LineItemCredit, ReasonCode: "goodwill", Reference: &LineItemReference{}},
+	} {
+		require.Error(t, req.checkReason(defaultReasonCodes, bill), "%+v", req)
+	}
+}
@@ -0,0 +1,154 @@
+package fees
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// SaveFailurePolicy decides how a bill compensates for a line item that could not be
+// saved, so its workflow and the database do not silently diverge.
+type SaveFailurePolicy string
+
+const (
+	// SaveFailurePolicyRepair keeps the item on the bill and queues its save for the
+	// line item repair workflow, which retries it until it is saved.
+	SaveFailurePolicyRepair SaveFailurePolicy = "repair"
+	// SaveFailurePolicyRemove removes the item from the bill, records it among the
+	// bill's dropped items, and notifies the customer so the item can be sent again.
+	SaveFailurePolicyRemove SaveFailurePolicy = "remove"
+)
+
+// IsValid reports whether p is a known save failure policy.
+func (p SaveFailurePolicy) IsValid() bool {
+	return p == SaveFailurePolicyRepair || p == SaveFailurePolicyRemove
+}
+
+const (
+	// LineItemRepairWorkflowID names the single running LineItemRepairWorkflow.
+	LineItemRepairWorkflowID = "line-item-repair"
+	// QueueLineItemRepairSignalName delivers a failed save to LineItemRepairWorkflow.
+	QueueLineItemRepairSignalName = "QueueLineItemRepairSignal"
+	// QueueLineItemRepairActivityName queues a failed save for repair.
+	QueueLineItemRepairActivityName = "QueueLineItemRepairActivity"
+
+	// lineItemRepairsPerRun is how many saves a LineItemRepairWorkflow run handles
+	// before continuing as new, bounding its history.
+	lineItemRepairsPerRun = 200
+)
+
+// DroppedLineItem is a line item removed from its bill because it could not be saved.
+type DroppedLineItem struct {
+	LineItem
+	Reason    string    `json:"reason"`
+	DroppedAt time.Time `json:"droppedAt"`
+}
+
+// ------ Bill workflow ------
+
+// compensateSave handles a line item whose save failed after retries, per the bill's
+// save failure policy. Adjustment items are always repaired, since the bill's close
+// depends on them.
+func (w *billWorkflow) compensateSave(params SaveLineItemActivityParams, cause error, adjustment bool) {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	if w.params.saveFailurePolicy() == SaveFailurePolicyRemove && !adjustment {
+		for i, item := range bill.LineItems {
+			if item.ID != params.LineItemID {
+				continue
+			}
+			bill.LineItems = append(bill.LineItems[:i], bill.LineItems[i+1:]...)
+			bill.TotalAmount = bill.lineItemTotal()
+			bill.refreshCommitment()
+			bill.DroppedLineItems = append(bill.DroppedLineItems, DroppedLineItem{
+				LineItem:  item,
+				Reason:    cause.Error(),
+				DroppedAt: workflow.Now(ctx),
+			})
+			w.touch()
+			logger.Warn("Line item could not be saved, removed from bill", "bill_id", bill.ID, "line_item_id", item.ID, "amount", item.Amount)
+
+			notify(ctx, Notification{
+				Event:      NotificationLineItemDropped,
+				BillID:     bill.ID,
+				CustomerID: bill.CustomerID,
+				Message:    fmt.Sprintf("Line item %s (%s, %.2f %s) could not be saved and was removed from the bill; send it again.", item.ID, item.Description, item.Amount, bill.Currency),
+			})
+			return
+		}
+		return
+	}
+
+	err := workflow.ExecuteActivity(ctx, QueueLineItemRepairActivityName, params).Get(ctx, nil)
+	if err != nil {
+		logger.Error("Failed to queue line item for repair", "bill_id", bill.ID, "line_item_id", params.LineItemID, "error", err)
+		return
+	}
+	logger.Warn("Line item could not be saved, queued for repair", "bill_id", bill.ID, "line_item_id", params.LineItemID)
+}
+
+// ------ Repair workflow ------
+
+// LineItemRepairWorkflow drains the queue of line item saves that failed in their
+// bill's workflow, retrying each until it is saved. A single run is signal-with-started
+// by the first queued save; it completes once the queue is empty and continues as new
+// after lineItemRepairsPerRun saves.
+func LineItemRepairWorkflow(ctx workflow.Context, queued []SaveLineItemActivityParams) error {
+	logger := workflow.GetLogger(ctx)
+	// Unlike saves from a bill's workflow, repairs retry until they succeed, so this
+	// overrides the activity's policy.
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    10 * time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    10 * time.Minute,
+		},
+	})
+
+	signals := workflow.GetSignalChannel(ctx, QueueLineItemRepairSignalName)
+	drain := func() {
+		var params SaveLineItemActivityParams
+		for signals.ReceiveAsync(&params) {
+			queued = append(queued, params)
+			params = SaveLineItemActivityParams{}
+		}
+	}
+
+	for handled := 0; ; handled++ {
+		drain()
+		if len(queued) == 0 {
+			logger.Info("Line item repair queue drained", "handled", handled)
+			return nil
+		}
+		if handled == lineItemRepairsPerRun {
+			return workflow.NewContinueAsNewError(ctx, LineItemRepairWorkflow, queued)
+		}
+
+		params := queued[0]
+		queued = queued[1:]
+		if err := workflow.ExecuteActivity(ctx, SaveLineItemActivityName, params).Get(ctx, nil); err != nil {
+			// Only non-retryable errors end up here; retrying them would not help.
+			logger.Error("Line item repair failed", "bill_id", params.BillID, "line_item_id", params.LineItemID, "error", err)
+			continue
+		}
+		logger.Info("Line item repaired", "bill_id", params.BillID, "line_item_id", params.LineItemID)
+	}
+}
+
+// QueueLineItemRepairActivity queues a failed line item save on LineItemRepairWorkflow,
+// starting the workflow if it is not running.
+func (a *Activities) QueueLineItemRepairActivity(ctx context.Context, params SaveLineItemActivityParams) error {
+	options := client.StartWorkflowOptions{
+		ID:        LineItemRepairWorkflowID,
+		TaskQueue: feesTaskQueue,
+	}
+	_, err := a.Temporal.SignalWithStartWorkflow(ctx, LineItemRepairWorkflowID, QueueLineItemRepairSignalName, params, options, LineItemRepairWorkflow, []SaveLineItemActivityParams(nil))
+	if err != nil {
+		return fmt.Errorf("QueueLineItemRepairActivity: failed to queue line item %s of bill %s: %w", params.LineItemID, params.BillID, err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package fees
+
+import (
+	"fmt"
+
+	"encore.app/apierr"
+	"go.temporal.io/sdk/workflow"
+)
+
+// LineItemType says whether a line item charges, credits or adjusts its bill.
+type LineItemType string
+
+const (
+	// LineItemCharge is a positive amount owed for something the customer used.
+	LineItemCharge LineItemType = "CHARGE"
+	// LineItemCredit is a negative amount given back to the customer, such as a
+	// goodwill credit, applied customer credit, or a credit note's item.
+	LineItemCredit LineItemType = "CREDIT"
+	// LineItemAdjustment corrects the bill's total up or down, such as the item that
+	// brings it to the customer's bill limits.
+	LineItemAdjustment LineItemType = "ADJUSTMENT"
+)
+
+// maxReasonCodeLength caps a line item's reason code.
+const maxReasonCodeLength = 64
+
+// IsValid reports whether t is a known line item type.
+func (t LineItemType) IsValid() bool {
+	switch t {
+	case LineItemCharge, LineItemCredit, LineItemAdjustment:
+		return true
+	}
+	return false
+}
+
+// signed reports whether v has the sign of the type's amounts: positive for charges,
+// negative for credits, and either for adjustments. An empty type is a charge.
+func (t LineItemType) signed(v float64) bool {
+	switch t {
+	case LineItemCredit:
+		return v < 0
+	case LineItemAdjustment:
+		return v != 0
+	}
+	return v > 0
+}
+
+// sign describes the amounts signed accepts, for error messages.
+func (t LineItemType) sign() string {
+	switch t {
+	case LineItemCredit:
+		return "a negative number for CREDIT items"
+	case LineItemAdjustment:
+		return "a non-zero number for ADJUSTMENT items"
+	}
+	return "a positive number"
+}
+
+// itemType returns the item's type. Items saved before types existed are charges,
+// unless negative, when they can only have been credits.
+func (item *LineItem) itemType() LineItemType {
+	switch {
+	case item.Type != "":
+		return item.Type
+	case item.Amount < 0:
+		return LineItemCredit
+	}
+	return LineItemCharge
+}
+
+// LineItemTotals splits a bill's line item total by type. Credits are negative.
+type LineItemTotals struct {
+	Charges     float64 `json:"charges"`
+	Credits     float64 `json:"credits"`
+	Adjustments float64 `json:"adjustments"`
+}
+
+// lineItemTotals sums the bill's line items by type.
+func (b *Bill) lineItemTotals() *LineItemTotals {
+	var totals LineItemTotals
+	for i := range b.LineItems {
+		item := &b.LineItems[i]
+		switch item.itemType() {
+		case LineItemCredit:
+			totals.Credits += item.Amount
+		case LineItemAdjustment:
+			totals.Adjustments += item.Amount
+		default:
+			totals.Charges += item.Amount
+		}
+	}
+	totals.Charges, totals.Credits, totals.Adjustments = b.round(totals.Charges), b.round(totals.Credits), b.round(totals.Adjustments)
+	return &totals
+}
+
+// validateType checks the request's type and reason code, defaulting the type to
+// CHARGE. Credits and adjustments need a reason code, and only they may take the
+// bill's total below zero.
+func (r *AddLineItemRequest) validateType() error {
+	if r.Type == "" {
+		r.Type = LineItemCharge
+	}
+	if !r.Type.IsValid() {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "invalid type %q: must be CHARGE, CREDIT or ADJUSTMENT", r.Type)
+	}
+	if len(r.ReasonCode) > maxReasonCodeLength {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "reasonCode must be at most %d characters", maxReasonCodeLength)
+	}
+	switch r.Type {
+	case LineItemCharge:
+		if r.ReasonCode != "" {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "reasonCode is only accepted on CREDIT and ADJUSTMENT items")
+		}
+		if r.AllowNegativeTotal {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "allowNegativeTotal is only accepted on CREDIT and ADJUSTMENT items")
+		}
+	case LineItemCredit, LineItemAdjustment:
+		if r.ReasonCode == "" {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "%s items require a reasonCode", r.Type)
+		}
+		if r.Category != "" {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "category is only accepted on CHARGE items, which are the ones aggregated")
+		}
+	}
+	return nil
+}
+
+// takesTotalNegative reports whether an item of amount would take the bill's running
+// total below zero.
+func (b *Bill) takesTotalNegative(amount float64) bool {
+	return amount < 0 && b.round(b.lineItemTotal()+amount) < 0
+}
+
+// negativeTotal is the error returned for a line item that would take the bill's total
+// below zero.
+func negativeTotal(bill *Bill, amount float64) error {
+	return apierr.FailedPrecondition(apierr.NegativeTotal, "line item of %.2f %s would take bill %s to %.2f %s; set allowNegativeTotal to accept it",
+		amount, bill.Currency, bill.ID, bill.lineItemTotal()+amount, bill.Currency)
+}
+
+// ------ Workflow ------
+
+// rejectNegativeTotal records a line item refused because it would have taken the
+// bill's total below zero.
+func (w *billWorkflow) rejectNegativeTotal(item LineItem) {
+	bill := w.bill
+	w.recordRejectedLineItem(item, fmt.Sprintf("total would be %.2f %s, below zero", bill.lineItemTotal()+item.Amount, bill.Currency))
+	w.logger.Warn("Line item rejected for taking the bill's total below zero", "bill_id", bill.ID, "line_item_id", item.ID, "amount", item.Amount, "total_amount", bill.TotalAmount)
+}
+
+// recordRejectedLineItem adds item to the bill's rejected line items, dropping the
+// oldest beyond maxRejectedLineItems.
+func (w *billWorkflow) recordRejectedLineItem(item LineItem, reason string) {
+	bill := w.bill
+	bill.RejectedLineItems = append(bill.RejectedLineItems, RejectedLineItem{
+		LineItem:   item,
+		Reason:     reason,
+		RejectedAt: workflow.Now(w.ctx),
+	})
+	if n := len(bill.RejectedLineItems); n > maxRejectedLineItems {
+		bill.RejectedLineItems = bill.RejectedLineItems[n-maxRejectedLineItems:]
+	}
+}
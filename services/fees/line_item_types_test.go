@@ -0,0 +1,68 @@
+package fees
+
+import (
+	"errors"
+	"testing"
+
+	"encore.dev/beta/errs"
+	"github.com/stretchr/testify/require"
+)
+
+// TestValidateLineItemType tests that items default to charges, that credits are
+// negative and adjustments non-zero, and that both need a reason code.
+func TestValidateLineItemType(t *testing.T) {
+	for _, req := range []*AddLineItemRequest{
+		{Amount: 12},
+		{Amount: -12, Type: LineItemCredit, ReasonCode: "goodwill"},
+		{Quantity: 2, UnitPrice: -1.5, Type: LineItemCredit, ReasonCode: "sla_breach", AllowNegativeTotal: true},
+		{Amount: 5, Type: LineItemAdjustment, ReasonCode: "underbilled"},
+		{Amount: -5, Type: LineItemAdjustment, ReasonCode: "overbilled"},
+	} {
+		require.NoError(t, req.validateType(), "%+v", req)
+		require.NoError(t, req.validateAmount(), "%+v", req)
+	}
+	req := &AddLineItemRequest{Amount: 12}
+	require.NoError(t, req.validateType())
+	require.Equal(t, LineItemCharge, req.Type)
+
+	for _, req := range []*AddLineItemRequest{
+		{Amount: 12, Type: "REFUND"},
+		{Amount: 12, ReasonCode: "goodwill"},
+		{Amount: 12, AllowNegativeTotal: true},
+		{Amount: -12, Type: LineItemCredit},
+		{Amount: 12, Type: LineItemCredit, ReasonCode: "goodwill"},
+		{Quantity: 2, UnitPrice: 1.5, Type: LineItemCredit, ReasonCode: "goodwill"},
+		{Amount: -12, Type: LineItemCredit, ReasonCode: "goodwill", Category: "api_call"},
+		{Amount: 0, Type: LineItemAdjustment, ReasonCode: "overbilled"},
+	} {
+		err := req.validateType()
+		if err == nil {
+			err = req.validateAmount()
+		}
+		var apiErr *errs.Error
+		require.True(t, errors.As(err, &apiErr), "%+v", req)
+		require.Equal(t, errs.InvalidArgument, apiErr.Code, "%+v", req)
+	}
+}
+
+// TestLineItemTotals tests that a bill's items are summed by type, counting negative
+// items saved before types existed as credits.
+func TestLineItemTotals(t *testing.T) {
+	bill := &Bill{ID: "bill-1", Currency: "USD", LineItems: []LineItem{
+		{Amount: 40, Type: LineItemCharge},
+		{Amount: 10},
+		{Amount: -5, Type: LineItemCredit},
+		{Amount: -2.5},
+		{Amount: 3, Type: LineItemAdjustment},
+		{Amount: -1, Type: LineItemAdjustment},
+	}}
+	require.Equal(t, &LineItemTotals{Charges: 50, Credits: -7.5, Adjustments: 2}, bill.lineItemTotals())
+
+	require.False(t, bill.takesTotalNegative(-44.5))
+	require.True(t, bill.takesTotalNegative(-44.51))
+	require.False(t, bill.takesTotalNegative(10))
+	err := negativeTotal(bill, -50)
+	var apiErr *errs.Error
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, errs.FailedPrecondition, apiErr.Code)
+}
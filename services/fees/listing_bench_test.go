@@ -0,0 +1,129 @@
+package fees
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The listing benchmarks run against benchBills bills, each with one line item, spread
+// over benchCustomers customers of the bench tenant. Run them with
+// `encore test -run '^$' -bench Listing ./services/fees`; each fails if a query takes
+// longer than benchQueryBudget on average.
+const (
+	benchBills       = 1_000_000
+	benchCustomers   = 10_000
+	benchQueryBudget = 100 * time.Millisecond
+	benchTenant      = "bench"
+)
+
+var seedBenchOnce sync.Once
+
+// seedBenchBills fills the database with the benchmark's bills and line items, unless
+// an earlier run already did, and refreshes the planner's statistics.
+func seedBenchBills(b *testing.B) *tracedDB {
+	b.Helper()
+	tdb := newTracedDB(db, DBConfig{})
+	seedBenchOnce.Do(func() {
+		ctx := context.Background()
+		var n int
+		if err := tdb.QueryRow(ctx, `SELECT COUNT(*) FROM bills WHERE tenant_id = $1`, benchTenant).Scan(&n); err != nil {
+			b.Fatalf("failed to count bench bills: %v", err)
+		}
+		if n >= benchBills {
+			return
+		}
+		// Customers get a bill every benchCustomers minutes, cycling through statuses.
+		if _, err := tdb.Exec(ctx, `
+            INSERT INTO bills (id, customer_id, currency, status, created_at, total_amount, tenant_id, version)
+            SELECT 'bench-bill-' || i, 'bench-cust-' || (i % $1), 'USD',
+                   (ARRAY['OPEN', 'CLOSED', 'PAID', 'OVERDUE'])[1 + (i / $1) % 4],
+                   TIMESTAMPTZ '2024-01-01' + i * INTERVAL '1 minute', 10, $2, 1
+            FROM generate_series(1, $3::int) AS i
+            ON CONFLICT (id) DO NOTHING
+        `, benchCustomers, benchTenant, benchBills); err != nil {
+			b.Fatalf("failed to seed bench bills: %v", err)
+		}
+		if _, err := tdb.Exec(ctx, `
+            INSERT INTO line_items (id, bill_id, description, amount, created_at)
+            SELECT 'bench-item-' || i, 'bench-bill-' || i, 'Usage', 10, TIMESTAMPTZ '2024-01-01' + i * INTERVAL '1 minute'
+            FROM generate_series(1, $1::int) AS i
+            ON CONFLICT (id) DO NOTHING
+        `, benchBills); err != nil {
+			b.Fatalf("failed to seed bench line items: %v", err)
+		}
+		if _, err := tdb.Exec(ctx, `ANALYZE bills, line_items`); err != nil {
+			b.Fatalf("failed to analyze bench bills: %v", err)
+		}
+	})
+	return tdb
+}
+
+// withinBudget fails b if its operations took longer than benchQueryBudget on average.
+func withinBudget(b *testing.B) {
+	b.Helper()
+	if per := b.Elapsed() / time.Duration(b.N); per > benchQueryBudget {
+		b.Fatalf("took %s per query, over the %s budget", per, benchQueryBudget)
+	}
+}
+
+// BenchmarkListingStaleBillsByCustomerStatus lists a customer's bills of one status, as
+// GET /bills?customerId=&status= does while Temporal is unavailable.
+func BenchmarkListingStaleBillsByCustomerStatus(b *testing.B) {
+	tdb := seedBenchBills(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		params := &ListBillsParams{CustomerID: "bench-cust-42", Status: string(BillStatusClosed), TenantID: benchTenant}
+		if _, err := listStaleBills(ctx, tdb, params, billModes{Live: true}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	withinBudget(b)
+}
+
+// BenchmarkListingStaleBillsByStatus lists the newest bills of one status across
+// customers.
+func BenchmarkListingStaleBillsByStatus(b *testing.B) {
+	tdb := seedBenchBills(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := listStaleBills(ctx, tdb, &ListBillsParams{Status: string(BillStatusOpen), Limit: 50}, billModes{Live: true}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	withinBudget(b)
+}
+
+// BenchmarkListingStaleBill reads a bill with its line items.
+func BenchmarkListingStaleBill(b *testing.B) {
+	tdb := seedBenchBills(b)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bill, err := loadStaleBill(ctx, tdb, nil, "bench-bill-500000")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if bill == nil || len(bill.LineItems) != 1 {
+			b.Fatalf("bench-bill-500000 was not seeded")
+		}
+	}
+	withinBudget(b)
+}
+
+// BenchmarkListingCustomerStatement reports a customer's bills of one month with their
+// line item totals.
+func BenchmarkListingCustomerStatement(b *testing.B) {
+	svc := &Service{db: seedBenchBills(b)}
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := svc.GetCustomerStatement(ctx, "bench-cust-42", &StatementParams{Period: "2024-06"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	withinBudget(b)
+}
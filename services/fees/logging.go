@@ -0,0 +1,134 @@
+package fees
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"encore.dev/beta/errs"
+	"encore.dev/middleware"
+	tlog "go.temporal.io/sdk/log"
+	"google.golang.org/grpc"
+)
+
+// Logging conventions: every log line goes through slog, with snake_case attribute
+// keys. Lines about a bill, customer, or workflow carry bill_id, customer_id, and
+// workflow_id so they can be joined across the API, workflows, and activities. Handlers
+// log through loggerFrom(ctx), which already carries the request's attributes.
+//
+// Levels: debug for routine progress, info for state changes, warn for failures the
+// service recovers from, error for failures that lose work or need an operator.
+
+// newLogger returns the process-wide logger, writing JSON lines at level and above.
+func newLogger(level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+}
+
+// temporalLogger routes the Temporal SDK's logs, including workflow and activity
+// loggers, through l. Workflow loggers skip lines during replay.
+func temporalLogger(l *slog.Logger) tlog.Logger {
+	return tlog.NewStructuredLogger(l)
+}
+
+// parseLogLevel parses "debug", "info", "warn", or "error".
+func parseLogLevel(v string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(v)); err != nil {
+		return 0, fmt.Errorf("invalid FEES_LOG_LEVEL %q: must be debug, info, warn, or error", v)
+	}
+	return level, nil
+}
+
+type loggerCtxKey struct{}
+
+// withLogger returns a context carrying l as the request's logger.
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerCtxKey{}, l)
+}
+
+// loggerFrom returns the request's logger, or the process-wide one outside a request.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerCtxKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// requestLogger returns a logger carrying the attributes every log line of a request
+// shares: the transport, endpoint, caller, and the bill or customer it names.
+func requestLogger(ctx context.Context, transport, endpoint string, attrs ...any) *slog.Logger {
+	attrs = append([]any{"transport", transport, "endpoint", endpoint}, attrs...)
+	if data := caller(ctx); data != nil {
+		attrs = append(attrs, "tenant_id", tenantOrDefault(data.TenantID), "key_id", data.KeyID)
+	}
+	return slog.Default().With(attrs...)
+}
+
+// logRequest logs the outcome of a request: errors the caller caused at debug level,
+// like successes, and server-side failures at error level.
+func logRequest(ctx context.Context, code string, elapsed time.Duration) {
+	l := loggerFrom(ctx)
+	switch code {
+	case errs.Internal.String(), errs.Unknown.String(), errs.DataLoss.String(), errs.Unavailable.String():
+		l.Error("Request failed", "code", code, "duration", elapsed)
+	default:
+		l.Debug("Request served", "code", code, "duration", elapsed)
+	}
+}
+
+// Log gives each request a logger carrying its endpoint, caller, and the bill,
+// customer, or subscription named by its path, and logs the request's outcome.
+//
+// encore:middleware target=all
+func (s *Service) Log(req middleware.Request, next middleware.Next) middleware.Response {
+	data := req.Data()
+	var attrs []any
+	if data.Trace != nil {
+		attrs = append(attrs, "trace_id", data.Trace.TraceID)
+	}
+	for _, p := range data.PathParams {
+		if key, ok := pathParamLogKeys[p.Name]; ok {
+			attrs = append(attrs, key, p.Value)
+		}
+	}
+	ctx := withLogger(req.Context(), requestLogger(req.Context(), "http", data.Endpoint, attrs...))
+
+	start := time.Now()
+	resp := next(req.WithContext(ctx))
+	logRequest(ctx, errorCode(resp.Err), time.Since(start))
+	return resp
+}
+
+// pathParamLogKeys maps path parameters to the log attributes they are recorded as.
+var pathParamLogKeys = map[string]string{
+	"billID":         "bill_id",
+	"customerID":     "customer_id",
+	"subscriptionID": "subscription_id",
+	"jobID":          "job_id",
+}
+
+// logUnaryInterceptor gives each unary RPC a request logger, like the Log middleware.
+func (s *Service) logUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var attrs []any
+	if r, ok := req.(interface{ GetBillId() string }); ok && r.GetBillId() != "" {
+		attrs = append(attrs, "bill_id", r.GetBillId())
+	}
+	ctx = withLogger(ctx, requestLogger(ctx, "grpc", rpcName(info.FullMethod), attrs...))
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logRequest(ctx, grpcCode(err), time.Since(start))
+	return resp, err
+}
+
+// logStreamInterceptor gives each streaming RPC a request logger.
+func (s *Service) logStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := withLogger(ss.Context(), requestLogger(ss.Context(), "grpc", rpcName(info.FullMethod)))
+
+	start := time.Now()
+	err := handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	logRequest(ctx, grpcCode(err), time.Since(start))
+	return err
+}
@@ -0,0 +1,60 @@
+package fees
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"encore.dev/beta/errs"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseLogLevel tests that log levels are parsed case-insensitively and typos rejected.
+func TestParseLogLevel(t *testing.T) {
+	level, err := parseLogLevel("debug")
+	require.NoError(t, err)
+	require.Equal(t, slog.LevelDebug, level)
+	level, err = parseLogLevel("WARN")
+	require.NoError(t, err)
+	require.Equal(t, slog.LevelWarn, level)
+	_, err = parseLogLevel("verbose")
+	require.ErrorContains(t, err, "FEES_LOG_LEVEL")
+}
+
+// TestRequestLogger tests that a request's logger carries its endpoint and caller, and
+// that only server-side failures are logged above debug level.
+func TestRequestLogger(t *testing.T) {
+	previous := slog.Default()
+	defer slog.SetDefault(previous)
+	var out bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	require.Same(t, slog.Default(), loggerFrom(context.Background()))
+
+	ctx := context.WithValue(context.Background(), callerCtxKey{}, &AuthData{KeyID: "key-1", TenantID: "acme"})
+	ctx = withLogger(ctx, requestLogger(ctx, "http", "GetBill", "bill_id", "b1"))
+	logRequest(ctx, errorCode(nil), time.Millisecond)
+	logRequest(ctx, errs.Internal.String(), time.Millisecond)
+
+	var lines []map[string]any
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var line map[string]any
+		require.NoError(t, dec.Decode(&line))
+		lines = append(lines, line)
+	}
+	require.Len(t, lines, 2)
+	for _, line := range lines {
+		require.Equal(t, "http", line["transport"])
+		require.Equal(t, "GetBill", line["endpoint"])
+		require.Equal(t, "b1", line["bill_id"])
+		require.Equal(t, "acme", line["tenant_id"])
+		require.Equal(t, "key-1", line["key_id"])
+	}
+	require.Equal(t, "DEBUG", lines[0]["level"])
+	require.Equal(t, "ERROR", lines[1]["level"])
+	require.Equal(t, "internal", lines[1]["code"])
+}
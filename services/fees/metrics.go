@@ -0,0 +1,242 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"encore.dev/beta/errs"
+	"encore.dev/middleware"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histograms. They span
+// fast API calls up to the minutes-long delays of a backed-up worker.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// serviceMetrics holds the process-wide Prometheus metrics served at /metrics.
+type serviceMetrics struct {
+	requests        *counterVec
+	requestDuration *histogramVec
+	signalFailures  *counterVec
+	queryFailures   *counterVec
+	activities      *counterVec
+	signalLatency   *histogramVec
+	activityTime    *histogramVec
+	temporalCalls   *histogramVec
+}
+
+func newServiceMetrics() *serviceMetrics {
+	return &serviceMetrics{
+		requests: newCounterVec("fees_api_requests_total",
+			"API calls by transport, endpoint, and result code.", "transport", "endpoint", "code"),
+		requestDuration: newHistogramVec("fees_api_request_duration_seconds",
+			"API call latency by transport and endpoint.", "transport", "endpoint"),
+		signalFailures: newCounterVec("fees_signal_send_failures_total",
+			"Signals the Temporal client failed to deliver, by signal name.", "signal"),
+		queryFailures: newCounterVec("fees_workflow_query_failures_total",
+			"Failed workflow queries, by query name.", "query"),
+		activities: newCounterVec("fees_activity_executions_total",
+			"Activity attempts by activity type and outcome (success or error).", "activity", "outcome"),
+		signalLatency: newHistogramVec("fees_signal_to_apply_latency_seconds",
+			"Delay between a signal being sent and its workflow applying it.", "signal"),
+		activityTime: newHistogramVec("fees_activity_duration_seconds",
+			"Activity attempt duration by activity type.", "activity"),
+		temporalCalls: newHistogramVec("fees_temporal_client_call_duration_seconds",
+			"Temporal client call latency by operation (start, signal, signal_with_start, or query) and workflow type, signal, or query name.", "operation", "name"),
+	}
+}
+
+// observeRequest records the outcome and latency of an API call. transport is "http"
+// or "grpc"; code is the Encore error code name, or "ok".
+func (m *serviceMetrics) observeRequest(transport, endpoint, code string, d time.Duration) {
+	m.requests.inc(transport, endpoint, code)
+	m.requestDuration.observe(d, transport, endpoint)
+}
+
+// errorCode returns the Encore error code name of err, "ok" for nil.
+func errorCode(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	var apiErr *errs.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code.String()
+	}
+	return errs.Unknown.String()
+}
+
+// writePrometheus writes all metrics in the Prometheus text exposition format.
+func (m *serviceMetrics) writePrometheus(w io.Writer) {
+	m.requests.write(w)
+	m.requestDuration.write(w)
+	m.signalFailures.write(w)
+	m.queryFailures.write(w)
+	m.activities.write(w)
+	m.signalLatency.write(w)
+	m.activityTime.write(w)
+	m.temporalCalls.write(w)
+}
+
+// Instrument records the count, result code, and latency of every API call.
+//
+// encore:middleware target=all
+func (s *Service) Instrument(req middleware.Request, next middleware.Next) middleware.Response {
+	start := time.Now()
+	resp := next(req)
+	s.metrics.observeRequest("http", req.Data().Endpoint, errorCode(resp.Err), time.Since(start))
+	return resp
+}
+
+// Metrics serves the service's metrics in the Prometheus text format, including the
+// number of open bills. Like the status feed it is unauthenticated and exposes no
+// tenant or customer data.
+//
+// encore:api public raw method=GET path=/metrics
+func (s *Service) Metrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.metrics.writePrometheus(w)
+	s.writeOpenBillsGauge(req.Context(), w)
+}
+
+// writeOpenBillsGauge reports the number of open bills, read at scrape time.
+func (s *Service) writeOpenBillsGauge(ctx context.Context, w io.Writer) {
+	var open int
+	if err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM bills WHERE status = $1`, BillStatusOpen).Scan(&open); err != nil {
+		// Leave the gauge out rather than report a wrong value.
+		return
+	}
+	fmt.Fprintf(w, "# HELP fees_open_bills Bills currently open.\n# TYPE fees_open_bills gauge\nfees_open_bills %d\n", open)
+}
+
+// ------ Metric primitives ------
+
+// counterVec is a counter partitioned by label values. It is safe for concurrent use.
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labelValues []string
+	n           uint64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]*counterValue)}
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.values[key]
+	if !ok {
+		v = &counterValue{labelValues: labelValues}
+		c.values[key] = v
+	}
+	v.n++
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		v := c.values[key]
+		fmt.Fprintf(w, "%s%s %d\n", c.name, formatLabels(c.labels, v.labelValues), v.n)
+	}
+}
+
+// histogramVec is a latency histogram partitioned by label values, with cumulative
+// latencyBuckets. It is safe for concurrent use.
+type histogramVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labelValues []string
+	counts      []uint64 // per bucket in latencyBuckets, plus +Inf
+	count       uint64
+	sum         float64
+}
+
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, values: make(map[string]*histogramValue)}
+}
+
+func (h *histogramVec) observe(d time.Duration, labelValues ...string) {
+	seconds := d.Seconds()
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	v, ok := h.values[key]
+	if !ok {
+		v = &histogramValue{labelValues: labelValues, counts: make([]uint64, len(latencyBuckets)+1)}
+		h.values[key] = v
+	}
+	v.counts[sort.SearchFloat64s(latencyBuckets, seconds)]++
+	v.count++
+	v.sum += seconds
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	bucketLabels := withLabel(h.labels, "le")
+	for _, key := range sortedKeys(h.values) {
+		v := h.values[key]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += v.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, withLabel(v.labelValues, formatFloat(bound))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, withLabel(v.labelValues, "+Inf")), v.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, v.labelValues), formatFloat(v.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, v.labelValues), v.count)
+	}
+}
+
+// formatLabels renders a Prometheus label set, e.g. {transport="http",endpoint="GetBill",code="ok"}.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%q", name, values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel returns a copy of labels with one more appended.
+func withLabel(labels []string, label string) []string {
+	return append(labels[:len(labels):len(labels)], label)
+}
+
+func formatFloat(f float64) string {
+	return fmt.Sprintf("%g", math.Round(f*1e6)/1e6)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
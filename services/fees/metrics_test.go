@@ -0,0 +1,51 @@
+package fees
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"encore.app/apierr"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestServiceMetrics_Requests tests that API calls are counted per transport, endpoint, and result code.
+func TestServiceMetrics_Requests(t *testing.T) {
+	metrics := newServiceMetrics()
+	metrics.observeRequest("http", "GetBill", errorCode(nil), 20*time.Millisecond)
+	metrics.observeRequest("http", "GetBill", errorCode(nil), 40*time.Millisecond)
+	metrics.observeRequest("http", "GetBill", errorCode(apierr.NotFound(apierr.BillNotFound, "bill %s not found", "b1")), time.Millisecond)
+	metrics.observeRequest("http", "CloseBill", errorCode(errors.New("boom")), 2*time.Second)
+	metrics.observeRequest("grpc", "GetBill", grpcCode(status.Error(codes.NotFound, "bill b1 not found")), time.Millisecond)
+
+	var out bytes.Buffer
+	metrics.writePrometheus(&out)
+	text := out.String()
+
+	require.Contains(t, text, "# TYPE fees_api_requests_total counter\n")
+	require.Contains(t, text, `fees_api_requests_total{transport="http",endpoint="GetBill",code="ok"} 2`)
+	require.Contains(t, text, `fees_api_requests_total{transport="http",endpoint="GetBill",code="not_found"} 1`)
+	require.Contains(t, text, `fees_api_requests_total{transport="http",endpoint="CloseBill",code="unknown"} 1`)
+	require.Contains(t, text, `fees_api_request_duration_seconds_bucket{transport="http",endpoint="GetBill",le="0.025"} 2`)
+	require.Contains(t, text, `fees_api_request_duration_seconds_bucket{transport="http",endpoint="GetBill",le="+Inf"} 3`)
+	require.Contains(t, text, `fees_api_request_duration_seconds_count{transport="http",endpoint="CloseBill"} 1`)
+	require.Contains(t, text, `fees_api_requests_total{transport="grpc",endpoint="GetBill",code="not_found"} 1`)
+}
+
+// TestCounterVec tests that counters are kept per label set and written in a stable order.
+func TestCounterVec(t *testing.T) {
+	activities := newCounterVec("fees_activity_executions_total", "Activity attempts.", "activity", "outcome")
+	activities.inc("SaveLineItemActivity", "success")
+	activities.inc("ChargePaymentActivity", "error")
+	activities.inc("SaveLineItemActivity", "success")
+
+	var out bytes.Buffer
+	activities.write(&out)
+	require.Equal(t, "# HELP fees_activity_executions_total Activity attempts.\n"+
+		"# TYPE fees_activity_executions_total counter\n"+
+		`fees_activity_executions_total{activity="ChargePaymentActivity",outcome="error"} 1`+"\n"+
+		`fees_activity_executions_total{activity="SaveLineItemActivity",outcome="success"} 2`+"\n", out.String())
+}
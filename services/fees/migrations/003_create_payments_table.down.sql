@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_payments_bill_id;
+DROP TABLE IF EXISTS payments;
+
+ALTER TABLE bills DROP CONSTRAINT IF EXISTS bills_status_check;
+ALTER TABLE bills ADD CONSTRAINT bills_status_check
+    CHECK (status IN ('OPEN', 'CLOSED'));
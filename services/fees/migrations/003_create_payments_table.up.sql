@@ -0,0 +1,17 @@
+ALTER TABLE bills DROP CONSTRAINT IF EXISTS bills_status_check;
+ALTER TABLE bills ADD CONSTRAINT bills_status_check
+    CHECK (status IN ('OPEN', 'CLOSED', 'PAID', 'PAYMENT_FAILED'));
+
+CREATE TABLE payments (
+    id TEXT PRIMARY KEY,
+    bill_id TEXT NOT NULL REFERENCES bills(id) ON DELETE CASCADE,
+    amount NUMERIC(16, 4) NOT NULL,
+    currency TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('PENDING', 'SUCCEEDED', 'DECLINED', 'FAILED')),
+    gateway_reference TEXT,
+    failure_reason TEXT,
+    created_at TIMESTAMPTZ NOT NULL,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_payments_bill_id ON payments(bill_id);
@@ -0,0 +1,4 @@
+ALTER TABLE line_items DROP COLUMN IF EXISTS credit_note_id;
+
+DROP INDEX IF EXISTS idx_credit_notes_bill_id;
+DROP TABLE IF EXISTS credit_notes;
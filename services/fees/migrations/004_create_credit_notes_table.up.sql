@@ -0,0 +1,17 @@
+CREATE TABLE credit_notes (
+    id TEXT PRIMARY KEY,
+    bill_id TEXT NOT NULL REFERENCES bills(id) ON DELETE CASCADE,
+    amount NUMERIC(16, 4) NOT NULL,
+    currency TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL CHECK (status IN ('PENDING', 'SUCCEEDED', 'FAILED')),
+    gateway_reference TEXT,
+    failure_reason TEXT,
+    created_at TIMESTAMPTZ NOT NULL,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_credit_notes_bill_id ON credit_notes(bill_id);
+
+-- Negative line items issued by a credit note reference it; regular charges leave it NULL.
+ALTER TABLE line_items ADD COLUMN credit_note_id TEXT REFERENCES credit_notes(id) ON DELETE CASCADE;
@@ -0,0 +1,3 @@
+ALTER TABLE bills DROP CONSTRAINT IF EXISTS bills_status_check;
+ALTER TABLE bills ADD CONSTRAINT bills_status_check
+    CHECK (status IN ('OPEN', 'CLOSED', 'PAID', 'PAYMENT_FAILED'));
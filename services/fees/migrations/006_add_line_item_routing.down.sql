@@ -0,0 +1,4 @@
+ALTER TABLE line_items
+    DROP COLUMN IF EXISTS original_period_end,
+    DROP COLUMN IF EXISTS original_period_start,
+    DROP COLUMN IF EXISTS routed_from_bill_id;
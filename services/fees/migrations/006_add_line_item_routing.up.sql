@@ -0,0 +1,4 @@
+ALTER TABLE line_items
+    ADD COLUMN routed_from_bill_id TEXT REFERENCES bills(id) ON DELETE SET NULL,
+    ADD COLUMN original_period_start TIMESTAMPTZ,
+    ADD COLUMN original_period_end TIMESTAMPTZ;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS quota_overrides;
+DROP TABLE IF EXISTS quota_usage;
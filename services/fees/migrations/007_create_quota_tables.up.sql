@@ -0,0 +1,17 @@
+-- Monthly usage counters per tenant and metric; period is the UTC calendar month, e.g. '2024-06'.
+CREATE TABLE quota_usage (
+    tenant_id TEXT NOT NULL,
+    metric TEXT NOT NULL,
+    period TEXT NOT NULL,
+    used INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (tenant_id, metric, period)
+);
+
+-- Admin overrides of the service-wide monthly caps; monthly_limit 0 means unlimited.
+CREATE TABLE quota_overrides (
+    tenant_id TEXT NOT NULL,
+    metric TEXT NOT NULL,
+    monthly_limit INTEGER NOT NULL CHECK (monthly_limit >= 0),
+    updated_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (tenant_id, metric)
+);
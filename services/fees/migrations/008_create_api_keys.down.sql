@@ -0,0 +1,3 @@
+ALTER TABLE line_items DROP COLUMN IF EXISTS created_by_key_id;
+ALTER TABLE bills DROP COLUMN IF EXISTS created_by_key_id;
+DROP TABLE IF EXISTS api_keys;
@@ -0,0 +1,18 @@
+-- API keys authenticating callers of the public endpoints; only a SHA-256 hash of each key is stored.
+CREATE TABLE api_keys (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    tenant_id TEXT NOT NULL,
+    key_prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_api_keys_tenant_id ON api_keys (tenant_id);
+
+-- Attribution of bills and line items to the API key that created them.
+ALTER TABLE bills ADD COLUMN created_by_key_id TEXT REFERENCES api_keys(id);
+ALTER TABLE line_items ADD COLUMN created_by_key_id TEXT REFERENCES api_keys(id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS bill_audit_log;
+DROP FUNCTION IF EXISTS reject_bill_audit_log_change();
@@ -0,0 +1,26 @@
+-- Append-only trail of bill mutations with before/after snapshots, kept for compliance.
+CREATE TABLE bill_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    -- Identifies the activity execution that made the change, so retries record it once.
+    event_id TEXT NOT NULL UNIQUE,
+    bill_id TEXT NOT NULL REFERENCES bills(id),
+    action TEXT NOT NULL,
+    -- NULL when the service made the change on its own.
+    actor_key_id TEXT REFERENCES api_keys(id),
+    subject_id TEXT,
+    before JSONB,
+    after JSONB NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_bill_audit_log_bill_id ON bill_audit_log (bill_id, id);
+
+CREATE FUNCTION reject_bill_audit_log_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'bill_audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER bill_audit_log_append_only
+    BEFORE UPDATE OR DELETE ON bill_audit_log
+    FOR EACH ROW EXECUTE FUNCTION reject_bill_audit_log_change();
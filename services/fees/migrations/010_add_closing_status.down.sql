@@ -0,0 +1,5 @@
+ALTER TABLE line_items DROP COLUMN IF EXISTS late;
+UPDATE bills SET status = 'OPEN' WHERE status = 'CLOSING';
+ALTER TABLE bills DROP CONSTRAINT IF EXISTS bills_status_check;
+ALTER TABLE bills ADD CONSTRAINT bills_status_check
+    CHECK (status IN ('OPEN', 'CLOSED', 'PAID', 'PAYMENT_FAILED', 'DELINQUENT'));
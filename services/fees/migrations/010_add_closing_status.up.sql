@@ -0,0 +1,6 @@
+ALTER TABLE bills DROP CONSTRAINT IF EXISTS bills_status_check;
+ALTER TABLE bills ADD CONSTRAINT bills_status_check
+    CHECK (status IN ('OPEN', 'CLOSING', 'CLOSED', 'PAID', 'PAYMENT_FAILED', 'DELINQUENT'));
+
+-- Line items added while their bill was closing.
+ALTER TABLE line_items ADD COLUMN late BOOLEAN NOT NULL DEFAULT FALSE;
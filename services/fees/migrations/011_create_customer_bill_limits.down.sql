@@ -0,0 +1,2 @@
+ALTER TABLE bills DROP COLUMN IF EXISTS adjustment;
+DROP TABLE IF EXISTS customer_bill_limits;
@@ -0,0 +1,15 @@
+-- Per-customer bounds on bill totals, applied when a bill in that currency closes.
+-- A bound of 0 is unset.
+CREATE TABLE customer_bill_limits (
+    customer_id TEXT NOT NULL,
+    currency TEXT NOT NULL,
+    minimum_total NUMERIC(16, 4) NOT NULL DEFAULT 0 CHECK (minimum_total >= 0),
+    maximum_total NUMERIC(16, 4) NOT NULL DEFAULT 0 CHECK (maximum_total >= 0),
+    over_maximum TEXT NOT NULL DEFAULT 'cap' CHECK (over_maximum IN ('cap', 'flag')),
+    updated_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (customer_id, currency),
+    CHECK (maximum_total = 0 OR maximum_total >= minimum_total)
+);
+
+-- The adjustment applied to a bill's total against its customer's limits on close.
+ALTER TABLE bills ADD COLUMN adjustment JSONB;
@@ -0,0 +1,5 @@
+ALTER TABLE bills DROP COLUMN IF EXISTS approval;
+UPDATE bills SET status = 'CLOSING' WHERE status = 'PENDING_APPROVAL';
+ALTER TABLE bills DROP CONSTRAINT IF EXISTS bills_status_check;
+ALTER TABLE bills ADD CONSTRAINT bills_status_check
+    CHECK (status IN ('OPEN', 'CLOSING', 'CLOSED', 'PAID', 'PAYMENT_FAILED', 'DELINQUENT'));
@@ -0,0 +1,6 @@
+ALTER TABLE bills DROP CONSTRAINT IF EXISTS bills_status_check;
+ALTER TABLE bills ADD CONSTRAINT bills_status_check
+    CHECK (status IN ('OPEN', 'CLOSING', 'PENDING_APPROVAL', 'CLOSED', 'PAID', 'PAYMENT_FAILED', 'DELINQUENT'));
+
+-- The latest approval request and decision of a bill held for approval.
+ALTER TABLE bills ADD COLUMN approval JSONB;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS temporal_outbox;
@@ -0,0 +1,16 @@
+-- CreateBill and AddLineItem requests queued while Temporal was unavailable, replayed
+-- in id order once it is reachable again.
+CREATE TABLE temporal_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    kind TEXT NOT NULL CHECK (kind IN ('create_bill', 'add_line_item')),
+    bill_id TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    delivered_at TIMESTAMPTZ,
+    failed_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_temporal_outbox_pending ON temporal_outbox (id) WHERE delivered_at IS NULL AND failed_at IS NULL;
+CREATE INDEX idx_temporal_outbox_bill_id ON temporal_outbox (bill_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS line_items_bill_client_reference_idx;
+ALTER TABLE line_items DROP COLUMN IF EXISTS client_reference;
@@ -0,0 +1,4 @@
+-- The caller's own ID for a line item. A bill holds at most one item per reference.
+ALTER TABLE line_items ADD COLUMN client_reference TEXT;
+CREATE UNIQUE INDEX line_items_bill_client_reference_idx ON line_items (bill_id, client_reference)
+    WHERE client_reference IS NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE bills DROP COLUMN IF EXISTS template_id;
+DROP TABLE IF EXISTS bill_templates;
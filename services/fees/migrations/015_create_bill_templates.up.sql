@@ -0,0 +1,17 @@
+CREATE TABLE bill_templates (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    -- Empty allows bills in any currency.
+    currency TEXT NOT NULL DEFAULT '',
+    -- Recurring items seeded onto new bills: [{"description": ..., "amount": ...}].
+    line_items JSONB NOT NULL DEFAULT '[]',
+    auto_collect BOOLEAN NOT NULL DEFAULT FALSE,
+    close_grace_period TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+-- The template a bill was created from. Templates can be deleted afterwards, so this
+-- is not a foreign key.
+ALTER TABLE bills ADD COLUMN template_id TEXT;
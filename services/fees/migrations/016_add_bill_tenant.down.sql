@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_bills_tenant_customer;
+DROP INDEX IF EXISTS idx_bills_tenant_id;
+ALTER TABLE bills DROP COLUMN IF EXISTS tenant_id;
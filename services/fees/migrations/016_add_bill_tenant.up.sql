@@ -0,0 +1,6 @@
+-- The platform a bill belongs to. Bills created before tenants were recorded belong to
+-- the default tenant.
+ALTER TABLE bills ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+
+CREATE INDEX idx_bills_tenant_id ON bills (tenant_id, created_at DESC);
+CREATE INDEX idx_bills_tenant_customer ON bills (tenant_id, customer_id, currency, status);
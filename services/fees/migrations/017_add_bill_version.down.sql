@@ -0,0 +1 @@
+ALTER TABLE bills DROP COLUMN IF EXISTS version;
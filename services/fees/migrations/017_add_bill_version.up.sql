@@ -0,0 +1,4 @@
+-- The bill's version as of its last persisted change. Writes only ever raise it, so a
+-- retried or reordered activity cannot move it back. Bills persisted before versions
+-- were recorded start at 0.
+ALTER TABLE bills ADD COLUMN version BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS bill_events;
+DROP FUNCTION IF EXISTS reject_bill_events_change();
@@ -0,0 +1,23 @@
+-- Ordered log of bill state transitions; replaying it rebuilds the bill for reconciliation.
+CREATE TABLE bill_events (
+    id BIGSERIAL PRIMARY KEY,
+    -- Identifies the activity execution that produced the event, so retries record it once.
+    event_id TEXT NOT NULL UNIQUE,
+    bill_id TEXT NOT NULL REFERENCES bills(id),
+    sequence BIGINT NOT NULL,
+    type TEXT NOT NULL,
+    bill_version BIGINT NOT NULL DEFAULT 0,
+    data JSONB NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (bill_id, sequence)
+);
+
+CREATE FUNCTION reject_bill_events_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'bill_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER bill_events_append_only
+    BEFORE UPDATE OR DELETE ON bill_events
+    FOR EACH ROW EXECUTE FUNCTION reject_bill_events_change();
@@ -0,0 +1,4 @@
+UPDATE bills SET status = 'CLOSING' WHERE status = 'CLOSE_FAILED';
+ALTER TABLE bills DROP CONSTRAINT IF EXISTS bills_status_check;
+ALTER TABLE bills ADD CONSTRAINT bills_status_check
+    CHECK (status IN ('OPEN', 'CLOSING', 'PENDING_APPROVAL', 'CLOSED', 'PAID', 'PAYMENT_FAILED', 'DELINQUENT'));
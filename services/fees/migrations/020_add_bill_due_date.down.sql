@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS bills_due_date_idx;
+UPDATE bills SET status = 'CLOSED' WHERE status = 'OVERDUE';
+ALTER TABLE bills DROP CONSTRAINT IF EXISTS bills_status_check;
+ALTER TABLE bills ADD CONSTRAINT bills_status_check
+    CHECK (status IN ('OPEN', 'CLOSING', 'PENDING_APPROVAL', 'CLOSE_FAILED', 'CLOSED', 'PAID', 'PAYMENT_FAILED', 'DELINQUENT'));
+ALTER TABLE bills DROP COLUMN due_date;
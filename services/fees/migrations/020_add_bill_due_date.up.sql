@@ -0,0 +1,6 @@
+-- When payment of a closed bill is due. Bills still unpaid after it become OVERDUE.
+ALTER TABLE bills ADD COLUMN due_date TIMESTAMPTZ;
+ALTER TABLE bills DROP CONSTRAINT IF EXISTS bills_status_check;
+ALTER TABLE bills ADD CONSTRAINT bills_status_check
+    CHECK (status IN ('OPEN', 'CLOSING', 'PENDING_APPROVAL', 'CLOSE_FAILED', 'CLOSED', 'PAID', 'PAYMENT_FAILED', 'OVERDUE', 'DELINQUENT'));
+CREATE INDEX bills_due_date_idx ON bills (due_date) WHERE status IN ('CLOSED', 'PAYMENT_FAILED', 'OVERDUE');
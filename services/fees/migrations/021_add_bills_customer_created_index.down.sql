@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_bills_customer_created;
@@ -0,0 +1,2 @@
+-- Serves customer statements, which read a customer's bills created in a period.
+CREATE INDEX idx_bills_customer_created ON bills (customer_id, created_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS report_refreshes;
+DROP MATERIALIZED VIEW IF EXISTS revenue_daily;
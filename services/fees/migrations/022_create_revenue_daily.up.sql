@@ -0,0 +1,25 @@
+-- Line item totals of closed bills per UTC day of closing, tenant, currency, and line
+-- item category. The revenue report reads it and refreshes it when it gets stale.
+CREATE MATERIALIZED VIEW revenue_daily AS
+SELECT date_trunc('day', b.closed_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS day,
+       b.tenant_id,
+       b.currency,
+       CASE
+           WHEN li.credit_note_id IS NOT NULL THEN 'credit'
+           WHEN li.id = b.adjustment->>'lineItemId' THEN 'adjustment'
+           WHEN li.routed_from_bill_id IS NOT NULL THEN 'routed'
+           ELSE 'charge'
+       END AS category,
+       SUM(li.amount) AS amount
+FROM bills b
+JOIN line_items li ON li.bill_id = b.id
+WHERE b.closed_at IS NOT NULL
+GROUP BY 1, 2, 3, 4;
+
+-- Unique so the view can be refreshed concurrently with reads.
+CREATE UNIQUE INDEX idx_revenue_daily ON revenue_daily (day, tenant_id, currency, category);
+
+CREATE TABLE report_refreshes (
+    report TEXT PRIMARY KEY,
+    refreshed_at TIMESTAMPTZ NOT NULL
+);
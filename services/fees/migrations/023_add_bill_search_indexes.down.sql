@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_line_items_description_trgm;
+DROP INDEX IF EXISTS idx_bills_customer_id_trgm;
+DROP INDEX IF EXISTS idx_bills_id_trgm;
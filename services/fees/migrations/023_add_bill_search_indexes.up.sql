@@ -0,0 +1,5 @@
+-- Trigram indexes for searching bills by ID, customer ID, and line item description.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX idx_bills_id_trgm ON bills USING gin (id gin_trgm_ops);
+CREATE INDEX idx_bills_customer_id_trgm ON bills USING gin (customer_id gin_trgm_ops);
+CREATE INDEX idx_line_items_description_trgm ON line_items USING gin (description gin_trgm_ops);
@@ -0,0 +1,14 @@
+-- Documents attached to bills. Their contents live in the bill-attachments bucket.
+CREATE TABLE bill_attachments (
+    id TEXT PRIMARY KEY,
+    bill_id TEXT NOT NULL REFERENCES bills(id) ON DELETE CASCADE,
+    file_name TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    sha256 TEXT NOT NULL,
+    description TEXT,
+    uploaded_by_key_id TEXT,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_bill_attachments_bill_id ON bill_attachments (bill_id, created_at);
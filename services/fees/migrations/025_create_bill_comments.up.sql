@@ -0,0 +1,11 @@
+-- Notes left on bills by operations and support staff.
+CREATE TABLE bill_comments (
+    id TEXT PRIMARY KEY,
+    bill_id TEXT NOT NULL REFERENCES bills(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    author TEXT,
+    author_key_id TEXT,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_bill_comments_bill_id ON bill_comments (bill_id, created_at);
@@ -0,0 +1,3 @@
+-- How the bill's line items and totals are rounded: {"mode": ..., "decimals": ...}.
+-- NULL for bills created before rounding policies, which keep four decimals.
+ALTER TABLE bills ADD COLUMN rounding JSONB;
@@ -0,0 +1,3 @@
+ALTER TABLE bills DROP COLUMN IF EXISTS applied_credit;
+DROP TABLE IF EXISTS customer_credit_entries;
+DROP TABLE IF EXISTS customer_credit_balances;
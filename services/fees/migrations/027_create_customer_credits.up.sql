@@ -0,0 +1,27 @@
+-- Credit available to customers, applied to their bills as they close.
+CREATE TABLE customer_credit_balances (
+    tenant_id TEXT NOT NULL,
+    customer_id TEXT NOT NULL,
+    currency TEXT NOT NULL,
+    balance NUMERIC(16, 4) NOT NULL CHECK (balance >= 0),
+    updated_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (tenant_id, customer_id, currency)
+);
+
+-- Every change to a credit balance: grants are positive, credit applied to a bill negative.
+CREATE TABLE customer_credit_entries (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    customer_id TEXT NOT NULL,
+    currency TEXT NOT NULL,
+    amount NUMERIC(16, 4) NOT NULL,
+    bill_id TEXT REFERENCES bills(id) ON DELETE SET NULL,
+    description TEXT,
+    created_by_key_id TEXT,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_customer_credit_entries_customer ON customer_credit_entries (customer_id, created_at DESC);
+
+-- The credit applied to the bill on close: {"amount": ..., "lineItemId": ...}.
+ALTER TABLE bills ADD COLUMN applied_credit JSONB;
@@ -0,0 +1,5 @@
+ALTER TABLE payments DROP COLUMN method;
+UPDATE bills SET status = 'CLOSED' WHERE status = 'PARTIALLY_PAID';
+ALTER TABLE bills DROP CONSTRAINT IF EXISTS bills_status_check;
+ALTER TABLE bills ADD CONSTRAINT bills_status_check
+    CHECK (status IN ('OPEN', 'CLOSING', 'PENDING_APPROVAL', 'CLOSE_FAILED', 'CLOSED', 'PAID', 'PAYMENT_FAILED', 'OVERDUE', 'DELINQUENT'));
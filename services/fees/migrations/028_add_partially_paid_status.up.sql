@@ -0,0 +1,6 @@
+-- Bills with a balance left after recorded partial payments are PARTIALLY_PAID.
+ALTER TABLE bills DROP CONSTRAINT IF EXISTS bills_status_check;
+ALTER TABLE bills ADD CONSTRAINT bills_status_check
+    CHECK (status IN ('OPEN', 'CLOSING', 'PENDING_APPROVAL', 'CLOSE_FAILED', 'CLOSED', 'PARTIALLY_PAID', 'PAID', 'PAYMENT_FAILED', 'OVERDUE', 'DELINQUENT'));
+-- How a payment recorded outside the payment gateway was received, such as 'bank_transfer'.
+ALTER TABLE payments ADD COLUMN method TEXT;
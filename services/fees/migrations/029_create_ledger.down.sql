@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ledger_postings;
+DROP TABLE IF EXISTS ledger_journal_entries;
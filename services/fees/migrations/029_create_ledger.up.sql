@@ -0,0 +1,22 @@
+-- Double-entry journal of bill lifecycle events. The debits and credits of every entry balance.
+CREATE TABLE ledger_journal_entries (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    bill_id TEXT NOT NULL REFERENCES bills(id),
+    customer_id TEXT,
+    currency TEXT NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('bill.closed', 'payment.received')),
+    posted_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_ledger_journal_entries_bill ON ledger_journal_entries (bill_id);
+
+CREATE TABLE ledger_postings (
+    journal_id TEXT NOT NULL REFERENCES ledger_journal_entries(id) ON DELETE CASCADE,
+    account TEXT NOT NULL,
+    side TEXT NOT NULL CHECK (side IN ('debit', 'credit')),
+    amount NUMERIC(16, 4) NOT NULL CHECK (amount > 0),
+    PRIMARY KEY (journal_id, account, side)
+);
+
+CREATE INDEX idx_ledger_postings_account ON ledger_postings (account);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_bills_open_created_at;
+DROP INDEX IF EXISTS idx_bills_open_period_end;
+ALTER TABLE bills DROP COLUMN period_end;
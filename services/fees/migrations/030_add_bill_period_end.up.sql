@@ -0,0 +1,4 @@
+-- When a bill's billing period ends. The close sweep closes bills still open after it.
+ALTER TABLE bills ADD COLUMN period_end TIMESTAMPTZ;
+CREATE INDEX idx_bills_open_period_end ON bills (period_end) WHERE status = 'OPEN';
+CREATE INDEX idx_bills_open_created_at ON bills (created_at) WHERE status = 'OPEN';
@@ -0,0 +1,18 @@
+-- Long-running operations, such as batch closes, each run by a JobWorkflow.
+CREATE TABLE jobs (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL DEFAULT '',
+    kind TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('QUEUED', 'RUNNING', 'SUCCEEDED', 'FAILED', 'CANCELED')),
+    params JSONB NOT NULL,
+    progress JSONB,
+    result JSONB,
+    error TEXT,
+    created_by_key_id TEXT,
+    created_at TIMESTAMPTZ NOT NULL,
+    started_at TIMESTAMPTZ,
+    cancel_requested_at TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_jobs_tenant_created ON jobs (tenant_id, created_at DESC);
@@ -0,0 +1,10 @@
+-- Per-customer spending alerts, copied onto bills in that currency when they are
+-- created. Thresholds are percentages of the budget.
+CREATE TABLE customer_spending_alerts (
+    customer_id TEXT NOT NULL,
+    currency TEXT NOT NULL,
+    budget NUMERIC(16, 4) NOT NULL CHECK (budget > 0),
+    thresholds DOUBLE PRECISION[] NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (customer_id, currency)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE line_items DROP COLUMN IF EXISTS over_hard_cap;
+ALTER TABLE customer_bill_limits DROP COLUMN IF EXISTS over_hard_cap, DROP COLUMN IF EXISTS hard_cap;
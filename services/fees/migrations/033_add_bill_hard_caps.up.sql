@@ -0,0 +1,7 @@
+-- A hard cap on bill totals, enforced as line items arrive. 0 is unset.
+ALTER TABLE customer_bill_limits
+    ADD COLUMN hard_cap NUMERIC(16, 4) NOT NULL DEFAULT 0 CHECK (hard_cap >= 0),
+    ADD COLUMN over_hard_cap TEXT NOT NULL DEFAULT 'reject' CHECK (over_hard_cap IN ('reject', 'flag'));
+
+-- Line items accepted above their bill's hard cap under the flag action.
+ALTER TABLE line_items ADD COLUMN over_hard_cap BOOLEAN NOT NULL DEFAULT FALSE;
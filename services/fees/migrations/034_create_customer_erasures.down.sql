@@ -0,0 +1,13 @@
+CREATE OR REPLACE FUNCTION reject_bill_events_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'bill_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION reject_bill_audit_log_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'bill_audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TABLE IF EXISTS customer_erasures;
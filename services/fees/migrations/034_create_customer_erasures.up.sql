@@ -0,0 +1,37 @@
+-- Certificates of customer data erasures. They name the customer only by a hash, so
+-- keeping them does not undo the erasure.
+CREATE TABLE customer_erasures (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    -- Hex SHA-256 of '<tenant>/<customer>'.
+    subject_hash TEXT NOT NULL,
+    mode TEXT NOT NULL CHECK (mode IN ('anonymize', 'delete')),
+    bills INT NOT NULL,
+    line_items INT NOT NULL,
+    workflows INT NOT NULL,
+    requested_by_key_id TEXT,
+    started_at TIMESTAMPTZ NOT NULL,
+    completed_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_customer_erasures_subject ON customer_erasures (tenant_id, subject_hash);
+
+-- The audit log and event log stay append-only, except to transactions erasing
+-- customer data, which set fees.erasure.
+CREATE OR REPLACE FUNCTION reject_bill_audit_log_change() RETURNS trigger AS $$
+BEGIN
+    IF current_setting('fees.erasure', true) = 'on' THEN
+        RETURN COALESCE(NEW, OLD);
+    END IF;
+    RAISE EXCEPTION 'bill_audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION reject_bill_events_change() RETURNS trigger AS $$
+BEGIN
+    IF current_setting('fees.erasure', true) = 'on' THEN
+        RETURN COALESCE(NEW, OLD);
+    END IF;
+    RAISE EXCEPTION 'bill_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
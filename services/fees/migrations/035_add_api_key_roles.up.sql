@@ -0,0 +1,2 @@
+-- Roles granted to API keys, each a named set of scopes, on top of the key's own scopes.
+ALTER TABLE api_keys ADD COLUMN roles TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,9 @@
+DELETE FROM ledger_journal_entries WHERE kind = 'payment.reversed';
+ALTER TABLE ledger_journal_entries DROP CONSTRAINT IF EXISTS ledger_journal_entries_kind_check;
+ALTER TABLE ledger_journal_entries ADD CONSTRAINT ledger_journal_entries_kind_check
+    CHECK (kind IN ('bill.closed', 'payment.received'));
+UPDATE payments SET status = 'SUCCEEDED' WHERE status = 'DISPUTED';
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check
+    CHECK (status IN ('PENDING', 'SUCCEEDED', 'DECLINED', 'FAILED'));
+DROP TABLE IF EXISTS payment_webhook_events;
@@ -0,0 +1,28 @@
+-- Payment-provider callbacks, recorded once per provider event ID so redelivered
+-- callbacks are not applied twice.
+CREATE TABLE payment_webhook_events (
+    provider TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    type TEXT NOT NULL,
+    payment_id TEXT NOT NULL,
+    -- The bill the payment belongs to, once known.
+    bill_id TEXT,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- Set once the event was delivered to the bill's workflow.
+    processed_at TIMESTAMPTZ,
+    -- Why the event could not be applied, if it could not.
+    error TEXT,
+    PRIMARY KEY (provider, event_id)
+);
+
+CREATE INDEX idx_payment_webhook_events_payment ON payment_webhook_events (payment_id);
+
+-- A charged-back payment is DISPUTED.
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check
+    CHECK (status IN ('PENDING', 'SUCCEEDED', 'DECLINED', 'FAILED', 'DISPUTED'));
+
+-- A payment the provider reports as failed after it succeeded is reversed in the ledger.
+ALTER TABLE ledger_journal_entries DROP CONSTRAINT IF EXISTS ledger_journal_entries_kind_check;
+ALTER TABLE ledger_journal_entries ADD CONSTRAINT ledger_journal_entries_kind_check
+    CHECK (kind IN ('bill.closed', 'payment.received', 'payment.reversed'));
@@ -0,0 +1,22 @@
+-- Chargebacks of bill payments, tracked by a DisputeWorkflow each.
+CREATE TABLE disputes (
+    id TEXT PRIMARY KEY,
+    bill_id TEXT NOT NULL REFERENCES bills(id) ON DELETE CASCADE,
+    tenant_id TEXT NOT NULL,
+    customer_id TEXT,
+    payment_id TEXT NOT NULL,
+    amount NUMERIC(16, 4) NOT NULL,
+    currency TEXT NOT NULL,
+    reason TEXT,
+    status TEXT NOT NULL CHECK (status IN ('NEEDS_EVIDENCE', 'EVIDENCE_SUBMITTED', 'EVIDENCE_OVERDUE', 'WON', 'LOST')),
+    evidence_due_by TIMESTAMPTZ NOT NULL,
+    -- {"text": ..., "attachmentIds": [...], "submittedAt": ..., "submittedByKeyId": ...}
+    evidence JSONB,
+    opened_at TIMESTAMPTZ NOT NULL,
+    resolved_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_disputes_bill_id ON disputes (bill_id, opened_at);
+-- Open disputes freeze their customer's credit.
+CREATE INDEX idx_disputes_open_customer ON disputes (tenant_id, customer_id, currency)
+    WHERE status IN ('NEEDS_EVIDENCE', 'EVIDENCE_SUBMITTED', 'EVIDENCE_OVERDUE');
@@ -0,0 +1,3 @@
+ALTER TABLE line_items DROP COLUMN IF EXISTS unit;
+ALTER TABLE line_items DROP COLUMN IF EXISTS unit_price;
+ALTER TABLE line_items DROP COLUMN IF EXISTS quantity;
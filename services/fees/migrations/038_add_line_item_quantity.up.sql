@@ -0,0 +1,4 @@
+-- Line items priced per unit, such as 12.5 GB at 0.08. Their amount is the product.
+ALTER TABLE line_items ADD COLUMN quantity NUMERIC(20, 6);
+ALTER TABLE line_items ADD COLUMN unit_price NUMERIC(20, 6);
+ALTER TABLE line_items ADD COLUMN unit TEXT;
@@ -0,0 +1,2 @@
+-- Keyset pagination of GET /bills/stream, which pages through bills by (created_at, id).
+CREATE INDEX idx_bills_created_id ON bills (created_at, id);
@@ -0,0 +1,33 @@
+-- One row per bill with what lists and dashboards show, kept up to date by the
+-- activities that change bills, so reading it needs no workflow queries or joins.
+CREATE TABLE bill_summaries (
+    bill_id TEXT PRIMARY KEY REFERENCES bills(id) ON DELETE CASCADE,
+    tenant_id TEXT NOT NULL,
+    customer_id TEXT,
+    currency TEXT NOT NULL,
+    status TEXT NOT NULL,
+    -- The sum of the bill's line items while it is open, and its total once it closed.
+    total_amount NUMERIC(16, 4) NOT NULL,
+    -- Line items of the bill, not counting those of its credit notes.
+    item_count INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    closed_at TIMESTAMPTZ,
+    due_date TIMESTAMPTZ,
+    version BIGINT NOT NULL,
+    -- When the bill last changed: its latest audit log entry.
+    last_activity_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_bill_summaries_tenant_activity ON bill_summaries (tenant_id, last_activity_at DESC, bill_id);
+CREATE INDEX idx_bill_summaries_activity ON bill_summaries (last_activity_at DESC, bill_id);
+CREATE INDEX idx_bill_summaries_customer ON bill_summaries (customer_id, last_activity_at DESC);
+
+INSERT INTO bill_summaries (bill_id, tenant_id, customer_id, currency, status, total_amount, item_count, created_at, closed_at, due_date, version, last_activity_at)
+SELECT b.id, b.tenant_id, b.customer_id, b.currency, b.status,
+       CASE WHEN b.closed_at IS NULL THEN COALESCE(li.total, 0) ELSE b.total_amount END,
+       COALESCE(li.count, 0), b.created_at, b.closed_at, b.due_date, b.version,
+       COALESCE((SELECT a.occurred_at FROM bill_audit_log a WHERE a.bill_id = b.id ORDER BY a.id DESC LIMIT 1), b.created_at)
+FROM bills b
+LEFT JOIN LATERAL (
+    SELECT SUM(amount) AS total, COUNT(*) AS count FROM line_items WHERE bill_id = b.id AND credit_note_id IS NULL
+) li ON true;
@@ -0,0 +1,3 @@
+ALTER TABLE line_items DROP COLUMN IF EXISTS sub_bill_id;
+DROP INDEX IF EXISTS idx_bills_parent;
+ALTER TABLE bills DROP COLUMN IF EXISTS parent_bill_id;
@@ -0,0 +1,7 @@
+-- Hierarchical bills: a sub-bill names its parent, and its total is rolled up to the
+-- parent as a line item naming the sub-bill when it closes. There is no foreign key,
+-- so erasing a parent's customer does not require erasing its sub-bills.
+ALTER TABLE bills ADD COLUMN parent_bill_id TEXT;
+CREATE INDEX idx_bills_parent ON bills (parent_bill_id, created_at) WHERE parent_bill_id IS NOT NULL;
+
+ALTER TABLE line_items ADD COLUMN sub_bill_id TEXT;
@@ -0,0 +1,14 @@
+-- Shares of a split bill's total issued to each of its payers when it closes.
+CREATE TABLE payer_shares (
+    id TEXT PRIMARY KEY,
+    bill_id TEXT NOT NULL REFERENCES bills(id) ON DELETE CASCADE,
+    payer_id TEXT NOT NULL,
+    amount NUMERIC(16, 4) NOT NULL,
+    -- Set on shares of a percentage split.
+    percent NUMERIC(7, 4),
+    currency TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    UNIQUE (bill_id, payer_id)
+);
+
+CREATE INDEX idx_payer_shares_payer ON payer_shares (payer_id, created_at);
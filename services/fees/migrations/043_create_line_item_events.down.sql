@@ -0,0 +1,2 @@
+ALTER TABLE line_items DROP COLUMN IF EXISTS aggregate;
+DROP TABLE IF EXISTS line_item_events;
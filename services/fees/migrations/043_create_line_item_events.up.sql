@@ -0,0 +1,22 @@
+-- Items a bill aggregated, as they were sent, kept for audit. The bill's line items
+-- hold one item per category and interval, whose aggregate describes it.
+CREATE TABLE line_item_events (
+    id TEXT PRIMARY KEY,
+    bill_id TEXT NOT NULL REFERENCES bills(id),
+    line_item_id TEXT NOT NULL,
+    category TEXT NOT NULL,
+    description TEXT NOT NULL,
+    amount NUMERIC(20, 10) NOT NULL,
+    client_reference TEXT,
+    created_by_key_id TEXT,
+    -- The bill version the event was applied at, so a retried save can tell itself
+    -- apart from a duplicate.
+    bill_version BIGINT NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_line_item_events_line_item ON line_item_events (bill_id, line_item_id, received_at, id);
+CREATE UNIQUE INDEX idx_line_item_events_client_reference ON line_item_events (bill_id, client_reference)
+    WHERE client_reference IS NOT NULL;
+
+ALTER TABLE line_items ADD COLUMN aggregate JSONB;
@@ -0,0 +1,15 @@
+-- Bill events waiting to be published. Rows are written in the transaction that records
+-- the event, and the relay marks them published once every publisher accepted them.
+CREATE TABLE event_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    event_id TEXT NOT NULL UNIQUE,
+    bill_id TEXT NOT NULL REFERENCES bills(id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    published_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_event_outbox_pending ON event_outbox (bill_id, id) WHERE published_at IS NULL;
+CREATE INDEX idx_event_outbox_published_at ON event_outbox (published_at) WHERE published_at IS NOT NULL;
@@ -0,0 +1,4 @@
+CREATE INDEX IF NOT EXISTS idx_line_items_bill_id ON line_items (bill_id);
+DROP INDEX IF EXISTS idx_line_items_bill_created;
+CREATE INDEX IF NOT EXISTS idx_bills_customer_id ON bills (customer_id);
+DROP INDEX IF EXISTS idx_bills_customer_status_created;
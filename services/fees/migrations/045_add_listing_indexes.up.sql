@@ -0,0 +1,8 @@
+-- Serves listing a customer's bills by status, newest first. It also covers lookups by
+-- customer alone, which idx_bills_customer_id served.
+CREATE INDEX idx_bills_customer_status_created ON bills (customer_id, status, created_at);
+DROP INDEX IF EXISTS idx_bills_customer_id;
+-- Reads a bill's line items in the order they were added, without sorting them. It
+-- also covers lookups by bill alone, which idx_line_items_bill_id served.
+CREATE INDEX idx_line_items_bill_created ON line_items (bill_id, created_at, id);
+DROP INDEX IF EXISTS idx_line_items_bill_id;
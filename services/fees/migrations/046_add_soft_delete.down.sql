@@ -0,0 +1,22 @@
+DROP MATERIALIZED VIEW revenue_daily;
+CREATE MATERIALIZED VIEW revenue_daily AS
+SELECT date_trunc('day', b.closed_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS day,
+       b.tenant_id,
+       b.currency,
+       CASE
+           WHEN li.credit_note_id IS NOT NULL THEN 'credit'
+           WHEN li.id = b.adjustment->>'lineItemId' THEN 'adjustment'
+           WHEN li.routed_from_bill_id IS NOT NULL THEN 'routed'
+           ELSE 'charge'
+       END AS category,
+       SUM(li.amount) AS amount
+FROM bills b
+JOIN line_items li ON li.bill_id = b.id
+WHERE b.closed_at IS NOT NULL
+GROUP BY 1, 2, 3, 4;
+CREATE UNIQUE INDEX idx_revenue_daily ON revenue_daily (day, tenant_id, currency, category);
+
+ALTER TABLE bill_summaries DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE line_items DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE bills DROP COLUMN IF EXISTS deleted_by_key_id;
+ALTER TABLE bills DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,4 @@
+DROP INDEX idx_bills_number_trgm;
+ALTER TABLE bill_summaries DROP COLUMN number;
+ALTER TABLE bills DROP COLUMN number;
+DROP TABLE bill_number_sequences;
@@ -0,0 +1,15 @@
+-- Closed bills get a number from their customer's sequence for the year they closed,
+-- such as CUST123-2024-00042. A sequence's last_value is the last number it gave out.
+CREATE TABLE bill_number_sequences (
+    tenant_id TEXT NOT NULL,
+    customer_id TEXT NOT NULL,
+    year INT NOT NULL,
+    last_value BIGINT NOT NULL,
+    PRIMARY KEY (tenant_id, customer_id, year)
+);
+
+ALTER TABLE bills ADD COLUMN number TEXT;
+ALTER TABLE bill_summaries ADD COLUMN number TEXT;
+
+-- Bill numbers are searched like bill and customer IDs.
+CREATE INDEX idx_bills_number_trgm ON bills USING gin (number gin_trgm_ops);
@@ -0,0 +1,12 @@
+-- How a tenant's bills are rendered as HTML. Tenants without a row get the default
+-- template, footer, and accent color.
+CREATE TABLE bill_html_settings (
+    tenant_id TEXT PRIMARY KEY,
+    -- A Go html/template replacing the default template, or NULL to keep it.
+    template TEXT,
+    footer TEXT NOT NULL DEFAULT '',
+    accent_color TEXT,
+    -- Origins allowed to embed the page in a frame; empty allows any.
+    frame_ancestors TEXT[] NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMPTZ NOT NULL
+);
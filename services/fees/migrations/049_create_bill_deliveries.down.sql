@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS bill_deliveries;
+DROP TABLE IF EXISTS customer_contacts;
@@ -0,0 +1,29 @@
+-- Where customers get their bills. Closed bills are emailed to the contact's address,
+-- copying the cc addresses, formatted for its locale.
+CREATE TABLE customer_contacts (
+    customer_id TEXT PRIMARY KEY,
+    email TEXT NOT NULL,
+    cc TEXT[] NOT NULL DEFAULT '{}',
+    locale TEXT NOT NULL DEFAULT '',
+    attach_pdf BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+-- Every email of a bill to its customer, on close or resent, and how sending it went.
+CREATE TABLE bill_deliveries (
+    id TEXT PRIMARY KEY,
+    bill_id TEXT NOT NULL REFERENCES bills(id) ON DELETE CASCADE,
+    customer_id TEXT NOT NULL,
+    trigger TEXT NOT NULL,
+    recipients TEXT[] NOT NULL,
+    provider TEXT NOT NULL,
+    status TEXT NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    provider_message_id TEXT,
+    error TEXT,
+    requested_by_key_id TEXT,
+    created_at TIMESTAMPTZ NOT NULL,
+    sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_bill_deliveries_bill_id ON bill_deliveries (bill_id, created_at);
@@ -0,0 +1,18 @@
+-- Where settled bills were archived in object storage. The rows outlive the bills, so
+-- an archived bill can still be read after the retention sweep deleted it; they have
+-- no foreign key to bills.
+CREATE TABLE bill_archives (
+    bill_id TEXT PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    customer_id TEXT NOT NULL DEFAULT '',
+    object TEXT NOT NULL,
+    -- event_sequence is the last event of the bill the archive holds; a bill with later
+    -- events, such as a refund, is archived again.
+    event_sequence BIGINT NOT NULL,
+    key_id TEXT NOT NULL,
+    signature TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    archived_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_bill_archives_customer ON bill_archives (tenant_id, customer_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_bill_summaries_customer_balance;
+ALTER TABLE bill_summaries DROP COLUMN IF EXISTS amount_paid;
@@ -0,0 +1,9 @@
+-- What has been paid of each bill, so customer balances can be read from the
+-- projection alone.
+ALTER TABLE bill_summaries ADD COLUMN amount_paid NUMERIC(16, 4) NOT NULL DEFAULT 0;
+
+UPDATE bill_summaries s SET amount_paid = p.total
+FROM (SELECT bill_id, SUM(amount) AS total FROM payments WHERE status = 'SUCCEEDED' GROUP BY bill_id) p
+WHERE p.bill_id = s.bill_id;
+
+CREATE INDEX idx_bill_summaries_customer_balance ON bill_summaries (customer_id, tenant_id, currency) WHERE deleted_at IS NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS accrual_snapshot_runs;
+DROP TABLE IF EXISTS accrual_snapshots;
@@ -0,0 +1,26 @@
+-- What each bill open at the end of a month had accrued by then, for the month-end
+-- close. Snapshots are financial records, so they have no foreign key to bills and
+-- outlive the retention sweep.
+CREATE TABLE accrual_snapshots (
+    -- The month, as YYYY-MM, whose end the snapshot was taken at.
+    period TEXT NOT NULL,
+    period_end TIMESTAMPTZ NOT NULL,
+    bill_id TEXT NOT NULL,
+    tenant_id TEXT NOT NULL,
+    customer_id TEXT NOT NULL DEFAULT '',
+    currency TEXT NOT NULL,
+    accrued_amount NUMERIC(16, 4) NOT NULL,
+    item_count INT NOT NULL,
+    taken_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (period, bill_id)
+);
+
+CREATE INDEX idx_accrual_snapshots_customer ON accrual_snapshots (period, tenant_id, customer_id);
+
+-- Periods whose snapshot completed, and how many bills it recorded.
+CREATE TABLE accrual_snapshot_runs (
+    period TEXT PRIMARY KEY,
+    period_end TIMESTAMPTZ NOT NULL,
+    bills INT NOT NULL,
+    completed_at TIMESTAMPTZ NOT NULL
+);
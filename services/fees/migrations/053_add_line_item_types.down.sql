@@ -0,0 +1,21 @@
+DROP MATERIALIZED VIEW revenue_daily;
+CREATE MATERIALIZED VIEW revenue_daily AS
+SELECT date_trunc('day', b.closed_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS day,
+       b.tenant_id,
+       b.currency,
+       CASE
+           WHEN li.credit_note_id IS NOT NULL THEN 'credit'
+           WHEN li.id = b.adjustment->>'lineItemId' THEN 'adjustment'
+           WHEN li.routed_from_bill_id IS NOT NULL THEN 'routed'
+           ELSE 'charge'
+       END AS category,
+       b.deleted_at IS NOT NULL AS deleted,
+       SUM(li.amount) AS amount
+FROM bills b
+JOIN line_items li ON li.bill_id = b.id
+WHERE b.closed_at IS NOT NULL
+GROUP BY 1, 2, 3, 4, 5;
+CREATE UNIQUE INDEX idx_revenue_daily ON revenue_daily (day, tenant_id, currency, category, deleted);
+
+ALTER TABLE line_items DROP COLUMN IF EXISTS reason_code;
+ALTER TABLE line_items DROP COLUMN IF EXISTS type;
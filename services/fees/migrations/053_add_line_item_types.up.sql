@@ -0,0 +1,32 @@
+-- Line items say whether they charge, credit or adjust the bill, and credits and
+-- adjustments added through the API say why.
+ALTER TABLE line_items ADD COLUMN type TEXT NOT NULL DEFAULT 'CHARGE'
+    CHECK (type IN ('CHARGE', 'CREDIT', 'ADJUSTMENT'));
+ALTER TABLE line_items ADD COLUMN reason_code TEXT;
+
+UPDATE line_items SET type = 'CREDIT' WHERE credit_note_id IS NOT NULL;
+UPDATE line_items li SET type = 'CREDIT' FROM bills b
+WHERE li.bill_id = b.id AND li.id = b.applied_credit->>'lineItemId';
+UPDATE line_items li SET type = 'ADJUSTMENT' FROM bills b
+WHERE li.bill_id = b.id AND li.id = b.adjustment->>'lineItemId';
+
+-- Revenue is categorized by the items' types.
+DROP MATERIALIZED VIEW revenue_daily;
+CREATE MATERIALIZED VIEW revenue_daily AS
+SELECT date_trunc('day', b.closed_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS day,
+       b.tenant_id,
+       b.currency,
+       CASE
+           WHEN li.type = 'CREDIT' THEN 'credit'
+           WHEN li.type = 'ADJUSTMENT' THEN 'adjustment'
+           WHEN li.routed_from_bill_id IS NOT NULL THEN 'routed'
+           ELSE 'charge'
+       END AS category,
+       b.deleted_at IS NOT NULL AS deleted,
+       SUM(li.amount) AS amount
+FROM bills b
+JOIN line_items li ON li.bill_id = b.id
+WHERE b.closed_at IS NOT NULL
+GROUP BY 1, 2, 3, 4, 5;
+
+CREATE UNIQUE INDEX idx_revenue_daily ON revenue_daily (day, tenant_id, currency, category, deleted);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_line_items_reason_code;
+ALTER TABLE line_items DROP COLUMN IF EXISTS reference_external;
+ALTER TABLE line_items DROP COLUMN IF EXISTS reference_line_item_id;
@@ -0,0 +1,13 @@
+-- Credits and adjustments link to what they correct: an item of the same bill, or a
+-- record outside the service such as a support ticket.
+ALTER TABLE line_items ADD COLUMN reference_line_item_id TEXT;
+ALTER TABLE line_items ADD COLUMN reference_external TEXT;
+
+-- The service's own credits and adjustments get their reserved reason codes.
+UPDATE line_items SET reason_code = 'refund' WHERE credit_note_id IS NOT NULL AND reason_code IS NULL;
+UPDATE line_items li SET reason_code = 'customer_credit' FROM bills b
+WHERE li.bill_id = b.id AND li.id = b.applied_credit->>'lineItemId' AND li.reason_code IS NULL;
+UPDATE line_items li SET reason_code = 'bill_limits' FROM bills b
+WHERE li.bill_id = b.id AND li.id = b.adjustment->>'lineItemId' AND li.reason_code IS NULL;
+
+CREATE INDEX idx_line_items_reason_code ON line_items (reason_code) WHERE reason_code IS NOT NULL;
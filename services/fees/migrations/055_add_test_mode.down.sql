@@ -0,0 +1,24 @@
+DROP MATERIALIZED VIEW revenue_daily;
+CREATE MATERIALIZED VIEW revenue_daily AS
+SELECT date_trunc('day', b.closed_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS day,
+       b.tenant_id,
+       b.currency,
+       CASE
+           WHEN li.type = 'CREDIT' THEN 'credit'
+           WHEN li.type = 'ADJUSTMENT' THEN 'adjustment'
+           WHEN li.routed_from_bill_id IS NOT NULL THEN 'routed'
+           ELSE 'charge'
+       END AS category,
+       b.deleted_at IS NOT NULL AS deleted,
+       SUM(li.amount) AS amount
+FROM bills b
+JOIN line_items li ON li.bill_id = b.id
+WHERE b.closed_at IS NOT NULL
+GROUP BY 1, 2, 3, 4, 5;
+CREATE UNIQUE INDEX idx_revenue_daily ON revenue_daily (day, tenant_id, currency, category, deleted);
+
+DROP INDEX IF EXISTS idx_bills_test;
+ALTER TABLE ledger_journal_entries DROP COLUMN IF EXISTS test;
+ALTER TABLE bill_summaries DROP COLUMN IF EXISTS test;
+ALTER TABLE bills DROP COLUMN IF EXISTS test;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS test;
@@ -0,0 +1,30 @@
+-- Test API keys create test bills, whose IDs start with "test_". Bills, their
+-- summaries and their journal entries say so, so reports can leave them out.
+ALTER TABLE api_keys ADD COLUMN test BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE bills ADD COLUMN test BOOLEAN GENERATED ALWAYS AS (id LIKE 'test\_%') STORED;
+ALTER TABLE bill_summaries ADD COLUMN test BOOLEAN GENERATED ALWAYS AS (bill_id LIKE 'test\_%') STORED;
+ALTER TABLE ledger_journal_entries ADD COLUMN test BOOLEAN GENERATED ALWAYS AS (bill_id LIKE 'test\_%') STORED;
+
+CREATE INDEX idx_bills_test ON bills (tenant_id, created_at) WHERE test;
+
+-- Revenue is split between live and test bills.
+DROP MATERIALIZED VIEW revenue_daily;
+CREATE MATERIALIZED VIEW revenue_daily AS
+SELECT date_trunc('day', b.closed_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS day,
+       b.tenant_id,
+       b.currency,
+       CASE
+           WHEN li.type = 'CREDIT' THEN 'credit'
+           WHEN li.type = 'ADJUSTMENT' THEN 'adjustment'
+           WHEN li.routed_from_bill_id IS NOT NULL THEN 'routed'
+           ELSE 'charge'
+       END AS category,
+       b.deleted_at IS NOT NULL AS deleted,
+       b.test,
+       SUM(li.amount) AS amount
+FROM bills b
+JOIN line_items li ON li.bill_id = b.id
+WHERE b.closed_at IS NOT NULL
+GROUP BY 1, 2, 3, 4, 5, 6;
+
+CREATE UNIQUE INDEX idx_revenue_daily ON revenue_daily (day, tenant_id, currency, category, deleted, test);
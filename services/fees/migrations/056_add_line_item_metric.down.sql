@@ -0,0 +1 @@
+ALTER TABLE line_items DROP COLUMN IF EXISTS metric;
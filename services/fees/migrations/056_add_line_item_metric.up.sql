@@ -0,0 +1,3 @@
+-- Line items rating usage of a metric the bill prices in tiers record the metric, so
+-- the usage a bill has rated can be summed from its items.
+ALTER TABLE line_items ADD COLUMN metric TEXT;
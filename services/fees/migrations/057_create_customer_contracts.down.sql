@@ -0,0 +1,2 @@
+ALTER TABLE line_items DROP COLUMN IF EXISTS drawdown;
+DROP TABLE IF EXISTS customer_contracts;
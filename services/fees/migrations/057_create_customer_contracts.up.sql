@@ -0,0 +1,13 @@
+-- Per-customer committed-use contracts: each bill in the currency bills the committed
+-- amount up front, and its usage draws the commitment down.
+CREATE TABLE customer_contracts (
+    customer_id TEXT NOT NULL,
+    currency TEXT NOT NULL,
+    committed_amount NUMERIC(16, 4) NOT NULL CHECK (committed_amount > 0),
+    description TEXT,
+    updated_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (customer_id, currency)
+);
+
+-- The part of a line item's usage its bill's commitment covered; amount is the overage.
+ALTER TABLE line_items ADD COLUMN drawdown NUMERIC(16, 4);
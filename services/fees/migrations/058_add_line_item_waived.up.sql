@@ -0,0 +1,3 @@
+-- Zero-amount line items record what a bill's free tiers and trials waived, so
+-- customers can see what they would have paid.
+ALTER TABLE line_items ADD COLUMN waived NUMERIC(16,4);
@@ -0,0 +1,28 @@
+-- Discount coupons, applied to bills by code and taken off their total on close.
+CREATE TABLE coupons (
+    code TEXT PRIMARY KEY,
+    name TEXT NOT NULL DEFAULT '',
+    -- 'percentage' takes percent_off of the total; 'fixed' takes amount_off in currency.
+    kind TEXT NOT NULL CHECK (kind IN ('percentage', 'fixed')),
+    percent_off NUMERIC(7, 4) NOT NULL DEFAULT 0,
+    amount_off NUMERIC(16, 4) NOT NULL DEFAULT 0,
+    currency TEXT NOT NULL DEFAULT '',
+    -- 0 allows any number of redemptions.
+    max_redemptions INTEGER NOT NULL DEFAULT 0,
+    redemptions INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ,
+    -- Empty allows any customer.
+    customer_ids TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+-- One row per bill a coupon was applied to, so a retried apply redeems it once.
+CREATE TABLE coupon_redemptions (
+    coupon_code TEXT NOT NULL REFERENCES coupons (code) ON DELETE CASCADE,
+    bill_id TEXT NOT NULL,
+    customer_id TEXT NOT NULL DEFAULT '',
+    redeemed_at TIMESTAMPTZ NOT NULL,
+    redeemed_by_key_id TEXT,
+    PRIMARY KEY (coupon_code, bill_id)
+);
@@ -0,0 +1,12 @@
+-- Only the default tenant's settings fit the keys without a tenant.
+DELETE FROM customer_contacts WHERE tenant_id <> 'default';
+ALTER TABLE customer_contacts DROP CONSTRAINT customer_contacts_pkey, ADD PRIMARY KEY (customer_id);
+ALTER TABLE customer_contacts DROP COLUMN IF EXISTS tenant_id;
+
+DELETE FROM customer_spending_alerts WHERE tenant_id <> 'default';
+ALTER TABLE customer_spending_alerts DROP CONSTRAINT customer_spending_alerts_pkey, ADD PRIMARY KEY (customer_id, currency);
+ALTER TABLE customer_spending_alerts DROP COLUMN IF EXISTS tenant_id;
+
+DELETE FROM customer_bill_limits WHERE tenant_id <> 'default';
+ALTER TABLE customer_bill_limits DROP CONSTRAINT customer_bill_limits_pkey, ADD PRIMARY KEY (customer_id, currency);
+ALTER TABLE customer_bill_limits DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,13 @@
+-- Customers belong to a tenant, and so do their bill limits, spending alerts and
+-- contact. Settings saved before belong to the default tenant.
+ALTER TABLE customer_bill_limits ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE customer_bill_limits DROP CONSTRAINT customer_bill_limits_pkey,
+    ADD PRIMARY KEY (tenant_id, customer_id, currency);
+
+ALTER TABLE customer_spending_alerts ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE customer_spending_alerts DROP CONSTRAINT customer_spending_alerts_pkey,
+    ADD PRIMARY KEY (tenant_id, customer_id, currency);
+
+ALTER TABLE customer_contacts ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE customer_contacts DROP CONSTRAINT customer_contacts_pkey,
+    ADD PRIMARY KEY (tenant_id, customer_id);
@@ -0,0 +1,4 @@
+-- Only the default tenant's contracts fit the key without a tenant.
+DELETE FROM customer_contracts WHERE tenant_id <> 'default';
+ALTER TABLE customer_contracts DROP CONSTRAINT customer_contracts_pkey, ADD PRIMARY KEY (customer_id, currency);
+ALTER TABLE customer_contracts DROP COLUMN IF EXISTS tenant_id;
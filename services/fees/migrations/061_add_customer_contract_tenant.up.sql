@@ -0,0 +1,5 @@
+-- Contracts belong to the customer's tenant, like their bill limits. Contracts saved
+-- before belong to the default tenant.
+ALTER TABLE customer_contracts ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE customer_contracts DROP CONSTRAINT customer_contracts_pkey,
+    ADD PRIMARY KEY (tenant_id, customer_id, currency);
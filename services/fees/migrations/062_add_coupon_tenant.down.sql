@@ -0,0 +1,13 @@
+-- Only the default tenant's coupons fit the keys without a tenant.
+DROP INDEX IF EXISTS idx_coupon_redemptions_customer;
+ALTER TABLE coupon_redemptions DROP CONSTRAINT coupon_redemptions_tenant_id_coupon_code_fkey;
+DELETE FROM coupon_redemptions WHERE tenant_id <> 'default';
+DELETE FROM coupons WHERE tenant_id <> 'default';
+
+ALTER TABLE coupon_redemptions DROP CONSTRAINT coupon_redemptions_pkey, ADD PRIMARY KEY (coupon_code, bill_id);
+ALTER TABLE coupon_redemptions DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE coupons DROP CONSTRAINT coupons_pkey, ADD PRIMARY KEY (code);
+ALTER TABLE coupons DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE coupon_redemptions ADD FOREIGN KEY (coupon_code) REFERENCES coupons (code) ON DELETE CASCADE;
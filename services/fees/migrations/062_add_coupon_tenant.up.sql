@@ -0,0 +1,14 @@
+-- Coupons belong to a tenant and are only redeemed for its bills, so two tenants can
+-- use the same code. Coupons created before belong to the default tenant.
+ALTER TABLE coupon_redemptions DROP CONSTRAINT coupon_redemptions_coupon_code_fkey;
+
+ALTER TABLE coupons ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE coupons DROP CONSTRAINT coupons_pkey, ADD PRIMARY KEY (tenant_id, code);
+
+ALTER TABLE coupon_redemptions ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE coupon_redemptions DROP CONSTRAINT coupon_redemptions_pkey,
+    ADD PRIMARY KEY (tenant_id, coupon_code, bill_id),
+    ADD FOREIGN KEY (tenant_id, coupon_code) REFERENCES coupons (tenant_id, code) ON DELETE CASCADE;
+
+-- Erasing a customer finds their redemptions.
+CREATE INDEX idx_coupon_redemptions_customer ON coupon_redemptions (tenant_id, customer_id);
@@ -0,0 +1,57 @@
+package fees
+
+import (
+	"context"
+)
+
+// NotificationEvent identifies what a notification is about.
+type NotificationEvent string
+
+const (
+	NotificationPaymentRetryFailed NotificationEvent = "payment.retry_failed"
+	NotificationPaymentRecovered   NotificationEvent = "payment.recovered"
+	NotificationBillDelinquent     NotificationEvent = "bill.delinquent"
+	NotificationBillOverdue        NotificationEvent = "bill.overdue"
+	NotificationLineItemDropped    NotificationEvent = "line_item.dropped"
+	// Approval notifications are addressed to billing operators.
+	NotificationBillApprovalRequested NotificationEvent = "bill.approval_requested"
+	NotificationBillApprovalEscalated NotificationEvent = "bill.approval_escalated"
+	// NotificationBillCloseFailed is addressed to billing operators too.
+	NotificationBillCloseFailed NotificationEvent = "bill.close_failed"
+	// NotificationSpendingThresholdCrossed tells the customer a bill reached one of its
+	// spending alert thresholds.
+	NotificationSpendingThresholdCrossed NotificationEvent = "bill.spending_threshold_crossed"
+	// Dispute notifications are addressed to billing operators, who answer disputes.
+	NotificationDisputeOpened          NotificationEvent = "dispute.opened"
+	NotificationDisputeEvidenceDue     NotificationEvent = "dispute.evidence_due"
+	NotificationDisputeEvidenceOverdue NotificationEvent = "dispute.evidence_overdue"
+	NotificationDisputeResolved        NotificationEvent = "dispute.resolved"
+	// NotificationPayerShareIssued tells a payer of a split bill what they owe of it.
+	NotificationPayerShareIssued NotificationEvent = "bill.payer_share_issued"
+	// NotificationReconciliationDiscrepancy tells billing operators that a bill rebuilt
+	// from its events disagrees with its workflow.
+	NotificationReconciliationDiscrepancy NotificationEvent = "bill.reconciliation_discrepancy"
+)
+
+// Notification is a message about a bill addressed to its customer or to billing operators.
+type Notification struct {
+	Event      NotificationEvent
+	BillID     string
+	CustomerID string
+	Message    string
+}
+
+// Notifier delivers notifications. Implementations wrap a delivery channel such as
+// email or chat and should be idempotent, since the calling activity may be retried.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// LogNotifier is a Notifier that writes notifications to the structured log.
+type LogNotifier struct{}
+
+// Notify implements Notifier.
+func (LogNotifier) Notify(ctx context.Context, n Notification) error {
+	loggerFrom(ctx).Info("Notification", "event", n.Event, "bill_id", n.BillID, "customer_id", n.CustomerID, "message", n.Message)
+	return nil
+}
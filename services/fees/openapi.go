@@ -0,0 +1,371 @@
+package fees
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/beta/errs"
+)
+
+// openAPIOperation describes an endpoint in the OpenAPI document.
+type openAPIOperation struct {
+	// Name is the endpoint's function name, used as the operation ID.
+	Name   string
+	Method string
+	// Path is the endpoint's Encore path, such as "/bills/:billID".
+	Path string
+	// Public endpoints need no API key.
+	Public bool
+	// Request and Response are zero values of the endpoint's request and response
+	// types, or nil for none.
+	Request  any
+	Response any
+}
+
+// openAPIOperations lists every public and authenticated endpoint that speaks JSON.
+// Raw endpoints (the metrics, the codec server, and the document itself) are left
+// out. TestOpenAPIOperations fails when this drifts from the endpoints' declarations.
+var openAPIOperations = []openAPIOperation{
+	{Name: "CreateBill", Method: "POST", Path: "/bills", Request: CreateBillRequest{}, Response: CreateBillResponse{}},
+	{Name: "ListBills", Method: "GET", Path: "/bills", Request: ListBillsParams{}, Response: ListBillsResponse{}},
+	{Name: "SearchBills", Method: "GET", Path: "/bills/search", Request: SearchBillsParams{}, Response: SearchBillsResponse{}},
+	{Name: "ListBillSummaries", Method: "GET", Path: "/bills/summaries", Request: ListBillSummariesParams{}, Response: ListBillSummariesResponse{}},
+	{Name: "CloseBills", Method: "POST", Path: "/bills/close-batch", Request: CloseBillsRequest{}, Response: JobResponse{}},
+	{Name: "GetBill", Method: "GET", Path: "/bills/:billID", Request: GetBillParams{}, Response: GetBillResponse{}},
+	{Name: "WaitForBill", Method: "GET", Path: "/bills/:billID/wait", Request: WaitForBillParams{}, Response: WaitForBillResponse{}},
+	{Name: "AddLineItem", Method: "POST", Path: "/bills/:billID/items", Request: AddLineItemRequest{}, Response: AddLineItemResponse{}},
+	{Name: "CloseBill", Method: "POST", Path: "/bills/:billID/close", Request: CloseBillRequest{}, Response: CloseBillResponse{}},
+	{Name: "RetryCloseBill", Method: "POST", Path: "/bills/:billID/close/retry", Response: CloseBillResponse{}},
+	{Name: "ApproveBill", Method: "POST", Path: "/bills/:billID/approve", Request: ApproveBillRequest{}, Response: BillApprovalResponse{}},
+	{Name: "RejectBill", Method: "POST", Path: "/bills/:billID/reject", Request: RejectBillRequest{}, Response: BillApprovalResponse{}},
+	{Name: "PayBill", Method: "POST", Path: "/bills/:billID/pay", Request: PayBillRequest{}, Response: PayBillResponse{}},
+	{Name: "RecordPayment", Method: "POST", Path: "/bills/:billID/payments", Request: RecordPaymentRequest{}, Response: RecordPaymentResponse{}},
+	{Name: "CreateRefund", Method: "POST", Path: "/bills/:billID/refunds", Request: CreateRefundRequest{}, Response: CreateRefundResponse{}},
+	{Name: "ListDisputes", Method: "GET", Path: "/bills/:billID/disputes", Response: ListDisputesResponse{}},
+	{Name: "ListBillChildren", Method: "GET", Path: "/bills/:billID/children", Response: ListBillChildrenResponse{}},
+	{Name: "SubmitDisputeEvidence", Method: "POST", Path: "/bills/:billID/disputes/:disputeID/evidence", Request: SubmitDisputeEvidenceRequest{}, Response: SubmitDisputeEvidenceResponse{}},
+	{Name: "GetDunning", Method: "GET", Path: "/bills/:billID/dunning", Response: DunningResponse{}},
+	{Name: "PauseDunning", Method: "POST", Path: "/bills/:billID/dunning/pause", Response: DunningActionResponse{}},
+	{Name: "ResumeDunning", Method: "POST", Path: "/bills/:billID/dunning/resume", Response: DunningActionResponse{}},
+	{Name: "GetBillAudit", Method: "GET", Path: "/bills/:billID/audit", Response: GetBillAuditResponse{}},
+	{Name: "GetBillEvents", Method: "GET", Path: "/bills/:billID/events", Response: GetBillEventsResponse{}},
+	{Name: "AddAttachment", Method: "POST", Path: "/bills/:billID/attachments", Request: AddAttachmentRequest{}, Response: AttachmentResponse{}},
+	{Name: "ListAttachments", Method: "GET", Path: "/bills/:billID/attachments", Response: ListAttachmentsResponse{}},
+	{Name: "DownloadAttachment", Method: "GET", Path: "/bills/:billID/attachments/:attachmentID/download", Response: AttachmentDownloadResponse{}},
+	{Name: "AddComment", Method: "POST", Path: "/bills/:billID/comments", Request: AddCommentRequest{}, Response: CommentResponse{}},
+	{Name: "ListComments", Method: "GET", Path: "/bills/:billID/comments", Response: ListCommentsResponse{}},
+	{Name: "GetBillArchive", Method: "GET", Path: "/bills/:billID/archive", Response: BillArchiveResponse{}},
+	{Name: "ResendBill", Method: "POST", Path: "/bills/:billID/deliveries", Request: ResendBillRequest{}, Response: BillDeliveryResponse{}},
+	{Name: "ListBillDeliveries", Method: "GET", Path: "/bills/:billID/deliveries", Response: ListBillDeliveriesResponse{}},
+	{Name: "SetBillSpendingAlerts", Method: "PUT", Path: "/bills/:billID/spending-alerts", Request: SetBillSpendingAlertsRequest{}, Response: SpendingAlertsResponse{}},
+	{Name: "SetPayerSplits", Method: "PUT", Path: "/bills/:billID/payer-splits", Request: SetPayerSplitsRequest{}, Response: PayerSplitsResponse{}},
+	{Name: "ApplyCoupon", Method: "POST", Path: "/bills/:billID/coupons", Request: ApplyCouponRequest{}, Response: ApplyCouponResponse{}},
+	{Name: "ListPayerShares", Method: "GET", Path: "/bills/:billID/payer-shares", Response: ListPayerSharesResponse{}},
+	{Name: "GetBillDisplay", Method: "GET", Path: "/bills/:billID/display", Request: BillDisplayParams{}, Response: BillDisplay{}},
+	{Name: "DeleteBill", Method: "DELETE", Path: "/bills/:billID", Response: BillDeletionResponse{}},
+	{Name: "RestoreBill", Method: "POST", Path: "/bills/:billID/restore", Response: BillDeletionResponse{}},
+	{Name: "ListLineItemEvents", Method: "GET", Path: "/bills/:billID/items/:lineItemID/events", Request: ListLineItemEventsParams{}, Response: ListLineItemEventsResponse{}},
+
+	{Name: "CreateSubscription", Method: "POST", Path: "/subscriptions", Request: CreateSubscriptionRequest{}, Response: CreateSubscriptionResponse{}},
+	{Name: "GetSubscription", Method: "GET", Path: "/subscriptions/:subscriptionID", Response: SubscriptionResponse{}},
+	{Name: "ChangeSubscriptionPlan", Method: "POST", Path: "/subscriptions/:subscriptionID/plan", Request: ChangeSubscriptionPlanRequest{}, Response: SubscriptionActionResponse{}},
+	{Name: "CancelSubscription", Method: "POST", Path: "/subscriptions/:subscriptionID/cancel", Request: CancelSubscriptionRequest{}, Response: SubscriptionActionResponse{}},
+
+	{Name: "GrantCredit", Method: "POST", Path: "/customers/:customerID/credits", Request: GrantCreditRequest{}, Response: GrantCreditResponse{}},
+	{Name: "GetCreditBalances", Method: "GET", Path: "/customers/:customerID/credits", Request: CreditBalancesParams{}, Response: CreditBalancesResponse{}},
+	{Name: "GetCustomerBalance", Method: "GET", Path: "/customers/:customerID/balance", Request: CustomerBalanceParams{}, Response: CustomerBalanceResponse{}},
+	{Name: "GetCustomerStatement", Method: "GET", Path: "/customers/:customerID/statements", Request: StatementParams{}, Response: StatementResponse{}},
+	{Name: "EraseCustomerData", Method: "DELETE", Path: "/customers/:customerID/data", Request: EraseCustomerDataRequest{}, Response: ErasureResponse{}},
+	{Name: "GetErasure", Method: "GET", Path: "/erasures/:erasureID", Response: ErasureCertificate{}},
+
+	{Name: "GetJob", Method: "GET", Path: "/jobs/:jobID", Response: JobResponse{}},
+	{Name: "CancelJob", Method: "POST", Path: "/jobs/:jobID/cancel", Response: JobResponse{}},
+
+	{Name: "SimulatePricing", Method: "POST", Path: "/pricing/simulate", Request: SimulatePricingRequest{}, Response: SimulatePricingResponse{}},
+	{Name: "GetQuotaUsage", Method: "GET", Path: "/quotas/:tenantID", Response: QuotaUsageResponse{}},
+	{Name: "GetRevenueReport", Method: "GET", Path: "/reports/revenue", Request: RevenueReportParams{}, Response: RevenueReportResponse{}},
+	{Name: "GetAccrualReport", Method: "GET", Path: "/reports/accruals", Request: AccrualReportParams{}, Response: AccrualReportResponse{}},
+	{Name: "GetLedgerEntries", Method: "GET", Path: "/ledger/accounts/:id/entries", Request: LedgerEntriesParams{}, Response: LedgerEntriesResponse{}},
+
+	{Name: "GetStatusFeed", Method: "GET", Path: "/status", Public: true, Response: StatusFeedResponse{}},
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	durationType   = reflect.TypeOf(time.Duration(0))
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+)
+
+// openAPIBuilder builds an OpenAPI 3 document, collecting the struct types it meets as
+// component schemas.
+type openAPIBuilder struct {
+	schemas map[string]any
+	names   map[reflect.Type]string
+	taken   map[string]reflect.Type
+}
+
+// buildOpenAPI returns the OpenAPI 3 document of openAPIOperations.
+func buildOpenAPI() map[string]any {
+	b := &openAPIBuilder{schemas: map[string]any{}, names: map[reflect.Type]string{}, taken: map[string]reflect.Type{}}
+	paths := map[string]any{}
+	for _, op := range openAPIOperations {
+		path := openAPIPath(op.Path)
+		item, _ := paths[path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[path] = item
+		}
+		item[strings.ToLower(op.Method)] = b.operation(op)
+	}
+
+	details := b.schema(reflect.TypeOf(apierr.Details{}))
+	detailsSchema := b.schemas[b.names[reflect.TypeOf(apierr.Details{})]].(map[string]any)
+	detailsSchema["properties"].(map[string]any)["reason"] = map[string]any{"type": "string", "enum": apierr.Reasons}
+	var codes []string
+	for code := errs.Canceled; code <= errs.Unauthenticated; code++ {
+		codes = append(codes, code.String())
+	}
+	b.schemas["Error"] = map[string]any{
+		"type":     "object",
+		"required": []string{"code", "message", "details"},
+		"properties": map[string]any{
+			"code":    map[string]any{"type": "string", "enum": codes, "description": "Determines the HTTP status, e.g. not_found is 404."},
+			"message": map[string]any{"type": "string"},
+			"details": details,
+		},
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Fees API",
+			"version":     "1.0.0",
+			"description": "Bills, line items, payments, and refunds, each bill run by a Temporal workflow. Errors carry a machine-readable reason in details.reason.",
+		},
+		"security": []any{map[string]any{"apiKey": []string{}}},
+		"paths":    paths,
+		"components": map[string]any{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{"type": "http", "scheme": "bearer", "description": "An API key, sent as \"Authorization: Bearer fms_...\"."},
+			},
+			"responses": map[string]any{
+				"Error": map[string]any{
+					"description": "The request failed.",
+					"content":     map[string]any{"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}}},
+				},
+			},
+		},
+	}
+}
+
+// openAPIPath converts an Encore path to an OpenAPI one: "/bills/:billID" becomes
+// "/bills/{billID}".
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if name, ok := strings.CutPrefix(s, ":"); ok {
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// operation describes one endpoint: its path, header, and query parameters, its JSON
+// body, its response, and the scope it requires.
+func (b *openAPIBuilder) operation(op openAPIOperation) map[string]any {
+	result := map[string]any{"operationId": op.Name}
+	var params []any
+	for _, s := range strings.Split(op.Path, "/") {
+		if name, ok := strings.CutPrefix(s, ":"); ok {
+			params = append(params, map[string]any{"name": name, "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+		}
+	}
+	if _, ok := mutatingEndpoints[op.Name]; ok {
+		params = append(params,
+			map[string]any{"name": IdempotencyKeyHeader, "in": "header", "schema": map[string]any{"type": "string"}, "description": "Makes retries of the request safe."},
+			map[string]any{"name": RetryAttemptHeader, "in": "header", "schema": map[string]any{"type": "integer"}, "description": "2 for the first retry, and so on."},
+		)
+	}
+	if op.Request != nil {
+		t := reflect.TypeOf(op.Request)
+		for _, f := range openAPIFields(t) {
+			if name := f.Tag.Get("header"); name != "" {
+				params = append(params, map[string]any{"name": name, "in": "header", "schema": b.schema(f.Type)})
+			} else if name := f.Tag.Get("query"); name != "" {
+				params = append(params, map[string]any{"name": name, "in": "query", "schema": b.schema(f.Type)})
+			}
+		}
+		if op.Method != "GET" && op.Method != "DELETE" {
+			result["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": b.schema(t)}},
+			}
+		}
+	}
+	if len(params) > 0 {
+		result["parameters"] = params
+	}
+
+	success := map[string]any{"description": "OK"}
+	if op.Response != nil {
+		t := reflect.TypeOf(op.Response)
+		success["content"] = map[string]any{"application/json": map[string]any{"schema": b.schema(t)}}
+		headers := map[string]any{}
+		for _, f := range openAPIFields(t) {
+			if name := f.Tag.Get("header"); name != "" {
+				headers[name] = map[string]any{"schema": b.schema(f.Type)}
+			}
+		}
+		if len(headers) > 0 {
+			success["headers"] = headers
+		}
+	}
+	result["responses"] = map[string]any{
+		"200":     success,
+		"default": map[string]any{"$ref": "#/components/responses/Error"},
+	}
+
+	if op.Public {
+		result["security"] = []any{}
+	} else if scope, ok := endpointScopes[op.Name]; ok {
+		result["description"] = "Requires the " + string(scope) + " scope."
+		result["x-required-scope"] = scope
+	}
+	return result
+}
+
+// openAPIFields returns the exported fields of struct t, with the fields of embedded
+// structs promoted as encoding/json does.
+func openAPIFields(t reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Tag.Get("json") == "" {
+			embedded := f.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				fields = append(fields, openAPIFields(embedded)...)
+				continue
+			}
+		}
+		if f.IsExported() {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// schema returns the schema of t, as a reference to a component schema for named
+// structs.
+func (b *openAPIBuilder) schema(t reflect.Type) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case durationType:
+		return map[string]any{"type": "integer", "format": "int64", "description": "Nanoseconds."}
+	case rawMessageType:
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name, ok := b.names[t]
+		if !ok {
+			name = b.componentName(t)
+			b.names[t] = name
+			b.schemas[name] = map[string]any{} // placeholder for recursive types
+			b.schemas[name] = b.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// componentName names the component schema of t: its type name, qualified with its
+// package if another package's type has the same name.
+func (b *openAPIBuilder) componentName(t reflect.Type) string {
+	name := t.Name()
+	if other, ok := b.taken[name]; ok && other != t {
+		pkg := t.PkgPath()
+		name = pkg[strings.LastIndex(pkg, "/")+1:] + "." + name
+	}
+	b.taken[name] = t
+	return name
+}
+
+// structSchema returns the schema of the JSON body of struct t. Header and query
+// fields travel outside the body and are left out. Fields that are neither pointers
+// nor omitempty are required.
+func (b *openAPIBuilder) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	for _, f := range openAPIFields(t) {
+		if f.Tag.Get("header") != "" || f.Tag.Get("query") != "" {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = b.schema(f.Type)
+		if f.Type.Kind() != reflect.Pointer && !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+var openAPIDocument = sync.OnceValues(func() ([]byte, error) {
+	return json.MarshalIndent(buildOpenAPI(), "", "  ")
+})
+
+// OpenAPI serves the OpenAPI 3 document of the public API, for generating clients.
+//
+// encore:api public raw method=GET path=/openapi.json
+func (s *Service) OpenAPI(w http.ResponseWriter, req *http.Request) {
+	doc, err := openAPIDocument()
+	if err != nil {
+		http.Error(w, "failed to build OpenAPI document", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(doc)
+}
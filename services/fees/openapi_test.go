@@ -0,0 +1,149 @@
+package fees
+
+import (
+	"encoding/json"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var updateOpenAPI = flag.Bool("update-openapi", false, "rewrite testdata/openapi.json from the current types")
+
+// TestOpenAPIOperations tests that openAPIOperations lists exactly the public and
+// authenticated JSON endpoints, with the methods, paths, and types they declare.
+func TestOpenAPIOperations(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	require.NoError(t, err)
+	declared := map[string]openAPIOperation{}
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+		require.NoError(t, err)
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Doc == nil {
+				continue
+			}
+			for _, c := range fn.Doc.List {
+				fields := strings.Fields(strings.TrimPrefix(c.Text, "//"))
+				if len(fields) < 2 || fields[0] != "encore:api" || fields[1] == "private" || contains(fields, "raw") {
+					continue
+				}
+				op := openAPIOperation{Name: fn.Name.Name, Public: fields[1] == "public"}
+				for _, field := range fields[2:] {
+					if v, ok := strings.CutPrefix(field, "method="); ok {
+						op.Method = v
+					} else if v, ok := strings.CutPrefix(field, "path="); ok {
+						op.Path = v
+					}
+				}
+				params := fn.Type.Params.List
+				if last := params[len(params)-1].Type; isStructParam(last) {
+					op.Request = typeName(last)
+				}
+				op.Response = typeName(fn.Type.Results.List[0].Type)
+				declared[op.Name] = op
+			}
+		}
+	}
+
+	listed := map[string]openAPIOperation{}
+	for _, op := range openAPIOperations {
+		require.NotContains(t, listed, op.Name, "%s is listed twice", op.Name)
+		if op.Request != nil {
+			op.Request = reflect.TypeOf(op.Request).Name()
+		}
+		if op.Response != nil {
+			op.Response = reflect.TypeOf(op.Response).Name()
+		}
+		listed[op.Name] = op
+	}
+	require.Equal(t, declared, listed)
+}
+
+func contains(fields []string, s string) bool {
+	for _, f := range fields {
+		if f == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isStructParam reports whether a parameter is a request struct rather than the
+// context or a path parameter.
+func isStructParam(expr ast.Expr) bool {
+	star, ok := expr.(*ast.StarExpr)
+	return ok && typeName(star) != ""
+}
+
+// typeName returns the name of a *T or T type expression.
+func typeName(expr ast.Expr) any {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// TestOpenAPIDocument tests that the served document matches testdata/openapi.json, so
+// a change to a request or response type cannot reach clients unreviewed. Run
+// "go test -run TestOpenAPIDocument -update-openapi" to accept a change.
+func TestOpenAPIDocument(t *testing.T) {
+	doc, err := openAPIDocument()
+	require.NoError(t, err)
+	golden := filepath.Join("testdata", "openapi.json")
+	if *updateOpenAPI {
+		require.NoError(t, os.MkdirAll("testdata", 0o755))
+		require.NoError(t, os.WriteFile(golden, append(doc, '\n'), 0o644))
+	}
+	want, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	require.JSONEq(t, string(want), string(doc), "the API changed; review it and rerun with -update-openapi")
+
+	var parsed struct {
+		Paths map[string]map[string]struct {
+			Parameters []struct {
+				Name string `json:"name"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+			RequestBody struct {
+				Content map[string]struct {
+					Schema map[string]any `json:"schema"`
+				} `json:"content"`
+			} `json:"requestBody"`
+			Responses map[string]struct {
+				Headers map[string]any `json:"headers"`
+			} `json:"responses"`
+			Scope string `json:"x-required-scope"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(doc, &parsed))
+
+	create := parsed.Paths["/bills"]["post"]
+	require.Equal(t, "#/components/schemas/CreateBillRequest", create.RequestBody.Content["application/json"].Schema["$ref"])
+	require.Contains(t, create.Parameters, struct {
+		Name string `json:"name"`
+		In   string `json:"in"`
+	}{Name: "X-Tenant-ID", In: "header"})
+	require.Equal(t, "bills:write", create.Scope)
+	require.Contains(t, parsed.Paths["/bills/{billID}"]["get"].Responses["200"].Headers, "ETag")
+	require.Contains(t, string(parsed.Components.Schemas["Details"]), `"bill_not_found"`)
+	require.NotContains(t, string(parsed.Components.Schemas["CreateBillRequest"]), "X-Tenant-ID")
+}
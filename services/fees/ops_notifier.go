@@ -0,0 +1,388 @@
+package fees
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	// opsPostTimeout bounds one post to an ops webhook.
+	opsPostTimeout = 10 * time.Second
+	// defaultOpsThrottle is how long the same event of the same bill is not posted again.
+	defaultOpsThrottle = 15 * time.Minute
+)
+
+// OpsChannel is a chat service that ops notifications are posted to.
+type OpsChannel string
+
+const (
+	OpsChannelSlack OpsChannel = "slack"
+	OpsChannelTeams OpsChannel = "teams"
+)
+
+// OpsWebhook is an incoming webhook of a chat channel that billing operators watch.
+type OpsWebhook struct {
+	Channel OpsChannel
+	URL     string
+}
+
+// OpsConfig says which notifications are posted to billing operators' chat channels,
+// and how.
+type OpsConfig struct {
+	// Webhooks are posted every notification of Events. None disables ops notifications.
+	Webhooks []OpsWebhook
+	Events   []NotificationEvent
+	// Templates replace the default message of an event, or of every event under the
+	// key "default". They are text/template templates rendering an opsMessage.
+	Templates map[string]string
+	// Throttle is how long the same event of the same bill is not posted again to a
+	// webhook. Zero posts every notification.
+	Throttle time.Duration
+	// RateLimit caps the messages posted to each webhook. Messages over it are dropped
+	// and counted in the next message posted.
+	RateLimit RateLimit
+}
+
+// defaultOpsEvents are the events posted to ops webhooks unless FEES_OPS_EVENTS lists
+// others: failures that need an operator, and bills that need a closer look.
+var defaultOpsEvents = []NotificationEvent{
+	NotificationBillCloseFailed,
+	NotificationReconciliationDiscrepancy,
+	NotificationBillApprovalRequested,
+	NotificationBillApprovalEscalated,
+	NotificationSpendingThresholdCrossed,
+	NotificationPaymentRetryFailed,
+	NotificationBillDelinquent,
+	NotificationDisputeOpened,
+	NotificationDisputeEvidenceOverdue,
+}
+
+// opsTitles are the headlines of ops messages, by event. They also list the events that
+// can be posted.
+var opsTitles = map[NotificationEvent]string{
+	NotificationPaymentRetryFailed:        "Payment retry failed",
+	NotificationPaymentRecovered:          "Payment recovered",
+	NotificationBillDelinquent:            "Bill delinquent",
+	NotificationBillOverdue:               "Bill overdue",
+	NotificationLineItemDropped:           "Line item dropped",
+	NotificationBillApprovalRequested:     "Bill awaiting approval",
+	NotificationBillApprovalEscalated:     "Bill approval escalated",
+	NotificationBillCloseFailed:           "Bill close failed",
+	NotificationSpendingThresholdCrossed:  "Spending threshold crossed",
+	NotificationDisputeOpened:             "Chargeback disputed",
+	NotificationDisputeEvidenceDue:        "Dispute evidence due",
+	NotificationDisputeEvidenceOverdue:    "Dispute evidence overdue",
+	NotificationDisputeResolved:           "Dispute resolved",
+	NotificationPayerShareIssued:          "Payer share issued",
+	NotificationReconciliationDiscrepancy: "Bill events disagree with the bill",
+}
+
+// opsMessage is what ops message templates render.
+type opsMessage struct {
+	Event      NotificationEvent
+	Title      string
+	BillID     string
+	CustomerID string
+	Message    string
+	// Suppressed counts the notifications throttled on the webhook since its last
+	// message.
+	Suppressed int
+}
+
+const defaultOpsTemplate = `{{.Title}}: bill {{.BillID}}{{with .CustomerID}} of customer {{.}}{{end}}
+{{.Message}}{{if .Suppressed}}
+({{.Suppressed}} more notifications were throttled.){{end}}`
+
+var defaultOpsMessageTemplate = template.Must(template.New("default").Option("missingkey=error").Parse(defaultOpsTemplate))
+
+// loadOpsConfig reads the ops notification settings from the environment.
+func loadOpsConfig() (OpsConfig, error) {
+	cfg := OpsConfig{Events: defaultOpsEvents, Throttle: defaultOpsThrottle, RateLimit: RateLimit{PerSecond: 1, Burst: 10}}
+
+	webhooks, err := secretFromEnv("FEES_OPS_WEBHOOKS", "ops webhooks")
+	if err != nil {
+		return OpsConfig{}, err
+	}
+	if cfg.Webhooks, err = parseOpsWebhooks(webhooks); err != nil {
+		return OpsConfig{}, fmt.Errorf("invalid FEES_OPS_WEBHOOKS: %w", err)
+	}
+
+	if v := os.Getenv("FEES_OPS_EVENTS"); v != "" {
+		cfg.Events = nil
+		for _, name := range strings.Split(v, ",") {
+			event := NotificationEvent(strings.TrimSpace(name))
+			if _, ok := opsTitles[event]; !ok {
+				return OpsConfig{}, fmt.Errorf("invalid FEES_OPS_EVENTS: unknown event %q", event)
+			}
+			cfg.Events = append(cfg.Events, event)
+		}
+	}
+
+	if path := os.Getenv("FEES_OPS_TEMPLATES_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return OpsConfig{}, fmt.Errorf("failed to read FEES_OPS_TEMPLATES_FILE: %w", err)
+		}
+		if err := json.Unmarshal(b, &cfg.Templates); err != nil {
+			return OpsConfig{}, fmt.Errorf("invalid FEES_OPS_TEMPLATES_FILE: must be a JSON object of templates by event: %w", err)
+		}
+		if _, err := parseOpsTemplates(cfg.Templates); err != nil {
+			return OpsConfig{}, fmt.Errorf("invalid FEES_OPS_TEMPLATES_FILE: %w", err)
+		}
+	}
+
+	if err := durationFromEnv("FEES_OPS_THROTTLE", &cfg.Throttle); err != nil {
+		return OpsConfig{}, err
+	}
+	if cfg.Throttle < 0 {
+		return OpsConfig{}, fmt.Errorf("FEES_OPS_THROTTLE must not be negative, got %s", cfg.Throttle)
+	}
+	if err := rateLimitFromEnv("FEES_OPS_RATE_LIMIT", &cfg.RateLimit); err != nil {
+		return OpsConfig{}, err
+	}
+	return cfg, nil
+}
+
+// parseOpsWebhooks parses comma-separated "<channel>=<url>" webhooks, such as
+// "slack=https://hooks.slack.com/services/...".
+func parseOpsWebhooks(v string) ([]OpsWebhook, error) {
+	var webhooks []OpsWebhook
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		channel, rawURL, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("webhook must be <channel>=<url>")
+		}
+		webhook := OpsWebhook{Channel: OpsChannel(strings.TrimSpace(channel)), URL: strings.TrimSpace(rawURL)}
+		if webhook.Channel != OpsChannelSlack && webhook.Channel != OpsChannelTeams {
+			return nil, fmt.Errorf("unknown channel %q: must be slack or teams", webhook.Channel)
+		}
+		// The URLs are secrets, so errors do not repeat them.
+		if u, err := url.Parse(webhook.URL); err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("the %s webhook URL must be an https URL", webhook.Channel)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+// parseOpsTemplates parses ops message templates by event, checking that each renders
+// a sample message.
+func parseOpsTemplates(templates map[string]string) (map[string]*template.Template, error) {
+	parsed := make(map[string]*template.Template, len(templates))
+	for key, text := range templates {
+		if _, ok := opsTitles[NotificationEvent(key)]; !ok && key != "default" {
+			return nil, fmt.Errorf("unknown event %q", key)
+		}
+		t, err := template.New(key).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("template of %s: %w", key, err)
+		}
+		sample := opsMessage{Event: NotificationBillCloseFailed, Title: opsTitles[NotificationBillCloseFailed], BillID: "bill-1", CustomerID: "cust-1", Message: "Sample.", Suppressed: 2}
+		if err := t.Execute(io.Discard, sample); err != nil {
+			return nil, fmt.Errorf("template of %s: %w", key, err)
+		}
+		parsed[key] = t
+	}
+	return parsed, nil
+}
+
+// opsNotifier is a Notifier that also posts operators' notifications to Slack and
+// Teams, after passing every notification on to next.
+//
+// Posting is best effort: a webhook that fails is logged, and the notification is not
+// retried, so a chat outage cannot hold up the workflows that notify. Throttling is
+// kept per process, so each worker throttles on its own.
+type opsNotifier struct {
+	next      Notifier
+	webhooks  []OpsWebhook
+	events    map[NotificationEvent]bool
+	templates map[string]*template.Template
+	throttle  time.Duration
+	limiter   *rateLimiter
+	client    *http.Client
+	clock     Clock
+
+	mu sync.Mutex
+	// posted holds when each event of each bill was last posted to a webhook.
+	posted     map[string]time.Time
+	suppressed map[int]int
+}
+
+// newOpsNotifier returns a Notifier posting to cfg's webhooks besides next, or next if
+// there are none.
+func newOpsNotifier(cfg OpsConfig, next Notifier, clock Clock) (Notifier, error) {
+	if len(cfg.Webhooks) == 0 {
+		return next, nil
+	}
+	templates, err := parseOpsTemplates(cfg.Templates)
+	if err != nil {
+		return nil, err
+	}
+	n := &opsNotifier{
+		next:       next,
+		webhooks:   cfg.Webhooks,
+		events:     make(map[NotificationEvent]bool),
+		templates:  templates,
+		throttle:   cfg.Throttle,
+		limiter:    newRateLimiter(cfg.RateLimit, clock),
+		client:     &http.Client{Timeout: opsPostTimeout},
+		clock:      clock,
+		posted:     make(map[string]time.Time),
+		suppressed: make(map[int]int),
+	}
+	for _, event := range cfg.Events {
+		n.events[event] = true
+	}
+	return n, nil
+}
+
+// Notify implements Notifier.
+func (n *opsNotifier) Notify(ctx context.Context, notification Notification) error {
+	if err := n.next.Notify(ctx, notification); err != nil {
+		return err
+	}
+	if !n.events[notification.Event] {
+		return nil
+	}
+	for i, webhook := range n.webhooks {
+		suppressed, ok := n.admit(i, notification)
+		if !ok {
+			continue
+		}
+		msg := opsMessage{
+			Event:      notification.Event,
+			Title:      opsTitles[notification.Event],
+			BillID:     notification.BillID,
+			CustomerID: notification.CustomerID,
+			Message:    notification.Message,
+			Suppressed: suppressed,
+		}
+		if err := n.post(ctx, webhook, msg, n.render(ctx, msg)); err != nil {
+			loggerFrom(ctx).Warn("Failed to post ops notification", "channel", webhook.Channel, "event", notification.Event, "bill_id", notification.BillID, "error", err)
+			n.restore(i, notification, suppressed)
+		}
+	}
+	return nil
+}
+
+// throttleKey identifies an event of a bill on webhook i.
+func throttleKey(i int, notification Notification) string {
+	return fmt.Sprintf("%d/%s/%s", i, notification.Event, notification.BillID)
+}
+
+// admit decides whether notification is posted to webhook i, and returns how many
+// notifications the webhook throttled since its last message. Notifications that are
+// not posted are counted instead.
+func (n *opsNotifier) admit(i int, notification Notification) (int, bool) {
+	now := n.clock.Now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for key, at := range n.posted {
+		if now.Sub(at) >= n.throttle {
+			delete(n.posted, key)
+		}
+	}
+
+	key := throttleKey(i, notification)
+	if _, ok := n.posted[key]; ok {
+		n.suppressed[i]++
+		return 0, false
+	}
+	if ok, _ := n.limiter.allow(fmt.Sprint(i)); !ok {
+		n.suppressed[i]++
+		return 0, false
+	}
+	if n.throttle > 0 {
+		n.posted[key] = now
+	}
+	suppressed := n.suppressed[i]
+	n.suppressed[i] = 0
+	return suppressed, true
+}
+
+// restore undoes admit for a notification that could not be posted, so the next one
+// of its bill is not throttled and still reports the suppressed count.
+func (n *opsNotifier) restore(i int, notification Notification, suppressed int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.posted, throttleKey(i, notification))
+	n.suppressed[i] += suppressed
+}
+
+// render renders msg with its event's template, the "default" one, or the built-in
+// one. A template that fails is logged and the built-in one used instead.
+func (n *opsNotifier) render(ctx context.Context, msg opsMessage) string {
+	t, ok := n.templates[string(msg.Event)]
+	if !ok {
+		t, ok = n.templates["default"]
+	}
+	var buf bytes.Buffer
+	if ok {
+		err := t.Execute(&buf, msg)
+		if err == nil {
+			return buf.String()
+		}
+		loggerFrom(ctx).Warn("Ops notification template failed, using the default", "template", t.Name(), "error", err)
+		buf.Reset()
+	}
+	// The default template renders every message.
+	_ = defaultOpsMessageTemplate.Execute(&buf, msg)
+	return buf.String()
+}
+
+// post posts text to webhook in its channel's message format.
+func (n *opsNotifier) post(ctx context.Context, webhook OpsWebhook, msg opsMessage, text string) error {
+	var payload any
+	switch webhook.Channel {
+	case OpsChannelTeams:
+		// Teams renders the text as Markdown, which needs blank lines between paragraphs.
+		payload = map[string]string{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    msg.Title,
+			"themeColor": "d1242f",
+			"text":       strings.ReplaceAll(text, "\n", "\n\n"),
+		}
+	default:
+		payload = map[string]string{"text": text}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// The error names the URL, which is a secret.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	return nil
+}
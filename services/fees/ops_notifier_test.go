@@ -0,0 +1,109 @@
+package fees
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// opsWebhookServer records the JSON payloads posted to it.
+func opsWebhookServer(t *testing.T, status int) (*httptest.Server, *[]map[string]string) {
+	var posted []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		posted = append(posted, payload)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &posted
+}
+
+// TestOpsNotifier tests that operators' notifications are posted to Slack and Teams in
+// their formats, and that other events are not.
+func TestOpsNotifier(t *testing.T) {
+	slack, slackPosts := opsWebhookServer(t, http.StatusOK)
+	teams, teamsPosts := opsWebhookServer(t, http.StatusOK)
+	n, err := newOpsNotifier(OpsConfig{
+		Webhooks:  []OpsWebhook{{Channel: OpsChannelSlack, URL: slack.URL}, {Channel: OpsChannelTeams, URL: teams.URL}},
+		Events:    []NotificationEvent{NotificationBillCloseFailed, NotificationBillDelinquent},
+		Templates: map[string]string{string(NotificationBillDelinquent): "{{.BillID}} is delinquent"},
+	}, LogNotifier{}, newFakeClock(time.Now()))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, n.Notify(ctx, Notification{Event: NotificationBillCloseFailed, BillID: "b1", CustomerID: "c1", Message: "Retried at noon."}))
+	require.NoError(t, n.Notify(ctx, Notification{Event: NotificationBillDelinquent, BillID: "b2"}))
+	require.NoError(t, n.Notify(ctx, Notification{Event: NotificationBillOverdue, BillID: "b3"}))
+
+	require.Equal(t, []map[string]string{
+		{"text": "Bill close failed: bill b1 of customer c1\nRetried at noon."},
+		{"text": "b2 is delinquent"},
+	}, *slackPosts)
+	require.Len(t, *teamsPosts, 2)
+	require.Equal(t, "MessageCard", (*teamsPosts)[0]["@type"])
+	require.Equal(t, "Bill close failed", (*teamsPosts)[0]["summary"])
+	require.Equal(t, "Bill close failed: bill b1 of customer c1\n\nRetried at noon.", (*teamsPosts)[0]["text"])
+}
+
+// TestOpsNotifierThrottle tests that repeats of a bill's event and messages over the
+// rate limit are dropped and counted in the next message, and that a failed post does
+// not throttle the next notification.
+func TestOpsNotifierThrottle(t *testing.T) {
+	server, posts := opsWebhookServer(t, http.StatusOK)
+	clock := newFakeClock(time.Now())
+	n, err := newOpsNotifier(OpsConfig{
+		Webhooks:  []OpsWebhook{{Channel: OpsChannelSlack, URL: server.URL}},
+		Events:    []NotificationEvent{NotificationBillCloseFailed},
+		Templates: map[string]string{"default": "{{.BillID}} {{.Suppressed}}"},
+		Throttle:  time.Hour,
+		RateLimit: RateLimit{PerSecond: 1, Burst: 2},
+	}, LogNotifier{}, clock)
+	require.NoError(t, err)
+	notify := func(billID string) {
+		require.NoError(t, n.Notify(context.Background(), Notification{Event: NotificationBillCloseFailed, BillID: billID}))
+	}
+
+	notify("b1")
+	notify("b1")
+	notify("b2")
+	notify("b3")
+	clock.Advance(time.Second)
+	notify("b4")
+	clock.Advance(time.Hour)
+	notify("b1")
+	require.Equal(t, []map[string]string{{"text": "b1 0"}, {"text": "b2 1"}, {"text": "b4 1"}, {"text": "b1 0"}}, *posts)
+
+	failing, failed := opsWebhookServer(t, http.StatusInternalServerError)
+	n.(*opsNotifier).webhooks[0].URL = failing.URL
+	clock.Advance(time.Hour)
+	notify("b5")
+	notify("b5")
+	require.Len(t, *failed, 2)
+}
+
+// TestParseOpsWebhooks tests that webhooks need a known channel and an https URL.
+func TestParseOpsWebhooks(t *testing.T) {
+	webhooks, err := parseOpsWebhooks("slack=https://hooks.slack.com/services/T/B/X, teams=https://acme.webhook.office.com/webhookb2/1")
+	require.NoError(t, err)
+	require.Equal(t, []OpsWebhook{
+		{Channel: OpsChannelSlack, URL: "https://hooks.slack.com/services/T/B/X"},
+		{Channel: OpsChannelTeams, URL: "https://acme.webhook.office.com/webhookb2/1"},
+	}, webhooks)
+
+	_, err = parseOpsWebhooks("discord=https://discord.com/api/webhooks/1")
+	require.Error(t, err)
+	_, err = parseOpsWebhooks("slack=http://hooks.slack.com/services/T/B/X")
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "hooks.slack.com")
+
+	_, err = parseOpsTemplates(map[string]string{"default": "{{.Missing}}"})
+	require.Error(t, err)
+	_, err = parseOpsTemplates(map[string]string{"bill.unknown": "{{.BillID}}"})
+	require.Error(t, err)
+}
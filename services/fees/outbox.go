@@ -0,0 +1,273 @@
+package fees
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"encore.app/apierr"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+)
+
+// outboxKind names the request an outbox entry replays.
+type outboxKind string
+
+const (
+	outboxCreateBill  outboxKind = "create_bill"
+	outboxAddLineItem outboxKind = "add_line_item"
+)
+
+// outboxCreateBillPayload is the queued form of a CreateBill request.
+type outboxCreateBillPayload struct {
+	WorkflowID string
+	// TaskQueue is the queue CreateBill routed the bill to; empty in requests queued
+	// before routing existed.
+	TaskQueue string
+	// Keyed requests reject duplicate workflow IDs, like CreateBill does.
+	Keyed  bool
+	Params BillWorkflowParams
+}
+
+// outboxAddLineItemPayload is the queued form of an AddLineItem request.
+type outboxAddLineItemPayload struct {
+	WorkflowID string
+	Signal     AddLineItemSignal
+}
+
+// outbox queues CreateBill and AddLineItem requests that could not reach Temporal and
+// replays them, oldest first, once it is reachable again.
+type outbox struct {
+	db       *tracedDB
+	temporal client.Client
+	clock    Clock
+	interval time.Duration
+}
+
+// enqueue saves a request for replay.
+func (o *outbox) enqueue(ctx context.Context, kind outboxKind, billID string, payload any) error {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s request for bill %s: %w", kind, billID, err)
+	}
+	_, err = o.db.Exec(ctx, `
+        INSERT INTO temporal_outbox (kind, bill_id, payload, created_at)
+        VALUES ($1, $2, $3, $4)
+    `, kind, billID, encoded, o.clock.Now())
+	if err != nil {
+		return fmt.Errorf("failed to queue %s request for bill %s: %w", kind, billID, err)
+	}
+	loggerFrom(ctx).Warn("Temporal unavailable, request queued for replay", "kind", kind, "bill_id", billID)
+	return nil
+}
+
+// pendingBill returns a stale view of a bill whose creation is still queued, with
+// its queued line items, or nil if there is none.
+func (o *outbox) pendingBill(ctx context.Context, billID string) (*Bill, error) {
+	rows, err := o.db.Query(ctx, `
+        SELECT kind, payload, created_at
+        FROM temporal_outbox
+        WHERE bill_id = $1 AND delivered_at IS NULL AND failed_at IS NULL
+        ORDER BY id
+    `, billID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load queued requests for bill %s: %w", billID, err)
+	}
+	defer rows.Close()
+
+	var bill *Bill
+	for rows.Next() {
+		var kind outboxKind
+		var payload []byte
+		var createdAt time.Time
+		if err := rows.Scan(&kind, &payload, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to read queued requests for bill %s: %w", billID, err)
+		}
+		switch {
+		case kind == outboxCreateBill:
+			var create outboxCreateBillPayload
+			if err := json.Unmarshal(payload, &create); err != nil {
+				return nil, fmt.Errorf("failed to decode queued bill %s: %w", billID, err)
+			}
+			bill = &Bill{
+				ID:             billID,
+				TenantID:       create.Params.TenantID,
+				CustomerID:     create.Params.CustomerID,
+				Currency:       create.Params.Currency,
+				Status:         BillStatusOpen,
+				LineItems:      []LineItem{},
+				CreatedAt:      &createdAt,
+				AutoCollect:    create.Params.AutoCollect,
+				CreatedByKeyID: create.Params.CreatedByKeyID,
+				TemplateID:     create.Params.TemplateID,
+				Stale:          true,
+			}
+			for i, item := range create.Params.TemplateItems {
+				bill.LineItems = append(bill.LineItems, LineItem{
+					ID:             templateItemID(billID, create.Params.TemplateID, i),
+					Description:    item.Description,
+					Amount:         item.Amount,
+					CreatedByKeyID: create.Params.CreatedByKeyID,
+				})
+				bill.TotalAmount += item.Amount
+			}
+		case kind == outboxAddLineItem && bill != nil:
+			var add outboxAddLineItemPayload
+			if err := json.Unmarshal(payload, &add); err != nil {
+				return nil, fmt.Errorf("failed to decode queued line item for bill %s: %w", billID, err)
+			}
+			bill.LineItems = append(bill.LineItems, LineItem{
+				ID:              add.Signal.LineItemID,
+				Description:     add.Signal.Description,
+				Amount:          add.Signal.Amount,
+				Type:            add.Signal.Type,
+				ReasonCode:      add.Signal.ReasonCode,
+				Reference:       add.Signal.Reference,
+				CreatedByKeyID:  add.Signal.CreatedByKeyID,
+				ClientReference: add.Signal.ClientReference,
+			})
+			bill.TotalAmount += add.Signal.Amount
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read queued requests for bill %s: %w", billID, err)
+	}
+	return bill, nil
+}
+
+// run replays queued requests every interval until ctx is done.
+func (o *outbox) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.clock.After(o.interval):
+		}
+		if n, err := o.replay(ctx); err != nil {
+			slog.Warn("Outbox replay stopped", "replayed", n, "error", err)
+		} else if n > 0 {
+			slog.Info("Outbox replayed queued requests", "replayed", n)
+		}
+	}
+}
+
+// replay delivers queued requests in order. It stops at the first request Temporal is
+// still unavailable for, so a bill's line items are never delivered before the bill.
+// Requests Temporal rejects for good are marked failed and skipped.
+func (o *outbox) replay(ctx context.Context) (int, error) {
+	rows, err := o.db.Query(ctx, `
+        SELECT id, kind, bill_id, payload
+        FROM temporal_outbox
+        WHERE delivered_at IS NULL AND failed_at IS NULL
+        ORDER BY id
+    `)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load queued requests: %w", err)
+	}
+	type entry struct {
+		id      int64
+		kind    outboxKind
+		billID  string
+		payload []byte
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.id, &e.kind, &e.billID, &e.payload); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to read queued requests: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read queued requests: %w", err)
+	}
+
+	replayed := 0
+	for _, e := range entries {
+		deliverErr := o.deliver(ctx, e.kind, e.payload)
+		if apierr.IsTemporalUnavailable(deliverErr) {
+			_, _ = o.db.Exec(ctx, `UPDATE temporal_outbox SET attempts = attempts + 1, last_error = $2 WHERE id = $1`, e.id, deliverErr.Error())
+			return replayed, deliverErr
+		}
+		if deliverErr != nil {
+			slog.Error("Queued request rejected by Temporal, dropping", "kind", e.kind, "bill_id", e.billID, "error", deliverErr)
+			_, err = o.db.Exec(ctx, `UPDATE temporal_outbox SET attempts = attempts + 1, last_error = $2, failed_at = $3 WHERE id = $1`, e.id, deliverErr.Error(), o.clock.Now())
+		} else {
+			_, err = o.db.Exec(ctx, `UPDATE temporal_outbox SET attempts = attempts + 1, delivered_at = $2 WHERE id = $1`, e.id, o.clock.Now())
+			replayed++
+		}
+		if err != nil {
+			return replayed, fmt.Errorf("failed to update queued request %d: %w", e.id, err)
+		}
+	}
+	return replayed, nil
+}
+
+// deliver sends one queued request to Temporal. A bill that was created after all,
+// by an attempt that timed out on the client side, counts as delivered.
+func (o *outbox) deliver(ctx context.Context, kind outboxKind, payload []byte) error {
+	switch kind {
+	case outboxCreateBill:
+		var create outboxCreateBillPayload
+		if err := json.Unmarshal(payload, &create); err != nil {
+			return err
+		}
+		options := client.StartWorkflowOptions{ID: create.WorkflowID, TaskQueue: create.TaskQueue}
+		if options.TaskQueue == "" {
+			options.TaskQueue = feesTaskQueue
+		}
+		if create.Keyed {
+			options.WorkflowIDReusePolicy = enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE
+			options.WorkflowExecutionErrorWhenAlreadyStarted = true
+		}
+		_, err := o.temporal.ExecuteWorkflow(ctx, options, BillWorkflow, &create.Params)
+		var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+		if errors.As(err, &alreadyStarted) {
+			return nil
+		}
+		return err
+	case outboxAddLineItem:
+		var add outboxAddLineItemPayload
+		if err := json.Unmarshal(payload, &add); err != nil {
+			return err
+		}
+		return o.temporal.SignalWorkflow(ctx, add.WorkflowID, "", AddLineItemSignalName, add.Signal)
+	}
+	return fmt.Errorf("unknown outbox request kind %q", kind)
+}
+
+// queueCreateBill queues a CreateBill request whose workflow could not be started
+// because Temporal is unavailable. A keyed retry of a request that is already queued
+// is not queued again.
+func (s *Service) queueCreateBill(ctx context.Context, tenantID string, options client.StartWorkflowOptions, params *BillWorkflowParams, cause error) (*CreateBillResponse, error) {
+	resp := &CreateBillResponse{
+		BillID:          params.BillID,
+		WorkflowID:      options.ID,
+		InitialStatus:   BillStatusOpen,
+		Queued:          true,
+		ConfirmationMsg: "Temporal is unavailable; the bill is queued and will be created once it is back.",
+	}
+
+	pending, err := s.outbox.pendingBill(ctx, params.BillID)
+	if err == nil && pending != nil {
+		s.quotas.release(ctx, tenantID, QuotaMetricBillsCreated)
+		resp.ConfirmationMsg = "Bill already queued for this idempotency key."
+		return resp, nil
+	}
+	if err == nil {
+		payload := outboxCreateBillPayload{WorkflowID: options.ID, TaskQueue: options.TaskQueue, Keyed: idempotencyKey(ctx) != "", Params: *params}
+		err = s.outbox.enqueue(ctx, outboxCreateBill, params.BillID, payload)
+	}
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to queue bill", "bill_id", params.BillID, "error", err)
+		s.quotas.release(ctx, tenantID, QuotaMetricBillsCreated)
+		return nil, apierr.FromTemporal(cause, apierr.Internal, "failed to create bill")
+	}
+	return resp, nil
+}
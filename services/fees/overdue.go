@@ -0,0 +1,73 @@
+package fees
+
+import (
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// awaitsPayment reports whether the bill has closed and has not been paid or given up
+// on yet, so it can still become overdue.
+func (b *Bill) awaitsPayment() bool {
+	return b.Status == BillStatusClosed || b.Status == BillStatusPaymentFailed || b.Status == BillStatusPartiallyPaid
+}
+
+// watchDueDate arms the overdue timer of a closed bill that awaits payment and has a
+// due date. A bill already past its due date, as a resumed run may find it, becomes
+// OVERDUE at once.
+func (w *billWorkflow) watchDueDate() {
+	bill := w.bill
+	if bill.DueDate == nil || !bill.awaitsPayment() || w.overdueTimer != nil {
+		return
+	}
+	wait := bill.DueDate.Sub(workflow.Now(w.ctx))
+	if wait <= 0 {
+		w.markOverdue()
+		return
+	}
+	timerCtx, cancel := workflow.WithCancel(w.ctx)
+	w.overdueTimer, w.cancelOverdueTimer = workflow.NewTimer(timerCtx, wait), cancel
+	w.logger.Info("Watching bill due date", "bill_id", bill.ID, "due_date", bill.DueDate)
+}
+
+// stopWatchingDueDate cancels the overdue timer once the bill no longer awaits payment,
+// so a paid bill does not keep its run open until the due date.
+func (w *billWorkflow) stopWatchingDueDate() {
+	if w.overdueTimer == nil || w.bill.awaitsPayment() {
+		return
+	}
+	w.cancelOverdueTimer()
+	w.overdueTimer, w.cancelOverdueTimer = nil, nil
+}
+
+// dueDateReached handles the overdue timer firing.
+func (w *billWorkflow) dueDateReached(f workflow.Future) {
+	w.overdueTimer, w.cancelOverdueTimer = nil, nil
+	if err := f.Get(w.ctx, nil); err != nil {
+		if !temporal.IsCanceledError(err) {
+			w.logger.Error("Overdue timer failed", "bill_id", w.bill.ID, "error", err)
+		}
+		return
+	}
+	w.markOverdue()
+}
+
+// markOverdue moves a bill that is still unpaid past its due date to OVERDUE and
+// notifies the customer.
+func (w *billWorkflow) markOverdue() {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+	if !bill.awaitsPayment() {
+		return
+	}
+
+	w.setStatus(BillStatusOverdue)
+	logger.Info("Bill is overdue", "bill_id", bill.ID, "due_date", bill.DueDate)
+	notify(ctx, Notification{
+		Event:      NotificationBillOverdue,
+		BillID:     bill.ID,
+		CustomerID: bill.CustomerID,
+		Message:    fmt.Sprintf("Bill of %.2f %s was due %s and is overdue.", bill.TotalAmount, bill.Currency, bill.DueDate.Format(time.RFC3339)),
+	})
+}
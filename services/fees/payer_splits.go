@@ -0,0 +1,320 @@
+package fees
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"encore.app/apierr"
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// SavePayerSharesActivityName saves the payer shares issued when a bill closes.
+	SavePayerSharesActivityName = "SavePayerSharesActivity"
+
+	// maxPayerSplits caps how many payers a bill's total can be split among.
+	maxPayerSplits = 20
+	// maxPayerIDLength caps the length of a payer ID.
+	maxPayerIDLength = 128
+)
+
+// PayerSplit is one payer's part of a bill's total: either a fixed Amount, taken off
+// the top, or a Percent of what is left after the fixed amounts.
+type PayerSplit struct {
+	PayerID string  `json:"payerId"`
+	Percent float64 `json:"percent,omitempty"`
+	Amount  float64 `json:"amount,omitempty"`
+}
+
+// PayerShare is the sub-invoice a payer receives when a split bill closes: what they
+// owe of the bill's total.
+type PayerShare struct {
+	ID      string  `json:"id"`
+	BillID  string  `json:"billId"`
+	PayerID string  `json:"payerId"`
+	Amount  float64 `json:"amount"`
+	// Percent is set on shares of a percentage split.
+	Percent   float64   `json:"percent,omitempty"`
+	Currency  string    `json:"currency"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// validatePayerSplits checks splits: each payer once, with either a positive amount or
+// a percentage, the percentages adding up to 100.
+func validatePayerSplits(splits []PayerSplit) error {
+	if len(splits) < 2 || len(splits) > maxPayerSplits {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "a bill can be split among 2 to %d payers, got %d", maxPayerSplits, len(splits))
+	}
+	seen := map[string]bool{}
+	var percent float64
+	for _, split := range splits {
+		if split.PayerID == "" || len(split.PayerID) > maxPayerIDLength {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "payerId is required and must be at most %d characters", maxPayerIDLength)
+		}
+		if seen[split.PayerID] {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "payer %s is listed more than once", split.PayerID)
+		}
+		seen[split.PayerID] = true
+		if (split.Percent == 0) == (split.Amount == 0) {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "payer %s must have either a percent or an amount", split.PayerID)
+		}
+		if split.Amount != 0 && (math.IsNaN(split.Amount) || math.IsInf(split.Amount, 0) || split.Amount < 0) {
+			return apierr.InvalidArgument(apierr.InvalidAmount, "amount of payer %s must be a positive number, got %v", split.PayerID, split.Amount)
+		}
+		if split.Percent != 0 && !(split.Percent > 0 && split.Percent <= 100) {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "percent of payer %s must be between 0 and 100, got %v", split.PayerID, split.Percent)
+		}
+		percent += split.Percent
+	}
+	// Percentages such as 33.33 + 33.33 + 33.34 do not add up exactly in floating point.
+	if math.Abs(percent-100) > 1e-9 {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "payer percentages must add up to 100, got %v", roundAmount(percent))
+	}
+	return nil
+}
+
+// payerShareID derives the ID of a payer's share of a bill, so a retried close issues
+// each share once.
+func payerShareID(billID, payerID string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte("feems/payer-share/"+billID+"/"+payerID)).String()
+}
+
+// payerShares splits total among splits, rounded by the bill's rounding policy. Fixed
+// amounts are taken first, in order and as far as the total goes; the rest is shared
+// by percentage, the last percentage payer receiving what rounding left over so the
+// shares add up to the total. Payers whose share comes to nothing receive none.
+func (b *Bill) payerShares(splits []PayerSplit, total float64, createdAt time.Time) []PayerShare {
+	if total <= 0 {
+		return nil
+	}
+	amounts := make([]float64, len(splits))
+	remaining := total
+	for i, split := range splits {
+		if split.Amount != 0 {
+			amounts[i] = b.round(math.Min(split.Amount, remaining))
+			remaining = b.round(remaining - amounts[i])
+		}
+	}
+	last := -1
+	shared := remaining
+	for i, split := range splits {
+		if split.Percent != 0 {
+			amounts[i] = b.round(shared * split.Percent / 100)
+			remaining = b.round(remaining - amounts[i])
+			last = i
+		}
+	}
+	if last >= 0 {
+		amounts[last] = b.round(amounts[last] + remaining)
+	}
+
+	var shares []PayerShare
+	for i, split := range splits {
+		if amounts[i] <= 0 {
+			continue
+		}
+		shares = append(shares, PayerShare{
+			ID:        payerShareID(b.ID, split.PayerID),
+			BillID:    b.ID,
+			PayerID:   split.PayerID,
+			Amount:    amounts[i],
+			Percent:   split.Percent,
+			Currency:  b.Currency,
+			CreatedAt: createdAt,
+		})
+	}
+	return shares
+}
+
+// paidByPayers reports whether the bill's total is owed by the payers it is split
+// among rather than by its customer: it has payer splits and a total to split.
+func (b *Bill) paidByPayers() bool {
+	return len(b.PayerSplits) > 0 && b.TotalAmount > 0
+}
+
+// ------ Workflow ------
+
+// SetPayerSplitsSignal replaces the payer splits of a bill that has not closed yet.
+type SetPayerSplitsSignal struct {
+	// Splits are the new splits; empty removes them.
+	Splits           []PayerSplit
+	RequestedByKeyID string
+	// IfVersion, when set, drops the signal unless the bill is at this version.
+	IfVersion int64
+}
+
+// setPayerSplits applies a SetPayerSplitsSignal.
+func (w *billWorkflow) setPayerSplits(signal SetPayerSplitsSignal) {
+	logger, bill := w.logger, w.bill
+
+	if w.outdated(SetPayerSplitsSignalName, signal.IfVersion) {
+		return
+	}
+	if !bill.isFinalizing() {
+		logger.Warn("SetPayerSplitsSignal received for a closed bill, ignoring.", "bill_id", bill.ID, "bill_status", bill.Status)
+		return
+	}
+	bill.PayerSplits = signal.Splits
+	w.touch()
+	logger.Info("Bill payer splits set", "bill_id", bill.ID, "payers", len(signal.Splits), "requested_by", signal.RequestedByKeyID)
+}
+
+// issuePayerShares splits the total of a bill that just closed among its payers and
+// saves a share for each, notifying the payer. If the shares cannot be saved, none is
+// issued and the bill is left unpaid for an operator to settle.
+func (w *billWorkflow) issuePayerShares() {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+	if len(bill.PayerSplits) == 0 {
+		return
+	}
+
+	shares := bill.payerShares(bill.PayerSplits, bill.TotalAmount, *bill.ClosedAt)
+	if len(shares) == 0 {
+		return
+	}
+	err := workflow.ExecuteActivity(ctx, SavePayerSharesActivityName, SavePayerSharesActivityParams{Shares: shares}).Get(ctx, nil)
+	if err != nil {
+		logger.Error("Failed to execute SavePayerSharesActivity, payer shares not issued", "bill_id", bill.ID, "error", err)
+		return
+	}
+	bill.PayerShares = shares
+	for _, share := range shares {
+		notify(ctx, Notification{
+			Event:      NotificationPayerShareIssued,
+			BillID:     bill.ID,
+			CustomerID: share.PayerID,
+			Message:    fmt.Sprintf("Your share of bill %s is %v %s.", bill.ID, share.Amount, share.Currency),
+		})
+	}
+	logger.Info("Payer shares issued", "bill_id", bill.ID, "shares", len(shares))
+}
+
+// SavePayerSharesActivityParams defines parameters for SavePayerSharesActivity.
+type SavePayerSharesActivityParams struct {
+	Shares []PayerShare
+}
+
+// SavePayerSharesActivity saves the payer shares of a closed bill. Shares already
+// saved by an earlier attempt are kept.
+func (a *Activities) SavePayerSharesActivity(ctx context.Context, params SavePayerSharesActivityParams) error {
+	err := a.DB.inTx(ctx, func(tx *tracedTx) error {
+		for _, share := range params.Shares {
+			_, err := tx.Exec(ctx, `
+                INSERT INTO payer_shares (id, bill_id, payer_id, amount, percent, currency, created_at)
+                VALUES ($1, $2, $3, $4, $5, $6, $7)
+                ON CONFLICT (id) DO NOTHING
+            `, share.ID, share.BillID, share.PayerID, share.Amount, nullIfZero(share.Percent), share.Currency, share.CreatedAt)
+			if err != nil {
+				return fmt.Errorf("failed to save share of payer %s in bill %s: %w", share.PayerID, share.BillID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("SavePayerSharesActivity: %w", err)
+	}
+	return nil
+}
+
+// ------ API ------
+
+// SetPayerSplitsRequest is the request payload for splitting a bill among payers.
+type SetPayerSplitsRequest struct {
+	// Splits are the payers and their parts; empty removes the split.
+	Splits []PayerSplit `json:"splits"`
+	// IfMatch, when set, applies the change only if the bill is still at this version.
+	IfMatch string `header:"If-Match"`
+}
+
+// PayerSplitsResponse is the response payload for a bill's payer splits.
+type PayerSplitsResponse struct {
+	RetryMetadata
+	BillID          string       `json:"billId"`
+	Splits          []PayerSplit `json:"splits"`
+	ConfirmationMsg string       `json:"confirmationMsg"`
+	// StateToken identifies the bill's state once this change is applied. Pass it to
+	// GetBill as minStateToken to read the bill with the change.
+	StateToken string `json:"stateToken,omitempty"`
+}
+
+// SetPayerSplits splits the total of a bill that has not closed yet among several
+// payers, by percentage or fixed amounts. When the bill closes, each payer is issued
+// a share of its total.
+//
+// encore:api auth method=PUT path=/bills/:billID/payer-splits
+func (s *Service) SetPayerSplits(ctx context.Context, billID string, params *SetPayerSplitsRequest) (*PayerSplitsResponse, error) {
+	if len(params.Splits) > 0 {
+		if err := validatePayerSplits(params.Splits); err != nil {
+			return nil, err
+		}
+	}
+	ifVersion, err := parseIfMatch(params.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	getResp, err := s.getBill(ctx, billID)
+	if err != nil {
+		return nil, err
+	}
+	bill := getResp.RetrievedBill
+	if !bill.isFinalizing() {
+		return nil, apierr.FailedPrecondition(apierr.BillClosed, "bill %s is %s and its payer splits can no longer change", billID, bill.Status)
+	}
+	if err := checkVersion(&bill, ifVersion); err != nil {
+		return nil, err
+	}
+
+	signal := SetPayerSplitsSignal{Splits: params.Splits, RequestedByKeyID: callerKeyID(ctx), IfVersion: ifVersion}
+	if err := s.temporalClient.SignalWorkflow(ctx, billWorkflowID(billID), "", SetPayerSplitsSignalName, signal); err != nil {
+		return nil, apierr.FromTemporal(err, apierr.BillNotFound, "bill %s not found", billID)
+	}
+	msg := "Payer splits set."
+	if len(params.Splits) == 0 {
+		msg = "Payer splits removed."
+	}
+	splits := params.Splits
+	if splits == nil {
+		splits = []PayerSplit{}
+	}
+	return &PayerSplitsResponse{BillID: billID, Splits: splits, ConfirmationMsg: msg, StateToken: nextStateToken(&bill)}, nil
+}
+
+// ListPayerSharesResponse lists the payer shares of a bill.
+type ListPayerSharesResponse struct {
+	BillID string       `json:"billId"`
+	Shares []PayerShare `json:"shares"`
+}
+
+// ListPayerShares lists the shares issued to the payers of a split bill once it closed.
+//
+// encore:api auth method=GET path=/bills/:billID/payer-shares
+func (s *Service) ListPayerShares(ctx context.Context, billID string) (*ListPayerSharesResponse, error) {
+	if err := s.checkBillVisible(ctx, billID); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(ctx, `
+        SELECT id, bill_id, payer_id, amount::float8, COALESCE(percent, 0)::float8, currency, created_at
+        FROM payer_shares WHERE bill_id = $1 ORDER BY created_at, payer_id
+    `, billID)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to list payer shares of bill %s", billID)
+	}
+	defer rows.Close()
+
+	resp := &ListPayerSharesResponse{BillID: billID, Shares: []PayerShare{}}
+	for rows.Next() {
+		var share PayerShare
+		if err := rows.Scan(&share.ID, &share.BillID, &share.PayerID, &share.Amount, &share.Percent, &share.Currency, &share.CreatedAt); err != nil {
+			return nil, apierr.Wrap(err, "failed to read payer shares of bill %s", billID)
+		}
+		resp.Shares = append(resp.Shares, share)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read payer shares of bill %s", billID)
+	}
+	return resp, nil
+}
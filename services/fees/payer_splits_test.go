@@ -0,0 +1,58 @@
+package fees
+
+import (
+	"testing"
+	"time"
+
+	"encore.app/apierr"
+	"encore.app/rounding"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePayerSplits(t *testing.T) {
+	require.NoError(t, validatePayerSplits([]PayerSplit{{PayerID: "a", Percent: 60}, {PayerID: "b", Percent: 40}}))
+	require.NoError(t, validatePayerSplits([]PayerSplit{{PayerID: "a", Amount: 100}, {PayerID: "b", Percent: 100}}))
+	require.NoError(t, validatePayerSplits([]PayerSplit{{PayerID: "a", Percent: 33.33}, {PayerID: "b", Percent: 33.33}, {PayerID: "c", Percent: 33.34}}))
+
+	for name, splits := range map[string][]PayerSplit{
+		"one payer":        {{PayerID: "a", Percent: 100}},
+		"short of 100":     {{PayerID: "a", Percent: 60}, {PayerID: "b", Percent: 30}},
+		"fixed only":       {{PayerID: "a", Amount: 10}, {PayerID: "b", Amount: 20}},
+		"duplicate payer":  {{PayerID: "a", Percent: 50}, {PayerID: "a", Percent: 50}},
+		"percent and sum":  {{PayerID: "a", Percent: 50, Amount: 5}, {PayerID: "b", Percent: 50}},
+		"negative amount":  {{PayerID: "a", Amount: -5}, {PayerID: "b", Percent: 100}},
+		"missing payer ID": {{Percent: 50}, {PayerID: "b", Percent: 50}},
+	} {
+		err := validatePayerSplits(splits)
+		require.Error(t, err, name)
+		require.Contains(t, []apierr.Reason{apierr.InvalidParameter, apierr.InvalidAmount}, apierr.ReasonOf(err), name)
+	}
+}
+
+// TestPayerShares tests that fixed amounts come off the top, the rest is shared by
+// percentage, and rounding leftovers go to the last percentage payer.
+func TestPayerShares(t *testing.T) {
+	policy := rounding.ForCurrency("USD", rounding.HalfUp)
+	bill := &Bill{ID: "bill-1", Currency: "USD", Rounding: &policy}
+	now := time.Now()
+
+	shares := bill.payerShares([]PayerSplit{{PayerID: "hq", Amount: 10}, {PayerID: "a", Percent: 33.33}, {PayerID: "b", Percent: 33.33}, {PayerID: "c", Percent: 33.34}}, 110, now)
+	require.Len(t, shares, 4)
+	amounts := map[string]float64{}
+	var sum float64
+	for _, s := range shares {
+		amounts[s.PayerID] = s.Amount
+		sum += s.Amount
+		require.Equal(t, payerShareID("bill-1", s.PayerID), s.ID)
+	}
+	require.Equal(t, map[string]float64{"hq": 10, "a": 33.33, "b": 33.33, "c": 33.34}, amounts)
+	require.InDelta(t, 110, sum, 1e-9)
+
+	// Fixed amounts beyond the total leave nothing to share.
+	shares = bill.payerShares([]PayerSplit{{PayerID: "hq", Amount: 50}, {PayerID: "a", Percent: 100}}, 20, now)
+	require.Len(t, shares, 1)
+	require.Equal(t, "hq", shares[0].PayerID)
+	require.True(t, shares[0].Amount == 20)
+
+	require.Empty(t, bill.payerShares([]PayerSplit{{PayerID: "a", Percent: 50}, {PayerID: "b", Percent: 50}}, 0, now))
+}
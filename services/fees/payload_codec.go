@@ -0,0 +1,125 @@
+package fees
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/temporal"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// payloadEncryptedEncoding marks payloads encrypted by payloadCodec.
+	payloadEncryptedEncoding = "binary/encrypted"
+	// payloadKeyIDMetadata names the key a payload was encrypted with.
+	payloadKeyIDMetadata = "encryption-key-id"
+)
+
+// payloadCodec encrypts Temporal payloads with AES-256-GCM, so customer IDs, line
+// item descriptions, and the rest of the workflows' inputs, results, signals, and
+// errors are not stored in Temporal in plaintext. Each payload records the ID of its
+// key, so keys can be rotated; payloads written before encryption was turned on are
+// passed through unchanged.
+type payloadCodec struct {
+	activeID string
+	keys     map[string]cipher.AEAD
+}
+
+// newPayloadCodec returns a codec encrypting with the first of keys.
+func newPayloadCodec(keys []EncryptionKey) (*payloadCodec, error) {
+	aeads, err := newAEADs(keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Temporal payload keys: %w", err)
+	}
+	return &payloadCodec{activeID: keys[0].ID, keys: aeads}, nil
+}
+
+// Encode encrypts payloads with the active key. The key ID is bound to the ciphertext,
+// so a payload cannot be passed off as encrypted with another key.
+func (c *payloadCodec) Encode(payloads []*commonpb.Payload) ([]*commonpb.Payload, error) {
+	aead := c.keys[c.activeID]
+	result := make([]*commonpb.Payload, len(payloads))
+	for i, p := range payloads {
+		plaintext, err := proto.Marshal(p)
+		if err != nil {
+			return payloads, fmt.Errorf("failed to encode payload: %w", err)
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return payloads, fmt.Errorf("failed to encrypt payload: %w", err)
+		}
+		result[i] = &commonpb.Payload{
+			Metadata: map[string][]byte{
+				converter.MetadataEncoding: []byte(payloadEncryptedEncoding),
+				payloadKeyIDMetadata:       []byte(c.activeID),
+			},
+			Data: aead.Seal(nonce, nonce, plaintext, []byte(c.activeID)),
+		}
+	}
+	return result, nil
+}
+
+// Decode decrypts payloads encrypted with any of the codec's keys.
+func (c *payloadCodec) Decode(payloads []*commonpb.Payload) ([]*commonpb.Payload, error) {
+	result := make([]*commonpb.Payload, len(payloads))
+	for i, p := range payloads {
+		if string(p.GetMetadata()[converter.MetadataEncoding]) != payloadEncryptedEncoding {
+			result[i] = p
+			continue
+		}
+		keyID := string(p.GetMetadata()[payloadKeyIDMetadata])
+		aead, ok := c.keys[keyID]
+		if !ok {
+			return payloads, fmt.Errorf("payload was encrypted with unknown key %q", keyID)
+		}
+		data := p.GetData()
+		if len(data) < aead.NonceSize() {
+			return payloads, fmt.Errorf("encrypted payload is truncated")
+		}
+		plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(keyID))
+		if err != nil {
+			return payloads, fmt.Errorf("failed to decrypt payload with key %q: %w", keyID, err)
+		}
+		decoded := &commonpb.Payload{}
+		if err := proto.Unmarshal(plaintext, decoded); err != nil {
+			return payloads, fmt.Errorf("failed to decode decrypted payload: %w", err)
+		}
+		result[i] = decoded
+	}
+	return result, nil
+}
+
+// payloadConverters returns the data converter and failure converter that encrypt
+// payloads with codec. Failure messages and stack traces are encrypted too, since
+// they may name customers.
+func payloadConverters(codec converter.PayloadCodec) (converter.DataConverter, converter.FailureConverter) {
+	dataConverter := converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), codec)
+	failureConverter := temporal.NewDefaultFailureConverter(temporal.DefaultFailureConverterOptions{
+		DataConverter:          dataConverter,
+		EncodeCommonAttributes: true,
+	})
+	return dataConverter, failureConverter
+}
+
+// PayloadCodec is the codec server for encrypted payloads. Operators point the Temporal
+// UI or CLI at /codec, which POSTs payloads to /codec/decode (or /codec/encode) to have
+// them decrypted for display. It requires the payloads:decode scope, which exposes the
+// payloads of every tenant.
+//
+// encore:api auth raw method=POST path=/codec/*op
+func (s *Service) PayloadCodec(w http.ResponseWriter, req *http.Request) {
+	// Raw endpoints write their own response, so the scope is checked here.
+	if err := checkScope("PayloadCodec", caller(req.Context())); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if s.payloadCodec == nil {
+		http.Error(w, "Temporal payloads are not encrypted", http.StatusNotFound)
+		return
+	}
+	converter.NewPayloadCodecHTTPHandler(s.payloadCodec).ServeHTTP(w, req)
+}
@@ -0,0 +1,92 @@
+package fees
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/converter"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func testEncryptionKey(id string, fill byte) string {
+	return id + ":" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, encryptionKeySize))
+}
+
+// TestPayloadCodec tests that payloads round-trip through encryption without leaking
+// their contents, that rotated keys still decrypt old payloads, and that tampered
+// payloads are rejected.
+func TestPayloadCodec(t *testing.T) {
+	oldKeys, err := parseEncryptionKeys(testEncryptionKey("k1", 1))
+	require.NoError(t, err)
+	oldCodec, err := newPayloadCodec(oldKeys)
+	require.NoError(t, err)
+	dc, _ := payloadConverters(oldCodec)
+	payload, err := dc.ToPayload(Bill{CustomerID: "cust-secret"})
+	require.NoError(t, err)
+	require.NotContains(t, string(payload.GetData()), "cust-secret")
+	require.Equal(t, "k1", string(payload.GetMetadata()[payloadKeyIDMetadata]))
+
+	keys, err := parseEncryptionKeys(testEncryptionKey("k2", 2) + "," + testEncryptionKey("k1", 1))
+	require.NoError(t, err)
+	codec, err := newPayloadCodec(keys)
+	require.NoError(t, err)
+	dc, _ = payloadConverters(codec)
+	var bill Bill
+	require.NoError(t, dc.FromPayload(payload, &bill))
+	require.Equal(t, "cust-secret", bill.CustomerID)
+
+	plain, err := converter.GetDefaultDataConverter().ToPayload("unencrypted")
+	require.NoError(t, err)
+	decoded, err := codec.Decode([]*commonpb.Payload{plain})
+	require.NoError(t, err)
+	require.Same(t, plain, decoded[0])
+
+	_, err = oldCodec.Decode(mustEncode(t, codec, plain))
+	require.ErrorContains(t, err, `unknown key "k2"`)
+	tampered := mustEncode(t, codec, plain)
+	tampered[0].Data[len(tampered[0].Data)-1] ^= 1
+	_, err = codec.Decode(tampered)
+	require.Error(t, err)
+
+	for _, v := range []string{"k1", "k1:notbase64", "k1:" + base64.StdEncoding.EncodeToString([]byte("short")), testEncryptionKey("k1", 1) + "," + testEncryptionKey("k1", 2)} {
+		_, err := parseEncryptionKeys(v)
+		require.Error(t, err, v)
+	}
+}
+
+func mustEncode(t *testing.T, codec *payloadCodec, p *commonpb.Payload) []*commonpb.Payload {
+	encoded, err := codec.Encode([]*commonpb.Payload{p})
+	require.NoError(t, err)
+	return encoded
+}
+
+// TestPayloadCodecServer tests that the codec server decrypts payloads for keys with
+// the payloads:decode scope only.
+func TestPayloadCodecServer(t *testing.T) {
+	keys, err := parseEncryptionKeys(testEncryptionKey("k1", 1))
+	require.NoError(t, err)
+	codec, err := newPayloadCodec(keys)
+	require.NoError(t, err)
+	s := &Service{payloadCodec: codec}
+	plain, err := converter.GetDefaultDataConverter().ToPayload("cust-secret")
+	require.NoError(t, err)
+	body, err := protojson.Marshal(&commonpb.Payloads{Payloads: mustEncode(t, codec, plain)})
+	require.NoError(t, err)
+
+	decode := func(scopes ...Scope) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/codec/decode", bytes.NewReader(body))
+		req = req.WithContext(withCaller(req.Context(), &AuthData{KeyID: "key-1", Scopes: scopes}))
+		w := httptest.NewRecorder()
+		s.PayloadCodec(w, req)
+		return w
+	}
+	require.Equal(t, http.StatusForbidden, decode(ScopeBillsRead).Code)
+	w := decode(ScopePayloadsDecode)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), base64.StdEncoding.EncodeToString(plain.GetData()))
+}
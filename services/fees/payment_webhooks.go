@@ -0,0 +1,485 @@
+package fees
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev"
+	"encore.dev/storage/sqldb"
+	"go.temporal.io/sdk/workflow"
+)
+
+// PaymentWebhookEventType is the kind of payment-provider callback.
+type PaymentWebhookEventType string
+
+const (
+	// PaymentWebhookSucceeded confirms a charge, such as one whose outcome the gateway
+	// call timed out before learning.
+	PaymentWebhookSucceeded PaymentWebhookEventType = "payment.succeeded"
+	// PaymentWebhookFailed reports a charge that failed, including one that succeeded
+	// and was later returned, such as a bank debit.
+	PaymentWebhookFailed PaymentWebhookEventType = "payment.failed"
+	// PaymentWebhookChargeback reports that the customer disputed a successful charge.
+	PaymentWebhookChargeback PaymentWebhookEventType = "payment.chargeback"
+	// PaymentWebhookDisputeWon reports that the provider decided a chargeback for the
+	// biller.
+	PaymentWebhookDisputeWon PaymentWebhookEventType = "dispute.won"
+	// PaymentWebhookDisputeLost reports that the provider decided a chargeback for the
+	// customer, who keeps the funds.
+	PaymentWebhookDisputeLost PaymentWebhookEventType = "dispute.lost"
+)
+
+// IsValid reports whether t is a known event type.
+func (t PaymentWebhookEventType) IsValid() bool {
+	switch t {
+	case PaymentWebhookSucceeded, PaymentWebhookFailed, PaymentWebhookChargeback,
+		PaymentWebhookDisputeWon, PaymentWebhookDisputeLost:
+		return true
+	}
+	return false
+}
+
+const (
+	// PaymentWebhookSignatureHeader carries a callback's signature:
+	// "t=<unix seconds>,v1=<hex HMAC-SHA256 of '<t>.<body>'>". Several v1 values may be
+	// given while the provider rotates its secret.
+	PaymentWebhookSignatureHeader = "Webhook-Signature"
+
+	// paymentWebhookTolerance bounds how far a callback's signing time may be from now,
+	// so a captured callback cannot be replayed later.
+	paymentWebhookTolerance = 5 * time.Minute
+	// maxPaymentWebhookBodySize caps the size of a callback's body.
+	maxPaymentWebhookBodySize = 64 << 10
+)
+
+// PaymentWebhookEvent is the body of a payment-provider callback. Each provider's
+// gateway integration delivers its callbacks in this form.
+type PaymentWebhookEvent struct {
+	// ID is the provider's ID of the event, which redeliveries repeat.
+	ID   string                  `json:"id"`
+	Type PaymentWebhookEventType `json:"type"`
+	// PaymentID is the payment the event concerns: the ChargeRequest.PaymentID the
+	// provider was charged with.
+	PaymentID string `json:"paymentId"`
+	// BillID is the payment's bill, when the provider echoes it. Otherwise it is looked
+	// up from the payment.
+	BillID           string `json:"billId,omitempty"`
+	GatewayReference string `json:"gatewayReference,omitempty"`
+	// Reason explains a failure or chargeback.
+	Reason string `json:"reason,omitempty"`
+	// EvidenceDueBy is when evidence contesting a chargeback is due. Without it, a
+	// chargeback may be contested for seven days.
+	EvidenceDueBy *time.Time `json:"evidenceDueBy,omitempty"`
+}
+
+// validate rejects events that cannot be applied to a payment.
+func (e *PaymentWebhookEvent) validate() error {
+	switch {
+	case e.ID == "":
+		return fmt.Errorf("id is required")
+	case !e.Type.IsValid():
+		return fmt.Errorf("unknown event type %q", e.Type)
+	case e.PaymentID == "":
+		return fmt.Errorf("paymentId is required")
+	}
+	return nil
+}
+
+// PaymentWebhookSignal delivers a payment-provider callback to the bill's workflow.
+type PaymentWebhookSignal struct {
+	Provider         string
+	EventID          string
+	Type             PaymentWebhookEventType
+	PaymentID        string
+	GatewayReference string
+	Reason           string
+	EvidenceDueBy    *time.Time
+}
+
+// PaymentWebhookOutcome says what became of a callback.
+type PaymentWebhookOutcome string
+
+const (
+	// PaymentWebhookAccepted callbacks were delivered to their bill's workflow.
+	PaymentWebhookAccepted PaymentWebhookOutcome = "accepted"
+	// PaymentWebhookDuplicate callbacks were delivered before.
+	PaymentWebhookDuplicate PaymentWebhookOutcome = "duplicate"
+	// PaymentWebhookIgnored callbacks name a payment or bill the service does not know.
+	// They are recorded with the reason and not retried.
+	PaymentWebhookIgnored PaymentWebhookOutcome = "ignored"
+)
+
+// PaymentWebhookResponse is the response to a payment-provider callback.
+type PaymentWebhookResponse struct {
+	EventID string                `json:"eventId"`
+	Outcome PaymentWebhookOutcome `json:"outcome"`
+}
+
+// ------ Signatures ------
+
+// paymentProviderPattern matches provider names, which appear in webhook URLs.
+var paymentProviderPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// loadPaymentWebhookSecrets reads FEES_PAYMENT_WEBHOOK_SECRETS (or the file named by
+// FEES_PAYMENT_WEBHOOK_SECRETS_FILE).
+func loadPaymentWebhookSecrets() (map[string][]string, error) {
+	v, err := secretFromEnv("FEES_PAYMENT_WEBHOOK_SECRETS", "payment webhook secrets")
+	if err != nil || v == "" {
+		return nil, err
+	}
+	secrets, err := parsePaymentWebhookSecrets(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FEES_PAYMENT_WEBHOOK_SECRETS: %w", err)
+	}
+	return secrets, nil
+}
+
+// parsePaymentWebhookSecrets parses "<provider>:<secret>,..." into secrets by provider.
+// A provider listed more than once has several secrets, as during a rotation.
+func parsePaymentWebhookSecrets(v string) (map[string][]string, error) {
+	secrets := make(map[string][]string)
+	for _, entry := range strings.Split(v, ",") {
+		provider, secret, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || secret == "" {
+			return nil, fmt.Errorf("invalid entry: must be <provider>:<secret>")
+		}
+		if !paymentProviderPattern.MatchString(provider) {
+			return nil, fmt.Errorf("invalid provider %q: use lowercase letters, digits, '-' and '_'", provider)
+		}
+		secrets[provider] = append(secrets[provider], secret)
+	}
+	return secrets, nil
+}
+
+// signPaymentWebhook returns the v1 signature of body signed at t with secret.
+func signPaymentWebhook(secret string, t int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", t)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyPaymentWebhook checks that header signs body with one of secrets, at a time
+// within paymentWebhookTolerance of now.
+func verifyPaymentWebhook(secrets []string, header string, body []byte, now time.Time) error {
+	var signedAt int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			t, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid signature timestamp")
+			}
+			signedAt = t
+		case "v1":
+			signatures = append(signatures, v)
+		}
+	}
+	if signedAt == 0 || len(signatures) == 0 {
+		return fmt.Errorf("missing or malformed %s header", PaymentWebhookSignatureHeader)
+	}
+	if age := now.Sub(time.Unix(signedAt, 0)); age > paymentWebhookTolerance || age < -paymentWebhookTolerance {
+		return fmt.Errorf("signature timestamp is outside the tolerance of %s", paymentWebhookTolerance)
+	}
+	for _, secret := range secrets {
+		expected := signPaymentWebhook(secret, signedAt, body)
+		for _, sig := range signatures {
+			if hmac.Equal([]byte(sig), []byte(expected)) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("signature does not match")
+}
+
+// ------ API ------
+
+// PaymentWebhook receives a payment provider's callbacks about payments that succeeded,
+// failed, or were charged back after the gateway call returned. It is unauthenticated:
+// callbacks are verified by their signature with the provider's secret in
+// FEES_PAYMENT_WEBHOOK_SECRETS. Each event is recorded in an inbox and signaled to its
+// bill's workflow once; redeliveries are acknowledged without effect. Errors that a
+// redelivery could fix answer 5xx, so the provider retries.
+//
+// encore:api public raw method=POST path=/webhooks/payments/:provider
+func (s *Service) PaymentWebhook(w http.ResponseWriter, req *http.Request) {
+	s.handlePaymentWebhook(w, req, encore.CurrentRequest().PathParams.Get("provider"))
+}
+
+// handlePaymentWebhook serves PaymentWebhook for provider.
+func (s *Service) handlePaymentWebhook(w http.ResponseWriter, req *http.Request, provider string) {
+	secrets := s.cfg.PaymentWebhookSecrets[provider]
+	if len(secrets) == 0 {
+		http.Error(w, "unknown payment provider", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxPaymentWebhookBodySize+1))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxPaymentWebhookBodySize {
+		http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := verifyPaymentWebhook(secrets, req.Header.Get(PaymentWebhookSignatureHeader), body, s.clock.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var event PaymentWebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, "invalid event: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := event.validate(); err != nil {
+		http.Error(w, "invalid event: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := req.Context()
+	outcome, err := s.receivePaymentWebhook(ctx, provider, event)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to process payment webhook", "provider", provider, "event_id", event.ID, "payment_id", event.PaymentID, "error", err)
+		http.Error(w, "failed to process event", http.StatusInternalServerError)
+		return
+	}
+	loggerFrom(ctx).Info("Payment webhook received", "provider", provider, "event_id", event.ID, "type", event.Type, "payment_id", event.PaymentID, "outcome", outcome)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PaymentWebhookResponse{EventID: event.ID, Outcome: outcome})
+}
+
+// receivePaymentWebhook records event in the inbox and signals it to its bill's
+// workflow, unless an earlier delivery already did.
+func (s *Service) receivePaymentWebhook(ctx context.Context, provider string, event PaymentWebhookEvent) (PaymentWebhookOutcome, error) {
+	res, err := s.db.Exec(ctx, `
+        INSERT INTO payment_webhook_events (provider, event_id, type, payment_id, bill_id)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (provider, event_id) DO NOTHING
+    `, provider, event.ID, event.Type, event.PaymentID, nullIfEmpty(event.BillID))
+	if err != nil {
+		return "", fmt.Errorf("failed to record event: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		var processed bool
+		err := s.db.QueryRow(ctx, `
+            SELECT processed_at IS NOT NULL FROM payment_webhook_events
+            WHERE provider = $1 AND event_id = $2
+        `, provider, event.ID).Scan(&processed)
+		if err != nil {
+			return "", fmt.Errorf("failed to read event: %w", err)
+		}
+		if processed {
+			return PaymentWebhookDuplicate, nil
+		}
+		// An earlier delivery failed before the event was signaled; try again.
+	}
+
+	billID := event.BillID
+	if billID == "" {
+		err := s.db.QueryRow(ctx, `SELECT bill_id FROM payments WHERE id = $1`, event.PaymentID).Scan(&billID)
+		if errors.Is(err, sqldb.ErrNoRows) {
+			return PaymentWebhookIgnored, s.finishPaymentWebhook(ctx, provider, event.ID, "", fmt.Sprintf("payment %s not found", event.PaymentID))
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to look up payment %s: %w", event.PaymentID, err)
+		}
+	}
+	getResp, err := s.getBill(ctx, billID)
+	if apierr.ReasonOf(err) == apierr.BillNotFound {
+		return PaymentWebhookIgnored, s.finishPaymentWebhook(ctx, provider, event.ID, billID, fmt.Sprintf("bill %s not found", billID))
+	}
+	if err != nil {
+		return "", err
+	}
+	bill := getResp.RetrievedBill
+
+	signal := PaymentWebhookSignal{
+		Provider:         provider,
+		EventID:          event.ID,
+		Type:             event.Type,
+		PaymentID:        event.PaymentID,
+		GatewayReference: event.GatewayReference,
+		Reason:           event.Reason,
+		EvidenceDueBy:    event.EvidenceDueBy,
+	}
+	if err := s.signalSettlement(ctx, &bill, PaymentWebhookSignalName, signal); err != nil {
+		return "", apierr.FromTemporal(err, apierr.BillNotFound, "bill %s not found", billID)
+	}
+	return PaymentWebhookAccepted, s.finishPaymentWebhook(ctx, provider, event.ID, billID, "")
+}
+
+// finishPaymentWebhook marks an inbox event processed, with the reason it was ignored
+// if it was.
+func (s *Service) finishPaymentWebhook(ctx context.Context, provider, eventID, billID, reason string) error {
+	_, err := s.db.Exec(ctx, `
+        UPDATE payment_webhook_events
+        SET processed_at = NOW(), bill_id = COALESCE($3, bill_id), error = $4
+        WHERE provider = $1 AND event_id = $2
+    `, provider, eventID, nullIfEmpty(billID), nullIfEmpty(reason))
+	if err != nil {
+		return fmt.Errorf("failed to mark event %s processed: %w", eventID, err)
+	}
+	return nil
+}
+
+// ------ Workflow ------
+
+// applyPaymentWebhook applies a payment-provider callback to the bill's payment. Events
+// that do not change the payment, such as a confirmation of a payment that already
+// succeeded, are ignored.
+func (w *billWorkflow) applyPaymentWebhook(signal PaymentWebhookSignal) {
+	payment := w.bill.payment(signal.PaymentID)
+	if payment == nil {
+		w.logger.Warn("Payment webhook for an unknown payment, ignoring.", "bill_id", w.bill.ID, "payment_id", signal.PaymentID, "event_id", signal.EventID)
+		return
+	}
+	switch signal.Type {
+	case PaymentWebhookSucceeded:
+		w.confirmPayment(payment, signal)
+	case PaymentWebhookFailed:
+		w.failPayment(payment, signal)
+	case PaymentWebhookChargeback:
+		w.disputePayment(payment, signal)
+	case PaymentWebhookDisputeWon, PaymentWebhookDisputeLost:
+		w.resolveDispute(payment, signal)
+	}
+}
+
+// confirmPayment marks a pending or failed payment succeeded and allocates it, as
+// collectPayment does for a charge that succeeds. A payment that was reversed stays
+// failed.
+func (w *billWorkflow) confirmPayment(payment *Payment, signal PaymentWebhookSignal) {
+	logger, bill := w.logger, w.bill
+
+	if payment.Status.allocated() || bill.reversed(payment.ID) {
+		logger.Info("Payment already settled, ignoring confirmation.", "bill_id", bill.ID, "payment_id", payment.ID, "payment_status", payment.Status)
+		return
+	}
+	if !bill.isPayable() || bill.round(payment.Amount) > bill.balanceDue() {
+		logger.Warn("Confirmed payment does not fit the bill's balance, ignoring.", "bill_id", bill.ID, "payment_id", payment.ID, "bill_status", bill.Status, "amount", payment.Amount)
+		return
+	}
+
+	completedAt := workflow.Now(w.ctx)
+	payment.Status = PaymentStatusSucceeded
+	payment.FailureReason = ""
+	payment.CompletedAt = &completedAt
+	if signal.GatewayReference != "" {
+		payment.GatewayReference = signal.GatewayReference
+	}
+	bill.allocatePayment(*payment)
+	w.touch()
+	logger.Info("Payment confirmed by provider", "bill_id", bill.ID, "payment_id", payment.ID, "event_id", signal.EventID)
+	w.savePayment(*payment, false)
+
+	if w.cancelDunning != nil {
+		w.cancelDunning()
+	}
+	switch {
+	case *bill.BalanceDue <= 0:
+		w.setStatus(BillStatusPaid)
+	case bill.Status == BillStatusClosed || bill.Status == BillStatusPaymentFailed:
+		w.setStatus(BillStatusPartiallyPaid)
+	}
+}
+
+// failPayment marks a pending payment failed. A gateway payment that had succeeded is
+// reversed: its amount is due again, the bill becomes PAYMENT_FAILED, and dunning
+// starts if it is configured.
+func (w *billWorkflow) failPayment(payment *Payment, signal PaymentWebhookSignal) {
+	logger, bill := w.logger, w.bill
+
+	reverse := payment.Status == PaymentStatusSucceeded && payment.Method == ""
+	if payment.Status != PaymentStatusPending && !reverse {
+		logger.Info("Payment cannot fail in its status, ignoring.", "bill_id", bill.ID, "payment_id", payment.ID, "payment_status", payment.Status, "event_id", signal.EventID)
+		return
+	}
+
+	failedAt := workflow.Now(w.ctx)
+	payment.Status = PaymentStatusFailed
+	payment.FailureReason = signal.Reason
+	payment.CompletedAt = &failedAt
+	if reverse {
+		bill.reversePayment(*payment, failedAt)
+	}
+	w.touch()
+	logger.Warn("Payment failed according to provider", "bill_id", bill.ID, "payment_id", payment.ID, "reversed", reverse, "reason", signal.Reason, "event_id", signal.EventID)
+	w.savePayment(*payment, reverse)
+	if !reverse {
+		return
+	}
+
+	switch bill.Status {
+	case BillStatusPaid, BillStatusPartiallyPaid, BillStatusClosed:
+		w.setStatus(BillStatusPaymentFailed)
+	}
+	if len(w.params.DunningSchedule) > 0 && w.dunning == nil && bill.Status == BillStatusPaymentFailed {
+		w.startDunning()
+	}
+}
+
+// disputePayment marks a successful payment charged back and opens a dispute for it.
+// Its funds stay allocated until the dispute is decided.
+func (w *billWorkflow) disputePayment(payment *Payment, signal PaymentWebhookSignal) {
+	logger, bill := w.logger, w.bill
+
+	if payment.Status != PaymentStatusSucceeded {
+		logger.Info("Only successful payments can be charged back, ignoring.", "bill_id", bill.ID, "payment_id", payment.ID, "payment_status", payment.Status, "event_id", signal.EventID)
+		return
+	}
+	payment.Status = PaymentStatusDisputed
+	payment.FailureReason = signal.Reason
+	w.touch()
+	logger.Warn("Payment charged back", "bill_id", bill.ID, "payment_id", payment.ID, "reason", signal.Reason, "event_id", signal.EventID)
+	w.savePayment(*payment, false)
+	w.openDispute(payment, signal)
+}
+
+// savePayment persists a payment changed by a provider callback.
+func (w *billWorkflow) savePayment(payment Payment, reversed bool) {
+	params := RecordPaymentActivityParams{
+		PaymentID:        payment.ID,
+		BillID:           w.bill.ID,
+		Amount:           payment.Amount,
+		Currency:         w.bill.Currency,
+		Status:           payment.Status,
+		GatewayReference: payment.GatewayReference,
+		FailureReason:    payment.FailureReason,
+		Method:           payment.Method,
+		Reversed:         reversed,
+	}
+	if payment.CreatedAt != nil {
+		params.CreatedAt = *payment.CreatedAt
+	}
+	if payment.CompletedAt != nil {
+		params.CompletedAt = *payment.CompletedAt
+	}
+	err := workflow.ExecuteActivity(w.ctx, RecordPaymentActivityName, params).Get(w.ctx, nil)
+	if err != nil {
+		w.logger.Error("Failed to execute RecordPaymentActivity", "bill_id", w.bill.ID, "payment_id", payment.ID, "error", err)
+	}
+}
+
+// reversed reports whether the bill's payment paymentID was allocated and then reversed.
+func (b *Bill) reversed(paymentID string) bool {
+	for _, a := range b.Allocations {
+		if a.PaymentID == paymentID && a.Amount < 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package fees
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePaymentWebhookSecrets(t *testing.T) {
+	secrets, err := parsePaymentWebhookSecrets("acme:s1, acme:s2,globex:s3")
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"acme": {"s1", "s2"}, "globex": {"s3"}}, secrets)
+
+	for _, v := range []string{"acme", "acme:", ":s1", "Acme:s1", "../x:s1"} {
+		_, err := parsePaymentWebhookSecrets(v)
+		require.Error(t, err, v)
+	}
+}
+
+// TestVerifyPaymentWebhook tests that callbacks verify under any of the provider's
+// secrets, and that tampered, stale, or unsigned ones do not.
+func TestVerifyPaymentWebhook(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	body := []byte(`{"id":"evt-1"}`)
+	signed := func(secret string, at time.Time) string {
+		return fmt.Sprintf("t=%d,v1=%s", at.Unix(), signPaymentWebhook(secret, at.Unix(), body))
+	}
+
+	require.NoError(t, verifyPaymentWebhook([]string{"s1"}, signed("s1", now), body, now))
+	require.NoError(t, verifyPaymentWebhook([]string{"s2", "s1"}, signed("s1", now.Add(-time.Minute)), body, now))
+	require.NoError(t, verifyPaymentWebhook([]string{"s2"}, signed("s1", now)+",v1="+signPaymentWebhook("s2", now.Unix(), body), body, now))
+
+	require.ErrorContains(t, verifyPaymentWebhook([]string{"s2"}, signed("s1", now), body, now), "does not match")
+	require.ErrorContains(t, verifyPaymentWebhook([]string{"s1"}, signed("s1", now), []byte(`{"id":"evt-2"}`), now), "does not match")
+	require.ErrorContains(t, verifyPaymentWebhook([]string{"s1"}, signed("s1", now.Add(-10*time.Minute)), body, now), "tolerance")
+	require.ErrorContains(t, verifyPaymentWebhook([]string{"s1"}, "", body, now), "missing")
+	require.ErrorContains(t, verifyPaymentWebhook([]string{"s1"}, "t=abc,v1=00", body, now), "timestamp")
+}
+
+// TestPaymentWebhook_Rejects tests that callbacks are refused before they reach the
+// inbox when their provider is unknown, their signature is wrong, or they are malformed.
+func TestPaymentWebhook_Rejects(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	s := &Service{clock: clock, cfg: &Config{PaymentWebhookSecrets: map[string][]string{"acme": {"s1"}}}}
+	post := func(provider, body, signature string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/payments/"+provider, strings.NewReader(body))
+		req.Header.Set(PaymentWebhookSignatureHeader, signature)
+		w := httptest.NewRecorder()
+		s.handlePaymentWebhook(w, req, provider)
+		return w
+	}
+	signAt := func(body string, at time.Time) string {
+		return fmt.Sprintf("t=%d,v1=%s", at.Unix(), signPaymentWebhook("s1", at.Unix(), []byte(body)))
+	}
+	sign := func(body string) string { return signAt(body, clock.Now()) }
+
+	event := `{"id":"evt-1","type":"payment.succeeded","paymentId":"pay-1"}`
+	require.Equal(t, http.StatusNotFound, post("globex", event, sign(event)).Code)
+	require.Equal(t, http.StatusUnauthorized, post("acme", event, "t=1,v1=00").Code)
+	// The tolerance is measured on the service's clock, not the wall clock.
+	require.Equal(t, http.StatusUnauthorized, post("acme", event, signAt(event, time.Now())).Code)
+	require.Equal(t, http.StatusRequestEntityTooLarge, post("acme", strings.Repeat(" ", maxPaymentWebhookBodySize+1), "").Code)
+
+	for _, body := range []string{
+		`not json`,
+		`{"type":"payment.succeeded","paymentId":"pay-1"}`,
+		`{"id":"evt-1","type":"payment.refunded","paymentId":"pay-1"}`,
+		`{"id":"evt-1","type":"payment.failed"}`,
+	} {
+		require.Equal(t, http.StatusBadRequest, post("acme", body, sign(body)).Code, body)
+	}
+}
@@ -0,0 +1,314 @@
+package fees
+
+import (
+	"errors"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// DefaultPaymentMaxAttempts bounds gateway retries for transient (non-decline) failures.
+const DefaultPaymentMaxAttempts = 5
+
+// PaymentWorkflowParams defines the parameters for starting the PaymentWorkflow.
+type PaymentWorkflowParams struct {
+	PaymentID   string
+	BillID      string
+	CustomerID  string
+	Amount      float64
+	Currency    string
+	MaxAttempts int32
+	// RequestedByKeyID is the API key that requested the payment; empty for automatic collection and dunning.
+	RequestedByKeyID string
+}
+
+// PaymentResult is the outcome returned by the PaymentWorkflow.
+type PaymentResult struct {
+	PaymentID        string
+	Status           PaymentStatus
+	GatewayReference string
+	FailureReason    string
+	CompletedAt      time.Time
+}
+
+// ChargePaymentActivityParams defines parameters for ChargePaymentActivity.
+type ChargePaymentActivityParams struct {
+	PaymentID  string
+	BillID     string
+	CustomerID string
+	Amount     float64
+	Currency   string
+}
+
+// RecordPaymentActivityParams defines parameters for RecordPaymentActivity.
+type RecordPaymentActivityParams struct {
+	PaymentID        string
+	BillID           string
+	Amount           float64
+	Currency         string
+	Status           PaymentStatus
+	GatewayReference string
+	FailureReason    string
+	CreatedAt        time.Time
+	CompletedAt      time.Time
+	ActorKeyID       string
+	// Method is how a recorded payment was received; empty for gateway charges.
+	Method string
+	// Reversed marks a payment that succeeded before the provider reported it failed;
+	// its receipt is reversed in the ledger.
+	Reversed bool
+}
+
+// PaymentWorkflow charges a closed bill through the payment gateway. Transient gateway
+// errors are retried; declines are final. The workflow itself only fails on
+// determinism or cancellation problems — payment outcomes are returned as a PaymentResult.
+func PaymentWorkflow(ctx workflow.Context, params *PaymentWorkflowParams) (*PaymentResult, error) {
+	logger := workflow.GetLogger(ctx)
+
+	maxAttempts := params.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultPaymentMaxAttempts
+	}
+
+	createdAt := workflow.Now(ctx)
+	// Each payment sets its own attempts, so the charge overrides the activity's policy.
+	chargeCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:        time.Second,
+			BackoffCoefficient:     2.0,
+			MaximumInterval:        time.Minute,
+			MaximumAttempts:        maxAttempts,
+			NonRetryableErrorTypes: []string{PaymentDeclinedErrorType},
+		},
+	})
+
+	logger.Info("PaymentWorkflow started", "bill_id", params.BillID, "payment_id", params.PaymentID, "amount", params.Amount)
+
+	result := &PaymentResult{PaymentID: params.PaymentID}
+	var charge ChargeResult
+	err := workflow.ExecuteActivity(chargeCtx, ChargePaymentActivityName, ChargePaymentActivityParams{
+		PaymentID:  params.PaymentID,
+		BillID:     params.BillID,
+		CustomerID: params.CustomerID,
+		Amount:     params.Amount,
+		Currency:   params.Currency,
+	}).Get(chargeCtx, &charge)
+
+	var appErr *temporal.ApplicationError
+	switch {
+	case err == nil:
+		result.Status = PaymentStatusSucceeded
+		result.GatewayReference = charge.Reference
+	case errors.As(err, &appErr) && appErr.Type() == PaymentDeclinedErrorType:
+		result.Status = PaymentStatusDeclined
+		result.FailureReason = appErr.Message()
+		logger.Warn("Payment declined by gateway", "bill_id", params.BillID, "payment_id", params.PaymentID, "reason", result.FailureReason)
+	default:
+		result.Status = PaymentStatusFailed
+		result.FailureReason = err.Error()
+		logger.Error("Payment failed after retries", "bill_id", params.BillID, "payment_id", params.PaymentID, "error", err)
+	}
+	result.CompletedAt = workflow.Now(ctx)
+
+	recordErr := workflow.ExecuteActivity(ctx, RecordPaymentActivityName, RecordPaymentActivityParams{
+		PaymentID:        params.PaymentID,
+		BillID:           params.BillID,
+		Amount:           params.Amount,
+		Currency:         params.Currency,
+		Status:           result.Status,
+		GatewayReference: result.GatewayReference,
+		FailureReason:    result.FailureReason,
+		CreatedAt:        createdAt,
+		CompletedAt:      result.CompletedAt,
+		ActorKeyID:       params.RequestedByKeyID,
+	}).Get(ctx, nil)
+	if recordErr != nil {
+		logger.Error("Failed to execute RecordPaymentActivity", "bill_id", params.BillID, "payment_id", params.PaymentID, "error", recordErr)
+	}
+
+	logger.Info("PaymentWorkflow completed", "bill_id", params.BillID, "payment_id", params.PaymentID, "status", result.Status)
+	return result, nil
+}
+
+// collectPayment runs a child PaymentWorkflow for the bill's balance due and moves the
+// bill to PAID or PAYMENT_FAILED depending on the outcome.
+func (w *billWorkflow) collectPayment(signal PayBillSignal) {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	if w.outdated(PayBillSignalName, signal.IfVersion) {
+		return
+	}
+	if !bill.isPayable() {
+		logger.Warn("PayBillSignal received for a bill that is not awaiting payment, ignoring.", "bill_id", bill.ID, "bill_status", bill.Status)
+		return
+	}
+
+	balance := bill.balanceDue()
+	if balance <= 0 {
+		logger.Info("Bill balance is zero, marking paid without charging", "bill_id", bill.ID)
+		w.setStatus(BillStatusPaid)
+		return
+	}
+
+	paymentID := signal.PaymentID
+	if paymentID == "" {
+		generatedID, idErr := generateID(ctx)
+		if idErr != nil {
+			logger.Error("Failed to generate PaymentID for bill", "bill_id", bill.ID, "error", idErr)
+			return
+		}
+		paymentID = generatedID
+	}
+	for _, p := range bill.Payments {
+		if p.ID == paymentID {
+			logger.Info("Duplicate PaymentID received, ignoring.", "bill_id", bill.ID, "payment_id", paymentID)
+			return
+		}
+	}
+
+	createdAt := workflow.Now(ctx)
+	bill.Payments = append(bill.Payments, Payment{
+		ID:        paymentID,
+		Status:    PaymentStatusPending,
+		Amount:    balance,
+		CreatedAt: &createdAt,
+	})
+	payment := &bill.Payments[len(bill.Payments)-1]
+	w.touch()
+
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID: "payment-" + paymentID,
+	})
+	var result PaymentResult
+	err := workflow.ExecuteChildWorkflow(childCtx, PaymentWorkflow, &PaymentWorkflowParams{
+		PaymentID:        paymentID,
+		BillID:           bill.ID,
+		CustomerID:       bill.CustomerID,
+		Amount:           balance,
+		Currency:         bill.Currency,
+		RequestedByKeyID: signal.RequestedByKeyID,
+	}).Get(childCtx, &result)
+	if err != nil {
+		logger.Error("PaymentWorkflow failed", "bill_id", bill.ID, "payment_id", paymentID, "error", err)
+		result = PaymentResult{PaymentID: paymentID, Status: PaymentStatusFailed, FailureReason: err.Error(), CompletedAt: workflow.Now(ctx)}
+	}
+
+	payment.Status = result.Status
+	payment.GatewayReference = result.GatewayReference
+	payment.FailureReason = result.FailureReason
+	payment.CompletedAt = &result.CompletedAt
+	w.touch()
+
+	if result.Status == PaymentStatusSucceeded {
+		bill.allocatePayment(*payment)
+		if w.cancelDunning != nil {
+			w.cancelDunning()
+		}
+		w.setStatus(BillStatusPaid)
+		return
+	}
+
+	// An overdue or delinquent bill keeps its status when another payment fails.
+	if bill.Status != BillStatusDelinquent && bill.Status != BillStatusOverdue {
+		w.setStatus(BillStatusPaymentFailed)
+	}
+	if len(w.params.DunningSchedule) > 0 && w.dunning == nil && (bill.Status == BillStatusPaymentFailed || bill.Status == BillStatusOverdue) {
+		w.startDunning()
+	}
+}
+
+// recordPayment applies a payment received outside the payment gateway to the bill's
+// balance. A payment that settles the balance moves the bill to PAID; a smaller one
+// moves a CLOSED or PAYMENT_FAILED bill to PARTIALLY_PAID, while OVERDUE and
+// DELINQUENT bills keep their status until they are paid in full. Dunning stops, since
+// its retries would charge the balance from before the payment.
+func (w *billWorkflow) recordPayment(signal RecordPaymentSignal) {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	if w.outdated(RecordPaymentSignalName, signal.IfVersion) {
+		return
+	}
+	if !bill.isPayable() {
+		logger.Warn("RecordPaymentSignal received for a bill that is not awaiting payment, ignoring.", "bill_id", bill.ID, "bill_status", bill.Status, "payment_id", signal.PaymentID)
+		return
+	}
+	for _, p := range bill.Payments {
+		if p.ID == signal.PaymentID {
+			logger.Info("Duplicate PaymentID received, ignoring.", "bill_id", bill.ID, "payment_id", signal.PaymentID)
+			return
+		}
+	}
+	amount, balance := bill.round(signal.Amount), bill.balanceDue()
+	if amount <= 0 || amount > balance {
+		logger.Warn("Recorded payment does not fit the bill's balance, ignoring.", "bill_id", bill.ID, "payment_id", signal.PaymentID, "amount", amount, "balance_due", balance)
+		return
+	}
+
+	receivedAt := workflow.Now(ctx)
+	payment := Payment{
+		ID:               signal.PaymentID,
+		Status:           PaymentStatusSucceeded,
+		Amount:           amount,
+		GatewayReference: signal.Reference,
+		CreatedAt:        &receivedAt,
+		CompletedAt:      &receivedAt,
+		Method:           signal.Method,
+	}
+	bill.Payments = append(bill.Payments, payment)
+	bill.allocatePayment(payment)
+	w.touch()
+	logger.Info("Payment recorded", "bill_id", bill.ID, "payment_id", payment.ID, "amount", amount, "balance_due", *bill.BalanceDue)
+
+	err := workflow.ExecuteActivity(ctx, RecordPaymentActivityName, RecordPaymentActivityParams{
+		PaymentID:        payment.ID,
+		BillID:           bill.ID,
+		Amount:           amount,
+		Currency:         bill.Currency,
+		Status:           payment.Status,
+		GatewayReference: payment.GatewayReference,
+		CreatedAt:        receivedAt,
+		CompletedAt:      receivedAt,
+		ActorKeyID:       signal.RequestedByKeyID,
+		Method:           payment.Method,
+	}).Get(ctx, nil)
+	if err != nil {
+		logger.Error("Failed to execute RecordPaymentActivity", "bill_id", bill.ID, "payment_id", payment.ID, "error", err)
+	}
+
+	if w.cancelDunning != nil {
+		w.cancelDunning()
+	}
+	switch {
+	case *bill.BalanceDue <= 0:
+		w.setStatus(BillStatusPaid)
+	case bill.Status == BillStatusClosed || bill.Status == BillStatusPaymentFailed:
+		w.setStatus(BillStatusPartiallyPaid)
+	}
+}
+
+// setStatus transitions the bill to a new status and persists it.
+func (w *billWorkflow) setStatus(status BillStatus) {
+	w.setStatusBy(status, "", nil)
+}
+
+// setStatusBy is setStatus attributed to the API key keyID, if any, saving approval
+// along with the status when it is set.
+func (w *billWorkflow) setStatusBy(status BillStatus, keyID string, approval *BillApproval) {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	bill.Status = status
+	w.touch()
+	actErr := workflow.ExecuteActivity(ctx, UpdateBillStatusActivityName, UpdateBillStatusActivityParams{
+		BillID:     bill.ID,
+		Status:     status,
+		ActorKeyID: keyID,
+		Approval:   approval,
+		DueDate:    bill.DueDate,
+		Version:    bill.Version,
+	}).Get(ctx, nil)
+	if actErr != nil {
+		logger.Error("Failed to execute UpdateBillStatusActivity", "bill_id", bill.ID, "status", status, "error", actErr)
+	}
+	logger.Info("Bill status updated in workflow state", "bill_id", bill.ID, "status", status)
+}
@@ -0,0 +1,351 @@
+package fees
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"encore.app/apierr"
+)
+
+// PaymentStatus represents the outcome of a single payment attempt.
+type PaymentStatus string
+
+const (
+	PaymentStatusPending   PaymentStatus = "PENDING"
+	PaymentStatusSucceeded PaymentStatus = "SUCCEEDED"
+	PaymentStatusDeclined  PaymentStatus = "DECLINED"
+	PaymentStatusFailed    PaymentStatus = "FAILED"
+	// PaymentStatusDisputed is a successful payment the customer charged back. Its
+	// funds stay allocated to the bill until the dispute is resolved.
+	PaymentStatusDisputed PaymentStatus = "DISPUTED"
+)
+
+// allocated reports whether a payment in status s counts toward its bill's amount paid.
+func (s PaymentStatus) allocated() bool {
+	return s == PaymentStatusSucceeded || s == PaymentStatusDisputed
+}
+
+// PaymentDeclinedErrorType is the application error type used for gateway declines.
+// Declines are final and are never retried by the PaymentWorkflow.
+const PaymentDeclinedErrorType = "PaymentDeclined"
+
+// Payment represents a payment collected (or attempted) against a bill.
+type Payment struct {
+	ID               string        `json:"id"`
+	Status           PaymentStatus `json:"status"`
+	Amount           float64       `json:"amount"`
+	GatewayReference string        `json:"gatewayReference,omitempty"`
+	FailureReason    string        `json:"failureReason,omitempty"`
+	CreatedAt        *time.Time    `json:"createdAt"`
+	CompletedAt      *time.Time    `json:"completedAt,omitempty"`
+	// Method is how a recorded payment was received, such as "bank_transfer". It is
+	// empty for charges through the payment gateway.
+	Method string `json:"method,omitempty"`
+	// DisputeID is the dispute opened when the payment was charged back.
+	DisputeID string `json:"disputeId,omitempty"`
+}
+
+// PaymentAllocation is the part of a bill's balance settled by one successful payment.
+type PaymentAllocation struct {
+	PaymentID string  `json:"paymentId"`
+	Amount    float64 `json:"amount"`
+	// BalanceAfter is the bill's balance due once the payment was allocated.
+	BalanceAfter float64   `json:"balanceAfter"`
+	AllocatedAt  time.Time `json:"allocatedAt"`
+}
+
+// ------ Gateway ------
+
+// ChargeRequest is the input to a payment gateway charge.
+type ChargeRequest struct {
+	// PaymentID doubles as the idempotency key for the gateway call.
+	PaymentID  string
+	BillID     string
+	CustomerID string
+	Amount     float64
+	Currency   string
+}
+
+// ChargeResult is the outcome of a successful gateway charge.
+type ChargeResult struct {
+	Reference string
+}
+
+// PaymentDeclinedError is returned by a PaymentGateway when the provider declines the charge.
+type PaymentDeclinedError struct {
+	Reason string
+}
+
+func (e *PaymentDeclinedError) Error() string {
+	return "payment declined: " + e.Reason
+}
+
+// RefundRequest is the input to a payment gateway refund.
+type RefundRequest struct {
+	// RefundID doubles as the idempotency key for the gateway call.
+	RefundID         string
+	BillID           string
+	PaymentReference string
+	Amount           float64
+	Currency         string
+}
+
+// RefundResult is the outcome of a successful gateway refund.
+type RefundResult struct {
+	Reference string
+}
+
+// PaymentGateway charges customers for closed bills and refunds them. Implementations
+// wrap a payment provider and must treat ChargeRequest.PaymentID and RefundRequest.RefundID
+// as idempotency keys, since the calling activities are retried on transient errors.
+type PaymentGateway interface {
+	Charge(ctx context.Context, req ChargeRequest) (*ChargeResult, error)
+	Refund(ctx context.Context, req RefundRequest) (*RefundResult, error)
+}
+
+// SandboxGateway is a PaymentGateway that approves every charge and refund without contacting a provider.
+type SandboxGateway struct{}
+
+// Charge implements PaymentGateway.
+func (SandboxGateway) Charge(ctx context.Context, req ChargeRequest) (*ChargeResult, error) {
+	return &ChargeResult{Reference: "sandbox_" + req.PaymentID}, nil
+}
+
+// Refund implements PaymentGateway.
+func (SandboxGateway) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	return &RefundResult{Reference: "sandbox_" + req.RefundID}, nil
+}
+
+// ------ API ------
+
+// PayBillResponse is the response payload after requesting payment of a bill.
+type PayBillResponse struct {
+	RetryMetadata
+	BillID          string     `json:"billId"`
+	PaymentID       string     `json:"paymentId"`
+	Status          BillStatus `json:"status"`
+	ConfirmationMsg string     `json:"confirmationMsg"`
+	// StateToken identifies the bill's state once this change is applied. Pass it to
+	// GetBill as minStateToken to read the bill with the change.
+	StateToken string `json:"stateToken,omitempty"`
+}
+
+// PayBillRequest holds the optional parameters for paying a bill.
+type PayBillRequest struct {
+	// IfMatch, when set, starts the payment only if the bill is still at this version.
+	IfMatch string `header:"If-Match"`
+}
+
+// PayBill starts payment collection for a closed bill.
+//
+// encore:api auth method=POST path=/bills/:billID/pay
+func (s *Service) PayBill(ctx context.Context, billID string, params *PayBillRequest) (*PayBillResponse, error) {
+	ifVersion, err := parseIfMatch(params.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+	getResp, err := s.getBill(ctx, billID)
+	if err != nil {
+		return nil, err
+	}
+	bill := getResp.RetrievedBill
+
+	paymentID := keyedID(ctx, "payment", billID)
+	for _, p := range bill.Payments {
+		if p.ID == paymentID {
+			// A retry of a keyed request that was already accepted.
+			return &PayBillResponse{
+				BillID:          billID,
+				PaymentID:       paymentID,
+				Status:          bill.Status,
+				ConfirmationMsg: "Payment already requested for this idempotency key.",
+				StateToken:      stateToken(bill.Version),
+			}, nil
+		}
+	}
+
+	if bill.Status == BillStatusPaid {
+		return nil, apierr.FailedPrecondition(apierr.BillAlreadyPaid, "bill %s is already paid", billID)
+	}
+	if !bill.isPayable() {
+		return nil, apierr.FailedPrecondition(apierr.BillNotPayable, "bill %s cannot be paid in status %s; close it first", billID, bill.Status)
+	}
+	if err := checkVersion(&bill, ifVersion); err != nil {
+		return nil, err
+	}
+
+	signal := PayBillSignal{PaymentID: paymentID, RequestedByKeyID: callerKeyID(ctx), IfVersion: ifVersion}
+	if err := s.signalSettlement(ctx, &bill, PayBillSignalName, signal); err != nil {
+		return nil, apierr.FromTemporal(err, apierr.BillNotFound, "bill %s not found", billID)
+	}
+
+	return &PayBillResponse{
+		BillID:          billID,
+		PaymentID:       paymentID,
+		Status:          bill.Status,
+		ConfirmationMsg: "Payment collection started.",
+		StateToken:      nextStateToken(&bill),
+	}, nil
+}
+
+// maxPaymentMethodLength caps the method of a recorded payment.
+const maxPaymentMethodLength = 64
+
+// RecordPaymentRequest is the request payload for recording a payment received outside
+// the payment gateway.
+type RecordPaymentRequest struct {
+	// Amount received. It must not exceed the bill's balance due.
+	Amount float64 `json:"amount"`
+	// Method is how the payment was received, such as "bank_transfer" or "check".
+	Method string `json:"method"`
+	// Reference is the payment's reference with the bank or processor, if any.
+	Reference string `json:"reference,omitempty"`
+	// IfMatch, when set, records the payment only if the bill is still at this version.
+	IfMatch string `header:"If-Match"`
+}
+
+// RecordPaymentResponse is the response payload after recording a payment.
+type RecordPaymentResponse struct {
+	RetryMetadata
+	BillID    string `json:"billId"`
+	PaymentID string `json:"paymentId"`
+	// BalanceDue is the bill's balance once the payment is allocated.
+	BalanceDue      float64 `json:"balanceDue"`
+	ConfirmationMsg string  `json:"confirmationMsg"`
+	// StateToken identifies the bill's state once this change is applied. Pass it to
+	// GetBill as minStateToken to read the bill with the change.
+	StateToken string `json:"stateToken,omitempty"`
+}
+
+// RecordPayment records a full or partial payment of a closed bill. The bill becomes
+// PARTIALLY_PAID while a balance remains and PAID once it is settled.
+//
+// encore:api auth method=POST path=/bills/:billID/payments
+func (s *Service) RecordPayment(ctx context.Context, billID string, params *RecordPaymentRequest) (*RecordPaymentResponse, error) {
+	if params.Amount <= 0 {
+		return nil, apierr.InvalidArgument(apierr.InvalidAmount, "payment amount must be positive, got %v", params.Amount)
+	}
+	params.Method = strings.TrimSpace(params.Method)
+	if params.Method == "" || len(params.Method) > maxPaymentMethodLength {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "method is required and must be at most %d characters", maxPaymentMethodLength)
+	}
+	ifVersion, err := parseIfMatch(params.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+	getResp, err := s.getBill(ctx, billID)
+	if err != nil {
+		return nil, err
+	}
+	bill := getResp.RetrievedBill
+
+	paymentID := keyedID(ctx, "recorded-payment", billID)
+	for _, p := range bill.Payments {
+		if p.ID == paymentID {
+			// A retry of a keyed request that was already accepted.
+			return &RecordPaymentResponse{
+				BillID:          billID,
+				PaymentID:       paymentID,
+				BalanceDue:      bill.balanceDue(),
+				ConfirmationMsg: "Payment already recorded for this idempotency key.",
+				StateToken:      stateToken(bill.Version),
+			}, nil
+		}
+	}
+
+	if bill.Status == BillStatusPaid {
+		return nil, apierr.FailedPrecondition(apierr.BillAlreadyPaid, "bill %s is already paid", billID)
+	}
+	if !bill.isPayable() {
+		return nil, apierr.FailedPrecondition(apierr.BillNotPayable, "bill %s cannot be paid in status %s; close it first", billID, bill.Status)
+	}
+	if err := checkVersion(&bill, ifVersion); err != nil {
+		return nil, err
+	}
+	amount, balance := bill.round(params.Amount), bill.balanceDue()
+	if amount <= 0 || amount > balance {
+		return nil, apierr.InvalidArgument(apierr.InvalidAmount, "payment amount %v exceeds balance due %v for bill %s", params.Amount, balance, billID)
+	}
+
+	signal := RecordPaymentSignal{
+		PaymentID:        paymentID,
+		Amount:           amount,
+		Method:           params.Method,
+		Reference:        params.Reference,
+		RequestedByKeyID: callerKeyID(ctx),
+		IfVersion:        ifVersion,
+	}
+	if err := s.signalSettlement(ctx, &bill, RecordPaymentSignalName, signal); err != nil {
+		return nil, apierr.FromTemporal(err, apierr.BillNotFound, "bill %s not found", billID)
+	}
+
+	return &RecordPaymentResponse{
+		BillID:          billID,
+		PaymentID:       paymentID,
+		BalanceDue:      bill.round(balance - amount),
+		ConfirmationMsg: "Payment recorded successfully.",
+		StateToken:      nextStateToken(&bill),
+	}, nil
+}
+
+// isPayable reports whether a payment can be collected for the bill.
+func (b *Bill) isPayable() bool {
+	switch b.Status {
+	case BillStatusClosed, BillStatusPaymentFailed, BillStatusPartiallyPaid, BillStatusOverdue, BillStatusDelinquent:
+		return true
+	}
+	return false
+}
+
+// balanceDue is the part of the bill's total not yet settled by successful payments.
+func (b *Bill) balanceDue() float64 {
+	if b.Status == BillStatusPaid {
+		return 0
+	}
+	return b.round(max(b.TotalAmount-b.AmountPaid, 0))
+}
+
+// refreshBalance recomputes the bill's reported balance due, such as once its total is
+// final on close.
+func (b *Bill) refreshBalance() {
+	due := b.balanceDue()
+	b.BalanceDue = &due
+}
+
+// allocatePayment applies a successful payment to the bill's balance and records the
+// allocation.
+func (b *Bill) allocatePayment(p Payment) {
+	allocatedAt := p.CreatedAt
+	if p.CompletedAt != nil {
+		allocatedAt = p.CompletedAt
+	}
+	b.AmountPaid = b.round(b.AmountPaid + p.Amount)
+	b.refreshBalance()
+	allocation := PaymentAllocation{PaymentID: p.ID, Amount: p.Amount, BalanceAfter: *b.BalanceDue}
+	if allocatedAt != nil {
+		allocation.AllocatedAt = *allocatedAt
+	}
+	b.Allocations = append(b.Allocations, allocation)
+}
+
+// reversePayment takes a payment that was allocated back off the bill's balance, such
+// as one the provider reports as failed after it succeeded. The reversal is recorded
+// as an allocation of the negative amount. The balance is due again even while the
+// bill is still PAID, until its status catches up.
+func (b *Bill) reversePayment(p Payment, reversedAt time.Time) {
+	b.AmountPaid = b.round(max(b.AmountPaid-p.Amount, 0))
+	due := b.round(max(b.TotalAmount-b.AmountPaid, 0))
+	b.BalanceDue = &due
+	b.Allocations = append(b.Allocations, PaymentAllocation{PaymentID: p.ID, Amount: -p.Amount, BalanceAfter: *b.BalanceDue, AllocatedAt: reversedAt})
+}
+
+// payment returns the bill's payment with the given ID, or nil if there is none.
+func (b *Bill) payment(id string) *Payment {
+	for i := range b.Payments {
+		if b.Payments[i].ID == id {
+			return &b.Payments[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"encore.app/apierr"
+	"encore.app/rounding"
+	"encore.dev/storage/sqldb"
+)
+
+// maxSimulatedLineItems caps the line items of one pricing simulation.
+const maxSimulatedLineItems = 1000
+
+// PricedLineItemSource names where a line item of a pricing simulation came from.
+type PricedLineItemSource string
+
+const (
+	PricedLineItemTemplate   PricedLineItemSource = "template"
+	PricedLineItemPlan       PricedLineItemSource = "plan"
+	PricedLineItemRequest    PricedLineItemSource = "request"
+	PricedLineItemAdjustment PricedLineItemSource = "adjustment"
+	PricedLineItemCredit     PricedLineItemSource = "credit"
+	PricedLineItemFreeTier   PricedLineItemSource = "free_tier"
+	PricedLineItemCoupon     PricedLineItemSource = "coupon"
+)
+
+// SimulatedLineItem is a hypothetical line item to price.
+type SimulatedLineItem struct {
+	Description string  `json:"description"`
+	Amount      float64 `json:"amount,omitempty"`
+	// Metric and Quantity rate the item as usage with the simulation's usage prices,
+	// instead of giving its Amount.
+	Metric   string  `json:"metric,omitempty"`
+	Quantity float64 `json:"quantity,omitempty"`
+}
+
+// PricedLineItem is a line item as a bill would hold it.
+type PricedLineItem struct {
+	Description string               `json:"description"`
+	Amount      float64              `json:"amount"`
+	Source      PricedLineItemSource `json:"source"`
+	// Metric and Quantity are set on items rating usage; Amount is what the item bills
+	// on top of the metric's earlier items.
+	Metric   string  `json:"metric,omitempty"`
+	Quantity float64 `json:"quantity,omitempty"`
+	// OverHardCap is set on items the bill's hard cap would flag.
+	OverHardCap bool `json:"overHardCap,omitempty"`
+	// Waived is set on the free_tier items following the items a free tier waives
+	// part of, to what it waived.
+	Waived float64 `json:"waived,omitempty"`
+}
+
+// PricingBreakdown is the would-be total of a bill and how it is reached.
+type PricingBreakdown struct {
+	Currency string           `json:"currency"`
+	Rounding *rounding.Policy `json:"rounding,omitempty"`
+	// LineItems are the items the bill would hold on close, including its adjustment
+	// and credit items.
+	LineItems []PricedLineItem `json:"lineItems"`
+	// RejectedLineItems are the items the bill's hard cap would refuse.
+	RejectedLineItems []PricedLineItem `json:"rejectedLineItems"`
+	// Subtotal is the total of the charged items, before coupons, adjustment and
+	// credit.
+	Subtotal float64 `json:"subtotal"`
+	// Discount is what the coupons take off the subtotal.
+	Discount   float64         `json:"discount"`
+	HardCap    *HardCap        `json:"hardCap,omitempty"`
+	Adjustment *BillAdjustment `json:"adjustment,omitempty"`
+	// Credit is the credit the customer's current balance would cover.
+	Credit float64 `json:"credit"`
+	Total  float64 `json:"total"`
+	// RequiresApproval is set when the bill would be held for approval on close.
+	RequiresApproval bool `json:"requiresApproval"`
+}
+
+// pricingInput is what a bill is priced from.
+type pricingInput struct {
+	Currency string
+	Rounding *rounding.Policy
+	Limits   *BillLimits
+	Approval ApprovalPolicy
+	// UsagePrices rate the items that give a metric.
+	UsagePrices []UsagePrice
+	// FreeTiers waive usage and charges. The items arrive as the bill opens, so its
+	// trials waive them all.
+	FreeTiers []FreeTier
+	// Coupons are taken off the total on close, before the bill limits.
+	Coupons []AppliedCoupon
+	// CreditBalance is the customer's available credit; 0 applies none.
+	CreditBalance float64
+	Items         []PricedLineItem
+}
+
+// priceBill prices a bill the way its workflow would: line items are rated, rounded
+// and checked against the hard cap as they arrive, then on close the total is
+// discounted by coupons, adjusted against the bill limits, checked for approval, and
+// reduced by available credit. Items
+// giving a metric must be for one of in's usage prices.
+func priceBill(in pricingInput) *PricingBreakdown {
+	bill := &Bill{Currency: in.Currency, Rounding: in.Rounding, HardCap: in.Limits.hardCap(), UsagePrices: in.UsagePrices, FreeTiers: in.FreeTiers}
+	var opened time.Time
+	bill.TrialStart = &opened
+	out := &PricingBreakdown{
+		Currency:          in.Currency,
+		Rounding:          in.Rounding,
+		HardCap:           bill.HardCap,
+		LineItems:         []PricedLineItem{},
+		RejectedLineItems: []PricedLineItem{},
+	}
+	for _, item := range in.Items {
+		if item.Metric != "" {
+			item.Amount, _ = bill.rateUsage(item.Metric, item.Quantity)
+		}
+		amount, waived, trial := bill.waive(AddLineItemSignal{Metric: item.Metric, Quantity: item.Quantity}, LineItemCharge, bill.round(item.Amount), opened)
+		item.Amount = amount
+		total := bill.lineItemTotal()
+		if bill.HardCap.rejects(total, item.Amount) {
+			out.RejectedLineItems = append(out.RejectedLineItems, item)
+			continue
+		}
+		item.OverHardCap = bill.HardCap.exceededBy(total, item.Amount)
+		bill.LineItems = append(bill.LineItems, LineItem{Description: item.Description, Amount: item.Amount, Metric: item.Metric, Quantity: item.Quantity})
+		out.LineItems = append(out.LineItems, item)
+		if waived != 0 {
+			bill.LineItems = append(bill.LineItems, LineItem{Metric: item.Metric, Waived: waived})
+			out.LineItems = append(out.LineItems, PricedLineItem{Description: waiverDescription(item.Description, trial), Metric: item.Metric, Waived: waived, Source: PricedLineItemFreeTier})
+		}
+	}
+
+	out.Subtotal = bill.lineItemTotal()
+	total := out.Subtotal
+	for _, c := range in.Coupons {
+		if amount := bill.couponDiscount(c, total); amount > 0 {
+			total = bill.round(total - amount)
+			out.LineItems = append(out.LineItems, PricedLineItem{Description: couponDescription(c.Code), Amount: -amount, Source: PricedLineItemCoupon})
+		}
+	}
+	out.Discount = bill.round(out.Subtotal - total)
+	if adj := bill.limitAdjustment(in.Limits, total); adj != nil {
+		out.Adjustment = adj
+		total = bill.round(total + adj.Amount)
+		if adj.Amount != 0 {
+			out.LineItems = append(out.LineItems, PricedLineItem{Description: adj.Kind.description(), Amount: adj.Amount, Source: PricedLineItemAdjustment})
+		}
+	}
+	out.RequiresApproval = in.Approval.requires(total)
+	if in.CreditBalance > 0 && total > 0 {
+		decimals := unroundedDecimals
+		if bill.Rounding != nil {
+			decimals = bill.Rounding.Decimals
+		}
+		if credit := creditToApply(in.CreditBalance, total, decimals); credit > 0 {
+			out.Credit = credit
+			total = bill.round(total - credit)
+			out.LineItems = append(out.LineItems, PricedLineItem{Description: creditLineItemDescription, Amount: -credit, Source: PricedLineItemCredit})
+		}
+	}
+	out.Total = total
+	return out
+}
+
+// loadCreditBalance returns a customer's available credit in currency, or 0.
+func loadCreditBalance(ctx context.Context, db *tracedDB, tenantID, customerID, currency string) (float64, error) {
+	var balance float64
+	err := db.QueryRow(ctx, `
+        SELECT balance::float8 FROM customer_credit_balances
+        WHERE tenant_id = $1 AND customer_id = $2 AND currency = $3
+    `, tenantOrDefault(tenantID), customerID, currency).Scan(&balance)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load credit balance of customer %s: %w", customerID, err)
+	}
+	return balance, nil
+}
+
+// ------ API ------
+
+// SimulatePricingRequest is the request payload for previewing the pricing of a bill.
+type SimulatePricingRequest struct {
+	// TenantID identifies the platform whose customer's credit is applied. API keys
+	// always use their own tenant.
+	TenantID string `header:"X-Tenant-ID"`
+	// CustomerID prices the bill with the customer's bill limits and credit balance.
+	CustomerID string `json:"customerId,omitempty"`
+	// Currency defaults to the plan's.
+	Currency string `json:"currency,omitempty"`
+	// Plan adds the charge of one billing period of a subscription plan, as a
+	// subscription's bill would hold it.
+	Plan *SubscriptionPlan `json:"plan,omitempty"`
+	// TemplateID adds a bill template's line items, as a bill created from it would.
+	TemplateID string              `json:"templateId,omitempty"`
+	LineItems  []SimulatedLineItem `json:"lineItems,omitempty"`
+	// UsagePrices rate the line items that give a metric. Defaults to the plan's.
+	UsagePrices []UsagePrice `json:"usagePrices,omitempty"`
+	// FreeTiers waive usage and charges. Defaults to the plan's.
+	FreeTiers []FreeTier `json:"freeTiers,omitempty"`
+	// Coupons are codes of coupons to take off the total. They are checked as they
+	// would be for the customer's bill, but not redeemed.
+	Coupons []string `json:"coupons,omitempty"`
+	// BillLimits replaces the customer's bill limits, to preview a change to them.
+	BillLimits *BillLimits `json:"billLimits,omitempty"`
+	// RoundingMode replaces the service-wide rounding mode.
+	RoundingMode rounding.Mode `json:"roundingMode,omitempty"`
+	// SkipCredits leaves the customer's credit balance out of the total.
+	SkipCredits bool `json:"skipCredits,omitempty"`
+}
+
+// SimulatePricingResponse is the response payload of a pricing simulation.
+type SimulatePricingResponse struct {
+	Pricing PricingBreakdown `json:"pricing"`
+}
+
+// SimulatePricing prices a hypothetical bill with the same rules bills are closed
+// with, without creating anything, so pricing changes can be previewed. Credit is
+// shown as it would apply now but is not reserved.
+//
+// encore:api auth method=POST path=/pricing/simulate
+func (s *Service) SimulatePricing(ctx context.Context, params *SimulatePricingRequest) (*SimulatePricingResponse, error) {
+	currency := params.Currency
+	if currency == "" && params.Plan != nil {
+		currency = params.Plan.Currency
+	}
+	if !validCurrency(currency) {
+		return nil, apierr.InvalidArgument(apierr.InvalidCurrency, "invalid currency %q: must be a three-letter ISO 4217 code such as \"USD\"", currency)
+	}
+	if len(params.LineItems) > maxSimulatedLineItems {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "at most %d line items can be simulated", maxSimulatedLineItems)
+	}
+	mode := s.cfg.RoundingMode
+	if params.RoundingMode != "" {
+		if !params.RoundingMode.IsValid() {
+			return nil, apierr.InvalidArgument(apierr.InvalidParameter, "invalid roundingMode %q", params.RoundingMode)
+		}
+		mode = params.RoundingMode
+	}
+
+	if params.BillLimits != nil {
+		if err := params.BillLimits.normalize(); err != nil {
+			return nil, err
+		}
+	}
+	in := pricingInput{Currency: currency, Limits: params.BillLimits, Approval: s.cfg.Approval}
+	if mode != "" {
+		policy := rounding.ForCurrency(currency, mode)
+		in.Rounding = &policy
+	}
+
+	if params.TemplateID != "" {
+		t, err := loadBillTemplate(ctx, s.db, params.TemplateID)
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to load bill template %s", params.TemplateID)
+		}
+		if t == nil {
+			return nil, apierr.NotFound(apierr.TemplateNotFound, "bill template %s not found", params.TemplateID)
+		}
+		if t.Currency != "" && t.Currency != currency {
+			return nil, apierr.InvalidArgument(apierr.InvalidCurrency, "bill template %s is for %s bills, not %s", t.ID, t.Currency, currency)
+		}
+		for _, item := range t.LineItems {
+			in.Items = append(in.Items, PricedLineItem{Description: item.Description, Amount: item.Amount, Source: PricedLineItemTemplate})
+		}
+	}
+	if plan := params.Plan; plan != nil {
+		if err := plan.validate(); err != nil {
+			return nil, err
+		}
+		if plan.Currency != currency {
+			return nil, apierr.InvalidArgument(apierr.InvalidCurrency, "plan is priced in %s, not %s", plan.Currency, currency)
+		}
+		start := s.clock.Now().UTC()
+		in.Items = append(in.Items, PricedLineItem{Description: plan.chargeDescription(start, plan.Interval.periodEnd(start)), Amount: plan.Amount, Source: PricedLineItemPlan})
+		in.UsagePrices = plan.UsagePrices
+		in.FreeTiers = plan.FreeTiers
+	}
+	if params.UsagePrices != nil {
+		if err := normalizeUsagePrices(params.UsagePrices); err != nil {
+			return nil, err
+		}
+		in.UsagePrices = params.UsagePrices
+	}
+	if params.FreeTiers != nil {
+		in.FreeTiers = params.FreeTiers
+	}
+	if err := normalizeFreeTiers(in.FreeTiers, in.UsagePrices); err != nil {
+		return nil, err
+	}
+	bill := &Bill{UsagePrices: in.UsagePrices}
+	for _, item := range params.LineItems {
+		if item.Metric != "" {
+			if math.IsNaN(item.Quantity) || math.IsInf(item.Quantity, 0) || item.Quantity <= 0 {
+				return nil, apierr.InvalidArgument(apierr.InvalidAmount, "line item quantity must be a positive number, got %v", item.Quantity)
+			}
+			if bill.usagePrice(item.Metric) == nil {
+				return nil, apierr.InvalidArgument(apierr.InvalidParameter, "no usage price for metric %s", item.Metric)
+			}
+			in.Items = append(in.Items, PricedLineItem{Description: item.Description, Metric: item.Metric, Quantity: item.Quantity, Source: PricedLineItemRequest})
+			continue
+		}
+		if math.IsNaN(item.Amount) || math.IsInf(item.Amount, 0) || item.Amount <= 0 {
+			return nil, apierr.InvalidArgument(apierr.InvalidAmount, "line item amount must be a positive number, got %v", item.Amount)
+		}
+		in.Items = append(in.Items, PricedLineItem{Description: item.Description, Amount: item.Amount, Source: PricedLineItemRequest})
+	}
+
+	tenantID := requestTenant(ctx, params.TenantID)
+	if err := normalizeCouponCodes(params.Coupons); err != nil {
+		return nil, err
+	}
+	coupons, err := s.checkCoupons(ctx, tenantID, params.Coupons, params.CustomerID, currency)
+	if err != nil {
+		return nil, err
+	}
+	in.Coupons = coupons
+
+	if params.CustomerID != "" {
+		if in.Limits == nil {
+			limits, err := loadBillLimits(ctx, s.db, tenantID, params.CustomerID, currency)
+			if err != nil {
+				return nil, apierr.Wrap(err, "failed to load bill limits for customer %s", params.CustomerID)
+			}
+			in.Limits = limits
+		}
+		if !params.SkipCredits {
+			balance, err := loadCreditBalance(ctx, s.db, tenantID, params.CustomerID, currency)
+			if err != nil {
+				return nil, apierr.Wrap(err, "failed to load credit balance of customer %s", params.CustomerID)
+			}
+			in.CreditBalance = balance
+		}
+	}
+
+	return &SimulatePricingResponse{Pricing: *priceBill(in)}, nil
+}
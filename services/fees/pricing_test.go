@@ -0,0 +1,89 @@
+package fees
+
+import (
+	"testing"
+
+	"encore.app/rounding"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPriceBill tests that a simulated bill is rounded, capped, adjusted and credited
+// the way its workflow would close it.
+func TestPriceBill(t *testing.T) {
+	policy := rounding.ForCurrency("USD", rounding.HalfUp)
+	out := priceBill(pricingInput{
+		Currency:      "USD",
+		Rounding:      &policy,
+		Limits:        &BillLimits{MinimumTotal: 50, HardCap: 80},
+		Approval:      ApprovalPolicy{Threshold: 50},
+		CreditBalance: 20.555,
+		Items: []PricedLineItem{
+			{Description: "Pro plan", Amount: 29.999, Source: PricedLineItemPlan},
+			{Description: "Usage", Amount: 60, Source: PricedLineItemRequest},
+			{Description: "Usage", Amount: 10, Source: PricedLineItemRequest},
+		},
+	})
+
+	require.Equal(t, 40.0, out.Subtotal)
+	require.Len(t, out.RejectedLineItems, 1)
+	require.Equal(t, 60.0, out.RejectedLineItems[0].Amount)
+	require.Equal(t, &BillAdjustment{Kind: AdjustmentMinimumCommitment, Limit: 50, TotalBefore: 40, Amount: 10}, out.Adjustment)
+	require.True(t, out.RequiresApproval)
+	require.Equal(t, 20.55, out.Credit)
+	require.Equal(t, 29.45, out.Total)
+	require.Equal(t, []PricedLineItem{
+		{Description: "Pro plan", Amount: 30, Source: PricedLineItemPlan},
+		{Description: "Usage", Amount: 10, Source: PricedLineItemRequest},
+		{Description: "Minimum commitment", Amount: 10, Source: PricedLineItemAdjustment},
+		{Description: creditLineItemDescription, Amount: -20.55, Source: PricedLineItemCredit},
+	}, out.LineItems)
+}
+
+// TestPriceBillFreeTiers tests that a simulation waives free units, and shows what they
+// would have cost.
+func TestPriceBillFreeTiers(t *testing.T) {
+	out := priceBill(pricingInput{
+		Currency:    "USD",
+		UsagePrices: []UsagePrice{flatCalls},
+		FreeTiers:   []FreeTier{{Metric: "api_calls", Units: 1000}},
+		Items: []PricedLineItem{
+			{Description: "API calls", Metric: "api_calls", Quantity: 1500, Source: PricedLineItemRequest},
+			{Description: "Support", Amount: 20, Source: PricedLineItemRequest},
+		},
+	})
+	require.Equal(t, 25.0, out.Total)
+	require.Equal(t, []PricedLineItem{
+		{Description: "API calls", Amount: 5, Metric: "api_calls", Quantity: 1500, Source: PricedLineItemRequest},
+		{Description: "Free tier: API calls", Metric: "api_calls", Waived: 10, Source: PricedLineItemFreeTier},
+		{Description: "Support", Amount: 20, Source: PricedLineItemRequest},
+	}, out.LineItems)
+}
+
+// TestPriceBillCoupons tests that a simulation takes coupons off the subtotal before
+// applying credit, as the bill's workflow would on close.
+func TestPriceBillCoupons(t *testing.T) {
+	out := priceBill(pricingInput{
+		Currency:      "USD",
+		Coupons:       []AppliedCoupon{{Code: "SPRING", Kind: CouponKindPercentage, PercentOff: 20}},
+		CreditBalance: 30,
+		Items:         []PricedLineItem{{Description: "Seats", Amount: 100, Source: PricedLineItemRequest}},
+	})
+	require.Equal(t, []float64{100, 20, 30, 50}, []float64{out.Subtotal, out.Discount, out.Credit, out.Total})
+	require.Equal(t, PricedLineItem{Description: "Coupon SPRING", Amount: -20, Source: PricedLineItemCoupon}, out.LineItems[1])
+}
+
+// TestPriceBillUsage tests that a simulation rates usage items in turn, as the bill's
+// workflow would.
+func TestPriceBillUsage(t *testing.T) {
+	out := priceBill(pricingInput{
+		Currency:    "USD",
+		UsagePrices: tieredPrices[1:2],
+		Items: []PricedLineItem{
+			{Description: "API calls", Metric: "api_calls", Quantity: 1000, Source: PricedLineItemRequest},
+			{Description: "API calls", Metric: "api_calls", Quantity: 500, Source: PricedLineItemRequest},
+		},
+	})
+	require.Equal(t, 12.0, out.Total)
+	require.Equal(t, 10.0, out.LineItems[0].Amount)
+	require.Equal(t, 2.0, out.LineItems[1].Amount)
+}
@@ -0,0 +1,230 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/storage/sqldb"
+)
+
+// QuotaMetric names an operation whose monthly volume is capped per tenant.
+type QuotaMetric string
+
+const (
+	QuotaMetricBillsCreated QuotaMetric = "bills_created"
+	QuotaMetricLineItems    QuotaMetric = "line_items"
+)
+
+// DefaultTenantID is the tenant charged for requests that do not name one.
+const DefaultTenantID = "default"
+
+// quotaMetrics lists every metric in the order quota reports present them.
+var quotaMetrics = []QuotaMetric{QuotaMetricBillsCreated, QuotaMetricLineItems}
+
+// IsValid checks if the quota metric is a known value.
+func (m QuotaMetric) IsValid() bool {
+	for _, known := range quotaMetrics {
+		if m == known {
+			return true
+		}
+	}
+	return false
+}
+
+// QuotaUsage reports a tenant's consumption of one metric in the current period.
+type QuotaUsage struct {
+	Metric QuotaMetric `json:"metric"`
+	Used   int         `json:"used"`
+	// Limit is the monthly cap; 0 means unlimited.
+	Limit int `json:"limit"`
+	// Overridden is true when an admin override replaces the service-wide default.
+	Overridden bool `json:"overridden"`
+}
+
+// QuotaUsageResponse is the response payload for retrieving a tenant's quota usage.
+type QuotaUsageResponse struct {
+	TenantID string `json:"tenantId"`
+	// Period is the calendar month (UTC) the usage applies to, e.g. "2024-06".
+	Period   string       `json:"period"`
+	ResetsAt time.Time    `json:"resetsAt"`
+	Usage    []QuotaUsage `json:"usage"`
+}
+
+// QuotaOverrideResponse is the response payload after changing a tenant's quota override.
+type QuotaOverrideResponse struct {
+	RetryMetadata
+	Quota *QuotaUsageResponse `json:"quota"`
+}
+
+// SetQuotaOverrideRequest is the request payload for overriding a tenant's monthly cap.
+type SetQuotaOverrideRequest struct {
+	// Limit replaces the default monthly cap for the tenant; 0 lifts the cap.
+	Limit int `json:"limit"`
+}
+
+// quotas enforces monthly per-tenant caps, tracking usage in the database.
+type quotas struct {
+	db    *tracedDB
+	cfg   *Config
+	clock Clock
+}
+
+// quotaPeriod returns the calendar month containing t and the moment the next one starts.
+func quotaPeriod(t time.Time) (string, time.Time) {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start.Format("2006-01"), start.AddDate(0, 1, 0)
+}
+
+// defaultLimit returns the service-wide monthly cap for metric; 0 means unlimited.
+func (q *quotas) defaultLimit(metric QuotaMetric) int {
+	switch metric {
+	case QuotaMetricBillsCreated:
+		return q.cfg.MonthlyBillQuota
+	case QuotaMetricLineItems:
+		return q.cfg.MonthlyLineItemQuota
+	}
+	return 0
+}
+
+// limit returns the tenant's cap for metric, preferring an admin override to the default.
+func (q *quotas) limit(ctx context.Context, tenantID string, metric QuotaMetric) (int, bool, error) {
+	var limit int
+	err := q.db.QueryRow(ctx, `
+        SELECT monthly_limit FROM quota_overrides
+        WHERE tenant_id = $1 AND metric = $2
+    `, tenantID, metric).Scan(&limit)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return q.defaultLimit(metric), false, nil
+	}
+	if err != nil {
+		return 0, false, apierr.Wrap(err, "failed to load quota override for tenant %s", tenantID)
+	}
+	return limit, true, nil
+}
+
+// consume records one use of metric for the tenant, failing with ResourceExhausted once
+// the monthly cap is reached. Usage is tracked even when the tenant has no cap.
+func (q *quotas) consume(ctx context.Context, tenantID string, metric QuotaMetric) error {
+	limit, _, err := q.limit(ctx, tenantID, metric)
+	if err != nil {
+		return err
+	}
+	period, resetsAt := quotaPeriod(q.clock.Now())
+
+	// The increment only applies while under the cap, so concurrent requests cannot overshoot it.
+	var used int
+	err = q.db.QueryRow(ctx, `
+        INSERT INTO quota_usage (tenant_id, metric, period, used)
+        VALUES ($1, $2, $3, 1)
+        ON CONFLICT (tenant_id, metric, period) DO UPDATE
+        SET used = quota_usage.used + 1
+        WHERE $4 <= 0 OR quota_usage.used < $4
+        RETURNING used
+    `, tenantID, metric, period, limit).Scan(&used)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return apierr.ResourceExhausted(apierr.QuotaExhausted, "monthly %s quota of %d exhausted for tenant %s; resets at %s",
+			metric, limit, tenantID, resetsAt.Format(time.RFC3339))
+	}
+	if err != nil {
+		return apierr.Wrap(err, "failed to record %s usage for tenant %s", metric, tenantID)
+	}
+	return nil
+}
+
+// release gives back a use recorded by consume when the operation did not go through.
+func (q *quotas) release(ctx context.Context, tenantID string, metric QuotaMetric) {
+	period, _ := quotaPeriod(q.clock.Now())
+	_, err := q.db.Exec(ctx, `
+        UPDATE quota_usage SET used = used - 1
+        WHERE tenant_id = $1 AND metric = $2 AND period = $3 AND used > 0
+    `, tenantID, metric, period)
+	if err != nil {
+		// Over-counting a failed request is preferable to failing the caller a second time.
+		loggerFrom(ctx).Warn("Failed to release quota usage", "tenant_id", tenantID, "metric", metric, "error", err)
+	}
+}
+
+// tenantOrDefault maps an empty tenant ID to DefaultTenantID.
+func tenantOrDefault(tenantID string) string {
+	if tenantID == "" {
+		return DefaultTenantID
+	}
+	return tenantID
+}
+
+// GetQuotaUsage reports a tenant's usage against its monthly caps for the current period.
+//
+// encore:api auth method=GET path=/quotas/:tenantID
+func (s *Service) GetQuotaUsage(ctx context.Context, tenantID string) (*QuotaUsageResponse, error) {
+	if data := caller(ctx); data != nil && data.TenantID != tenantID {
+		return nil, apierr.PermissionDenied(apierr.InsufficientScope, "API key may not read quotas of tenant %s", tenantID)
+	}
+	period, resetsAt := quotaPeriod(s.clock.Now())
+	resp := &QuotaUsageResponse{TenantID: tenantID, Period: period, ResetsAt: resetsAt}
+
+	for _, metric := range quotaMetrics {
+		limit, overridden, err := s.quotas.limit(ctx, tenantID, metric)
+		if err != nil {
+			return nil, err
+		}
+		var used int
+		err = s.db.QueryRow(ctx, `
+            SELECT used FROM quota_usage
+            WHERE tenant_id = $1 AND metric = $2 AND period = $3
+        `, tenantID, metric, period).Scan(&used)
+		if err != nil && !errors.Is(err, sqldb.ErrNoRows) {
+			return nil, apierr.Wrap(err, "failed to load %s usage for tenant %s", metric, tenantID)
+		}
+		resp.Usage = append(resp.Usage, QuotaUsage{Metric: metric, Used: used, Limit: limit, Overridden: overridden})
+	}
+	return resp, nil
+}
+
+// SetQuotaOverride replaces a tenant's default monthly cap for one metric.
+// It is private so it can only be called by internal admin tooling.
+//
+// encore:api private method=PUT path=/admin/quotas/:tenantID/:metric
+func (s *Service) SetQuotaOverride(ctx context.Context, tenantID string, metric string, params *SetQuotaOverrideRequest) (*QuotaOverrideResponse, error) {
+	if !QuotaMetric(metric).IsValid() {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "unknown quota metric %q", metric)
+	}
+	if params.Limit < 0 {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "limit must not be negative")
+	}
+
+	_, err := s.db.Exec(ctx, `
+        INSERT INTO quota_overrides (tenant_id, metric, monthly_limit, updated_at)
+        VALUES ($1, $2, $3, NOW())
+        ON CONFLICT (tenant_id, metric) DO UPDATE
+        SET monthly_limit = EXCLUDED.monthly_limit, updated_at = EXCLUDED.updated_at
+    `, tenantID, metric, params.Limit)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to save quota override for tenant %s", tenantID)
+	}
+	return s.quotaOverrideResponse(ctx, tenantID)
+}
+
+// ClearQuotaOverride restores the service-wide default cap for a tenant's metric.
+//
+// encore:api private method=DELETE path=/admin/quotas/:tenantID/:metric
+func (s *Service) ClearQuotaOverride(ctx context.Context, tenantID string, metric string) (*QuotaOverrideResponse, error) {
+	_, err := s.db.Exec(ctx, `
+        DELETE FROM quota_overrides WHERE tenant_id = $1 AND metric = $2
+    `, tenantID, metric)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to clear quota override for tenant %s", tenantID)
+	}
+	return s.quotaOverrideResponse(ctx, tenantID)
+}
+
+// quotaOverrideResponse reports the tenant's quota state after an override change.
+func (s *Service) quotaOverrideResponse(ctx context.Context, tenantID string) (*QuotaOverrideResponse, error) {
+	usage, err := s.GetQuotaUsage(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return &QuotaOverrideResponse{Quota: usage}, nil
+}
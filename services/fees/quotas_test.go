@@ -0,0 +1,26 @@
+package fees
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestQuotaPeriod tests that usage periods are UTC calendar months.
+func TestQuotaPeriod(t *testing.T) {
+	period, resetsAt := quotaPeriod(time.Date(2024, 12, 31, 23, 30, 0, 0, time.UTC))
+	require.Equal(t, "2024-12", period)
+	require.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), resetsAt)
+
+	// 2024-07-01 01:00 in UTC+2 is still June in UTC.
+	period, _ = quotaPeriod(time.Date(2024, 7, 1, 1, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60)))
+	require.Equal(t, "2024-06", period)
+}
+
+// TestQuotaMetric_IsValid tests that only known metrics can be overridden.
+func TestQuotaMetric_IsValid(t *testing.T) {
+	require.True(t, QuotaMetricBillsCreated.IsValid())
+	require.True(t, QuotaMetricLineItems.IsValid())
+	require.False(t, QuotaMetric("refunds").IsValid())
+}
@@ -0,0 +1,132 @@
+package fees
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/middleware"
+	"golang.org/x/time/rate"
+)
+
+// RateLimit configures a token bucket: PerSecond tokens are added every second, up to Burst.
+type RateLimit struct {
+	PerSecond float64
+	Burst     int
+}
+
+// Enabled reports whether the limit throttles at all.
+func (l RateLimit) Enabled() bool { return l.PerSecond > 0 }
+
+// rateLimiterIdleTTL is how long an unused bucket is kept. Buckets idle this long have
+// refilled for any realistic limit, so dropping them does not reset anyone's budget.
+const rateLimiterIdleTTL = 10 * time.Minute
+
+// rateLimiter keeps one token bucket per key.
+type rateLimiter struct {
+	limit RateLimit
+	clock Clock
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	lim      *rate.Limiter
+	lastSeen time.Time
+}
+
+// newRateLimiter returns a limiter enforcing limit per key, or nil if limit is disabled.
+func newRateLimiter(limit RateLimit, clock Clock) *rateLimiter {
+	if !limit.Enabled() {
+		return nil
+	}
+	return &rateLimiter{limit: limit, clock: clock, buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token from key's bucket. When the bucket is empty it returns false and
+// how long the caller should wait before retrying.
+func (r *rateLimiter) allow(key string) (bool, time.Duration) {
+	if r == nil || key == "" {
+		return true, 0
+	}
+	now := r.clock.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweep(now)
+
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &bucket{lim: rate.NewLimiter(rate.Limit(r.limit.PerSecond), r.limit.Burst)}
+		r.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	res := b.lim.ReserveN(now, 1)
+	if !res.OK() {
+		return false, time.Second
+	}
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// sweep drops idle buckets at most once a minute, so memory is bounded by active callers.
+func (r *rateLimiter) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < time.Minute {
+		return
+	}
+	r.lastSweep = now
+	for key, b := range r.buckets {
+		if now.Sub(b.lastSeen) > rateLimiterIdleTTL {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+// rateLimits throttles requests per API key and per customer, so a runaway client
+// cannot starve the Temporal client or the database.
+type rateLimits struct {
+	perKey      *rateLimiter
+	perCustomer *rateLimiter
+}
+
+func newRateLimits(cfg *Config, clock Clock) *rateLimits {
+	return &rateLimits{
+		perKey:      newRateLimiter(cfg.KeyRateLimit, clock),
+		perCustomer: newRateLimiter(cfg.CustomerRateLimit, clock),
+	}
+}
+
+// checkKey takes a token for the calling API key. Internal calls without a key are not limited.
+func (l *rateLimits) checkKey(ctx context.Context) error {
+	keyID := callerKeyID(ctx)
+	if ok, retryAfter := l.perKey.allow(keyID); !ok {
+		return apierr.TooManyRequests(retryAfter, "rate limit exceeded for API key %s", keyID)
+	}
+	return nil
+}
+
+// checkCustomer takes a token for customerID. Bills without a customer are not limited.
+func (l *rateLimits) checkCustomer(customerID string) error {
+	if ok, retryAfter := l.perCustomer.allow(customerID); !ok {
+		return apierr.TooManyRequests(retryAfter, "rate limit exceeded for customer %s", customerID)
+	}
+	return nil
+}
+
+// Throttle enforces the per-key rate limit on every endpoint. The per-customer limit is
+// checked by the handlers that create bills and line items, once the customer is known.
+//
+// encore:middleware target=all
+func (s *Service) Throttle(req middleware.Request, next middleware.Next) middleware.Response {
+	if err := s.limits.checkKey(req.Context()); err != nil {
+		return middleware.Response{Err: err}
+	}
+	return next(req)
+}
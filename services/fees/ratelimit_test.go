@@ -0,0 +1,62 @@
+package fees
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestRateLimiter_Allow tests that each key gets its own bucket that refills over time.
+func TestRateLimiter_Allow(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	r := newRateLimiter(RateLimit{PerSecond: 2, Burst: 2}, clock)
+
+	for i := 0; i < 2; i++ {
+		ok, _ := r.allow("key-a")
+		require.True(t, ok)
+	}
+	ok, retryAfter := r.allow("key-a")
+	require.False(t, ok)
+	require.Equal(t, 500*time.Millisecond, retryAfter)
+
+	ok, _ = r.allow("key-b")
+	require.True(t, ok, "other keys are unaffected")
+
+	clock.Advance(500 * time.Millisecond)
+	ok, _ = r.allow("key-a")
+	require.True(t, ok, "a token is refilled after the retry delay")
+}
+
+// TestRateLimiter_DisabledAndSweep tests that a disabled limit allows everything and idle buckets are dropped.
+func TestRateLimiter_DisabledAndSweep(t *testing.T) {
+	disabled := newRateLimiter(RateLimit{}, systemClock{})
+	ok, _ := disabled.allow("key-a")
+	require.True(t, ok)
+
+	clock := newFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	r := newRateLimiter(RateLimit{PerSecond: 1, Burst: 1}, clock)
+	r.allow("key-a")
+	require.Len(t, r.buckets, 1)
+
+	clock.Advance(rateLimiterIdleTTL + time.Minute)
+	r.allow("key-b")
+	require.Len(t, r.buckets, 1)
+	require.Contains(t, r.buckets, "key-b")
+}
+
+// TestParseRateLimit tests the "<per second>[:<burst>]" configuration format.
+func TestParseRateLimit(t *testing.T) {
+	limit, err := parseRateLimit("20:100")
+	require.NoError(t, err)
+	require.Equal(t, RateLimit{PerSecond: 20, Burst: 100}, limit)
+
+	limit, err = parseRateLimit("0.5")
+	require.NoError(t, err)
+	require.Equal(t, RateLimit{PerSecond: 0.5, Burst: 1}, limit)
+
+	for _, bad := range []string{"0", "-1", "abc", "5:0", "5:x"} {
+		_, err := parseRateLimit(bad)
+		require.Error(t, err, bad)
+	}
+}
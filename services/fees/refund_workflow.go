@@ -0,0 +1,250 @@
+package fees
+
+import (
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// RefundWorkflowParams defines the parameters for starting the RefundWorkflow.
+type RefundWorkflowParams struct {
+	CreditNoteID string
+	BillID       string
+	Amount       float64
+	Currency     string
+	Reason       string
+	LineItems    []LineItem
+	// PaymentReference is the gateway reference of the payment being refunded.
+	// Without one, the credit note is issued without moving money.
+	PaymentReference string
+	// RequestedByKeyID is the API key that requested the refund.
+	RequestedByKeyID string
+}
+
+// CreditNoteResult is the outcome returned by the RefundWorkflow.
+type CreditNoteResult struct {
+	CreditNoteID     string
+	Status           RefundStatus
+	GatewayReference string
+	FailureReason    string
+	CompletedAt      time.Time
+}
+
+// RecordCreditNoteActivityParams defines parameters for RecordCreditNoteActivity.
+type RecordCreditNoteActivityParams struct {
+	CreditNoteID string
+	BillID       string
+	Amount       float64
+	Currency     string
+	Reason       string
+	LineItems    []LineItem
+	CreatedAt    time.Time
+	ActorKeyID   string
+}
+
+// RefundPaymentActivityParams defines parameters for RefundPaymentActivity.
+type RefundPaymentActivityParams struct {
+	CreditNoteID     string
+	BillID           string
+	PaymentReference string
+	Amount           float64
+	Currency         string
+}
+
+// UpdateCreditNoteActivityParams defines parameters for UpdateCreditNoteActivity.
+type UpdateCreditNoteActivityParams struct {
+	CreditNoteID     string
+	BillID           string
+	Status           RefundStatus
+	GatewayReference string
+	FailureReason    string
+	CompletedAt      time.Time
+	ActorKeyID       string
+}
+
+// RefundWorkflow records a credit note for a bill and, when the bill was paid,
+// refunds the amount through the payment gateway.
+func RefundWorkflow(ctx workflow.Context, params *RefundWorkflowParams) (*CreditNoteResult, error) {
+	logger := workflow.GetLogger(ctx)
+
+	logger.Info("RefundWorkflow started", "bill_id", params.BillID, "credit_note_id", params.CreditNoteID, "amount", params.Amount)
+
+	result := &CreditNoteResult{CreditNoteID: params.CreditNoteID}
+	err := workflow.ExecuteActivity(ctx, RecordCreditNoteActivityName, RecordCreditNoteActivityParams{
+		CreditNoteID: params.CreditNoteID,
+		BillID:       params.BillID,
+		Amount:       params.Amount,
+		Currency:     params.Currency,
+		Reason:       params.Reason,
+		LineItems:    params.LineItems,
+		CreatedAt:    workflow.Now(ctx),
+		ActorKeyID:   params.RequestedByKeyID,
+	}).Get(ctx, nil)
+	if err != nil {
+		logger.Error("Failed to execute RecordCreditNoteActivity", "bill_id", params.BillID, "credit_note_id", params.CreditNoteID, "error", err)
+		result.Status = RefundStatusFailed
+		result.FailureReason = err.Error()
+		result.CompletedAt = workflow.Now(ctx)
+		return result, nil
+	}
+
+	result.Status = RefundStatusSucceeded
+	if params.PaymentReference != "" {
+		var refund RefundResult
+		err = workflow.ExecuteActivity(ctx, RefundPaymentActivityName, RefundPaymentActivityParams{
+			CreditNoteID:     params.CreditNoteID,
+			BillID:           params.BillID,
+			PaymentReference: params.PaymentReference,
+			Amount:           params.Amount,
+			Currency:         params.Currency,
+		}).Get(ctx, &refund)
+		if err != nil {
+			logger.Error("Refund failed at gateway", "bill_id", params.BillID, "credit_note_id", params.CreditNoteID, "error", err)
+			result.Status = RefundStatusFailed
+			result.FailureReason = err.Error()
+		} else {
+			result.GatewayReference = refund.Reference
+		}
+	}
+	result.CompletedAt = workflow.Now(ctx)
+
+	err = workflow.ExecuteActivity(ctx, UpdateCreditNoteActivityName, UpdateCreditNoteActivityParams{
+		CreditNoteID:     params.CreditNoteID,
+		BillID:           params.BillID,
+		Status:           result.Status,
+		GatewayReference: result.GatewayReference,
+		FailureReason:    result.FailureReason,
+		CompletedAt:      result.CompletedAt,
+		ActorKeyID:       params.RequestedByKeyID,
+	}).Get(ctx, nil)
+	if err != nil {
+		logger.Error("Failed to execute UpdateCreditNoteActivity", "bill_id", params.BillID, "credit_note_id", params.CreditNoteID, "error", err)
+	}
+
+	logger.Info("RefundWorkflow completed", "bill_id", params.BillID, "credit_note_id", params.CreditNoteID, "status", result.Status)
+	return result, nil
+}
+
+// refund issues a credit note against the bill through a child RefundWorkflow.
+func (w *billWorkflow) refund(signal RefundBillSignal) {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	if w.outdated(RefundBillSignalName, signal.IfVersion) {
+		return
+	}
+	if !bill.isRefundable() {
+		logger.Warn("RefundBillSignal received for a bill that cannot be refunded, ignoring.", "bill_id", bill.ID, "bill_status", bill.Status)
+		return
+	}
+	if bill.disputed() {
+		logger.Warn("RefundBillSignal received for a bill with a disputed payment, ignoring.", "bill_id", bill.ID)
+		return
+	}
+
+	remaining := bill.refundableAmount()
+	amount := signal.Amount
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount <= 0 || remaining <= 0 || (amount > remaining && !(signal.CarryForward && bill.CustomerID != "")) {
+		logger.Warn("RefundBillSignal amount is not refundable, ignoring.", "bill_id", bill.ID, "amount", amount, "refundable", remaining)
+		return
+	}
+	amount, carry := bill.splitCarryForward(amount, remaining)
+
+	creditNoteID := signal.CreditNoteID
+	if creditNoteID == "" {
+		generatedID, idErr := generateID(ctx)
+		if idErr != nil {
+			logger.Error("Failed to generate CreditNoteID for bill", "bill_id", bill.ID, "error", idErr)
+			return
+		}
+		creditNoteID = generatedID
+	}
+	for _, note := range bill.CreditNotes {
+		if note.ID == creditNoteID {
+			logger.Info("Duplicate CreditNoteID received, ignoring.", "bill_id", bill.ID, "credit_note_id", creditNoteID)
+			return
+		}
+	}
+
+	lineItems, err := w.creditLineItems(amount)
+	if err != nil {
+		logger.Error("Failed to build credit note line items", "bill_id", bill.ID, "error", err)
+		return
+	}
+
+	createdAt := workflow.Now(ctx)
+	bill.CreditNotes = append(bill.CreditNotes, CreditNote{
+		ID:        creditNoteID,
+		Status:    RefundStatusPending,
+		Amount:    amount,
+		Reason:    signal.Reason,
+		LineItems: lineItems,
+		CreatedAt: &createdAt,
+	})
+	note := &bill.CreditNotes[len(bill.CreditNotes)-1]
+	w.touch()
+
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID: "refund-" + creditNoteID,
+	})
+	var result CreditNoteResult
+	err = workflow.ExecuteChildWorkflow(childCtx, RefundWorkflow, &RefundWorkflowParams{
+		CreditNoteID:     creditNoteID,
+		BillID:           bill.ID,
+		Amount:           amount,
+		Currency:         bill.Currency,
+		Reason:           signal.Reason,
+		LineItems:        lineItems,
+		PaymentReference: bill.settledPaymentReference(),
+		RequestedByKeyID: signal.RequestedByKeyID,
+	}).Get(childCtx, &result)
+	if err != nil {
+		logger.Error("RefundWorkflow failed", "bill_id", bill.ID, "credit_note_id", creditNoteID, "error", err)
+		result = CreditNoteResult{CreditNoteID: creditNoteID, Status: RefundStatusFailed, FailureReason: err.Error(), CompletedAt: workflow.Now(ctx)}
+	}
+
+	note.Status = result.Status
+	note.GatewayReference = result.GatewayReference
+	note.FailureReason = result.FailureReason
+	note.CompletedAt = &result.CompletedAt
+	if result.Status == RefundStatusSucceeded {
+		bill.RefundedAmount += amount
+		if carry > 0 {
+			w.carryForward(note, carry)
+		}
+	}
+	w.touch()
+	logger.Info("Credit note applied to workflow state", "bill_id", bill.ID, "credit_note_id", creditNoteID, "status", note.Status, "refunded_amount", bill.RefundedAmount)
+}
+
+// creditLineItems builds the negative line items of a credit note. A refund of the
+// whole bill mirrors every original item; a partial refund is a single adjustment.
+func (w *billWorkflow) creditLineItems(amount float64) ([]LineItem, error) {
+	bill := w.bill
+	if len(bill.CreditNotes) == 0 && amount == bill.TotalAmount {
+		items := make([]LineItem, 0, len(bill.LineItems))
+		for _, item := range bill.LineItems {
+			// Items billing nothing, such as usage a free tier waived, have nothing to refund.
+			if item.Amount == 0 {
+				continue
+			}
+			id, err := generateID(w.ctx)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, LineItem{
+				ID: id, Description: "Refund: " + item.Description, Amount: -item.Amount,
+				Type: LineItemCredit, ReasonCode: ReasonCodeRefund, Reference: &LineItemReference{LineItemID: item.ID},
+			})
+		}
+		return items, nil
+	}
+
+	id, err := generateID(w.ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []LineItem{{ID: id, Description: "Partial refund", Amount: -amount, Type: LineItemCredit, ReasonCode: ReasonCodeRefund}}, nil
+}
@@ -0,0 +1,171 @@
+package fees
+
+import (
+	"context"
+	"time"
+
+	"encore.app/apierr"
+)
+
+// RefundStatus represents the state of a credit note's refund.
+type RefundStatus string
+
+const (
+	RefundStatusPending   RefundStatus = "PENDING"
+	RefundStatusSucceeded RefundStatus = "SUCCEEDED"
+	RefundStatusFailed    RefundStatus = "FAILED"
+)
+
+// CreditNote records a full or partial refund issued against a closed bill.
+// Its line items carry negative amounts mirroring what was refunded.
+type CreditNote struct {
+	ID               string       `json:"id"`
+	Status           RefundStatus `json:"status"`
+	Amount           float64      `json:"amount"`
+	Reason           string       `json:"reason,omitempty"`
+	LineItems        []LineItem   `json:"lineItems"`
+	GatewayReference string       `json:"gatewayReference,omitempty"`
+	FailureReason    string       `json:"failureReason,omitempty"`
+	CreatedAt        *time.Time   `json:"createdAt"`
+	CompletedAt      *time.Time   `json:"completedAt,omitempty"`
+	// CarriedForward is the part of the credit beyond the bill's refundable amount,
+	// added to the customer's credit balance for their next bills.
+	CarriedForward float64 `json:"carriedForward,omitempty"`
+}
+
+// CreateRefundRequest is the request payload for refunding a bill.
+type CreateRefundRequest struct {
+	// Amount to refund. Omit (or send 0) to refund the remaining refundable amount.
+	Amount float64 `json:"amount,omitempty"`
+	Reason string  `json:"reason,omitempty"`
+	// CarryForward, when set, refunds what is left of the bill and adds the rest of
+	// Amount to the customer's credit balance, applied to their next bills as they
+	// close, instead of rejecting an amount beyond the refundable amount.
+	CarryForward bool `json:"carryForward,omitempty"`
+	// IfMatch, when set, refunds the bill only if it is still at this version.
+	IfMatch string `header:"If-Match"`
+}
+
+// CreateRefundResponse is the response payload after requesting a refund.
+type CreateRefundResponse struct {
+	RetryMetadata
+	BillID          string       `json:"billId"`
+	CreditNoteID    string       `json:"creditNoteId"`
+	Amount          float64      `json:"amount"`
+	CarriedForward  float64      `json:"carriedForward,omitempty"`
+	Status          RefundStatus `json:"status"`
+	ConfirmationMsg string       `json:"confirmationMsg"`
+	// StateToken identifies the bill's state once this change is applied. Pass it to
+	// GetBill as minStateToken to read the bill with the change.
+	StateToken string `json:"stateToken,omitempty"`
+}
+
+// CreateRefund issues a full or partial refund of a closed bill as a credit note.
+//
+// encore:api auth method=POST path=/bills/:billID/refunds
+func (s *Service) CreateRefund(ctx context.Context, billID string, params *CreateRefundRequest) (*CreateRefundResponse, error) {
+	if params.Amount < 0 {
+		return nil, apierr.InvalidArgument(apierr.InvalidAmount, "refund amount must be positive, got %v", params.Amount)
+	}
+	ifVersion, err := parseIfMatch(params.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	getResp, err := s.getBill(ctx, billID)
+	if err != nil {
+		return nil, err
+	}
+	bill := getResp.RetrievedBill
+
+	creditNoteID := keyedID(ctx, "refund", billID)
+	for _, note := range bill.CreditNotes {
+		if note.ID == creditNoteID {
+			// A retry of a keyed request that was already accepted.
+			return &CreateRefundResponse{
+				BillID:          billID,
+				CreditNoteID:    creditNoteID,
+				Amount:          note.Amount,
+				CarriedForward:  note.CarriedForward,
+				Status:          note.Status,
+				ConfirmationMsg: "Refund already requested for this idempotency key.",
+				StateToken:      stateToken(bill.Version),
+			}, nil
+		}
+	}
+
+	if !bill.isRefundable() {
+		return nil, apierr.FailedPrecondition(apierr.BillNotRefundable, "bill %s cannot be refunded in status %s", billID, bill.Status)
+	}
+	if bill.disputed() {
+		return nil, apierr.FailedPrecondition(apierr.BillDisputed, "bill %s has a disputed payment and cannot be refunded until the dispute is decided", billID)
+	}
+	if err := checkVersion(&bill, ifVersion); err != nil {
+		return nil, err
+	}
+
+	remaining := bill.refundableAmount()
+	amount := params.Amount
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount <= 0 || remaining <= 0 {
+		return nil, apierr.FailedPrecondition(apierr.NothingToRefund, "bill %s has nothing left to refund", billID)
+	}
+	if amount > remaining && !params.CarryForward {
+		return nil, apierr.InvalidArgument(apierr.RefundExceedsBalance, "refund amount %v exceeds refundable amount %v for bill %s", amount, remaining, billID)
+	}
+	if params.CarryForward && bill.CustomerID == "" {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "bill %s has no customer to carry credit forward to", billID)
+	}
+	refunded, carry := bill.splitCarryForward(amount, remaining)
+
+	signal := RefundBillSignal{
+		CreditNoteID:     creditNoteID,
+		Amount:           amount,
+		Reason:           params.Reason,
+		CarryForward:     params.CarryForward,
+		RequestedByKeyID: callerKeyID(ctx),
+		IfVersion:        ifVersion,
+	}
+	if err := s.signalSettlement(ctx, &bill, RefundBillSignalName, signal); err != nil {
+		return nil, apierr.FromTemporal(err, apierr.BillNotFound, "bill %s not found", billID)
+	}
+
+	return &CreateRefundResponse{
+		BillID:          billID,
+		CreditNoteID:    creditNoteID,
+		Amount:          refunded,
+		CarriedForward:  carry,
+		Status:          RefundStatusPending,
+		ConfirmationMsg: "Refund requested successfully.",
+		StateToken:      nextStateToken(&bill),
+	}, nil
+}
+
+// isRefundable reports whether credit notes can be issued against the bill.
+func (b *Bill) isRefundable() bool {
+	return b.Status == BillStatusClosed || b.Status == BillStatusPaid
+}
+
+// refundableAmount is the part of the bill total not yet covered by
+// succeeded or in-flight credit notes.
+func (b *Bill) refundableAmount() float64 {
+	credited := 0.0
+	for _, note := range b.CreditNotes {
+		if note.Status != RefundStatusFailed {
+			credited += note.Amount
+		}
+	}
+	return b.TotalAmount - credited
+}
+
+// settledPaymentReference returns the gateway reference of the bill's successful payment, if any.
+func (b *Bill) settledPaymentReference() string {
+	for i := len(b.Payments) - 1; i >= 0; i-- {
+		if b.Payments[i].Status == PaymentStatusSucceeded {
+			return b.Payments[i].GatewayReference
+		}
+	}
+	return ""
+}
@@ -0,0 +1,211 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/storage/sqldb"
+)
+
+// defaultRevenueReportMaxAge is used when FEES_REVENUE_REPORT_MAX_AGE is unset.
+const defaultRevenueReportMaxAge = 5 * time.Minute
+
+// revenueReportDateLayout is the format of a revenue report's from and to dates.
+const revenueReportDateLayout = "2006-01-02"
+
+// RevenueGrouping is the length of a revenue report's periods.
+type RevenueGrouping string
+
+const (
+	RevenueGroupByDay   RevenueGrouping = "day"
+	RevenueGroupByMonth RevenueGrouping = "month"
+)
+
+// RevenueReportParams selects the bills and periods of a revenue report.
+type RevenueReportParams struct {
+	// GroupBy is "day" or "month". Defaults to "day".
+	GroupBy string `query:"groupBy"`
+	// Currency limits the report to one currency.
+	Currency string `query:"currency"`
+	// From and To bound the report to bills closed on or after From and before To,
+	// given as UTC dates such as "2024-06-01". Both are optional.
+	From string `query:"from"`
+	To   string `query:"to"`
+	// IncludeDeleted also counts deleted bills, reporting their share of each period as
+	// DeletedAmount. It requires the bills:delete scope.
+	IncludeDeleted bool `query:"includeDeleted"`
+	// IncludeTest also counts test bills, reporting their share of each period as
+	// TestAmount. Test mode API keys only ever see test bills' revenue.
+	IncludeTest bool `query:"includeTest"`
+}
+
+// RevenuePeriod is the revenue of one currency in one period.
+type RevenuePeriod struct {
+	// Start is the first instant of the period, in UTC.
+	Start       time.Time `json:"start"`
+	Currency    string    `json:"currency"`
+	TotalAmount float64   `json:"totalAmount"`
+	// Categories splits TotalAmount by line item category. Credits are negative.
+	Categories map[LineItemCategory]float64 `json:"categories"`
+	// DeletedAmount is the part of TotalAmount from deleted bills, when the report
+	// includes them.
+	DeletedAmount float64 `json:"deletedAmount,omitempty"`
+	// TestAmount is the part of TotalAmount from test bills, when the report includes
+	// them.
+	TestAmount float64 `json:"testAmount,omitempty"`
+}
+
+// RevenueReportResponse is the response payload of a revenue report.
+type RevenueReportResponse struct {
+	GroupBy  RevenueGrouping `json:"groupBy"`
+	Currency string          `json:"currency,omitempty"`
+	// Periods holds one entry per period and currency with revenue, oldest first.
+	Periods []RevenuePeriod `json:"periods"`
+	// AsOf is when the report's data was last refreshed. Bills closed since are not
+	// included yet.
+	AsOf time.Time `json:"asOf"`
+}
+
+// revenueReport names the revenue_daily view in report_refreshes.
+const revenueReport = "revenue"
+
+// parseRevenueReportParams validates params and returns the grouping and the bounds of
+// the report. Zero bounds are unset.
+func parseRevenueReportParams(params *RevenueReportParams) (RevenueGrouping, time.Time, time.Time, error) {
+	var from, to time.Time
+	groupBy := RevenueGrouping(params.GroupBy)
+	switch groupBy {
+	case "":
+		groupBy = RevenueGroupByDay
+	case RevenueGroupByDay, RevenueGroupByMonth:
+	default:
+		return "", from, to, apierr.InvalidArgument(apierr.InvalidParameter, "invalid groupBy %q: must be \"day\" or \"month\"", params.GroupBy)
+	}
+	if params.Currency != "" && !validCurrency(params.Currency) {
+		return "", from, to, apierr.InvalidArgument(apierr.InvalidCurrency, "invalid currency %q: must be a three-letter ISO 4217 code such as \"USD\"", params.Currency)
+	}
+
+	var err error
+	if params.From != "" {
+		if from, err = time.Parse(revenueReportDateLayout, params.From); err != nil {
+			return "", from, to, apierr.InvalidArgument(apierr.InvalidParameter, "invalid from %q: must be a date such as \"2024-06-01\"", params.From)
+		}
+	}
+	if params.To != "" {
+		if to, err = time.Parse(revenueReportDateLayout, params.To); err != nil {
+			return "", from, to, apierr.InvalidArgument(apierr.InvalidParameter, "invalid to %q: must be a date such as \"2024-06-30\"", params.To)
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return "", from, to, apierr.InvalidArgument(apierr.InvalidParameter, "from %s must be before to %s", params.From, params.To)
+	}
+	return groupBy, from, to, nil
+}
+
+// GetRevenueReport returns the line item totals of closed bills per period, currency,
+// and line item category. Bills count towards the UTC day or month they closed in.
+// Deleted bills are left out unless params.IncludeDeleted, and test bills unless
+// params.IncludeTest. Tenant-scoped callers only see their own tenant's revenue.
+//
+// The report reads the revenue_daily materialized view, which is refreshed when it is
+// older than FEES_REVENUE_REPORT_MAX_AGE.
+//
+// encore:api auth method=GET path=/reports/revenue
+func (s *Service) GetRevenueReport(ctx context.Context, params *RevenueReportParams) (*RevenueReportResponse, error) {
+	groupBy, from, to, err := parseRevenueReportParams(params)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIncludeDeleted(ctx, params.IncludeDeleted); err != nil {
+		return nil, err
+	}
+	asOf, err := s.refreshRevenueReport(ctx)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to refresh revenue report")
+	}
+
+	modes := callerBillModes(ctx, params.IncludeTest)
+	var fromArg, toArg *time.Time
+	if !from.IsZero() {
+		fromArg = &from
+	}
+	if !to.IsZero() {
+		toArg = &to
+	}
+	rows, err := s.db.Query(ctx, `
+        SELECT date_trunc($1::text, day AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS period,
+               currency, category, SUM(amount)::float8, COALESCE(SUM(amount) FILTER (WHERE deleted), 0)::float8,
+               COALESCE(SUM(amount) FILTER (WHERE test), 0)::float8
+        FROM revenue_daily
+        WHERE ($2 = '' OR currency = $2) AND ($3 = '' OR tenant_id = $3)
+          AND ($4::timestamptz IS NULL OR day >= $4) AND ($5::timestamptz IS NULL OR day < $5)
+          AND ($6 OR NOT deleted) AND (($7 AND NOT test) OR ($8 AND test))
+        GROUP BY 1, 2, 3
+        ORDER BY 1, 2, 3
+    `, string(groupBy), params.Currency, callerTenant(ctx), fromArg, toArg, params.IncludeDeleted, modes.Live, modes.Test)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load revenue report")
+	}
+	defer rows.Close()
+
+	resp := &RevenueReportResponse{GroupBy: groupBy, Currency: params.Currency, Periods: []RevenuePeriod{}, AsOf: asOf}
+	for rows.Next() {
+		var start time.Time
+		var currency string
+		var category LineItemCategory
+		var amount, deleted, test float64
+		if err := rows.Scan(&start, &currency, &category, &amount, &deleted, &test); err != nil {
+			return nil, apierr.Wrap(err, "failed to read revenue report")
+		}
+		// Rows are ordered by period and currency, so each entry's rows are adjacent.
+		n := len(resp.Periods)
+		if n == 0 || !resp.Periods[n-1].Start.Equal(start) || resp.Periods[n-1].Currency != currency {
+			resp.Periods = append(resp.Periods, RevenuePeriod{Start: start.UTC(), Currency: currency, Categories: map[LineItemCategory]float64{}})
+			n++
+		}
+		period := &resp.Periods[n-1]
+		period.Categories[category] = roundAmount(amount)
+		period.TotalAmount = roundAmount(period.TotalAmount + amount)
+		period.DeletedAmount = roundAmount(period.DeletedAmount + deleted)
+		period.TestAmount = roundAmount(period.TestAmount + test)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read revenue report")
+	}
+	return resp, nil
+}
+
+// refreshRevenueReport refreshes the revenue_daily view if it is older than the
+// configured maximum age, and returns when it was last refreshed. If the refresh
+// fails, the stale view is used.
+func (s *Service) refreshRevenueReport(ctx context.Context) (time.Time, error) {
+	var refreshedAt time.Time
+	err := s.db.QueryRow(ctx, `SELECT refreshed_at FROM report_refreshes WHERE report = $1`, revenueReport).Scan(&refreshedAt)
+	if err != nil && !errors.Is(err, sqldb.ErrNoRows) {
+		return time.Time{}, err
+	}
+	now := s.clock.Now()
+	if err == nil && now.Sub(refreshedAt) < s.cfg.RevenueReportMaxAge {
+		return refreshedAt, nil
+	}
+
+	// Concurrently, so that reports already reading the view are not blocked.
+	if _, refreshErr := s.db.Exec(ctx, `REFRESH MATERIALIZED VIEW CONCURRENTLY revenue_daily`); refreshErr != nil {
+		if err == nil {
+			loggerFrom(ctx).Warn("Failed to refresh revenue report, using stale data", "refreshed_at", refreshedAt, "error", refreshErr)
+			return refreshedAt, nil
+		}
+		return time.Time{}, refreshErr
+	}
+	_, err = s.db.Exec(ctx, `
+        INSERT INTO report_refreshes (report, refreshed_at)
+        VALUES ($1, $2)
+        ON CONFLICT (report) DO UPDATE SET refreshed_at = EXCLUDED.refreshed_at
+    `, revenueReport, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return now, nil
+}
@@ -0,0 +1,33 @@
+package fees
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseRevenueReportParams tests the defaults and validation of revenue report parameters.
+func TestParseRevenueReportParams(t *testing.T) {
+	groupBy, from, to, err := parseRevenueReportParams(&RevenueReportParams{})
+	require.NoError(t, err)
+	require.Equal(t, RevenueGroupByDay, groupBy)
+	require.True(t, from.IsZero())
+	require.True(t, to.IsZero())
+
+	groupBy, from, to, err = parseRevenueReportParams(&RevenueReportParams{GroupBy: "month", Currency: "EUR", From: "2024-01-01", To: "2024-07-01"})
+	require.NoError(t, err)
+	require.Equal(t, RevenueGroupByMonth, groupBy)
+	require.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), from)
+	require.Equal(t, time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC), to)
+
+	for _, params := range []RevenueReportParams{
+		{GroupBy: "week"},
+		{Currency: "eur"},
+		{From: "2024-06"},
+		{From: "2024-06-01", To: "2024-06-01"},
+	} {
+		_, _, _, err := parseRevenueReportParams(&params)
+		require.Error(t, err, params)
+	}
+}
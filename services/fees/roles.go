@@ -0,0 +1,136 @@
+package fees
+
+import (
+	"context"
+	"errors"
+
+	"encore.app/apierr"
+	"encore.dev/storage/sqldb"
+)
+
+// Role grants an API key a fixed set of scopes, so keys for people and systems with
+// the same job can be issued without listing scopes one by one.
+type Role string
+
+const (
+	// RoleReader reads bills, e.g. support staff answering customer questions.
+	RoleReader Role = "reader"
+	// RoleBiller runs billing: it creates, closes, and collects bills and refunds them.
+	RoleBiller Role = "biller"
+	// RoleAuditor reads bills with their audit trail, revenue reports, and the ledger.
+	RoleAuditor Role = "auditor"
+	// RoleAdmin may call every endpoint of its tenant, including approvals, erasure, and
+	// deleting bills.
+	RoleAdmin Role = "admin"
+)
+
+// RoleDefinition is a role and the scopes it grants.
+type RoleDefinition struct {
+	Role   Role    `json:"role"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// roleDefinitions lists the roles keys can be granted. payloads:decode exposes every
+// tenant's data, so no role grants it; it must be granted to a key directly.
+var roleDefinitions = []RoleDefinition{
+	{Role: RoleReader, Scopes: []Scope{ScopeBillsRead, ScopeQuotasRead}},
+	{Role: RoleBiller, Scopes: []Scope{ScopeBillsRead, ScopeBillsWrite, ScopePaymentsWrite, ScopeQuotasRead}},
+	{Role: RoleAuditor, Scopes: []Scope{ScopeBillsRead, ScopeAuditRead, ScopeReportsRead}},
+	{Role: RoleAdmin, Scopes: []Scope{ScopeBillsRead, ScopeBillsWrite, ScopePaymentsWrite, ScopeQuotasRead, ScopeAuditRead, ScopeBillsApprove, ScopeReportsRead, ScopeCustomersErase, ScopeBillsDelete}},
+}
+
+// IsValid reports whether r is a known role.
+func (r Role) IsValid() bool {
+	return r.scopes() != nil
+}
+
+// scopes returns the scopes r grants, or nil for an unknown role.
+func (r Role) scopes() []Scope {
+	for _, def := range roleDefinitions {
+		if def.Role == r {
+			return def.Scopes
+		}
+	}
+	return nil
+}
+
+// grants reports whether r grants scope.
+func (r Role) grants(scope Scope) bool {
+	for _, s := range r.scopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// rolesGranting returns the names of the roles that grant scope, for error messages.
+func rolesGranting(scope Scope) []string {
+	var roles []string
+	for _, def := range roleDefinitions {
+		if def.Role.grants(scope) {
+			roles = append(roles, string(def.Role))
+		}
+	}
+	return roles
+}
+
+// validateRoles rejects unknown roles.
+func validateRoles(roles []Role) ([]string, error) {
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if !role.IsValid() {
+			return nil, apierr.InvalidArgument(apierr.InvalidParameter, "unknown role %q", role)
+		}
+		names = append(names, string(role))
+	}
+	return names, nil
+}
+
+// ListRolesResponse is the response payload for listing roles.
+type ListRolesResponse struct {
+	Roles []RoleDefinition `json:"roles"`
+}
+
+// SetAPIKeyRolesRequest is the request payload for changing the roles of an API key.
+type SetAPIKeyRolesRequest struct {
+	// Roles replace the key's roles; empty removes them all. The key's own scopes are
+	// kept.
+	Roles []Role `json:"roles"`
+}
+
+// SetAPIKeyRolesResponse is the response payload after changing the roles of an API key.
+type SetAPIKeyRolesResponse struct {
+	RetryMetadata
+	Key APIKey `json:"key"`
+}
+
+// ListRoles lists the roles API keys can be granted and the scopes each grants.
+//
+// encore:api private method=GET path=/admin/roles
+func (s *Service) ListRoles(ctx context.Context) (*ListRolesResponse, error) {
+	return &ListRolesResponse{Roles: roleDefinitions}, nil
+}
+
+// SetAPIKeyRoles replaces the roles of an API key. The change applies from the key's
+// next request.
+//
+// encore:api private method=PUT path=/admin/api-keys/:keyID/roles
+func (s *Service) SetAPIKeyRoles(ctx context.Context, keyID string, params *SetAPIKeyRolesRequest) (*SetAPIKeyRolesResponse, error) {
+	roles, err := validateRoles(params.Roles)
+	if err != nil {
+		return nil, err
+	}
+	row := s.db.QueryRow(ctx, `
+        UPDATE api_keys SET roles = $2
+        WHERE id = $1
+        RETURNING `+apiKeyColumns, keyID, roles)
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return nil, apierr.NotFound(apierr.APIKeyNotFound, "API key %s not found", keyID)
+	}
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to set roles of API key %s", keyID)
+	}
+	return &SetAPIKeyRolesResponse{Key: *key}, nil
+}
@@ -0,0 +1,52 @@
+package fees
+
+import (
+	"testing"
+
+	"encore.dev/beta/errs"
+	"github.com/stretchr/testify/require"
+
+	"encore.app/apierr"
+)
+
+// TestRoles tests that roles grant their scopes on top of a key's own, so support
+// staff with the reader role can read bills but not close or refund them.
+func TestRoles(t *testing.T) {
+	support := &AuthData{KeyID: "key-support", Roles: []Role{RoleReader}}
+	require.NoError(t, checkScope("GetBill", support))
+	require.NoError(t, checkScope("SearchBills", support))
+	require.Equal(t, apierr.InsufficientScope, apierr.ReasonOf(checkScope("CloseBill", support)))
+	require.Equal(t, apierr.InsufficientScope, apierr.ReasonOf(checkScope("CreateRefund", support)))
+	var denied *errs.Error
+	require.ErrorAs(t, checkScope("CloseBill", support), &denied)
+	require.Contains(t, denied.Message, "biller, admin")
+
+	biller := &AuthData{KeyID: "key-billing", Roles: []Role{RoleBiller}}
+	require.NoError(t, checkScope("CloseBill", biller))
+	require.NoError(t, checkScope("CreateRefund", biller))
+	require.Error(t, checkScope("GetBillAudit", biller))
+	require.Error(t, checkScope("ApproveBill", biller))
+
+	auditor := &AuthData{KeyID: "key-audit", Roles: []Role{RoleAuditor}}
+	require.NoError(t, checkScope("GetBillAudit", auditor))
+	require.NoError(t, checkScope("GetRevenueReport", auditor))
+	require.Error(t, checkScope("AddLineItem", auditor))
+
+	admin := &AuthData{KeyID: "key-admin", Roles: []Role{RoleAdmin}}
+	require.NoError(t, checkScope("ApproveBill", admin))
+	require.NoError(t, checkScope("EraseCustomerData", admin))
+	require.Error(t, checkScope("PayloadCodec", admin))
+
+	mixed := &AuthData{KeyID: "key-mixed", Scopes: []Scope{ScopeAuditRead}, Roles: []Role{RoleReader}}
+	require.NoError(t, checkScope("GetBillEvents", mixed))
+	require.NoError(t, checkScope("GetBill", mixed))
+
+	for _, def := range roleDefinitions {
+		require.True(t, def.Role.IsValid())
+		for _, scope := range def.Scopes {
+			require.True(t, scope.IsValid(), "%s grants unknown scope %s", def.Role, scope)
+		}
+	}
+	_, err := validateRoles([]Role{RoleReader, "superuser"})
+	require.Equal(t, apierr.InvalidParameter, apierr.ReasonOf(err))
+}
@@ -0,0 +1,152 @@
+package fees
+
+import (
+	"context"
+	"strings"
+
+	"encore.app/apierr"
+)
+
+const (
+	// minSearchQueryLength is the shortest query the trigram indexes can serve.
+	minSearchQueryLength = 3
+	// defaultSearchLimit and maxSearchLimit bound a page of search results.
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchMatch names the part of a bill a search query matched.
+type SearchMatch string
+
+const (
+	SearchMatchBillID     SearchMatch = "billId"
+	SearchMatchNumber     SearchMatch = "number"
+	SearchMatchCustomerID SearchMatch = "customerId"
+	SearchMatchLineItem   SearchMatch = "lineItem"
+)
+
+// SearchBillsParams is the query of a bill search.
+type SearchBillsParams struct {
+	// Q is matched against bill IDs, bill numbers, customer IDs, and line item
+	// descriptions that are not encrypted.
+	Q      string `query:"q"`
+	Limit  int    `query:"limit"`
+	Offset int    `query:"offset"`
+}
+
+// BillSearchResult is a bill matching a search, as saved in the database. Line items
+// are not included.
+type BillSearchResult struct {
+	Bill Bill `json:"bill"`
+	// Score ranks the result; an exact ID or number match scores 1.
+	Score     float64       `json:"score"`
+	MatchedOn []SearchMatch `json:"matchedOn"`
+}
+
+// SearchBillsResponse is the response payload of a bill search.
+type SearchBillsResponse struct {
+	Results    []BillSearchResult `json:"results"`
+	TotalCount int                `json:"totalCount"`
+	Limit      int                `json:"limit"`
+	Offset     int                `json:"offset"`
+}
+
+// searchBillsQuery ranks bills by how closely their ID, number, customer ID, or line
+// item descriptions resemble $1, using the pg_trgm indexes on those columns. Encrypted
+// descriptions cannot be matched and are skipped, and deleted bills are left out, as
+// are live bills when $5 is set for a test mode API key.
+const searchBillsQuery = `
+        SELECT ` + billColumns + `, m.score, m.matched_id, m.matched_number, m.matched_customer, m.matched_item, COUNT(*) OVER ()
+        FROM (
+            SELECT bill_id, MAX(score) AS score, bool_or(matched_id) AS matched_id, bool_or(matched_number) AS matched_number,
+                   bool_or(matched_customer) AS matched_customer, bool_or(matched_item) AS matched_item
+            FROM (
+                SELECT id AS bill_id,
+                       CASE WHEN id = $1 OR number = $1 OR customer_id = $1 THEN 1
+                            ELSE GREATEST(similarity(id, $1), similarity(number, $1), similarity(customer_id, $1)) END AS score,
+                       id = $1 OR id % $1 AS matched_id,
+                       COALESCE(number = $1 OR number % $1, false) AS matched_number,
+                       customer_id = $1 OR customer_id % $1 AS matched_customer,
+                       false AS matched_item
+                FROM bills
+                WHERE id = $1 OR number = $1 OR customer_id = $1 OR id % $1 OR number % $1 OR customer_id % $1
+                UNION ALL
+                SELECT bill_id, word_similarity($1, description), false, false, false, true
+                FROM line_items
+                WHERE $1 <% description AND description NOT LIKE 'enc:%'
+            ) candidates
+            GROUP BY bill_id
+        ) m
+        JOIN bills ON bills.id = m.bill_id
+        WHERE ($2 = '' OR bills.tenant_id = $2) AND bills.deleted_at IS NULL AND (bills.test OR NOT $5)
+        ORDER BY m.score DESC, bills.created_at DESC, bills.id
+        LIMIT $3 OFFSET $4
+    `
+
+// withExtraColumns scans the columns after those of scanBill into extra.
+type withExtraColumns struct {
+	row   interface{ Scan(...any) error }
+	extra []any
+}
+
+func (r withExtraColumns) Scan(dest ...any) error {
+	return r.row.Scan(append(dest, r.extra...)...)
+}
+
+// SearchBills finds bills by bill ID, bill number, customer ID, or line item
+// description, best matches first, for support agents investigating a dispute.
+// Tenant-scoped callers only find their own tenant's bills, and test mode API keys only
+// test bills.
+//
+// encore:api auth method=GET path=/bills/search
+func (s *Service) SearchBills(ctx context.Context, params *SearchBillsParams) (*SearchBillsResponse, error) {
+	query := strings.TrimSpace(params.Q)
+	if len([]rune(query)) < minSearchQueryLength {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "q must be at least %d characters", minSearchQueryLength)
+	}
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	offset := max(params.Offset, 0)
+
+	rows, err := s.db.Query(ctx, searchBillsQuery, query, callerTenant(ctx), limit, offset, callerTestMode(ctx))
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to search bills")
+	}
+	defer rows.Close()
+
+	resp := &SearchBillsResponse{Results: []BillSearchResult{}, Limit: limit, Offset: offset}
+	for rows.Next() {
+		var result BillSearchResult
+		var matchedID, matchedNumber, matchedCustomer, matchedItem bool
+		bill, err := scanBill(withExtraColumns{rows, []any{&result.Score, &matchedID, &matchedNumber, &matchedCustomer, &matchedItem, &resp.TotalCount}})
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to read search results")
+		}
+		// Search always reads the database; Stale only flags reads that fell back to it.
+		bill.Stale = false
+		result.Bill = *bill
+		result.MatchedOn = []SearchMatch{}
+		if matchedID {
+			result.MatchedOn = append(result.MatchedOn, SearchMatchBillID)
+		}
+		if matchedNumber {
+			result.MatchedOn = append(result.MatchedOn, SearchMatchNumber)
+		}
+		if matchedCustomer {
+			result.MatchedOn = append(result.MatchedOn, SearchMatchCustomerID)
+		}
+		if matchedItem {
+			result.MatchedOn = append(result.MatchedOn, SearchMatchLineItem)
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read search results")
+	}
+	return resp, nil
+}
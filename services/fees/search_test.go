@@ -0,0 +1,18 @@
+package fees
+
+import (
+	"context"
+	"testing"
+
+	"encore.app/apierr"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSearchBills_ShortQuery tests that queries too short for the trigram indexes are rejected.
+func TestSearchBills_ShortQuery(t *testing.T) {
+	s := &Service{}
+	for _, q := range []string{"", "ab", "  ab  "} {
+		_, err := s.SearchBills(context.Background(), &SearchBillsParams{Q: q})
+		require.Equal(t, apierr.InvalidParameter, apierr.ReasonOf(err), q)
+	}
+}
@@ -2,16 +2,22 @@ package fees
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"strings"
 	"time"
 
+	"encore.app/apierr"
 	"encore.dev/storage/sqldb"
-	"github.com/google/uuid"
 	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
 	"go.temporal.io/api/workflowservice/v1"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/interceptor"
 	"go.temporal.io/sdk/worker"
+	"google.golang.org/grpc"
 )
 
 // env specific task queue name
@@ -19,13 +25,56 @@ var (
 	feesTaskQueue = getTaskQueueName()
 )
 
+const (
+	// closePollTimeout bounds how long CloseBill waits for the workflow to report the bill closed.
+	closePollTimeout = 10 * time.Second
+	// closePollInterval is the pause between CloseBill's status queries.
+	closePollInterval = 250 * time.Millisecond
+	// lineItemWaitTimeout bounds how long AddLineItem with wait=true waits for the item to be applied.
+	lineItemWaitTimeout = 10 * time.Second
+	// lineItemPollInterval is the pause between AddLineItem's wait queries.
+	lineItemPollInterval = 100 * time.Millisecond
+	// stateTokenWaitTimeout bounds how long GetBill waits for a bill to reach a minStateToken.
+	stateTokenWaitTimeout = 10 * time.Second
+	// stateTokenPollInterval is the pause between GetBill's reads while it waits.
+	stateTokenPollInterval = 100 * time.Millisecond
+	// maxClientReferenceLength caps a line item's client reference.
+	maxClientReferenceLength = 255
+)
+
 // Service defines the fees service.
 //
 // encore:service
 type Service struct {
-	db             *sqldb.Database
-	temporalClient client.Client
-	temporalWorker worker.Worker
+	db              *tracedDB
+	temporalClient  client.Client
+	temporalWorkers []worker.Worker
+	statusMetrics   *statusMetrics
+	metrics         *serviceMetrics
+	clock           Clock
+	cfg             *Config
+	router          *LateItemRouter
+	grpcServer      *grpc.Server
+	quotas          *quotas
+	limits          *rateLimits
+	// payloadCodec serves the codec server; it is nil unless payloads are encrypted.
+	payloadCodec *payloadCodec
+	// fields decrypts line item columns; it is nil unless they are encrypted.
+	fields *fieldCipher
+	// mailer emails bills resent through the API.
+	mailer *billMailer
+	// notifier sends the notifications raised by API calls rather than workflows.
+	notifier Notifier
+	// archiver reads archived bills; it is nil unless archive signing keys are set.
+	archiver *billArchiver
+	// breaker guards temporalClient; outbox is nil unless queuing is enabled.
+	breaker    *circuitBreaker
+	outbox     *outbox
+	stopOutbox context.CancelFunc
+	// stopEventRelay stops publishing bill events from the event outbox.
+	stopEventRelay context.CancelFunc
+	// shutdownTracing flushes buffered spans to the trace exporter.
+	shutdownTracing func(context.Context) error
 }
 
 var db = sqldb.NewDatabase("fees", sqldb.DatabaseConfig{
@@ -34,56 +83,321 @@ var db = sqldb.NewDatabase("fees", sqldb.DatabaseConfig{
 
 // initService is automatically called by Encore to initialize the service.
 func initService() (*Service, error) {
-	c, err := client.Dial(client.Options{})
+	cfg, err := loadConfig()
 	if err != nil {
-		return nil, fmt.Errorf("could not create temporal client: %w", err)
+		return nil, fmt.Errorf("could not load fees config: %w", err)
 	}
+	slog.SetDefault(newLogger(cfg.LogLevel))
 
-	w := worker.New(c, feesTaskQueue, worker.Options{})
+	shutdownTracing, err := initTracing(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	tracing, err := newTemporalTracingInterceptor()
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporal tracing interceptor: %w", err)
+	}
 
-	// Register workflows and activities
-	w.RegisterWorkflow(BillWorkflow)
+	fields, err := newFieldCipher(cfg.FieldEncryptionKeys)
+	if err != nil {
+		return nil, err
+	}
 
-	dbActivities := &Activities{DB: db}
-	w.RegisterActivity(dbActivities.UpsertBillActivity)
-	w.RegisterActivity(dbActivities.SaveLineItemActivity)
-	w.RegisterActivity(dbActivities.UpdateBillOnCloseActivity)
+	var clock Clock = systemClock{}
+	metrics := newServiceMetrics()
+	clientOptions, err := cfg.Temporal.clientOptions()
+	if err != nil {
+		return nil, fmt.Errorf("could not configure temporal client: %w", err)
+	}
+	clientOptions.Interceptors = []interceptor.ClientInterceptor{tracing, &clientMetricsInterceptor{metrics: metrics}, &clientLoggingInterceptor{metrics: metrics}}
+	clientOptions.MetricsHandler = &temporalMetricsHandler{metrics: metrics}
+	clientOptions.Logger = temporalLogger(slog.Default())
+	c, err := client.Dial(clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporal client: %w", err)
+	}
 
-	err = w.Start()
+	tdb := newTracedDB(db, cfg.DB)
+	router := &LateItemRouter{DB: tdb, Temporal: c, Config: cfg}
+	mailer := &billMailer{DB: tdb, Sender: cfg.Email.sender(clock), Config: cfg.Email}
+	notifier, err := newOpsNotifier(cfg.Ops, LogNotifier{}, clock)
 	if err != nil {
 		c.Close()
-		return nil, fmt.Errorf("could not start temporal worker: %w", err)
+		return nil, fmt.Errorf("could not configure ops notifications: %w", err)
+	}
+	archiver := newBillArchiver(tdb, fields, cfg.ArchiveSigningKeys, clock)
+	dbActivities := &Activities{DB: tdb, Gateway: SandboxGateway{}, Notifier: notifier, Router: router, Temporal: c, Namespace: cfg.Temporal.Namespace, Fields: fields, Mailer: mailer, Archiver: archiver}
+
+	var workers []worker.Worker
+	for _, queue := range cfg.TaskQueues.Poll {
+		w := newWorker(c, queue, cfg.Worker, &workerMetricsInterceptor{metrics: metrics}, &workerLoggingInterceptor{})
+		register(w, dbActivities)
+		if err := w.Start(); err != nil {
+			stopWorkers(workers)
+			c.Close()
+			return nil, fmt.Errorf("could not start temporal worker for task queue %s: %w", queue, err)
+		}
+		workers = append(workers, w)
+	}
+
+	// The sweeps are not needed to serve requests, so a Temporal outage only delays them.
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := ensureCloseSweepSchedule(ctx, c.ScheduleClient(), cfg); err != nil {
+			slog.Warn("Could not set up the close sweep schedule", "schedule_id", CloseSweepScheduleID, "error", err)
+		}
+		if err := ensureArchiveSweepSchedule(ctx, c.ScheduleClient(), cfg); err != nil {
+			slog.Warn("Could not set up the archive sweep schedule", "schedule_id", ArchiveSweepScheduleID, "error", err)
+		}
+		if err := ensureRetentionSweepSchedule(ctx, c.ScheduleClient(), cfg); err != nil {
+			slog.Warn("Could not set up the retention sweep schedule", "schedule_id", RetentionSweepScheduleID, "error", err)
+		}
+		if err := ensureAccrualSnapshotSchedule(ctx, c.ScheduleClient(), cfg); err != nil {
+			slog.Warn("Could not set up the accrual snapshot schedule", "schedule_id", AccrualSnapshotScheduleID, "error", err)
+		}
+	}()
+
+	breaker := newCircuitBreaker(clock, cfg.Temporal.BreakerFailures, cfg.Temporal.BreakerCooldown)
+	guarded := &breakerClient{Client: c, breaker: breaker}
+
+	svc := &Service{
+		db:              tdb,
+		temporalClient:  guarded,
+		breaker:         breaker,
+		temporalWorkers: workers,
+		statusMetrics:   &statusMetrics{clock: clock},
+		metrics:         metrics,
+		clock:           clock,
+		cfg:             cfg,
+		router:          router,
+		shutdownTracing: shutdownTracing,
+		quotas:          &quotas{db: tdb, cfg: cfg, clock: clock},
+		limits:          newRateLimits(cfg, clock),
+		fields:          fields,
+		mailer:          mailer,
+		notifier:        notifier,
+		archiver:        archiver,
+	}
+	if len(cfg.Temporal.PayloadKeys) > 0 {
+		// The keys were already checked when the client was configured.
+		svc.payloadCodec, _ = newPayloadCodec(cfg.Temporal.PayloadKeys)
+	}
+
+	if cfg.GRPCAddr != "" {
+		svc.grpcServer, err = startGRPCServer(cfg.GRPCAddr, svc)
+		if err != nil {
+			stopWorkers(workers)
+			c.Close()
+			return nil, fmt.Errorf("could not start gRPC server: %w", err)
+		}
+	}
+
+	if cfg.Temporal.Outbox {
+		svc.outbox = &outbox{db: tdb, temporal: guarded, clock: clock, interval: cfg.Temporal.OutboxInterval}
+		var outboxCtx context.Context
+		outboxCtx, svc.stopOutbox = context.WithCancel(context.Background())
+		go svc.outbox.run(outboxCtx)
+	}
+
+	relay := &eventRelay{
+		db:         tdb,
+		fields:     fields,
+		publishers: []EventPublisher{topicPublisher{topic: BillEvents}},
+		clock:      clock,
+		interval:   cfg.EventRelayInterval,
+	}
+	if cfg.EventWebhookURL != "" {
+		relay.publishers = append(relay.publishers, &webhookPublisher{
+			url:     cfg.EventWebhookURL,
+			secret:  cfg.EventWebhookSecret,
+			client:  &http.Client{Timeout: eventWebhookTimeout},
+			clock:   clock,
+			metrics: svc.statusMetrics,
+		})
 	}
+	var relayCtx context.Context
+	relayCtx, svc.stopEventRelay = context.WithCancel(context.Background())
+	go relay.run(relayCtx)
 
-	return &Service{db: db, temporalClient: c, temporalWorker: w}, nil
+	return svc, nil
 }
 
 // Shutdown is called by Encore when the service is shutting down.
 func (s *Service) Shutdown(force context.Context) {
-	s.temporalWorker.Stop()
+	if s.grpcServer != nil {
+		s.grpcServer.GracefulStop()
+	}
+	if s.stopOutbox != nil {
+		s.stopOutbox()
+	}
+	if s.stopEventRelay != nil {
+		s.stopEventRelay()
+	}
+	stopWorkers(s.temporalWorkers)
 	s.temporalClient.Close()
+	if err := s.shutdownTracing(force); err != nil {
+		slog.Warn("Failed to flush traces", "error", err)
+	}
 }
 
 // CreateBill creates a new bill.
 //
-// encore:api public method=POST path=/bills
+// encore:api auth method=POST path=/bills
 func (s *Service) CreateBill(ctx context.Context, params *CreateBillRequest) (*CreateBillResponse, error) {
-	billID := uuid.NewString()
+	if !validCurrency(params.Currency) {
+		return nil, apierr.InvalidArgument(apierr.InvalidCurrency, "invalid currency %q: must be a three-letter ISO 4217 code such as \"USD\"", params.Currency)
+	}
+	if params.DueDate != nil && !params.DueDate.After(s.clock.Now()) {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "dueDate %s must be in the future", params.DueDate.Format(time.RFC3339))
+	}
+	if params.PeriodEnd != nil && !params.PeriodEnd.After(s.clock.Now()) {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "periodEnd %s must be in the future", params.PeriodEnd.Format(time.RFC3339))
+	}
+	if params.SpendingAlerts != nil {
+		if err := params.SpendingAlerts.normalize(); err != nil {
+			return nil, err
+		}
+	}
+	if params.Aggregation != nil {
+		if err := params.Aggregation.normalize(); err != nil {
+			return nil, err
+		}
+	}
+	if err := normalizeUsagePrices(params.UsagePrices); err != nil {
+		return nil, err
+	}
+	if err := normalizeFreeTiers(params.FreeTiers, params.UsagePrices); err != nil {
+		return nil, err
+	}
+	if err := normalizeCouponCodes(params.Coupons); err != nil {
+		return nil, err
+	}
+	if err := s.limits.checkCustomer(params.CustomerID); err != nil {
+		return nil, err
+	}
+	tenantID := requestTenant(ctx, params.TenantID)
+	params.Test = params.Test || callerTestMode(ctx)
+	if params.ParentBillID != "" {
+		if err := s.checkParentBill(ctx, tenantID, params); err != nil {
+			return nil, err
+		}
+	}
+	billID := keyedID(ctx, "bill", tenantID)
+	if params.Test {
+		billID = testBillIDPrefix + billID
+	}
 
-	workflowParams := BillWorkflowParams{
-		BillID:     billID,
-		CustomerID: params.CustomerID,
-		Currency:   params.Currency,
+	var template *BillTemplate
+	if params.TemplateID != "" {
+		t, err := loadBillTemplate(ctx, s.db, params.TemplateID)
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to load bill template %s", params.TemplateID)
+		}
+		if t == nil {
+			return nil, apierr.NotFound(apierr.TemplateNotFound, "bill template %s not found", params.TemplateID)
+		}
+		if t.Currency != "" && t.Currency != params.Currency {
+			return nil, apierr.InvalidArgument(apierr.InvalidCurrency, "bill template %s is for %s bills, not %s", t.ID, t.Currency, params.Currency)
+		}
+		template = t
+	}
+
+	gracePeriod := s.cfg.CloseGracePeriod
+	closeGracePeriod := params.CloseGracePeriod
+	if closeGracePeriod == "" && template != nil {
+		closeGracePeriod = template.CloseGracePeriod
+	}
+	if closeGracePeriod != "" {
+		d, err := parseGracePeriod("closeGracePeriod", closeGracePeriod)
+		if err != nil {
+			return nil, err
+		}
+		gracePeriod = d
+	}
+
+	workflowParams, err := newBillParams(ctx, s.db, s.cfg, tenantID, params.CustomerID, params.Currency)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load billing settings for customer %s", params.CustomerID)
+	}
+	if params.SpendingAlerts != nil {
+		workflowParams.SpendingAlerts = params.SpendingAlerts
+	}
+	// A sub-bill's usage draws down its parent's commitment once rolled up, not its own.
+	var contract *Contract
+	if params.ParentBillID == "" {
+		if contract, err = loadContract(ctx, s.db, tenantID, params.CustomerID, params.Currency); err != nil {
+			return nil, apierr.Wrap(err, "failed to load contract for customer %s", params.CustomerID)
+		}
+	}
+
+	if err := s.quotas.consume(ctx, tenantID, QuotaMetricBillsCreated); err != nil {
+		return nil, err
+	}
+	var coupons []AppliedCoupon
+	if len(params.Coupons) > 0 {
+		if coupons, err = s.redeemCoupons(ctx, tenantID, billID, params.CustomerID, params.Currency, params.Coupons); err != nil {
+			s.quotas.release(ctx, tenantID, QuotaMetricBillsCreated)
+			return nil, err
+		}
+	}
+
+	workflowParams.BillID = billID
+	workflowParams.AutoCollect = params.AutoCollect
+	workflowParams.CloseGracePeriod = gracePeriod
+	workflowParams.DueDate = params.DueDate
+	workflowParams.PeriodEnd = params.PeriodEnd
+	workflowParams.CreatedByKeyID = callerKeyID(ctx)
+	workflowParams.ParentBillID = params.ParentBillID
+	workflowParams.Aggregation = params.Aggregation
+	workflowParams.UsagePrices = params.UsagePrices
+	workflowParams.FreeTiers = params.FreeTiers
+	workflowParams.Coupons = coupons
+	workflowParams.Contract = contract
+	if template != nil {
+		workflowParams.TemplateID = template.ID
+		workflowParams.TemplateItems = template.LineItems
+		// Sub-bills are collected through their parent.
+		workflowParams.AutoCollect = workflowParams.AutoCollect || (template.AutoCollect && params.ParentBillID == "")
 	}
 
 	options := client.StartWorkflowOptions{
-		ID:        "bill-" + billID,
-		TaskQueue: feesTaskQueue,
+		ID:        billWorkflowID(billID),
+		TaskQueue: s.cfg.TaskQueues.forTenant(tenantID),
+	}
+	if idempotencyKey(ctx) != "" {
+		// A keyed bill is created at most once, even after its workflow has completed.
+		options.WorkflowIDReusePolicy = enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE
+		options.WorkflowExecutionErrorWhenAlreadyStarted = true
 	}
 
 	we, err := s.temporalClient.ExecuteWorkflow(ctx, options, BillWorkflow, &workflowParams)
+	var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+	if errors.As(err, &alreadyStarted) {
+		// A retry of a keyed request: report the bill created the first time.
+		s.quotas.release(ctx, tenantID, QuotaMetricBillsCreated)
+		return &CreateBillResponse{
+			BillID:          billID,
+			WorkflowID:      options.ID,
+			RunID:           alreadyStarted.RunId,
+			InitialStatus:   BillStatusOpen,
+			ConfirmationMsg: "Bill already created for this idempotency key.",
+		}, nil
+	}
+	if err != nil && ctx.Err() != nil {
+		// The caller gave up, so the bill is not queued. It may have been created all the
+		// same, so its quota use is kept.
+		return nil, apierr.FromContext(ctx, "bill %s may not have been created", billID)
+	}
+	if err != nil && s.outbox != nil && apierr.IsTemporalUnavailable(err) {
+		return s.queueCreateBill(ctx, tenantID, options, &workflowParams, err)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to start BillWorkflow: %w", err)
+		s.quotas.release(ctx, tenantID, QuotaMetricBillsCreated)
+		if len(coupons) > 0 {
+			s.releaseCoupons(ctx, billID, params.Coupons)
+		}
+		return nil, apierr.FromTemporal(err, apierr.Internal, "failed to create bill")
 	}
 
 	// TEST STABILITY: Allow a brief moment for the workflow to initialize and set up its query handler.
@@ -102,142 +416,515 @@ func (s *Service) CreateBill(ctx context.Context, params *CreateBillRequest) (*C
 
 // AddLineItem adds a line item to an existing bill.
 //
-// encore:api public method=POST path=/bills/:billID/items
+// encore:api auth method=POST path=/bills/:billID/items
 func (s *Service) AddLineItem(ctx context.Context, billID string, params *AddLineItemRequest) (*AddLineItemResponse, error) {
-	lineItemID := uuid.NewString()
+	if err := params.validateType(); err != nil {
+		return nil, err
+	}
+	if err := params.validateAmount(); err != nil {
+		return nil, err
+	}
+	if len(params.ClientReference) > maxClientReferenceLength {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "clientReference must be at most %d characters", maxClientReferenceLength)
+	}
+	if len(params.Category) > maxLineItemCategoryLength {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "category must be at most %d characters", maxLineItemCategoryLength)
+	}
+	ifVersion, err := parseIfMatch(params.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	bill, err := s.getBill(ctx, billID)
+	if err != nil {
+		return nil, err
+	}
+	if params.ClientReference != "" {
+		if existing := bill.RetrievedBill.lineItem("", params.ClientReference); existing != nil {
+			return &AddLineItemResponse{
+				LineItemID:      existing.ID,
+				BillID:          billID,
+				Duplicate:       true,
+				StateToken:      stateToken(bill.RetrievedBill.Version),
+				ConfirmationMsg: fmt.Sprintf("Bill already holds line item %s for client reference %q.", existing.ID, params.ClientReference),
+			}, nil
+		}
+	}
+	if params.Currency != "" && !strings.EqualFold(params.Currency, bill.RetrievedBill.Currency) {
+		return nil, apierr.InvalidArgument(apierr.InvalidCurrency, "line item currency %s does not match bill %s's currency %s", params.Currency, billID, bill.RetrievedBill.Currency)
+	}
+	if err := checkVersion(&bill.RetrievedBill, ifVersion); err != nil {
+		return nil, err
+	}
+	if err := params.checkReason(s.cfg.ReasonCodes, &bill.RetrievedBill); err != nil {
+		return nil, err
+	}
+	if err := s.limits.checkCustomer(bill.RetrievedBill.CustomerID); err != nil {
+		return nil, err
+	}
+	if bill.RetrievedBill.Status == BillStatusPendingApproval {
+		return nil, apierr.FailedPrecondition(apierr.BillClosed, "bill %s is awaiting approval and does not accept line items; reject it to make changes", billID)
+	}
+	if bill.RetrievedBill.Status == BillStatusCloseFailed {
+		return nil, apierr.FailedPrecondition(apierr.BillClosed, "bill %s has been finalized and does not accept line items while its close is retried", billID)
+	}
+	if params.Metric != "" {
+		// A closed bill's usage is settled, and the next bill may price it differently.
+		if !bill.RetrievedBill.acceptsLineItems() {
+			return nil, apierr.FailedPrecondition(apierr.BillClosed, "bill %s is %s and no longer rates usage", billID, bill.RetrievedBill.Status)
+		}
+		if err := params.rateLineItem(&bill.RetrievedBill); err != nil {
+			return nil, err
+		}
+	}
+	if b := &bill.RetrievedBill; b.acceptsLineItems() {
+		signal := AddLineItemSignal{Metric: params.Metric, Quantity: params.Quantity}
+		amount, _, _ := b.waive(signal, params.Type, b.round(params.Amount), s.clock.Now())
+		if b.drawsDown(signal, params.Type) {
+			amount, _ = b.overage(amount)
+		}
+		if b.HardCap.rejects(b.lineItemTotal(), amount) {
+			return nil, hardCapExceeded(b, amount)
+		}
+		if !params.AllowNegativeTotal && params.Metric == "" && b.takesTotalNegative(amount) {
+			return nil, negativeTotal(b, amount)
+		}
+	}
+	routeLate := s.cfg.LateItemPolicy != LateItemPolicyReject
+	if !bill.RetrievedBill.acceptsLineItems() && !routeLate {
+		return nil, apierr.FailedPrecondition(apierr.BillClosed, "bill %s is %s and no longer accepts line items", billID, bill.RetrievedBill.Status)
+	}
+
+	tenantID := tenantOrDefault(bill.RetrievedBill.TenantID)
+	if err := s.quotas.consume(ctx, tenantID, QuotaMetricLineItems); err != nil {
+		return nil, err
+	}
+
+	// An item with a client reference is named after it, so a concurrent request for the
+	// same reference that passed the check above is dropped by the workflow as a duplicate.
+	lineItemID := keyedID(ctx, "line-item", billID)
+	if params.ClientReference != "" {
+		lineItemID = referenceID(billID, params.ClientReference)
+	}
 	signal := AddLineItemSignal{
-		LineItemID:  lineItemID,
-		Description: params.Description,
-		Amount:      params.Amount,
+		LineItemID:      lineItemID,
+		Description:     params.Description,
+		Amount:          params.Amount,
+		Quantity:        params.Quantity,
+		UnitPrice:       params.UnitPrice,
+		Unit:            params.Unit,
+		CreatedByKeyID:  callerKeyID(ctx),
+		ClientReference: params.ClientReference,
+		Category:        params.Category,
+		Metric:          params.Metric,
+		Type:            params.Type,
+		ReasonCode:      params.ReasonCode,
+		Reference:       params.Reference,
+		IfVersion:       ifVersion,
+
+		AllowNegativeTotal: params.AllowNegativeTotal,
+	}
+	if params.Wait && bill.RetrievedBill.Aggregation.aggregates(signal) {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "bill %s aggregates this line item, so it cannot be waited for; read the bill with the response's stateToken instead", billID)
 	}
 
-	wfID := "bill-" + billID
-	err := s.temporalClient.SignalWorkflow(ctx, wfID, "", AddLineItemSignalName, signal)
+	wfID := billWorkflowID(billID)
+	err = s.temporalClient.SignalWorkflow(ctx, wfID, "", AddLineItemSignalName, signal)
+	var notFound *serviceerror.NotFound
+	if err != nil && routeLate && errors.As(err, &notFound) {
+		// The bill's workflow has completed, so the bill is closed; forward the item.
+		resp, routeErr := s.routeLateLineItem(ctx, &bill.RetrievedBill, signal)
+		if routeErr != nil {
+			s.quotas.release(ctx, tenantID, QuotaMetricLineItems)
+		}
+		return resp, routeErr
+	}
+	if err != nil && ctx.Err() != nil {
+		// As for CreateBill, the item is not queued and its quota use is kept.
+		return nil, apierr.FromContext(ctx, "line item %s may not have been added to bill %s", lineItemID, billID)
+	}
+	// A conditional item is not queued: the bill may well have changed by the time it is replayed.
+	if err != nil && s.outbox != nil && ifVersion == 0 && apierr.IsTemporalUnavailable(err) {
+		queueErr := s.outbox.enqueue(ctx, outboxAddLineItem, billID, outboxAddLineItemPayload{WorkflowID: wfID, Signal: signal})
+		if queueErr == nil {
+			return &AddLineItemResponse{
+				LineItemID:      lineItemID,
+				BillID:          billID,
+				Queued:          true,
+				StateToken:      nextStateToken(&bill.RetrievedBill),
+				ConfirmationMsg: "Temporal is unavailable; the line item is queued and will be added once it is back.",
+			}, nil
+		}
+		loggerFrom(ctx).Error("Failed to queue line item", "error", queueErr)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to send AddLineItemSignal to workflow %s: %w", wfID, err)
+		s.quotas.release(ctx, tenantID, QuotaMetricLineItems)
+		if errors.As(err, &notFound) {
+			return nil, apierr.FailedPrecondition(apierr.BillClosed, "bill %s is closed and no longer accepts line items", billID)
+		}
+		return nil, apierr.FromTemporal(err, apierr.BillNotFound, "bill %s not found", billID)
+	}
+
+	if params.Wait {
+		applied, err := s.awaitLineItem(ctx, billID, lineItemID, ifVersion)
+		if err != nil {
+			return nil, err
+		}
+		total := applied.lineItemTotal()
+		return &AddLineItemResponse{
+			LineItemID:      lineItemID,
+			BillID:          billID,
+			TotalAmount:     &total,
+			ItemCount:       len(applied.LineItems),
+			Status:          applied.Status,
+			StateToken:      stateToken(applied.Version),
+			ConfirmationMsg: fmt.Sprintf("LineItem added; bill total is now %.2f %s across %d items.", total, applied.Currency, len(applied.LineItems)),
+		}, nil
 	}
 
 	return &AddLineItemResponse{
 		LineItemID:      lineItemID,
 		BillID:          billID,
+		StateToken:      nextStateToken(&bill.RetrievedBill),
 		ConfirmationMsg: "LineItem added successfully.",
 	}, nil
 }
 
-// CloseBill closes an existing bill.
-//
-// encore:api public method=POST path=/bills/:billID/close
-func (s *Service) CloseBill(ctx context.Context, billID string) (*CloseBillResponse, error) {
-	wfID := "bill-" + billID
-	err := s.temporalClient.SignalWorkflow(ctx, wfID, "", CloseBillSignalName, CloseBillSignal{})
+// awaitLineItem polls the bill's workflow until it reports lineItemID, for AddLineItem's
+// wait option. It gives up if the bill stops accepting line items first, since the item
+// was then dropped or routed to a later bill, or after lineItemWaitTimeout. An item sent
+// with ifVersion was dropped if the bill moved past that version without it.
+func (s *Service) awaitLineItem(ctx context.Context, billID, lineItemID string, ifVersion int64) (*Bill, error) {
+	bill, err := s.pollBillWorkflow(ctx, billID, lineItemWaitTimeout, lineItemPollInterval, func(bill *Bill) (bool, error) {
+		for _, item := range bill.LineItems {
+			if item.ID == lineItemID {
+				return true, nil
+			}
+		}
+		for _, dropped := range bill.DroppedLineItems {
+			if dropped.ID == lineItemID {
+				return false, apierr.Unavailable(apierr.LineItemDropped, "line item %s could not be saved and was removed from bill %s; send it again", lineItemID, billID)
+			}
+		}
+		for _, rejected := range bill.RejectedLineItems {
+			if rejected.ID == lineItemID && rejected.Amount < 0 {
+				return false, apierr.FailedPrecondition(apierr.NegativeTotal, "line item %s was rejected by bill %s: %s", lineItemID, billID, rejected.Reason)
+			}
+			if rejected.ID == lineItemID {
+				return false, apierr.ResourceExhausted(apierr.QuotaExceeded, "line item %s was rejected by bill %s: %s", lineItemID, billID, rejected.Reason)
+			}
+		}
+		if ifVersion != 0 && bill.Version > ifVersion {
+			return false, apierr.FailedPrecondition(apierr.VersionMismatch, "bill %s changed to version %d before line item %s was applied; re-read it and retry", billID, bill.Version, lineItemID)
+		}
+		if !bill.acceptsLineItems() {
+			return false, apierr.FailedPrecondition(apierr.BillClosed, "bill %s became %s before line item %s was applied", billID, bill.Status, lineItemID)
+		}
+		return false, nil
+	})
+	switch {
+	case errors.Is(err, errPollTimeout):
+		return nil, apierr.Unavailable(apierr.LineItemTimeout, "timeout waiting for line item %s to be applied to bill %s; it may still be applied", lineItemID, billID)
+	case err != nil && ctx.Err() != nil:
+		return nil, apierr.FromContext(ctx, "line item %s was sent to bill %s but not yet confirmed; it may still be applied", lineItemID, billID)
+	}
+	return bill, err
+}
+
+// routeLateLineItem forwards a line item sent to a completed bill to a later bill per the late item policy.
+func (s *Service) routeLateLineItem(ctx context.Context, closed *Bill, signal AddLineItemSignal) (*AddLineItemResponse, error) {
+	billID := closed.ID
+	nextID, err := s.router.Route(ctx, closed, signal, s.cfg.LateItemPolicy)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send CloseBillSignal to workflow %s: %w", wfID, err)
+		return nil, apierr.FromTemporal(err, apierr.Internal, "failed to route line item for closed bill %s", billID)
 	}
+	loggerFrom(ctx).Info("Late line item routed to the next bill", "next_bill_id", nextID, "line_item_id", signal.LineItemID)
+	return &AddLineItemResponse{
+		LineItemID:      signal.LineItemID,
+		BillID:          nextID,
+		ConfirmationMsg: fmt.Sprintf("Bill %s is closed; line item routed to bill %s.", billID, nextID),
+	}, nil
+}
 
-	var billDetails Bill
-	var lastQueryError error
-
-	// Retry querying the workflow for a short period to allow for signal processing and state update.
-	// This makes the API call more robust to timing variations.
-	pollingTimeout := time.After(10 * time.Second) // Total timeout for polling
-	retryInterval := 250 * time.Millisecond        // Interval between retries (slightly increased)
-
-	for {
-		select {
-		case <-pollingTimeout:
-			errMsg := fmt.Sprintf("timeout waiting for bill %s to close and become queryable after 10s", billID)
-			if lastQueryError != nil {
-				errMsg = fmt.Sprintf("%s. last query error: %v", errMsg, lastQueryError)
-			}
-			return nil, fmt.Errorf(errMsg)
-		default:
-			// Create a new context with a shorter timeout for each query attempt
-			// to prevent one slow query from blocking the entire polling duration.
-			queryCtx, cancelQueryCtx := context.WithTimeout(ctx, 2*time.Second)
-
-			resp, err := s.temporalClient.QueryWorkflow(queryCtx, wfID, "", GetBillDetailsQueryName)
-			cancelQueryCtx() // Important to call cancel to free resources
-
-			if err != nil {
-				lastQueryError = fmt.Errorf("query attempt for BillWorkflow %s failed: %w", wfID, err)
-				// Log the error for debugging test failures
-				slog.Warn("CloseBill: QueryWorkflow attempt failed", "billID", billID, "workflowID", wfID, "error", err.Error())
-				time.Sleep(retryInterval)
-				continue
-			}
+// parseGracePeriod parses a close grace period given in the named request field.
+func parseGracePeriod(field, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, apierr.InvalidArgument(apierr.InvalidParameter, "invalid %s %q: must be a non-negative duration such as \"5m\"", field, value)
+	}
+	return d, nil
+}
 
-			if err := resp.Get(&billDetails); err != nil {
-				lastQueryError = fmt.Errorf("failed to decode bill details for %s: %w", wfID, err)
-				slog.Warn("CloseBill: Failed to decode bill details", "billID", billID, "workflowID", wfID, "error", err.Error())
-				time.Sleep(retryInterval)
-				continue
-			}
+// CloseBill closes an existing bill, or moves it to CLOSING when a grace period applies.
+//
+// encore:api auth method=POST path=/bills/:billID/close
+func (s *Service) CloseBill(ctx context.Context, billID string, params *CloseBillRequest) (*CloseBillResponse, error) {
+	var ifVersion int64
+	if params != nil {
+		var err error
+		if ifVersion, err = parseIfMatch(params.IfMatch); err != nil {
+			return nil, err
+		}
+	}
+	if ifVersion != 0 {
+		bill, err := s.getBill(ctx, billID)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkVersion(&bill.RetrievedBill, ifVersion); err != nil {
+			return nil, err
+		}
+	} else if err := s.authorizeBill(ctx, billID); err != nil {
+		return nil, err
+	}
+	signal := CloseBillSignal{RequestedByKeyID: callerKeyID(ctx), IfVersion: ifVersion}
+	if params != nil && params.GracePeriod != "" {
+		d, err := parseGracePeriod("gracePeriod", params.GracePeriod)
+		if err != nil {
+			return nil, err
+		}
+		signal.GracePeriod = &d
+	}
+	if params != nil && params.DueDate != "" {
+		dueDate, err := time.Parse(time.RFC3339, params.DueDate)
+		if err != nil || !dueDate.After(s.clock.Now()) {
+			return nil, apierr.InvalidArgument(apierr.InvalidParameter, "invalid dueDate %q: must be a future RFC 3339 time such as \"2025-07-01T00:00:00Z\"", params.DueDate)
+		}
+		signal.DueDate = &dueDate
+	}
 
-			if billDetails.Status == BillStatusClosed {
-				slog.Info("CloseBill: Successfully queried and confirmed bill closed", "billID", billID, "workflowID", wfID)
-				goto found // exit loop
-			}
+	wfID := billWorkflowID(billID)
+	err := s.temporalClient.SignalWorkflow(ctx, wfID, "", CloseBillSignalName, signal)
+	if err != nil && ctx.Err() != nil {
+		return nil, apierr.FromContext(ctx, "close of bill %s may not have been requested", billID)
+	}
+	if err != nil {
+		s.statusMetrics.recordClose(false)
+		return nil, apierr.FromTemporal(err, apierr.BillNotFound, "bill %s not found", billID)
+	}
 
-			lastQueryError = fmt.Errorf("bill %s queryable but status is %s (expected CLOSED)", billID, billDetails.Status)
-			slog.Warn("CloseBill: Bill not yet closed", "billID", billID, "workflowID", wfID, "status", billDetails.Status)
-			time.Sleep(retryInterval)
+	// Poll the workflow for a short while, so the response reports the close. The wait
+	// ends early if the request does, so a caller that gave up is not kept polling.
+	billDetails, err := s.pollBillWorkflow(ctx, billID, closePollTimeout, closePollInterval, func(bill *Bill) (bool, error) {
+		switch {
+		case !bill.isFinalizing():
+			// An auto-collected, zero-total or overdue bill can move past CLOSED before
+			// the first poll, so any later status means the close went through.
+			return true, nil
+		case bill.Status == BillStatusClosing && bill.FinalizesAt != nil:
+			// The bill is waiting out its grace period; it will close on its own.
+			return true, nil
+		case bill.Status == BillStatusPendingApproval:
+			// The bill finalizes once ApproveBill is called.
+			return true, nil
+		case bill.Status == BillStatusCloseFailed:
+			// The close could not be saved; the workflow retries it later.
+			loggerFrom(ctx).Warn("Close could not be saved", "workflow_id", wfID, "close_failure", bill.CloseFailure)
+			return false, apierr.Unavailable(apierr.CloseFailed, "bill %s was finalized but its close could not be saved; it is retried automatically, or retry it with POST /bills/%s/close/retry", billID, billID)
+		case ifVersion != 0 && bill.Version > ifVersion:
+			// Another change reached the bill first, so the workflow dropped the close.
+			return false, apierr.FailedPrecondition(apierr.VersionMismatch, "bill %s changed to version %d before it could close; re-read it and retry", billID, bill.Version)
 		}
+		loggerFrom(ctx).Warn("Bill not yet closed", "workflow_id", wfID, "status", bill.Status)
+		return false, nil
+	})
+	switch {
+	case errors.Is(err, errPollTimeout):
+		loggerFrom(ctx).Warn("Timed out waiting for bill to close", "workflow_id", wfID)
+		s.statusMetrics.recordClose(false)
+		return nil, apierr.Unavailable(apierr.CloseTimeout, "timeout waiting for bill %s to close after %s", billID, closePollTimeout)
+	case err != nil && ctx.Err() != nil:
+		// The close was requested and may well go through; only the wait is abandoned.
+		loggerFrom(ctx).Info("Request ended while waiting for bill to close", "workflow_id", wfID, "error", ctx.Err())
+		return nil, apierr.FromContext(ctx, "close of bill %s was requested but not yet confirmed; it may still close", billID)
+	case err != nil:
+		s.statusMetrics.recordClose(false)
+		return nil, err
+	}
+	switch billDetails.Status {
+	case BillStatusClosing:
+		loggerFrom(ctx).Info("Close scheduled after grace period", "workflow_id", wfID, "finalizes_at", billDetails.FinalizesAt)
+		return &CloseBillResponse{
+			Bill:            *billDetails,
+			ConfirmationMsg: fmt.Sprintf("Close requested; bill finalizes at %s.", billDetails.FinalizesAt.Format(time.RFC3339)),
+		}, nil
+	case BillStatusPendingApproval:
+		loggerFrom(ctx).Info("Close held for approval", "workflow_id", wfID)
+		return &CloseBillResponse{
+			Bill:            *billDetails,
+			ConfirmationMsg: "Close requested; the bill's total requires approval before it finalizes.",
+		}, nil
 	}
 
-found: // Label to break out of the loop
+	loggerFrom(ctx).Info("Bill closed", "workflow_id", wfID, "status", billDetails.Status)
+	s.statusMetrics.recordClose(true)
+	// The close was saved before the workflow marked the bill CLOSED, with its number.
+	if saved, err := savedBills(ctx, s.db, []string{billID}); err != nil {
+		loggerFrom(ctx).Warn("Failed to read number of closed bill", "bill_id", billID, "error", err)
+	} else {
+		billDetails.Number = saved[billID].Number
+	}
 	return &CloseBillResponse{
-		Bill:            billDetails,
+		Bill:            *billDetails,
 		ConfirmationMsg: "Bill closed successfully and details retrieved.",
 	}, nil
 }
 
-// GetBill retrieves the details of a specific bill.
+// GetBill retrieves the details of a specific bill. With params.AsOf it returns the bill
+// as it was at that moment instead, rebuilt from its event stream. With
+// params.MinStateToken it waits until the bill reflects the change the token came from.
+// With params.IncludeWorkflow it also describes the bill's workflow, so support can see
+// why a bill is stuck.
 //
-// encore:api public method=GET path=/bills/:billID
-func (s *Service) GetBill(ctx context.Context, billID string) (*GetBillResponse, error) {
-	slog.Info("GetBill: Entered function", "billID", billID)
-	wfID := "bill-" + billID
+// encore:api auth method=GET path=/bills/:billID
+func (s *Service) GetBill(ctx context.Context, billID string, params *GetBillParams) (*GetBillResponse, error) {
+	if params == nil {
+		params = &GetBillParams{}
+	}
+	var resp *GetBillResponse
+	var err error
+	if params.AsOf != "" && params.MinStateToken != "" {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "asOf and minStateToken cannot be combined")
+	}
+	if err := checkIncludeDeleted(ctx, params.IncludeDeleted); err != nil {
+		return nil, err
+	}
+	if params.MinStateToken != "" {
+		version, parseErr := parseStateToken(params.MinStateToken)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		resp, err = s.awaitBillVersion(ctx, billID, version, params.IncludeDeleted)
+	} else if params.AsOf != "" {
+		asOf, parseErr := time.Parse(time.RFC3339Nano, params.AsOf)
+		if parseErr != nil {
+			return nil, apierr.InvalidArgument(apierr.InvalidParameter, "invalid asOf %q: must be an RFC 3339 time", params.AsOf)
+		}
+		resp, err = s.billAsOf(ctx, billID, asOf)
+		if err == nil && resp.RetrievedBill.DeletedAt != nil && !params.IncludeDeleted {
+			return nil, apierr.NotFound(apierr.BillNotFound, "bill %s was deleted at %s", billID, asOf.Format(time.RFC3339))
+		}
+	} else {
+		resp, err = s.readBill(ctx, billID, params.IncludeDeleted)
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp.RetrievedBill.Subtotals = resp.RetrievedBill.lineItemTotals()
+	if !params.IncludeWorkflow {
+		return resp, nil
+	}
+
+	// The bill is returned even when its workflow cannot be described, such as while
+	// Temporal is unavailable.
+	desc, descErr := s.temporalClient.DescribeWorkflowExecution(ctx, billWorkflowID(billID), "")
+	if descErr != nil {
+		loggerFrom(ctx).Warn("Failed to describe bill workflow", "error", descErr)
+		return resp, nil
+	}
+	resp.Workflow = describeBillWorkflow(billID, desc)
+	return resp, nil
+}
+
+// getBill retrieves the current details of a bill from its workflow, or from the
+// database while Temporal is unavailable. Deleted bills are not found.
+func (s *Service) getBill(ctx context.Context, billID string) (*GetBillResponse, error) {
+	return s.readBill(ctx, billID, false)
+}
+
+// readBill is getBill, also returning deleted bills if includeDeleted.
+func (s *Service) readBill(ctx context.Context, billID string, includeDeleted bool) (*GetBillResponse, error) {
+	// Test mode keys are told live bills do not exist, as they are other tenants' bills.
+	if !callerBillModes(ctx, true).covers(billID) {
+		return nil, apierr.NotFound(apierr.BillNotFound, "bill %s not found", billID)
+	}
+	wfID := billWorkflowID(billID)
 	var billDetails Bill
 	resp, err := s.temporalClient.QueryWorkflow(ctx, wfID, "", GetBillDetailsQueryName)
+	if err != nil && ctx.Err() != nil {
+		// The request ended; falling back to the database would fail the same way.
+		return nil, apierr.FromContext(ctx, "bill %s was not read", billID)
+	}
 	if err != nil {
-		slog.Error("GetBill: QueryWorkflow failed", "billID", billID, "workflowID", wfID, "error", err.Error())
-		return nil, fmt.Errorf("failed to query BillWorkflow %s: %w", wfID, err)
+		loggerFrom(ctx).Warn("Failed to query bill workflow", "workflow_id", wfID, "error", err)
+		if stale, staleErr := s.staleBill(ctx, billID, err); staleErr != nil {
+			loggerFrom(ctx).Error("Failed to read stale bill", "error", staleErr)
+		} else if stale != nil && visibleToCaller(ctx, stale.TenantID) && (stale.DeletedAt == nil || includeDeleted) {
+			return &GetBillResponse{RetrievedBill: *stale, ETag: billETag(stale.Version)}, nil
+		}
+		// The bill's workflow, and maybe its row, are gone, but it may have been archived.
+		if archive, archived, archiveErr := s.archivedBill(ctx, billID, err); archiveErr != nil {
+			loggerFrom(ctx).Error("Failed to read archived bill", "error", archiveErr)
+		} else if archived != nil && visibleToCaller(ctx, archived.TenantID) && (archived.DeletedAt == nil || includeDeleted) {
+			return &GetBillResponse{RetrievedBill: *archived, ETag: billETag(archived.Version), Archive: archive}, nil
+		}
+		return nil, apierr.FromTemporal(err, apierr.BillNotFound, "bill %s not found", billID)
 	}
 
-	slog.Info("GetBill: QueryWorkflow successful", "billID", billID, "workflowID", wfID)
-
 	if err := resp.Get(&billDetails); err != nil {
-		slog.Error("GetBill: resp.Get failed to decode billDetails", "billID", billID, "workflowID", wfID, "error", err.Error())
-		return nil, fmt.Errorf("failed to decode bill details from workflow %s: %w", wfID, err)
+		loggerFrom(ctx).Error("Failed to decode bill details", "workflow_id", wfID, "error", err)
+		return nil, apierr.Wrap(err, "failed to decode details of bill %s", billID)
+	}
+	if !visibleToCaller(ctx, billDetails.TenantID) {
+		return nil, apierr.NotFound(apierr.BillNotFound, "bill %s not found", billID)
+	}
+	// The workflow answers while the database is unavailable, and so does the bill,
+	// without its number.
+	saved, err := savedBills(ctx, s.db, []string{billID})
+	if err != nil {
+		loggerFrom(ctx).Warn("Failed to read saved fields of bill", "error", err)
+	}
+	if b, ok := saved[billID]; ok {
+		if b.DeletedAt != nil && !includeDeleted {
+			return nil, apierr.NotFound(apierr.BillNotFound, "bill %s not found", billID)
+		}
+		billDetails.Number, billDetails.DeletedAt = b.Number, b.DeletedAt
 	}
 
-	// Log the successfully decoded billDetails. Be mindful of logging potentially large/sensitive data in a real production system.
-	// For debugging, this is useful.
-	slog.Info("GetBill: billDetails decoded successfully", "billID", billID, "workflowID", wfID, "details", fmt.Sprintf("%+v", billDetails))
-
-	responsePayload := &GetBillResponse{
+	return &GetBillResponse{
 		RetrievedBill: billDetails,
+		ETag:          billETag(billDetails.Version),
+	}, nil
+}
+
+// authorizeBill fails with NotFound unless the caller may act on the bill. Internal
+// calls are not checked; tenant-scoped callers pay for a query of the bill.
+func (s *Service) authorizeBill(ctx context.Context, billID string) error {
+	if callerTenant(ctx) == "" {
+		return nil
 	}
-	slog.Info("GetBill: Prepared response payload", "billID", billID, "payload", fmt.Sprintf("%+v", responsePayload))
-	return responsePayload, nil
+	_, err := s.getBill(ctx, billID)
+	return err
 }
 
-// ListBills lists all bills, with optional filtering.
+// ListBills lists all bills, with optional filtering. Tenant-scoped callers only see
+// their own tenant's bills, and test bills are left out unless params.IncludeTest.
 //
-// encore:api public method=GET path=/bills
+// encore:api auth method=GET path=/bills
 func (s *Service) ListBills(ctx context.Context, params *ListBillsParams) (*ListBillsResponse, error) {
+	if tenant := callerTenant(ctx); tenant != "" {
+		if params.TenantID != "" && params.TenantID != tenant {
+			return nil, apierr.PermissionDenied(apierr.InsufficientScope, "API key may not list bills of tenant %s", params.TenantID)
+		}
+		params.TenantID = tenant
+	}
+	if err := checkIncludeDeleted(ctx, params.IncludeDeleted); err != nil {
+		return nil, err
+	}
+	modes := callerBillModes(ctx, params.IncludeTest)
 	var queryParts []string
 	queryParts = append(queryParts, fmt.Sprintf("WorkflowType = '%s'", "BillWorkflow"))
 
-	switch params.Status {
-	case string(BillStatusOpen):
+	status := BillStatus(params.Status)
+	switch {
+	case status == BillStatusOpen || status == BillStatusClosing || status == BillStatusPendingApproval || status == BillStatusCloseFailed:
+		// Bills that have not closed yet always have a running workflow.
 		queryParts = append(queryParts, fmt.Sprintf("ExecutionStatus = '%s'", enums.WORKFLOW_EXECUTION_STATUS_RUNNING.String()))
-	case string(BillStatusClosed):
-		queryParts = append(queryParts, fmt.Sprintf("ExecutionStatus = '%s'", enums.WORKFLOW_EXECUTION_STATUS_COMPLETED.String()))
-	case "":
-		// No additional status filter, list all (running and completed)
+	case status == "" || status.IsValid():
+		// Closed bills may still be running while payment is being collected,
+		// so the status filter is applied to the queried bill state below.
 	default:
-		return nil, fmt.Errorf("invalid status parameter: '%s'. Must be 'OPEN', 'CLOSED', or empty", params.Status)
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "invalid status parameter: '%s'. Must be 'OPEN', 'CLOSING', 'PENDING_APPROVAL', 'CLOSE_FAILED', 'CLOSED', 'PARTIALLY_PAID', 'PAID', 'PAYMENT_FAILED', 'OVERDUE', 'DELINQUENT', or empty", params.Status)
 	}
 
 	queryString := ""
@@ -249,32 +936,142 @@ func (s *Service) ListBills(ctx context.Context, params *ListBillsParams) (*List
 	}
 
 	request := &workflowservice.ListWorkflowExecutionsRequest{
-		Namespace: "default",
+		Namespace: s.cfg.Temporal.Namespace,
 		Query:     queryString,
 	}
 
-	resp, err := s.temporalClient.WorkflowService().ListWorkflowExecutions(ctx, request)
+	var resp *workflowservice.ListWorkflowExecutionsResponse
+	err := s.breaker.do(ctx, func() (err error) {
+		resp, err = s.temporalClient.WorkflowService().ListWorkflowExecutions(ctx, request)
+		return err
+	})
+	if err != nil && ctx.Err() != nil {
+		return nil, apierr.FromContext(ctx, "failed to list bills")
+	}
+	if err != nil && apierr.IsTemporalUnavailable(err) {
+		bills, staleErr := listStaleBills(ctx, s.db, params, modes)
+		if staleErr != nil {
+			loggerFrom(ctx).Error("Failed to read stale bills", "error", staleErr)
+			return nil, apierr.FromTemporal(err, apierr.Internal, "failed to list bills")
+		}
+		return &ListBillsResponse{Bills: bills, TotalCount: len(bills), Stale: true}, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to list workflow executions: %w", err)
+		return nil, apierr.FromTemporal(err, apierr.Internal, "failed to list bills")
 	}
 
 	var bills []Bill
+	seen := make(map[string]bool)
 	for _, executionInfo := range resp.GetExecutions() {
 		wfID := executionInfo.GetExecution().GetWorkflowId()
 		runID := executionInfo.GetExecution().GetRunId()
 
+		// A bill resumed for payment has several runs; executions are listed
+		// newest first, so only the latest run reflects the bill's state.
+		if seen[wfID] {
+			continue
+		}
+		seen[wfID] = true
+
 		var billDetails Bill
+		// Failed queries are logged by the client interceptor.
 		queryResp, err := s.temporalClient.QueryWorkflow(ctx, wfID, runID, GetBillDetailsQueryName)
 		if err != nil {
-			fmt.Printf("failed to query workflow %s run %s: %v\n", wfID, runID, err)
 			continue
 		}
 		if err := queryResp.Get(&billDetails); err != nil {
-			fmt.Printf("failed to decode bill details from workflow %s run %s: %v\n", wfID, runID, err)
+			loggerFrom(ctx).Warn("Failed to decode bill details", "workflow_id", wfID, "run_id", runID, "error", err)
+			continue
+		}
+		if status != "" && billDetails.Status != status {
+			continue
+		}
+		if params.TenantID != "" && tenantOrDefault(billDetails.TenantID) != params.TenantID {
+			continue
+		}
+		if params.CustomerID != "" && billDetails.CustomerID != params.CustomerID {
+			continue
+		}
+		if !modes.covers(billDetails.ID) {
 			continue
 		}
 		bills = append(bills, billDetails)
 	}
 
-	return &ListBillsResponse{Bills: bills}, nil
+	ids := make([]string, len(bills))
+	for i, b := range bills {
+		ids[i] = b.ID
+	}
+	saved, err := savedBills(ctx, s.db, ids)
+	if err != nil {
+		loggerFrom(ctx).Warn("Failed to read saved fields of bills", "error", err)
+	}
+	listed := bills[:0]
+	for _, b := range bills {
+		if sb, ok := saved[b.ID]; ok {
+			if sb.DeletedAt != nil && !params.IncludeDeleted {
+				continue
+			}
+			b.Number, b.DeletedAt = sb.Number, sb.DeletedAt
+		}
+		listed = append(listed, b)
+	}
+	return &ListBillsResponse{Bills: listed}, nil
+}
+
+// signalSettlement delivers a post-close signal to a bill's workflow. If the run that
+// closed the bill has already completed, a new run is started from the bill's last
+// known state so the signal can still be handled.
+func (s *Service) signalSettlement(ctx context.Context, bill *Bill, signalName string, arg interface{}) error {
+	wfID := billWorkflowID(bill.ID)
+	options := client.StartWorkflowOptions{
+		ID:                    wfID,
+		TaskQueue:             s.cfg.TaskQueues.forTenant(bill.TenantID),
+		WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE,
+	}
+	workflowParams := s.cfg.billParams(bill.TenantID, bill.CustomerID, bill.Currency)
+	workflowParams.BillID = bill.ID
+	workflowParams.AutoCollect = bill.AutoCollect
+	workflowParams.Resume = bill
+	_, err := s.temporalClient.SignalWithStartWorkflow(ctx, wfID, signalName, arg, options, BillWorkflow, &workflowParams)
+	return err
+}
+
+// billParams returns the params of a bill for the customer in currency under the
+// configured billing policies. Callers set the bill's ID and whatever is particular to
+// how the bill came about.
+func (c *Config) billParams(tenantID, customerID, currency string) BillWorkflowParams {
+	return BillWorkflowParams{
+		TenantID:          tenantID,
+		CustomerID:        customerID,
+		Currency:          currency,
+		CloseGracePeriod:  c.CloseGracePeriod,
+		DunningSchedule:   c.DunningSchedule,
+		LateItemPolicy:    c.LateItemPolicy,
+		SaveFailurePolicy: c.SaveFailurePolicy,
+		Approval:          c.Approval,
+		RoundingMode:      c.RoundingMode,
+		ApplyCredits:      true,
+		EmailOnClose:      true,
+	}
+}
+
+// newBillParams returns billParams with the customer's bill limits and spending
+// alerts in currency, which every new bill starts with, whether it was created through
+// the API, by a subscription or to hold late items. A contract is left to the caller,
+// since follow-up bills and sub-bills are not billed a commitment of their own.
+// Without a database the customer has neither.
+func newBillParams(ctx context.Context, db *tracedDB, cfg *Config, tenantID, customerID, currency string) (BillWorkflowParams, error) {
+	params := cfg.billParams(tenantID, customerID, currency)
+	if db == nil {
+		return params, nil
+	}
+	var err error
+	if params.BillLimits, err = loadBillLimits(ctx, db, tenantID, customerID, currency); err != nil {
+		return params, err
+	}
+	if params.SpendingAlerts, err = loadSpendingAlerts(ctx, db, tenantID, customerID, currency); err != nil {
+		return params, err
+	}
+	return params, nil
 }
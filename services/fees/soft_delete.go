@@ -0,0 +1,152 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/storage/sqldb"
+)
+
+// BillDeletionResponse is the response payload for deleting and restoring a bill.
+type BillDeletionResponse struct {
+	BillID string `json:"billId"`
+	// DeletedAt and DeletedByKeyID are set while the bill is deleted.
+	DeletedAt      *time.Time `json:"deletedAt,omitempty"`
+	DeletedByKeyID string     `json:"deletedByKeyId,omitempty"`
+}
+
+// canSeeDeleted reports whether the caller may read deleted bills: internal callers,
+// and keys with the bills:delete scope.
+func canSeeDeleted(ctx context.Context) bool {
+	data := caller(ctx)
+	return data == nil || data.HasScope(ScopeBillsDelete)
+}
+
+// checkIncludeDeleted fails with PermissionDenied when a read asks for deleted bills
+// the caller may not see.
+func checkIncludeDeleted(ctx context.Context, includeDeleted bool) error {
+	if includeDeleted && !canSeeDeleted(ctx) {
+		return apierr.PermissionDenied(apierr.InsufficientScope, "includeDeleted requires the %s scope", ScopeBillsDelete)
+	}
+	return nil
+}
+
+// lockBillForDeletion locks the bill for a deletion or restore and reads what they
+// check. It fails with NotFound unless the bill is saved and visible to the caller.
+func lockBillForDeletion(ctx context.Context, tx *tracedTx, billID string) (*BillDeletionResponse, bool, int64, error) {
+	resp := &BillDeletionResponse{BillID: billID}
+	var tenantID string
+	var settled bool
+	var version int64
+	err := tx.QueryRow(ctx, `
+        SELECT tenant_id, `+settledBillCondition+`, version, deleted_at, COALESCE(deleted_by_key_id, '')
+        FROM bills WHERE id = $1
+        FOR UPDATE
+    `, billID).Scan(&tenantID, &settled, &version, &resp.DeletedAt, &resp.DeletedByKeyID)
+	if errors.Is(err, sqldb.ErrNoRows) || (err == nil && !visibleToCaller(ctx, tenantID)) {
+		return nil, false, 0, apierr.NotFound(apierr.BillNotFound, "bill %s not found", billID)
+	}
+	if err != nil {
+		return nil, false, 0, apierr.Wrap(err, "failed to load bill %s", billID)
+	}
+	return resp, settled, version, nil
+}
+
+// DeleteBill soft-deletes a settled bill with its line items: reads leave them out
+// unless asked for deleted bills, and RestoreBill brings them back. Bills that are not
+// settled yet fail with bill_not_settled. Deleting a deleted bill changes nothing.
+//
+// encore:api auth method=DELETE path=/bills/:billID
+func (s *Service) DeleteBill(ctx context.Context, billID string) (*BillDeletionResponse, error) {
+	now := s.clock.Now()
+	var resp *BillDeletionResponse
+	err := s.db.inTx(ctx, func(tx *tracedTx) error {
+		current, settled, version, err := lockBillForDeletion(ctx, tx, billID)
+		if err != nil {
+			return err
+		}
+		resp = current
+		if resp.DeletedAt != nil {
+			return nil
+		}
+		if !settled {
+			return apierr.FailedPrecondition(apierr.BillNotSettled, "bill %s is not settled; only paid bills and closed bills with nothing to pay can be deleted", billID)
+		}
+		ev := auditEvent{
+			BillID:     billID,
+			Action:     AuditBillDeleted,
+			ActorKeyID: callerKeyID(ctx),
+			Event:      &BillEventData{DeletedAt: &now},
+			Version:    version,
+		}
+		return auditInTx(ctx, tx, keyedID(ctx, "bill-deleted", billID), ev, func(tx *tracedTx) error {
+			if _, err := tx.Exec(ctx, `UPDATE bills SET deleted_at = $2, deleted_by_key_id = $3 WHERE id = $1`, billID, now, nullIfEmpty(ev.ActorKeyID)); err != nil {
+				return apierr.Wrap(err, "failed to delete bill %s", billID)
+			}
+			if _, err := tx.Exec(ctx, `UPDATE line_items SET deleted_at = $2 WHERE bill_id = $1 AND deleted_at IS NULL`, billID, now); err != nil {
+				return apierr.Wrap(err, "failed to delete line items of bill %s", billID)
+			}
+			return staleRevenueReport(ctx, tx)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.DeletedAt == nil {
+		resp.DeletedAt, resp.DeletedByKeyID = &now, callerKeyID(ctx)
+		loggerFrom(ctx).Info("Bill deleted", "bill_id", billID)
+	}
+	return resp, nil
+}
+
+// RestoreBill undoes DeleteBill, restoring the bill with the line items deleted with
+// it. Restoring a bill that is not deleted changes nothing.
+//
+// encore:api auth method=POST path=/bills/:billID/restore
+func (s *Service) RestoreBill(ctx context.Context, billID string) (*BillDeletionResponse, error) {
+	restored := false
+	err := s.db.inTx(ctx, func(tx *tracedTx) error {
+		current, _, version, err := lockBillForDeletion(ctx, tx, billID)
+		if err != nil {
+			return err
+		}
+		if current.DeletedAt == nil {
+			return nil
+		}
+		ev := auditEvent{
+			BillID:     billID,
+			Action:     AuditBillRestored,
+			ActorKeyID: callerKeyID(ctx),
+			Event:      &BillEventData{},
+			Version:    version,
+		}
+		restored = true
+		return auditInTx(ctx, tx, keyedID(ctx, "bill-restored", billID), ev, func(tx *tracedTx) error {
+			if _, err := tx.Exec(ctx, `UPDATE bills SET deleted_at = NULL, deleted_by_key_id = NULL WHERE id = $1`, billID); err != nil {
+				return apierr.Wrap(err, "failed to restore bill %s", billID)
+			}
+			if _, err := tx.Exec(ctx, `UPDATE line_items SET deleted_at = NULL WHERE bill_id = $1 AND deleted_at = $2`, billID, *current.DeletedAt); err != nil {
+				return apierr.Wrap(err, "failed to restore line items of bill %s", billID)
+			}
+			return staleRevenueReport(ctx, tx)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if restored {
+		loggerFrom(ctx).Info("Bill restored", "bill_id", billID)
+	}
+	return &BillDeletionResponse{BillID: billID}, nil
+}
+
+// staleRevenueReport has the next revenue report refresh the revenue_daily view, so
+// it sees a deletion or restore at once.
+func staleRevenueReport(ctx context.Context, tx *tracedTx) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM report_refreshes WHERE report = $1`, revenueReport); err != nil {
+		return apierr.Wrap(err, "failed to mark the revenue report stale")
+	}
+	return nil
+}
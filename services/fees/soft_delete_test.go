@@ -0,0 +1,41 @@
+package fees
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"encore.app/apierr"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCheckIncludeDeleted tests that only internal callers and keys with bills:delete
+// may read deleted bills.
+func TestCheckIncludeDeleted(t *testing.T) {
+	require.NoError(t, checkIncludeDeleted(context.Background(), true))
+
+	reader := withCaller(context.Background(), &AuthData{KeyID: "key-reader", TenantID: "acme", Roles: []Role{RoleReader}})
+	require.NoError(t, checkIncludeDeleted(reader, false))
+	require.Equal(t, apierr.InsufficientScope, apierr.ReasonOf(checkIncludeDeleted(reader, true)))
+
+	admin := withCaller(context.Background(), &AuthData{KeyID: "key-admin", TenantID: "acme", Roles: []Role{RoleAdmin}})
+	require.NoError(t, checkIncludeDeleted(admin, true))
+}
+
+// TestReplayBill_Deleted tests that a bill's events record when it was deleted, and
+// that a restore clears it.
+func TestReplayBill_Deleted(t *testing.T) {
+	deletedAt := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	events := append(billEvents(),
+		BillEvent{Sequence: 10, Type: AuditBillDeleted, BillVersion: 6, Data: BillEventData{DeletedAt: &deletedAt}})
+
+	bill, err := replayBill("b1", events)
+	require.NoError(t, err)
+	require.Equal(t, &deletedAt, bill.DeletedAt)
+	require.Equal(t, BillStatusPaid, bill.Status)
+
+	events = append(events, BillEvent{Sequence: 11, Type: AuditBillRestored, BillVersion: 6})
+	bill, err = replayBill("b1", events)
+	require.NoError(t, err)
+	require.Nil(t, bill.DeletedAt)
+}
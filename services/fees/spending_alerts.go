@@ -0,0 +1,292 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/storage/sqldb"
+	"go.temporal.io/sdk/workflow"
+)
+
+// maxSpendingAlertThresholds caps the thresholds of one set of spending alerts.
+const maxSpendingAlertThresholds = 10
+
+// SpendingAlerts notifies a customer as a bill's total approaches a budget.
+type SpendingAlerts struct {
+	Budget float64 `json:"budget"`
+	// Thresholds are percentages of Budget, such as 80 and 100, at which the customer
+	// is notified. A threshold above 100 alerts on overspending.
+	Thresholds []float64 `json:"thresholds"`
+}
+
+// CrossedThreshold records a spending alert threshold a bill's total reached.
+type CrossedThreshold struct {
+	// Threshold is the percentage of the budget that was reached.
+	Threshold float64 `json:"threshold"`
+	// Amount is the total at which the threshold is reached.
+	Amount float64 `json:"amount"`
+	// Total is the bill's total when the threshold was reached.
+	Total     float64   `json:"total"`
+	CrossedAt time.Time `json:"crossedAt"`
+}
+
+// SetSpendingAlertsSignal replaces the spending alerts of an open bill.
+type SetSpendingAlertsSignal struct {
+	// Alerts are the new alerts; nil removes them.
+	Alerts           *SpendingAlerts
+	RequestedByKeyID string
+	// IfVersion, when set, drops the signal unless the bill is at this version.
+	IfVersion int64
+}
+
+// normalize validates the alerts and sorts their thresholds, dropping duplicates.
+func (a *SpendingAlerts) normalize() error {
+	if a.Budget <= 0 {
+		return apierr.InvalidArgument(apierr.InvalidAmount, "budget must be positive")
+	}
+	if len(a.Thresholds) == 0 {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "at least one threshold is required")
+	}
+	if len(a.Thresholds) > maxSpendingAlertThresholds {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "at most %d thresholds are allowed", maxSpendingAlertThresholds)
+	}
+	thresholds := append([]float64(nil), a.Thresholds...)
+	sort.Float64s(thresholds)
+	a.Thresholds = thresholds[:0]
+	for _, t := range thresholds {
+		if t <= 0 || t > 1000 {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "invalid threshold %v: must be a percentage of the budget between 0 and 1000", t)
+		}
+		if n := len(a.Thresholds); n == 0 || a.Thresholds[n-1] != t {
+			a.Thresholds = append(a.Thresholds, t)
+		}
+	}
+	a.Budget = roundAmount(a.Budget)
+	return nil
+}
+
+// amount returns the total at which threshold is reached.
+func (a *SpendingAlerts) amount(threshold float64) float64 {
+	return roundAmount(a.Budget * threshold / 100)
+}
+
+// crossed reports whether the bill has already reached threshold at amount.
+func (b *Bill) crossed(threshold, amount float64) bool {
+	for _, c := range b.CrossedThresholds {
+		if c.Threshold == threshold && c.Amount == amount {
+			return true
+		}
+	}
+	return false
+}
+
+// ------ Workflow ------
+
+// checkSpendingAlerts records each threshold of the bill's spending alerts that its
+// total has reached, notifying the customer once per threshold.
+func (w *billWorkflow) checkSpendingAlerts() {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	alerts := bill.SpendingAlerts
+	if alerts == nil {
+		return
+	}
+	for _, threshold := range alerts.Thresholds {
+		amount := alerts.amount(threshold)
+		if bill.TotalAmount < amount || bill.crossed(threshold, amount) {
+			continue
+		}
+		bill.CrossedThresholds = append(bill.CrossedThresholds, CrossedThreshold{
+			Threshold: threshold,
+			Amount:    amount,
+			Total:     bill.TotalAmount,
+			CrossedAt: workflow.Now(ctx),
+		})
+		logger.Info("Bill crossed spending threshold", "bill_id", bill.ID, "threshold", threshold, "amount", amount, "total_amount", bill.TotalAmount)
+		notify(ctx, Notification{
+			Event:      NotificationSpendingThresholdCrossed,
+			BillID:     bill.ID,
+			CustomerID: bill.CustomerID,
+			Message:    fmt.Sprintf("Bill total %.2f %s reached %g%% of the %.2f %s budget.", bill.TotalAmount, bill.Currency, threshold, alerts.Budget, bill.Currency),
+		})
+	}
+}
+
+// setSpendingAlerts replaces the bill's spending alerts. Thresholds kept at the same
+// amount stay crossed; the others are checked against the current total.
+func (w *billWorkflow) setSpendingAlerts(signal SetSpendingAlertsSignal) {
+	logger, bill := w.logger, w.bill
+
+	if w.outdated(SetSpendingAlertsSignalName, signal.IfVersion) {
+		return
+	}
+	if !bill.isFinalizing() {
+		logger.Warn("SetSpendingAlertsSignal received for a closed bill, ignoring.", "bill_id", bill.ID, "bill_status", bill.Status)
+		return
+	}
+
+	var kept []CrossedThreshold
+	if alerts := signal.Alerts; alerts != nil {
+		for _, c := range bill.CrossedThresholds {
+			for _, t := range alerts.Thresholds {
+				if c.Threshold == t && c.Amount == alerts.amount(t) {
+					kept = append(kept, c)
+				}
+			}
+		}
+	}
+	bill.SpendingAlerts, bill.CrossedThresholds = signal.Alerts, kept
+	w.touch()
+	logger.Info("Bill spending alerts set", "bill_id", bill.ID, "alerts", signal.Alerts, "requested_by", signal.RequestedByKeyID)
+	w.checkSpendingAlerts()
+}
+
+// ------ Customer alerts ------
+
+// loadSpendingAlerts returns the customer's spending alerts for bills in currency, or
+// nil if none are set.
+func loadSpendingAlerts(ctx context.Context, db *tracedDB, tenantID, customerID, currency string) (*SpendingAlerts, error) {
+	var a SpendingAlerts
+	err := db.QueryRow(ctx, `
+        SELECT budget, thresholds
+        FROM customer_spending_alerts
+        WHERE tenant_id = $3 AND customer_id = $1 AND currency = $2
+    `, customerID, currency, tenantOrDefault(tenantID)).Scan(&a.Budget, &a.Thresholds)
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load spending alerts for customer %s: %w", customerID, err)
+	}
+	return &a, nil
+}
+
+// ------ API ------
+
+// SetBillSpendingAlertsRequest is the request payload for setting a bill's spending
+// alerts. An empty request removes them.
+type SetBillSpendingAlertsRequest struct {
+	SpendingAlerts
+	// IfMatch, when set, applies the change only if the bill is still at this version.
+	IfMatch string `header:"If-Match"`
+}
+
+// SpendingAlertsResponse is the response payload for a bill's spending alerts.
+type SpendingAlertsResponse struct {
+	RetryMetadata
+	BillID          string          `json:"billId"`
+	SpendingAlerts  *SpendingAlerts `json:"spendingAlerts,omitempty"`
+	ConfirmationMsg string          `json:"confirmationMsg"`
+	// StateToken identifies the bill's state once this change is applied. Pass it to
+	// GetBill as minStateToken to read the bill with the change.
+	StateToken string `json:"stateToken,omitempty"`
+}
+
+// SetBillSpendingAlerts replaces the spending alerts of a bill that has not closed yet.
+// Thresholds the bill's total has already reached notify the customer at once.
+//
+// encore:api auth method=PUT path=/bills/:billID/spending-alerts
+func (s *Service) SetBillSpendingAlerts(ctx context.Context, billID string, params *SetBillSpendingAlertsRequest) (*SpendingAlertsResponse, error) {
+	var alerts *SpendingAlerts
+	if params.Budget != 0 || len(params.Thresholds) > 0 {
+		alerts = &params.SpendingAlerts
+		if err := alerts.normalize(); err != nil {
+			return nil, err
+		}
+	}
+	ifVersion, err := parseIfMatch(params.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	getResp, err := s.getBill(ctx, billID)
+	if err != nil {
+		return nil, err
+	}
+	bill := getResp.RetrievedBill
+	if !bill.isFinalizing() {
+		return nil, apierr.FailedPrecondition(apierr.BillClosed, "bill %s is %s and its spending alerts can no longer change", billID, bill.Status)
+	}
+	if err := checkVersion(&bill, ifVersion); err != nil {
+		return nil, err
+	}
+
+	signal := SetSpendingAlertsSignal{Alerts: alerts, RequestedByKeyID: callerKeyID(ctx), IfVersion: ifVersion}
+	if err := s.temporalClient.SignalWorkflow(ctx, billWorkflowID(billID), "", SetSpendingAlertsSignalName, signal); err != nil {
+		return nil, apierr.FromTemporal(err, apierr.BillNotFound, "bill %s not found", billID)
+	}
+	msg := "Spending alerts set."
+	if alerts == nil {
+		msg = "Spending alerts removed."
+	}
+	return &SpendingAlertsResponse{BillID: billID, SpendingAlerts: alerts, ConfirmationMsg: msg, StateToken: nextStateToken(&bill)}, nil
+}
+
+// SetCustomerSpendingAlertsRequest is the request payload for setting a customer's
+// spending alerts.
+type SetCustomerSpendingAlertsRequest struct {
+	CustomerSettingsRequest
+	SpendingAlerts
+}
+
+// CustomerSpendingAlertsResponse reports a customer's spending alerts in one currency.
+type CustomerSpendingAlertsResponse struct {
+	RetryMetadata
+	CustomerID string `json:"customerId"`
+	Currency   string `json:"currency"`
+	// SpendingAlerts is absent when the customer has no alerts in the currency.
+	SpendingAlerts *SpendingAlerts `json:"spendingAlerts,omitempty"`
+}
+
+// SetCustomerSpendingAlerts sets the spending alerts of a customer's bills in one
+// currency. They apply to bills created afterwards, unless the bill sets its own.
+//
+// encore:api private method=PUT path=/admin/customers/:customerID/spending-alerts/:currency
+func (s *Service) SetCustomerSpendingAlerts(ctx context.Context, customerID string, currency string, params *SetCustomerSpendingAlertsRequest) (*CustomerSpendingAlertsResponse, error) {
+	if !validCurrency(currency) {
+		return nil, apierr.InvalidArgument(apierr.InvalidCurrency, "invalid currency %q: must be a three-letter ISO 4217 code such as \"USD\"", currency)
+	}
+	if err := params.normalize(); err != nil {
+		return nil, err
+	}
+	_, err := s.db.Exec(ctx, `
+        INSERT INTO customer_spending_alerts (tenant_id, customer_id, currency, budget, thresholds, updated_at)
+        VALUES ($5, $1, $2, $3, $4, NOW())
+        ON CONFLICT (tenant_id, customer_id, currency) DO UPDATE
+        SET budget = EXCLUDED.budget, thresholds = EXCLUDED.thresholds, updated_at = EXCLUDED.updated_at
+    `, customerID, currency, params.Budget, params.Thresholds, tenantOrDefault(params.TenantID))
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to save spending alerts for customer %s", customerID)
+	}
+	return s.GetCustomerSpendingAlerts(ctx, customerID, currency, &params.CustomerSettingsRequest)
+}
+
+// GetCustomerSpendingAlerts returns the spending alerts of a customer's bills in one
+// currency.
+//
+// encore:api private method=GET path=/admin/customers/:customerID/spending-alerts/:currency
+func (s *Service) GetCustomerSpendingAlerts(ctx context.Context, customerID string, currency string, params *CustomerSettingsRequest) (*CustomerSpendingAlertsResponse, error) {
+	alerts, err := loadSpendingAlerts(ctx, s.db, params.TenantID, customerID, currency)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load spending alerts for customer %s", customerID)
+	}
+	return &CustomerSpendingAlertsResponse{CustomerID: customerID, Currency: currency, SpendingAlerts: alerts}, nil
+}
+
+// ClearCustomerSpendingAlerts removes the spending alerts of a customer's bills in one
+// currency. Bills already created keep theirs.
+//
+// encore:api private method=DELETE path=/admin/customers/:customerID/spending-alerts/:currency
+func (s *Service) ClearCustomerSpendingAlerts(ctx context.Context, customerID string, currency string, params *CustomerSettingsRequest) (*CustomerSpendingAlertsResponse, error) {
+	_, err := s.db.Exec(ctx, `
+        DELETE FROM customer_spending_alerts WHERE tenant_id = $3 AND customer_id = $1 AND currency = $2
+    `, customerID, currency, tenantOrDefault(params.TenantID))
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to clear spending alerts for customer %s", customerID)
+	}
+	return &CustomerSpendingAlertsResponse{CustomerID: customerID, Currency: currency}, nil
+}
@@ -0,0 +1,21 @@
+package fees
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestSpendingAlerts_Normalize tests that thresholds are validated, sorted and deduplicated.
+func TestSpendingAlerts_Normalize(t *testing.T) {
+	alerts := &SpendingAlerts{Budget: 250, Thresholds: []float64{100, 50, 80, 50}}
+	require.NoError(t, alerts.normalize())
+	require.Equal(t, []float64{50, 80, 100}, alerts.Thresholds)
+	require.Equal(t, 200.0, alerts.amount(80))
+
+	require.Error(t, (&SpendingAlerts{Budget: 0, Thresholds: []float64{80}}).normalize())
+	require.Error(t, (&SpendingAlerts{Budget: 100}).normalize())
+	require.Error(t, (&SpendingAlerts{Budget: 100, Thresholds: []float64{0}}).normalize())
+	require.Error(t, (&SpendingAlerts{Budget: 100, Thresholds: []float64{1500}}).normalize())
+	require.Error(t, (&SpendingAlerts{Budget: 100, Thresholds: []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}}).normalize())
+}
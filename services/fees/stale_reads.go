@@ -0,0 +1,238 @@
+package fees
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"encore.app/apierr"
+	"encore.dev/storage/sqldb"
+	"go.temporal.io/api/serviceerror"
+)
+
+// maxStaleListLimit caps how many bills a database-backed ListBills returns.
+const maxStaleListLimit = 100
+
+// billColumns are the bills columns scanned by scanBill.
+const billColumns = `id, tenant_id, customer_id, currency, status, total_amount::float8, created_at, closed_at, COALESCE(created_by_key_id, ''), COALESCE(template_id, ''), adjustment, approval, version, due_date, rounding, applied_credit, period_end, COALESCE(parent_bill_id, ''), deleted_at, COALESCE(number, ''), test`
+
+// scanBill reads a row of billColumns into a stale Bill.
+func scanBill(row interface{ Scan(...any) error }) (*Bill, error) {
+	var b Bill
+	var createdAt time.Time
+	var adjustment, approval, rounding, appliedCredit []byte
+	if err := row.Scan(&b.ID, &b.TenantID, &b.CustomerID, &b.Currency, &b.Status, &b.TotalAmount, &createdAt, &b.ClosedAt, &b.CreatedByKeyID, &b.TemplateID, &adjustment, &approval, &b.Version, &b.DueDate, &rounding, &appliedCredit, &b.PeriodEnd, &b.ParentBillID, &b.DeletedAt, &b.Number, &b.Test); err != nil {
+		return nil, err
+	}
+	b.CreatedAt = &createdAt
+	if adjustment != nil {
+		if err := json.Unmarshal(adjustment, &b.Adjustment); err != nil {
+			return nil, fmt.Errorf("failed to decode adjustment of bill %s: %w", b.ID, err)
+		}
+	}
+	if approval != nil {
+		if err := json.Unmarshal(approval, &b.Approval); err != nil {
+			return nil, fmt.Errorf("failed to decode approval of bill %s: %w", b.ID, err)
+		}
+	}
+	if rounding != nil {
+		if err := json.Unmarshal(rounding, &b.Rounding); err != nil {
+			return nil, fmt.Errorf("failed to decode rounding policy of bill %s: %w", b.ID, err)
+		}
+	}
+	if appliedCredit != nil {
+		if err := json.Unmarshal(appliedCredit, &b.AppliedCredit); err != nil {
+			return nil, fmt.Errorf("failed to decode applied credit of bill %s: %w", b.ID, err)
+		}
+	}
+	b.LineItems = []LineItem{}
+	b.Stale = true
+	return &b, nil
+}
+
+// loadStaleBill reads a bill and its line items from the database, for when its
+// workflow cannot be queried. It returns nil if the bill has not been saved.
+func loadStaleBill(ctx context.Context, db *tracedDB, fields *fieldCipher, billID string) (*Bill, error) {
+	bill, err := scanBill(db.QueryRow(ctx, `SELECT `+billColumns+` FROM bills WHERE id = $1`, billID))
+	if errors.Is(err, sqldb.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bill %s: %w", billID, err)
+	}
+
+	rows, err := db.Query(ctx, `SELECT `+lineItemColumns+` FROM line_items WHERE bill_id = $1 ORDER BY created_at, id`, billID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load line items of bill %s: %w", billID, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		_, item, err := scanLineItem(rows, fields)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read line items of bill %s: %w", billID, err)
+		}
+		bill.LineItems = append(bill.LineItems, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read line items of bill %s: %w", billID, err)
+	}
+	return bill, nil
+}
+
+const lineItemColumns = `bill_id, id, description, amount::float8, late, over_hard_cap, COALESCE(created_by_key_id, ''),
+    COALESCE(client_reference, ''), routed_from_bill_id, original_period_start, original_period_end,
+    COALESCE(quantity, 0)::float8, COALESCE(unit_price, 0)::float8, COALESCE(unit, ''), COALESCE(sub_bill_id, ''), aggregate, type, COALESCE(reason_code, ''),
+    COALESCE(reference_line_item_id, ''), COALESCE(reference_external, ''), COALESCE(metric, ''), COALESCE(drawdown, 0)::float8,
+    COALESCE(waived, 0)::float8`
+
+// scanLineItem reads a row of lineItemColumns, decrypting the item's fields, and
+// returns the item with the ID of its bill.
+func scanLineItem(row interface{ Scan(...any) error }, fields *fieldCipher) (string, LineItem, error) {
+	var billID string
+	var item LineItem
+	var routedFromBillID *string
+	var periodStart, periodEnd *time.Time
+	var aggregate []byte
+	var reference LineItemReference
+	if err := row.Scan(&billID, &item.ID, &item.Description, &item.Amount, &item.Late, &item.OverHardCap, &item.CreatedByKeyID, &item.ClientReference, &routedFromBillID, &periodStart, &periodEnd,
+		&item.Quantity, &item.UnitPrice, &item.Unit, &item.SubBillID, &aggregate, &item.Type, &item.ReasonCode, &reference.LineItemID, &reference.External, &item.Metric, &item.Drawdown, &item.Waived); err != nil {
+		return "", LineItem{}, err
+	}
+	var err error
+	if item.Aggregate, err = scanLineItemAggregate(aggregate); err != nil {
+		return "", LineItem{}, fmt.Errorf("failed to decode aggregate of line item %s: %w", item.ID, err)
+	}
+	if err := fields.openLineItem(&item); err != nil {
+		return "", LineItem{}, fmt.Errorf("failed to decrypt line item %s: %w", item.ID, err)
+	}
+	if reference != (LineItemReference{}) {
+		item.Reference = &reference
+	}
+	if routedFromBillID != nil {
+		item.RoutedFrom = &RoutedFrom{BillID: *routedFromBillID, PeriodStart: periodStart, PeriodEnd: periodEnd}
+	}
+	return billID, item, nil
+}
+
+// listStaleBills reads bills from the database, newest first, for when Temporal
+// cannot be queried. Line items are not included.
+func listStaleBills(ctx context.Context, db *tracedDB, params *ListBillsParams, modes billModes) ([]Bill, error) {
+	limit := params.Limit
+	if limit <= 0 || limit > maxStaleListLimit {
+		limit = maxStaleListLimit
+	}
+	where, args := staleBillFilter(params, modes)
+	args = append(args, limit, max(params.Offset, 0))
+	rows, err := db.Query(ctx, fmt.Sprintf(`
+        SELECT %s
+        FROM bills
+        WHERE %s
+        ORDER BY created_at DESC, id
+        LIMIT $%d OFFSET $%d
+    `, billColumns, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bills: %w", err)
+	}
+	defer rows.Close()
+
+	bills := []Bill{}
+	for rows.Next() {
+		bill, err := scanBill(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bills: %w", err)
+		}
+		bills = append(bills, *bill)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read bills: %w", err)
+	}
+	return bills, nil
+}
+
+// staleBillFilter builds the WHERE clause of listStaleBills and its arguments. Only the
+// filters that are set are added, rather than matching unset ones against an empty
+// argument, so Postgres can plan the query on idx_bills_customer_status_created.
+func staleBillFilter(params *ListBillsParams, modes billModes) (string, []any) {
+	var conditions []string
+	var args []any
+	for _, f := range []struct{ column, value string }{
+		{"customer_id", params.CustomerID},
+		{"status", params.Status},
+		{"currency", params.Currency},
+		{"tenant_id", params.TenantID},
+	} {
+		if f.value == "" {
+			continue
+		}
+		args = append(args, f.value)
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", f.column, len(args)))
+	}
+	if !params.IncludeDeleted {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+	switch {
+	case !modes.Test:
+		conditions = append(conditions, "NOT test")
+	case !modes.Live:
+		conditions = append(conditions, "test")
+	}
+	if len(conditions) == 0 {
+		return "TRUE", nil
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// staleBill returns the bill from the database, or from the outbox if its creation is
+// still queued, when its workflow could not be queried because of queryErr. A queued
+// bill is also returned when Temporal is back but the outbox has not replayed it yet.
+// It returns nil otherwise.
+func (s *Service) staleBill(ctx context.Context, billID string, queryErr error) (*Bill, error) {
+	var notFound *serviceerror.NotFound
+	if errors.As(queryErr, &notFound) && s.outbox != nil {
+		return s.outbox.pendingBill(ctx, billID)
+	}
+	if !apierr.IsTemporalUnavailable(queryErr) {
+		return nil, nil
+	}
+	bill, err := loadStaleBill(ctx, s.db, s.fields, billID)
+	if bill != nil || err != nil || s.outbox == nil {
+		return bill, err
+	}
+	return s.outbox.pendingBill(ctx, billID)
+}
+
+// savedBill is what the database holds of a bill that its workflow does not: its
+// number and whether it was deleted.
+type savedBill struct {
+	Number    string
+	DeletedAt *time.Time
+}
+
+// savedBills reads the saved fields of the bills that have any, by bill ID. Without a
+// database no bill has any.
+func savedBills(ctx context.Context, db *tracedDB, billIDs []string) (map[string]savedBill, error) {
+	saved := map[string]savedBill{}
+	if db == nil || len(billIDs) == 0 {
+		return saved, nil
+	}
+	rows, err := db.Query(ctx, `
+        SELECT id, COALESCE(number, ''), deleted_at FROM bills
+        WHERE id = ANY($1::text[]) AND (number IS NOT NULL OR deleted_at IS NOT NULL)
+    `, billIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var b savedBill
+		if err := rows.Scan(&id, &b.Number, &b.DeletedAt); err != nil {
+			return nil, err
+		}
+		saved[id] = b
+	}
+	return saved, rows.Err()
+}
@@ -0,0 +1,23 @@
+package fees
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestStaleBillFilter tests that only the filters that are set reach the query, so the
+// listing can use the customer and status index, and that deleted and test bills are
+// left out unless asked for.
+func TestStaleBillFilter(t *testing.T) {
+	where, args := staleBillFilter(&ListBillsParams{IncludeDeleted: true}, billModes{Live: true, Test: true})
+	require.Equal(t, "TRUE", where)
+	require.Empty(t, args)
+
+	where, args = staleBillFilter(&ListBillsParams{CustomerID: "cust-1", Status: "CLOSED", TenantID: "acme"}, billModes{Live: true})
+	require.Equal(t, "customer_id = $1 AND status = $2 AND tenant_id = $3 AND deleted_at IS NULL AND NOT test", where)
+	require.Equal(t, []any{"cust-1", "CLOSED", "acme"}, args)
+
+	where, _ = staleBillFilter(&ListBillsParams{IncludeDeleted: true}, billModes{Test: true})
+	require.Equal(t, "test", where)
+}
@@ -0,0 +1,195 @@
+package fees
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"encore.app/apierr"
+)
+
+// statementPeriodLayout is the format of a statement period: a calendar month.
+const statementPeriodLayout = "2006-01"
+
+// LineItemCategory groups line items on statements and revenue reports by where they
+// came from.
+type LineItemCategory string
+
+const (
+	// LineItemCategoryCharge is an item added through the API, by a template, or by a subscription.
+	LineItemCategoryCharge LineItemCategory = "charge"
+	// LineItemCategoryRouted is an item forwarded from a bill that had already closed.
+	LineItemCategoryRouted LineItemCategory = "routed"
+	// LineItemCategoryAdjustment is an ADJUSTMENT item, such as the one that brought a
+	// bill's total to the customer's limits.
+	LineItemCategoryAdjustment LineItemCategory = "adjustment"
+	// LineItemCategoryCredit is a CREDIT item: one added through the API, the customer
+	// credit applied to a bill, or an item of a credit note.
+	LineItemCategoryCredit LineItemCategory = "credit"
+)
+
+// StatementParams selects the period of a customer statement.
+type StatementParams struct {
+	// Period is the calendar month of the statement, such as "2024-06". Bills belong
+	// to the month, in UTC, in which they were created.
+	Period string `query:"period"`
+	// IncludeTest also includes test bills. Test mode API keys only ever see test bills.
+	IncludeTest bool `query:"includeTest"`
+}
+
+// StatementCurrencyTotal sums a customer's bills of one currency in a period.
+type StatementCurrencyTotal struct {
+	Currency    string  `json:"currency"`
+	TotalAmount float64 `json:"totalAmount"`
+	BillCount   int     `json:"billCount"`
+	// Categories splits TotalAmount by line item category. Credits are negative.
+	Categories map[LineItemCategory]float64 `json:"categories"`
+}
+
+// StatementBill is a bill listed on a statement.
+type StatementBill struct {
+	ID string `json:"id"`
+	// Number is the bill's number, set once it closed.
+	Number      string     `json:"number,omitempty"`
+	Status      BillStatus `json:"status"`
+	Currency    string     `json:"currency"`
+	TotalAmount float64    `json:"totalAmount"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ClosedAt    *time.Time `json:"closedAt,omitempty"`
+	DueDate     *time.Time `json:"dueDate,omitempty"`
+	// URL is the path of the bill's details.
+	URL string `json:"url"`
+}
+
+// StatementResponse is the response payload of a customer statement.
+type StatementResponse struct {
+	CustomerID  string    `json:"customerId"`
+	Period      string    `json:"period"`
+	PeriodStart time.Time `json:"periodStart"`
+	PeriodEnd   time.Time `json:"periodEnd"`
+	// Totals has one entry per currency the customer was billed in, by currency.
+	Totals []StatementCurrencyTotal `json:"totals"`
+	// BillCounts counts the period's bills by status.
+	BillCounts map[BillStatus]int `json:"billCounts"`
+	Bills      []StatementBill    `json:"bills"`
+}
+
+// parseStatementPeriod returns the bounds of a statement period, start inclusive and
+// end exclusive.
+func parseStatementPeriod(period string) (time.Time, time.Time, error) {
+	if period == "" {
+		return time.Time{}, time.Time{}, apierr.InvalidArgument(apierr.InvalidParameter, "period is required, e.g. \"2024-06\"")
+	}
+	start, err := time.Parse(statementPeriodLayout, period)
+	if err != nil {
+		return time.Time{}, time.Time{}, apierr.InvalidArgument(apierr.InvalidParameter, "invalid period %q: must be a month such as \"2024-06\"", period)
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// lineItemCategorySQL derives the LineItemCategory of line item li from its type. The
+// revenue_daily view repeats it.
+const lineItemCategorySQL = `CASE
+                   WHEN li.type = 'CREDIT' THEN 'credit'
+                   WHEN li.type = 'ADJUSTMENT' THEN 'adjustment'
+                   WHEN li.routed_from_bill_id IS NOT NULL THEN 'routed'
+                   ELSE 'charge'
+               END`
+
+// statementCategoryTotalsQuery sums the line items of a customer's bills in a period
+// by currency and category.
+const statementCategoryTotalsQuery = `
+        SELECT b.currency,
+               ` + lineItemCategorySQL + ` AS category,
+               SUM(li.amount)::float8
+        FROM bills b
+        JOIN line_items li ON li.bill_id = b.id
+        WHERE b.customer_id = $1 AND b.created_at >= $2 AND b.created_at < $3 AND ($4 = '' OR b.tenant_id = $4)
+          AND b.deleted_at IS NULL AND (($5 AND NOT b.test) OR ($6 AND b.test))
+        GROUP BY 1, 2
+    `
+
+// GetCustomerStatement summarizes a customer's bills created in a calendar month:
+// totals by currency and line item category, bill counts by status, and the bills
+// themselves. Deleted bills are left out, as are test bills unless params.IncludeTest.
+// Tenant-scoped callers only see their own tenant's bills.
+//
+// encore:api auth method=GET path=/customers/:customerID/statements
+func (s *Service) GetCustomerStatement(ctx context.Context, customerID string, params *StatementParams) (*StatementResponse, error) {
+	start, end, err := parseStatementPeriod(params.Period)
+	if err != nil {
+		return nil, err
+	}
+	tenant := callerTenant(ctx)
+	modes := callerBillModes(ctx, params.IncludeTest)
+
+	resp := &StatementResponse{
+		CustomerID:  customerID,
+		Period:      params.Period,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Totals:      []StatementCurrencyTotal{},
+		BillCounts:  map[BillStatus]int{},
+		Bills:       []StatementBill{},
+	}
+	totals := map[string]*StatementCurrencyTotal{}
+	totalFor := func(currency string) *StatementCurrencyTotal {
+		if t, ok := totals[currency]; ok {
+			return t
+		}
+		t := &StatementCurrencyTotal{Currency: currency, Categories: map[LineItemCategory]float64{}}
+		totals[currency] = t
+		return t
+	}
+
+	rows, err := s.db.Query(ctx, `
+        SELECT id, COALESCE(number, ''), status, currency, total_amount::float8, created_at, closed_at, due_date
+        FROM bills
+        WHERE customer_id = $1 AND created_at >= $2 AND created_at < $3 AND ($4 = '' OR tenant_id = $4)
+          AND deleted_at IS NULL AND (($5 AND NOT test) OR ($6 AND test))
+        ORDER BY created_at, id
+    `, customerID, start, end, tenant, modes.Live, modes.Test)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load bills of customer %s", customerID)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b StatementBill
+		if err := rows.Scan(&b.ID, &b.Number, &b.Status, &b.Currency, &b.TotalAmount, &b.CreatedAt, &b.ClosedAt, &b.DueDate); err != nil {
+			return nil, apierr.Wrap(err, "failed to read bills of customer %s", customerID)
+		}
+		b.URL = "/bills/" + b.ID
+		resp.Bills = append(resp.Bills, b)
+		resp.BillCounts[b.Status]++
+		totalFor(b.Currency).BillCount++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read bills of customer %s", customerID)
+	}
+
+	rows, err = s.db.Query(ctx, statementCategoryTotalsQuery, customerID, start, end, tenant, modes.Live, modes.Test)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to total bills of customer %s", customerID)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var currency string
+		var category LineItemCategory
+		var amount float64
+		if err := rows.Scan(&currency, &category, &amount); err != nil {
+			return nil, apierr.Wrap(err, "failed to read totals of customer %s", customerID)
+		}
+		t := totalFor(currency)
+		t.Categories[category] = roundAmount(amount)
+		t.TotalAmount = roundAmount(t.TotalAmount + amount)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read totals of customer %s", customerID)
+	}
+
+	for _, t := range totals {
+		resp.Totals = append(resp.Totals, *t)
+	}
+	sort.Slice(resp.Totals, func(i, j int) bool { return resp.Totals[i].Currency < resp.Totals[j].Currency })
+	return resp, nil
+}
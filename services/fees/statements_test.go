@@ -0,0 +1,21 @@
+package fees
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseStatementPeriod tests that a period is a calendar month in UTC.
+func TestParseStatementPeriod(t *testing.T) {
+	start, end, err := parseStatementPeriod("2024-12")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), start)
+	require.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), end)
+
+	for _, period := range []string{"", "2024-13", "2024-06-01", "June 2024"} {
+		_, _, err := parseStatementPeriod(period)
+		require.Error(t, err, period)
+	}
+}
@@ -0,0 +1,116 @@
+package fees
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// statusWindow is the period the public status feed aggregates over.
+const statusWindow = time.Hour
+
+// outcomeCounter counts successes and failures in per-minute buckets over a
+// rolling statusWindow. It is safe for concurrent use.
+type outcomeCounter struct {
+	mu      sync.Mutex
+	buckets [60]outcomeBucket
+}
+
+type outcomeBucket struct {
+	minute    int64
+	succeeded int
+	failed    int
+}
+
+func (c *outcomeCounter) record(now time.Time, ok bool) {
+	minute := now.Unix() / 60
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b := &c.buckets[minute%int64(len(c.buckets))]
+	if b.minute != minute {
+		*b = outcomeBucket{minute: minute}
+	}
+	if ok {
+		b.succeeded++
+	} else {
+		b.failed++
+	}
+}
+
+func (c *outcomeCounter) totals(now time.Time) (succeeded, failed int) {
+	oldest := now.Add(-statusWindow).Unix() / 60
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, b := range c.buckets {
+		if b.minute > oldest {
+			succeeded += b.succeeded
+			failed += b.failed
+		}
+	}
+	return succeeded, failed
+}
+
+// statusMetrics holds the process-wide counters behind the public status feed.
+// Only aggregate outcomes are recorded; no bill, customer, or tenant data is kept.
+type statusMetrics struct {
+	clock             Clock
+	closes            outcomeCounter
+	webhookDeliveries outcomeCounter
+}
+
+// recordClose records the outcome of a CloseBill request.
+func (m *statusMetrics) recordClose(ok bool) {
+	m.closes.record(m.clock.Now(), ok)
+}
+
+// recordWebhookDelivery records the outcome of an outbound webhook delivery.
+func (m *statusMetrics) recordWebhookDelivery(ok bool) {
+	m.webhookDeliveries.record(m.clock.Now(), ok)
+}
+
+// StatusFeedResponse is the aggregated, tenant-free payload served to the status page.
+type StatusFeedResponse struct {
+	GeneratedAt   time.Time `json:"generatedAt"`
+	WindowMinutes int       `json:"windowMinutes"`
+	// BillsProcessed is the number of bills closed during the window.
+	BillsProcessed int `json:"billsProcessed"`
+	// CloseSuccessRate and WebhookDeliverySuccessRate are fractions between 0 and 1,
+	// omitted when there was no traffic during the window.
+	CloseSuccessRate           *float64 `json:"closeSuccessRate,omitempty"`
+	WebhookDeliverySuccessRate *float64 `json:"webhookDeliverySuccessRate,omitempty"`
+	// Degraded is set while Temporal is unreachable and bills are served from the
+	// database.
+	Degraded bool `json:"degraded,omitempty"`
+}
+
+// GetStatusFeed returns aggregated health figures for the internal status page.
+// It is intentionally unauthenticated and exposes no tenant or customer data.
+//
+// encore:api public method=GET path=/status
+func (s *Service) GetStatusFeed(ctx context.Context) (*StatusFeedResponse, error) {
+	now := s.clock.Now()
+	closesOK, closesFailed := s.statusMetrics.closes.totals(now)
+	webhooksOK, webhooksFailed := s.statusMetrics.webhookDeliveries.totals(now)
+
+	return &StatusFeedResponse{
+		GeneratedAt:                now.UTC().Truncate(time.Minute),
+		WindowMinutes:              int(statusWindow / time.Minute),
+		BillsProcessed:             closesOK,
+		CloseSuccessRate:           successRate(closesOK, closesFailed),
+		WebhookDeliverySuccessRate: successRate(webhooksOK, webhooksFailed),
+		Degraded:                   s.breaker.Open(),
+	}, nil
+}
+
+// successRate returns succeeded/(succeeded+failed) rounded to three decimals, or nil without traffic.
+func successRate(succeeded, failed int) *float64 {
+	total := succeeded + failed
+	if total == 0 {
+		return nil
+	}
+	rate := math.Round(float64(succeeded)/float64(total)*1000) / 1000
+	return &rate
+}
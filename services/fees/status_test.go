@@ -0,0 +1,40 @@
+package fees
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestOutcomeCounter_RollingWindow tests that outcomes older than the status window are dropped.
+func TestOutcomeCounter_RollingWindow(t *testing.T) {
+	var c outcomeCounter
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	c.record(start, true)
+	c.record(start.Add(30*time.Minute), true)
+	c.record(start.Add(30*time.Minute), false)
+
+	ok, failed := c.totals(start.Add(45 * time.Minute))
+	require.Equal(t, 2, ok)
+	require.Equal(t, 1, failed)
+
+	// The first success falls out of the window an hour after it was recorded.
+	ok, failed = c.totals(start.Add(61 * time.Minute))
+	require.Equal(t, 1, ok)
+	require.Equal(t, 1, failed)
+
+	// A bucket reused for a later minute starts from zero.
+	c.record(start.Add(2*time.Hour), false)
+	ok, failed = c.totals(start.Add(2 * time.Hour))
+	require.Equal(t, 0, ok)
+	require.Equal(t, 1, failed)
+}
+
+// TestSuccessRate tests rate rounding and the no-traffic case.
+func TestSuccessRate(t *testing.T) {
+	require.Nil(t, successRate(0, 0))
+	require.Equal(t, 1.0, *successRate(5, 0))
+	require.Equal(t, 0.667, *successRate(2, 1))
+}
@@ -0,0 +1,150 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"encore.app/apierr"
+	"encore.dev/storage/sqldb"
+	"github.com/google/uuid"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// RollUpSubBillActivityName adds a closed sub-bill's total to its parent bill.
+const RollUpSubBillActivityName = "RollUpSubBillActivity"
+
+// checkParentBill checks that a bill of tenantID created with params can be a sub-bill
+// of params.ParentBillID: the parent must be visible to the caller, not closed yet,
+// and in the same tenant and currency.
+func (s *Service) checkParentBill(ctx context.Context, tenantID string, params *CreateBillRequest) error {
+	if params.AutoCollect || params.DueDate != nil {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "sub-bills are paid through their parent bill and cannot set autoCollect or dueDate")
+	}
+	var parent Bill
+	err := s.db.QueryRow(ctx, `SELECT tenant_id, currency, status FROM bills WHERE id = $1`, params.ParentBillID).
+		Scan(&parent.TenantID, &parent.Currency, &parent.Status)
+	if errors.Is(err, sqldb.ErrNoRows) || (err == nil && !visibleToCaller(ctx, parent.TenantID)) {
+		return apierr.NotFound(apierr.BillNotFound, "parent bill %s not found", params.ParentBillID)
+	}
+	if err != nil {
+		return apierr.Wrap(err, "failed to load parent bill %s", params.ParentBillID)
+	}
+	if tenantOrDefault(parent.TenantID) != tenantID {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "parent bill %s belongs to another tenant", params.ParentBillID)
+	}
+	if isTestBill(params.ParentBillID) != params.Test {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "test and live bills cannot be sub-bills of each other")
+	}
+	if parent.Currency != params.Currency {
+		return apierr.InvalidArgument(apierr.InvalidCurrency, "parent bill %s is in %s, not %s", params.ParentBillID, parent.Currency, params.Currency)
+	}
+	if !parent.isFinalizing() {
+		return apierr.FailedPrecondition(apierr.BillClosed, "parent bill %s is already closed", params.ParentBillID)
+	}
+	return nil
+}
+
+// subBillLineItemID derives the ID of the parent's line item rolling up a sub-bill, so
+// a retried roll-up adds it once.
+func subBillLineItemID(subBillID string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte("feems/sub-bill/"+subBillID)).String()
+}
+
+// rollUpToParent adds the total of a sub-bill that just closed to its parent bill as
+// a line item tagged with the sub-bill's ID. Bills without a parent, and sub-bills
+// that came to nothing, roll up nothing.
+func (w *billWorkflow) rollUpToParent() {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+	if bill.ParentBillID == "" || bill.TotalAmount == 0 {
+		return
+	}
+
+	signal := AddLineItemSignal{
+		LineItemID:  subBillLineItemID(bill.ID),
+		Description: fmt.Sprintf("Sub-bill %s", bill.ID),
+		Amount:      bill.TotalAmount,
+		SubBillID:   bill.ID,
+	}
+	err := workflow.ExecuteActivity(ctx, RollUpSubBillActivityName, RollUpSubBillActivityParams{
+		ParentBillID: bill.ParentBillID,
+		Signal:       signal,
+	}).Get(ctx, nil)
+	if err != nil {
+		logger.Error("Failed to execute RollUpSubBillActivity", "bill_id", bill.ID, "parent_bill_id", bill.ParentBillID, "amount", bill.TotalAmount, "error", err)
+		return
+	}
+	logger.Info("Sub-bill total rolled up to parent bill", "bill_id", bill.ID, "parent_bill_id", bill.ParentBillID, "amount", bill.TotalAmount)
+}
+
+// RollUpSubBillActivityParams defines parameters for RollUpSubBillActivity.
+type RollUpSubBillActivityParams struct {
+	ParentBillID string
+	Signal       AddLineItemSignal
+}
+
+// RollUpSubBillActivity signals a sub-bill's roll-up item to its parent bill. A parent
+// whose workflow has completed no longer takes signals, so the item is parked on the
+// parent's follow-up bill instead, as a late item would be.
+func (a *Activities) RollUpSubBillActivity(ctx context.Context, params RollUpSubBillActivityParams) error {
+	err := a.Temporal.SignalWorkflow(ctx, billWorkflowID(params.ParentBillID), "", AddLineItemSignalName, params.Signal)
+	var notFound *serviceerror.NotFound
+	if !errors.As(err, &notFound) {
+		if err != nil {
+			return fmt.Errorf("RollUpSubBillActivity: failed to signal parent bill %s: %w", params.ParentBillID, err)
+		}
+		return nil
+	}
+
+	parent, err := loadStaleBill(ctx, a.DB, a.Fields, params.ParentBillID)
+	if err != nil {
+		return fmt.Errorf("RollUpSubBillActivity: failed to load parent bill %s: %w", params.ParentBillID, err)
+	}
+	if parent == nil {
+		return temporal.NewNonRetryableApplicationError(fmt.Sprintf("parent bill %s not found", params.ParentBillID), "ParentBillNotFound", nil)
+	}
+	if _, err := a.Router.Route(ctx, parent, params.Signal, LateItemPolicyPark); err != nil {
+		return fmt.Errorf("RollUpSubBillActivity: failed to park sub-bill %s on the follow-up of bill %s: %w", params.Signal.SubBillID, params.ParentBillID, err)
+	}
+	return nil
+}
+
+// ListBillChildrenResponse lists the sub-bills of a bill.
+type ListBillChildrenResponse struct {
+	BillID string `json:"billId"`
+	// Children are the sub-bills as saved in the database, without line items.
+	Children []Bill `json:"children"`
+}
+
+// ListBillChildren lists the sub-bills of a bill, oldest first, leaving out deleted
+// ones. Closed sub-bills' totals appear on the parent as line items with their
+// subBillId.
+//
+// encore:api auth method=GET path=/bills/:billID/children
+func (s *Service) ListBillChildren(ctx context.Context, billID string) (*ListBillChildrenResponse, error) {
+	if err := s.checkBillVisible(ctx, billID); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(ctx, `SELECT `+billColumns+` FROM bills WHERE parent_bill_id = $1 AND deleted_at IS NULL ORDER BY created_at, id`, billID)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to list sub-bills of bill %s", billID)
+	}
+	defer rows.Close()
+
+	resp := &ListBillChildrenResponse{BillID: billID, Children: []Bill{}}
+	for rows.Next() {
+		bill, err := scanBill(rows)
+		if err != nil {
+			return nil, apierr.Wrap(err, "failed to read sub-bills of bill %s", billID)
+		}
+		// Sub-bills are always read from the database; Stale only flags reads that fell back to it.
+		bill.Stale = false
+		resp.Children = append(resp.Children, *bill)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, apierr.Wrap(err, "failed to read sub-bills of bill %s", billID)
+	}
+	return resp, nil
+}
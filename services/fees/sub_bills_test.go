@@ -0,0 +1,30 @@
+package fees
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"encore.app/apierr"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSubBillLineItemID tests that a sub-bill always rolls up under the same item, and
+// that sub-bills do not share one.
+func TestSubBillLineItemID(t *testing.T) {
+	require.Equal(t, subBillLineItemID("bill-1"), subBillLineItemID("bill-1"))
+	require.NotEqual(t, subBillLineItemID("bill-1"), subBillLineItemID("bill-2"))
+	require.NotEqual(t, subBillLineItemID("bill-1"), nextBillID("bill-1"))
+}
+
+// TestCheckParentBill_PaidThroughParent tests that sub-bills cannot collect payment or
+// fall due on their own.
+func TestCheckParentBill_PaidThroughParent(t *testing.T) {
+	s := &Service{}
+	err := s.checkParentBill(context.Background(), "default", &CreateBillRequest{Currency: "USD", ParentBillID: "bill-org", AutoCollect: true})
+	require.Equal(t, apierr.InvalidParameter, apierr.ReasonOf(err))
+
+	dueDate := time.Now().Add(24 * time.Hour)
+	err = s.checkParentBill(context.Background(), "default", &CreateBillRequest{Currency: "USD", ParentBillID: "bill-org", DueDate: &dueDate})
+	require.Equal(t, apierr.InvalidParameter, apierr.ReasonOf(err))
+}
@@ -0,0 +1,212 @@
+package fees
+
+import (
+	"fmt"
+	"time"
+
+	"encore.app/proration"
+	"github.com/google/uuid"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/workflow"
+)
+
+// SubscriptionWorkflowParams defines the parameters for starting the SubscriptionWorkflow.
+// Each run bills one period and continues as new for the next.
+type SubscriptionWorkflowParams struct {
+	SubscriptionID string
+	Plan           SubscriptionPlan
+	// Bill holds the settings of every bill the subscription opens; BillID is set per period.
+	Bill              BillWorkflowParams
+	Period            int
+	PeriodStart       time.Time
+	CancelAtPeriodEnd bool
+	// Proration measures the unused part of a period on a plan change or immediate
+	// cancellation; empty means proration.ByDay.
+	Proration proration.Method
+}
+
+// subscriptionBillID names the bill a subscription opens for a period, so a retried
+// period start finds its existing bill.
+func subscriptionBillID(subscriptionID string, period int) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(fmt.Sprintf("feems/subscription-bill/%s/%d", subscriptionID, period))).String()
+}
+
+// InvalidProrationErrorType is the application error type ProrateActivity fails with
+// when a change cannot be prorated. It is never retried.
+const InvalidProrationErrorType = "InvalidProration"
+
+// ProrateActivityParams defines parameters for ProrateActivity.
+type ProrateActivityParams struct {
+	Change proration.Change
+}
+
+// SubscriptionWorkflow bills a customer's plan one period at a time. It opens a child
+// BillWorkflow for the period, charges the plan, and closes the bill when the period
+// ends. A plan change or immediate cancellation mid-period adds proration line items to
+// the current bill. The workflow continues as new for each period until the subscription
+// is canceled.
+func SubscriptionWorkflow(ctx workflow.Context, params *SubscriptionWorkflowParams) (*SubscriptionState, error) {
+	logger := workflow.GetLogger(ctx)
+	state := &SubscriptionState{
+		ID:                 params.SubscriptionID,
+		TenantID:           tenantOrDefault(params.Bill.TenantID),
+		CustomerID:         params.Bill.CustomerID,
+		Status:             SubscriptionStatusActive,
+		Plan:               params.Plan,
+		Period:             params.Period,
+		CurrentPeriodStart: params.PeriodStart,
+		CurrentPeriodEnd:   params.Plan.Interval.periodEnd(params.PeriodStart),
+		CurrentBillID:      subscriptionBillID(params.SubscriptionID, params.Period),
+		CancelAtPeriodEnd:  params.CancelAtPeriodEnd,
+	}
+	if err := workflow.SetQueryHandler(ctx, GetSubscriptionStateQueryName, func() (*SubscriptionState, error) {
+		return state, nil
+	}); err != nil {
+		logger.Error("Failed to register subscription query handler", "error", err)
+		return nil, err
+	}
+
+	changeCh := workflow.GetSignalChannel(ctx, ChangeSubscriptionPlanSignalName)
+	cancelCh := workflow.GetSignalChannel(ctx, CancelSubscriptionSignalName)
+
+	// The bill outlives this run: it is closed at period end but still collected,
+	// refunded or dunned after the subscription has moved on to the next period.
+	billParams := params.Bill
+	billParams.BillID = state.CurrentBillID
+	billParams.UsagePrices = params.Plan.UsagePrices
+	billParams.FreeTiers = params.Plan.FreeTiers
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID:        billWorkflowID(billParams.BillID),
+		ParentClosePolicy: enums.PARENT_CLOSE_POLICY_ABANDON,
+	})
+	bill := workflow.ExecuteChildWorkflow(childCtx, BillWorkflow, &billParams)
+	if err := bill.GetChildWorkflowExecution().Get(ctx, nil); err != nil {
+		logger.Error("Failed to start subscription bill", "subscription_id", params.SubscriptionID, "bill_id", billParams.BillID, "error", err)
+		return nil, fmt.Errorf("failed to start bill for period %d: %w", params.Period, err)
+	}
+	logger.Info("SubscriptionWorkflow period started", "subscription_id", params.SubscriptionID, "period", params.Period, "bill_id", billParams.BillID)
+
+	charge := func(reference, description string, amount float64) {
+		reference = fmt.Sprintf("subscription/%s/%d/%s", params.SubscriptionID, params.Period, reference)
+		err := bill.SignalChildWorkflow(ctx, AddLineItemSignalName, AddLineItemSignal{
+			LineItemID:      referenceID(billParams.BillID, reference),
+			Description:     description,
+			Amount:          amount,
+			CreatedByKeyID:  billParams.CreatedByKeyID,
+			ClientReference: reference,
+			NotUsage:        true,
+		}).Get(ctx, nil)
+		if err != nil {
+			logger.Error("Failed to add subscription charge", "subscription_id", params.SubscriptionID, "bill_id", billParams.BillID, "reference", reference, "error", err)
+		}
+	}
+
+	charge("plan", state.Plan.chargeDescription(state.CurrentPeriodStart, state.CurrentPeriodEnd), state.Plan.Amount)
+
+	method := params.Proration
+	if method == "" {
+		method = proration.ByDay
+	}
+	// prorate prices a switch from the current plan to amount for the rest of the period.
+	prorate := func(amount float64) (credit, charge float64) {
+		var result proration.Result
+		err := workflow.ExecuteActivity(ctx, ProrateActivityName, ProrateActivityParams{Change: proration.Change{
+			From:        state.Plan.Amount,
+			To:          amount,
+			PeriodStart: state.CurrentPeriodStart,
+			PeriodEnd:   state.CurrentPeriodEnd,
+			At:          workflow.Now(ctx),
+			Method:      method,
+		}}).Get(ctx, &result)
+		if err != nil {
+			logger.Error("Failed to execute ProrateActivity", "subscription_id", params.SubscriptionID, "error", err)
+			return 0, 0
+		}
+		return result.Credit, result.Charge
+	}
+
+	changePlan := func(signal ChangeSubscriptionPlanSignal) {
+		if signal.Plan.Currency != state.Plan.Currency {
+			logger.Warn("Plan change to another currency ignored", "subscription_id", params.SubscriptionID, "currency", signal.Plan.Currency)
+			return
+		}
+		credit, amount := prorate(signal.Plan.Amount)
+		if credit != 0 {
+			charge("change/"+signal.ChangeID+"/credit", fmt.Sprintf("Proration credit: unused %s plan", state.Plan.Name), credit)
+		}
+		if amount != 0 {
+			charge("change/"+signal.ChangeID+"/charge", fmt.Sprintf("Proration: %s plan for the rest of the period", signal.Plan.Name), amount)
+		}
+		logger.Info("Subscription plan changed", "subscription_id", params.SubscriptionID, "from", state.Plan.Name, "to", signal.Plan.Name)
+		state.Plan = signal.Plan
+	}
+
+	ended := false
+	cancelSubscription := func(signal CancelSubscriptionSignal) {
+		state.CancelAtPeriodEnd = true
+		logger.Info("Subscription cancellation requested", "subscription_id", params.SubscriptionID, "immediately", signal.Immediately)
+		if !signal.Immediately || ended {
+			return
+		}
+		if credit, _ := prorate(0); credit != 0 {
+			charge("cancel/credit", fmt.Sprintf("Proration credit: unused %s plan after cancellation", state.Plan.Name), credit)
+		}
+		state.CurrentPeriodEnd = workflow.Now(ctx)
+		ended = true
+	}
+
+	// Wait for the period to end, applying plan changes and cancellation as they arrive.
+	for !ended {
+		timerCtx, cancelTimer := workflow.WithCancel(ctx)
+		selector := workflow.NewSelector(ctx)
+		selector.AddFuture(workflow.NewTimer(timerCtx, state.CurrentPeriodEnd.Sub(workflow.Now(ctx))), func(f workflow.Future) {
+			ended = true
+		})
+		selector.AddReceive(changeCh, func(c workflow.ReceiveChannel, more bool) {
+			var signal ChangeSubscriptionPlanSignal
+			c.Receive(ctx, &signal)
+			changePlan(signal)
+		})
+		selector.AddReceive(cancelCh, func(c workflow.ReceiveChannel, more bool) {
+			var signal CancelSubscriptionSignal
+			c.Receive(ctx, &signal)
+			cancelSubscription(signal)
+		})
+		selector.Select(ctx)
+		cancelTimer()
+	}
+
+	// Signals that arrived with the period end apply from the next period, without proration.
+	for {
+		var signal ChangeSubscriptionPlanSignal
+		if !changeCh.ReceiveAsync(&signal) {
+			break
+		}
+		if signal.Plan.Currency == state.Plan.Currency {
+			state.Plan = signal.Plan
+		}
+	}
+	for {
+		var signal CancelSubscriptionSignal
+		if !cancelCh.ReceiveAsync(&signal) {
+			break
+		}
+		cancelSubscription(signal)
+	}
+
+	if err := bill.SignalChildWorkflow(ctx, CloseBillSignalName, CloseBillSignal{}).Get(ctx, nil); err != nil {
+		logger.Error("Failed to close subscription bill", "subscription_id", params.SubscriptionID, "bill_id", billParams.BillID, "error", err)
+	}
+
+	if state.CancelAtPeriodEnd {
+		state.Status = SubscriptionStatusCanceled
+		logger.Info("SubscriptionWorkflow canceled", "subscription_id", params.SubscriptionID, "period", params.Period)
+		return state, nil
+	}
+
+	next := *params
+	next.Plan = state.Plan
+	next.Period++
+	next.PeriodStart = state.CurrentPeriodEnd
+	return nil, workflow.NewContinueAsNewError(ctx, SubscriptionWorkflow, &next)
+}
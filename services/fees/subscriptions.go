@@ -0,0 +1,323 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"encore.app/apierr"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+)
+
+// SubscriptionInterval is how often a subscription bills its plan.
+type SubscriptionInterval string
+
+const (
+	SubscriptionIntervalDaily   SubscriptionInterval = "daily"
+	SubscriptionIntervalWeekly  SubscriptionInterval = "weekly"
+	SubscriptionIntervalMonthly SubscriptionInterval = "monthly"
+	SubscriptionIntervalYearly  SubscriptionInterval = "yearly"
+)
+
+// periodEnd returns the end of a billing period that starts at start.
+func (i SubscriptionInterval) periodEnd(start time.Time) time.Time {
+	switch i {
+	case SubscriptionIntervalDaily:
+		return start.AddDate(0, 0, 1)
+	case SubscriptionIntervalWeekly:
+		return start.AddDate(0, 0, 7)
+	case SubscriptionIntervalYearly:
+		return start.AddDate(1, 0, 0)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
+
+func (i SubscriptionInterval) valid() bool {
+	switch i {
+	case SubscriptionIntervalDaily, SubscriptionIntervalWeekly, SubscriptionIntervalMonthly, SubscriptionIntervalYearly:
+		return true
+	}
+	return false
+}
+
+// SubscriptionPlan is the recurring charge a subscription bills each period.
+type SubscriptionPlan struct {
+	Name     string               `json:"name"`
+	Amount   float64              `json:"amount"`
+	Currency string               `json:"currency"`
+	Interval SubscriptionInterval `json:"interval"`
+	// UsagePrices price the usage of metrics in tiers on each period's bill, on top of
+	// the plan's amount.
+	UsagePrices []UsagePrice `json:"usagePrices,omitempty"`
+	// FreeTiers waive the first units of a metric's usage on each period's bill, or
+	// what the subscription charges in the first days after it starts.
+	FreeTiers []FreeTier `json:"freeTiers,omitempty"`
+}
+
+// validate rejects plans that could not be billed.
+func (p *SubscriptionPlan) validate() error {
+	if p.Name == "" {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "plan.name is required")
+	}
+	if math.IsNaN(p.Amount) || math.IsInf(p.Amount, 0) || p.Amount <= 0 {
+		return apierr.InvalidArgument(apierr.InvalidAmount, "plan.amount must be a positive number, got %v", p.Amount)
+	}
+	if !validCurrency(p.Currency) {
+		return apierr.InvalidArgument(apierr.InvalidCurrency, "invalid currency %q: must be a three-letter ISO 4217 code such as \"USD\"", p.Currency)
+	}
+	if !p.Interval.valid() {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "invalid plan.interval %q: must be daily, weekly, monthly or yearly", p.Interval)
+	}
+	if err := normalizeUsagePrices(p.UsagePrices); err != nil {
+		return err
+	}
+	return normalizeFreeTiers(p.FreeTiers, p.UsagePrices)
+}
+
+// chargeDescription describes the line item charging the plan for a billing period.
+func (p *SubscriptionPlan) chargeDescription(start, end time.Time) string {
+	const dateLayout = "2006-01-02"
+	return fmt.Sprintf("%s plan, %s to %s", p.Name, start.Format(dateLayout), end.Format(dateLayout))
+}
+
+// SubscriptionStatus represents the state of a subscription.
+type SubscriptionStatus string
+
+const (
+	SubscriptionStatusActive   SubscriptionStatus = "ACTIVE"
+	SubscriptionStatusCanceled SubscriptionStatus = "CANCELED"
+)
+
+// SubscriptionState is the queryable state of a SubscriptionWorkflow.
+type SubscriptionState struct {
+	ID         string             `json:"id"`
+	TenantID   string             `json:"tenantId"`
+	CustomerID string             `json:"customerId"`
+	Status     SubscriptionStatus `json:"status"`
+	Plan       SubscriptionPlan   `json:"plan"`
+	// Period counts billing periods from zero; each period has its own bill.
+	Period             int       `json:"period"`
+	CurrentPeriodStart time.Time `json:"currentPeriodStart"`
+	CurrentPeriodEnd   time.Time `json:"currentPeriodEnd"`
+	CurrentBillID      string    `json:"currentBillId"`
+	// CancelAtPeriodEnd is set once cancellation is requested; the current bill is still
+	// closed and collected as usual.
+	CancelAtPeriodEnd bool `json:"cancelAtPeriodEnd,omitempty"`
+}
+
+// CreateSubscriptionRequest is the request payload for subscribing a customer to a plan.
+type CreateSubscriptionRequest struct {
+	// TenantID identifies the platform calling the API, which owns the subscription and
+	// its bills. API keys always use their own tenant.
+	TenantID   string           `header:"X-Tenant-ID"`
+	CustomerID string           `json:"customerId"`
+	Plan       SubscriptionPlan `json:"plan"`
+	// AutoCollect charges each period's bill as soon as it closes.
+	AutoCollect bool `json:"autoCollect,omitempty"`
+}
+
+// CreateSubscriptionResponse is the response payload after subscribing a customer.
+type CreateSubscriptionResponse struct {
+	RetryMetadata
+	SubscriptionID  string `json:"subscriptionId"`
+	WorkflowID      string `json:"workflowId"`
+	RunID           string `json:"runId"`
+	ConfirmationMsg string `json:"confirmationMsg"`
+}
+
+// SubscriptionResponse is the response payload for retrieving a subscription.
+type SubscriptionResponse struct {
+	Subscription SubscriptionState `json:"subscription"`
+}
+
+// ChangeSubscriptionPlanRequest is the request payload for moving a subscription to
+// another plan.
+type ChangeSubscriptionPlanRequest struct {
+	Plan SubscriptionPlan `json:"plan"`
+}
+
+// CancelSubscriptionRequest is the request payload for canceling a subscription.
+type CancelSubscriptionRequest struct {
+	// Immediately ends the period now and credits its unused part, instead of canceling
+	// at period end.
+	Immediately bool `json:"immediately,omitempty"`
+}
+
+// SubscriptionActionResponse is the response payload after changing or canceling a
+// subscription.
+type SubscriptionActionResponse struct {
+	RetryMetadata
+	SubscriptionID  string `json:"subscriptionId"`
+	ConfirmationMsg string `json:"confirmationMsg"`
+}
+
+// ChangeSubscriptionPlanSignal moves a subscription to another plan for the rest of the
+// current period.
+type ChangeSubscriptionPlanSignal struct {
+	// ChangeID names the proration items of the change, so a retried request adds them once.
+	ChangeID string
+	Plan     SubscriptionPlan
+	// RequestedByKeyID is the API key that requested the change.
+	RequestedByKeyID string
+}
+
+// CancelSubscriptionSignal cancels a subscription.
+type CancelSubscriptionSignal struct {
+	Immediately bool
+	// RequestedByKeyID is the API key that requested the cancellation.
+	RequestedByKeyID string
+}
+
+func subscriptionWorkflowID(subscriptionID string) string {
+	return "subscription-" + subscriptionID
+}
+
+// CreateSubscription subscribes a customer to a plan. A bill is opened for each billing
+// period, charged the plan amount, and closed when the period ends.
+//
+// encore:api auth method=POST path=/subscriptions
+func (s *Service) CreateSubscription(ctx context.Context, params *CreateSubscriptionRequest) (*CreateSubscriptionResponse, error) {
+	if params.CustomerID == "" {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "customerId is required")
+	}
+	// Subscription bills are live bills, so test mode keys would create revenue.
+	if callerTestMode(ctx) {
+		return nil, apierr.FailedPrecondition(apierr.TestModeUnsupported, "test mode API keys cannot create subscriptions")
+	}
+	if err := params.Plan.validate(); err != nil {
+		return nil, err
+	}
+	if err := s.limits.checkCustomer(params.CustomerID); err != nil {
+		return nil, err
+	}
+	tenantID := requestTenant(ctx, params.TenantID)
+	billParams, err := newBillParams(ctx, s.db, s.cfg, tenantID, params.CustomerID, params.Plan.Currency)
+	if err != nil {
+		return nil, apierr.Wrap(err, "failed to load billing settings for customer %s", params.CustomerID)
+	}
+	if billParams.Contract, err = loadContract(ctx, s.db, tenantID, params.CustomerID, params.Plan.Currency); err != nil {
+		return nil, apierr.Wrap(err, "failed to load contract for customer %s", params.CustomerID)
+	}
+	billParams.AutoCollect = params.AutoCollect
+	billParams.CreatedByKeyID = callerKeyID(ctx)
+
+	subscriptionID := keyedID(ctx, "subscription", tenantID)
+	start := s.clock.Now().UTC()
+	// Trials count from the start of the subscription, not of each period.
+	billParams.TrialStart = &start
+	workflowParams := SubscriptionWorkflowParams{
+		SubscriptionID: subscriptionID,
+		Plan:           params.Plan,
+		PeriodStart:    start,
+		Proration:      s.cfg.ProrationMethod,
+		Bill:           billParams,
+	}
+
+	options := client.StartWorkflowOptions{
+		ID:        subscriptionWorkflowID(subscriptionID),
+		TaskQueue: s.cfg.TaskQueues.forTenant(tenantID),
+	}
+	if idempotencyKey(ctx) != "" {
+		options.WorkflowIDReusePolicy = enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE
+		options.WorkflowExecutionErrorWhenAlreadyStarted = true
+	}
+
+	we, err := s.temporalClient.ExecuteWorkflow(ctx, options, SubscriptionWorkflow, &workflowParams)
+	var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+	if errors.As(err, &alreadyStarted) {
+		return &CreateSubscriptionResponse{
+			SubscriptionID:  subscriptionID,
+			WorkflowID:      options.ID,
+			RunID:           alreadyStarted.RunId,
+			ConfirmationMsg: "Subscription already created for this idempotency key.",
+		}, nil
+	}
+	if err != nil {
+		return nil, apierr.FromTemporal(err, apierr.Internal, "failed to create subscription")
+	}
+
+	return &CreateSubscriptionResponse{
+		SubscriptionID:  subscriptionID,
+		WorkflowID:      we.GetID(),
+		RunID:           we.GetRunID(),
+		ConfirmationMsg: fmt.Sprintf("Customer %s subscribed to plan %s.", params.CustomerID, params.Plan.Name),
+	}, nil
+}
+
+// GetSubscription retrieves a subscription and its current billing period.
+//
+// encore:api auth method=GET path=/subscriptions/:subscriptionID
+func (s *Service) GetSubscription(ctx context.Context, subscriptionID string) (*SubscriptionResponse, error) {
+	resp, err := s.temporalClient.QueryWorkflow(ctx, subscriptionWorkflowID(subscriptionID), "", GetSubscriptionStateQueryName)
+	if err != nil {
+		return nil, apierr.FromTemporal(err, apierr.SubscriptionNotFound, "subscription %s not found", subscriptionID)
+	}
+
+	var state SubscriptionState
+	if err := resp.Get(&state); err != nil {
+		return nil, apierr.Wrap(err, "failed to decode state of subscription %s", subscriptionID)
+	}
+	if !visibleToCaller(ctx, state.TenantID) {
+		return nil, apierr.NotFound(apierr.SubscriptionNotFound, "subscription %s not found", subscriptionID)
+	}
+	return &SubscriptionResponse{Subscription: state}, nil
+}
+
+// ChangeSubscriptionPlan upgrades or downgrades a subscription. The current bill is
+// credited for the unused part of the old plan and charged for the rest of the period on
+// the new one; the new plan's interval applies from the next period.
+//
+// encore:api auth method=POST path=/subscriptions/:subscriptionID/plan
+func (s *Service) ChangeSubscriptionPlan(ctx context.Context, subscriptionID string, params *ChangeSubscriptionPlanRequest) (*SubscriptionActionResponse, error) {
+	if err := params.Plan.validate(); err != nil {
+		return nil, err
+	}
+	current, err := s.GetSubscription(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if current.Subscription.Status != SubscriptionStatusActive {
+		return nil, apierr.FailedPrecondition(apierr.SubscriptionCanceled, "subscription %s is %s and its plan can no longer change", subscriptionID, current.Subscription.Status)
+	}
+	if params.Plan.Currency != current.Subscription.Plan.Currency {
+		return nil, apierr.InvalidArgument(apierr.InvalidCurrency, "subscription %s bills in %s; a plan in %s cannot replace it", subscriptionID, current.Subscription.Plan.Currency, params.Plan.Currency)
+	}
+
+	signal := ChangeSubscriptionPlanSignal{
+		ChangeID:         keyedID(ctx, "subscription-plan-change", subscriptionID),
+		Plan:             params.Plan,
+		RequestedByKeyID: callerKeyID(ctx),
+	}
+	if err := s.temporalClient.SignalWorkflow(ctx, subscriptionWorkflowID(subscriptionID), "", ChangeSubscriptionPlanSignalName, signal); err != nil {
+		return nil, apierr.FromTemporal(err, apierr.SubscriptionNotFound, "subscription %s not found", subscriptionID)
+	}
+	return &SubscriptionActionResponse{
+		SubscriptionID:  subscriptionID,
+		ConfirmationMsg: fmt.Sprintf("Plan change to %s requested.", params.Plan.Name),
+	}, nil
+}
+
+// CancelSubscription ends a subscription at the end of its current period, or at once
+// with the unused part of the period credited. The current bill is closed and collected
+// as usual; no further bills are opened.
+//
+// encore:api auth method=POST path=/subscriptions/:subscriptionID/cancel
+func (s *Service) CancelSubscription(ctx context.Context, subscriptionID string, params *CancelSubscriptionRequest) (*SubscriptionActionResponse, error) {
+	if _, err := s.GetSubscription(ctx, subscriptionID); err != nil {
+		return nil, err
+	}
+	signal := CancelSubscriptionSignal{Immediately: params.Immediately, RequestedByKeyID: callerKeyID(ctx)}
+	if err := s.temporalClient.SignalWorkflow(ctx, subscriptionWorkflowID(subscriptionID), "", CancelSubscriptionSignalName, signal); err != nil {
+		return nil, apierr.FromTemporal(err, apierr.SubscriptionNotFound, "subscription %s not found", subscriptionID)
+	}
+	msg := "Subscription will be canceled at the end of the current period."
+	if params.Immediately {
+		msg = "Subscription cancellation requested; the rest of the current period will be credited."
+	}
+	return &SubscriptionActionResponse{SubscriptionID: subscriptionID, ConfirmationMsg: msg}, nil
+}
@@ -0,0 +1,38 @@
+package fees
+
+import (
+	"testing"
+	"time"
+
+	"encore.app/apierr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionInterval_PeriodEnd(t *testing.T) {
+	start := time.Date(2026, time.January, 31, 12, 0, 0, 0, time.UTC)
+	require.Equal(t, start.AddDate(0, 0, 1), SubscriptionIntervalDaily.periodEnd(start))
+	require.Equal(t, start.AddDate(0, 0, 7), SubscriptionIntervalWeekly.periodEnd(start))
+	require.Equal(t, start.AddDate(0, 1, 0), SubscriptionIntervalMonthly.periodEnd(start))
+	require.Equal(t, start.AddDate(1, 0, 0), SubscriptionIntervalYearly.periodEnd(start))
+}
+
+func TestSubscriptionPlan_Validate(t *testing.T) {
+	valid := SubscriptionPlan{Name: "Pro", Amount: 49, Currency: "USD", Interval: SubscriptionIntervalMonthly}
+	require.NoError(t, valid.validate())
+
+	for name, tc := range map[string]struct {
+		mutate func(*SubscriptionPlan)
+		reason apierr.Reason
+	}{
+		"missing name":     {func(p *SubscriptionPlan) { p.Name = "" }, apierr.InvalidParameter},
+		"zero amount":      {func(p *SubscriptionPlan) { p.Amount = 0 }, apierr.InvalidAmount},
+		"bad currency":     {func(p *SubscriptionPlan) { p.Currency = "usd" }, apierr.InvalidCurrency},
+		"unknown interval": {func(p *SubscriptionPlan) { p.Interval = "hourly" }, apierr.InvalidParameter},
+	} {
+		t.Run(name, func(t *testing.T) {
+			plan := valid
+			tc.mutate(&plan)
+			require.Equal(t, tc.reason, apierr.ReasonOf(plan.validate()))
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package fees
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// TaskQueueConfig says which Temporal task queues the fees workflows run on. Tenants
+// without a dedicated queue share Default, so a tenant that starts many workflows can
+// be moved to its own queue, and its own workers, without starving the others.
+type TaskQueueConfig struct {
+	// Default is the shared task queue; see getTaskQueueName.
+	Default string
+	// Tenants maps tenant IDs to dedicated task queues, given as
+	// "<tenant>=<queue>,<tenant>=<queue>".
+	Tenants map[string]string
+	// Poll lists the task queues this process runs workers for. It defaults to the
+	// shared queue and every dedicated one, so a single process serves all tenants.
+	Poll []string
+}
+
+// loadTaskQueueConfig reads the task queue settings from the environment.
+func loadTaskQueueConfig() (TaskQueueConfig, error) {
+	c := TaskQueueConfig{Default: feesTaskQueue}
+	tenants, err := parseTenantTaskQueues(os.Getenv("FEES_TENANT_TASK_QUEUES"))
+	if err != nil {
+		return TaskQueueConfig{}, err
+	}
+	c.Tenants = tenants
+
+	if v := os.Getenv("FEES_WORKER_TASK_QUEUES"); v != "" {
+		for _, queue := range strings.Split(v, ",") {
+			queue = strings.TrimSpace(queue)
+			if queue == "" {
+				return TaskQueueConfig{}, fmt.Errorf("invalid FEES_WORKER_TASK_QUEUES %q: empty task queue", v)
+			}
+			c.Poll = appendUnique(c.Poll, queue)
+		}
+		return c, nil
+	}
+	c.Poll = []string{c.Default}
+	dedicated := make([]string, 0, len(c.Tenants))
+	for _, queue := range c.Tenants {
+		dedicated = append(dedicated, queue)
+	}
+	sort.Strings(dedicated)
+	for _, queue := range dedicated {
+		c.Poll = appendUnique(c.Poll, queue)
+	}
+	return c, nil
+}
+
+// parseTenantTaskQueues parses "<tenant>=<queue>,<tenant>=<queue>". An empty string
+// routes no tenant.
+func parseTenantTaskQueues(v string) (map[string]string, error) {
+	if v == "" {
+		return nil, nil
+	}
+	tenants := make(map[string]string)
+	for _, entry := range strings.Split(v, ",") {
+		tenant, queue, ok := strings.Cut(entry, "=")
+		tenant, queue = strings.TrimSpace(tenant), strings.TrimSpace(queue)
+		if !ok || tenant == "" || queue == "" {
+			return nil, fmt.Errorf("invalid FEES_TENANT_TASK_QUEUES entry %q: want <tenant>=<queue>", entry)
+		}
+		if _, dup := tenants[tenant]; dup {
+			return nil, fmt.Errorf("invalid FEES_TENANT_TASK_QUEUES: tenant %s is routed twice", tenant)
+		}
+		tenants[tenant] = queue
+	}
+	return tenants, nil
+}
+
+// forTenant returns the task queue new workflows of tenantID are started on. Workflows
+// already running stay on the queue they were started on, as do their child workflows
+// and activities.
+func (c TaskQueueConfig) forTenant(tenantID string) string {
+	if queue, ok := c.Tenants[tenantOrDefault(tenantID)]; ok {
+		return queue
+	}
+	return c.Default
+}
+
+// appendUnique appends s to list unless it is already there.
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
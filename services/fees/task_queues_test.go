@@ -0,0 +1,39 @@
+package fees
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoadTaskQueueConfig tests that tenants are routed to their dedicated task queues
+// and that the process polls the shared queue and every dedicated one by default.
+func TestLoadTaskQueueConfig(t *testing.T) {
+	c, err := loadTaskQueueConfig()
+	require.NoError(t, err)
+	require.Equal(t, feesTaskQueue, c.forTenant("acme"))
+	require.Equal(t, []string{feesTaskQueue}, c.Poll)
+
+	t.Setenv("FEES_TENANT_TASK_QUEUES", "acme=acme-fees, globex = big-tenants,initech=big-tenants")
+	c, err = loadTaskQueueConfig()
+	require.NoError(t, err)
+	require.Equal(t, "acme-fees", c.forTenant("acme"))
+	require.Equal(t, "big-tenants", c.forTenant("globex"))
+	require.Equal(t, feesTaskQueue, c.forTenant(""))
+	require.Equal(t, []string{feesTaskQueue, "acme-fees", "big-tenants"}, c.Poll)
+
+	t.Setenv("FEES_WORKER_TASK_QUEUES", "acme-fees")
+	c, err = loadTaskQueueConfig()
+	require.NoError(t, err)
+	require.Equal(t, []string{"acme-fees"}, c.Poll)
+
+	for _, v := range []string{"acme", "acme=", "=acme-fees", "acme=a,acme=b"} {
+		t.Setenv("FEES_TENANT_TASK_QUEUES", v)
+		_, err := loadTaskQueueConfig()
+		require.Error(t, err, v)
+	}
+	t.Setenv("FEES_TENANT_TASK_QUEUES", "")
+	t.Setenv("FEES_WORKER_TASK_QUEUES", "acme-fees,,")
+	_, err = loadTaskQueueConfig()
+	require.Error(t, err)
+}
@@ -0,0 +1,154 @@
+package fees
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"go.temporal.io/sdk/client"
+)
+
+// TemporalConfig says how to reach the Temporal cluster and how to degrade while it
+// cannot be reached. The connection settings are used by the client, the worker, and
+// visibility queries such as ListBills.
+type TemporalConfig struct {
+	// HostPort is the frontend address, e.g. "my-ns.a1b2c.tmprl.cloud:7233".
+	HostPort string
+	// Namespace holds the fees workflows.
+	Namespace string
+
+	// TLS enables TLS to the frontend. It is implied by any of the settings below.
+	TLS bool
+	// CACertFile verifies the server with a private CA instead of the system roots.
+	CACertFile string
+	// ClientCertFile and ClientKeyFile authenticate the service with mTLS. They are
+	// set together.
+	ClientCertFile string
+	ClientKeyFile  string
+	// ServerName overrides the name the server certificate is verified against.
+	ServerName string
+	// APIKey authenticates with Temporal Cloud API key auth instead of mTLS.
+	APIKey string
+	// PayloadKeys encrypt the payloads the service stores in Temporal. The first key
+	// encrypts; the others still decrypt payloads written before a key rotation. None
+	// leaves payloads unencrypted.
+	PayloadKeys []EncryptionKey
+
+	// BreakerFailures is how many consecutive unavailability errors open the circuit
+	// breaker around Temporal calls; zero disables it. BreakerCooldown is how long it
+	// stays open before a probe call is let through.
+	BreakerFailures int
+	BreakerCooldown time.Duration
+	// Outbox queues CreateBill and AddLineItem requests that fail because Temporal is
+	// unavailable, replaying them every OutboxInterval once it is back.
+	Outbox         bool
+	OutboxInterval time.Duration
+}
+
+// loadTemporalConfig reads the Temporal connection settings from the environment.
+func loadTemporalConfig() (TemporalConfig, error) {
+	c := TemporalConfig{
+		HostPort:       os.Getenv("FEES_TEMPORAL_ADDRESS"),
+		Namespace:      os.Getenv("FEES_TEMPORAL_NAMESPACE"),
+		CACertFile:     os.Getenv("FEES_TEMPORAL_TLS_CA_FILE"),
+		ClientCertFile: os.Getenv("FEES_TEMPORAL_TLS_CERT_FILE"),
+		ClientKeyFile:  os.Getenv("FEES_TEMPORAL_TLS_KEY_FILE"),
+		ServerName:     os.Getenv("FEES_TEMPORAL_TLS_SERVER_NAME"),
+		APIKey:         os.Getenv("FEES_TEMPORAL_API_KEY"),
+
+		BreakerFailures: 5,
+		BreakerCooldown: 30 * time.Second,
+		OutboxInterval:  10 * time.Second,
+	}
+	if c.HostPort == "" {
+		c.HostPort = client.DefaultHostPort
+	}
+	if c.Namespace == "" {
+		c.Namespace = client.DefaultNamespace
+	}
+	if v := os.Getenv("FEES_TEMPORAL_TLS"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return TemporalConfig{}, fmt.Errorf("invalid FEES_TEMPORAL_TLS %q: %w", v, err)
+		}
+		c.TLS = b
+	}
+	if err := countFromEnv("FEES_TEMPORAL_BREAKER_FAILURES", &c.BreakerFailures); err != nil {
+		return TemporalConfig{}, err
+	}
+	if err := durationFromEnv("FEES_TEMPORAL_BREAKER_COOLDOWN", &c.BreakerCooldown); err != nil {
+		return TemporalConfig{}, err
+	}
+	if v := os.Getenv("FEES_TEMPORAL_OUTBOX"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return TemporalConfig{}, fmt.Errorf("invalid FEES_TEMPORAL_OUTBOX %q: %w", v, err)
+		}
+		c.Outbox = b
+	}
+	if err := durationFromEnv("FEES_TEMPORAL_OUTBOX_INTERVAL", &c.OutboxInterval); err != nil {
+		return TemporalConfig{}, err
+	}
+	if c.BreakerCooldown <= 0 || c.OutboxInterval <= 0 {
+		return TemporalConfig{}, fmt.Errorf("FEES_TEMPORAL_BREAKER_COOLDOWN and FEES_TEMPORAL_OUTBOX_INTERVAL must be positive")
+	}
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		return TemporalConfig{}, fmt.Errorf("FEES_TEMPORAL_TLS_CERT_FILE and FEES_TEMPORAL_TLS_KEY_FILE must be set together")
+	}
+	if c.ClientCertFile != "" && c.APIKey != "" {
+		return TemporalConfig{}, fmt.Errorf("FEES_TEMPORAL_API_KEY cannot be combined with a client certificate")
+	}
+	keys, err := loadEncryptionKeys("FEES_TEMPORAL_PAYLOAD_KEYS", "Temporal payload keys")
+	if err != nil {
+		return TemporalConfig{}, err
+	}
+	c.PayloadKeys = keys
+	if c.CACertFile != "" || c.ClientCertFile != "" || c.ServerName != "" || c.APIKey != "" {
+		c.TLS = true
+	}
+	return c, nil
+}
+
+// clientOptions returns the options for dialing Temporal, loading the configured
+// certificates and encrypting payloads with the configured keys.
+func (c TemporalConfig) clientOptions() (client.Options, error) {
+	opts := client.Options{HostPort: c.HostPort, Namespace: c.Namespace}
+	if len(c.PayloadKeys) > 0 {
+		codec, err := newPayloadCodec(c.PayloadKeys)
+		if err != nil {
+			return client.Options{}, err
+		}
+		opts.DataConverter, opts.FailureConverter = payloadConverters(codec)
+	}
+	if !c.TLS {
+		return opts, nil
+	}
+
+	tlsConfig := &tls.Config{ServerName: c.ServerName, MinVersion: tls.VersionTLS12}
+	if c.CACertFile != "" {
+		pem, err := os.ReadFile(c.CACertFile)
+		if err != nil {
+			return client.Options{}, fmt.Errorf("failed to read Temporal CA certificate: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return client.Options{}, fmt.Errorf("no certificates found in Temporal CA file %s", c.CACertFile)
+		}
+	}
+	if c.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCertFile, c.ClientKeyFile)
+		if err != nil {
+			return client.Options{}, fmt.Errorf("failed to load Temporal client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	opts.ConnectionOptions.TLS = tlsConfig
+
+	if c.APIKey != "" {
+		opts.Credentials = client.NewAPIKeyStaticCredentials(c.APIKey)
+	}
+	return opts, nil
+}
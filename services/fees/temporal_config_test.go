@@ -0,0 +1,52 @@
+package fees
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/client"
+)
+
+// TestLoadTemporalConfig tests that the Temporal connection settings default to a local
+// cluster and that Temporal Cloud settings enable TLS.
+func TestLoadTemporalConfig(t *testing.T) {
+	c, err := loadTemporalConfig()
+	require.NoError(t, err)
+	require.Equal(t, TemporalConfig{
+		HostPort:        client.DefaultHostPort,
+		Namespace:       client.DefaultNamespace,
+		BreakerFailures: 5,
+		BreakerCooldown: 30 * time.Second,
+		OutboxInterval:  10 * time.Second,
+	}, c)
+	opts, err := c.clientOptions()
+	require.NoError(t, err)
+	require.Nil(t, opts.ConnectionOptions.TLS)
+
+	t.Setenv("FEES_TEMPORAL_ADDRESS", "fees.a1b2c.tmprl.cloud:7233")
+	t.Setenv("FEES_TEMPORAL_NAMESPACE", "fees.a1b2c")
+	t.Setenv("FEES_TEMPORAL_API_KEY", "secret")
+	c, err = loadTemporalConfig()
+	require.NoError(t, err)
+	require.True(t, c.TLS)
+	opts, err = c.clientOptions()
+	require.NoError(t, err)
+	require.Equal(t, "fees.a1b2c.tmprl.cloud:7233", opts.HostPort)
+	require.Equal(t, "fees.a1b2c", opts.Namespace)
+	require.NotNil(t, opts.ConnectionOptions.TLS)
+	require.NotNil(t, opts.Credentials)
+
+	t.Setenv("FEES_TEMPORAL_TLS_CERT_FILE", "client.pem")
+	_, err = loadTemporalConfig()
+	require.ErrorContains(t, err, "must be set together")
+
+	t.Setenv("FEES_TEMPORAL_TLS_KEY_FILE", "client.key")
+	_, err = loadTemporalConfig()
+	require.ErrorContains(t, err, "cannot be combined")
+
+	c = TemporalConfig{TLS: true, CACertFile: filepath.Join(t.TempDir(), "missing.pem")}
+	_, err = c.clientOptions()
+	require.ErrorContains(t, err, "CA certificate")
+}
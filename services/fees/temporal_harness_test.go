@@ -0,0 +1,177 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/operatorservice/v1"
+	"go.temporal.io/api/serviceerror"
+	workflowv1 "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// testNamespacePrefix marks namespaces registered by the test harness. Workflows are
+// only ever terminated in such namespaces, so the tests cannot disturb a shared cluster.
+const testNamespacePrefix = "fees-test-"
+
+// TestMain registers a fresh Temporal namespace for the test run, unless
+// FEES_TEMPORAL_NAMESPACE names one, and deletes it afterwards. Tests that need no
+// Temporal server still run when none is reachable.
+func TestMain(m *testing.M) {
+	teardown := setupTestNamespace()
+	code := m.Run()
+	teardown()
+	os.Exit(code)
+}
+
+// setupTestNamespace registers a randomly named namespace on the configured Temporal
+// server and points FEES_TEMPORAL_NAMESPACE at it. It returns a func that deletes it.
+func setupTestNamespace() func() {
+	if os.Getenv("FEES_TEMPORAL_NAMESPACE") != "" {
+		return func() {}
+	}
+	cfg, err := loadTemporalConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "test harness: %v\n", err)
+		return func() {}
+	}
+	options, err := cfg.clientOptions()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "test harness: %v\n", err)
+		return func() {}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := client.DialContext(ctx, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "test harness: Temporal is not reachable at %s, integration tests will fail: %v\n", cfg.HostPort, err)
+		return func() {}
+	}
+
+	namespace := testNamespacePrefix + uuid.NewString()[:8]
+	if err := registerTestNamespace(c, namespace); err != nil {
+		fmt.Fprintf(os.Stderr, "test harness: %v\n", err)
+		c.Close()
+		return func() {}
+	}
+	os.Setenv("FEES_TEMPORAL_NAMESPACE", namespace)
+
+	return func() {
+		defer c.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if _, err := c.OperatorService().DeleteNamespace(ctx, &operatorservice.DeleteNamespaceRequest{Namespace: namespace}); err != nil {
+			fmt.Fprintf(os.Stderr, "test harness: failed to delete namespace %s: %v\n", namespace, err)
+		}
+	}
+}
+
+// registerTestNamespace registers namespace and waits until the server accepts calls
+// in it, which takes a moment after registration.
+func registerTestNamespace(c client.Client, namespace string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := c.WorkflowService().RegisterNamespace(ctx, &workflowservice.RegisterNamespaceRequest{
+		Namespace:                        namespace,
+		Description:                      "fees service test run",
+		WorkflowExecutionRetentionPeriod: durationpb.New(24 * time.Hour),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register namespace %s: %w", namespace, err)
+	}
+
+	for {
+		_, err := c.WorkflowService().ListWorkflowExecutions(ctx, &workflowservice.ListWorkflowExecutionsRequest{Namespace: namespace, PageSize: 1})
+		var notFound *serviceerror.NamespaceNotFound
+		if !errors.As(err, &notFound) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("namespace %s did not become available: %w", namespace, ctx.Err())
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// newTemporalService starts a Service against the test run's Temporal namespace for
+// an integration test. Its BillWorkflows are terminated and its worker stopped when
+// the test ends.
+func newTemporalService(t *testing.T) *Service {
+	t.Helper()
+	svc, err := initService()
+	require.NoError(t, err)
+	require.NotNil(t, svc)
+
+	t.Cleanup(func() {
+		terminateBillWorkflows(t, svc)
+		stopWorkers(svc.temporalWorkers)
+		svc.temporalClient.Close()
+	})
+	return svc
+}
+
+// terminateBillWorkflows terminates the running BillWorkflows in svc's namespace. It
+// refuses to touch a namespace the test harness did not register.
+func terminateBillWorkflows(t *testing.T, svc *Service) {
+	t.Helper()
+	namespace := svc.cfg.Temporal.Namespace
+	if !strings.HasPrefix(namespace, testNamespacePrefix) {
+		t.Logf("Not terminating workflows in namespace %s, which the test harness did not register.", namespace)
+		return
+	}
+
+	running := func(ctx context.Context) ([]*workflowv1.WorkflowExecutionInfo, error) {
+		var executions []*workflowv1.WorkflowExecutionInfo
+		var nextPageToken []byte
+		for {
+			resp, err := svc.temporalClient.WorkflowService().ListWorkflowExecutions(ctx, &workflowservice.ListWorkflowExecutionsRequest{
+				Namespace:     namespace,
+				Query:         fmt.Sprintf("WorkflowType = '%s' AND ExecutionStatus = '%s'", "BillWorkflow", enums.WORKFLOW_EXECUTION_STATUS_RUNNING.String()),
+				NextPageToken: nextPageToken,
+			})
+			if err != nil {
+				return nil, err
+			}
+			executions = append(executions, resp.GetExecutions()...)
+			if nextPageToken = resp.GetNextPageToken(); len(nextPageToken) == 0 {
+				return executions, nil
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	executions, err := running(ctx)
+	if err != nil {
+		t.Logf("Warning: Failed to list running BillWorkflows for cleanup: %v", err)
+		return
+	}
+	for _, info := range executions {
+		exec := info.GetExecution()
+		if err := svc.temporalClient.TerminateWorkflow(ctx, exec.GetWorkflowId(), exec.GetRunId(), "test cleanup"); err != nil {
+			t.Logf("Warning: Failed to terminate workflow %s run %s: %v", exec.GetWorkflowId(), exec.GetRunId(), err)
+		}
+	}
+	if len(executions) == 0 {
+		return
+	}
+
+	require.Eventually(t, func() bool {
+		executions, err := running(ctx)
+		return err == nil && len(executions) == 0
+	}, 15*time.Second, 500*time.Millisecond, "BillWorkflows did not terminate in time after cleanup.")
+	t.Logf("Terminated %d running BillWorkflows in namespace %s.", len(executions), namespace)
+}
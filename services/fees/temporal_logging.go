@@ -0,0 +1,166 @@
+package fees
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// ActivityPanicErrorType is the application error type of an activity attempt that
+// panicked. It stays retryable, like the SDK's own panic error.
+const ActivityPanicErrorType = "ActivityPanic"
+
+// billIDFromWorkflowID returns the bill ID of a BillWorkflow ID, or "" for other workflows.
+func billIDFromWorkflowID(workflowID string) string {
+	if id, ok := strings.CutPrefix(workflowID, testBillWorkflowIDPrefix); ok {
+		return testBillIDPrefix + id
+	}
+	billID, ok := strings.CutPrefix(workflowID, billWorkflowID(""))
+	if !ok {
+		return ""
+	}
+	return billID
+}
+
+// ------ Client side: log and time starts, signals, and queries ------
+
+// clientLoggingInterceptor logs every workflow start, signal, and query sent through
+// the Temporal client, with the bill it concerns, and times them.
+type clientLoggingInterceptor struct {
+	interceptor.ClientInterceptorBase
+	metrics *serviceMetrics
+}
+
+func (i *clientLoggingInterceptor) InterceptClient(next interceptor.ClientOutboundInterceptor) interceptor.ClientOutboundInterceptor {
+	return &clientLoggingOutbound{
+		ClientOutboundInterceptorBase: interceptor.ClientOutboundInterceptorBase{Next: next},
+		metrics:                       i.metrics,
+	}
+}
+
+type clientLoggingOutbound struct {
+	interceptor.ClientOutboundInterceptorBase
+	metrics *serviceMetrics
+}
+
+func (o *clientLoggingOutbound) ExecuteWorkflow(ctx context.Context, in *interceptor.ClientExecuteWorkflowInput) (client.WorkflowRun, error) {
+	start := time.Now()
+	run, err := o.Next.ExecuteWorkflow(ctx, in)
+	o.observe(ctx, "start", in.WorkflowType, in.Options.ID, start, err)
+	return run, err
+}
+
+func (o *clientLoggingOutbound) SignalWorkflow(ctx context.Context, in *interceptor.ClientSignalWorkflowInput) error {
+	start := time.Now()
+	err := o.Next.SignalWorkflow(ctx, in)
+	o.observe(ctx, "signal", in.SignalName, in.WorkflowID, start, err)
+	return err
+}
+
+func (o *clientLoggingOutbound) SignalWithStartWorkflow(ctx context.Context, in *interceptor.ClientSignalWithStartWorkflowInput) (client.WorkflowRun, error) {
+	start := time.Now()
+	run, err := o.Next.SignalWithStartWorkflow(ctx, in)
+	o.observe(ctx, "signal_with_start", in.SignalName, in.Options.ID, start, err)
+	return run, err
+}
+
+func (o *clientLoggingOutbound) QueryWorkflow(ctx context.Context, in *interceptor.ClientQueryWorkflowInput) (converter.EncodedValue, error) {
+	start := time.Now()
+	value, err := o.Next.QueryWorkflow(ctx, in)
+	o.observe(ctx, "query", in.QueryType, in.WorkflowID, start, err)
+	return value, err
+}
+
+// observe logs and times one client call. Failures are logged at warn level; the
+// caller decides whether they matter.
+func (o *clientLoggingOutbound) observe(ctx context.Context, operation, name, workflowID string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	o.metrics.temporalCalls.observe(elapsed, operation, name)
+	attrs := []any{"operation", operation, "name", name, "workflow_id", workflowID, "bill_id", billIDFromWorkflowID(workflowID), "duration", elapsed}
+	if err != nil {
+		slog.WarnContext(ctx, "Temporal call failed", append(attrs, "error", err)...)
+		return
+	}
+	slog.DebugContext(ctx, "Temporal call", attrs...)
+}
+
+// ------ Worker side: log signals, queries, and activities; recover panics ------
+
+// workerLoggingInterceptor logs every signal and query a workflow handles and every
+// activity attempt, with the bill they concern. Workflows and activities that panic
+// fail with an error instead, so a bad input cannot wedge a worker.
+type workerLoggingInterceptor struct {
+	interceptor.WorkerInterceptorBase
+}
+
+func (*workerLoggingInterceptor) InterceptWorkflow(ctx workflow.Context, next interceptor.WorkflowInboundInterceptor) interceptor.WorkflowInboundInterceptor {
+	return &workflowLoggingInbound{WorkflowInboundInterceptorBase: interceptor.WorkflowInboundInterceptorBase{Next: next}}
+}
+
+func (*workerLoggingInterceptor) InterceptActivity(ctx context.Context, next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	return &activityLoggingInbound{ActivityInboundInterceptorBase: interceptor.ActivityInboundInterceptorBase{Next: next}}
+}
+
+type workflowLoggingInbound struct {
+	interceptor.WorkflowInboundInterceptorBase
+}
+
+// ExecuteWorkflow fails a workflow that panics rather than leaving its task to retry
+// forever, which is the SDK's default.
+func (i *workflowLoggingInbound) ExecuteWorkflow(ctx workflow.Context, in *interceptor.ExecuteWorkflowInput) (result any, err error) {
+	info := workflow.GetInfo(ctx)
+	defer func() {
+		if r := recover(); r != nil {
+			workflow.GetLogger(ctx).Error("Workflow panicked", "workflow_type", info.WorkflowType.Name, "bill_id", billIDFromWorkflowID(info.WorkflowExecution.ID), "panic", r, "stack", string(debug.Stack()))
+			result, err = nil, fmt.Errorf("workflow %s panicked: %v", info.WorkflowType.Name, r)
+		}
+	}()
+	return i.Next.ExecuteWorkflow(ctx, in)
+}
+
+// HandleSignal logs through the workflow logger, which skips replayed signals.
+func (i *workflowLoggingInbound) HandleSignal(ctx workflow.Context, in *interceptor.HandleSignalInput) error {
+	workflow.GetLogger(ctx).Debug("Signal received", "signal", in.SignalName, "bill_id", billIDFromWorkflowID(workflow.GetInfo(ctx).WorkflowExecution.ID))
+	return i.Next.HandleSignal(ctx, in)
+}
+
+func (i *workflowLoggingInbound) HandleQuery(ctx workflow.Context, in *interceptor.HandleQueryInput) (any, error) {
+	result, err := i.Next.HandleQuery(ctx, in)
+	if err != nil {
+		workflow.GetLogger(ctx).Warn("Query failed", "query", in.QueryType, "bill_id", billIDFromWorkflowID(workflow.GetInfo(ctx).WorkflowExecution.ID), "error", err)
+	}
+	return result, err
+}
+
+type activityLoggingInbound struct {
+	interceptor.ActivityInboundInterceptorBase
+}
+
+func (i *activityLoggingInbound) ExecuteActivity(ctx context.Context, in *interceptor.ExecuteActivityInput) (result any, err error) {
+	info := activity.GetInfo(ctx)
+	logger := activity.GetLogger(ctx)
+	billID := billIDFromWorkflowID(info.WorkflowExecution.ID)
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("Activity panicked", "activity", info.ActivityType.Name, "bill_id", billID, "attempt", info.Attempt, "panic", r, "stack", string(debug.Stack()))
+			result, err = nil, temporal.NewApplicationError(fmt.Sprintf("activity %s panicked: %v", info.ActivityType.Name, r), ActivityPanicErrorType)
+		}
+		if err != nil {
+			logger.Warn("Activity failed", "activity", info.ActivityType.Name, "bill_id", billID, "attempt", info.Attempt, "duration", time.Since(start), "error", err)
+			return
+		}
+		logger.Debug("Activity completed", "activity", info.ActivityType.Name, "bill_id", billID, "attempt", info.Attempt, "duration", time.Since(start))
+	}()
+	return i.Next.ExecuteActivity(ctx, in)
+}
@@ -0,0 +1,83 @@
+package fees
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+)
+
+// TestWorkerLoggingInterceptor_Panics tests that panicking activities and workflows fail
+// with an error instead of crashing or blocking the worker.
+func TestWorkerLoggingInterceptor_Panics(t *testing.T) {
+	panickingActivity := func(ctx context.Context) error { panic("boom") }
+	callsActivity := func(ctx workflow.Context) error {
+		ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+			StartToCloseTimeout: time.Second,
+			RetryPolicy:         &temporal.RetryPolicy{MaximumAttempts: 1},
+		})
+		return workflow.ExecuteActivity(ctx, "PanickingActivity").Get(ctx, nil)
+	}
+	panickingWorkflow := func(ctx workflow.Context) error { panic("bad state") }
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.SetWorkerOptions(worker.Options{Interceptors: []interceptor.WorkerInterceptor{&workerLoggingInterceptor{}}})
+	env.RegisterActivityWithOptions(panickingActivity, activity.RegisterOptions{Name: "PanickingActivity"})
+	env.RegisterWorkflowWithOptions(callsActivity, workflow.RegisterOptions{Name: "CallsActivity"})
+	env.ExecuteWorkflow("CallsActivity")
+	require.True(t, env.IsWorkflowCompleted())
+	var appErr *temporal.ApplicationError
+	require.ErrorAs(t, env.GetWorkflowError(), &appErr)
+	require.Equal(t, ActivityPanicErrorType, appErr.Type())
+	require.Contains(t, appErr.Error(), "activity PanickingActivity panicked: boom")
+
+	env = suite.NewTestWorkflowEnvironment()
+	env.SetWorkerOptions(worker.Options{Interceptors: []interceptor.WorkerInterceptor{&workerLoggingInterceptor{}}})
+	env.RegisterWorkflowWithOptions(panickingWorkflow, workflow.RegisterOptions{Name: "PanickingWorkflow"})
+	env.ExecuteWorkflow("PanickingWorkflow")
+	require.True(t, env.IsWorkflowCompleted())
+	require.ErrorContains(t, env.GetWorkflowError(), "workflow PanickingWorkflow panicked: bad state")
+}
+
+// signalStub is the next client interceptor in TestClientLoggingInterceptor.
+type signalStub struct {
+	interceptor.ClientOutboundInterceptor
+	err error
+}
+
+func (s signalStub) SignalWorkflow(context.Context, *interceptor.ClientSignalWorkflowInput) error {
+	return s.err
+}
+
+// TestClientLoggingInterceptor tests that client calls are timed per operation and name,
+// whether or not they fail.
+func TestClientLoggingInterceptor(t *testing.T) {
+	metrics := newServiceMetrics()
+	i := &clientLoggingInterceptor{metrics: metrics}
+	in := &interceptor.ClientSignalWorkflowInput{WorkflowID: billWorkflowID("b1"), SignalName: CloseBillSignalName}
+
+	require.NoError(t, i.InterceptClient(signalStub{}).SignalWorkflow(context.Background(), in))
+	require.Error(t, i.InterceptClient(signalStub{err: errors.New("unavailable")}).SignalWorkflow(context.Background(), in))
+
+	var out bytes.Buffer
+	metrics.temporalCalls.write(&out)
+	require.Contains(t, out.String(), `fees_temporal_client_call_duration_seconds_count{operation="signal",name="CloseBillSignal"} 2`)
+}
+
+// TestBillIDFromWorkflowID tests that only BillWorkflow IDs yield a bill ID.
+func TestBillIDFromWorkflowID(t *testing.T) {
+	require.Equal(t, "b1", billIDFromWorkflowID(billWorkflowID("b1")))
+	require.Equal(t, "test_b1", billIDFromWorkflowID(billWorkflowID("test_b1")))
+	require.Equal(t, "", billIDFromWorkflowID(subscriptionWorkflowID("s1")))
+	require.Equal(t, "", billIDFromWorkflowID(LineItemRepairWorkflowID))
+}
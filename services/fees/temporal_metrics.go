@@ -0,0 +1,185 @@
+package fees
+
+import (
+	"context"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// signalSentAtHeader carries the time, in Unix nanoseconds, at which a signal was
+	// accepted by the Temporal client.
+	signalSentAtHeader = "fees-signal-sent-at"
+	// signalLatencyMetric is the Temporal timer recording how long a signal waited
+	// between SignalWorkflow and the workflow applying it.
+	signalLatencyMetric = "fees_signal_to_apply_latency"
+)
+
+// ------ Client side: stamp signals, count failures ------
+
+// clientMetricsInterceptor stamps every signal sent through the Temporal client with its
+// send time, and counts signals and queries that fail.
+type clientMetricsInterceptor struct {
+	interceptor.ClientInterceptorBase
+	metrics *serviceMetrics
+}
+
+func (i *clientMetricsInterceptor) InterceptClient(next interceptor.ClientOutboundInterceptor) interceptor.ClientOutboundInterceptor {
+	return &clientMetricsOutbound{
+		ClientOutboundInterceptorBase: interceptor.ClientOutboundInterceptorBase{Next: next},
+		metrics:                       i.metrics,
+	}
+}
+
+type clientMetricsOutbound struct {
+	interceptor.ClientOutboundInterceptorBase
+	metrics *serviceMetrics
+}
+
+func (o *clientMetricsOutbound) SignalWorkflow(ctx context.Context, in *interceptor.ClientSignalWorkflowInput) error {
+	stampSignal(ctx, time.Now())
+	err := o.Next.SignalWorkflow(ctx, in)
+	if err != nil {
+		o.metrics.signalFailures.inc(in.SignalName)
+	}
+	return err
+}
+
+func (o *clientMetricsOutbound) SignalWithStartWorkflow(ctx context.Context, in *interceptor.ClientSignalWithStartWorkflowInput) (client.WorkflowRun, error) {
+	stampSignal(ctx, time.Now())
+	run, err := o.Next.SignalWithStartWorkflow(ctx, in)
+	if err != nil {
+		o.metrics.signalFailures.inc(in.SignalName)
+	}
+	return run, err
+}
+
+func (o *clientMetricsOutbound) QueryWorkflow(ctx context.Context, in *interceptor.ClientQueryWorkflowInput) (converter.EncodedValue, error) {
+	value, err := o.Next.QueryWorkflow(ctx, in)
+	if err != nil {
+		o.metrics.queryFailures.inc(in.QueryType)
+	}
+	return value, err
+}
+
+// stampSignal writes sentAt into the outgoing Temporal header. A failure only costs the
+// measurement, so it never fails the signal.
+func stampSignal(ctx context.Context, sentAt time.Time) {
+	header := interceptor.Header(ctx)
+	if header == nil {
+		return
+	}
+	payload, err := converter.GetDefaultDataConverter().ToPayload(sentAt.UnixNano())
+	if err != nil {
+		return
+	}
+	header[signalSentAtHeader] = payload
+}
+
+// ------ Worker side: signal latency, activity outcomes ------
+
+// workerMetricsInterceptor records, for every stamped signal a workflow receives, the
+// delay since it was sent, and counts activity attempts by outcome.
+type workerMetricsInterceptor struct {
+	interceptor.WorkerInterceptorBase
+	metrics *serviceMetrics
+}
+
+func (*workerMetricsInterceptor) InterceptWorkflow(ctx workflow.Context, next interceptor.WorkflowInboundInterceptor) interceptor.WorkflowInboundInterceptor {
+	return &signalLatencyInbound{WorkflowInboundInterceptorBase: interceptor.WorkflowInboundInterceptorBase{Next: next}}
+}
+
+func (i *workerMetricsInterceptor) InterceptActivity(ctx context.Context, next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	return &activityMetricsInbound{
+		ActivityInboundInterceptorBase: interceptor.ActivityInboundInterceptorBase{Next: next},
+		metrics:                        i.metrics,
+	}
+}
+
+type signalLatencyInbound struct {
+	interceptor.WorkflowInboundInterceptorBase
+}
+
+// HandleSignal measures against workflow.Now, the start of the workflow task delivering
+// the signal, so the latency covers time spent queued for a busy worker. The SDK's
+// metrics handler skips the recording during replay.
+func (i *signalLatencyInbound) HandleSignal(ctx workflow.Context, in *interceptor.HandleSignalInput) error {
+	if payload, ok := interceptor.WorkflowHeader(ctx)[signalSentAtHeader]; ok {
+		var sentAtNanos int64
+		if err := converter.GetDefaultDataConverter().FromPayload(payload, &sentAtNanos); err == nil {
+			latency := max(workflow.Now(ctx).Sub(time.Unix(0, sentAtNanos)), 0)
+			workflow.GetMetricsHandler(ctx).
+				WithTags(map[string]string{"signal": in.SignalName}).
+				Timer(signalLatencyMetric).
+				Record(latency)
+		}
+	}
+	return i.Next.HandleSignal(ctx, in)
+}
+
+type activityMetricsInbound struct {
+	interceptor.ActivityInboundInterceptorBase
+	metrics *serviceMetrics
+}
+
+// ExecuteActivity counts and times every attempt, so retried failures show up in the
+// error rate.
+func (i *activityMetricsInbound) ExecuteActivity(ctx context.Context, in *interceptor.ExecuteActivityInput) (any, error) {
+	start := time.Now()
+	result, err := i.Next.ExecuteActivity(ctx, in)
+	name := activity.GetInfo(ctx).ActivityType.Name
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	i.metrics.activities.inc(name, outcome)
+	i.metrics.activityTime.observe(time.Since(start), name)
+	return result, err
+}
+
+// ------ Temporal metrics handler ------
+
+// temporalMetricsHandler is the Temporal client's metrics handler. It feeds the
+// signal-to-apply timer into the service metrics and discards the SDK's own metrics.
+type temporalMetricsHandler struct {
+	metrics *serviceMetrics
+	tags    map[string]string
+}
+
+func (m *temporalMetricsHandler) WithTags(tags map[string]string) client.MetricsHandler {
+	merged := make(map[string]string, len(m.tags)+len(tags))
+	for k, v := range m.tags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return &temporalMetricsHandler{metrics: m.metrics, tags: merged}
+}
+
+func (m *temporalMetricsHandler) Counter(name string) client.MetricsCounter {
+	return client.MetricsNopHandler.Counter(name)
+}
+
+func (m *temporalMetricsHandler) Gauge(name string) client.MetricsGauge {
+	return client.MetricsNopHandler.Gauge(name)
+}
+
+func (m *temporalMetricsHandler) Timer(name string) client.MetricsTimer {
+	if name != signalLatencyMetric {
+		return client.MetricsNopHandler.Timer(name)
+	}
+	return signalLatencyTimer{hist: m.metrics.signalLatency, signal: m.tags["signal"]}
+}
+
+type signalLatencyTimer struct {
+	hist   *histogramVec
+	signal string
+}
+
+func (t signalLatencyTimer) Record(d time.Duration) { t.hist.observe(d, t.signal) }
@@ -0,0 +1,33 @@
+package fees
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestTemporalMetricsHandler tests that only the signal-to-apply timer reaches the histograms, per signal.
+func TestTemporalMetricsHandler(t *testing.T) {
+	metrics := newServiceMetrics()
+	handler := &temporalMetricsHandler{metrics: metrics}
+
+	handler.WithTags(map[string]string{"signal": AddLineItemSignalName}).Timer(signalLatencyMetric).Record(80 * time.Millisecond)
+	handler.WithTags(map[string]string{"signal": AddLineItemSignalName}).Timer(signalLatencyMetric).Record(3 * time.Second)
+	handler.WithTags(map[string]string{"signal": CloseBillSignalName}).Timer(signalLatencyMetric).Record(10 * time.Minute)
+	handler.Timer("temporal_workflow_task_execution_latency").Record(time.Second)
+
+	var out bytes.Buffer
+	metrics.signalLatency.write(&out)
+	text := out.String()
+
+	require.Contains(t, text, "# TYPE fees_signal_to_apply_latency_seconds histogram\n")
+	require.Contains(t, text, `fees_signal_to_apply_latency_seconds_bucket{signal="AddLineItemSignal",le="0.05"} 0`)
+	require.Contains(t, text, `fees_signal_to_apply_latency_seconds_bucket{signal="AddLineItemSignal",le="0.1"} 1`)
+	require.Contains(t, text, `fees_signal_to_apply_latency_seconds_bucket{signal="AddLineItemSignal",le="5"} 2`)
+	require.Contains(t, text, `fees_signal_to_apply_latency_seconds_sum{signal="AddLineItemSignal"} 3.08`)
+	require.Contains(t, text, `fees_signal_to_apply_latency_seconds_bucket{signal="CloseBillSignal",le="300"} 0`)
+	require.Contains(t, text, `fees_signal_to_apply_latency_seconds_bucket{signal="CloseBillSignal",le="+Inf"} 1`)
+	require.NotContains(t, text, `signal=""`)
+}
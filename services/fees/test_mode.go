@@ -0,0 +1,193 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"encore.app/apierr"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// testBillIDPrefix starts the ID of every test bill, so a bill's mode is known from
+	// its ID alone.
+	testBillIDPrefix = "test_"
+	// testBillWorkflowIDPrefix starts the workflow ID of every test bill, in place of
+	// "bill-".
+	testBillWorkflowIDPrefix = "test-bill-"
+
+	// PurgeTestBillsActivityName deletes one batch of test bills.
+	PurgeTestBillsActivityName = "PurgeTestBillsActivity"
+)
+
+// isTestBill reports whether billID is a test bill's.
+func isTestBill(billID string) bool {
+	return strings.HasPrefix(billID, testBillIDPrefix)
+}
+
+// callerTestMode reports whether the request authenticated with a test mode API key.
+func callerTestMode(ctx context.Context) bool {
+	data := caller(ctx)
+	return data != nil && data.Test
+}
+
+// billModes says which bills a listing or report covers: live bills, test bills, or
+// both.
+type billModes struct {
+	Live bool
+	Test bool
+}
+
+// callerBillModes returns the bills the caller's listings and reports cover. Test
+// mode API keys only ever see test bills; other callers see live bills, and test bills
+// too if includeTest.
+func callerBillModes(ctx context.Context, includeTest bool) billModes {
+	if callerTestMode(ctx) {
+		return billModes{Test: true}
+	}
+	return billModes{Live: true, Test: includeTest}
+}
+
+// covers reports whether m covers the bill with ID billID.
+func (m billModes) covers(billID string) bool {
+	if isTestBill(billID) {
+		return m.Test
+	}
+	return m.Live
+}
+
+// ------ Purge ------
+
+// PurgeTestBillsRequest selects the test bills to purge.
+type PurgeTestBillsRequest struct {
+	// TenantID limits the purge to one tenant's test bills.
+	TenantID string `json:"tenantId,omitempty"`
+	// CreatedBefore limits the purge to test bills created before it. Defaults to now.
+	CreatedBefore *time.Time `json:"createdBefore,omitempty"`
+}
+
+// PurgeTestBillsResponse names the workflow purging the test bills.
+type PurgeTestBillsResponse struct {
+	WorkflowID string `json:"workflowId"`
+	RunID      string `json:"runId"`
+}
+
+// TestBillPurgeParams configures a TestBillPurgeWorkflow run.
+type TestBillPurgeParams struct {
+	TenantID      string
+	CreatedBefore time.Time
+}
+
+// TestBillPurgeResult counts what a TestBillPurgeWorkflow run deleted.
+type TestBillPurgeResult struct {
+	Bills     int `json:"bills"`
+	LineItems int `json:"lineItems"`
+	Workflows int `json:"workflows"`
+}
+
+// PurgeTestBillsActivityParams defines parameters for PurgeTestBillsActivity.
+type PurgeTestBillsActivityParams struct {
+	TenantID      string
+	CreatedBefore time.Time
+	Limit         int
+}
+
+// TestBillPurgeWorkflow deletes test bills created before params.CreatedBefore, with
+// their line items and workflows, in batches. It reuses the retention sweep's batches,
+// so deleted bills are gone for good.
+func TestBillPurgeWorkflow(ctx workflow.Context, params TestBillPurgeParams) (*TestBillPurgeResult, error) {
+	logger := workflow.GetLogger(ctx)
+
+	result := &TestBillPurgeResult{}
+	for {
+		var batch PurgeExpiredBillsActivityResult
+		err := workflow.ExecuteActivity(ctx, PurgeTestBillsActivityName, PurgeTestBillsActivityParams{
+			TenantID:      params.TenantID,
+			CreatedBefore: params.CreatedBefore,
+			Limit:         erasureBatchSize,
+		}).Get(ctx, &batch)
+		if err != nil {
+			logger.Error("Failed to execute PurgeTestBillsActivity", "tenant_id", params.TenantID, "error", err)
+			return result, err
+		}
+		result.Bills += batch.Found
+		result.LineItems += batch.LineItems
+		result.Workflows += batch.Workflows
+		if batch.Found < erasureBatchSize {
+			break
+		}
+	}
+	logger.Info("Test bill purge finished", "tenant_id", params.TenantID, "created_before", params.CreatedBefore, "bills", result.Bills, "line_items", result.LineItems, "workflows", result.Workflows)
+	return result, nil
+}
+
+// PurgeTestBillsActivity deletes up to params.Limit test bills created before
+// params.CreatedBefore, of params.TenantID if set. Their workflows are deleted too,
+// terminating test bills still open. Deleted bills no longer match, so every batch
+// starts from the lowest bill ID.
+func (a *Activities) PurgeTestBillsActivity(ctx context.Context, params PurgeTestBillsActivityParams) (*PurgeExpiredBillsActivityResult, error) {
+	defer keepAlive(ctx)()
+	ids, err := queryBillIDs(ctx, a.DB, `
+        SELECT id FROM bills
+        WHERE test AND created_at < $1 AND ($2 = '' OR tenant_id = $2)
+        ORDER BY id
+        LIMIT $3
+    `, params.CreatedBefore, params.TenantID, params.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("PurgeTestBillsActivity: failed to find test bills created before %s: %w", params.CreatedBefore, err)
+	}
+	if len(ids) == 0 {
+		return &PurgeExpiredBillsActivityResult{}, nil
+	}
+	e := &eraser{db: a.DB, temporal: a.Temporal, namespace: a.Namespace}
+	erased, err := e.erase(ctx, ids, ErasureDelete, "")
+	if err != nil {
+		return nil, fmt.Errorf("PurgeTestBillsActivity: failed to delete test bills created before %s: %w", params.CreatedBefore, err)
+	}
+	return &PurgeExpiredBillsActivityResult{Found: len(ids), LineItems: erased.LineItems, Workflows: erased.Workflows}, nil
+}
+
+// testBillPurgeWorkflowID names the workflow purging a tenant's test bills, or every
+// tenant's if tenantID is empty, so concurrent purges of the same bills are one run.
+func testBillPurgeWorkflowID(tenantID string) string {
+	if tenantID == "" {
+		return "purge-test-bills"
+	}
+	return "purge-test-bills-" + tenantID
+}
+
+// PurgeTestBills deletes test bills, with their line items and workflows, so
+// integrators' test runs leave nothing behind. It returns at once; the returned
+// workflow reports what it deleted. A purge of the same tenant already running is
+// returned instead of starting another.
+// It is private so it can only be called by internal admin tooling.
+//
+// encore:api private method=POST path=/admin/test-bills/purge
+func (s *Service) PurgeTestBills(ctx context.Context, req *PurgeTestBillsRequest) (*PurgeTestBillsResponse, error) {
+	createdBefore := s.clock.Now().UTC()
+	if req.CreatedBefore != nil {
+		if req.CreatedBefore.After(createdBefore) {
+			return nil, apierr.InvalidArgument(apierr.InvalidParameter, "createdBefore %s must not be in the future", req.CreatedBefore.Format(time.RFC3339))
+		}
+		createdBefore = *req.CreatedBefore
+	}
+	workflowID := testBillPurgeWorkflowID(req.TenantID)
+	run, err := s.temporalClient.ExecuteWorkflow(ctx, client.StartWorkflowOptions{
+		ID:        workflowID,
+		TaskQueue: feesTaskQueue,
+	}, TestBillPurgeWorkflow, TestBillPurgeParams{TenantID: req.TenantID, CreatedBefore: createdBefore})
+	var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+	if errors.As(err, &alreadyStarted) {
+		return &PurgeTestBillsResponse{WorkflowID: workflowID, RunID: alreadyStarted.RunId}, nil
+	}
+	if err != nil {
+		return nil, apierr.FromTemporal(err, apierr.Internal, "failed to start test bill purge")
+	}
+	loggerFrom(ctx).Info("Test bill purge started", "tenant_id", req.TenantID, "created_before", createdBefore, "run_id", run.GetRunID())
+	return &PurgeTestBillsResponse{WorkflowID: workflowID, RunID: run.GetRunID()}, nil
+}
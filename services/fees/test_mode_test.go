@@ -0,0 +1,41 @@
+package fees
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestTestBillIDs tests that test bills run under their own workflow ID prefix, and
+// that their follow-up bills are test bills too.
+func TestTestBillIDs(t *testing.T) {
+	require.True(t, isTestBill("test_9b2c"))
+	require.False(t, isTestBill("9b2c"))
+	require.Equal(t, "test-bill-9b2c", billWorkflowID("test_9b2c"))
+	require.Equal(t, "bill-9b2c", billWorkflowID("9b2c"))
+
+	require.True(t, isTestBill(nextBillID("test_9b2c")))
+	require.False(t, isTestBill(nextBillID("9b2c")))
+	require.NotEqual(t, nextBillID("9b2c"), nextBillID("test_9b2c"))
+}
+
+// TestCallerBillModes tests that live bills are listed unless test bills are asked for,
+// and that test mode keys only ever see test bills.
+func TestCallerBillModes(t *testing.T) {
+	live := withCaller(context.Background(), &AuthData{KeyID: "key-1", TenantID: "acme"})
+	require.Equal(t, billModes{Live: true}, callerBillModes(live, false))
+	require.Equal(t, billModes{Live: true, Test: true}, callerBillModes(live, true))
+	require.Equal(t, billModes{Live: true}, callerBillModes(context.Background(), false))
+
+	test := withCaller(context.Background(), &AuthData{KeyID: "key-2", TenantID: "acme", Test: true})
+	require.True(t, callerTestMode(test))
+	require.Equal(t, billModes{Test: true}, callerBillModes(test, false))
+	require.Equal(t, billModes{Test: true}, callerBillModes(test, true))
+
+	modes := callerBillModes(test, true)
+	require.True(t, modes.covers("test_9b2c"))
+	require.False(t, modes.covers("9b2c"))
+	require.True(t, callerBillModes(live, false).covers("9b2c"))
+	require.False(t, callerBillModes(live, false).covers("test_9b2c"))
+}
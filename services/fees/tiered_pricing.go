@@ -0,0 +1,229 @@
+package fees
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+
+	"encore.app/apierr"
+)
+
+const (
+	// maxUsagePrices caps the metrics a bill or plan prices.
+	maxUsagePrices = 50
+	// maxPriceTiers caps the tiers of one metric's price.
+	maxPriceTiers = 20
+	// maxMetricLength caps the length of a metric's name, such as "api_calls".
+	maxMetricLength = 64
+)
+
+// metricPattern is the form of a metric's name, such as "api_calls" or "storage.gb".
+var metricPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// ReasonCodeUsageRepricing is the adjustment lowering the amount billed for a metric
+// when its usage reaches a cheaper volume tier. It is reserved, like the reason codes
+// in line_item_reasons.go.
+const ReasonCodeUsageRepricing = "usage_repricing"
+
+// TierMode says how a metric's tiers price its usage.
+type TierMode string
+
+const (
+	// TierModeGraduated prices each unit at the tier it falls in: the first 1,000 units
+	// at the first tier's price, the next 10,000 at the second's, and so on.
+	TierModeGraduated TierMode = "graduated"
+	// TierModeVolume prices every unit at the tier the total usage falls in, so 1,001
+	// units are all priced at the second tier's price.
+	TierModeVolume TierMode = "volume"
+)
+
+// IsValid reports whether m is a known tier mode.
+func (m TierMode) IsValid() bool {
+	return m == TierModeGraduated || m == TierModeVolume
+}
+
+// PriceTier is one tier of a metric's price.
+type PriceTier struct {
+	// UpTo is the usage the tier ends at, counted from zero and inclusive: tiers of
+	// 1000 and 11000 price the first 1,000 units and the next 10,000. The last tier
+	// leaves it 0 and has no end.
+	UpTo      float64 `json:"upTo,omitempty"`
+	UnitPrice float64 `json:"unitPrice"`
+}
+
+// UsagePrice prices the usage of one metric, such as API calls, in tiers.
+type UsagePrice struct {
+	Metric string `json:"metric"`
+	// Mode is graduated (the default) or volume.
+	Mode  TierMode    `json:"mode,omitempty"`
+	Tiers []PriceTier `json:"tiers"`
+}
+
+// normalize defaults the price's mode and rejects prices that could not rate usage.
+func (p *UsagePrice) normalize() error {
+	if p.Metric == "" {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "usage price metric is required")
+	}
+	if len(p.Metric) > maxMetricLength || !metricPattern.MatchString(p.Metric) {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "invalid metric %q: must be at most %d lowercase letters, digits, dots, dashes and underscores", p.Metric, maxMetricLength)
+	}
+	if p.Mode == "" {
+		p.Mode = TierModeGraduated
+	}
+	if !p.Mode.IsValid() {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "invalid mode %q of metric %s: must be graduated or volume", p.Mode, p.Metric)
+	}
+	if len(p.Tiers) == 0 || len(p.Tiers) > maxPriceTiers {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "metric %s must have between 1 and %d tiers", p.Metric, maxPriceTiers)
+	}
+	var last float64
+	for i, tier := range p.Tiers {
+		if math.IsNaN(tier.UnitPrice) || math.IsInf(tier.UnitPrice, 0) || tier.UnitPrice < 0 {
+			return apierr.InvalidArgument(apierr.InvalidAmount, "unitPrice of tier %d of metric %s must be zero or positive, got %v", i+1, p.Metric, tier.UnitPrice)
+		}
+		if i == len(p.Tiers)-1 {
+			if tier.UpTo != 0 {
+				return apierr.InvalidArgument(apierr.InvalidParameter, "the last tier of metric %s must leave upTo unset, so all usage is priced", p.Metric)
+			}
+			break
+		}
+		if math.IsNaN(tier.UpTo) || math.IsInf(tier.UpTo, 0) || tier.UpTo <= last {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "upTo of tier %d of metric %s must be above the previous tier's, got %v", i+1, p.Metric, tier.UpTo)
+		}
+		last = tier.UpTo
+	}
+	return nil
+}
+
+// normalizeUsagePrices normalizes each price and rejects metrics priced twice.
+func normalizeUsagePrices(prices []UsagePrice) error {
+	if len(prices) > maxUsagePrices {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "at most %d metrics can be priced", maxUsagePrices)
+	}
+	seen := make(map[string]bool, len(prices))
+	for i := range prices {
+		if err := prices[i].normalize(); err != nil {
+			return err
+		}
+		if seen[prices[i].Metric] {
+			return apierr.InvalidArgument(apierr.InvalidParameter, "metric %s is priced twice", prices[i].Metric)
+		}
+		seen[prices[i].Metric] = true
+	}
+	return nil
+}
+
+// amount returns the price of quantity units of usage at the four decimals amounts are
+// stored with. Like lineItemAmount, it works with the decimal values of the tiers, so
+// tier boundaries are exact.
+func (p *UsagePrice) amount(quantity float64) float64 {
+	q := decimalRat(quantity)
+	if p.Mode == TierModeVolume {
+		return roundedAmount(new(big.Rat).Mul(q, p.volumeUnitPrice(q)))
+	}
+	total, lower := new(big.Rat), new(big.Rat)
+	for _, tier := range p.Tiers {
+		unbounded := tier.UpTo == 0
+		upper := decimalRat(tier.UpTo)
+		price := decimalRat(tier.UnitPrice)
+		units := q
+		if !unbounded && q.Cmp(upper) > 0 {
+			units = upper
+		}
+		units = new(big.Rat).Sub(units, lower)
+		if units.Sign() <= 0 {
+			break
+		}
+		total.Add(total, units.Mul(units, price))
+		lower = upper
+	}
+	return roundedAmount(total)
+}
+
+// volumeUnitPrice returns the price of the tier q units of usage fall in, which every
+// unit costs under volume pricing.
+func (p *UsagePrice) volumeUnitPrice(q *big.Rat) *big.Rat {
+	for _, tier := range p.Tiers {
+		if tier.UpTo == 0 || q.Cmp(decimalRat(tier.UpTo)) <= 0 {
+			return decimalRat(tier.UnitPrice)
+		}
+	}
+	return new(big.Rat)
+}
+
+// usagePrice returns the bill's price for metric, or nil.
+func (b *Bill) usagePrice(metric string) *UsagePrice {
+	for i := range b.UsagePrices {
+		if b.UsagePrices[i].Metric == metric {
+			return &b.UsagePrices[i]
+		}
+	}
+	return nil
+}
+
+// metricUsage returns the usage of metric the bill's line items have rated so far, and
+// the amount they billed for it, including what the bill's commitment covered and its
+// free tiers waived.
+func (b *Bill) metricUsage(metric string) (quantity, amount float64) {
+	for _, item := range b.LineItems {
+		if item.Metric == metric {
+			quantity += item.Quantity
+			amount += item.Amount + item.Drawdown + item.Waived
+		}
+	}
+	return quantity, amount
+}
+
+// rateUsage returns the amount a line item adding quantity units of metric bills: the
+// price of the bill's usage of the metric with the item, less what its earlier items
+// billed. Billing the difference keeps the metric's items adding up to the price of its
+// total usage, however rounding falls. The amount is negative when a volume price drops
+// to a cheaper tier. It reports false if the bill does not price metric.
+func (b *Bill) rateUsage(metric string, quantity float64) (float64, bool) {
+	price := b.usagePrice(metric)
+	if price == nil {
+		return 0, false
+	}
+	used, billed := b.metricUsage(metric)
+	return b.round(b.round(price.amount(used+quantity)) - billed), true
+}
+
+// validateUsage checks a request rating usage of a metric: it gives the quantity, and
+// the bill's price for the metric gives the amount.
+func (r *AddLineItemRequest) validateUsage() error {
+	if len(r.Metric) > maxMetricLength {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "metric must be at most %d characters", maxMetricLength)
+	}
+	if r.Type != LineItemCharge {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "metric is only accepted on CHARGE items")
+	}
+	if r.Amount != 0 || r.UnitPrice != 0 {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "items with a metric are priced by the bill's usage prices, so amount and unitPrice must be left out")
+	}
+	if math.IsNaN(r.Quantity) || math.IsInf(r.Quantity, 0) || r.Quantity <= 0 {
+		return apierr.InvalidArgument(apierr.InvalidAmount, "line item quantity must be a positive number, got %v", r.Quantity)
+	}
+	if len(r.Unit) > maxUnitLength {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "unit must be at most %d characters", maxUnitLength)
+	}
+	return nil
+}
+
+// rateLineItem sets the amount of a request rating usage from bill's price for its
+// metric, for the checks made before the item is sent to the bill. The bill's workflow
+// rates the item again when it adds it, against the usage it holds by then.
+func (r *AddLineItemRequest) rateLineItem(bill *Bill) error {
+	amount, ok := bill.rateUsage(r.Metric, r.Quantity)
+	if !ok {
+		return apierr.InvalidArgument(apierr.InvalidParameter, "bill %s has no usage price for metric %s", bill.ID, r.Metric)
+	}
+	r.Amount = amount
+	return nil
+}
+
+// unpricedMetric describes why the workflow refused an item of a metric the bill does
+// not price.
+func unpricedMetric(metric string) string {
+	return fmt.Sprintf("the bill has no usage price for metric %s", metric)
+}
@@ -0,0 +1,147 @@
+package fees
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"encore.app/apierr"
+	"github.com/stretchr/testify/require"
+)
+
+var updatePricingTiers = flag.Bool("update-pricing-tiers", false, "rewrite testdata/tiered_pricing.json from the current pricing")
+
+// tieredPrices are the prices of the golden file: 1,000 units at 0.01, the next 10,000
+// at 0.008 and the rest at 0.005, in both modes, and a free allowance.
+var tieredPrices = []UsagePrice{
+	{Metric: "api_calls", Mode: TierModeGraduated, Tiers: []PriceTier{{UpTo: 1000, UnitPrice: 0.01}, {UpTo: 11000, UnitPrice: 0.008}, {UnitPrice: 0.005}}},
+	{Metric: "api_calls", Mode: TierModeVolume, Tiers: []PriceTier{{UpTo: 1000, UnitPrice: 0.01}, {UpTo: 11000, UnitPrice: 0.008}, {UnitPrice: 0.005}}},
+	{Metric: "storage.gb", Mode: TierModeGraduated, Tiers: []PriceTier{{UpTo: 100, UnitPrice: 0}, {UnitPrice: 0.0231}}},
+}
+
+// tieredQuantities straddle the boundaries of tieredPrices.
+var tieredQuantities = []float64{0, 1, 99.5, 100, 100.1, 999, 1000, 1000.5, 1001, 10999, 11000, 11001, 250000}
+
+// tieredRatings are usage rated in turn on one bill, crossing the tiers of tieredPrices.
+var tieredRatings = []float64{999, 1, 1, 9998, 1, 1, 0.3333, 0.3333}
+
+// tieredPricingGolden is the content of testdata/tiered_pricing.json.
+type tieredPricingGolden struct {
+	Amounts []tieredAmount `json:"amounts"`
+	Ratings []tieredRating `json:"ratings"`
+}
+
+type tieredAmount struct {
+	Metric   string   `json:"metric"`
+	Mode     TierMode `json:"mode"`
+	Quantity float64  `json:"quantity"`
+	Amount   float64  `json:"amount"`
+}
+
+type tieredRating struct {
+	Metric string   `json:"metric"`
+	Mode   TierMode `json:"mode"`
+	// Quantities are the usage of each item, and Amounts what each billed.
+	Quantities []float64 `json:"quantities"`
+	Amounts    []float64 `json:"amounts"`
+}
+
+// TestTieredPricingGolden tests the tier boundary math against
+// testdata/tiered_pricing.json: the price of usage just below, at and above each
+// boundary, and what items rating usage in turn bill. Run
+// "go test -run TestTieredPricingGolden -update-pricing-tiers" to accept a change.
+func TestTieredPricingGolden(t *testing.T) {
+	var got tieredPricingGolden
+	for _, price := range tieredPrices {
+		for _, q := range tieredQuantities {
+			got.Amounts = append(got.Amounts, tieredAmount{Metric: price.Metric, Mode: price.Mode, Quantity: q, Amount: price.amount(q)})
+		}
+		bill := &Bill{UsagePrices: []UsagePrice{price}}
+		rating := tieredRating{Metric: price.Metric, Mode: price.Mode, Quantities: tieredRatings}
+		for _, q := range tieredRatings {
+			amount, ok := bill.rateUsage(price.Metric, q)
+			require.True(t, ok)
+			rating.Amounts = append(rating.Amounts, amount)
+			bill.LineItems = append(bill.LineItems, LineItem{Metric: price.Metric, Quantity: q, Amount: amount})
+		}
+		// However they fall, the items add up to the price of the usage they rated.
+		used, billed := bill.metricUsage(price.Metric)
+		require.Equal(t, price.amount(used), roundAmount(billed), "%s %s", price.Metric, price.Mode)
+		got.Ratings = append(got.Ratings, rating)
+	}
+
+	doc, err := json.MarshalIndent(got, "", "  ")
+	require.NoError(t, err)
+	golden := filepath.Join("testdata", "tiered_pricing.json")
+	if *updatePricingTiers {
+		require.NoError(t, os.WriteFile(golden, append(doc, '\n'), 0o644))
+	}
+	want, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	require.JSONEq(t, string(want), string(doc), "tiered pricing changed; review it and rerun with -update-pricing-tiers")
+}
+
+// TestUsagePriceAmount spells out the boundaries the golden file covers.
+func TestUsagePriceAmount(t *testing.T) {
+	graduated, volume := tieredPrices[0], tieredPrices[1]
+	cases := []struct {
+		quantity, graduated, volume float64
+	}{
+		{1000, 10, 10},
+		{1001, 10.008, 8.008},
+		{11000, 90, 88},
+		{11001, 90.005, 55.005},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.graduated, graduated.amount(c.quantity), "graduated %v", c.quantity)
+		require.Equal(t, c.volume, volume.amount(c.quantity), "volume %v", c.quantity)
+	}
+}
+
+// TestNormalizeUsagePrices tests that prices default to graduated, and that prices
+// leaving usage unpriced or tiers out of order are refused.
+func TestNormalizeUsagePrices(t *testing.T) {
+	prices := []UsagePrice{{Metric: "api_calls", Tiers: []PriceTier{{UpTo: 1000, UnitPrice: 0}, {UnitPrice: 0.01}}}}
+	require.NoError(t, normalizeUsagePrices(prices))
+	require.Equal(t, TierModeGraduated, prices[0].Mode)
+
+	for _, price := range []UsagePrice{
+		{Tiers: []PriceTier{{UnitPrice: 1}}},
+		{Metric: "API Calls", Tiers: []PriceTier{{UnitPrice: 1}}},
+		{Metric: "api_calls", Mode: "stairstep", Tiers: []PriceTier{{UnitPrice: 1}}},
+		{Metric: "api_calls"},
+		{Metric: "api_calls", Tiers: []PriceTier{{UpTo: 1000, UnitPrice: 1}}},
+		{Metric: "api_calls", Tiers: []PriceTier{{UpTo: 1000, UnitPrice: 1}, {UpTo: 1000, UnitPrice: 0.5}, {UnitPrice: 0.1}}},
+		{Metric: "api_calls", Tiers: []PriceTier{{UnitPrice: -1}}},
+	} {
+		err := normalizeUsagePrices([]UsagePrice{price})
+		require.Error(t, err, "%+v", price)
+	}
+	twice := []UsagePrice{{Metric: "api_calls", Tiers: []PriceTier{{UnitPrice: 1}}}, {Metric: "api_calls", Tiers: []PriceTier{{UnitPrice: 2}}}}
+	require.Equal(t, apierr.InvalidParameter, apierr.ReasonOf(normalizeUsagePrices(twice)))
+}
+
+// TestRateLineItem tests that a request rating usage is priced from its bill, and that
+// it gives only a quantity of a metric the bill prices.
+func TestRateLineItem(t *testing.T) {
+	bill := &Bill{ID: "b1", UsagePrices: tieredPrices[1:2], LineItems: []LineItem{{Metric: "api_calls", Quantity: 1000, Amount: 10}}}
+
+	req := &AddLineItemRequest{Metric: "api_calls", Quantity: 1, Type: LineItemCharge}
+	require.NoError(t, req.validateAmount())
+	require.NoError(t, req.rateLineItem(bill))
+	require.Equal(t, -1.992, req.Amount)
+
+	req = &AddLineItemRequest{Metric: "storage.gb", Quantity: 1, Type: LineItemCharge}
+	require.Equal(t, apierr.InvalidParameter, apierr.ReasonOf(req.rateLineItem(bill)))
+
+	for _, req := range []*AddLineItemRequest{
+		{Metric: "api_calls", Type: LineItemCharge},
+		{Metric: "api_calls", Quantity: 1, Amount: 0.01, Type: LineItemCharge},
+		{Metric: "api_calls", Quantity: 1, UnitPrice: 0.01, Type: LineItemCharge},
+		{Metric: "api_calls", Quantity: 1, Type: LineItemCredit},
+	} {
+		require.Error(t, req.validateAmount(), "%+v", req)
+	}
+}
@@ -0,0 +1,217 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"encore.app/apierr"
+	"encore.dev/middleware"
+	"encore.dev/storage/sqldb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	temporalotel "go.temporal.io/sdk/contrib/opentelemetry"
+	"go.temporal.io/sdk/interceptor"
+)
+
+// tracer creates the service's own spans. It resolves against the global tracer
+// provider, so spans started before initTracing runs are simply not recorded.
+var tracer = otel.Tracer("encore.app/services/fees")
+
+// initTracing installs the global OpenTelemetry tracer provider and W3C trace-context
+// propagator. Without an OTLP endpoint spans are not exported, but incoming trace context
+// is still carried through to Temporal so callers' traces stay connected.
+// The returned function flushes and stops the exporter.
+func initTracing(ctx context.Context, cfg *Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if cfg.OTLPEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint))
+	if err != nil {
+		return nil, fmt.Errorf("could not create OTLP trace exporter: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("fees"))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// newTemporalTracingInterceptor returns the Temporal interceptor that copies the active
+// span into workflow and activity headers, and starts spans for workflow starts, signals,
+// queries, workflow runs, and activity executions. Registered on the client, it also
+// applies to the worker created from it.
+func newTemporalTracingInterceptor() (interceptor.Interceptor, error) {
+	return temporalotel.NewTracingInterceptor(temporalotel.TracerOptions{
+		TextMapPropagator: otel.GetTextMapPropagator(),
+	})
+}
+
+// Trace starts a server span for every API call, continuing the caller's trace when the
+// request carries a traceparent header. Handlers pass the span on through their context,
+// so workflow starts, activities, and SQL calls join the same trace.
+//
+// encore:middleware target=all
+func (s *Service) Trace(req middleware.Request, next middleware.Next) middleware.Response {
+	data := req.Data()
+	ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(data.Headers))
+	ctx, span := tracer.Start(ctx, data.Service+"."+data.Endpoint,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("url.path", data.Path)))
+	defer span.End()
+	if data.Trace != nil {
+		// Links the span to Encore's built-in trace of the same request.
+		span.SetAttributes(attribute.String("encore.trace_id", data.Trace.TraceID))
+	}
+
+	resp := next(req.WithContext(ctx))
+	if resp.Err != nil {
+		span.SetStatus(codes.Error, errorCode(resp.Err))
+		if reason := apierr.ReasonOf(resp.Err); reason != "" {
+			span.SetAttributes(attribute.String("fees.error_reason", string(reason)))
+		}
+	}
+	return resp
+}
+
+// tracedDB wraps the service database so every statement runs in a client span. It
+// also applies the DBConfig limits: see db_pool.go.
+type tracedDB struct {
+	*sqldb.Database
+	cfg DBConfig
+	// conns holds a token per connection in use while cfg.MaxConns caps them; nil
+	// otherwise.
+	conns chan struct{}
+	stats dbStats
+}
+
+func (db *tracedDB) Exec(ctx context.Context, query string, args ...interface{}) (sqldb.ExecResult, error) {
+	ctx, release, err := db.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	ctx, finish := db.observe(ctx, query)
+	res, err := db.Database.Exec(ctx, query, args...)
+	finish(err)
+	return res, err
+}
+
+// Query holds its connection until the rows are closed.
+func (db *tracedDB) Query(ctx context.Context, query string, args ...interface{}) (*tracedRows, error) {
+	ctx, release, err := db.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, finish := db.observe(ctx, query)
+	rows, err := db.Database.Query(ctx, query, args...)
+	finish(err)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, release: release}, nil
+}
+
+// QueryRow's span covers running the query; errors surface later, from Scan, which
+// also gives back the connection.
+func (db *tracedDB) QueryRow(ctx context.Context, query string, args ...interface{}) *tracedRow {
+	ctx, release, err := db.acquire(ctx)
+	if err != nil {
+		return &tracedRow{err: err}
+	}
+	ctx, finish := db.observe(ctx, query)
+	row := db.Database.QueryRow(ctx, query, args...)
+	finish(nil)
+	return &tracedRow{row: row, release: release}
+}
+
+// Begin starts a transaction whose statements are traced like the database's own. It
+// holds its connection until it commits or rolls back.
+func (db *tracedDB) Begin(ctx context.Context) (*tracedTx, error) {
+	ctx, release, err := db.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.Database.Begin(ctx)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	t := &tracedTx{Tx: tx, db: db, release: release}
+	if timeout := db.cfg.StatementTimeout; timeout > 0 {
+		if _, err := t.Exec(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout.Milliseconds())); err != nil {
+			_ = t.Rollback()
+			return nil, fmt.Errorf("failed to set statement timeout: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// tracedTx wraps a transaction so every statement runs in a client span.
+type tracedTx struct {
+	*sqldb.Tx
+	db      *tracedDB
+	release func()
+}
+
+func (tx *tracedTx) Exec(ctx context.Context, query string, args ...interface{}) (sqldb.ExecResult, error) {
+	ctx, finish := tx.db.observe(ctx, query)
+	res, err := tx.Tx.Exec(ctx, query, args...)
+	finish(err)
+	return res, err
+}
+
+func (tx *tracedTx) QueryRow(ctx context.Context, query string, args ...interface{}) *sqldb.Row {
+	ctx, finish := tx.db.observe(ctx, query)
+	defer finish(nil)
+	return tx.Tx.QueryRow(ctx, query, args...)
+}
+
+func (tx *tracedTx) Commit() error {
+	defer tx.release()
+	return tx.Tx.Commit()
+}
+
+func (tx *tracedTx) Rollback() error {
+	defer tx.release()
+	return tx.Tx.Rollback()
+}
+
+func startDBSpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	op := sqlOperation(query)
+	return tracer.Start(ctx, op+" fees",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBName("fees"),
+			semconv.DBOperation(op),
+			semconv.DBStatement(strings.Join(strings.Fields(query), " ")),
+		))
+}
+
+func endDBSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sqldb.ErrNoRows) {
+		span.SetStatus(codes.Error, "query failed")
+	}
+}
+
+// sqlOperation returns the statement's leading keyword, e.g. "INSERT".
+func sqlOperation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "QUERY"
+	}
+	return strings.ToUpper(fields[0])
+}
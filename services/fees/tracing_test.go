@@ -0,0 +1,61 @@
+package fees
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+)
+
+// TestTemporalTracing tests that a bill workflow and its activities are recorded in one trace.
+func TestTemporalTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	tracing, err := newTemporalTracingInterceptor()
+	require.NoError(t, err)
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.SetWorkerOptions(worker.Options{Interceptors: []interceptor.WorkerInterceptor{newActivityOptionsInterceptor(nil), tracing}})
+	activities := &Activities{}
+	env.RegisterActivity(activities.UpsertBillActivity)
+	env.RegisterActivity(activities.UpdateBillOnCloseActivity)
+	env.OnActivity(activities.UpsertBillActivity, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(activities.UpdateBillOnCloseActivity, mock.Anything, mock.Anything).Return(nil)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, time.Second)
+	env.ExecuteWorkflow(BillWorkflow, &BillWorkflowParams{BillID: uuid.NewString(), CustomerID: "cust-1", Currency: "USD"})
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	byName := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		byName[span.Name()] = span
+	}
+	run, ok := byName["RunWorkflow:BillWorkflow"]
+	require.True(t, ok, "workflow span recorded")
+	upsert, ok := byName["StartActivity:UpsertBillActivity"]
+	require.True(t, ok, "activity span recorded")
+	require.Equal(t, run.SpanContext().TraceID(), upsert.SpanContext().TraceID())
+	require.Equal(t, run.SpanContext().SpanID(), upsert.Parent().SpanID())
+}
+
+// TestSQLOperation tests that DB spans are named after the statement's leading keyword.
+func TestSQLOperation(t *testing.T) {
+	require.Equal(t, "INSERT", sqlOperation("\n        insert INTO bills (id) VALUES ($1)"))
+	require.Equal(t, "SELECT", sqlOperation("SELECT COUNT(*) FROM bills"))
+	require.Equal(t, "QUERY", sqlOperation("  "))
+}
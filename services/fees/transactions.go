@@ -0,0 +1,66 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.dev/storage/sqldb"
+)
+
+const (
+	// txMaxAttempts is how many times inTx runs a transaction Postgres keeps aborting.
+	txMaxAttempts = 3
+	// txRetryDelay is how long inTx waits before running an aborted transaction again,
+	// growing with every attempt.
+	txRetryDelay = 20 * time.Millisecond
+)
+
+// retryableTxCodes are the Postgres error codes of transactions aborted because they
+// conflicted with concurrent ones: serialization_failure and deadlock_detected. Run
+// again, they usually succeed.
+var retryableTxCodes = map[string]bool{"40001": true, "40P01": true}
+
+// retryableTxError reports whether err aborted a transaction that may succeed when run
+// again.
+func retryableTxError(err error) bool {
+	var dbErr *sqldb.Error
+	return errors.As(err, &dbErr) && retryableTxCodes[dbErr.DatabaseCode]
+}
+
+// inTx runs fn in a transaction and commits it, or rolls it back if fn fails. A
+// transaction Postgres aborts for a serialization failure or deadlock is run again
+// from the start, up to txMaxAttempts times, so fn must have no effects outside tx.
+// The error of the last attempt is returned as fn returned it.
+func (db *tracedDB) inTx(ctx context.Context, fn func(tx *tracedTx) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = db.runTx(ctx, fn)
+		if err == nil || attempt == txMaxAttempts || !retryableTxError(err) {
+			return err
+		}
+		loggerFrom(ctx).Debug("Transaction aborted by a concurrent one, retrying", "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt) * txRetryDelay):
+		}
+	}
+}
+
+// runTx runs fn in one transaction.
+func (db *tracedDB) runTx(ctx context.Context, fn func(tx *tracedTx) error) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package fees
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"encore.dev/storage/sqldb"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRetryableTxError tests that only transactions aborted by concurrent ones are run
+// again.
+func TestRetryableTxError(t *testing.T) {
+	require.True(t, retryableTxError(fmt.Errorf("failed to commit transaction: %w", &sqldb.Error{DatabaseCode: "40001"})))
+	require.True(t, retryableTxError(&sqldb.Error{DatabaseCode: "40P01"}))
+	require.False(t, retryableTxError(&sqldb.Error{DatabaseCode: "23505"}))
+	require.False(t, retryableTxError(errors.New("connection reset")))
+	require.False(t, retryableTxError(nil))
+}
@@ -2,19 +2,63 @@ package fees
 
 import (
 	"time"
+
+	"encore.app/rounding"
 )
 
 // BillStatus represents the status of a bill.
 type BillStatus string
 
 const (
-	BillStatusOpen   BillStatus = "OPEN"
-	BillStatusClosed BillStatus = "CLOSED"
+	BillStatusOpen    BillStatus = "OPEN"
+	BillStatusClosing BillStatus = "CLOSING"
+	// BillStatusPendingApproval bills have a total at or above the approval threshold
+	// and finalize only once approved.
+	BillStatusPendingApproval BillStatus = "PENDING_APPROVAL"
+	BillStatusClosed          BillStatus = "CLOSED"
+	BillStatusPaid            BillStatus = "PAID"
+	BillStatusPaymentFailed   BillStatus = "PAYMENT_FAILED"
+	BillStatusDelinquent      BillStatus = "DELINQUENT"
+	// BillStatusCloseFailed bills were finalized, but saving the close failed. They
+	// close once a retry of the close is saved.
+	BillStatusCloseFailed BillStatus = "CLOSE_FAILED"
+	// BillStatusOverdue bills are closed and still unpaid after their due date.
+	BillStatusOverdue BillStatus = "OVERDUE"
+	// BillStatusPartiallyPaid bills are closed and have been paid in part.
+	BillStatusPartiallyPaid BillStatus = "PARTIALLY_PAID"
 )
 
+// IsValid reports whether s is a known bill status.
+func (s BillStatus) IsValid() bool {
+	switch s {
+	case BillStatusOpen, BillStatusClosing, BillStatusPendingApproval, BillStatusCloseFailed, BillStatusClosed, BillStatusPaid, BillStatusPaymentFailed, BillStatusPartiallyPaid, BillStatusOverdue, BillStatusDelinquent:
+		return true
+	}
+	return false
+}
+
+// validCurrency reports whether c looks like an ISO 4217 currency code: three uppercase letters.
+func validCurrency(c string) bool {
+	if len(c) != 3 {
+		return false
+	}
+	for _, r := range c {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
 // Bill represents a customer bill.
 type Bill struct {
-	ID          string     `json:"id"`
+	ID string `json:"id"`
+	// Number is the bill's human-readable number, such as CUST123-2024-00042, given
+	// when it closes. Bills without a customer are not numbered.
+	Number string `json:"number,omitempty"`
+	// TenantID is the platform the bill belongs to. Bills created before tenants
+	// were recorded belong to DefaultTenantID.
+	TenantID    string     `json:"tenantId,omitempty"`
 	CustomerID  string     `json:"customerId,omitempty"`
 	Currency    string     `json:"currency"`
 	Status      BillStatus `json:"status"`
@@ -22,6 +66,98 @@ type Bill struct {
 	TotalAmount float64    `json:"totalAmount"`
 	CreatedAt   *time.Time `json:"createdAt"`
 	ClosedAt    *time.Time `json:"closedAt,omitempty"`
+	// Subtotals splits the total of the line items by type. GetBill sets it.
+	Subtotals *LineItemTotals `json:"subtotals,omitempty"`
+	// DueDate is when payment of the closed bill is due. A bill still unpaid then
+	// becomes OVERDUE.
+	DueDate *time.Time `json:"dueDate,omitempty"`
+	// PeriodEnd is when the billing period of the bill ends. The close sweep closes
+	// bills still open after it.
+	PeriodEnd *time.Time `json:"periodEnd,omitempty"`
+	// Rounding is how the bill's line items and totals are rounded to its currency's
+	// minor units. Bills created before rounding policies keep four decimals.
+	Rounding *rounding.Policy `json:"rounding,omitempty"`
+	// Version increases with every change to the bill. Mutating endpoints accept it
+	// in If-Match to reject changes based on an outdated read of the bill.
+	Version int64 `json:"version"`
+	// CloseRequestedAt and FinalizesAt are set once a close has been requested with a
+	// grace period. The bill is CLOSING until FinalizesAt and still accepts line items,
+	// which are flagged as late.
+	CloseRequestedAt *time.Time `json:"closeRequestedAt,omitempty"`
+	FinalizesAt      *time.Time `json:"finalizesAt,omitempty"`
+	AutoCollect      bool       `json:"autoCollect,omitempty"`
+	Payments         []Payment  `json:"payments,omitempty"`
+	// AmountPaid sums the successful payments allocated to the closed bill, and
+	// BalanceDue is what remains to be paid. BalanceDue is set once the bill closes.
+	AmountPaid float64  `json:"amountPaid,omitempty"`
+	BalanceDue *float64 `json:"balanceDue,omitempty"`
+	// Allocations lists the successful payments in the order they were applied to
+	// the bill's balance.
+	Allocations []PaymentAllocation `json:"allocations,omitempty"`
+	// RefundedAmount is the sum of successfully issued credit notes.
+	RefundedAmount float64      `json:"refundedAmount,omitempty"`
+	CreditNotes    []CreditNote `json:"creditNotes,omitempty"`
+	// CreatedByKeyID is the API key that created the bill.
+	CreatedByKeyID string `json:"createdByKeyId,omitempty"`
+	// Test is set on test bills, created by test mode API keys. Reports leave them out
+	// unless asked to include them, and POST /admin/test-bills/purge deletes them.
+	Test bool `json:"test,omitempty"`
+	// FollowUpOf is set on a bill opened to hold the late line items of the closed
+	// bill it names.
+	FollowUpOf string `json:"followUpOf,omitempty"`
+	// ParentBillID is set on a sub-bill to the bill its total rolls up to on close.
+	ParentBillID string `json:"parentBillId,omitempty"`
+	// TemplateID is the bill template the bill was created from, if any.
+	TemplateID string `json:"templateId,omitempty"`
+	// Adjustment is set when the bill's total was topped up, capped, or flagged
+	// against the customer's bill limits on close.
+	Adjustment *BillAdjustment `json:"adjustment,omitempty"`
+	// AppliedCredit is set when the customer's credit balance was applied to the bill
+	// on close.
+	AppliedCredit *AppliedCredit `json:"appliedCredit,omitempty"`
+	// Approval is set once the bill has been held for approval, and records the
+	// latest decision.
+	Approval *BillApproval `json:"approval,omitempty"`
+	// DroppedLineItems lists items removed from the bill because they could not be
+	// saved, under the remove save failure policy.
+	DroppedLineItems []DroppedLineItem `json:"droppedLineItems,omitempty"`
+	// CloseFailure is set while the bill is CLOSE_FAILED.
+	CloseFailure *CloseFailure `json:"closeFailure,omitempty"`
+	// SpendingAlerts notify the customer as the bill's total reaches percentages of a
+	// budget, and CrossedThresholds lists those reached so far.
+	SpendingAlerts    *SpendingAlerts    `json:"spendingAlerts,omitempty"`
+	CrossedThresholds []CrossedThreshold `json:"crossedThresholds,omitempty"`
+	// HardCap is the bound on the bill's total enforced as line items arrive, from
+	// the customer's bill limits. RejectedLineItems lists the latest items it refused,
+	// and credits refused for taking the total below zero.
+	HardCap           *HardCap           `json:"hardCap,omitempty"`
+	RejectedLineItems []RejectedLineItem `json:"rejectedLineItems,omitempty"`
+	// PayerSplits split the bill's total among several payers, and PayerShares are the
+	// shares issued to them once it closed.
+	PayerSplits []PayerSplit `json:"payerSplits,omitempty"`
+	PayerShares []PayerShare `json:"payerShares,omitempty"`
+	// Aggregation consolidates the bill's small line items into one item per category
+	// and interval.
+	Aggregation *LineItemAggregation `json:"aggregation,omitempty"`
+	// UsagePrices price the usage of metrics, such as API calls, in tiers. Line items
+	// giving a metric and a quantity are rated with them.
+	UsagePrices []UsagePrice `json:"usagePrices,omitempty"`
+	// FreeTiers waive the first units of a metric's usage, or what the bill charges in
+	// the first days of a trial counted from TrialStart.
+	FreeTiers  []FreeTier `json:"freeTiers,omitempty"`
+	TrialStart *time.Time `json:"trialStart,omitempty"`
+	// Commitment is set on bills of customers with a committed-use contract: the
+	// committed amount billed up front, and how much of it usage has drawn down.
+	Commitment *Commitment `json:"commitment,omitempty"`
+	// Coupons are the coupons applied to the bill, taken off its total when it closes.
+	Coupons []AppliedCoupon `json:"coupons,omitempty"`
+	// Stale is set on bills read from the database, or from queued requests, because
+	// Temporal was unavailable. They may lag the bill's workflow and omit payments,
+	// credit notes, and items added since.
+	Stale bool `json:"stale,omitempty"`
+	// DeletedAt is set on deleted bills, which are only returned when asked for with
+	// includeDeleted.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
 
 // LineItem represents an individual item on a bill.
@@ -29,55 +165,242 @@ type LineItem struct {
 	ID          string  `json:"id"`
 	Description string  `json:"description"`
 	Amount      float64 `json:"amount"`
+	// Type says whether the item charges, credits or adjusts the bill. Items of bills
+	// that ran before types existed leave it empty, and are charges unless negative.
+	Type LineItemType `json:"type,omitempty"`
+	// ReasonCode says why a credit or adjustment was made, and Reference links it to
+	// what it corrects.
+	ReasonCode string             `json:"reasonCode,omitempty"`
+	Reference  *LineItemReference `json:"reference,omitempty"`
+	// Quantity, UnitPrice and Unit are set on items priced per unit, such as 12.5 GB at
+	// 0.08; Amount is then their product.
+	Quantity  float64 `json:"quantity,omitempty"`
+	UnitPrice float64 `json:"unitPrice,omitempty"`
+	Unit      string  `json:"unit,omitempty"`
+	// RoutedFrom is set when the item arrived after its original bill closed and was forwarded here.
+	RoutedFrom *RoutedFrom `json:"routedFrom,omitempty"`
+	// SubBillID is set on the item holding the rolled-up total of a sub-bill.
+	SubBillID string `json:"subBillId,omitempty"`
+	// Late is set on items added while the bill was CLOSING.
+	Late bool `json:"late,omitempty"`
+	// OverHardCap is set on items that took the bill above its hard cap under the
+	// flag action.
+	OverHardCap bool `json:"overHardCap,omitempty"`
+	// CreatedByKeyID is the API key that added the item.
+	CreatedByKeyID string `json:"createdByKeyId,omitempty"`
+	// ClientReference is the caller's own ID for the item, such as a usage record ID.
+	// A bill holds at most one item per reference.
+	ClientReference string `json:"clientReference,omitempty"`
+	// Aggregate is set on an item consolidating the small items of one category and
+	// interval, under the bill's aggregation.
+	Aggregate *LineItemAggregate `json:"aggregate,omitempty"`
+	// Metric is set on items rating usage of a metric the bill prices; Quantity is the
+	// usage they add.
+	Metric string `json:"metric,omitempty"`
+	// Drawdown is the part of the item's usage the bill's commitment covered; Amount is
+	// only the overage beyond it.
+	Drawdown float64 `json:"drawdown,omitempty"`
+	// Waived is set on the zero-amount items recording what the bill's free tiers
+	// waived of the item they reference: what the customer would have paid for it.
+	Waived float64 `json:"waived,omitempty"`
 }
 
 // ------ API Payloads ------
 
+// CustomerSettingsRequest names the tenant of the customer whose settings an admin
+// endpoint reads or clears.
+type CustomerSettingsRequest struct {
+	// TenantID is the platform the customer belongs to; the same customer ID in another
+	// tenant has settings of its own. Defaults to the default tenant.
+	TenantID string `header:"X-Tenant-ID"`
+}
+
 // CreateBillRequest is the request payload for creating a new bill.
 type CreateBillRequest struct {
+	// TenantID identifies the platform calling the API, which owns the bill and is
+	// charged for it against its quotas. API keys always use their own tenant.
+	TenantID   string `header:"X-Tenant-ID"`
 	CustomerID string `json:"customerId,omitempty"`
 	Currency   string `json:"currency"`
+	// AutoCollect charges the customer through the payment gateway as soon as the bill closes.
+	AutoCollect bool `json:"autoCollect,omitempty"`
+	// CloseGracePeriod (e.g. "5m") keeps accepting late line items for this long after
+	// CloseBill is requested. Defaults to the template's, then the service-wide setting;
+	// "0s" disables it.
+	CloseGracePeriod string `json:"closeGracePeriod,omitempty"`
+	// TemplateID seeds the bill with a bill template's line items. The template's
+	// AutoCollect also applies, and its CloseGracePeriod unless one is given here.
+	TemplateID string `json:"templateId,omitempty"`
+	// DueDate is when payment of the bill is due once it closes; CloseBill may set a
+	// new one. It must be in the future.
+	DueDate *time.Time `json:"dueDate,omitempty"`
+	// PeriodEnd is when the bill's billing period ends; the nightly close sweep closes
+	// the bill if it is still open then. It must be in the future.
+	PeriodEnd *time.Time `json:"periodEnd,omitempty"`
+	// SpendingAlerts notify the customer as the bill's total reaches percentages of a
+	// budget. Defaults to the customer's spending alerts in the bill's currency.
+	SpendingAlerts *SpendingAlerts `json:"spendingAlerts,omitempty"`
+	// ParentBillID makes the bill a sub-bill of an open bill in the same currency and
+	// tenant, such as a department's bill under its organization's. The sub-bill's
+	// total is added to the parent when it closes, and it is paid through the parent,
+	// so it cannot set autoCollect or dueDate.
+	ParentBillID string `json:"parentBillId,omitempty"`
+	// Aggregation consolidates the bill's small line items into one item per category
+	// and interval, such as hourly, keeping each item as an event for audit.
+	Aggregation *LineItemAggregation `json:"aggregation,omitempty"`
+	// UsagePrices price the usage of metrics in tiers, for line items that give a
+	// metric and a quantity instead of an amount.
+	UsagePrices []UsagePrice `json:"usagePrices,omitempty"`
+	// FreeTiers waive the first units of a metric's usage, such as the first 1,000 API
+	// calls, or what the bill charges in the first days after it is created.
+	FreeTiers []FreeTier `json:"freeTiers,omitempty"`
+	// Coupons are codes of coupons to apply to the bill, redeemed as it is created.
+	Coupons []string `json:"coupons,omitempty"`
+	// Test creates a test bill, which reports leave out. Bills created with a test
+	// mode API key always are.
+	Test bool `json:"test,omitempty"`
 }
 
 // CreateBillResponse is the response payload after creating a new bill.
 type CreateBillResponse struct {
-	BillID          string     `json:"billId"`
-	WorkflowID      string     `json:"workflowId"`
-	RunID           string     `json:"runId"`
-	InitialStatus   BillStatus `json:"initialStatus"`
-	ConfirmationMsg string     `json:"confirmationMsg"`
+	RetryMetadata
+	BillID        string     `json:"billId"`
+	WorkflowID    string     `json:"workflowId"`
+	RunID         string     `json:"runId"`
+	InitialStatus BillStatus `json:"initialStatus"`
+	// Queued is set when Temporal was unavailable and the bill will be created once
+	// it is back. RunID is empty until then.
+	Queued          bool   `json:"queued,omitempty"`
+	ConfirmationMsg string `json:"confirmationMsg"`
 }
 
 // AddLineItemRequest is the request payload for adding a line item to a bill.
 type AddLineItemRequest struct {
-	Description string  `json:"description"`
-	Amount      float64 `json:"amount"`
+	Description string `json:"description"`
+	// Amount is the item's amount. It may be left out when Quantity and UnitPrice are
+	// given, and must match their product otherwise.
+	Amount    float64 `json:"amount,omitempty"`
+	Quantity  float64 `json:"quantity,omitempty"`
+	UnitPrice float64 `json:"unitPrice,omitempty"`
+	// Unit names what Quantity counts, such as "transaction" or "GB".
+	Unit string `json:"unit,omitempty"`
+	// Currency, when set, must be the bill's currency.
+	Currency string `json:"currency,omitempty"`
+	// Type is CHARGE (the default), CREDIT, or ADJUSTMENT. Charges are positive,
+	// credits negative, and adjustments either.
+	Type LineItemType `json:"type,omitempty"`
+	// ReasonCode says why a credit or adjustment was made; they require one from the
+	// configured list.
+	ReasonCode string `json:"reasonCode,omitempty"`
+	// Reference optionally links a credit or adjustment to the item of the bill it
+	// corrects, or to an external record such as a support ticket.
+	Reference *LineItemReference `json:"reference,omitempty"`
+	// AllowNegativeTotal lets a credit or adjustment take the bill's total below zero.
+	AllowNegativeTotal bool `json:"allowNegativeTotal,omitempty"`
+	// ClientReference optionally identifies the item on the caller's side. Adding an item
+	// with a reference the bill already holds adds nothing and returns the existing item.
+	ClientReference string `json:"clientReference,omitempty"`
+	// Category groups the item with others of its kind, such as "api_call", when the
+	// bill aggregates line items. Defaults to Description.
+	Category string `json:"category,omitempty"`
+	// Metric rates the item as Quantity units of usage of a metric the bill prices, such
+	// as "api_calls". The bill's usage price for the metric sets the amount, so Amount and
+	// UnitPrice are left out.
+	Metric string `json:"metric,omitempty"`
+	// Wait (?wait=true) holds the response until the bill reports the item applied, so
+	// an immediate GetBill sees it. Otherwise the item is applied asynchronously.
+	Wait bool `query:"wait"`
+	// IfMatch, when set, adds the item only if the bill is still at this version.
+	IfMatch string `header:"If-Match"`
 }
 
 // AddLineItemResponse is the response payload after adding a line item.
 type AddLineItemResponse struct {
-	LineItemID      string `json:"lineItemId"`
-	BillID          string `json:"billId"`
-	ConfirmationMsg string `json:"confirmationMsg"`
+	RetryMetadata
+	LineItemID string `json:"lineItemId"`
+	BillID     string `json:"billId"`
+	// StateToken identifies the bill's state once this change is applied. Pass it to
+	// GetBill as minStateToken to read the bill with the change.
+	StateToken string `json:"stateToken,omitempty"`
+	// Queued is set when Temporal was unavailable and the item will be added once it is back.
+	Queued bool `json:"queued,omitempty"`
+	// Duplicate is set when the bill already held an item with the request's ClientReference;
+	// LineItemID is then that item's ID.
+	Duplicate bool `json:"duplicate,omitempty"`
+	// TotalAmount, ItemCount and Status describe the bill just after the item was
+	// applied. They are set only when Wait was requested, since otherwise the item has
+	// not been applied yet when the response is sent.
+	TotalAmount     *float64   `json:"totalAmount,omitempty"`
+	ItemCount       int        `json:"itemCount,omitempty"`
+	Status          BillStatus `json:"status,omitempty"`
+	ConfirmationMsg string     `json:"confirmationMsg"`
+}
+
+// CloseBillRequest holds the optional parameters for closing a bill.
+type CloseBillRequest struct {
+	// GracePeriod (e.g. "5m") keeps the bill CLOSING and accepting late line items for
+	// this long before it finalizes. Defaults to the bill's own grace period; "0s" closes at once.
+	GracePeriod string `query:"gracePeriod"`
+	// DueDate (RFC 3339) sets when payment of the closed bill is due, replacing the one
+	// given at creation. It must be in the future.
+	DueDate string `query:"dueDate"`
+	// IfMatch, when set, closes the bill only if it is still at this version.
+	IfMatch string `header:"If-Match"`
 }
 
 // CloseBillResponse is the response payload after closing a bill.
 type CloseBillResponse struct {
 	Bill
+	RetryMetadata
 	ConfirmationMsg string `json:"confirmationMsg,omitempty"`
 }
 
 // GetBillResponse is the response payload for retrieving a bill.
 type GetBillResponse struct {
 	RetrievedBill Bill `json:"bill"`
+	// ETag is the bill's version, for use in If-Match. It is not set on a bill as of
+	// an earlier time.
+	ETag string `header:"ETag"`
+	// AsOf echoes the time the bill was rebuilt for, when one was requested.
+	AsOf *time.Time `json:"asOf,omitempty"`
+	// Workflow describes the bill's workflow, when it was requested and Temporal could
+	// describe it.
+	Workflow *BillWorkflowDescription `json:"workflow,omitempty"`
+	// Archive is set when the bill was read from its archive, because Temporal no
+	// longer holds its workflow, such as after the retention sweep deleted it.
+	Archive *BillArchive `json:"archive,omitempty"`
+}
+
+// GetBillParams defines the query parameters for retrieving a bill.
+type GetBillParams struct {
+	// AsOf (RFC 3339) returns the bill as it was at that moment, rebuilt from its
+	// event stream, such as the total a customer saw before the bill changed.
+	AsOf string `query:"asOf"`
+	// IncludeWorkflow also describes the bill's workflow: its run, status, pending
+	// activities and their last failures, and history length.
+	IncludeWorkflow bool `query:"includeWorkflow"`
+	// MinStateToken, a stateToken returned by a change to the bill, waits until the bill
+	// reflects that change, so a read right after a write sees it.
+	MinStateToken string `query:"minStateToken"`
+	// IncludeDeleted returns the bill even if it was deleted. It requires the
+	// bills:delete scope.
+	IncludeDeleted bool `query:"includeDeleted"`
 }
 
 // ListBillsParams defines parameters for listing bills.
 type ListBillsParams struct {
-	Status   string `query:"status"`
-	Currency string `query:"currency"`
+	Status     string `query:"status"`
+	Currency   string `query:"currency"`
+	CustomerID string `query:"customerId"`
+	// TenantID filters internal callers' listings to one tenant. Tenant-scoped API keys
+	// only ever list their own tenant's bills.
+	TenantID string `query:"tenantId"`
 	Limit    int    `query:"limit"`
 	Offset   int    `query:"offset"`
+	// IncludeDeleted lists deleted bills too. It requires the bills:delete scope.
+	IncludeDeleted bool `query:"includeDeleted"`
+	// IncludeTest lists test bills too. Test mode API keys only ever list test bills.
+	IncludeTest bool `query:"includeTest"`
 }
 
 // ListBillsResponse is the response payload for listing bills.
@@ -86,6 +409,8 @@ type ListBillsResponse struct {
 	TotalCount int    `json:"totalCount"`
 	Limit      int    `json:"limit"`
 	Offset     int    `json:"offset"`
+	// Stale is set when Temporal was unavailable and the bills were read from the database.
+	Stale bool `json:"stale,omitempty"`
 }
 
 // ------- Workflow Types -------
@@ -94,12 +419,43 @@ const (
 	UpsertBillActivityName        = "UpsertBillActivity"
 	SaveLineItemActivityName      = "SaveLineItemActivity"
 	UpdateBillOnCloseActivityName = "UpdateBillOnCloseActivity"
+	UpdateBillStatusActivityName  = "UpdateBillStatusActivity"
+	ChargePaymentActivityName     = "ChargePaymentActivity"
+	RecordPaymentActivityName     = "RecordPaymentActivity"
+	RecordCreditNoteActivityName  = "RecordCreditNoteActivity"
+	RefundPaymentActivityName     = "RefundPaymentActivity"
+	UpdateCreditNoteActivityName  = "UpdateCreditNoteActivity"
+	SendNotificationActivityName  = "SendNotificationActivity"
+	RouteLateLineItemActivityName = "RouteLateLineItemActivity"
+	ProrateActivityName           = "ProrateActivity"
 )
 
 const (
-	AddLineItemSignalName   = "AddLineItemSignal"
-	CloseBillSignalName     = "CloseBillSignal"
-	GetBillDetailsQueryName = "GetBillDetailsQuery"
+	AddLineItemSignalName    = "AddLineItemSignal"
+	CloseBillSignalName      = "CloseBillSignal"
+	PayBillSignalName        = "PayBillSignal"
+	RecordPaymentSignalName  = "RecordPaymentSignal"
+	PaymentWebhookSignalName = "PaymentWebhookSignal"
+	RefundBillSignalName     = "RefundBillSignal"
+	ApproveBillSignalName    = "ApproveBillSignal"
+	RetryCloseSignalName     = "RetryCloseSignal"
+	RejectBillSignalName     = "RejectBillSignal"
+	GetBillDetailsQueryName  = "GetBillDetailsQuery"
+
+	SetSpendingAlertsSignalName = "SetSpendingAlertsSignal"
+	SetPayerSplitsSignalName    = "SetPayerSplitsSignal"
+	ApplyCouponSignalName       = "ApplyCouponSignal"
+
+	PauseDunningSignalName   = "PauseDunningSignal"
+	ResumeDunningSignalName  = "ResumeDunningSignal"
+	GetDunningStateQueryName = "GetDunningStateQuery"
+
+	SubmitDisputeEvidenceSignalName = "SubmitDisputeEvidenceSignal"
+	ResolveDisputeSignalName        = "ResolveDisputeSignal"
+
+	ChangeSubscriptionPlanSignalName = "ChangeSubscriptionPlanSignal"
+	CancelSubscriptionSignalName     = "CancelSubscriptionSignal"
+	GetSubscriptionStateQueryName    = "GetSubscriptionStateQuery"
 )
 
 // AddLineItemSignal defines the data for adding a line item.
@@ -107,24 +463,199 @@ type AddLineItemSignal struct {
 	LineItemID  string
 	Description string
 	Amount      float64
+	// Quantity and UnitPrice, when set, price the item per Unit. The workflow computes
+	// its amount from them, ignoring Amount.
+	Quantity  float64
+	UnitPrice float64
+	Unit      string
+	// RoutedFrom tags items forwarded from a bill that had already closed.
+	RoutedFrom *RoutedFrom
+	// SubBillID tags the item rolling up the total of a sub-bill.
+	SubBillID string
+	// CreatedByKeyID is the API key that sent the item, if any.
+	CreatedByKeyID string
+	// ClientReference is the caller's ID for the item; the workflow ignores an item whose
+	// reference the bill already holds.
+	ClientReference string
+	// Category is the aggregation category of the item, if the bill aggregates it.
+	Category string
+	// Metric, when set, rates Quantity units of usage with the bill's price for the
+	// metric, ignoring Amount and UnitPrice.
+	Metric string
+	// NotUsage marks the service's own charges, such as a subscription's plan charge,
+	// which the bill's commitment does not cover.
+	NotUsage bool
+	// Commitment marks the item billing the bill's commitment, which trials do not waive.
+	Commitment bool
+	// Type and ReasonCode classify the item; an empty Type is a charge.
+	Type       LineItemType
+	ReasonCode string
+	Reference  *LineItemReference
+	// AllowNegativeTotal accepts the item even if it takes the bill's total below zero.
+	AllowNegativeTotal bool
+	// IfVersion, when set, drops the signal unless the bill is at this version.
+	IfVersion int64
+}
+
+type CloseBillSignal struct {
+	// RequestedByKeyID is the API key that requested the close, recorded in the audit log.
+	RequestedByKeyID string
+	// GracePeriod overrides the bill's CloseGracePeriod when set.
+	GracePeriod *time.Duration
+	// DueDate replaces the bill's due date when set.
+	DueDate *time.Time
+	// IfVersion, when set, drops the signal unless the bill is at this version.
+	IfVersion int64
+}
+
+// PayBillSignal requests payment collection for a closed bill.
+type PayBillSignal struct {
+	PaymentID string
+	// RequestedByKeyID is the API key that requested the payment; empty for automatic collection.
+	RequestedByKeyID string
+	// IfVersion, when set, drops the signal unless the bill is at this version.
+	IfVersion int64
 }
 
-type CloseBillSignal struct{}
+// RecordPaymentSignal records a payment of a closed bill that was received outside
+// the payment gateway, such as a bank transfer.
+type RecordPaymentSignal struct {
+	PaymentID string
+	Amount    float64
+	Method    string
+	// Reference is the payment's reference with the bank or processor, if any.
+	Reference string
+	// RequestedByKeyID is the API key that recorded the payment.
+	RequestedByKeyID string
+	// IfVersion, when set, drops the signal unless the bill is at this version.
+	IfVersion int64
+}
+
+// RefundBillSignal requests a full or partial refund of a closed bill.
+type RefundBillSignal struct {
+	CreditNoteID string
+	// Amount is the amount to refund; zero refunds the remaining refundable amount.
+	Amount float64
+	Reason string
+	// CarryForward carries the part of Amount beyond the refundable amount forward to
+	// the customer's credit balance instead of ignoring the signal.
+	CarryForward bool
+	// RequestedByKeyID is the API key that requested the refund.
+	RequestedByKeyID string
+	// IfVersion, when set, drops the signal unless the bill is at this version.
+	IfVersion int64
+}
 
 // BillWorkflowParams defines the parameters for starting the BillWorkflow.
 type BillWorkflowParams struct {
-	BillID     string
-	CustomerID string
-	Currency   string
+	BillID      string
+	TenantID    string
+	CustomerID  string
+	Currency    string
+	AutoCollect bool
+	// CloseGracePeriod delays finalization after a CloseBillSignal that does not set its
+	// own grace period; zero closes immediately.
+	CloseGracePeriod time.Duration
+	// DueDate is when payment of the bill is due once it closes, if any.
+	DueDate *time.Time
+	// PeriodEnd is when the bill's billing period ends, if any.
+	PeriodEnd *time.Time
+	// DunningSchedule lists retry offsets, measured from the first failed payment,
+	// at which a DunningWorkflow re-attempts the charge. Empty disables dunning.
+	DunningSchedule []time.Duration
+	// LateItemPolicy decides what happens to line items signaled after close.
+	LateItemPolicy LateItemPolicy
+	// SaveFailurePolicy decides how line items that could not be saved are compensated
+	// for. Empty repairs them.
+	SaveFailurePolicy SaveFailurePolicy
+	// RouteLateItems is the pre-policy setting, still honored for runs started before
+	// LateItemPolicy existed: true routes like LateItemPolicyNextBill.
+	RouteLateItems bool
+	// FollowUpOf is set on a follow-up bill to the ID of the closed bill whose late
+	// line items it holds.
+	FollowUpOf string
+	// ParentBillID is set on a sub-bill to the bill its total rolls up to on close.
+	ParentBillID string
+	// Aggregation, when set, consolidates the bill's small line items.
+	Aggregation *LineItemAggregation
+	// UsagePrices rate the line items that give a metric.
+	UsagePrices []UsagePrice
+	// FreeTiers waive usage and trial charges. Their trials count from TrialStart, or
+	// from when the run starts if it is nil.
+	FreeTiers  []FreeTier
+	TrialStart *time.Time
+	// Coupons are the coupons redeemed for the bill as it was created.
+	Coupons []AppliedCoupon
+	// Contract is the customer's committed-use contract when the bill was created. Its
+	// commitment is billed when the run starts.
+	Contract *Contract
+	// TemplateID and TemplateItems seed the bill with a bill template's line items
+	// when the run starts.
+	TemplateID    string
+	TemplateItems []TemplateLineItem
+	// BillLimits are the customer's bill limits when the bill was created, applied
+	// on close.
+	BillLimits *BillLimits
+	// SpendingAlerts are the bill's spending alerts when it was created.
+	SpendingAlerts *SpendingAlerts
+	// Approval holds bills whose total reaches its threshold for approval before
+	// they finalize.
+	Approval ApprovalPolicy
+	// RoundingMode sets the rounding policy of the bill, applied at its currency's
+	// minor units. Empty keeps four decimals, as runs started before it existed did.
+	RoundingMode rounding.Mode
+	// ApplyCredits applies the customer's credit balance to the bill on close. Runs
+	// started before credit balances existed leave it unset, so their replay is unchanged.
+	ApplyCredits bool
+	// EmailOnClose emails the bill to its customer's contact once it closes. Runs
+	// started before bill emails existed leave it unset, so their replay is unchanged.
+	EmailOnClose bool
+	// CreatedByKeyID is the API key that created the bill, if any.
+	CreatedByKeyID string
+	// Resume, when set, starts the run from a previously closed bill's state
+	// so post-close signals (e.g. payment) can be handled after the original run completed.
+	Resume *Bill
+}
+
+// lateItemPolicy returns the policy for line items signaled after close, falling
+// back to RouteLateItems for runs started before LateItemPolicy existed.
+func (p *BillWorkflowParams) lateItemPolicy() LateItemPolicy {
+	switch {
+	case p.LateItemPolicy != "":
+		return p.LateItemPolicy
+	case p.RouteLateItems:
+		return LateItemPolicyNextBill
+	}
+	return LateItemPolicyReject
+}
+
+// saveFailurePolicy returns the policy for line items that could not be saved,
+// repairing them for runs started before SaveFailurePolicy existed.
+func (p *BillWorkflowParams) saveFailurePolicy() SaveFailurePolicy {
+	if p.SaveFailurePolicy != "" {
+		return p.SaveFailurePolicy
+	}
+	return SaveFailurePolicyRepair
 }
 
 // UpsertBillActivityParams defines parameters for UpsertBillActivity.
 type UpsertBillActivityParams struct {
 	BillID     string
+	TenantID   string
 	CustomerID string
 	Currency   string
 	Status     BillStatus
 	CreatedAt  time.Time
+	// CreatedByKeyID is the API key that created the bill, if any.
+	CreatedByKeyID string
+	// TemplateID is the bill template the bill was created from, if any.
+	TemplateID string
+	// ParentBillID is the bill a sub-bill rolls up to, if any.
+	ParentBillID string
+	DueDate      *time.Time
+	PeriodEnd    *time.Time
+	Rounding     *rounding.Policy
+	Version      int64
 }
 
 // SaveLineItemActivityParams defines parameters for SaveLineItemActivity.
@@ -133,7 +664,25 @@ type SaveLineItemActivityParams struct {
 	BillID      string
 	Description string
 	Amount      float64
+	Type        LineItemType
+	ReasonCode  string
+	Reference   *LineItemReference
+	Quantity    float64
+	UnitPrice   float64
+	Unit        string
+	Metric      string
+	Drawdown    float64
+	Waived      float64
 	CreatedAt   time.Time
+	RoutedFrom  *RoutedFrom
+	SubBillID   string
+	Late        bool
+	OverHardCap bool
+	// CreatedByKeyID is the API key that added the item, if any.
+	CreatedByKeyID  string
+	ClientReference string
+	// BillVersion is the bill's version once the item was added.
+	BillVersion int64
 }
 
 // UpdateBillOnCloseActivityParams defines parameters for UpdateBillStatusAndTotalActivity.
@@ -142,4 +691,25 @@ type UpdateBillOnCloseActivityParams struct {
 	Status      BillStatus
 	TotalAmount float64
 	ClosedAt    time.Time
+	// ClosedByKeyID is the API key that requested the close, or that approved it
+	// for bills held for approval.
+	ClosedByKeyID string
+	Adjustment    *BillAdjustment
+	AppliedCredit *AppliedCredit
+	Approval      *BillApproval
+	DueDate       *time.Time
+	Version       int64
+}
+
+// UpdateBillStatusActivityParams defines parameters for UpdateBillStatusActivity.
+type UpdateBillStatusActivityParams struct {
+	BillID string
+	Status BillStatus
+	// ActorKeyID is the API key whose request changed the status, if any.
+	ActorKeyID string
+	// Approval, when set, is saved along with the status.
+	Approval *BillApproval
+	// DueDate is the bill's due date, recorded with an OVERDUE status.
+	DueDate *time.Time
+	Version int64
 }
@@ -1,8 +1,17 @@
 package fees
 
-import "encore.dev"
+import (
+	"os"
 
+	"encore.dev"
+)
+
+// getTaskQueueName returns the shared task queue: FEES_TASK_QUEUE if set, otherwise
+// one named after the Encore environment.
 func getTaskQueueName() string {
+	if name := os.Getenv("FEES_TASK_QUEUE"); name != "" {
+		return name
+	}
 	envName := encore.Meta().Environment.Name
 	return envName + "_FEES_TASK_QUEUE"
 }
@@ -0,0 +1,97 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"encore.app/apierr"
+)
+
+// touch records a change to the bill by bumping its version.
+func (w *billWorkflow) touch() {
+	w.bill.Version++
+}
+
+// outdated reports whether a signal sent with ifVersion must be dropped because the bill
+// has changed since the sender read it. A zero ifVersion is never outdated.
+func (w *billWorkflow) outdated(signalName string, ifVersion int64) bool {
+	if ifVersion == 0 || ifVersion == w.bill.Version {
+		return false
+	}
+	w.logger.Warn("Signal sent for an outdated bill version, ignoring.", "bill_id", w.bill.ID, "signal", signalName, "if_version", ifVersion, "version", w.bill.Version)
+	return true
+}
+
+// billETag formats a bill version as an entity tag.
+func billETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// parseIfMatch parses an If-Match header holding a bill version, as a bare number or an
+// entity tag from billETag. An empty header or "*" matches any version and returns 0.
+func parseIfMatch(header string) (int64, error) {
+	value := strings.TrimPrefix(strings.TrimSpace(header), "W/")
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, apierr.InvalidArgument(apierr.InvalidParameter, "invalid If-Match %q: must be a bill version such as \"3\"", header)
+	}
+	return version, nil
+}
+
+// checkVersion fails with version_mismatch unless the bill is at version, or version is 0.
+func checkVersion(bill *Bill, version int64) error {
+	if version == 0 || bill.Version == version {
+		return nil
+	}
+	return apierr.FailedPrecondition(apierr.VersionMismatch, "bill %s is at version %d, not %d; re-read it and retry", bill.ID, bill.Version, version)
+}
+
+// stateToken formats the bill version a client may ask GetBill to wait for. Clients
+// treat it as opaque, so it can later carry more than a version.
+func stateToken(version int64) string {
+	return "v" + strconv.FormatInt(version, 10)
+}
+
+// nextStateToken is the state token of a change just signaled to bill: every change a
+// bill workflow applies bumps its version past the one the change was checked against.
+func nextStateToken(bill *Bill) string {
+	return stateToken(bill.Version + 1)
+}
+
+// parseStateToken parses a state token from stateToken into the bill version it stands for.
+func parseStateToken(token string) (int64, error) {
+	version, err := strconv.ParseInt(strings.TrimPrefix(token, "v"), 10, 64)
+	if err != nil || version <= 0 || !strings.HasPrefix(token, "v") {
+		return 0, apierr.InvalidArgument(apierr.InvalidParameter, "invalid minStateToken %q: must be a stateToken returned by a change to the bill", token)
+	}
+	return version, nil
+}
+
+// awaitBillVersion reads the bill until it is at least at version, for GetBill's
+// minStateToken. It gives up after stateTokenWaitTimeout, such as when the change the
+// token came from was dropped by the workflow.
+func (s *Service) awaitBillVersion(ctx context.Context, billID string, version int64, includeDeleted bool) (*GetBillResponse, error) {
+	var resp *GetBillResponse
+	err := s.poll(ctx, stateTokenWaitTimeout, stateTokenPollInterval, func() (bool, error) {
+		var err error
+		resp, err = s.readBill(ctx, billID, includeDeleted)
+		return err == nil && resp.RetrievedBill.Version >= version, err
+	})
+	switch {
+	case errors.Is(err, errPollTimeout):
+		return nil, apierr.Unavailable(apierr.StateTokenTimeout, "bill %s is still at version %d after %s, not %d; the change may have been dropped", billID, resp.RetrievedBill.Version, stateTokenWaitTimeout, version)
+	case err != nil && ctx.Err() != nil:
+		return nil, apierr.FromContext(ctx, "bill %s did not reach version %d", billID, version)
+	case err != nil:
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,77 @@
+package fees
+
+import (
+	"context"
+	"testing"
+
+	"encore.app/apierr"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIfMatch(t *testing.T) {
+	for header, want := range map[string]int64{
+		"":        0,
+		"*":       0,
+		"7":       7,
+		`"7"`:     7,
+		`W/"7"`:   7,
+		" \"12\"": 12,
+	} {
+		got, err := parseIfMatch(header)
+		require.NoError(t, err, header)
+		require.Equal(t, want, got, header)
+	}
+
+	for _, header := range []string{"0", "-1", `"abc"`, "v7"} {
+		_, err := parseIfMatch(header)
+		require.Equal(t, apierr.InvalidParameter, apierr.ReasonOf(err), header)
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	bill := &Bill{ID: "b1", Version: 3}
+	require.NoError(t, checkVersion(bill, 0))
+	require.NoError(t, checkVersion(bill, 3))
+	require.Equal(t, apierr.VersionMismatch, apierr.ReasonOf(checkVersion(bill, 2)))
+}
+
+func TestParseStateToken(t *testing.T) {
+	version, err := parseStateToken(nextStateToken(&Bill{Version: 4}))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), version)
+
+	for _, token := range []string{"5", "v0", "v-1", "vx", `"5"`} {
+		_, err := parseStateToken(token)
+		require.Equal(t, apierr.InvalidParameter, apierr.ReasonOf(err), token)
+	}
+}
+
+// TestAwaitBillVersion tests that GetBill's minStateToken re-reads the bill until it
+// reaches the token's version.
+func TestAwaitBillVersion(t *testing.T) {
+	svc, tc, clock := newClockedService(t)
+	tc.On("QueryWorkflow", mock.Anything, "bill-b1", "", GetBillDetailsQueryName).
+		Return(encodedBill{Bill{ID: "b1", Status: BillStatusOpen, Version: 2}}, nil).Once()
+	tc.On("QueryWorkflow", mock.Anything, "bill-b1", "", GetBillDetailsQueryName).
+		Return(encodedBill{Bill{ID: "b1", Status: BillStatusOpen, Version: 3, LineItems: []LineItem{{ID: "li-1", Amount: 5}}}}, nil).Once()
+
+	type result struct {
+		resp *GetBillResponse
+		err  error
+	}
+	done := make(chan result)
+	go func() {
+		resp, err := svc.GetBill(context.Background(), "b1", &GetBillParams{MinStateToken: "v3"})
+		done <- result{resp, err}
+	}()
+
+	// The overall timeout and the first poll interval are pending.
+	clock.BlockUntil(2)
+	clock.Advance(stateTokenPollInterval)
+
+	res := <-done
+	require.NoError(t, res.err)
+	require.Equal(t, int64(3), res.resp.RetrievedBill.Version)
+	require.Len(t, res.resp.RetrievedBill.LineItems, 1)
+}
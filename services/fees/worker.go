@@ -0,0 +1,120 @@
+package fees
+
+import (
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/worker"
+)
+
+// WorkerConfig tunes the Temporal worker that runs the fees workflows and activities.
+// Zero values keep the SDK defaults.
+type WorkerConfig struct {
+	// MaxConcurrentActivities caps how many activities the worker runs at once.
+	MaxConcurrentActivities int
+	// MaxConcurrentWorkflowTasks caps how many workflow tasks the worker runs at once.
+	// The SDK does not allow 1.
+	MaxConcurrentWorkflowTasks int
+	// StickyCacheSize is how many workflow executions are kept cached between tasks.
+	// It is process-wide rather than per worker.
+	StickyCacheSize int
+	// Identity names the worker in Temporal's history and UI, instead of the SDK's
+	// "<pid>@<hostname>@".
+	Identity string
+	// StopTimeout is how long Shutdown waits for running activities to finish before
+	// cancelling them.
+	StopTimeout time.Duration
+	// ActivityPolicies overrides the timeouts and retries of activity types, by name.
+	ActivityPolicies map[string]ActivityPolicy
+}
+
+// validate rejects settings the SDK would panic on or silently misapply.
+func (c WorkerConfig) validate() error {
+	if c.MaxConcurrentWorkflowTasks == 1 {
+		return fmt.Errorf("FEES_WORKER_MAX_CONCURRENT_WORKFLOW_TASKS must be at least 2, got 1")
+	}
+	if c.StopTimeout < 0 {
+		return fmt.Errorf("FEES_WORKER_STOP_TIMEOUT must not be negative, got %s", c.StopTimeout)
+	}
+	return nil
+}
+
+// options returns the worker options for c.
+func (c WorkerConfig) options(interceptors ...interceptor.WorkerInterceptor) worker.Options {
+	return worker.Options{
+		MaxConcurrentActivityExecutionSize:     c.MaxConcurrentActivities,
+		MaxConcurrentWorkflowTaskExecutionSize: c.MaxConcurrentWorkflowTasks,
+		Identity:                               c.Identity,
+		WorkerStopTimeout:                      c.StopTimeout,
+		Interceptors:                           interceptors,
+	}
+}
+
+// newWorker creates the worker for taskQueue, applying cfg. Each worker polling a
+// queue gets its own share of the concurrency limits.
+func newWorker(c client.Client, taskQueue string, cfg WorkerConfig, interceptors ...interceptor.WorkerInterceptor) worker.Worker {
+	if cfg.StickyCacheSize > 0 {
+		worker.SetStickyWorkflowCacheSize(cfg.StickyCacheSize)
+	}
+	// Workflows leave activity timeouts and retries to activityOptionsInterceptor, so
+	// every worker needs it.
+	interceptors = append([]interceptor.WorkerInterceptor{newActivityOptionsInterceptor(cfg.ActivityPolicies)}, interceptors...)
+	return worker.New(c, taskQueue, cfg.options(interceptors...))
+}
+
+// register registers the fees workflows and activities on w. Every task queue gets
+// all of them, since any workflow may be routed to a tenant's queue.
+func register(w worker.Worker, a *Activities) {
+	w.RegisterWorkflow(BillWorkflow)
+	w.RegisterWorkflow(PaymentWorkflow)
+	w.RegisterWorkflow(RefundWorkflow)
+	w.RegisterWorkflow(DunningWorkflow)
+	w.RegisterWorkflow(SubscriptionWorkflow)
+	w.RegisterWorkflow(LineItemRepairWorkflow)
+	w.RegisterWorkflow(CloseSweepWorkflow)
+	w.RegisterWorkflow(JobWorkflow)
+	w.RegisterWorkflow(RetentionSweepWorkflow)
+	w.RegisterWorkflow(ArchiveSweepWorkflow)
+	w.RegisterWorkflow(AccrualSnapshotWorkflow)
+	w.RegisterWorkflow(TestBillPurgeWorkflow)
+	w.RegisterWorkflow(DisputeWorkflow)
+
+	w.RegisterActivity(a.UpsertBillActivity)
+	w.RegisterActivity(a.SaveLineItemActivity)
+	w.RegisterActivity(a.SaveAggregatedLineItemActivity)
+	w.RegisterActivity(a.UpdateBillOnCloseActivity)
+	w.RegisterActivity(a.UpdateBillStatusActivity)
+	w.RegisterActivity(a.ChargePaymentActivity)
+	w.RegisterActivity(a.RecordPaymentActivity)
+	w.RegisterActivity(a.RecordCreditNoteActivity)
+	w.RegisterActivity(a.RefundPaymentActivity)
+	w.RegisterActivity(a.UpdateCreditNoteActivity)
+	w.RegisterActivity(a.SendNotificationActivity)
+	w.RegisterActivity(a.RouteLateLineItemActivity)
+	w.RegisterActivity(a.ProrateActivity)
+	w.RegisterActivity(a.QueueLineItemRepairActivity)
+	w.RegisterActivity(a.ApplyCreditActivity)
+	w.RegisterActivity(a.CarryForwardCreditActivity)
+	w.RegisterActivity(a.SweepBillsActivity)
+	w.RegisterActivity(a.FindCloseBatchBillsActivity)
+	w.RegisterActivity(a.EncryptLineItemsActivity)
+	w.RegisterActivity(a.RebuildBillSummariesActivity)
+	w.RegisterActivity(a.RollUpSubBillActivity)
+	w.RegisterActivity(a.SavePayerSharesActivity)
+	w.RegisterActivity(a.UpdateJobActivity)
+	w.RegisterActivity(a.PurgeExpiredBillsActivity)
+	w.RegisterActivity(a.ArchiveBillsActivity)
+	w.RegisterActivity(a.SnapshotAccrualsActivity)
+	w.RegisterActivity(a.PurgeTestBillsActivity)
+	w.RegisterActivity(a.SaveDisputeActivity)
+	w.RegisterActivity(a.DeliverBillActivity)
+}
+
+// stopWorkers stops the workers in ws.
+func stopWorkers(ws []worker.Worker) {
+	for _, w := range ws {
+		w.Stop()
+	}
+}
@@ -0,0 +1,47 @@
+package fees
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoadConfig_Worker tests that worker tuning is read from the environment and validated.
+func TestLoadConfig_Worker(t *testing.T) {
+	t.Setenv("FEES_WORKER_MAX_CONCURRENT_ACTIVITIES", "50")
+	t.Setenv("FEES_WORKER_MAX_CONCURRENT_WORKFLOW_TASKS", "20")
+	t.Setenv("FEES_WORKER_STICKY_CACHE_SIZE", "2048")
+	t.Setenv("FEES_WORKER_IDENTITY", "fees-worker-1")
+	t.Setenv("FEES_WORKER_STOP_TIMEOUT", "30s")
+
+	cfg, err := loadConfig()
+	require.NoError(t, err)
+	require.Equal(t, WorkerConfig{
+		MaxConcurrentActivities:    50,
+		MaxConcurrentWorkflowTasks: 20,
+		StickyCacheSize:            2048,
+		Identity:                   "fees-worker-1",
+		StopTimeout:                30 * time.Second,
+	}, cfg.Worker)
+
+	opts := cfg.Worker.options()
+	require.Equal(t, 50, opts.MaxConcurrentActivityExecutionSize)
+	require.Equal(t, 20, opts.MaxConcurrentWorkflowTaskExecutionSize)
+	require.Equal(t, "fees-worker-1", opts.Identity)
+	require.Equal(t, 30*time.Second, opts.WorkerStopTimeout)
+
+	for name, value := range map[string]string{
+		"FEES_WORKER_MAX_CONCURRENT_ACTIVITIES":     "-1",
+		"FEES_WORKER_MAX_CONCURRENT_WORKFLOW_TASKS": "1",
+		"FEES_WORKER_STICKY_CACHE_SIZE":             "lots",
+		"FEES_WORKER_STOP_TIMEOUT":                  "-5s",
+		"FEES_ACTIVITY_POLICIES":                    "UpdateBillOnCloseActivity=maxAttempts:-1",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			_, err := loadConfig()
+			require.Error(t, err)
+		})
+	}
+}
@@ -2,67 +2,151 @@ package fees
 
 import (
 	"fmt"
-	"time"
 
+	"encore.app/rounding"
 	"github.com/google/uuid"
+	"go.temporal.io/sdk/log"
 	"go.temporal.io/sdk/workflow"
 )
 
+// billWorkflow holds the state of a single BillWorkflow execution.
+type billWorkflow struct {
+	ctx    workflow.Context
+	logger log.Logger
+	params *BillWorkflowParams
+	bill   *Bill
+
+	// graceTimer fires when a requested close should be finalized.
+	graceTimer workflow.Future
+
+	// approvalTimer fires when a bill held for approval should be escalated.
+	approvalTimer       workflow.Future
+	cancelApprovalTimer workflow.CancelFunc
+
+	// dunning is the running DunningWorkflow child, if any.
+	dunning       workflow.ChildWorkflowFuture
+	cancelDunning workflow.CancelFunc
+
+	// closeRequestedBy is the API key whose CloseBillSignal led to the close.
+	closeRequestedBy string
+
+	// pendingClose is the close write of a CLOSE_FAILED bill, held until a retry saves
+	// it. closeRetryTimer fires when the workflow retries it on its own.
+	pendingClose          *UpdateBillOnCloseActivityParams
+	closeRetryTimer       workflow.Future
+	cancelCloseRetryTimer workflow.CancelFunc
+
+	// overdueTimer fires at the due date of a closed bill that awaits payment.
+	overdueTimer       workflow.Future
+	cancelOverdueTimer workflow.CancelFunc
+}
+
+// saveMaxAttempts bounds how often saving a line item or a close is attempted before
+// the workflow compensates for the failure.
+const saveMaxAttempts = 5
+
 // BillWorkflow manages the lifecycle of a single bill.
-func BillWorkflow(ctx workflow.Context, params *BillWorkflowParams) (respBill *Bill, respErr error) {
+func BillWorkflow(ctx workflow.Context, params *BillWorkflowParams) (*Bill, error) {
 	logger := workflow.GetLogger(ctx)
 	var workflowErr error
 
-	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
-		StartToCloseTimeout: 10 * time.Second,
-	})
-
-	defer func() {
-		if r := recover(); r != nil {
-			logger.Error("BillWorkflow panicked", "panic", r)
-			respErr = fmt.Errorf("Workflow panic: %v", r)
+	w := &billWorkflow{ctx: ctx, logger: logger, params: params}
+
+	if params.Resume != nil {
+		// A settled-phase run started for a bill whose previous run already closed it.
+		resumed := *params.Resume
+		w.bill = &resumed
+		logger.Info("BillWorkflow resumed", "bill_id", w.bill.ID, "status", w.bill.Status)
+	} else {
+		billID := params.BillID
+		if billID == "" {
+			generatedID, idErr := generateID(ctx)
+			if idErr != nil {
+				logger.Error("Failed to generate BillID", "error", idErr)
+				return nil, fmt.Errorf("failed to generate BillID: %w", idErr)
+			}
+			billID = generatedID
 		}
-	}()
 
-	billID := params.BillID
-	if billID == "" {
-		generatedID, idErr := generateID(ctx)
-		if idErr != nil {
-			logger.Error("Failed to generate BillID", "error", idErr)
-			return nil, fmt.Errorf("failed to generate BillID: %w", idErr)
+		createdAt := workflow.Now(ctx)
+		w.bill = &Bill{
+			ID:             billID,
+			TenantID:       params.TenantID,
+			CustomerID:     params.CustomerID,
+			Currency:       params.Currency,
+			Status:         BillStatusOpen,
+			LineItems:      make([]LineItem, 0),
+			CreatedAt:      &createdAt,
+			DueDate:        params.DueDate,
+			PeriodEnd:      params.PeriodEnd,
+			AutoCollect:    params.AutoCollect,
+			CreatedByKeyID: params.CreatedByKeyID,
+			Test:           isTestBill(billID),
+			FollowUpOf:     params.FollowUpOf,
+			ParentBillID:   params.ParentBillID,
+			TemplateID:     params.TemplateID,
+			SpendingAlerts: params.SpendingAlerts,
+			HardCap:        params.BillLimits.hardCap(),
+			Aggregation:    params.Aggregation,
+			UsagePrices:    params.UsagePrices,
+			FreeTiers:      params.FreeTiers,
+			Coupons:        params.Coupons,
+			Version:        1,
+		}
+		if params.hasTrial() {
+			w.bill.TrialStart = params.TrialStart
+			if w.bill.TrialStart == nil {
+				w.bill.TrialStart = &createdAt
+			}
+		}
+		if params.RoundingMode != "" {
+			policy := rounding.ForCurrency(params.Currency, params.RoundingMode)
+			w.bill.Rounding = &policy
 		}
-		billID = generatedID
-	}
-
-	createdAt := workflow.Now(ctx)
-	bill := &Bill{
-		ID:         billID,
-		CustomerID: params.CustomerID,
-		Currency:   params.Currency,
-		Status:     BillStatusOpen,
-		LineItems:  make([]LineItem, 0),
-		CreatedAt:  &createdAt,
-	}
 
-	logger.Info("BillWorkflow started", "BillID", bill.ID)
+		logger.Info("BillWorkflow started", "bill_id", w.bill.ID)
+
+		upsertParams := UpsertBillActivityParams{
+			BillID:         w.bill.ID,
+			TenantID:       w.bill.TenantID,
+			CustomerID:     w.bill.CustomerID,
+			Currency:       w.bill.Currency,
+			Status:         w.bill.Status,
+			CreatedAt:      *w.bill.CreatedAt,
+			CreatedByKeyID: w.bill.CreatedByKeyID,
+			TemplateID:     w.bill.TemplateID,
+			ParentBillID:   w.bill.ParentBillID,
+			DueDate:        w.bill.DueDate,
+			PeriodEnd:      w.bill.PeriodEnd,
+			Rounding:       w.bill.Rounding,
+			Version:        w.bill.Version,
+		}
 
-	upsertParams := UpsertBillActivityParams{
-		BillID:     bill.ID,
-		CustomerID: bill.CustomerID,
-		Currency:   bill.Currency,
-		Status:     bill.Status,
-		CreatedAt:  *bill.CreatedAt,
-	}
+		// Activity: Upsert bill
+		err := workflow.ExecuteActivity(ctx, UpsertBillActivityName, upsertParams).Get(ctx, nil)
+		if err != nil {
+			logger.Error("Failed to execute UpsertBillActivity", "bill_id", w.bill.ID, "error", err)
+			return nil, fmt.Errorf("UpsertBillActivity failed: %w", err)
+		}
 
-	// Activity: Upsert bill
-	err := workflow.ExecuteActivity(ctx, UpsertBillActivityName, upsertParams).Get(ctx, nil)
-	if err != nil {
-		logger.Error("Failed to execute UpsertBillActivity", "BillID", bill.ID, "error", err)
-		return nil, fmt.Errorf("UpsertBillActivity failed: %w", err)
+		if params.Contract != nil {
+			w.billCommitment(params.Contract)
+		}
+		// Seed the template's recurring items before any signal is handled.
+		for i, item := range params.TemplateItems {
+			w.addLineItem(AddLineItemSignal{
+				LineItemID:     templateItemID(w.bill.ID, params.TemplateID, i),
+				Description:    item.Description,
+				Amount:         item.Amount,
+				CreatedByKeyID: params.CreatedByKeyID,
+				NotUsage:       true,
+			})
+		}
 	}
+	bill := w.bill
 
 	// Set up query handler
-	err = workflow.SetQueryHandler(ctx, GetBillDetailsQueryName, func() (*Bill, error) {
+	err := workflow.SetQueryHandler(ctx, GetBillDetailsQueryName, func() (*Bill, error) {
 		return bill, nil
 	})
 	if err != nil {
@@ -71,7 +155,7 @@ func BillWorkflow(ctx workflow.Context, params *BillWorkflowParams) (respBill *B
 	}
 
 	// Main workflow loop to process signals
-	for bill.Status == BillStatusOpen && workflowErr == nil {
+	for bill.isFinalizing() && workflowErr == nil {
 		selector := workflow.NewSelector(ctx)
 
 		// Handle AddLineItemSignal
@@ -82,113 +166,514 @@ func BillWorkflow(ctx workflow.Context, params *BillWorkflowParams) (respBill *B
 				logger.Info("AddLineItemSignal channel closed.")
 				return
 			}
-
-			if bill.Status != BillStatusOpen {
-				logger.Warn("AddLineItemSignal received for a non-open bill, ignoring.", "BillID", bill.ID, "BillStatus", bill.Status, "AttemptedLineItemID", signal.LineItemID)
-				return
-			}
-
-			lineItemID := signal.LineItemID
-			if lineItemID == "" {
-				generatedID, idErr := generateID(ctx)
-				if idErr != nil {
-					logger.Error("Failed to generate LineItemID for bill", "BillID", bill.ID, "error", idErr)
-					return
-				}
-				lineItemID = generatedID
-			}
-
-			for _, item := range bill.LineItems {
-				if item.ID == lineItemID {
-					logger.Info("Duplicate LineItemID received, ignoring.", "BillID", bill.ID, "LineItemID", lineItemID)
-					return
-				}
-			}
-
-			itemCreatedAt := workflow.Now(ctx)
-			newLineItem := LineItem{
-				ID:          lineItemID,
-				Description: signal.Description,
-				Amount:      signal.Amount,
-			}
-
-			// Add to workflow state first
-			bill.LineItems = append(bill.LineItems, newLineItem)
-			logger.Info("Line item added to workflow state prior to saving", "BillID", bill.ID, "LineItemID", newLineItem.ID, "Amount", newLineItem.Amount)
-
-			// Recalculate total amount after adding the new line item to the workflow state
-			currentTotal := 0.0
-			for _, item := range bill.LineItems {
-				currentTotal += item.Amount
-			}
-			bill.TotalAmount = currentTotal
-			logger.Info("Updated bill.TotalAmount in workflow state", "BillID", bill.ID, "NewTotalAmount", bill.TotalAmount)
-
-			saveLineItemParams := SaveLineItemActivityParams{
-				LineItemID:  newLineItem.ID,
-				BillID:      bill.ID,
-				Description: newLineItem.Description,
-				Amount:      newLineItem.Amount,
-				CreatedAt:   itemCreatedAt,
-			}
-
-			// Activity: Save new line item
-			actErr := workflow.ExecuteActivity(ctx, SaveLineItemActivityName, saveLineItemParams).Get(ctx, nil)
-			if actErr != nil {
-				logger.Error("Failed to execute SaveLineItemActivity", "BillID", bill.ID, "LineItemID", newLineItem.ID, "Description", newLineItem.Description, "Amount", newLineItem.Amount, "error", actErr)
-			} else {
-				logger.Info("Successfully saved line item via activity", "BillID", bill.ID, "LineItemID", newLineItem.ID)
-			}
+			w.addLineItem(signal)
 		})
 
 		// Handle CloseBillSignal
 		selector.AddReceive(workflow.GetSignalChannel(ctx, CloseBillSignalName), func(c workflow.ReceiveChannel, more bool) {
-			c.Receive(ctx, nil)
+			var signal CloseBillSignal
+			c.Receive(ctx, &signal)
 			if !more {
 				logger.Info("CloseBillSignal channel closed.")
 				return
 			}
+			w.requestClose(signal)
+		})
 
-			total := 0.0
-			for _, item := range bill.LineItems {
-				total += item.Amount
-			}
+		// Handle ApproveBillSignal and RejectBillSignal
+		selector.AddReceive(workflow.GetSignalChannel(ctx, ApproveBillSignalName), func(c workflow.ReceiveChannel, more bool) {
+			var signal ApproveBillSignal
+			c.Receive(ctx, &signal)
+			w.approve(signal)
+		})
+		selector.AddReceive(workflow.GetSignalChannel(ctx, RejectBillSignalName), func(c workflow.ReceiveChannel, more bool) {
+			var signal RejectBillSignal
+			c.Receive(ctx, &signal)
+			w.reject(signal)
+		})
 
-			closedAtTimeSnapshot := workflow.Now(ctx)
-			updateBillParams := UpdateBillOnCloseActivityParams{
-				BillID:      bill.ID,
-				Status:      BillStatusClosed,
-				TotalAmount: total,
-				ClosedAt:    closedAtTimeSnapshot,
-			}
+		// Handle RetryCloseSignal
+		selector.AddReceive(workflow.GetSignalChannel(ctx, RetryCloseSignalName), func(c workflow.ReceiveChannel, more bool) {
+			var signal RetryCloseSignal
+			c.Receive(ctx, &signal)
+			w.retryClose(signal)
+		})
 
-			logger.Info("Executing UpdateBillOnCloseActivity", "BillID", bill.ID)
-			actErr := workflow.ExecuteActivity(ctx, UpdateBillOnCloseActivityName, updateBillParams).Get(ctx, nil)
-			if actErr != nil {
-				logger.Error("Failed to execute UpdateBillOnCloseActivity", "BillID", bill.ID, "error", actErr)
-			}
+		// Handle SetSpendingAlertsSignal
+		selector.AddReceive(workflow.GetSignalChannel(ctx, SetSpendingAlertsSignalName), func(c workflow.ReceiveChannel, more bool) {
+			var signal SetSpendingAlertsSignal
+			c.Receive(ctx, &signal)
+			w.setSpendingAlerts(signal)
+		})
+
+		// Handle SetPayerSplitsSignal
+		selector.AddReceive(workflow.GetSignalChannel(ctx, SetPayerSplitsSignalName), func(c workflow.ReceiveChannel, more bool) {
+			var signal SetPayerSplitsSignal
+			c.Receive(ctx, &signal)
+			w.setPayerSplits(signal)
+		})
 
-			// Closing here, but in prod, we will have to retry before marking the bill closed
-			bill.Status = BillStatusClosed
-			bill.ClosedAt = &closedAtTimeSnapshot
-			bill.TotalAmount = total
-			logger.Info("Bill marked as closed in workflow state", "BillID", bill.ID, "TotalAmount", bill.TotalAmount, "ActivitySuccess", actErr == nil)
+		// Handle ApplyCouponSignal
+		selector.AddReceive(workflow.GetSignalChannel(ctx, ApplyCouponSignalName), func(c workflow.ReceiveChannel, more bool) {
+			var signal ApplyCouponSignal
+			c.Receive(ctx, &signal)
+			w.applyCoupon(signal)
 		})
 
+		// Finalize once the close grace period has elapsed
+		if w.graceTimer != nil {
+			selector.AddFuture(w.graceTimer, func(f workflow.Future) {
+				if err := f.Get(ctx, nil); err != nil {
+					logger.Error("Close grace timer failed", "bill_id", bill.ID, "error", err)
+				}
+				logger.Info("Close grace period elapsed, finalizing bill", "bill_id", bill.ID)
+				w.graceTimer = nil
+				w.finalize()
+			})
+		}
+
+		// Escalate a bill that has waited too long for approval
+		if w.approvalTimer != nil {
+			selector.AddFuture(w.approvalTimer, func(f workflow.Future) {
+				if err := f.Get(ctx, nil); err != nil {
+					logger.Error("Approval escalation timer failed", "bill_id", bill.ID, "error", err)
+				}
+				w.escalateApproval()
+			})
+		}
+
+		// Retry saving a close that failed
+		if w.closeRetryTimer != nil {
+			selector.AddFuture(w.closeRetryTimer, func(f workflow.Future) {
+				if err := f.Get(ctx, nil); err != nil {
+					logger.Error("Close retry timer failed", "bill_id", bill.ID, "error", err)
+				}
+				w.retryClose(RetryCloseSignal{})
+			})
+		}
+
 		// Block until a signal is received or workflow is canceled
 		selector.Select(ctx)
 
 		// If a signal handler set an error (e.g. from a hypothetical critical signal activity not covered here), break the loop.
 		if workflowErr != nil {
-			logger.Error("Workflow loop terminating due to critical signal processing error", "BillID", bill.ID, "error", workflowErr)
+			logger.Error("Workflow loop terminating due to critical signal processing error", "bill_id", bill.ID, "error", workflowErr)
 			break
 		}
 	}
 
-	logger.Info("BillWorkflow completed", "BillID", bill.ID, "Status", bill.Status)
+	if workflowErr == nil {
+		w.settle()
+	}
+
+	logger.Info("BillWorkflow completed", "bill_id", bill.ID, "status", bill.Status)
 	return bill, workflowErr
 }
 
+// isFinalizing reports whether the bill has not closed yet: it is open, closing,
+// awaiting approval, or waiting for its close to be retried.
+func (b *Bill) isFinalizing() bool {
+	return b.acceptsLineItems() || b.Status == BillStatusPendingApproval || b.Status == BillStatusCloseFailed
+}
+
+// lineItemTotal sums the bill's line items, rounded by the bill's rounding policy.
+func (b *Bill) lineItemTotal() float64 {
+	total := 0.0
+	for _, item := range b.LineItems {
+		total += item.Amount
+	}
+	return b.round(total)
+}
+
+// round rounds an amount of the bill by its rounding policy, or to four decimals if
+// it has none.
+func (b *Bill) round(v float64) float64 {
+	if b.Rounding == nil {
+		return roundAmount(v)
+	}
+	return b.Rounding.Round(v)
+}
+
+// lineItem returns the bill's item with the given ID or, if reference is set, client
+// reference, or nil if there is none.
+func (b *Bill) lineItem(id, reference string) *LineItem {
+	for i, item := range b.LineItems {
+		if item.ID == id || (reference != "" && item.ClientReference == reference) {
+			return &b.LineItems[i]
+		}
+	}
+	return nil
+}
+
+// acceptsLineItems reports whether line items can still be added to the bill itself,
+// rather than being routed to the customer's next bill.
+func (b *Bill) acceptsLineItems() bool {
+	return b.Status == BillStatusOpen || b.Status == BillStatusClosing
+}
+
+// addLineItem applies an AddLineItemSignal to an open or closing bill and persists
+// the new item. Items added while the bill is closing are flagged as late.
+func (w *billWorkflow) addLineItem(signal AddLineItemSignal) {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	if w.outdated(AddLineItemSignalName, signal.IfVersion) {
+		return
+	}
+	if !bill.acceptsLineItems() {
+		if signal.SubBillID != "" {
+			w.routeLateItem(signal)
+			return
+		}
+		logger.Warn("AddLineItemSignal received for a non-open bill, ignoring.", "bill_id", bill.ID, "bill_status", bill.Status, "attempted_line_item_id", signal.LineItemID)
+		return
+	}
+
+	lineItemID := signal.LineItemID
+	if lineItemID == "" {
+		generatedID, idErr := generateID(ctx)
+		if idErr != nil {
+			logger.Error("Failed to generate LineItemID for bill", "bill_id", bill.ID, "error", idErr)
+			return
+		}
+		lineItemID = generatedID
+	}
+
+	if existing := bill.lineItem(lineItemID, signal.ClientReference); existing != nil {
+		logger.Info("Duplicate line item received, ignoring.", "bill_id", bill.ID, "line_item_id", lineItemID, "client_reference", signal.ClientReference, "existing_line_item_id", existing.ID)
+		return
+	}
+
+	amount := signal.Amount
+	if signal.Quantity != 0 {
+		amount = lineItemAmount(signal.Quantity, signal.UnitPrice)
+	}
+	itemType := signal.Type
+	if itemType == "" {
+		itemType = LineItemCharge
+	}
+	reasonCode := signal.ReasonCode
+	rated := false
+	if signal.Metric != "" {
+		// Rated now rather than by the API, so items of the same metric that raced each
+		// other are priced against each other's usage.
+		amount, rated = bill.rateUsage(signal.Metric, signal.Quantity)
+	}
+	itemCreatedAt := workflow.Now(ctx)
+	amount, waived, trial := bill.waive(signal, itemType, amount, itemCreatedAt)
+	if signal.Metric != "" && amount < 0 {
+		itemType, reasonCode = LineItemAdjustment, ReasonCodeUsageRepricing
+	}
+	var drawdown float64
+	if bill.drawsDown(signal, itemType) {
+		amount, drawdown = bill.overage(bill.round(amount))
+	}
+	// Usage the commitment covers or a free tier waives is kept item by item; only what
+	// is billed in full is aggregated.
+	if drawdown == 0 && waived == 0 && bill.Aggregation.aggregates(signal) {
+		w.aggregateLineItem(signal, lineItemID, amount)
+		return
+	}
+	newLineItem := LineItem{
+		ID:              lineItemID,
+		Description:     signal.Description,
+		Amount:          bill.round(amount),
+		Type:            itemType,
+		ReasonCode:      reasonCode,
+		Reference:       signal.Reference,
+		Quantity:        signal.Quantity,
+		UnitPrice:       signal.UnitPrice,
+		Unit:            signal.Unit,
+		RoutedFrom:      signal.RoutedFrom,
+		SubBillID:       signal.SubBillID,
+		Late:            bill.Status == BillStatusClosing,
+		CreatedByKeyID:  signal.CreatedByKeyID,
+		ClientReference: signal.ClientReference,
+		Metric:          signal.Metric,
+		Drawdown:        drawdown,
+	}
+	if signal.Metric != "" && !rated {
+		w.recordRejectedLineItem(newLineItem, unpricedMetric(signal.Metric))
+		logger.Warn("Line item rejected for a metric the bill does not price", "bill_id", bill.ID, "line_item_id", newLineItem.ID, "metric", signal.Metric)
+		return
+	}
+	if bill.HardCap.rejects(bill.lineItemTotal(), newLineItem.Amount) {
+		w.rejectLineItem(newLineItem)
+		return
+	}
+	// Checked again here, as items the API let through may have raced each other. Usage
+	// repricing only gives back what the metric's earlier items billed, so it passes.
+	if !signal.AllowNegativeTotal && !rated && bill.takesTotalNegative(newLineItem.Amount) {
+		w.rejectNegativeTotal(newLineItem)
+		return
+	}
+	newLineItem.OverHardCap = bill.HardCap.exceededBy(bill.lineItemTotal(), newLineItem.Amount)
+
+	// Add to workflow state first
+	bill.LineItems = append(bill.LineItems, newLineItem)
+	w.touch()
+	logger.Info("Line item added to workflow state prior to saving", "bill_id", bill.ID, "line_item_id", newLineItem.ID, "amount", newLineItem.Amount)
+
+	// Recalculate total amount after adding the new line item to the workflow state
+	bill.TotalAmount = bill.lineItemTotal()
+	bill.refreshCommitment()
+	logger.Info("Updated bill.TotalAmount in workflow state", "bill_id", bill.ID, "new_total_amount", bill.TotalAmount)
+
+	saveLineItemParams := SaveLineItemActivityParams{
+		LineItemID:      newLineItem.ID,
+		BillID:          bill.ID,
+		Description:     newLineItem.Description,
+		Amount:          newLineItem.Amount,
+		Type:            newLineItem.Type,
+		ReasonCode:      newLineItem.ReasonCode,
+		Reference:       newLineItem.Reference,
+		Quantity:        newLineItem.Quantity,
+		UnitPrice:       newLineItem.UnitPrice,
+		Unit:            newLineItem.Unit,
+		Metric:          newLineItem.Metric,
+		Drawdown:        newLineItem.Drawdown,
+		CreatedAt:       itemCreatedAt,
+		RoutedFrom:      newLineItem.RoutedFrom,
+		SubBillID:       newLineItem.SubBillID,
+		Late:            newLineItem.Late,
+		OverHardCap:     newLineItem.OverHardCap,
+		CreatedByKeyID:  newLineItem.CreatedByKeyID,
+		ClientReference: newLineItem.ClientReference,
+		BillVersion:     bill.Version,
+	}
+
+	// Activity: Save new line item
+	actErr := workflow.ExecuteActivity(ctx, SaveLineItemActivityName, saveLineItemParams).Get(ctx, nil)
+	if actErr != nil {
+		logger.Error("Failed to execute SaveLineItemActivity", "bill_id", bill.ID, "line_item_id", newLineItem.ID, "description", newLineItem.Description, "amount", newLineItem.Amount, "error", actErr)
+		w.compensateSave(saveLineItemParams, actErr, false)
+	} else {
+		logger.Info("Successfully saved line item via activity", "bill_id", bill.ID, "line_item_id", newLineItem.ID)
+	}
+	if waived != 0 {
+		w.addWaiverItem(newLineItem, waived, trial)
+	}
+	w.checkSpendingAlerts()
+}
+
+// requestClose closes the bill, or moves it to CLOSING when the request or the bill
+// has a grace period, so late-arriving line items are still accepted in the meantime.
+func (w *billWorkflow) requestClose(signal CloseBillSignal) {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	if w.outdated(CloseBillSignalName, signal.IfVersion) {
+		return
+	}
+	if bill.Status != BillStatusOpen {
+		logger.Info("CloseBillSignal received while close is already pending, ignoring.", "bill_id", bill.ID, "bill_status", bill.Status, "finalizes_at", bill.FinalizesAt)
+		return
+	}
+	w.closeRequestedBy = signal.RequestedByKeyID
+	if signal.DueDate != nil {
+		bill.DueDate = signal.DueDate
+	}
+
+	grace := w.params.CloseGracePeriod
+	if signal.GracePeriod != nil {
+		grace = *signal.GracePeriod
+	}
+	if grace <= 0 {
+		w.finalize()
+		return
+	}
+
+	requestedAt := workflow.Now(ctx)
+	finalizesAt := requestedAt.Add(grace)
+	bill.CloseRequestedAt = &requestedAt
+	bill.FinalizesAt = &finalizesAt
+	w.graceTimer = workflow.NewTimer(ctx, grace)
+	w.setStatus(BillStatusClosing)
+	logger.Info("Close requested, holding finalization for grace period", "bill_id", bill.ID, "grace_period", grace, "finalizes_at", finalizesAt)
+}
+
+// finalize closes the bill, unless its total, once discounted by its coupons and
+// adjusted against the customer's bill limits, requires approval first.
+func (w *billWorkflow) finalize() {
+	total := w.bill.discounted(w.bill.lineItemTotal())
+	if adj := w.limitAdjustment(total); adj != nil {
+		total += adj.Amount
+	}
+	if w.params.Approval.requires(total) {
+		w.requestApproval(total)
+		return
+	}
+	w.close()
+}
+
+// close finalizes the bill total, taking off its coupons and adjusting it against the
+// customer's bill limits, and saves the close. The bill is marked closed once the
+// close is saved, or CLOSE_FAILED if saving it keeps failing.
+func (w *billWorkflow) close() {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	w.touch()
+	total := w.addCouponItems(bill.lineItemTotal())
+	if adj := w.limitAdjustment(total); adj != nil {
+		w.addAdjustmentItem(adj)
+		bill.Adjustment = adj
+		total = bill.round(total + adj.Amount)
+		logger.Info("Bill total adjusted against customer limits", "bill_id", bill.ID, "kind", adj.Kind, "limit", adj.Limit, "total_before", adj.TotalBefore, "amount", adj.Amount)
+	}
+	if credit := w.applyCredit(total); credit != nil {
+		bill.AppliedCredit = credit
+		total = bill.round(total - credit.Amount)
+	}
+
+	w.saveClose(UpdateBillOnCloseActivityParams{
+		BillID:        bill.ID,
+		Status:        BillStatusClosed,
+		TotalAmount:   total,
+		ClosedAt:      workflow.Now(ctx),
+		ClosedByKeyID: w.closeRequestedBy,
+		Adjustment:    bill.Adjustment,
+		AppliedCredit: bill.AppliedCredit,
+		Approval:      bill.Approval,
+		DueDate:       bill.DueDate,
+		Version:       bill.Version,
+	})
+}
+
+// limitAdjustment returns the adjustment the bill's total needs to respect the
+// customer's bill limits, rounded by the bill's rounding policy, or nil.
+func (w *billWorkflow) limitAdjustment(total float64) *BillAdjustment {
+	return w.bill.limitAdjustment(w.params.BillLimits, total)
+}
+
+// limitAdjustment returns the adjustment a total of the bill needs to respect limits,
+// rounded by the bill's rounding policy, or nil.
+func (b *Bill) limitAdjustment(limits *BillLimits, total float64) *BillAdjustment {
+	adj := limits.adjustment(total)
+	if adj != nil {
+		adj.Amount = b.round(adj.Amount)
+	}
+	return adj
+}
+
+// addAdjustmentItem adds the line item that brings the bill's total to the limit of adj.
+// Flag-only adjustments add nothing.
+func (w *billWorkflow) addAdjustmentItem(adj *BillAdjustment) {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+	if adj.Amount == 0 {
+		return
+	}
+
+	// Derived from the bill so a replayed or retried close reuses the same item.
+	adj.LineItemID = uuid.NewSHA1(uuid.NameSpaceOID, []byte("feems/adjustment/"+bill.ID+"/"+string(adj.Kind))).String()
+	item := LineItem{ID: adj.LineItemID, Description: adj.Kind.description(), Amount: adj.Amount, Type: LineItemAdjustment, ReasonCode: ReasonCodeBillLimits}
+	bill.LineItems = append(bill.LineItems, item)
+
+	params := SaveLineItemActivityParams{
+		LineItemID:  item.ID,
+		BillID:      bill.ID,
+		Description: item.Description,
+		Amount:      item.Amount,
+		Type:        item.Type,
+		ReasonCode:  item.ReasonCode,
+		CreatedAt:   workflow.Now(ctx),
+		BillVersion: bill.Version,
+	}
+	actErr := workflow.ExecuteActivity(ctx, SaveLineItemActivityName, params).Get(ctx, nil)
+	if actErr != nil {
+		logger.Error("Failed to execute SaveLineItemActivity for adjustment", "bill_id", bill.ID, "line_item_id", item.ID, "kind", adj.Kind, "error", actErr)
+		w.compensateSave(params, actErr, true)
+	}
+}
+
+// settle runs the post-close phase of the bill: automatic payment collection,
+// dunning after a failed payment, overdue detection, and any payment, refund or late
+// line item signals delivered to the run. The workflow completes once no settlement
+// work is pending.
+func (w *billWorkflow) settle() {
+	// The payers of a split bill pay their shares; the bill itself is not collected,
+	// even when its shares could not be saved.
+	if w.bill.AutoCollect && w.bill.Status == BillStatusClosed && !w.bill.paidByPayers() {
+		w.collectPayment(PayBillSignal{})
+	}
+	w.watchDueDate()
+
+	for {
+		w.stopWatchingDueDate()
+		pending := true
+		selector := workflow.NewSelector(w.ctx)
+		selector.AddReceive(workflow.GetSignalChannel(w.ctx, PayBillSignalName), func(c workflow.ReceiveChannel, more bool) {
+			var signal PayBillSignal
+			c.Receive(w.ctx, &signal)
+			w.collectPayment(signal)
+		})
+		selector.AddReceive(workflow.GetSignalChannel(w.ctx, RecordPaymentSignalName), func(c workflow.ReceiveChannel, more bool) {
+			var signal RecordPaymentSignal
+			c.Receive(w.ctx, &signal)
+			w.recordPayment(signal)
+		})
+		selector.AddReceive(workflow.GetSignalChannel(w.ctx, PaymentWebhookSignalName), func(c workflow.ReceiveChannel, more bool) {
+			var signal PaymentWebhookSignal
+			c.Receive(w.ctx, &signal)
+			w.applyPaymentWebhook(signal)
+		})
+		selector.AddReceive(workflow.GetSignalChannel(w.ctx, RefundBillSignalName), func(c workflow.ReceiveChannel, more bool) {
+			var signal RefundBillSignal
+			c.Receive(w.ctx, &signal)
+			w.refund(signal)
+		})
+		selector.AddReceive(workflow.GetSignalChannel(w.ctx, AddLineItemSignalName), func(c workflow.ReceiveChannel, more bool) {
+			var signal AddLineItemSignal
+			c.Receive(w.ctx, &signal)
+			w.routeLateItem(signal)
+		})
+		if w.dunning != nil {
+			selector.AddFuture(w.dunning, w.finishDunning)
+		}
+		if w.overdueTimer != nil {
+			selector.AddFuture(w.overdueTimer, w.dueDateReached)
+		}
+		if w.dunning == nil && w.overdueTimer == nil {
+			selector.AddDefault(func() {
+				pending = false
+			})
+		}
+		selector.Select(w.ctx)
+		if !pending {
+			return
+		}
+	}
+}
+
+// routeLateItem forwards a line item that arrived after the bill closed to a later
+// bill per the late item policy. Under the reject policy the API refuses such items
+// up front, so only items that raced the close reach this point and are dropped.
+func (w *billWorkflow) routeLateItem(signal AddLineItemSignal) {
+	ctx, logger, bill := w.ctx, w.logger, w.bill
+
+	if w.outdated(AddLineItemSignalName, signal.IfVersion) {
+		return
+	}
+	policy := w.params.lateItemPolicy()
+	if policy == LateItemPolicyReject && signal.SubBillID != "" {
+		// A sub-bill's total is never dropped: it goes on the follow-up bill instead.
+		policy = LateItemPolicyPark
+	}
+	if policy == LateItemPolicyReject {
+		logger.Warn("AddLineItemSignal received for a non-open bill, ignoring.", "bill_id", bill.ID, "bill_status", bill.Status, "attempted_line_item_id", signal.LineItemID)
+		return
+	}
+
+	var nextID string
+	err := workflow.ExecuteActivity(ctx, RouteLateLineItemActivityName, RouteLateLineItemActivityParams{
+		Bill:   *bill,
+		Signal: signal,
+		Policy: policy,
+	}).Get(ctx, &nextID)
+	if err != nil {
+		logger.Error("Failed to execute RouteLateLineItemActivity", "bill_id", bill.ID, "line_item_id", signal.LineItemID, "error", err)
+		return
+	}
+	logger.Info("Late line item routed to next bill", "bill_id", bill.ID, "line_item_id", signal.LineItemID, "next_bill_id", nextID, "policy", policy)
+}
+
 // Helper to generate UUIDs if needed within workflow/activity (though often IDs are passed in)
 func generateID(ctx workflow.Context) (string, error) {
 	var id string
@@ -0,0 +1,238 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"encore.app/apierr"
+	"github.com/google/uuid"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/api/workflowservice/v1"
+)
+
+// billWorkflowID returns the ID of the workflow that runs a bill. Test bills' workflows
+// have a prefix of their own, so they stand out in Temporal.
+func billWorkflowID(billID string) string {
+	if id, ok := strings.CutPrefix(billID, testBillIDPrefix); ok {
+		return testBillWorkflowIDPrefix + id
+	}
+	return "bill-" + billID
+}
+
+// BillWorkflowDescription describes the workflow that runs a bill.
+type BillWorkflowDescription struct {
+	BillID     string `json:"billId"`
+	WorkflowID string `json:"workflowId"`
+	// RunID is the bill's latest run; a bill resumed for payment has several.
+	RunID     string     `json:"runId"`
+	Status    string     `json:"status"`
+	TaskQueue string     `json:"taskQueue"`
+	StartedAt time.Time  `json:"startedAt"`
+	ClosedAt  *time.Time `json:"closedAt,omitempty"`
+	// HistoryLength is the number of events in the run's history.
+	HistoryLength int64 `json:"historyLength"`
+	// PendingActivities lists the activities the run is waiting on, with their retries.
+	PendingActivities []PendingActivity `json:"pendingActivities"`
+	// LastFailure is the most recent failure of a pending activity, the usual reason a
+	// bill is stuck.
+	LastFailure string `json:"lastFailure,omitempty"`
+	// ResetPoints lists the events the run can be reset to, oldest first.
+	ResetPoints []WorkflowResetPoint `json:"resetPoints"`
+}
+
+// PendingActivity is an activity a bill workflow is waiting on.
+type PendingActivity struct {
+	ActivityID string `json:"activityId"`
+	Type       string `json:"type"`
+	State      string `json:"state"`
+	Attempt    int32  `json:"attempt"`
+	// LastFailure is the error of the activity's last failed attempt.
+	LastFailure     string     `json:"lastFailure,omitempty"`
+	LastStartedAt   *time.Time `json:"lastStartedAt,omitempty"`
+	NextAttemptAt   *time.Time `json:"nextAttemptAt,omitempty"`
+	LastHeartbeatAt *time.Time `json:"lastHeartbeatAt,omitempty"`
+}
+
+// WorkflowResetPoint is an event a bill workflow can be reset to.
+type WorkflowResetPoint struct {
+	RunID string `json:"runId"`
+	// EventID is the workflow task completed event to pass to the reset endpoint.
+	EventID   int64     `json:"eventId"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Resettable is false for points Temporal no longer allows resetting to.
+	Resettable bool `json:"resettable"`
+}
+
+// describeBillWorkflow builds the description of a bill's workflow from Temporal's.
+func describeBillWorkflow(billID string, desc *workflowservice.DescribeWorkflowExecutionResponse) *BillWorkflowDescription {
+	info := desc.GetWorkflowExecutionInfo()
+	d := &BillWorkflowDescription{
+		BillID:            billID,
+		WorkflowID:        info.GetExecution().GetWorkflowId(),
+		RunID:             info.GetExecution().GetRunId(),
+		Status:            info.GetStatus().String(),
+		TaskQueue:         info.GetTaskQueue(),
+		StartedAt:         info.GetStartTime().AsTime(),
+		HistoryLength:     info.GetHistoryLength(),
+		PendingActivities: []PendingActivity{},
+		ResetPoints:       []WorkflowResetPoint{},
+	}
+	if info.GetCloseTime() != nil {
+		closedAt := info.GetCloseTime().AsTime()
+		d.ClosedAt = &closedAt
+	}
+	var lastFailedAt time.Time
+	for _, pa := range desc.GetPendingActivities() {
+		activity := PendingActivity{
+			ActivityID:  pa.GetActivityId(),
+			Type:        pa.GetActivityType().GetName(),
+			State:       pa.GetState().String(),
+			Attempt:     pa.GetAttempt(),
+			LastFailure: pa.GetLastFailure().GetMessage(),
+		}
+		if t := pa.GetLastStartedTime(); t != nil {
+			ts := t.AsTime()
+			activity.LastStartedAt = &ts
+		}
+		if t := pa.GetScheduledTime(); t != nil && pa.GetAttempt() > 1 {
+			ts := t.AsTime()
+			activity.NextAttemptAt = &ts
+		}
+		if t := pa.GetLastHeartbeatTime(); t != nil {
+			ts := t.AsTime()
+			activity.LastHeartbeatAt = &ts
+		}
+		d.PendingActivities = append(d.PendingActivities, activity)
+		if activity.LastFailure != "" && activity.LastStartedAt != nil && (lastFailedAt.IsZero() || activity.LastStartedAt.After(lastFailedAt)) {
+			d.LastFailure, lastFailedAt = activity.LastFailure, *activity.LastStartedAt
+		}
+	}
+	for _, p := range info.GetAutoResetPoints().GetPoints() {
+		d.ResetPoints = append(d.ResetPoints, WorkflowResetPoint{
+			RunID:      p.GetRunId(),
+			EventID:    p.GetFirstWorkflowTaskCompletedId(),
+			CreatedAt:  p.GetCreateTime().AsTime(),
+			Resettable: p.GetResettable(),
+		})
+	}
+	return d
+}
+
+// ------ API ------
+
+// TerminateBillWorkflowRequest is the request payload for terminating a bill's workflow.
+type TerminateBillWorkflowRequest struct {
+	// Reason is recorded in the workflow's history.
+	Reason string `json:"reason"`
+}
+
+// ResetBillWorkflowRequest is the request payload for resetting a bill's workflow.
+type ResetBillWorkflowRequest struct {
+	// EventID is the workflow task completed event to reset to, such as the eventId of
+	// one of the workflow's reset points.
+	EventID int64 `json:"eventId"`
+	// RunID is the run to reset. It defaults to the bill's latest run.
+	RunID string `json:"runId,omitempty"`
+	// Reason is recorded in the workflow's history.
+	Reason string `json:"reason"`
+}
+
+// ResetBillWorkflowResponse is the response payload for resetting a bill's workflow.
+type ResetBillWorkflowResponse struct {
+	// RunID is the run the bill's workflow continues in.
+	RunID    string                   `json:"runId"`
+	Workflow *BillWorkflowDescription `json:"workflow"`
+}
+
+// GetBillWorkflow describes the workflow that runs a bill: its latest run, the
+// activities it is waiting on and the events it can be reset to.
+//
+// encore:api private method=GET path=/admin/bills/:billID/workflow
+func (s *Service) GetBillWorkflow(ctx context.Context, billID string) (*BillWorkflowDescription, error) {
+	desc, err := s.temporalClient.DescribeWorkflowExecution(ctx, billWorkflowID(billID), "")
+	if err != nil {
+		return nil, apierr.FromTemporal(err, apierr.BillNotFound, "workflow of bill %s not found", billID)
+	}
+	return describeBillWorkflow(billID, desc), nil
+}
+
+// TerminateBillWorkflow terminates the running workflow of a bill. The bill keeps the
+// state it was last saved with and takes no further signals; reset the workflow to run
+// it again.
+//
+// encore:api private method=POST path=/admin/bills/:billID/workflow/terminate
+func (s *Service) TerminateBillWorkflow(ctx context.Context, billID string, params *TerminateBillWorkflowRequest) (*BillWorkflowDescription, error) {
+	if params.Reason == "" {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "reason is required")
+	}
+	wfID := billWorkflowID(billID)
+	current, err := s.GetBillWorkflow(ctx, billID)
+	if err != nil {
+		return nil, err
+	}
+	if current.Status != enums.WORKFLOW_EXECUTION_STATUS_RUNNING.String() {
+		return nil, apierr.FailedPrecondition(apierr.WorkflowNotRunning, "workflow of bill %s is %s, not running", billID, current.Status)
+	}
+	if err := s.temporalClient.TerminateWorkflow(ctx, wfID, current.RunID, params.Reason); err != nil {
+		return nil, apierr.FromTemporal(err, apierr.BillNotFound, "workflow of bill %s not found", billID)
+	}
+	loggerFrom(ctx).Warn("Bill workflow terminated", "run_id", current.RunID, "reason", params.Reason)
+	return s.GetBillWorkflow(ctx, billID)
+}
+
+// ResetBillWorkflow resets the workflow of a bill to an earlier workflow task, starting
+// a new run that replays history up to that event. Signals received after it, such as
+// added line items, are applied again; activities after it run again.
+//
+// encore:api private method=POST path=/admin/bills/:billID/workflow/reset
+func (s *Service) ResetBillWorkflow(ctx context.Context, billID string, params *ResetBillWorkflowRequest) (*ResetBillWorkflowResponse, error) {
+	if params.EventID <= 0 {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "eventId must be a positive event ID")
+	}
+	if params.Reason == "" {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "reason is required")
+	}
+	runID := params.RunID
+	if runID == "" {
+		current, err := s.GetBillWorkflow(ctx, billID)
+		if err != nil {
+			return nil, err
+		}
+		runID = current.RunID
+	}
+
+	resp, err := s.temporalClient.ResetWorkflowExecution(ctx, &workflowservice.ResetWorkflowExecutionRequest{
+		Namespace:                 s.cfg.Temporal.Namespace,
+		WorkflowExecution:         &commonpb.WorkflowExecution{WorkflowId: billWorkflowID(billID), RunId: runID},
+		Reason:                    params.Reason,
+		WorkflowTaskFinishEventId: params.EventID,
+		RequestId:                 uuid.NewString(),
+	})
+	var invalid *serviceerror.InvalidArgument
+	if errors.As(err, &invalid) {
+		return nil, apierr.InvalidArgument(apierr.InvalidParameter, "cannot reset workflow of bill %s to event %d: %s", billID, params.EventID, invalid.Message)
+	}
+	if err != nil {
+		return nil, apierr.FromTemporal(err, apierr.BillNotFound, "workflow of bill %s not found", billID)
+	}
+	loggerFrom(ctx).Warn("Bill workflow reset", "from_run_id", runID, "event_id", params.EventID, "new_run_id", resp.GetRunId(), "reason", params.Reason)
+
+	workflow, err := s.GetBillWorkflow(ctx, billID)
+	if err != nil {
+		return nil, err
+	}
+	return &ResetBillWorkflowResponse{RunID: resp.GetRunId(), Workflow: workflow}, nil
+}
+
+// RetryBillWorkflowClose retries the failed close of a CLOSE_FAILED bill without
+// waiting for the workflow's own retry. It is the operator's counterpart of
+// RetryCloseBill and is not limited to one tenant's bills.
+//
+// encore:api private method=POST path=/admin/bills/:billID/workflow/retry-close
+func (s *Service) RetryBillWorkflowClose(ctx context.Context, billID string) (*CloseBillResponse, error) {
+	return s.retryClose(ctx, billID, RetryCloseSignal{RequestedByKeyID: operatorActor})
+}
@@ -0,0 +1,53 @@
+package fees
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/api/enums/v1"
+	failurepb "go.temporal.io/api/failure/v1"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestDescribeBillWorkflow tests that a described workflow lists its pending
+// activities and reset points.
+func TestDescribeBillWorkflow(t *testing.T) {
+	started := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	desc := &workflowservice.DescribeWorkflowExecutionResponse{
+		WorkflowExecutionInfo: &workflowpb.WorkflowExecutionInfo{
+			Execution:     &commonpb.WorkflowExecution{WorkflowId: "bill-b1", RunId: "run-2"},
+			Status:        enums.WORKFLOW_EXECUTION_STATUS_RUNNING,
+			TaskQueue:     feesTaskQueue,
+			StartTime:     timestamppb.New(started),
+			HistoryLength: 42,
+			AutoResetPoints: &workflowpb.ResetPoints{Points: []*workflowpb.ResetPointInfo{
+				{RunId: "run-2", FirstWorkflowTaskCompletedId: 4, CreateTime: timestamppb.New(started), Resettable: true},
+			}},
+		},
+		PendingActivities: []*workflowpb.PendingActivityInfo{{
+			ActivityId:      "7",
+			ActivityType:    &commonpb.ActivityType{Name: UpdateBillOnCloseActivityName},
+			State:           enums.PENDING_ACTIVITY_STATE_SCHEDULED,
+			Attempt:         3,
+			LastFailure:     &failurepb.Failure{Message: "connection refused"},
+			LastStartedTime: timestamppb.New(started.Add(time.Minute)),
+		}},
+	}
+
+	d := describeBillWorkflow("b1", desc)
+	require.Equal(t, "bill-b1", d.WorkflowID)
+	require.Equal(t, "run-2", d.RunID)
+	require.Equal(t, "Running", d.Status)
+	require.Equal(t, int64(42), d.HistoryLength)
+	require.Nil(t, d.ClosedAt)
+	require.Len(t, d.PendingActivities, 1)
+	require.Equal(t, UpdateBillOnCloseActivityName, d.PendingActivities[0].Type)
+	require.Equal(t, int32(3), d.PendingActivities[0].Attempt)
+	require.Equal(t, "connection refused", d.PendingActivities[0].LastFailure)
+	require.Equal(t, "connection refused", d.LastFailure)
+	require.Equal(t, []WorkflowResetPoint{{RunID: "run-2", EventID: 4, CreatedAt: started, Resettable: true}}, d.ResetPoints)
+}
@@ -1,15 +1,24 @@
 package fees
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
+	"encore.app/proration"
+	"encore.app/rounding"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/interceptor"
 	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
 )
 
 type BillWorkflowTestSuite struct {
@@ -24,12 +33,38 @@ func TestBillWorkflowTestSuite(t *testing.T) {
 
 func (s *BillWorkflowTestSuite) SetupTest() {
 	s.env = s.NewTestWorkflowEnvironment()
+	s.env.SetWorkerOptions(worker.Options{Interceptors: []interceptor.WorkerInterceptor{newActivityOptionsInterceptor(nil)}})
 
 	// The DB instance can be nil for these tests as we are mocking outcomes.
-	dbActivities := &Activities{DB: nil}
+	dbActivities := &Activities{DB: nil, Gateway: SandboxGateway{}, Notifier: LogNotifier{}}
 	s.env.RegisterActivity(dbActivities.UpsertBillActivity)
 	s.env.RegisterActivity(dbActivities.SaveLineItemActivity)
+	s.env.RegisterActivity(dbActivities.SaveAggregatedLineItemActivity)
 	s.env.RegisterActivity(dbActivities.UpdateBillOnCloseActivity)
+	s.env.RegisterActivity(dbActivities.UpdateBillStatusActivity)
+	s.env.RegisterActivity(dbActivities.ChargePaymentActivity)
+	s.env.RegisterActivity(dbActivities.RecordPaymentActivity)
+	s.env.RegisterActivity(dbActivities.RecordCreditNoteActivity)
+	s.env.RegisterActivity(dbActivities.RefundPaymentActivity)
+	s.env.RegisterActivity(dbActivities.UpdateCreditNoteActivity)
+	s.env.RegisterActivity(dbActivities.SendNotificationActivity)
+	s.env.RegisterActivity(dbActivities.RouteLateLineItemActivity)
+	s.env.RegisterActivity(dbActivities.ProrateActivity)
+	s.env.RegisterActivity(dbActivities.QueueLineItemRepairActivity)
+	s.env.RegisterActivity(dbActivities.ApplyCreditActivity)
+	s.env.RegisterActivity(dbActivities.CarryForwardCreditActivity)
+	s.env.RegisterActivity(dbActivities.SweepBillsActivity)
+	s.env.RegisterActivity(dbActivities.FindCloseBatchBillsActivity)
+	s.env.RegisterActivity(dbActivities.EncryptLineItemsActivity)
+	s.env.RegisterActivity(dbActivities.RebuildBillSummariesActivity)
+	s.env.RegisterActivity(dbActivities.RollUpSubBillActivity)
+	s.env.RegisterActivity(dbActivities.SavePayerSharesActivity)
+	s.env.RegisterActivity(dbActivities.UpdateJobActivity)
+	s.env.RegisterActivity(dbActivities.SaveDisputeActivity)
+	s.env.RegisterActivity(dbActivities.DeliverBillActivity)
+	s.env.RegisterActivity(dbActivities.ArchiveBillsActivity)
+	s.env.RegisterActivity(dbActivities.SnapshotAccrualsActivity)
+	s.env.RegisterActivity(dbActivities.PurgeTestBillsActivity)
 }
 
 func (s *BillWorkflowTestSuite) AfterTest(suiteName, testName string) {
@@ -223,6 +258,10 @@ func (s *BillWorkflowTestSuite) Test_BillWorkflow_SaveLineItemActivityFailure()
 	// Mock activities
 	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.AnythingOfType("fees.UpsertBillActivityParams")).Return(nil).Once()
 	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.AnythingOfType("fees.SaveLineItemActivityParams")).Return(temporal.NewNonRetryableApplicationError(expectedErrText, "SaveItemError", nil)).Once()
+	// The failed save is queued for repair under the default policy
+	s.env.OnActivity("QueueLineItemRepairActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return p.LineItemID == item1ID && p.Amount == item1Amount
+	})).Return(nil).Once()
 	// UpdateBillOnCloseActivity should still be called as workflow continues
 	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.AnythingOfType("fees.UpdateBillOnCloseActivityParams")).Return(nil).Once()
 
@@ -250,7 +289,7 @@ func (s *BillWorkflowTestSuite) Test_BillWorkflow_SaveLineItemActivityFailure()
 	s.env.ExecuteWorkflow(BillWorkflow, &params)
 
 	require.True(s.T(), s.env.IsWorkflowCompleted())
-	require.NoError(s.T(), s.env.GetWorkflowError(), "Workflow should complete even if SaveLineItemActivity fails (as per current design)")
+	require.NoError(s.T(), s.env.GetWorkflowError(), "Workflow should complete even if SaveLineItemActivity fails")
 
 	var finalBillDetails Bill
 	err := s.env.GetWorkflowResult(&finalBillDetails)
@@ -258,10 +297,82 @@ func (s *BillWorkflowTestSuite) Test_BillWorkflow_SaveLineItemActivityFailure()
 	require.Equal(s.T(), BillStatusClosed, finalBillDetails.Status)
 	require.Len(s.T(), finalBillDetails.LineItems, 1)
 	require.True(s.T(), item1Amount == finalBillDetails.TotalAmount, "Total should reflect the item in workflow state")
-	// Note: This test highlights that the DB might be inconsistent with workflow state if SaveLineItemActivity fails.
+	require.Empty(s.T(), finalBillDetails.DroppedLineItems)
+}
+
+// Test_BillWorkflow_SaveFailureRemovesItem tests that under the remove policy, an item that
+// cannot be saved is removed from the bill, recorded as dropped, and the customer notified.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_SaveFailureRemovesItem() {
+	params := BillWorkflowParams{
+		BillID:            uuid.NewString(),
+		CustomerID:        "cust-save-remove",
+		Currency:          "USD",
+		SaveFailurePolicy: SaveFailurePolicyRemove,
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+	keptID, droppedID := uuid.NewString(), uuid.NewString()
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return p.LineItemID == keptID
+	})).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return p.LineItemID == droppedID
+	})).Return(temporal.NewNonRetryableApplicationError("simulated save error", "SaveItemError", nil)).Once()
+	s.env.OnActivity("SendNotificationActivity", mock.Anything, mock.MatchedBy(func(p SendNotificationActivityParams) bool {
+		return p.Notification.Event == NotificationLineItemDropped && p.Notification.CustomerID == "cust-save-remove"
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillOnCloseActivityParams) bool {
+		return p.TotalAmount == 10
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: keptID, Description: "Kept", Amount: 10})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: droppedID, Description: "Dropped", Amount: 25})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 3*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusClosed, finalBill.Status)
+	require.Len(s.T(), finalBill.LineItems, 1)
+	require.Equal(s.T(), keptID, finalBill.LineItems[0].ID)
+	require.Equal(s.T(), 10.0, finalBill.TotalAmount)
+	require.Len(s.T(), finalBill.DroppedLineItems, 1)
+	require.Equal(s.T(), droppedID, finalBill.DroppedLineItems[0].ID)
+	require.Contains(s.T(), finalBill.DroppedLineItems[0].Reason, "simulated save error")
+}
+
+// Test_LineItemRepairWorkflow_DrainsQueue tests that the repair workflow saves every queued
+// line item, moving past saves that fail for good.
+func (s *BillWorkflowTestSuite) Test_LineItemRepairWorkflow_DrainsQueue() {
+	s.env.RegisterWorkflow(LineItemRepairWorkflow)
+	queued := []SaveLineItemActivityParams{
+		{LineItemID: "li-bad", BillID: "b1", Amount: 5},
+		{LineItemID: "li-ok", BillID: "b2", Amount: 7},
+	}
+
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, queued[0]).
+		Return(temporal.NewNonRetryableApplicationError("bill deleted", "SaveItemError", nil)).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, queued[1]).Return(nil).Once()
+
+	s.env.ExecuteWorkflow(LineItemRepairWorkflow, queued)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
 }
 
-// Test_BillWorkflow_UpdateBillOnCloseActivityFailure tests the failure of UpdateBillOnCloseActivity (workflow logs and continues)
+// Test_BillWorkflow_UpdateBillOnCloseActivityFailure tests that a close that cannot be saved
+// leaves the bill CLOSE_FAILED, and that it closes once a later retry saves it.
 func (s *BillWorkflowTestSuite) Test_BillWorkflow_UpdateBillOnCloseActivityFailure() {
 	params := BillWorkflowParams{
 		BillID:     uuid.NewString(),
@@ -270,24 +381,2292 @@ func (s *BillWorkflowTestSuite) Test_BillWorkflow_UpdateBillOnCloseActivityFailu
 	}
 	s.env.RegisterWorkflow(BillWorkflow)
 	expectedErrText := "simulated update bill on close error"
+	closeErr := temporal.NewNonRetryableApplicationError(expectedErrText, "UpdateCloseError", nil)
 
-	// Mock activities
+	// Mock activities: the close fails, an operator's retry fails again, and the
+	// workflow's own retry succeeds.
 	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.AnythingOfType("fees.UpsertBillActivityParams")).Return(nil).Once()
-	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.AnythingOfType("fees.UpdateBillOnCloseActivityParams")).Return(temporal.NewNonRetryableApplicationError(expectedErrText, "UpdateCloseError", nil)).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.AnythingOfType("fees.UpdateBillOnCloseActivityParams")).Return(closeErr).Twice()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillOnCloseActivityParams) bool {
+		return p.Status == BillStatusClosed && p.Version == 6
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillStatusActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillStatusActivityParams) bool {
+		return p.Status == BillStatusCloseFailed
+	})).Return(nil).Twice()
+	s.env.OnActivity("SendNotificationActivity", mock.Anything, mock.MatchedBy(func(p SendNotificationActivityParams) bool {
+		return p.Notification.Event == NotificationBillCloseFailed
+	})).Return(nil).Once()
 
 	s.env.RegisterDelayedCallback(func() {
 		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
 	}, 1*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		var bill Bill
+		encoded, err := s.env.QueryWorkflow(GetBillDetailsQueryName)
+		require.NoError(s.T(), err)
+		require.NoError(s.T(), encoded.Get(&bill))
+		require.Equal(s.T(), BillStatusCloseFailed, bill.Status)
+		require.Nil(s.T(), bill.ClosedAt)
+		require.Contains(s.T(), bill.CloseFailure.Error, expectedErrText)
+		require.Zero(s.T(), bill.CloseFailure.Retries)
+		s.env.SignalWorkflow(RetryCloseSignalName, RetryCloseSignal{RequestedByKeyID: "key-ops"})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		var bill Bill
+		encoded, err := s.env.QueryWorkflow(GetBillDetailsQueryName)
+		require.NoError(s.T(), err)
+		require.NoError(s.T(), encoded.Get(&bill))
+		require.Equal(s.T(), BillStatusCloseFailed, bill.Status)
+		require.Equal(s.T(), 1, bill.CloseFailure.Retries)
+	}, 10*time.Minute)
 
 	s.env.ExecuteWorkflow(BillWorkflow, &params)
 
 	require.True(s.T(), s.env.IsWorkflowCompleted())
-	// Workflow is designed to log and continue, so no workflow error is expected here.
-	require.NoError(s.T(), s.env.GetWorkflowError(), "Workflow should complete even if UpdateBillOnCloseActivity fails (as per current design)")
+	require.NoError(s.T(), s.env.GetWorkflowError())
 
 	var finalBillDetails Bill
 	err := s.env.GetWorkflowResult(&finalBillDetails)
 	require.NoError(s.T(), err)
 	require.Equal(s.T(), BillStatusClosed, finalBillDetails.Status)
-	// Note: This test highlights that the DB might not reflect the closed status if UpdateBillOnCloseActivity fails.
+	require.NotNil(s.T(), finalBillDetails.ClosedAt)
+	require.Nil(s.T(), finalBillDetails.CloseFailure)
+}
+
+// Test_BillWorkflow_CloseRetriesTransientFailures tests that transient failures to save a
+// close are retried before the bill is marked closed.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_CloseRetriesTransientFailures() {
+	params := BillWorkflowParams{
+		BillID:     uuid.NewString(),
+		CustomerID: "cust-close-retry",
+		Currency:   "USD",
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(errors.New("connection reset")).Times(activityPolicies[UpdateBillOnCloseActivityName].MaximumAttempts - 1)
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusClosed, finalBill.Status)
+	require.Nil(s.T(), finalBill.CloseFailure)
+}
+
+// Test_BillWorkflow_AutoCollectPaid tests that an auto-collect bill is charged on close and marked paid.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_AutoCollectPaid() {
+	params := BillWorkflowParams{
+		BillID:      uuid.NewString(),
+		CustomerID:  "cust-autocollect",
+		Currency:    "USD",
+		AutoCollect: true,
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+	s.env.RegisterWorkflow(PaymentWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("ChargePaymentActivity", mock.Anything, mock.MatchedBy(func(p ChargePaymentActivityParams) bool {
+		return p.BillID == params.BillID && p.Amount == 42.0
+	})).Return(&ChargeResult{Reference: "ch_123"}, nil).Once()
+	s.env.OnActivity("RecordPaymentActivity", mock.Anything, mock.MatchedBy(func(p RecordPaymentActivityParams) bool {
+		return p.Status == PaymentStatusSucceeded && p.GatewayReference == "ch_123"
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillStatusActivity", mock.Anything, UpdateBillStatusActivityParams{BillID: params.BillID, Status: BillStatusPaid, Version: 6}).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Usage", Amount: 42.0})
+	}, 1*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 2*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusPaid, finalBill.Status)
+	require.Len(s.T(), finalBill.Payments, 1)
+	require.Equal(s.T(), PaymentStatusSucceeded, finalBill.Payments[0].Status)
+	require.Equal(s.T(), "ch_123", finalBill.Payments[0].GatewayReference)
+}
+
+// Test_BillWorkflow_MarksOverdue tests that a closed bill left unpaid becomes OVERDUE at its due date.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_MarksOverdue() {
+	dueDate := s.env.Now().Add(14 * 24 * time.Hour)
+	params := BillWorkflowParams{
+		BillID:     uuid.NewString(),
+		CustomerID: "cust-overdue",
+		Currency:   "USD",
+		DueDate:    &dueDate,
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.MatchedBy(func(p UpsertBillActivityParams) bool {
+		return p.DueDate != nil && p.DueDate.Equal(dueDate)
+	})).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("UpdateBillStatusActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillStatusActivityParams) bool {
+		return p.BillID == params.BillID && p.Status == BillStatusOverdue && p.DueDate != nil && p.DueDate.Equal(dueDate)
+	})).Return(nil).Once()
+	s.env.OnActivity("SendNotificationActivity", mock.Anything, mock.MatchedBy(func(p SendNotificationActivityParams) bool {
+		return p.Notification.Event == NotificationBillOverdue && p.Notification.BillID == params.BillID
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Usage", Amount: 25.0})
+	}, 1*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 2*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusOverdue, finalBill.Status)
+	require.True(s.T(), dueDate.Equal(*finalBill.DueDate))
+}
+
+// Test_BillWorkflow_PaidBeforeDueDate tests that paying a bill before its due date cancels the overdue timer.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_PaidBeforeDueDate() {
+	dueDate := s.env.Now().Add(14 * 24 * time.Hour)
+	params := BillWorkflowParams{
+		BillID:      uuid.NewString(),
+		CustomerID:  "cust-due-paid",
+		Currency:    "USD",
+		AutoCollect: true,
+		DueDate:     &dueDate,
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+	s.env.RegisterWorkflow(PaymentWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("ChargePaymentActivity", mock.Anything, mock.Anything).Return(&ChargeResult{Reference: "ch_due"}, nil).Once()
+	s.env.OnActivity("RecordPaymentActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("UpdateBillStatusActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillStatusActivityParams) bool {
+		return p.Status == BillStatusPaid
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Usage", Amount: 42.0})
+	}, 1*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 2*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusPaid, finalBill.Status)
+	require.True(s.T(), s.env.Now().Before(dueDate), "the run should end once the bill is paid, not at its due date")
+}
+
+// Test_BillWorkflow_ResumedPaymentDeclined tests paying an already closed bill whose charge is declined.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_ResumedPaymentDeclined() {
+	closedAt := time.Now()
+	closedBill := Bill{
+		ID:          uuid.NewString(),
+		CustomerID:  "cust-declined",
+		Currency:    "EUR",
+		Status:      BillStatusClosed,
+		LineItems:   []LineItem{{ID: uuid.NewString(), Description: "Fee", Amount: 10}},
+		TotalAmount: 10,
+		CreatedAt:   &closedAt,
+		ClosedAt:    &closedAt,
+	}
+	params := BillWorkflowParams{
+		BillID:     closedBill.ID,
+		CustomerID: closedBill.CustomerID,
+		Currency:   closedBill.Currency,
+		Resume:     &closedBill,
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+	s.env.RegisterWorkflow(PaymentWorkflow)
+
+	s.env.OnActivity("ChargePaymentActivity", mock.Anything, mock.Anything).
+		Return(nil, temporal.NewNonRetryableApplicationError("insufficient funds", PaymentDeclinedErrorType, nil)).Once()
+	s.env.OnActivity("RecordPaymentActivity", mock.Anything, mock.MatchedBy(func(p RecordPaymentActivityParams) bool {
+		return p.Status == PaymentStatusDeclined
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillStatusActivity", mock.Anything, UpdateBillStatusActivityParams{BillID: closedBill.ID, Status: BillStatusPaymentFailed, Version: 3}).Return(nil).Once()
+
+	// Signal-with-start delivers the signal before the first workflow task.
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(PayBillSignalName, PayBillSignal{PaymentID: "pay-1"})
+	}, 0)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusPaymentFailed, finalBill.Status)
+	require.Len(s.T(), finalBill.Payments, 1)
+	require.Equal(s.T(), "pay-1", finalBill.Payments[0].ID)
+	require.Equal(s.T(), PaymentStatusDeclined, finalBill.Payments[0].Status)
+	require.Equal(s.T(), "insufficient funds", finalBill.Payments[0].FailureReason)
+}
+
+// Test_BillWorkflow_PartialRefundOfPaidBill tests issuing a partial credit note against a paid bill.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_PartialRefundOfPaidBill() {
+	closedAt := time.Now()
+	paidBill := Bill{
+		ID:          uuid.NewString(),
+		CustomerID:  "cust-refund",
+		Currency:    "USD",
+		Status:      BillStatusPaid,
+		LineItems:   []LineItem{{ID: uuid.NewString(), Description: "Fee", Amount: 100}},
+		TotalAmount: 100,
+		CreatedAt:   &closedAt,
+		ClosedAt:    &closedAt,
+		Payments:    []Payment{{ID: "pay-1", Status: PaymentStatusSucceeded, Amount: 100, GatewayReference: "ch_paid"}},
+	}
+	params := BillWorkflowParams{BillID: paidBill.ID, CustomerID: paidBill.CustomerID, Currency: paidBill.Currency, Resume: &paidBill}
+	s.env.RegisterWorkflow(BillWorkflow)
+	s.env.RegisterWorkflow(RefundWorkflow)
+
+	s.env.OnActivity("RecordCreditNoteActivity", mock.Anything, mock.MatchedBy(func(p RecordCreditNoteActivityParams) bool {
+		return p.CreditNoteID == "cn-1" && len(p.LineItems) == 1 && p.LineItems[0].Amount == -30
+	})).Return(nil).Once()
+	s.env.OnActivity("RefundPaymentActivity", mock.Anything, mock.MatchedBy(func(p RefundPaymentActivityParams) bool {
+		return p.PaymentReference == "ch_paid" && p.Amount == 30
+	})).Return(&RefundResult{Reference: "re_1"}, nil).Once()
+	s.env.OnActivity("UpdateCreditNoteActivity", mock.Anything, mock.MatchedBy(func(p UpdateCreditNoteActivityParams) bool {
+		return p.Status == RefundStatusSucceeded && p.GatewayReference == "re_1"
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(RefundBillSignalName, RefundBillSignal{CreditNoteID: "cn-1", Amount: 30, Reason: "goodwill"})
+	}, 0)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusPaid, finalBill.Status)
+	require.True(s.T(), finalBill.RefundedAmount == 30)
+	require.Len(s.T(), finalBill.CreditNotes, 1)
+	require.Equal(s.T(), RefundStatusSucceeded, finalBill.CreditNotes[0].Status)
+	require.Equal(s.T(), "goodwill", finalBill.CreditNotes[0].Reason)
+	require.True(s.T(), finalBill.refundableAmount() == 70)
+}
+
+// Test_BillWorkflow_RefundCarriesRemainderForward tests that a credit note beyond the
+// bill's refundable amount refunds the bill and carries the rest to the customer's credit.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_RefundCarriesRemainderForward() {
+	closedAt := time.Now()
+	paidBill := Bill{
+		ID:          uuid.NewString(),
+		CustomerID:  "cust-carry",
+		Currency:    "USD",
+		Status:      BillStatusPaid,
+		LineItems:   []LineItem{{ID: uuid.NewString(), Description: "Fee", Amount: 100}},
+		TotalAmount: 100,
+		CreatedAt:   &closedAt,
+		ClosedAt:    &closedAt,
+		Payments:    []Payment{{ID: "pay-1", Status: PaymentStatusSucceeded, Amount: 100, GatewayReference: "ch_paid"}},
+	}
+	params := BillWorkflowParams{BillID: paidBill.ID, CustomerID: paidBill.CustomerID, Currency: paidBill.Currency, Resume: &paidBill}
+	s.env.RegisterWorkflow(BillWorkflow)
+	s.env.RegisterWorkflow(RefundWorkflow)
+
+	s.env.OnActivity("RecordCreditNoteActivity", mock.Anything, mock.MatchedBy(func(p RecordCreditNoteActivityParams) bool {
+		return p.CreditNoteID == "cn-1" && p.Amount == 100
+	})).Return(nil).Once()
+	s.env.OnActivity("RefundPaymentActivity", mock.Anything, mock.MatchedBy(func(p RefundPaymentActivityParams) bool {
+		return p.Amount == 100
+	})).Return(&RefundResult{Reference: "re_1"}, nil).Once()
+	s.env.OnActivity("UpdateCreditNoteActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("CarryForwardCreditActivity", mock.Anything, mock.MatchedBy(func(p CarryForwardCreditActivityParams) bool {
+		return p.EntryID == carryForwardEntryID("cn-1") && p.CustomerID == "cust-carry" && p.Currency == "USD" && p.Amount == 25 && p.BillID == paidBill.ID
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(RefundBillSignalName, RefundBillSignal{CreditNoteID: "cn-1", Amount: 125, CarryForward: true})
+	}, 0)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.True(s.T(), finalBill.RefundedAmount == 100)
+	require.Len(s.T(), finalBill.CreditNotes, 1)
+	require.True(s.T(), finalBill.CreditNotes[0].Amount == 100)
+	require.True(s.T(), finalBill.CreditNotes[0].CarriedForward == 25)
+}
+
+// Test_BillWorkflow_AggregatesLineItems tests that small items of one category are
+// consolidated into one line item, and that larger items are added as they are.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_AggregatesLineItems() {
+	params := BillWorkflowParams{
+		BillID:      uuid.NewString(),
+		CustomerID:  "cust-aggregate",
+		Currency:    "USD",
+		Aggregation: &LineItemAggregation{Interval: "1h", MaxAmount: 1},
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveAggregatedLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveAggregatedLineItemActivityParams) bool {
+		return p.Event.Category == "api-calls" && p.LineItem.Aggregate.Count == 1
+	})).Return(true, nil).Once()
+	s.env.OnActivity("SaveAggregatedLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveAggregatedLineItemActivityParams) bool {
+		return p.Event.Category == "api-calls" && p.LineItem.Aggregate.Count == 2
+	})).Return(true, nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return p.Description == "Setup fee"
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "GET /v1/rates", Category: "api-calls", Amount: 0.00004})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "GET /v1/rates", Category: "api-calls", Amount: 0.00003})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Setup fee", Amount: 25})
+	}, 3*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 4*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var closed Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&closed))
+	require.Len(s.T(), closed.LineItems, 2)
+	aggregated := closed.LineItems[0]
+	require.NotNil(s.T(), aggregated.Aggregate)
+	require.Equal(s.T(), "api-calls", aggregated.Aggregate.Category)
+	require.Equal(s.T(), 2, aggregated.Aggregate.Count)
+	require.InDelta(s.T(), 0.00007, aggregated.Aggregate.RawAmount, 1e-12)
+	require.True(s.T(), aggregated.Amount == 0.0001)
+	require.Nil(s.T(), closed.LineItems[1].Aggregate)
+	require.True(s.T(), closed.TotalAmount == 25.0001)
+}
+
+// Test_BillWorkflow_RecordsPartialPayments tests that recorded payments are allocated to a
+// closed bill's balance, moving it to PARTIALLY_PAID and then to PAID.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_RecordsPartialPayments() {
+	closedBill := s.closedBill(100)
+	params := BillWorkflowParams{BillID: closedBill.ID, CustomerID: closedBill.CustomerID, Currency: closedBill.Currency, Resume: &closedBill}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("RecordPaymentActivity", mock.Anything, mock.MatchedBy(func(p RecordPaymentActivityParams) bool {
+		return p.PaymentID == "rp-1" && p.Amount == 40 && p.Method == "bank_transfer" && p.GatewayReference == "wire-1" && p.Status == PaymentStatusSucceeded
+	})).Return(nil).Once()
+	s.env.OnActivity("RecordPaymentActivity", mock.Anything, mock.MatchedBy(func(p RecordPaymentActivityParams) bool {
+		return p.PaymentID == "rp-2" && p.Amount == 60 && p.Method == "check"
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillStatusActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillStatusActivityParams) bool {
+		return p.Status == BillStatusPartiallyPaid
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillStatusActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillStatusActivityParams) bool {
+		return p.Status == BillStatusPaid
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(RecordPaymentSignalName, RecordPaymentSignal{PaymentID: "rp-1", Amount: 40, Method: "bank_transfer", Reference: "wire-1"})
+	}, 0)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(RecordPaymentSignalName, RecordPaymentSignal{PaymentID: "rp-2", Amount: 60, Method: "check"})
+	}, 0)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusPaid, finalBill.Status)
+	require.Len(s.T(), finalBill.Payments, 2)
+	require.True(s.T(), finalBill.AmountPaid == 100)
+	require.NotNil(s.T(), finalBill.BalanceDue)
+	require.True(s.T(), *finalBill.BalanceDue == 0)
+	require.Len(s.T(), finalBill.Allocations, 2)
+	require.Equal(s.T(), "rp-1", finalBill.Allocations[0].PaymentID)
+	require.True(s.T(), finalBill.Allocations[0].BalanceAfter == 60)
+	require.True(s.T(), finalBill.Allocations[1].BalanceAfter == 0)
+	require.False(s.T(), finalBill.Allocations[0].AllocatedAt.IsZero())
+}
+
+// Test_BillWorkflow_IgnoresOverpayment tests that a recorded payment above the bill's
+// balance due is dropped and leaves the bill unchanged.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_IgnoresOverpayment() {
+	closedBill := s.closedBill(50)
+	params := BillWorkflowParams{BillID: closedBill.ID, CustomerID: closedBill.CustomerID, Currency: closedBill.Currency, Resume: &closedBill}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(RecordPaymentSignalName, RecordPaymentSignal{PaymentID: "rp-1", Amount: 50.01, Method: "bank_transfer"})
+	}, 0)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusClosed, finalBill.Status)
+	require.Empty(s.T(), finalBill.Payments)
+	require.True(s.T(), finalBill.balanceDue() == 50)
+}
+
+// Test_BillWorkflow_PaymentWebhookConfirmsFailedCharge tests that a provider's
+// confirmation of a charge that failed on our side settles the bill.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_PaymentWebhookConfirmsFailedCharge() {
+	bill := s.closedBill(80)
+	bill.Status = BillStatusPaymentFailed
+	bill.Payments = []Payment{{ID: "pay-1", Status: PaymentStatusFailed, Amount: 80, FailureReason: "timeout", CreatedAt: bill.ClosedAt}}
+	params := BillWorkflowParams{BillID: bill.ID, CustomerID: bill.CustomerID, Currency: bill.Currency, Resume: &bill}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("RecordPaymentActivity", mock.Anything, mock.MatchedBy(func(p RecordPaymentActivityParams) bool {
+		return p.PaymentID == "pay-1" && p.Status == PaymentStatusSucceeded && p.GatewayReference == "ch_late" && !p.Reversed
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillStatusActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillStatusActivityParams) bool {
+		return p.Status == BillStatusPaid
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(PaymentWebhookSignalName, PaymentWebhookSignal{Provider: "acme", EventID: "evt-1", Type: PaymentWebhookSucceeded, PaymentID: "pay-1", GatewayReference: "ch_late"})
+	}, 0)
+	s.env.RegisterDelayedCallback(func() {
+		// A redelivered confirmation changes nothing.
+		s.env.SignalWorkflow(PaymentWebhookSignalName, PaymentWebhookSignal{Provider: "acme", EventID: "evt-1", Type: PaymentWebhookSucceeded, PaymentID: "pay-1"})
+	}, 0)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusPaid, finalBill.Status)
+	require.Equal(s.T(), PaymentStatusSucceeded, finalBill.Payments[0].Status)
+	require.Empty(s.T(), finalBill.Payments[0].FailureReason)
+	require.True(s.T(), finalBill.AmountPaid == 80)
+	require.Len(s.T(), finalBill.Allocations, 1)
+}
+
+// Test_BillWorkflow_PaymentWebhookReversesReturnedPayment tests that a payment the
+// provider reports failed after it succeeded is taken back off the bill's balance.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_PaymentWebhookReversesReturnedPayment() {
+	bill := s.closedBill(100)
+	bill.Status = BillStatusPaid
+	bill.Payments = []Payment{{ID: "pay-1", Status: PaymentStatusSucceeded, Amount: 100, GatewayReference: "ch_1", CreatedAt: bill.ClosedAt, CompletedAt: bill.ClosedAt}}
+	bill.allocatePayment(bill.Payments[0])
+	params := BillWorkflowParams{BillID: bill.ID, CustomerID: bill.CustomerID, Currency: bill.Currency, Resume: &bill}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("RecordPaymentActivity", mock.Anything, mock.MatchedBy(func(p RecordPaymentActivityParams) bool {
+		return p.PaymentID == "pay-1" && p.Status == PaymentStatusFailed && p.FailureReason == "insufficient_funds" && p.Reversed
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillStatusActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillStatusActivityParams) bool {
+		return p.Status == BillStatusPaymentFailed
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(PaymentWebhookSignalName, PaymentWebhookSignal{Provider: "acme", EventID: "evt-2", Type: PaymentWebhookFailed, PaymentID: "pay-1", Reason: "insufficient_funds"})
+	}, 0)
+	s.env.RegisterDelayedCallback(func() {
+		// A later confirmation does not revive the reversed payment.
+		s.env.SignalWorkflow(PaymentWebhookSignalName, PaymentWebhookSignal{Provider: "acme", EventID: "evt-3", Type: PaymentWebhookSucceeded, PaymentID: "pay-1"})
+	}, 0)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusPaymentFailed, finalBill.Status)
+	require.Equal(s.T(), PaymentStatusFailed, finalBill.Payments[0].Status)
+	require.True(s.T(), finalBill.AmountPaid == 0)
+	require.True(s.T(), *finalBill.BalanceDue == 100)
+	require.Len(s.T(), finalBill.Allocations, 2)
+	require.True(s.T(), finalBill.Allocations[1].Amount == -100)
+}
+
+// Test_BillWorkflow_PaymentWebhookChargeback tests that a chargeback marks the payment
+// disputed without changing the bill's balance, and opens a dispute for it.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_PaymentWebhookChargeback() {
+	bill := s.closedBill(60)
+	bill.Status = BillStatusPaid
+	bill.Payments = []Payment{{ID: "pay-1", Status: PaymentStatusSucceeded, Amount: 60, CreatedAt: bill.ClosedAt, CompletedAt: bill.ClosedAt}}
+	bill.allocatePayment(bill.Payments[0])
+	params := BillWorkflowParams{BillID: bill.ID, CustomerID: bill.CustomerID, Currency: bill.Currency, Resume: &bill}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("RecordPaymentActivity", mock.Anything, mock.MatchedBy(func(p RecordPaymentActivityParams) bool {
+		return p.PaymentID == "pay-1" && p.Status == PaymentStatusDisputed && p.FailureReason == "fraudulent" && !p.Reversed
+	})).Return(nil).Once()
+	dueBy := time.Now().Add(72 * time.Hour).UTC().Truncate(time.Second)
+	s.env.RegisterWorkflow(DisputeWorkflow)
+	s.env.OnWorkflow(DisputeWorkflow, mock.Anything, mock.MatchedBy(func(p *DisputeWorkflowParams) bool {
+		return p.Dispute.ID == disputeID("acme", "evt-4") && p.Dispute.BillID == bill.ID && p.Dispute.PaymentID == "pay-1" &&
+			p.Dispute.Amount == 60 && p.Dispute.Reason == "fraudulent" && p.Dispute.EvidenceDueBy.Equal(dueBy) && p.CustomerID == bill.CustomerID
+	})).Return(&Dispute{}, nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(PaymentWebhookSignalName, PaymentWebhookSignal{Provider: "acme", EventID: "evt-4", Type: PaymentWebhookChargeback, PaymentID: "pay-1", Reason: "fraudulent", EvidenceDueBy: &dueBy})
+	}, 0)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(PaymentWebhookSignalName, PaymentWebhookSignal{Provider: "acme", EventID: "evt-5", Type: PaymentWebhookChargeback, PaymentID: "pay-unknown"})
+	}, 0)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusPaid, finalBill.Status)
+	require.Equal(s.T(), PaymentStatusDisputed, finalBill.Payments[0].Status)
+	require.Equal(s.T(), disputeID("acme", "evt-4"), finalBill.Payments[0].DisputeID)
+	require.True(s.T(), finalBill.AmountPaid == 60)
+}
+
+// Test_BillWorkflow_DisputeLost tests that a lost dispute reverses the payment without
+// starting dunning, and tells the DisputeWorkflow.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_DisputeLost() {
+	bill := s.closedBill(60)
+	bill.Status = BillStatusPaid
+	bill.Payments = []Payment{{ID: "pay-1", Status: PaymentStatusSucceeded, Amount: 60, CreatedAt: bill.ClosedAt, CompletedAt: bill.ClosedAt}}
+	bill.allocatePayment(bill.Payments[0])
+	bill.Payments[0].Status = PaymentStatusDisputed
+	bill.Payments[0].DisputeID = "dsp-1"
+	params := BillWorkflowParams{BillID: bill.ID, CustomerID: bill.CustomerID, Currency: bill.Currency, Resume: &bill, DunningSchedule: []time.Duration{time.Hour}}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("RecordPaymentActivity", mock.Anything, mock.MatchedBy(func(p RecordPaymentActivityParams) bool {
+		return p.PaymentID == "pay-1" && p.Status == PaymentStatusFailed && p.FailureReason == "chargeback lost: fraudulent" && p.Reversed
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillStatusActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillStatusActivityParams) bool {
+		return p.Status == BillStatusPaymentFailed
+	})).Return(nil).Once()
+	s.env.OnSignalExternalWorkflow(mock.Anything, disputeWorkflowID("dsp-1"), "", ResolveDisputeSignalName, ResolveDisputeSignal{Outcome: DisputeStatusLost}).
+		Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(PaymentWebhookSignalName, PaymentWebhookSignal{Provider: "acme", EventID: "evt-6", Type: PaymentWebhookDisputeLost, PaymentID: "pay-1", Reason: "fraudulent"})
+	}, 0)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusPaymentFailed, finalBill.Status)
+	require.Equal(s.T(), PaymentStatusFailed, finalBill.Payments[0].Status)
+	require.True(s.T(), *finalBill.BalanceDue == 60)
+}
+
+// Test_DisputeWorkflow_EvidenceOverdue tests that a dispute without evidence is
+// reminded of, marked overdue at its deadline, and completes when it is decided.
+func (s *BillWorkflowTestSuite) Test_DisputeWorkflow_EvidenceOverdue() {
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	s.env.SetStartTime(start)
+	s.env.RegisterWorkflow(DisputeWorkflow)
+	params := &DisputeWorkflowParams{
+		Dispute:    Dispute{ID: "dsp-1", BillID: "bill-1", PaymentID: "pay-1", Amount: 60, Currency: "USD", Reason: "fraudulent", EvidenceDueBy: start.Add(5 * 24 * time.Hour)},
+		CustomerID: "cust-1",
+	}
+
+	var saved []DisputeStatus
+	s.env.OnActivity("SaveDisputeActivity", mock.Anything, mock.Anything).Return(func(_ context.Context, p SaveDisputeActivityParams) error {
+		saved = append(saved, p.Dispute.Status)
+		return nil
+	}).Times(3)
+	var notified []NotificationEvent
+	s.env.OnActivity("SendNotificationActivity", mock.Anything, mock.Anything).Return(func(_ context.Context, p SendNotificationActivityParams) error {
+		notified = append(notified, p.Notification.Event)
+		return nil
+	}).Times(4)
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(ResolveDisputeSignalName, ResolveDisputeSignal{Outcome: DisputeStatusLost})
+	}, 6*24*time.Hour)
+
+	s.env.ExecuteWorkflow(DisputeWorkflow, params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var dispute Dispute
+	require.NoError(s.T(), s.env.GetWorkflowResult(&dispute))
+	require.Equal(s.T(), DisputeStatusLost, dispute.Status)
+	require.Nil(s.T(), dispute.Evidence)
+	require.Equal(s.T(), []DisputeStatus{DisputeStatusNeedsEvidence, DisputeStatusEvidenceOverdue, DisputeStatusLost}, saved)
+	require.Equal(s.T(), []NotificationEvent{NotificationDisputeOpened, NotificationDisputeEvidenceDue, NotificationDisputeEvidenceOverdue, NotificationDisputeResolved}, notified)
+}
+
+// Test_DisputeWorkflow_EvidenceSubmitted tests that evidence submitted before the
+// deadline stops the reminder and keeps the dispute from becoming overdue.
+func (s *BillWorkflowTestSuite) Test_DisputeWorkflow_EvidenceSubmitted() {
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	s.env.SetStartTime(start)
+	s.env.RegisterWorkflow(DisputeWorkflow)
+	params := &DisputeWorkflowParams{
+		Dispute:    Dispute{ID: "dsp-2", BillID: "bill-1", PaymentID: "pay-1", Amount: 60, Currency: "USD", EvidenceDueBy: start.Add(5 * 24 * time.Hour)},
+		CustomerID: "cust-1",
+	}
+
+	var saved []DisputeStatus
+	s.env.OnActivity("SaveDisputeActivity", mock.Anything, mock.Anything).Return(func(_ context.Context, p SaveDisputeActivityParams) error {
+		saved = append(saved, p.Dispute.Status)
+		return nil
+	}).Times(3)
+	var notified []NotificationEvent
+	s.env.OnActivity("SendNotificationActivity", mock.Anything, mock.Anything).Return(func(_ context.Context, p SendNotificationActivityParams) error {
+		notified = append(notified, p.Notification.Event)
+		return nil
+	}).Times(2)
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(SubmitDisputeEvidenceSignalName, SubmitDisputeEvidenceSignal{Evidence: DisputeEvidence{Text: "Usage logs attached.", AttachmentIDs: []string{"att-1"}}})
+	}, time.Hour)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(ResolveDisputeSignalName, ResolveDisputeSignal{Outcome: DisputeStatusWon})
+	}, 10*24*time.Hour)
+
+	s.env.ExecuteWorkflow(DisputeWorkflow, params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var dispute Dispute
+	require.NoError(s.T(), s.env.GetWorkflowResult(&dispute))
+	require.Equal(s.T(), DisputeStatusWon, dispute.Status)
+	require.Equal(s.T(), "Usage logs attached.", dispute.Evidence.Text)
+	require.NotNil(s.T(), dispute.ResolvedAt)
+	require.Equal(s.T(), []DisputeStatus{DisputeStatusNeedsEvidence, DisputeStatusEvidenceSubmitted, DisputeStatusWon}, saved)
+	require.Equal(s.T(), []NotificationEvent{NotificationDisputeOpened, NotificationDisputeResolved}, notified)
+}
+
+// Test_BillWorkflow_LineItemQuantity tests that the workflow prices an item given as a
+// quantity and unit price, ignoring the amount sent along.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_LineItemQuantity() {
+	params := BillWorkflowParams{BillID: uuid.NewString(), CustomerID: "cust-usage", Currency: "USD"}
+	s.env.RegisterWorkflow(BillWorkflow)
+	itemID := uuid.NewString()
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return p.LineItemID == itemID && p.Amount == 0.03 && p.Quantity == 0.1 && p.UnitPrice == 0.3 && p.Unit == "GB"
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillOnCloseActivityParams) bool {
+		return p.TotalAmount == 0.03
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: itemID, Description: "Storage", Amount: 99, Quantity: 0.1, UnitPrice: 0.3, Unit: "GB"})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 2*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var closed Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&closed))
+	require.Len(s.T(), closed.LineItems, 1)
+	item := closed.LineItems[0]
+	require.True(s.T(), item.Amount == 0.03)
+	require.True(s.T(), item.Quantity == 0.1 && item.UnitPrice == 0.3)
+	require.Equal(s.T(), "GB", item.Unit)
+}
+
+// Test_BillWorkflow_UsagePricing tests that the workflow rates usage of a metric with
+// the bill's volume price, lowering what earlier items billed once a cheaper tier is
+// reached, and refuses usage of a metric the bill does not price.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_UsagePricing() {
+	params := BillWorkflowParams{BillID: uuid.NewString(), CustomerID: "cust-usage", Currency: "USD", UsagePrices: []UsagePrice{
+		{Metric: "api_calls", Mode: TierModeVolume, Tiers: []PriceTier{{UpTo: 1000, UnitPrice: 0.01}, {UnitPrice: 0.008}}},
+	}}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	var saved []SaveLineItemActivityParams
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(func(_ context.Context, p SaveLineItemActivityParams) error {
+		saved = append(saved, p)
+		return nil
+	})
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillOnCloseActivityParams) bool {
+		return p.TotalAmount == 8.008
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "li-1", Description: "API calls", Quantity: 1000, Unit: "call", Metric: "api_calls"})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "li-2", Description: "API calls", Quantity: 1, Unit: "call", Metric: "api_calls"})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "li-3", Description: "Storage", Quantity: 5, Metric: "storage.gb"})
+	}, 3*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 4*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var closed Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&closed))
+	require.Len(s.T(), closed.LineItems, 2)
+	require.Equal(s.T(), LineItem{ID: "li-1", Description: "API calls", Amount: 10, Type: LineItemCharge, Quantity: 1000, Unit: "call", Metric: "api_calls"}, closed.LineItems[0])
+	require.Equal(s.T(), LineItem{ID: "li-2", Description: "API calls", Amount: -1.992, Type: LineItemAdjustment, ReasonCode: ReasonCodeUsageRepricing, Quantity: 1, Unit: "call", Metric: "api_calls"}, closed.LineItems[1])
+	require.Len(s.T(), saved, 2)
+	require.Equal(s.T(), "api_calls", saved[1].Metric)
+	require.Len(s.T(), closed.RejectedLineItems, 1)
+	require.Equal(s.T(), "li-3", closed.RejectedLineItems[0].ID)
+	require.Equal(s.T(), unpricedMetric("storage.gb"), closed.RejectedLineItems[0].Reason)
+}
+
+// Test_BillWorkflow_Commitment tests that a bill under a contract bills the commitment
+// when it starts, that usage draws it down, and that only overage is billed beyond it.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_Commitment() {
+	params := BillWorkflowParams{BillID: uuid.NewString(), CustomerID: "cust-contract", Currency: "USD", Contract: &Contract{CommittedAmount: 100}}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	saved := map[string]SaveLineItemActivityParams{}
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(func(_ context.Context, p SaveLineItemActivityParams) error {
+		saved[p.LineItemID] = p
+		return nil
+	})
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillOnCloseActivityParams) bool {
+		return p.TotalAmount == 125
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "usage-1", Description: "Usage", Amount: 60})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "usage-2", Description: "Usage", Amount: 55})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "plan", Description: "Support plan", Amount: 50, NotUsage: true})
+	}, 3*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "credit", Description: "Goodwill", Amount: -40, Type: LineItemCredit, ReasonCode: "goodwill"})
+	}, 4*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 5*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var closed Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&closed))
+	commitmentID := commitmentLineItemID(params.BillID)
+	require.Equal(s.T(), &Commitment{Amount: 100, LineItemID: commitmentID, DrawnDown: 100, Remaining: 0}, closed.Commitment)
+	require.Len(s.T(), closed.LineItems, 5)
+	require.Equal(s.T(), LineItem{ID: commitmentID, Description: defaultCommitmentDescription, Amount: 100, Type: LineItemCharge}, closed.LineItems[0])
+	require.Equal(s.T(), []float64{0, 60}, []float64{closed.LineItems[1].Amount, closed.LineItems[1].Drawdown})
+	require.Equal(s.T(), []float64{15, 40}, []float64{closed.LineItems[2].Amount, closed.LineItems[2].Drawdown})
+	require.Equal(s.T(), []float64{50, 0}, []float64{closed.LineItems[3].Amount, closed.LineItems[3].Drawdown})
+	require.Equal(s.T(), []float64{-40, 0}, []float64{closed.LineItems[4].Amount, closed.LineItems[4].Drawdown})
+	require.Equal(s.T(), 40.0, saved["usage-2"].Drawdown)
+}
+
+// Test_BillWorkflow_FreeTiers tests that a bill waives what it charges during its trial
+// and its free units of usage after it, recording each waiver as a zero-amount item.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_FreeTiers() {
+	params := BillWorkflowParams{
+		BillID:      uuid.NewString(),
+		Currency:    "USD",
+		UsagePrices: []UsagePrice{flatCalls},
+		FreeTiers:   []FreeTier{{Metric: "api_calls", Units: 1000}, {Days: 1}},
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	saved := map[string]SaveLineItemActivityParams{}
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(func(_ context.Context, p SaveLineItemActivityParams) error {
+		saved[p.LineItemID] = p
+		return nil
+	})
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillOnCloseActivityParams) bool {
+		return p.TotalAmount == 32
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "setup", Description: "Setup", Amount: 50})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "calls-1", Description: "API calls", Metric: "api_calls", Quantity: 600})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "calls-2", Description: "API calls", Metric: "api_calls", Quantity: 600})
+	}, 25*time.Hour)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "support", Description: "Support", Amount: 30})
+	}, 26*time.Hour)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 27*time.Hour)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var closed Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&closed))
+	require.NotNil(s.T(), closed.TrialStart)
+	amounts := make([][2]float64, 0, len(closed.LineItems))
+	for _, item := range closed.LineItems {
+		amounts = append(amounts, [2]float64{item.Amount, item.Waived})
+	}
+	require.Equal(s.T(), [][2]float64{{0, 0}, {0, 50}, {0, 0}, {0, 6}, {2, 0}, {0, 4}, {30, 0}}, amounts)
+	require.Equal(s.T(), LineItem{
+		ID:          waiverLineItemID("calls-2"),
+		Description: "Free tier: API calls",
+		Type:        LineItemCharge,
+		Reference:   &LineItemReference{LineItemID: "calls-2"},
+		Metric:      "api_calls",
+		Waived:      4,
+	}, closed.LineItems[5])
+	require.Equal(s.T(), "Free trial: Setup", closed.LineItems[1].Description)
+	require.Equal(s.T(), 50.0, saved[waiverLineItemID("setup")].Waived)
+}
+
+// Test_BillWorkflow_Coupons tests that coupons redeemed when the bill was created and
+// applied afterwards are taken off its total on close, each as a credit, in the order
+// they were applied, and that a coupon applied twice counts once.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_Coupons() {
+	params := BillWorkflowParams{
+		BillID:   uuid.NewString(),
+		Currency: "USD",
+		Coupons:  []AppliedCoupon{{Code: "WELCOME10", Kind: CouponKindPercentage, PercentOff: 10}},
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	saved := map[string]SaveLineItemActivityParams{}
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(func(_ context.Context, p SaveLineItemActivityParams) error {
+		saved[p.LineItemID] = p
+		return nil
+	})
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillOnCloseActivityParams) bool {
+		return p.TotalAmount == 85
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "seats", Description: "Seats", Amount: 80})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(ApplyCouponSignalName, ApplyCouponSignal{Coupon: AppliedCoupon{Code: "FLAT5", Kind: CouponKindFixed, AmountOff: 5}})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(ApplyCouponSignalName, ApplyCouponSignal{Coupon: AppliedCoupon{Code: "WELCOME10", Kind: CouponKindPercentage, PercentOff: 10}})
+	}, 3*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "support", Description: "Support", Amount: 20})
+	}, 4*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 5*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var closed Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&closed))
+	welcome, flat := couponLineItemID(params.BillID, "WELCOME10"), couponLineItemID(params.BillID, "FLAT5")
+	require.Equal(s.T(), []AppliedCoupon{
+		{Code: "WELCOME10", Kind: CouponKindPercentage, PercentOff: 10, Amount: 10, LineItemID: welcome},
+		{Code: "FLAT5", Kind: CouponKindFixed, AmountOff: 5, Amount: 5, LineItemID: flat},
+	}, closed.Coupons)
+	require.Len(s.T(), closed.LineItems, 4)
+	require.Equal(s.T(), LineItem{
+		ID:          flat,
+		Description: "Coupon FLAT5",
+		Amount:      -5,
+		Type:        LineItemCredit,
+		ReasonCode:  ReasonCodeCoupon,
+		Reference:   &LineItemReference{External: "FLAT5"},
+	}, closed.LineItems[3])
+	require.Equal(s.T(), -10.0, saved[welcome].Amount)
+	require.Equal(s.T(), ReasonCodeCoupon, saved[welcome].ReasonCode)
+}
+
+// Test_BillWorkflow_SubBillRollsUp tests that a sub-bill adds its total to its parent
+// when it closes, tagged with its ID.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_SubBillRollsUp() {
+	params := BillWorkflowParams{BillID: uuid.NewString(), CustomerID: "cust-dept", Currency: "USD", ParentBillID: "bill-org"}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.MatchedBy(func(p UpsertBillActivityParams) bool {
+		return p.ParentBillID == "bill-org"
+	})).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(nil).Twice()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	var rolledUp RollUpSubBillActivityParams
+	s.env.OnActivity(RollUpSubBillActivityName, mock.Anything, mock.Anything).Return(func(_ context.Context, p RollUpSubBillActivityParams) error {
+		rolledUp = p
+		return nil
+	}).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Seats", Amount: 40})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Usage", Amount: 2.5})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 3*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	require.Equal(s.T(), "bill-org", rolledUp.ParentBillID)
+	require.Equal(s.T(), params.BillID, rolledUp.Signal.SubBillID)
+	require.Equal(s.T(), subBillLineItemID(params.BillID), rolledUp.Signal.LineItemID)
+	require.True(s.T(), rolledUp.Signal.Amount == 42.5)
+}
+
+// Test_BillWorkflow_SubBillAfterParentClosed tests that a sub-bill's total reaching a
+// parent that already closed is parked on its follow-up bill, even when the parent
+// rejects late items.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_SubBillAfterParentClosed() {
+	parent := s.closedBill(10)
+	params := BillWorkflowParams{BillID: parent.ID, CustomerID: parent.CustomerID, Currency: parent.Currency, LateItemPolicy: LateItemPolicyReject, Resume: &parent}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("RouteLateLineItemActivity", mock.Anything, mock.MatchedBy(func(p RouteLateLineItemActivityParams) bool {
+		return p.Bill.ID == parent.ID && p.Signal.SubBillID == "bill-dept" && p.Policy == LateItemPolicyPark
+	})).Return(nextBillID(parent.ID), nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: subBillLineItemID("bill-dept"), Description: "Sub-bill bill-dept", Amount: 7, SubBillID: "bill-dept"})
+	}, 0)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+}
+
+// Test_BillWorkflow_PayerSplits tests that a split bill issues each payer a share of
+// its total on close, and is not auto-collected itself.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_PayerSplits() {
+	params := BillWorkflowParams{BillID: uuid.NewString(), CustomerID: "cust-split", Currency: "USD", AutoCollect: true}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	var saved SavePayerSharesActivityParams
+	s.env.OnActivity(SavePayerSharesActivityName, mock.Anything, mock.Anything).Return(func(_ context.Context, p SavePayerSharesActivityParams) error {
+		saved = p
+		return nil
+	}).Once()
+	s.env.OnActivity("SendNotificationActivity", mock.Anything, mock.MatchedBy(func(p SendNotificationActivityParams) bool {
+		return p.Notification.Event == NotificationPayerShareIssued
+	})).Return(nil).Twice()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Venue", Amount: 90})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(SetPayerSplitsSignalName, SetPayerSplitsSignal{Splits: []PayerSplit{{PayerID: "payer-a", Amount: 30}, {PayerID: "payer-b", Percent: 100}}})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 3*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	require.Len(s.T(), saved.Shares, 2)
+	require.Equal(s.T(), "payer-a", saved.Shares[0].PayerID)
+	require.True(s.T(), saved.Shares[0].Amount == 30)
+	require.Equal(s.T(), "payer-b", saved.Shares[1].PayerID)
+	require.True(s.T(), saved.Shares[1].Amount == 60)
+
+	var closed Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&closed))
+	require.Len(s.T(), closed.PayerShares, 2)
+	require.Empty(s.T(), closed.Payments)
+}
+
+// Test_BillWorkflow_PayerSplits_SaveFailed tests that a split bill whose shares could
+// not be saved is not collected from its own customer instead.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_PayerSplits_SaveFailed() {
+	params := BillWorkflowParams{BillID: uuid.NewString(), CustomerID: "cust-split", Currency: "USD", AutoCollect: true}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity(SavePayerSharesActivityName, mock.Anything, mock.Anything).Return(errors.New("database unavailable"))
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Venue", Amount: 90})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(SetPayerSplitsSignalName, SetPayerSplitsSignal{Splits: []PayerSplit{{PayerID: "payer-a", Percent: 50}, {PayerID: "payer-b", Percent: 50}}})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 3*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	var closed Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&closed))
+	require.Equal(s.T(), BillStatusClosed, closed.Status)
+	require.Empty(s.T(), closed.PayerShares)
+	require.Empty(s.T(), closed.Payments)
+	s.env.AssertNotCalled(s.T(), "ChargePaymentActivity", mock.Anything, mock.Anything)
+}
+
+// closedBill returns a closed bill with a single line item of total, for resuming.
+func (s *BillWorkflowTestSuite) closedBill(total float64) Bill {
+	closedAt := time.Now()
+	return Bill{
+		ID:          uuid.NewString(),
+		CustomerID:  "cust-record",
+		Currency:    "USD",
+		Status:      BillStatusClosed,
+		LineItems:   []LineItem{{ID: uuid.NewString(), Description: "Fee", Amount: total}},
+		TotalAmount: total,
+		CreatedAt:   &closedAt,
+		ClosedAt:    &closedAt,
+	}
+}
+
+// Test_CloseSweepWorkflow_Batches tests that the close sweep resumes after each full
+// batch and stops after a partial one, counting the bills it signaled.
+func (s *BillWorkflowTestSuite) Test_CloseSweepWorkflow_Batches() {
+	s.env.RegisterWorkflow(CloseSweepWorkflow)
+
+	s.env.OnActivity("SweepBillsActivity", mock.Anything, mock.MatchedBy(func(p SweepBillsActivityParams) bool {
+		return p.AfterID == "" && p.BillTTL == time.Hour && p.Limit == closeSweepBatchSize
+	})).Return(&SweepBillsActivityResult{Found: closeSweepBatchSize, Signaled: closeSweepBatchSize - 1, Failed: 1, LastID: "bill-500"}, nil).Once()
+	s.env.OnActivity("SweepBillsActivity", mock.Anything, mock.MatchedBy(func(p SweepBillsActivityParams) bool {
+		return p.AfterID == "bill-500"
+	})).Return(&SweepBillsActivityResult{Found: 3, Signaled: 3, LastID: "bill-503"}, nil).Once()
+
+	s.env.ExecuteWorkflow(CloseSweepWorkflow, CloseSweepParams{BillTTL: time.Hour})
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	var result CloseSweepResult
+	require.NoError(s.T(), s.env.GetWorkflowResult(&result))
+	require.Equal(s.T(), CloseSweepResult{Signaled: closeSweepBatchSize + 2, Failed: 1}, result)
+}
+
+// Test_ArchiveSweepWorkflow_Batches tests that the archive sweep pages past each full
+// batch, so bills that failed to archive are not found again, and counts the failures.
+func (s *BillWorkflowTestSuite) Test_ArchiveSweepWorkflow_Batches() {
+	s.env.RegisterWorkflow(ArchiveSweepWorkflow)
+
+	s.env.OnActivity(ArchiveBillsActivityName, mock.Anything, mock.MatchedBy(func(p ArchiveBillsActivityParams) bool {
+		return p.AfterID == "" && p.Limit == archiveBatchSize && !p.Before.After(s.env.Now().AddDate(0, 0, -90))
+	})).Return(&ArchiveBillsActivityResult{Found: archiveBatchSize, Archived: archiveBatchSize - 2, Failed: 2, LastID: "bill-100"}, nil).Once()
+	s.env.OnActivity(ArchiveBillsActivityName, mock.Anything, mock.MatchedBy(func(p ArchiveBillsActivityParams) bool {
+		return p.AfterID == "bill-100"
+	})).Return(&ArchiveBillsActivityResult{Found: 4, Archived: 4, LastID: "bill-104"}, nil).Once()
+
+	s.env.ExecuteWorkflow(ArchiveSweepWorkflow, ArchiveSweepParams{AfterDays: 90})
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	var result ArchiveSweepResult
+	require.NoError(s.T(), s.env.GetWorkflowResult(&result))
+	require.Equal(s.T(), ArchiveSweepResult{Archived: archiveBatchSize + 2, Failed: 2}, result)
+}
+
+// Test_AccrualSnapshotWorkflow_Batches tests that a scheduled accrual snapshot covers
+// the month before it runs, paging past each full batch.
+func (s *BillWorkflowTestSuite) Test_AccrualSnapshotWorkflow_Batches() {
+	s.env.RegisterWorkflow(AccrualSnapshotWorkflow)
+	s.env.SetStartTime(time.Date(2025, 1, 1, 0, 15, 0, 0, time.UTC))
+	periodEnd := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	s.env.OnActivity(SnapshotAccrualsActivityName, mock.Anything, mock.MatchedBy(func(p SnapshotAccrualsActivityParams) bool {
+		return p.Period == "2024-12" && p.PeriodEnd.Equal(periodEnd) && p.AfterID == "" && p.Limit == accrualSnapshotBatchSize
+	})).Return(&SnapshotAccrualsActivityResult{Found: accrualSnapshotBatchSize, LastID: "bill-500"}, nil).Once()
+	s.env.OnActivity(SnapshotAccrualsActivityName, mock.Anything, mock.MatchedBy(func(p SnapshotAccrualsActivityParams) bool {
+		return p.AfterID == "bill-500"
+	})).Return(&SnapshotAccrualsActivityResult{Found: 3, LastID: "bill-503"}, nil).Once()
+
+	s.env.ExecuteWorkflow(AccrualSnapshotWorkflow, AccrualSnapshotParams{})
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	var result AccrualSnapshotResult
+	require.NoError(s.T(), s.env.GetWorkflowResult(&result))
+	require.Equal(s.T(), AccrualSnapshotResult{Period: "2024-12", Bills: accrualSnapshotBatchSize + 3}, result)
+}
+
+// Test_TestBillPurgeWorkflow_Batches tests that a test bill purge deletes batches until
+// one comes back short, and totals what they deleted.
+func (s *BillWorkflowTestSuite) Test_TestBillPurgeWorkflow_Batches() {
+	s.env.RegisterWorkflow(TestBillPurgeWorkflow)
+	createdBefore := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	s.env.OnActivity(PurgeTestBillsActivityName, mock.Anything, PurgeTestBillsActivityParams{
+		TenantID: "acme", CreatedBefore: createdBefore, Limit: erasureBatchSize,
+	}).Return(&PurgeExpiredBillsActivityResult{Found: erasureBatchSize, LineItems: 250, Workflows: 200}, nil).Once()
+	s.env.OnActivity(PurgeTestBillsActivityName, mock.Anything, mock.Anything).
+		Return(&PurgeExpiredBillsActivityResult{Found: 4, LineItems: 9, Workflows: 8}, nil).Once()
+
+	s.env.ExecuteWorkflow(TestBillPurgeWorkflow, TestBillPurgeParams{TenantID: "acme", CreatedBefore: createdBefore})
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	var result TestBillPurgeResult
+	require.NoError(s.T(), s.env.GetWorkflowResult(&result))
+	require.Equal(s.T(), TestBillPurgeResult{Bills: erasureBatchSize + 4, LineItems: 259, Workflows: 208}, result)
+}
+
+// closeBatchJob returns the parameters of a close_batch job for filter.
+func closeBatchJob(filter CloseBatchFilter) JobWorkflowParams {
+	params, _ := json.Marshal(filter)
+	return JobWorkflowParams{JobID: "job-1", Kind: JobKindCloseBatch, TenantID: filter.TenantID, RequestedByKeyID: "key-finance", Params: params}
+}
+
+// Test_JobWorkflow_CloseBatch tests that a close batch job pages through the matching
+// bills, signals each to close, checkpoints its progress after every page, and
+// succeeds reporting the bills it could not signal.
+func (s *BillWorkflowTestSuite) Test_JobWorkflow_CloseBatch() {
+	s.env.RegisterWorkflow(JobWorkflow)
+	filter := CloseBatchFilter{CustomerIDs: []string{"cust-1", "cust-2"}, TenantID: "acme"}
+
+	fullPage := make([]string, closeBatchPageSize)
+	for i := range fullPage {
+		fullPage[i] = fmt.Sprintf("b%04d", i)
+	}
+	s.env.OnActivity("FindCloseBatchBillsActivity", mock.Anything, mock.MatchedBy(func(p FindCloseBatchBillsActivityParams) bool {
+		return p.AfterID == "" && p.Filter.TenantID == "acme" && len(p.Filter.CustomerIDs) == 2
+	})).Return(fullPage, nil).Once()
+	s.env.OnActivity("FindCloseBatchBillsActivity", mock.Anything, mock.MatchedBy(func(p FindCloseBatchBillsActivityParams) bool {
+		return p.AfterID == fullPage[closeBatchPageSize-1]
+	})).Return([]string{"c0001", "c0002"}, nil).Once()
+
+	s.env.OnSignalExternalWorkflow(mock.Anything, "bill-c0002", "", CloseBillSignalName, mock.Anything).
+		Return(errors.New("workflow not found")).Once()
+	s.env.OnSignalExternalWorkflow(mock.Anything, mock.Anything, "", CloseBillSignalName, CloseBillSignal{RequestedByKeyID: "key-finance"}).
+		Return(nil).Times(closeBatchPageSize + 1)
+
+	var updates []UpdateJobActivityParams
+	s.env.OnActivity("UpdateJobActivity", mock.Anything, mock.Anything).Return(func(_ context.Context, p UpdateJobActivityParams) error {
+		if p.Progress != nil {
+			progress := *p.Progress
+			p.Progress = &progress
+		}
+		updates = append(updates, p)
+		return nil
+	}).Times(4)
+
+	s.env.ExecuteWorkflow(JobWorkflow, closeBatchJob(filter))
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	require.Len(s.T(), updates, 4)
+	require.Equal(s.T(), JobStatusRunning, updates[0].Status)
+	require.Nil(s.T(), updates[0].Progress)
+	require.Equal(s.T(), JobProgress{Processed: closeBatchPageSize, Succeeded: closeBatchPageSize}, *updates[1].Progress)
+	final := updates[3]
+	require.Equal(s.T(), JobStatusSucceeded, final.Status)
+	require.Equal(s.T(), JobProgress{Processed: closeBatchPageSize + 2, Succeeded: closeBatchPageSize + 1, Failed: 1, FailedIDs: []string{"c0002"}}, *final.Progress)
+}
+
+// Test_JobWorkflow_Canceled tests that a canceled job stops and is saved as canceled.
+func (s *BillWorkflowTestSuite) Test_JobWorkflow_Canceled() {
+	s.env.RegisterWorkflow(JobWorkflow)
+
+	s.env.OnActivity("UpdateJobActivity", mock.Anything, mock.MatchedBy(func(p UpdateJobActivityParams) bool {
+		return p.Status == JobStatusRunning
+	})).Return(nil).Once()
+	s.env.OnActivity("FindCloseBatchBillsActivity", mock.Anything, mock.Anything).After(time.Hour).Return([]string{"b1"}, nil).Maybe()
+	s.env.OnActivity("UpdateJobActivity", mock.Anything, mock.MatchedBy(func(p UpdateJobActivityParams) bool {
+		return p.Status == JobStatusCanceled && p.Progress.Processed == 0
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(s.env.CancelWorkflow, time.Minute)
+	s.env.ExecuteWorkflow(JobWorkflow, closeBatchJob(CloseBatchFilter{CustomerIDs: []string{"cust-1"}}))
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.True(s.T(), temporal.IsCanceledError(s.env.GetWorkflowError()))
+}
+
+// Test_JobWorkflow_EncryptLineItems tests that an encryption job pages through line
+// items by ID and reports how many it read and rewrote.
+func (s *BillWorkflowTestSuite) Test_JobWorkflow_EncryptLineItems() {
+	s.env.RegisterWorkflow(JobWorkflow)
+
+	s.env.OnActivity(EncryptLineItemsActivityName, mock.Anything, EncryptLineItemsActivityParams{Limit: encryptLineItemsPageSize}).
+		Return(&EncryptLineItemsResult{LastID: "li-0499", Read: encryptLineItemsPageSize, Encrypted: 300}, nil).Once()
+	s.env.OnActivity(EncryptLineItemsActivityName, mock.Anything, EncryptLineItemsActivityParams{AfterID: "li-0499", Limit: encryptLineItemsPageSize}).
+		Return(&EncryptLineItemsResult{LastID: "li-0510", Read: 11, Encrypted: 11}, nil).Once()
+
+	var final UpdateJobActivityParams
+	s.env.OnActivity("UpdateJobActivity", mock.Anything, mock.Anything).Return(func(_ context.Context, p UpdateJobActivityParams) error {
+		final = p
+		return nil
+	}).Times(4)
+
+	s.env.ExecuteWorkflow(JobWorkflow, JobWorkflowParams{JobID: "job-1", Kind: JobKindEncryptLineItems, Params: json.RawMessage(`{}`)})
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	require.Equal(s.T(), JobStatusSucceeded, final.Status)
+	require.Equal(s.T(), JobProgress{Processed: encryptLineItemsPageSize + 11, Succeeded: 311}, *final.Progress)
+}
+
+// Test_JobWorkflow_RebuildBillSummaries tests that a rebuild job pages through bills
+// by ID until a page comes back short.
+func (s *BillWorkflowTestSuite) Test_JobWorkflow_RebuildBillSummaries() {
+	s.env.RegisterWorkflow(JobWorkflow)
+
+	s.env.OnActivity(RebuildBillSummariesActivityName, mock.Anything, RebuildBillSummariesActivityParams{Limit: rebuildBillSummariesPageSize}).
+		Return(&RebuildBillSummariesResult{LastID: "bill-0499", Read: rebuildBillSummariesPageSize}, nil).Once()
+	s.env.OnActivity(RebuildBillSummariesActivityName, mock.Anything, RebuildBillSummariesActivityParams{AfterID: "bill-0499", Limit: rebuildBillSummariesPageSize}).
+		Return(&RebuildBillSummariesResult{LastID: "bill-0506", Read: 7}, nil).Once()
+
+	var final UpdateJobActivityParams
+	s.env.OnActivity("UpdateJobActivity", mock.Anything, mock.Anything).Return(func(_ context.Context, p UpdateJobActivityParams) error {
+		final = p
+		return nil
+	}).Times(4)
+
+	s.env.ExecuteWorkflow(JobWorkflow, JobWorkflowParams{JobID: "job-1", Kind: JobKindRebuildBillSummaries, Params: json.RawMessage(`{}`)})
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	require.Equal(s.T(), JobStatusSucceeded, final.Status)
+	require.Equal(s.T(), JobProgress{Processed: rebuildBillSummariesPageSize + 7, Succeeded: rebuildBillSummariesPageSize + 7}, *final.Progress)
+}
+
+// Test_BillWorkflow_CloseRecordsRequester tests that the key requesting a close reaches the audited close activity.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_CloseRecordsRequester() {
+	params := BillWorkflowParams{BillID: uuid.NewString(), CustomerID: "cust-audit", Currency: "USD", CreatedByKeyID: "key-creator"}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.MatchedBy(func(p UpsertBillActivityParams) bool {
+		return p.CreatedByKeyID == "key-creator"
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillOnCloseActivityParams) bool {
+		return p.ClosedByKeyID == "key-closer"
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{RequestedByKeyID: "key-closer"})
+	}, time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+}
+
+// Test_BillWorkflow_MinimumCommitment tests that a bill below the customer's minimum is topped up on close.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_MinimumCommitment() {
+	params := BillWorkflowParams{
+		BillID:     uuid.NewString(),
+		CustomerID: "cust-min",
+		Currency:   "USD",
+		BillLimits: &BillLimits{MinimumTotal: 100},
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return p.Amount == 40
+	})).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return p.Amount == 60 && p.Description == "Minimum commitment"
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillOnCloseActivityParams) bool {
+		return p.TotalAmount == 100 && p.Adjustment != nil && p.Adjustment.Kind == AdjustmentMinimumCommitment
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Usage", Amount: 40})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 2*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.True(s.T(), finalBill.TotalAmount == 100)
+	require.Len(s.T(), finalBill.LineItems, 2)
+	require.NotNil(s.T(), finalBill.Adjustment)
+	require.Equal(s.T(), finalBill.LineItems[1].ID, finalBill.Adjustment.LineItemID)
+	require.True(s.T(), finalBill.Adjustment.TotalBefore == 40)
+}
+
+// Test_BillWorkflow_MaximumFlagged tests that a bill above a flag-only maximum keeps its total.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_MaximumFlagged() {
+	params := BillWorkflowParams{
+		BillID:     uuid.NewString(),
+		CustomerID: "cust-max",
+		Currency:   "USD",
+		BillLimits: &BillLimits{MaximumTotal: 50, OverMaximum: OverMaximumFlag},
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillOnCloseActivityParams) bool {
+		return p.TotalAmount == 80 && p.Adjustment != nil && p.Adjustment.Kind == AdjustmentMaximumExceeded
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Usage", Amount: 80})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 2*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.True(s.T(), finalBill.TotalAmount == 80)
+	require.Len(s.T(), finalBill.LineItems, 1)
+	require.Empty(s.T(), finalBill.Adjustment.LineItemID)
+}
+
+// Test_BillWorkflow_CloseGracePeriodAcceptsLateItems tests that the bill is CLOSING during the grace period and
+// that items arriving then are billed and flagged as late.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_CloseGracePeriodAcceptsLateItems() {
+	params := BillWorkflowParams{
+		BillID:           uuid.NewString(),
+		CustomerID:       "cust-grace",
+		Currency:         "USD",
+		CloseGracePeriod: 5 * time.Minute,
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return !p.Late
+	})).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return p.Late
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillStatusActivity", mock.Anything, UpdateBillStatusActivityParams{BillID: params.BillID, Status: BillStatusClosing, Version: 3}).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillOnCloseActivityParams) bool {
+		return p.TotalAmount == 15
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "On time", Amount: 10})
+	}, 1*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		qr, err := s.env.QueryWorkflow(GetBillDetailsQueryName)
+		require.NoError(s.T(), err)
+		var pending Bill
+		require.NoError(s.T(), qr.Get(&pending))
+		require.Equal(s.T(), BillStatusClosing, pending.Status)
+		require.NotNil(s.T(), pending.FinalizesAt)
+
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Late", Amount: 5})
+	}, 2*time.Minute)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusClosed, finalBill.Status)
+	require.Len(s.T(), finalBill.LineItems, 2)
+	require.False(s.T(), finalBill.LineItems[0].Late)
+	require.True(s.T(), finalBill.LineItems[1].Late)
+	require.True(s.T(), finalBill.TotalAmount == 15)
+	require.Equal(s.T(), *finalBill.FinalizesAt, *finalBill.ClosedAt)
+}
+
+// Test_BillWorkflow_CloseGracePeriodFromRequest tests that a close request's grace period overrides the bill's.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_CloseGracePeriodFromRequest() {
+	params := BillWorkflowParams{BillID: uuid.NewString(), CustomerID: "cust-grace", Currency: "USD"}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("UpdateBillStatusActivity", mock.Anything, UpdateBillStatusActivityParams{BillID: params.BillID, Status: BillStatusClosing, Version: 2}).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Once()
+
+	grace := time.Minute
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{GracePeriod: &grace})
+	}, time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusClosed, finalBill.Status)
+	require.Equal(s.T(), grace, finalBill.FinalizesAt.Sub(*finalBill.CloseRequestedAt))
+}
+
+// Test_BillWorkflow_VersionDropsOutdatedSignals tests that every change bumps the bill's version, and that
+// signals sent for an earlier version are dropped.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_VersionDropsOutdatedSignals() {
+	params := BillWorkflowParams{BillID: uuid.NewString(), CustomerID: "cust-version", Currency: "USD"}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.MatchedBy(func(p UpsertBillActivityParams) bool {
+		return p.Version == 1
+	})).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return p.Amount == 10 && p.BillVersion == 2
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillOnCloseActivityParams) bool {
+		return p.TotalAmount == 10 && p.Version == 3
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "li-current", Description: "Current", Amount: 10, IfVersion: 1})
+	}, 1*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		// Both were sent by a caller that read the bill before the first item was added.
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "li-outdated", Description: "Outdated", Amount: 5, IfVersion: 1})
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{IfVersion: 1})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		qr, err := s.env.QueryWorkflow(GetBillDetailsQueryName)
+		require.NoError(s.T(), err)
+		var bill Bill
+		require.NoError(s.T(), qr.Get(&bill))
+		require.Equal(s.T(), BillStatusOpen, bill.Status)
+		require.Equal(s.T(), int64(2), bill.Version)
+
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{IfVersion: bill.Version})
+	}, 3*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusClosed, finalBill.Status)
+	require.Len(s.T(), finalBill.LineItems, 1)
+	require.Equal(s.T(), "li-current", finalBill.LineItems[0].ID)
+	require.Equal(s.T(), int64(3), finalBill.Version)
+}
+
+// Test_BillWorkflow_ApprovalEscalatesThenApproves tests that a bill at the approval threshold waits for
+// approval, escalates when overdue, and closes once approved.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_ApprovalEscalatesThenApproves() {
+	params := BillWorkflowParams{
+		BillID:     uuid.NewString(),
+		CustomerID: "cust-approval",
+		Currency:   "USD",
+		Approval:   ApprovalPolicy{Threshold: 100, EscalateAfter: time.Hour},
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("UpdateBillStatusActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillStatusActivityParams) bool {
+		return p.Status == BillStatusPendingApproval && p.Approval != nil && p.Approval.Total == 150
+	})).Return(nil).Once()
+	s.env.OnActivity("SendNotificationActivity", mock.Anything, mock.MatchedBy(func(p SendNotificationActivityParams) bool {
+		return p.Notification.Event == NotificationBillApprovalRequested
+	})).Return(nil).Once()
+	s.env.OnActivity("SendNotificationActivity", mock.Anything, mock.MatchedBy(func(p SendNotificationActivityParams) bool {
+		return p.Notification.Event == NotificationBillApprovalEscalated
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillOnCloseActivityParams) bool {
+		return p.ClosedByKeyID == "key-approver" && p.Approval != nil && p.Approval.Decision == ApprovalApproved
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Usage", Amount: 150})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{RequestedByKeyID: "key-closer"})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(ApproveBillSignalName, ApproveBillSignal{RequestedByKeyID: "key-approver"})
+	}, 2*time.Hour)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusClosed, finalBill.Status)
+	require.NotNil(s.T(), finalBill.Approval)
+	require.NotNil(s.T(), finalBill.Approval.EscalatedAt)
+	require.Equal(s.T(), "key-approver", finalBill.Approval.DecidedByKeyID)
+}
+
+// Test_BillWorkflow_RejectReopensBill tests that a rejected bill returns to OPEN and can be closed again.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_RejectReopensBill() {
+	params := BillWorkflowParams{
+		BillID:     uuid.NewString(),
+		CustomerID: "cust-approval",
+		Currency:   "USD",
+		Approval:   ApprovalPolicy{Threshold: 100},
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SendNotificationActivity", mock.Anything, mock.Anything).Return(nil).Twice()
+	s.env.OnActivity("UpdateBillStatusActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillStatusActivityParams) bool {
+		return p.Status == BillStatusPendingApproval
+	})).Return(nil).Twice()
+	s.env.OnActivity("UpdateBillStatusActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillStatusActivityParams) bool {
+		return p.Status == BillStatusOpen && p.ActorKeyID == "key-approver" && p.Approval.Reason == "wrong rate"
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Usage", Amount: 150})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(RejectBillSignalName, RejectBillSignal{Reason: "wrong rate", RequestedByKeyID: "key-approver"})
+	}, 3*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		var bill Bill
+		encoded, err := s.env.QueryWorkflow(GetBillDetailsQueryName)
+		require.NoError(s.T(), err)
+		require.NoError(s.T(), encoded.Get(&bill))
+		require.Equal(s.T(), BillStatusOpen, bill.Status)
+		require.Equal(s.T(), ApprovalRejected, bill.Approval.Decision)
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 4*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(ApproveBillSignalName, ApproveBillSignal{})
+	}, 5*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusClosed, finalBill.Status)
+	require.Equal(s.T(), ApprovalApproved, finalBill.Approval.Decision)
+	require.Empty(s.T(), finalBill.Approval.Reason)
+}
+
+// Test_BillWorkflow_DunningRecoversAfterPause tests that dunning retries a failed payment on schedule,
+// honors pause/resume, and marks the bill paid once a retry succeeds.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_DunningRecoversAfterPause() {
+	params := BillWorkflowParams{
+		BillID:          uuid.NewString(),
+		CustomerID:      "cust-dunning",
+		Currency:        "USD",
+		AutoCollect:     true,
+		DunningSchedule: []time.Duration{24 * time.Hour, 72 * time.Hour},
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+	s.env.RegisterWorkflow(PaymentWorkflow)
+	s.env.RegisterWorkflow(DunningWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	declined := temporal.NewNonRetryableApplicationError("card expired", PaymentDeclinedErrorType, nil)
+	s.env.OnActivity("ChargePaymentActivity", mock.Anything, mock.Anything).Return(nil, declined).Twice()
+	s.env.OnActivity("ChargePaymentActivity", mock.Anything, mock.Anything).Return(&ChargeResult{Reference: "ch_recovered"}, nil).Once()
+	s.env.OnActivity("RecordPaymentActivity", mock.Anything, mock.Anything).Return(nil).Times(3)
+	s.env.OnActivity("UpdateBillStatusActivity", mock.Anything, UpdateBillStatusActivityParams{BillID: params.BillID, Status: BillStatusPaymentFailed, Version: 6}).Return(nil).Once()
+	s.env.OnActivity("UpdateBillStatusActivity", mock.Anything, UpdateBillStatusActivityParams{BillID: params.BillID, Status: BillStatusPaid, Version: 8}).Return(nil).Once()
+	s.env.OnActivity("SendNotificationActivity", mock.Anything, mock.MatchedBy(func(p SendNotificationActivityParams) bool {
+		return p.Notification.Event == NotificationPaymentRetryFailed
+	})).Return(nil).Once()
+	s.env.OnActivity("SendNotificationActivity", mock.Anything, mock.MatchedBy(func(p SendNotificationActivityParams) bool {
+		return p.Notification.Event == NotificationPaymentRecovered
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Usage", Amount: 25})
+	}, 1*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 2*time.Millisecond)
+	// Pause after the first retry failed; the second retry must wait for the resume.
+	s.env.RegisterDelayedCallback(func() {
+		require.NoError(s.T(), s.env.SignalWorkflowByID("dunning-"+params.BillID, PauseDunningSignalName, nil))
+	}, 48*time.Hour)
+	s.env.RegisterDelayedCallback(func() {
+		qr, err := s.env.QueryWorkflowByID("dunning-"+params.BillID, GetDunningStateQueryName)
+		require.NoError(s.T(), err)
+		var state DunningState
+		require.NoError(s.T(), qr.Get(&state))
+		require.Equal(s.T(), DunningStatusPaused, state.Status)
+		require.Equal(s.T(), 2, state.Step)
+		require.Len(s.T(), state.Attempts, 1)
+
+		require.NoError(s.T(), s.env.SignalWorkflowByID("dunning-"+params.BillID, ResumeDunningSignalName, nil))
+	}, 100*time.Hour)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusPaid, finalBill.Status)
+	require.Len(s.T(), finalBill.Payments, 3)
+	require.Equal(s.T(), PaymentStatusSucceeded, finalBill.Payments[2].Status)
+	require.Greater(s.T(), finalBill.Payments[2].CreatedAt.Sub(*finalBill.ClosedAt), 96*time.Hour, "second retry should run only after resume")
+}
+
+// Test_BillWorkflow_RoutesLateItemFromClosedBill tests that a line item signaled to an
+// already-closed bill is forwarded to the next bill instead of being dropped.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_RoutesLateItemFromClosedBill() {
+	closedAt := time.Now()
+	createdAt := closedAt.Add(-30 * 24 * time.Hour)
+	closed := &Bill{
+		ID:          uuid.NewString(),
+		CustomerID:  "cust-late",
+		Currency:    "USD",
+		Status:      BillStatusClosed,
+		LineItems:   []LineItem{{ID: uuid.NewString(), Description: "Usage", Amount: 10}},
+		TotalAmount: 10,
+		CreatedAt:   &createdAt,
+		ClosedAt:    &closedAt,
+	}
+	params := BillWorkflowParams{
+		BillID:         closed.ID,
+		CustomerID:     closed.CustomerID,
+		Currency:       closed.Currency,
+		RouteLateItems: true,
+		Resume:         closed,
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	lateItemID := uuid.NewString()
+	s.env.OnActivity("RouteLateLineItemActivity", mock.Anything, mock.MatchedBy(func(p RouteLateLineItemActivityParams) bool {
+		return p.Bill.ID == closed.ID && p.Signal.LineItemID == lateItemID
+	})).Return(nextBillID(closed.ID), nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: lateItemID, Description: "Late usage", Amount: 5})
+	}, 0)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), BillStatusClosed, finalBill.Status)
+	require.Len(s.T(), finalBill.LineItems, 1)
+	require.True(s.T(), finalBill.TotalAmount == 10)
+}
+
+// Test_BillWorkflow_DuplicateClientReference tests that a second item with a client
+// reference the bill already holds is ignored, even under a different line item ID.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_DuplicateClientReference() {
+	params := BillWorkflowParams{BillID: uuid.NewString(), CustomerID: "cust-ref", Currency: "USD"}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return p.LineItemID == "li-1" && p.ClientReference == "usage-42"
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "li-1", Description: "Usage", Amount: 10, ClientReference: "usage-42"})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "li-2", Description: "Usage retry", Amount: 10, ClientReference: "usage-42"})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 3*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Len(s.T(), finalBill.LineItems, 1)
+	require.Equal(s.T(), "li-1", finalBill.LineItems[0].ID)
+	require.Equal(s.T(), "usage-42", finalBill.LineItems[0].ClientReference)
+	require.True(s.T(), finalBill.TotalAmount == 10)
+}
+
+// Test_BillWorkflow_SeedsTemplateItems tests that a bill created from a template starts
+// with the template's line items.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_SeedsTemplateItems() {
+	params := BillWorkflowParams{
+		BillID:     uuid.NewString(),
+		CustomerID: "cust-template",
+		Currency:   "USD",
+		TemplateID: "tpl-card",
+		TemplateItems: []TemplateLineItem{
+			{Description: "Platform fee", Amount: 25},
+			{Description: "Card network fee", Amount: 4.5},
+		},
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.MatchedBy(func(p UpsertBillActivityParams) bool {
+		return p.TemplateID == "tpl-card"
+	})).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(nil).Times(2)
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), "tpl-card", finalBill.TemplateID)
+	require.Len(s.T(), finalBill.LineItems, 2)
+	require.Equal(s.T(), templateItemID(params.BillID, "tpl-card", 0), finalBill.LineItems[0].ID)
+	require.Equal(s.T(), "Card network fee", finalBill.LineItems[1].Description)
+	require.True(s.T(), finalBill.TotalAmount == 29.5)
+}
+
+// Test_BillWorkflow_RoundsToCurrency tests that a bill rounds its line items and total
+// to its currency's minor units with its rounding mode, and records the policy.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_RoundsToCurrency() {
+	params := BillWorkflowParams{BillID: uuid.NewString(), CustomerID: "cust-jpy", Currency: "JPY", RoundingMode: rounding.HalfEven}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.MatchedBy(func(p UpsertBillActivityParams) bool {
+		return p.Rounding != nil && *p.Rounding == rounding.Policy{Mode: rounding.HalfEven, Decimals: 0}
+	})).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return p.LineItemID == "li-1" && p.Amount == 100
+	})).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return p.LineItemID == "li-2" && p.Amount == 202
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillOnCloseActivityParams) bool {
+		return p.TotalAmount == 302
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "li-1", Description: "Usage", Amount: 100.5})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "li-2", Description: "Usage", Amount: 201.5})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 3*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), &rounding.Policy{Mode: rounding.HalfEven, Decimals: 0}, finalBill.Rounding)
+	require.True(s.T(), finalBill.TotalAmount == 302)
+}
+
+// Test_BillWorkflow_RoundsLimitAdjustment tests that the adjustment bringing a bill to
+// the customer's minimum total is rounded like the bill's other amounts.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_RoundsLimitAdjustment() {
+	params := BillWorkflowParams{
+		BillID:       uuid.NewString(),
+		CustomerID:   "cust-bhd",
+		Currency:     "BHD",
+		RoundingMode: rounding.Floor,
+		BillLimits:   &BillLimits{MinimumTotal: 10},
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return p.LineItemID == "li-1" && p.Amount == 1.234
+	})).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return p.LineItemID != "li-1" && p.Amount == 8.766
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillOnCloseActivityParams) bool {
+		return p.TotalAmount == 10
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "li-1", Description: "Usage", Amount: 1.23456})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 2*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.NotNil(s.T(), finalBill.Adjustment)
+	require.True(s.T(), finalBill.Adjustment.Amount == 8.766)
+	require.True(s.T(), finalBill.TotalAmount == 10)
+}
+
+// Test_BillWorkflow_AppliesCredit tests that a closing bill takes the customer's
+// available credit, deducts it with a negative line item, and records it.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_AppliesCredit() {
+	params := BillWorkflowParams{BillID: uuid.NewString(), CustomerID: "cust-credit", Currency: "USD", ApplyCredits: true}
+	creditItemID := creditLineItemID(params.BillID)
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return p.LineItemID == "li-1"
+	})).Return(nil).Once()
+	s.env.OnActivity("ApplyCreditActivity", mock.Anything, mock.MatchedBy(func(p ApplyCreditActivityParams) bool {
+		return p.EntryID == creditItemID && p.CustomerID == "cust-credit" && p.Currency == "USD" && p.Amount == 100 && p.Decimals == unroundedDecimals
+	})).Return(30.0, nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return p.LineItemID == creditItemID && p.Amount == -30
+	})).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillOnCloseActivityParams) bool {
+		return p.TotalAmount == 70 && p.AppliedCredit != nil && p.AppliedCredit.Amount == 30
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "li-1", Description: "Platform fee", Amount: 100})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 2*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), &AppliedCredit{Amount: 30, LineItemID: creditItemID}, finalBill.AppliedCredit)
+	require.Len(s.T(), finalBill.LineItems, 2)
+	require.Equal(s.T(), creditLineItemDescription, finalBill.LineItems[1].Description)
+	require.True(s.T(), finalBill.TotalAmount == 70)
+}
+
+// Test_BillWorkflow_ClosesWithoutCredit tests that a bill closes unchanged when the
+// customer has no credit.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_ClosesWithoutCredit() {
+	s.closeWithoutCredit(nil)
+}
+
+// Test_BillWorkflow_ClosesWhenCreditFails tests that a bill closes without credit when
+// the credit cannot be reserved.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_ClosesWhenCreditFails() {
+	s.closeWithoutCredit(temporal.NewNonRetryableApplicationError("database unavailable", "db", nil))
+}
+
+// closeWithoutCredit closes a bill whose ApplyCreditActivity returns no credit and
+// applyErr, and checks that the bill closed at its line item total.
+func (s *BillWorkflowTestSuite) closeWithoutCredit(applyErr error) {
+	params := BillWorkflowParams{BillID: uuid.NewString(), CustomerID: "cust-credit", Currency: "USD", ApplyCredits: true}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("ApplyCreditActivity", mock.Anything, mock.Anything).Return(0.0, applyErr).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.MatchedBy(func(p UpdateBillOnCloseActivityParams) bool {
+		return p.TotalAmount == 40 && p.AppliedCredit == nil
+	})).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: "li-1", Description: "Platform fee", Amount: 40})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 2*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Nil(s.T(), finalBill.AppliedCredit)
+	require.Len(s.T(), finalBill.LineItems, 1)
+	require.True(s.T(), finalBill.TotalAmount == 40)
+}
+
+// Test_SubscriptionWorkflow_ProratesPlanChange tests that a subscription charges its plan
+// on the period's bill, prorates a mid-period upgrade, and continues as new at period end.
+func (s *BillWorkflowTestSuite) Test_SubscriptionWorkflow_ProratesPlanChange() {
+	start := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	s.env.SetStartTime(start)
+	params := SubscriptionWorkflowParams{
+		SubscriptionID: "sub-1",
+		Plan:           SubscriptionPlan{Name: "Basic", Amount: 31, Currency: "USD", Interval: SubscriptionIntervalMonthly},
+		PeriodStart:    start,
+		Bill:           BillWorkflowParams{CustomerID: "cust-sub", Currency: "USD"},
+	}
+	billID := subscriptionBillID("sub-1", 0)
+	s.env.RegisterWorkflow(BillWorkflow)
+	s.env.RegisterWorkflow(SubscriptionWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.MatchedBy(func(p UpsertBillActivityParams) bool {
+		return p.BillID == billID && p.CustomerID == "cust-sub"
+	})).Return(nil).Once()
+	for _, amount := range []float64{31, -21, 42} {
+		amount := amount
+		s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+			return p.BillID == billID && p.Amount == amount
+		})).Return(nil).Once()
+	}
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(ChangeSubscriptionPlanSignalName, ChangeSubscriptionPlanSignal{
+			ChangeID: "change-1",
+			Plan:     SubscriptionPlan{Name: "Pro", Amount: 62, Currency: "USD", Interval: SubscriptionIntervalMonthly},
+		})
+	}, 10*24*time.Hour)
+	s.env.RegisterDelayedCallback(func() {
+		qr, err := s.env.QueryWorkflow(GetSubscriptionStateQueryName)
+		require.NoError(s.T(), err)
+		var state SubscriptionState
+		require.NoError(s.T(), qr.Get(&state))
+		require.Equal(s.T(), SubscriptionStatusActive, state.Status)
+		require.Equal(s.T(), "Pro", state.Plan.Name)
+		require.Equal(s.T(), billID, state.CurrentBillID)
+		require.Equal(s.T(), start.AddDate(0, 1, 0), state.CurrentPeriodEnd)
+	}, 11*24*time.Hour)
+
+	s.env.ExecuteWorkflow(SubscriptionWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	var continued *workflow.ContinueAsNewError
+	require.ErrorAs(s.T(), s.env.GetWorkflowError(), &continued)
+	require.Equal(s.T(), "SubscriptionWorkflow", continued.WorkflowType.Name)
+}
+
+// Test_SubscriptionWorkflow_CancelAtPeriodEnd tests that a canceled subscription closes
+// its current bill and ends instead of starting another period.
+func (s *BillWorkflowTestSuite) Test_SubscriptionWorkflow_CancelAtPeriodEnd() {
+	start := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	s.env.SetStartTime(start)
+	params := SubscriptionWorkflowParams{
+		SubscriptionID: "sub-2",
+		Plan:           SubscriptionPlan{Name: "Basic", Amount: 10, Currency: "USD", Interval: SubscriptionIntervalWeekly},
+		PeriodStart:    start,
+		Bill:           BillWorkflowParams{CustomerID: "cust-sub", Currency: "USD"},
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+	s.env.RegisterWorkflow(SubscriptionWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CancelSubscriptionSignalName, CancelSubscriptionSignal{})
+	}, 24*time.Hour)
+
+	s.env.ExecuteWorkflow(SubscriptionWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	var state SubscriptionState
+	require.NoError(s.T(), s.env.GetWorkflowResult(&state))
+	require.Equal(s.T(), SubscriptionStatusCanceled, state.Status)
+	require.True(s.T(), state.CancelAtPeriodEnd)
+	require.Equal(s.T(), start.AddDate(0, 0, 7), state.CurrentPeriodEnd)
+}
+
+// Test_SubscriptionWorkflow_CancelImmediately tests that an immediate cancellation credits
+// the unused part of the period and closes the bill right away.
+func (s *BillWorkflowTestSuite) Test_SubscriptionWorkflow_CancelImmediately() {
+	start := time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)
+	s.env.SetStartTime(start)
+	params := SubscriptionWorkflowParams{
+		SubscriptionID: "sub-3",
+		Plan:           SubscriptionPlan{Name: "Basic", Amount: 30, Currency: "USD", Interval: SubscriptionIntervalMonthly},
+		PeriodStart:    start,
+		Proration:      proration.BySecond,
+		Bill:           BillWorkflowParams{CustomerID: "cust-sub", Currency: "USD"},
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+	s.env.RegisterWorkflow(SubscriptionWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	for _, amount := range []float64{30, -12.5} {
+		amount := amount
+		s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+			return p.Amount == amount
+		})).Return(nil).Once()
+	}
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CancelSubscriptionSignalName, CancelSubscriptionSignal{Immediately: true})
+	}, 17*24*time.Hour+12*time.Hour)
+
+	s.env.ExecuteWorkflow(SubscriptionWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	var state SubscriptionState
+	require.NoError(s.T(), s.env.GetWorkflowResult(&state))
+	require.Equal(s.T(), SubscriptionStatusCanceled, state.Status)
+	require.Equal(s.T(), start.Add(17*24*time.Hour+12*time.Hour), state.CurrentPeriodEnd)
+}
+
+// Test_BillWorkflow_SpendingAlerts tests that the customer is notified once per spending
+// threshold the bill's total reaches, including after the alerts are changed.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_SpendingAlerts() {
+	params := BillWorkflowParams{
+		BillID:         uuid.NewString(),
+		CustomerID:     "cust-alerts",
+		Currency:       "USD",
+		SpendingAlerts: &SpendingAlerts{Budget: 100, Thresholds: []float64{50, 80}},
+	}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(nil).Twice()
+	s.env.OnActivity("SendNotificationActivity", mock.Anything, mock.MatchedBy(func(p SendNotificationActivityParams) bool {
+		return p.Notification.Event == NotificationSpendingThresholdCrossed && p.Notification.CustomerID == "cust-alerts"
+	})).Return(nil).Times(3)
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Usage", Amount: 60})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Usage", Amount: 30})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		// 50% stays crossed; 90% is reached at once.
+		s.env.SignalWorkflow(SetSpendingAlertsSignalName, SetSpendingAlertsSignal{Alerts: &SpendingAlerts{Budget: 100, Thresholds: []float64{50, 90, 120}}})
+	}, 3*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 4*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Len(s.T(), finalBill.CrossedThresholds, 2)
+	require.Equal(s.T(), 50.0, finalBill.CrossedThresholds[0].Threshold)
+	require.Equal(s.T(), 60.0, finalBill.CrossedThresholds[0].Total)
+	require.Equal(s.T(), 90.0, finalBill.CrossedThresholds[1].Threshold)
+	require.Equal(s.T(), 90.0, finalBill.CrossedThresholds[1].Total)
+}
+
+// Test_BillWorkflow_HardCapRejectsLineItem tests that a line item taking the bill above
+// its hard cap is rejected and recorded, while later items within the cap are accepted.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_HardCapRejectsLineItem() {
+	params := BillWorkflowParams{
+		BillID:     uuid.NewString(),
+		CustomerID: "cust-prepaid",
+		Currency:   "USD",
+		BillLimits: &BillLimits{HardCap: 100},
+	}
+	rejectedID := uuid.NewString()
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.MatchedBy(func(p SaveLineItemActivityParams) bool {
+		return p.LineItemID != rejectedID && !p.OverHardCap
+	})).Return(nil).Twice()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Usage", Amount: 60})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: rejectedID, Description: "Usage", Amount: 50})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Usage", Amount: 40})
+	}, 3*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 4*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), 100.0, finalBill.TotalAmount)
+	require.Len(s.T(), finalBill.LineItems, 2)
+	require.Len(s.T(), finalBill.RejectedLineItems, 1)
+	require.Equal(s.T(), rejectedID, finalBill.RejectedLineItems[0].ID)
+	require.Equal(s.T(), &HardCap{Amount: 100, Action: HardCapReject}, finalBill.HardCap)
+}
+
+// Test_BillWorkflow_CreditBelowZero tests that a credit taking the bill's total below
+// zero is rejected and recorded unless it allows a negative total, and that items are
+// saved with their types.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_CreditBelowZero() {
+	params := BillWorkflowParams{BillID: uuid.NewString(), CustomerID: "cust-credit", Currency: "USD"}
+	rejectedID := uuid.NewString()
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	var saved []SaveLineItemActivityParams
+	s.env.OnActivity("SaveLineItemActivity", mock.Anything, mock.Anything).Return(func(_ context.Context, p SaveLineItemActivityParams) error {
+		saved = append(saved, p)
+		return nil
+	}).Times(3)
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Usage", Amount: 30})
+	}, time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: rejectedID, Description: "Goodwill", Amount: -40, Type: LineItemCredit, ReasonCode: "goodwill"})
+	}, 2*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Goodwill", Amount: -20, Type: LineItemCredit, ReasonCode: "goodwill"})
+	}, 3*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(AddLineItemSignalName, AddLineItemSignal{LineItemID: uuid.NewString(), Description: "Overbilled", Amount: -15, Type: LineItemAdjustment, ReasonCode: "overbilled", AllowNegativeTotal: true})
+	}, 4*time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, 5*time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+
+	var finalBill Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&finalBill))
+	require.Equal(s.T(), -5.0, finalBill.TotalAmount)
+	require.Len(s.T(), finalBill.RejectedLineItems, 1)
+	require.Equal(s.T(), rejectedID, finalBill.RejectedLineItems[0].ID)
+	require.Equal(s.T(), &LineItemTotals{Charges: 30, Credits: -20, Adjustments: -15}, finalBill.lineItemTotals())
+	require.Len(s.T(), saved, 3)
+	require.Equal(s.T(), LineItemCharge, saved[0].Type)
+	require.Equal(s.T(), LineItemCredit, saved[1].Type)
+	require.Equal(s.T(), "goodwill", saved[1].ReasonCode)
+	require.Equal(s.T(), LineItemAdjustment, saved[2].Type)
+}
+
+// Test_BillWorkflow_EmailsOnClose tests that a closed bill is emailed to its customer,
+// and that the bill stays closed when the email cannot be sent.
+func (s *BillWorkflowTestSuite) Test_BillWorkflow_EmailsOnClose() {
+	params := BillWorkflowParams{BillID: uuid.NewString(), CustomerID: "cust-email", Currency: "USD", EmailOnClose: true}
+	s.env.RegisterWorkflow(BillWorkflow)
+
+	s.env.OnActivity("UpsertBillActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	s.env.OnActivity("UpdateBillOnCloseActivity", mock.Anything, mock.Anything).Return(nil).Once()
+	var delivered DeliverBillActivityParams
+	s.env.OnActivity(DeliverBillActivityName, mock.Anything, mock.Anything).Return(func(_ context.Context, p DeliverBillActivityParams) error {
+		delivered = p
+		return temporal.NewNonRetryableApplicationError("mailbox unavailable", EmailRejectedErrorType, nil)
+	}).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(CloseBillSignalName, CloseBillSignal{})
+	}, time.Millisecond)
+
+	s.env.ExecuteWorkflow(BillWorkflow, &params)
+
+	require.True(s.T(), s.env.IsWorkflowCompleted())
+	require.NoError(s.T(), s.env.GetWorkflowError())
+	require.Equal(s.T(), params.BillID, delivered.Bill.ID)
+	require.Equal(s.T(), BillStatusClosed, delivered.Bill.Status)
+
+	var closed Bill
+	require.NoError(s.T(), s.env.GetWorkflowResult(&closed))
+	require.Equal(s.T(), BillStatusClosed, closed.Status)
 }